@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"toyou-proxy/dump"
+)
+
+// registerDumpHandlers 注册调试转储的管理接口
+//
+//	GET /dumps?route=api.example.com/v1      最近的转储记录，最新的在前，可按规则筛选
+//	GET /dumps/{id}                          单个转储记录
+//	GET /dumps/har?route=api.example.com/v1  下载转储记录的HAR文件，可导入浏览器开发者工具
+func (s *Server) registerDumpHandlers() {
+	s.Handle("/dumps", s.handleDumps)
+	s.Handle("/dumps/har", s.handleDumpsHAR)
+	s.Handle("/dumps/", s.handleDump)
+}
+
+// handleDumps 返回最近的转储记录
+func (s *Server) handleDumps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dump.GetDefaultRecorder().Recent(r.URL.Query().Get("route")))
+}
+
+// handleDumpsHAR 以HAR文件下载最近的转储记录
+func (s *Server) handleDumpsHAR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	route := r.URL.Query().Get("route")
+	filename := "toyou-proxy.har"
+	if route != "" {
+		filename = "toyou-proxy-" + harFilename.Replace(route) + ".har"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	writeJSON(w, http.StatusOK, dump.NewHAR(dump.GetDefaultRecorder().Recent(route)))
+}
+
+// harFilename 把规则中不能用于文件名的字符替换为下划线
+var harFilename = strings.NewReplacer("/", "_", "*", "_", " ", "_", "\"", "_", "\\", "_", ":", "_", "?", "_")
+
+// handleDump 返回单个转储记录
+func (s *Server) handleDump(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/dumps/"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid dump id")
+		return
+	}
+	d, exists := dump.GetDefaultRecorder().Get(id)
+	if !exists {
+		writeError(w, http.StatusNotFound, "dump not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"toyou-proxy/middleware"
+)
+
+// dynamicRouteRequest 推送域名映射的请求体
+type dynamicRouteRequest struct {
+	Service    string `json:"service"`
+	TTLSeconds int    `json:"ttl_seconds"` // 可选，为0时永不过期
+}
+
+// invalidateRequest 缓存失效请求体
+type invalidateRequest struct {
+	Hosts []string `json:"hosts"` // 为空时全部失效
+}
+
+// registerDynamicRouteHandlers 注册动态路由推送接口
+//
+//	GET    /dynamic-routes              列出推送的映射
+//	PUT    /dynamic-routes/{host}       设置域名映射
+//	DELETE /dynamic-routes/{host}       删除域名映射
+//	POST   /dynamic-routes/invalidate   使dynamic_route插件缓存失效
+func (s *Server) registerDynamicRouteHandlers() {
+	s.Handle("/dynamic-routes", s.handleDynamicRoutes)
+	s.Handle("/dynamic-routes/", s.handleDynamicRoute)
+}
+
+// handleDynamicRoutes 列出推送的映射
+func (s *Server) handleDynamicRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, middleware.GetDynamicRouteStore().List())
+}
+
+// handleDynamicRoute 处理单个域名映射
+func (s *Server) handleDynamicRoute(w http.ResponseWriter, r *http.Request) {
+	host := strings.TrimPrefix(r.URL.Path, "/dynamic-routes/")
+	if host == "" {
+		writeError(w, http.StatusBadRequest, "host is required")
+		return
+	}
+
+	store := middleware.GetDynamicRouteStore()
+
+	if host == "invalidate" {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var req invalidateRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+				return
+			}
+		}
+
+		if len(req.Hosts) == 0 {
+			store.Invalidate("")
+		}
+		for _, h := range req.Hosts {
+			store.Invalidate(h)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"invalidated": req.Hosts})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		var req dynamicRouteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if req.Service == "" {
+			writeError(w, http.StatusBadRequest, "service is required")
+			return
+		}
+
+		store.Set(host, req.Service, time.Duration(req.TTLSeconds)*time.Second)
+		writeJSON(w, http.StatusOK, map[string]string{"host": host, "service": req.Service})
+	case http.MethodDelete:
+		if !store.Delete(host) {
+			writeError(w, http.StatusNotFound, "mapping not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, http.MethodPut, http.MethodPost, http.MethodDelete)
+	}
+}
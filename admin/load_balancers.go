@@ -0,0 +1,266 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"toyou-proxy/loadbalancer"
+)
+
+// maxDrainWait 摘除后端时等待已转发请求完成的时间上限
+const maxDrainWait = 10 * time.Minute
+
+// drainRequest 摘除或恢复后端的请求体
+type drainRequest struct {
+	Backend     string `json:"backend"`      // 后端URL，也可以只写scheme://host
+	WaitSeconds int    `json:"wait_seconds"` // 摘除时可选，等待该后端已转发的请求完成后再返回
+}
+
+// backendRequest 增加、删除后端或修改权重的请求体
+type backendRequest struct {
+	Backend string `json:"backend"` // 后端URL，删除和修改权重时也可以只写scheme://host
+	Weight  int    `json:"weight"`  // 增加时可选，默认为1
+}
+
+// backendStatus 后端服务器状态
+type backendStatus struct {
+	URL            string  `json:"url"`
+	Weight         int     `json:"weight"`
+	Active         bool    `json:"active"`
+	Draining       bool    `json:"draining"`
+	Ejected        bool    `json:"ejected"`     // 被动健康检查摘除
+	Connections    int     `json:"connections"` // 已转发尚未完成的请求数
+	ResponseTimeMs float64 `json:"response_time_ms"`
+	LoadHint       int     `json:"load_hint"`
+}
+
+// registerLoadBalancerHandlers 注册负载均衡接口，部署工具在部署前摘除后端、部署后恢复
+//
+//	GET  /load-balancers                     列出所有负载均衡服务的后端状态
+//	GET  /load-balancers/{service}           单个服务的后端状态
+//	POST /load-balancers/{service}/drain     摘除后端，不再转发新请求
+//	POST /load-balancers/{service}/undrain   恢复后端，启用健康检查时先通过一次检查
+//	POST /load-balancers/{service}/backends  增加后端，扩容时不需要重启
+//	DELETE /load-balancers/{service}/backends 删除后端，已转发的请求正常完成
+//	POST /load-balancers/{service}/weight    修改后端的权重
+func (s *Server) registerLoadBalancerHandlers() {
+	s.Handle("/load-balancers", s.handleLoadBalancers)
+	s.Handle("/load-balancers/", s.handleLoadBalancer)
+}
+
+// handleLoadBalancers 列出所有负载均衡服务的后端状态
+func (s *Server) handleLoadBalancers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	manager := loadbalancer.GetDefaultManager()
+	result := make(map[string][]backendStatus)
+	for _, name := range manager.ListLoadBalancers() {
+		if lb, err := manager.GetLoadBalancer(name); err == nil {
+			result[name] = backendStatuses(lb)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleLoadBalancer 处理单个服务的负载均衡器
+func (s *Server) handleLoadBalancer(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/load-balancers/")
+	name, action, _ := strings.Cut(path, "/")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "service name is required")
+		return
+	}
+
+	lb, err := loadbalancer.GetDefaultManager().GetLoadBalancer(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "load balancer not found")
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		writeJSON(w, http.StatusOK, backendStatuses(lb))
+	case "drain", "undrain":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s.handleDrain(w, r, name, lb, action == "drain")
+	case "backends":
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			methodNotAllowed(w, http.MethodPost, http.MethodDelete)
+			return
+		}
+		s.handleBackends(w, r, name, lb)
+	case "weight":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s.handleWeight(w, r, name, lb)
+	default:
+		writeError(w, http.StatusNotFound, "unknown action: "+action)
+	}
+}
+
+// handleDrain 摘除或恢复后端，返回该后端的状态
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request, name string, lb loadbalancer.LoadBalancer, draining bool) {
+	var req drainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Backend == "" {
+		writeError(w, http.StatusBadRequest, "backend is required")
+		return
+	}
+	backendURL := strings.TrimSuffix(req.Backend, "/")
+	if err := lb.SetDraining(backendURL, draining); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.store.setDrained(name, backendURL, draining)
+
+	// 等待已转发的请求完成，部署工具可以在返回后直接停止后端
+	if draining && req.WaitSeconds > 0 {
+		wait := time.Duration(req.WaitSeconds) * time.Second
+		if wait > maxDrainWait {
+			wait = maxDrainWait
+		}
+		deadline := time.Now().Add(wait)
+		for time.Now().Before(deadline) {
+			if status, ok := findBackendStatus(lb, backendURL); !ok || status.Connections == 0 {
+				break
+			}
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	status, _ := findBackendStatus(lb, backendURL)
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleBackends 增加或删除后端。增加时返回新后端的状态，删除时返回剩余后端的状态
+func (s *Server) handleBackends(w http.ResponseWriter, r *http.Request, name string, lb loadbalancer.LoadBalancer) {
+	req, ok := decodeBackendRequest(w, r)
+	if !ok {
+		return
+	}
+	manager := loadbalancer.GetDefaultManager()
+
+	if r.Method == http.MethodDelete {
+		if err := manager.RemoveBackend(name, req.Backend); err != nil {
+			writeError(w, backendErrorStatus(err), err.Error())
+			return
+		}
+		// 删除的后端不再保留摘除记录
+		s.store.setDrained(name, req.Backend, false)
+		writeJSON(w, http.StatusOK, backendStatuses(lb))
+		return
+	}
+
+	parsed, err := url.Parse(req.Backend)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		writeError(w, http.StatusBadRequest, "backend must be an absolute http or https URL")
+		return
+	}
+	if req.Weight < 0 {
+		writeError(w, http.StatusBadRequest, "weight must not be negative")
+		return
+	}
+	if err := manager.AddBackend(name, req.Backend, req.Weight); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	s.store.save()
+
+	status, _ := findBackendStatus(lb, req.Backend)
+	writeJSON(w, http.StatusCreated, status)
+}
+
+// handleWeight 修改后端的权重，返回该后端的状态
+func (s *Server) handleWeight(w http.ResponseWriter, r *http.Request, name string, lb loadbalancer.LoadBalancer) {
+	req, ok := decodeBackendRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Weight <= 0 {
+		writeError(w, http.StatusBadRequest, "weight must be greater than 0")
+		return
+	}
+	if err := loadbalancer.GetDefaultManager().SetBackendWeight(name, req.Backend, req.Weight); err != nil {
+		writeError(w, backendErrorStatus(err), err.Error())
+		return
+	}
+	s.store.save()
+
+	status, _ := findBackendStatus(lb, req.Backend)
+	writeJSON(w, http.StatusOK, status)
+}
+
+// decodeBackendRequest 读取后端操作的请求体，出错时写入错误响应并返回false
+func decodeBackendRequest(w http.ResponseWriter, r *http.Request) (backendRequest, bool) {
+	var req backendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return req, false
+	}
+	if req.Backend == "" {
+		writeError(w, http.StatusBadRequest, "backend is required")
+		return req, false
+	}
+	req.Backend = strings.TrimSuffix(req.Backend, "/")
+	return req, true
+}
+
+// backendErrorStatus 后端不存在时返回404，其他错误（如删除最后一个后端）返回409
+func backendErrorStatus(err error) int {
+	if errors.Is(err, loadbalancer.ErrBackendNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusConflict
+}
+
+// backendStatuses 返回负载均衡器所有后端的状态
+func backendStatuses(lb loadbalancer.LoadBalancer) []backendStatus {
+	backends := lb.GetBackends()
+	result := make([]backendStatus, len(backends))
+	for i, backend := range backends {
+		result[i] = backendStatus{
+			URL:            backend.URL,
+			Weight:         backend.Weight,
+			Active:         backend.Active,
+			Draining:       backend.Draining,
+			Ejected:        time.Now().Before(backend.EjectedUntil),
+			Connections:    backend.Connections,
+			ResponseTimeMs: float64(backend.ResponseTime) / float64(time.Millisecond),
+			LoadHint:       backend.LoadHint,
+		}
+	}
+	return result
+}
+
+// findBackendStatus 按URL或scheme://host查找后端的状态
+func findBackendStatus(lb loadbalancer.LoadBalancer, backendURL string) (backendStatus, bool) {
+	for _, status := range backendStatuses(lb) {
+		if status.URL == backendURL || strings.HasPrefix(status.URL, backendURL+"/") {
+			return status, true
+		}
+	}
+	return backendStatus{}, false
+}
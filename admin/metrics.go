@@ -0,0 +1,25 @@
+package admin
+
+import (
+	"net/http"
+
+	"toyou-proxy/metrics"
+)
+
+// registerMetricsHandlers 注册指标接口
+//
+//	GET /metrics   以Prometheus文本格式输出指标
+func (s *Server) registerMetricsHandlers() {
+	s.Handle("/metrics", s.handleMetrics)
+}
+
+// handleMetrics 输出所有指标
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.GetDefaultRegistry().WritePrometheus(w)
+}
@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"toyou-proxy/middleware"
+)
+
+// middlewareToggleRequest 修改中间件启用状态的请求体
+type middlewareToggleRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// registerMiddlewareHandlers 注册中间件管理接口
+//
+//	GET    /middlewares            列出中间件及其启用状态
+//	GET    /middlewares/{name}     查看单个中间件的启用状态
+//	PUT    /middlewares/{name}     在运行时启用或禁用中间件，下一个请求生效
+//	DELETE /middlewares/{name}     清除运行时覆盖，恢复配置文件中的状态
+//	GET    /middlewares/health     中间件共享状态的健康检查结果，存在不健康的组件时返回503
+//	GET    /middlewares/traces     最近追踪的中间件链及各中间件耗时，最新的在前
+//	GET    /middlewares/profile    在采样窗口内按中间件统计CPU时间，需要开启admin.profiling
+func (s *Server) registerMiddlewareHandlers() {
+	s.Handle("/middlewares", s.handleMiddlewares)
+	s.Handle("/middlewares/", s.handleMiddleware)
+}
+
+// handleMiddlewares 列出中间件及其启用状态
+func (s *Server) handleMiddlewares(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, middleware.GetMiddlewareToggles().List())
+}
+
+// handleMiddleware 处理单个中间件
+func (s *Server) handleMiddleware(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/middlewares/")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "middleware name is required")
+		return
+	}
+	if name == "health" {
+		s.handleMiddlewareHealth(w, r)
+		return
+	}
+	if name == "traces" {
+		s.handleMiddlewareTraces(w, r)
+		return
+	}
+	if name == "profile" {
+		s.handleMiddlewareProfile(w, r)
+		return
+	}
+
+	toggles := middleware.GetMiddlewareToggles()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var req middlewareToggleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if req.Enabled == nil {
+			writeError(w, http.StatusBadRequest, "enabled is required")
+			return
+		}
+		if !toggles.Set(name, *req.Enabled) {
+			writeError(w, http.StatusNotFound, "middleware not configured")
+			return
+		}
+		log.Printf("Admin API: middleware '%s' enabled=%v", name, *req.Enabled)
+	case http.MethodDelete:
+		if toggles.Reset(name) {
+			log.Printf("Admin API: middleware '%s' override cleared", name)
+		}
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete)
+		return
+	}
+
+	status, exists := toggles.Get(name)
+	if !exists {
+		writeError(w, http.StatusNotFound, "middleware not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleMiddlewareHealth 输出中间件健康检查结果
+func (s *Server) handleMiddlewareHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	results := middleware.GetLifecycleManager().HealthCheck()
+	status := http.StatusOK
+	for _, result := range results {
+		if !result.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	if results == nil {
+		results = []middleware.ComponentHealth{}
+	}
+	writeJSON(w, status, results)
+}
+
+// handleMiddlewareTraces 返回最近追踪的中间件链
+func (s *Server) handleMiddlewareTraces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, middleware.GetChainTracer().Recent())
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"toyou-proxy/profiler"
+)
+
+// 中间件CPU采样时长
+const (
+	defaultProfileSeconds = 10
+	maxProfileSeconds     = 300
+)
+
+// handleMiddlewareProfile 在采样窗口内对进程进行CPU采样，按中间件汇总后返回，请求在采样结束后才返回
+//
+//	?seconds=10      采样时长，默认10秒，最长300秒
+//	?format=json     按中间件和规则汇总的CPU时间（默认）
+//	?format=folded   中间件执行期间采样的折叠栈，用于生成火焰图，all=true时包括中间件之外的采样
+//	?format=pprof    原始pprof文件，可用 go tool pprof -tagfocus=middleware=auth 分析
+func (s *Server) handleMiddlewareProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if !s.config.Profiling {
+		writeError(w, http.StatusForbidden, "profiling is disabled, set admin.profiling to enable it")
+		return
+	}
+
+	query := r.URL.Query()
+	seconds := defaultProfileSeconds
+	if value := query.Get("seconds"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxProfileSeconds {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("seconds must be between 1 and %d", maxProfileSeconds))
+			return
+		}
+		seconds = parsed
+	}
+	format := query.Get("format")
+	switch format {
+	case "", "json", "folded", "pprof":
+	default:
+		writeError(w, http.StatusBadRequest, "format must be 'json', 'folded' or 'pprof'")
+		return
+	}
+
+	profile, err := profiler.Capture(r.Context(), time.Duration(seconds)*time.Second)
+	if err == profiler.ErrBusy {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	switch format {
+	case "folded":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		profile.WriteFolded(w, query.Get("all") == "true")
+	case "pprof":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="toyou-proxy-middlewares.pprof"`)
+		w.Write(profile.Raw)
+	default:
+		writeJSON(w, http.StatusOK, profile.Summary())
+	}
+}
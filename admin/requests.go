@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"toyou-proxy/inflight"
+)
+
+// registerRequestHandlers 注册正在处理的请求的管理接口，关闭期间可以查看阻塞排空的请求并选择性地强制关闭
+//
+//	GET    /requests                          列出正在处理的请求，最早开始的在前
+//	DELETE /requests?kind=sse&older_than=60s  强制关闭满足条件的请求，至少需要一个条件
+//	DELETE /requests/{id}                     强制关闭单个请求
+func (s *Server) registerRequestHandlers() {
+	s.Handle("/requests", s.handleRequests)
+	s.Handle("/requests/", s.handleRequest)
+}
+
+// handleRequests 列出或批量关闭正在处理的请求，kind和older_than同时用于筛选列表
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	match, err := requestFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tracker := inflight.GetDefaultTracker()
+	switch r.Method {
+	case http.MethodGet:
+		list := make([]inflight.Request, 0)
+		for _, req := range tracker.List() {
+			if match == nil || match(req) {
+				list = append(list, req)
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodDelete:
+		if match == nil {
+			writeError(w, http.StatusBadRequest, "kind or older_than is required to close requests")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"closed": tracker.CloseMatching(match)})
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// handleRequest 强制关闭单个请求
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/requests/"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request id")
+		return
+	}
+	if !inflight.GetDefaultTracker().Close(id) {
+		writeError(w, http.StatusNotFound, "request not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestFilter 根据查询参数kind和older_than创建筛选条件，都未指定时返回nil
+func requestFilter(r *http.Request) (func(inflight.Request) bool, error) {
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	switch kind {
+	case "", inflight.KindHTTP, inflight.KindSSE, inflight.KindWebSocket:
+	default:
+		return nil, fmt.Errorf("invalid kind '%s', expected http, sse or websocket", kind)
+	}
+
+	var olderThan time.Duration
+	if value := query.Get("older_than"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid older_than '%s'", value)
+		}
+		olderThan = parsed
+	}
+
+	if kind == "" && olderThan == 0 {
+		return nil, nil
+	}
+	return func(req inflight.Request) bool {
+		if kind != "" && req.Kind != kind {
+			return false
+		}
+		return req.AgeSeconds >= olderThan.Seconds()
+	}, nil
+}
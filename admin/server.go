@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"toyou-proxy/config"
+)
+
+// DefaultListen 管理API默认监听地址
+const DefaultListen = "127.0.0.1:9090"
+
+// Server 管理API服务器
+type Server struct {
+	config config.AdminConfig
+	mux    *http.ServeMux
+	server *http.Server
+	state  ServerState // 代理服务器的运行状态
+	store  *stateStore // 运行时修改的状态文件，未配置时为nil
+}
+
+// NewServer 创建管理API服务器，配置了state_file时恢复保存的运行时修改（需要在负载均衡器创建之后调用）
+func NewServer(cfg config.AdminConfig) *Server {
+	if cfg.Listen == "" {
+		cfg.Listen = DefaultListen
+	}
+
+	s := &Server{
+		config: cfg,
+		mux:    http.NewServeMux(),
+	}
+
+	// 注册内置接口
+	s.registerDynamicRouteHandlers()
+	s.registerServiceHandlers()
+	s.registerMetricsHandlers()
+	s.registerMiddlewareHandlers()
+	s.registerRequestHandlers()
+	s.registerDumpHandlers()
+	s.registerStartupHandlers()
+	s.registerLoadBalancerHandlers()
+
+	// 恢复上次运行时通过管理API做的修改
+	if cfg.StateFile != "" {
+		s.store = newStateStore(cfg.StateFile)
+		if err := s.store.restore(); err != nil {
+			log.Printf("Failed to restore runtime state: %v", err)
+		}
+	}
+
+	return s
+}
+
+// Handle 注册管理接口，pattern语义与http.ServeMux一致
+func (s *Server) Handle(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Start 绑定监听地址并在后台启动管理API服务器
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.config.Listen, err)
+	}
+
+	s.server = &http.Server{
+		Addr:    s.config.Listen,
+		Handler: s,
+	}
+
+	go func() {
+		log.Printf("Starting admin API on %s", s.config.Listen)
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin API failed: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop 停止管理API服务器
+func (s *Server) Stop() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+// ServeHTTP 校验令牌后分发请求
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.config.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// writeJSON 写入JSON响应
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Admin API: failed to encode response: %v", err)
+	}
+}
+
+// writeError 写入JSON格式的错误响应
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// methodNotAllowed 写入405响应
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"toyou-proxy/config"
+	"toyou-proxy/registry"
+)
+
+// registerServiceHandlers 注册服务注册表接口
+//
+//	GET    /services          列出所有服务及其来源
+//	GET    /services/{name}   获取服务定义
+//	PUT    /services/{name}   注册或更新运行时服务
+//	DELETE /services/{name}   注销运行时服务
+func (s *Server) registerServiceHandlers() {
+	s.Handle("/services", s.handleServices)
+	s.Handle("/services/", s.handleService)
+}
+
+// handleServices 列出所有服务
+func (s *Server) handleServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, registry.GetDefaultRegistry().Entries())
+}
+
+// handleService 处理单个服务
+func (s *Server) handleService(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/services/")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "service name is required")
+		return
+	}
+
+	services := registry.GetDefaultRegistry()
+
+	switch r.Method {
+	case http.MethodGet:
+		service, exists := services.Get(name)
+		if !exists {
+			writeError(w, http.StatusNotFound, "service not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, service)
+	case http.MethodPut, http.MethodPost:
+		var service config.Service
+		if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if err := services.Register(name, service); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.store.save()
+		writeJSON(w, http.StatusOK, service)
+	case http.MethodDelete:
+		if !services.Deregister(name) {
+			writeError(w, http.StatusNotFound, "runtime service not found")
+			return
+		}
+		s.store.save()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete)
+	}
+}
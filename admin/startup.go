@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+
+	"toyou-proxy/config"
+)
+
+// ServerState 代理服务器的运行状态，由启动管理API的服务器提供
+type ServerState interface {
+	// GetConfig 返回当前生效的配置
+	GetConfig() *config.Config
+	// GetStartupReport 返回最近一次生成的启动报告，启动完成前返回nil
+	GetStartupReport() interface{}
+}
+
+// SetServerState 设置代理服务器的运行状态，未设置时 /startup 和 /config 返回503
+func (s *Server) SetServerState(state ServerState) {
+	s.state = state
+}
+
+// registerStartupHandlers 注册启动报告和配置接口
+//
+//	GET /startup    启动报告：监听器、域名规则、每个路由的中间件链、插件和警告，重新加载后为最近一次的结果
+//	GET /config     当前生效的配置，敏感配置项已替换；?format=yaml 时输出YAML
+func (s *Server) registerStartupHandlers() {
+	s.Handle("/startup", s.handleStartupReport)
+	s.Handle("/config", s.handleConfig)
+}
+
+// handleStartupReport 返回启动报告
+func (s *Server) handleStartupReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	var report interface{}
+	if s.state != nil {
+		report = s.state.GetStartupReport()
+	}
+	if report == nil {
+		writeError(w, http.StatusServiceUnavailable, "the server is still starting")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleConfig 返回当前生效的配置
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if s.state == nil {
+		writeError(w, http.StatusServiceUnavailable, "the server is still starting")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "yaml" {
+		writeError(w, http.StatusBadRequest, "format must be 'json' or 'yaml'")
+		return
+	}
+
+	values, err := s.state.GetConfig().Redacted()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if format == "yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+		yaml.NewEncoder(w).Encode(values)
+		return
+	}
+	writeJSON(w, http.StatusOK, values)
+}
@@ -0,0 +1,168 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"toyou-proxy/config"
+	"toyou-proxy/loadbalancer"
+	"toyou-proxy/registry"
+)
+
+// runtimeState 保存到状态文件的运行时修改
+type runtimeState struct {
+	Services map[string]config.Service `yaml:"services,omitempty"` // 通过管理API注册的服务
+	Drained  map[string][]string       `yaml:"drained,omitempty"`  // 通过管理API摘除的后端，按负载均衡器名称
+
+	// 通过管理API增删的后端和修改的权重，按负载均衡器名称
+	Backends map[string]loadbalancer.BackendChanges `yaml:"backends,omitempty"`
+}
+
+// stateStore 把通过管理API做的修改保存到状态文件，重启后恢复；为nil时不保存
+type stateStore struct {
+	path    string
+	mu      sync.Mutex
+	drained map[string]map[string]bool
+}
+
+// newStateStore 创建状态文件存储
+func newStateStore(path string) *stateStore {
+	return &stateStore{
+		path:    path,
+		drained: make(map[string]map[string]bool),
+	}
+}
+
+// restore 读取状态文件，重新注册运行时服务、应用后端的修改并摘除记录的后端；文件不存在时不做任何操作
+func (st *stateStore) restore() error {
+	data, err := os.ReadFile(st.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state runtimeState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%s: %v", st.path, err)
+	}
+
+	services := registry.GetDefaultRegistry()
+	for name, service := range state.Services {
+		if err := services.Register(name, service); err != nil {
+			log.Printf("Failed to restore runtime service %s: %v", name, err)
+		}
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// 先应用后端的修改，摘除记录可能指向通过管理API增加的后端
+	manager := loadbalancer.GetDefaultManager()
+	for name, changes := range state.Backends {
+		for _, backend := range changes.Removed {
+			if err := manager.RemoveBackend(name, backend); err != nil {
+				log.Printf("Removed backend of load balancer %s not restored: %v", name, err)
+			}
+		}
+		for backend, weight := range changes.Added {
+			if err := manager.AddBackend(name, backend, weight); err != nil {
+				log.Printf("Added backend of load balancer %s not restored: %v", name, err)
+			}
+		}
+		for backend, weight := range changes.Weights {
+			if err := manager.SetBackendWeight(name, backend, weight); err != nil {
+				log.Printf("Weight of backend of load balancer %s not restored: %v", name, err)
+			}
+		}
+	}
+
+	for name, backends := range state.Drained {
+		lb, err := manager.GetLoadBalancer(name)
+		if err != nil {
+			log.Printf("Drained backends of load balancer %s not restored: %v", name, err)
+			continue
+		}
+		for _, backend := range backends {
+			if err := lb.SetDraining(backend, true); err != nil {
+				log.Printf("Drained backend of load balancer %s not restored: %v", name, err)
+				continue
+			}
+			st.markDrained(name, backend, true)
+		}
+	}
+
+	log.Printf("Restored runtime state from %s: %d services, %d load balancers with changed backends, %d load balancers with drained backends",
+		st.path, len(state.Services), len(state.Backends), len(st.drained))
+	return nil
+}
+
+// setDrained 记录通过管理API摘除或恢复的后端并保存
+func (st *stateStore) setDrained(lb, backend string, drained bool) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	st.markDrained(lb, backend, drained)
+	st.mu.Unlock()
+	st.save()
+}
+
+// markDrained 更新摘除记录，调用方需要持有锁
+func (st *stateStore) markDrained(lb, backend string, drained bool) {
+	if drained {
+		if st.drained[lb] == nil {
+			st.drained[lb] = make(map[string]bool)
+		}
+		st.drained[lb][backend] = true
+		return
+	}
+	delete(st.drained[lb], backend)
+	if len(st.drained[lb]) == 0 {
+		delete(st.drained, lb)
+	}
+}
+
+// save 把运行时服务、后端的修改和摘除记录写入状态文件，先写临时文件再替换，避免写入中断时留下不完整的文件
+func (st *stateStore) save() {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	state := runtimeState{
+		Services: make(map[string]config.Service),
+		Drained:  make(map[string][]string),
+		Backends: loadbalancer.GetDefaultManager().BackendChanges(),
+	}
+	for _, entry := range registry.GetDefaultRegistry().Entries() {
+		if entry.Source == registry.SourceRuntime {
+			state.Services[entry.Name] = entry.Service
+		}
+	}
+	for lb, backends := range st.drained {
+		for backend := range backends {
+			state.Drained[lb] = append(state.Drained[lb], backend)
+		}
+		sort.Strings(state.Drained[lb])
+	}
+
+	data, err := yaml.Marshal(state)
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(st.path), "."+filepath.Base(st.path)+".tmp")
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, st.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save runtime state to %s: %v", st.path, err)
+	}
+}
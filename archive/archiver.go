@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"toyou-proxy/metrics"
+)
+
+// queueSize 等待写入的归档记录数上限，队列满时请求等待写入，而不是丢弃记录
+const queueSize = 1000
+
+// stopTimeout 停止时等待队列写完的最长时间
+const stopTimeout = 30 * time.Second
+
+// archivedRecords 归档记录写入结果
+var archivedRecords = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_archived_records_total",
+	"Request/response pairs written to the compliance archive.",
+	"result",
+)
+
+// Archiver 在后台加密并写入归档记录
+type Archiver struct {
+	store   Store
+	key     []byte
+	queue   chan *Record
+	done    chan struct{}
+	stopped bool
+	mu      sync.RWMutex
+}
+
+// NewArchiver 创建归档器并启动后台写入
+func NewArchiver(store Store, key []byte) *Archiver {
+	a := &Archiver{
+		store: store,
+		key:   key,
+		queue: make(chan *Record, queueSize),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Submit 提交归档记录，归档器已停止时直接写入
+func (a *Archiver) Submit(record *Record) {
+	if record.ID == "" {
+		record.ID = NewID(record.Time)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.stopped {
+		a.storeRecord(record)
+		return
+	}
+	a.queue <- record
+}
+
+// Start 后台写入在创建时已经启动
+func (a *Archiver) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop 写完队列中的记录后停止后台写入，最多等待stopTimeout
+func (a *Archiver) Stop() error {
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return nil
+	}
+	a.stopped = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	select {
+	case <-a.done:
+		return nil
+	case <-time.After(stopTimeout):
+		return fmt.Errorf("%d records still queued after %v", len(a.queue), stopTimeout)
+	}
+}
+
+// HealthCheck 队列积压超过一半时视为不健康，通常是存储不可用
+func (a *Archiver) HealthCheck() error {
+	if pending := len(a.queue); pending > queueSize/2 {
+		return fmt.Errorf("%d records waiting to be archived", pending)
+	}
+	return nil
+}
+
+// run 依次写入归档记录
+func (a *Archiver) run() {
+	defer close(a.done)
+	for record := range a.queue {
+		a.storeRecord(record)
+	}
+}
+
+// storeRecord 写入一条记录并记录结果
+func (a *Archiver) storeRecord(record *Record) {
+	if err := a.write(record); err != nil {
+		archivedRecords.Inc("failed")
+		log.Printf("Archive: failed to store record %s: %v", record.ID, err)
+		return
+	}
+	archivedRecords.Inc("stored")
+}
+
+// write 加密并保存一条记录
+func (a *Archiver) write(record *Record) error {
+	plaintext, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	sealed, err := Seal(a.key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	name := record.Time.UTC().Format("2006/01/02/") + record.ID + FileExt
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = a.store.Put(ctx, name, sealed)
+		cancel()
+		if err == nil || attempt == 3 {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// NewID 生成按时间排序的记录ID
+func NewID(t time.Time) string {
+	buf := make([]byte, 6)
+	rand.Read(buf)
+	return t.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(buf)
+}
@@ -0,0 +1,37 @@
+package archive
+
+import (
+	"net/http"
+	"time"
+)
+
+// Record 归档的请求/响应对
+type Record struct {
+	ID         string         `json:"id"`
+	Time       time.Time      `json:"time"`
+	DurationMs int64          `json:"duration_ms"`
+	ClientIP   string         `json:"client_ip"`
+	Subject    string         `json:"subject,omitempty"` // 调用方身份
+	Request    RequestRecord  `json:"request"`
+	Response   ResponseRecord `json:"response"`
+}
+
+// RequestRecord 归档的请求
+type RequestRecord struct {
+	Method        string      `json:"method"`
+	Host          string      `json:"host"`
+	URI           string      `json:"uri"`
+	Proto         string      `json:"proto"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// ResponseRecord 归档的响应
+type ResponseRecord struct {
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+	BodySize      int64       `json:"body_size"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"toyou-proxy/objectstore"
+)
+
+// FileExt 归档文件扩展名
+const FileExt = ".tpa"
+
+// Store 归档存储
+type Store interface {
+	// Put 保存归档记录，name为以/分隔的相对路径
+	Put(ctx context.Context, name string, data []byte) error
+}
+
+// DiskStore 本地磁盘归档存储，按保留期删除过期文件
+type DiskStore struct {
+	dir       string
+	retention time.Duration
+}
+
+// NewDiskStore 创建磁盘归档存储，retention为0时永久保留
+func NewDiskStore(dir string, retention time.Duration) (*DiskStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("dir is required for disk store")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create archive dir: %v", err)
+	}
+
+	ds := &DiskStore{dir: dir, retention: retention}
+	if retention > 0 {
+		go ds.enforceRetention()
+	}
+	return ds, nil
+}
+
+// Put 保存归档记录，先写临时文件再重命名，避免留下不完整的记录
+func (ds *DiskStore) Put(ctx context.Context, name string, data []byte) error {
+	target := filepath.Join(ds.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// enforceRetention 每小时删除超过保留期的归档文件
+func (ds *DiskStore) enforceRetention() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		ds.sweep(time.Now())
+		<-ticker.C
+	}
+}
+
+// sweep 删除超过保留期的归档文件和空目录
+func (ds *DiskStore) sweep(now time.Time) {
+	removed := 0
+	var dirs []string
+	filepath.Walk(ds.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if p != ds.dir {
+				dirs = append(dirs, p)
+			}
+			return nil
+		}
+		if strings.HasSuffix(p, FileExt) && now.Sub(info.ModTime()) > ds.retention {
+			if os.Remove(p) == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+
+	// 从最深的目录开始删除空目录
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	if removed > 0 {
+		log.Printf("Archive: removed %d record(s) older than %v", removed, ds.retention)
+	}
+}
+
+// S3Store S3兼容对象存储归档，保留期通过存储桶生命周期规则配置
+type S3Store struct {
+	client *objectstore.Client
+	prefix string
+}
+
+// NewS3Store 创建对象存储归档
+func NewS3Store(client *objectstore.Client, prefix string) *S3Store {
+	return &S3Store{client: client, prefix: strings.Trim(prefix, "/")}
+}
+
+// Put 上传归档记录
+func (s *S3Store) Put(ctx context.Context, name string, data []byte) error {
+	return s.client.PutObject(ctx, path.Join(s.prefix, name), data, "application/octet-stream")
+}
@@ -0,0 +1,166 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Report 压测结果
+type Report struct {
+	DurationMs float64      `json:"duration_ms"`
+	Sent       int          `json:"sent"`   // 发出的请求数
+	Missed     int          `json:"missed"` // 达到并发上限而跳过的请求数
+	RPS        float64      `json:"rps"`    // 实际完成的请求速率
+	Routes     []RouteStats `json:"routes"` // 按路由名称排序
+	Total      RouteStats   `json:"total"`
+}
+
+// RouteStats 一个路由的请求数和延迟分布，延迟单位为毫秒，只统计收到响应的请求
+type RouteStats struct {
+	Route    string         `json:"route"`
+	Requests int            `json:"requests"`
+	Errors   int            `json:"errors"` // 连接失败、超时等没有收到完整响应的请求
+	Status   map[string]int `json:"status"` // 按状态码分类（2xx、3xx、4xx、5xx）
+	Mean     float64        `json:"mean_ms"`
+	P50      float64        `json:"p50_ms"`
+	P90      float64        `json:"p90_ms"`
+	P95      float64        `json:"p95_ms"`
+	P99      float64        `json:"p99_ms"`
+	Max      float64        `json:"max_ms"`
+}
+
+// collector 收集每个路由的请求结果
+type collector struct {
+	mu     sync.Mutex
+	routes map[string]*routeResults
+}
+
+// routeResults 一个路由的请求结果
+type routeResults struct {
+	latencies []time.Duration
+	errors    int
+	status    map[string]int
+}
+
+func newCollector() *collector {
+	return &collector{routes: make(map[string]*routeResults)}
+}
+
+// add 记录一个请求的结果
+func (c *collector) add(route string, res result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, exists := c.routes[route]
+	if !exists {
+		r = &routeResults{status: make(map[string]int)}
+		c.routes[route] = r
+	}
+	if res.err != nil {
+		r.errors++
+		return
+	}
+	r.latencies = append(r.latencies, res.latency)
+	r.status[fmt.Sprintf("%dxx", res.status/100)]++
+}
+
+// report 汇总结果
+func (c *collector) report(elapsed time.Duration, sent, missed int) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := &Report{
+		DurationMs: milliseconds(elapsed),
+		Sent:       sent,
+		Missed:     missed,
+		Routes:     []RouteStats{},
+	}
+
+	total := &routeResults{status: make(map[string]int)}
+	for route, r := range c.routes {
+		report.Routes = append(report.Routes, r.stats(route))
+		total.latencies = append(total.latencies, r.latencies...)
+		total.errors += r.errors
+		for class, n := range r.status {
+			total.status[class] += n
+		}
+	}
+	sort.Slice(report.Routes, func(i, j int) bool {
+		return report.Routes[i].Route < report.Routes[j].Route
+	})
+	report.Total = total.stats("total")
+	if elapsed > 0 {
+		report.RPS = float64(report.Total.Requests) / elapsed.Seconds()
+	}
+	return report
+}
+
+// stats 计算延迟分布
+func (r *routeResults) stats(route string) RouteStats {
+	stats := RouteStats{
+		Route:    route,
+		Requests: len(r.latencies) + r.errors,
+		Errors:   r.errors,
+		Status:   r.status,
+	}
+	if len(r.latencies) == 0 {
+		return stats
+	}
+
+	latencies := append([]time.Duration(nil), r.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	stats.Mean = milliseconds(sum / time.Duration(len(latencies)))
+	stats.P50 = percentile(latencies, 0.50)
+	stats.P90 = percentile(latencies, 0.90)
+	stats.P95 = percentile(latencies, 0.95)
+	stats.P99 = percentile(latencies, 0.99)
+	stats.Max = milliseconds(latencies[len(latencies)-1])
+	return stats
+}
+
+// percentile 返回已排序延迟的百分位数（nearest-rank）
+func percentile(sorted []time.Duration, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return milliseconds(sorted[rank])
+}
+
+// milliseconds 把时长转换为毫秒
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteText 以表格输出压测结果
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Duration: %.1fs, sent: %d, missed: %d, throughput: %.1f req/s\n\n",
+		r.DurationMs/1000, r.Sent, r.Missed, r.RPS)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Route\tRequests\tErrors\t2xx\t3xx\t4xx\t5xx\tMean\tp50\tp90\tp95\tp99\tMax\t")
+	for _, stats := range append(r.Routes, r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			stats.Route, stats.Requests, stats.Errors,
+			stats.Status["2xx"], stats.Status["3xx"], stats.Status["4xx"], stats.Status["5xx"],
+			formatMs(stats.Mean), formatMs(stats.P50), formatMs(stats.P90), formatMs(stats.P95), formatMs(stats.P99), formatMs(stats.Max))
+	}
+	tw.Flush()
+}
+
+// formatMs 格式化毫秒数
+func formatMs(ms float64) string {
+	if ms >= 1000 {
+		return fmt.Sprintf("%.2fs", ms/1000)
+	}
+	return fmt.Sprintf("%.2fms", ms)
+}
@@ -0,0 +1,230 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 默认压测参数
+const (
+	defaultDuration    = 30 * time.Second
+	defaultConcurrency = 100
+	defaultTimeout     = 10 * time.Second
+)
+
+// scheduleInterval 检查需要发出的请求数的间隔，高RPS时每次批量发出
+const scheduleInterval = time.Millisecond
+
+// Options 压测参数
+type Options struct {
+	// 压测目标（通常是运行中的代理），如 http://127.0.0.1:8080；请求的域名放在Host头中，
+	// 为nil时请求直接发往记录中的URL
+	Target *url.URL
+	// 相对路径请求的Host头，默认为压测目标的地址
+	Host string
+	// 目标请求速率（每秒），按固定间隔发出请求，不等待之前的请求完成；为0时由并发数决定速率
+	RPS float64
+	// 压测时长，默认30秒
+	Duration time.Duration
+	// 发出的请求数上限，达到后停止，0表示不限制
+	Requests int
+	// 同时进行的请求数上限，默认100；达到上限时计划发出的请求被跳过并计入missed
+	Concurrency int
+	// 单个请求的超时时间，默认10秒
+	Timeout time.Duration
+	// 不校验HTTPS证书
+	Insecure bool
+	// 返回请求所属的路由，用于分组统计，为nil时按域名分组
+	Route func(host, path string) string
+}
+
+// request 一个待发送的请求
+type request struct {
+	target *Target
+	route  string
+}
+
+// Run 按参数发送请求并统计每个路由的延迟，ctx取消时提前结束
+func Run(ctx context.Context, targets []Target, opts Options) (*Report, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no requests to send")
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = defaultDuration
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	for _, t := range targets {
+		if t.URL.Host == "" && opts.Target == nil {
+			return nil, fmt.Errorf("a target address is required to send '%s'", t.URL)
+		}
+	}
+
+	// 预先计算每个请求的路由，避免在发送时重复匹配
+	requests := make([]request, len(targets))
+	for i := range targets {
+		host := targets[i].URL.Host
+		if host == "" {
+			host = opts.hostHeader()
+		}
+		route := host
+		if opts.Route != nil {
+			if route = opts.Route(host, targets[i].URL.Path); route == "" {
+				route = "(unmatched)"
+			}
+		}
+		requests[i] = request{target: &targets[i], route: route}
+	}
+
+	client := &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:               nil, // 不使用环境变量中的HTTP代理
+			MaxIdleConnsPerHost: opts.Concurrency,
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: opts.Insecure},
+		},
+		// 重放记录的请求时不跟随重定向，重定向响应本身计入统计
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	// 压测时长只限制发出新请求，已发出的请求等待完成
+	scheduleCtx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	collector := newCollector()
+	jobs := make(chan request)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range jobs {
+				res := send(ctx, client, req.target, opts)
+				// 中断压测时取消的请求不计入统计
+				if ctx.Err() == nil {
+					collector.add(req.route, res)
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	sent, missed := schedule(scheduleCtx, requests, opts, jobs)
+	close(jobs)
+	wg.Wait()
+
+	return collector.report(time.Since(start), sent, missed), nil
+}
+
+// schedule 按目标速率把请求交给空闲的worker，返回发出和跳过的请求数
+func schedule(ctx context.Context, requests []request, opts Options, jobs chan<- request) (sent, missed int) {
+	next := 0
+	take := func() request {
+		req := requests[next%len(requests)]
+		next++
+		return req
+	}
+	done := func() bool {
+		return opts.Requests > 0 && sent+missed >= opts.Requests
+	}
+
+	// 没有目标速率时，worker空闲后立即发出下一个请求
+	if opts.RPS <= 0 {
+		for !done() {
+			select {
+			case jobs <- take():
+				sent++
+			case <-ctx.Done():
+				return sent, missed
+			}
+		}
+		return sent, missed
+	}
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	start := time.Now()
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return sent, missed
+		case now := <-ticker.C:
+			due := int(now.Sub(start).Seconds()*opts.RPS) - sent - missed
+			for ; due > 0 && !done(); due-- {
+				select {
+				case jobs <- take():
+					sent++
+				default:
+					// 所有worker都在等待响应，跳过该请求而不是推迟，避免掩盖延迟（coordinated omission）
+					next++
+					missed++
+				}
+			}
+		}
+	}
+	return sent, missed
+}
+
+// result 一个请求的结果
+type result struct {
+	latency time.Duration
+	status  int
+	err     error
+}
+
+// send 发送请求并读完响应体，延迟为从发出请求到读完响应体的时间
+func send(ctx context.Context, client *http.Client, t *Target, opts Options) result {
+	u := *t.URL
+	host := u.Host
+	if opts.Target != nil {
+		u.Scheme, u.Host = opts.Target.Scheme, opts.Target.Host
+	}
+	if host == "" {
+		host = opts.hostHeader()
+	}
+
+	var body io.Reader
+	if len(t.Body) > 0 {
+		body = bytes.NewReader(t.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, t.Method, u.String(), body)
+	if err != nil {
+		return result{err: err}
+	}
+	req.Header = t.Header.Clone()
+	req.Host = host
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return result{latency: time.Since(start), status: resp.StatusCode, err: err}
+}
+
+// hostHeader 返回相对路径请求的Host头
+func (opts Options) hostHeader() string {
+	if opts.Host != "" {
+		return opts.Host
+	}
+	if opts.Target != nil {
+		return opts.Target.Host
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package bench
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"toyou-proxy/archive"
+	"toyou-proxy/headers"
+)
+
+// Target 一个压测请求，按加载的顺序循环发送
+type Target struct {
+	Method string
+	URL    *url.URL // 没有域名时为相对路径，发往压测目标地址
+	Header http.Header
+	Body   []byte
+}
+
+// LoadURLs 加载URL列表，每行一个 "[METHOD] URL"，方法默认为GET，忽略空行和以#开头的行
+// URL可以是完整的URL或以/开头的路径
+func LoadURLs(r io.Reader) ([]Target, error) {
+	var targets []Target
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		method, rawURL := http.MethodGet, text
+		if fields := strings.Fields(text); len(fields) == 2 {
+			method, rawURL = strings.ToUpper(fields[0]), fields[1]
+		} else if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected '[METHOD] URL'", line)
+		}
+
+		u, err := parseTargetURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		targets = append(targets, Target{Method: method, URL: u, Header: http.Header{}})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// harFile HAR文件中压测需要的字段
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// LoadHAR 加载HAR文件（如浏览器开发者工具导出的文件）中记录的请求
+func LoadHAR(r io.Reader) ([]Target, error) {
+	var har harFile
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, fmt.Errorf("invalid HAR file: %v", err)
+	}
+
+	targets := make([]Target, 0, len(har.Log.Entries))
+	for i, entry := range har.Log.Entries {
+		u, err := parseTargetURL(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i+1, err)
+		}
+		target := Target{Method: entry.Request.Method, URL: u, Header: http.Header{}}
+		for _, h := range entry.Request.Headers {
+			// HTTP/2的伪头部（如 :authority）不能作为请求头发送
+			if !strings.HasPrefix(h.Name, ":") {
+				target.Header.Add(h.Name, h.Value)
+			}
+		}
+		if entry.Request.PostData != nil {
+			target.Body = []byte(entry.Request.PostData.Text)
+		}
+		targets = append(targets, cleanTarget(target))
+	}
+	return targets, nil
+}
+
+// LoadArchive 加载合规归档记录中的请求，输入为 toyou-proxy archive decrypt 输出的JSON
+// 归档中的请求体可能被截断，截断的请求按记录的部分发送
+func LoadArchive(r io.Reader) ([]Target, error) {
+	var targets []Target
+	decoder := json.NewDecoder(r)
+	for {
+		var record archive.Record
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive record: %v", err)
+		}
+
+		u, err := url.Parse("http://" + record.Request.Host + record.Request.URI)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %v", record.ID, err)
+		}
+		header := record.Request.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		targets = append(targets, cleanTarget(Target{
+			Method: record.Request.Method,
+			URL:    u,
+			Header: header,
+			Body:   record.Request.Body,
+		}))
+	}
+	return targets, nil
+}
+
+// parseTargetURL 解析完整的URL或以/开头的路径
+func parseTargetURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" && !strings.HasPrefix(u.Path, "/") {
+		return nil, fmt.Errorf("invalid URL '%s', expected an absolute URL or a path", rawURL)
+	}
+	if u.Host != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme in '%s'", rawURL)
+	}
+	return u, nil
+}
+
+// cleanTarget 删除记录中由客户端连接决定的请求头，重放时重新生成
+func cleanTarget(t Target) Target {
+	headers.RemoveHopByHop(t.Header)
+	t.Header.Del("Content-Length")
+	t.Header.Del("Host")
+	return t
+}
@@ -15,7 +15,7 @@ rm -f toyou-proxy
 
 # 构建主程序
 echo "Building main application..."
-go build -o toyou-proxy cmd/main.go
+go build -o toyou-proxy ./cmd
 
 if [ $? -eq 0 ]; then
     echo "Build successful!"
@@ -23,6 +23,13 @@ if [ $? -eq 0 ]; then
     
     # 设置执行权限
     chmod +x toyou-proxy
+
+    # 预编译插件
+    echo "Precompiling plugins..."
+    if ! ./toyou-proxy plugins build; then
+        echo "Plugin precompilation failed!"
+        exit 1
+    fi
     
     # 显示使用说明
     echo ""
@@ -30,6 +37,7 @@ if [ $? -eq 0 ]; then
     echo "  ./toyou-proxy                    # 使用默认配置"
     echo "  ./toyou-proxy -config config.yaml # 指定配置文件"
     echo "  ./toyou-proxy -help              # 显示帮助"
+    echo "  ./toyou-proxy plugins build      # 预编译插件到 cache/plugins"
 else
     echo "Build failed!"
     exit 1
@@ -0,0 +1,192 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Resolver 根据可信代理解析请求的客户端IP
+// 只有连接的来源地址属于可信代理时才读取X-Forwarded-For和X-Real-IP，外部调用方可以伪造这些请求头
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver 创建客户端IP解析器，trustedProxies为可信代理的IP或网段（CIDR），为空时只使用连接的来源地址
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, entry := range trustedProxies {
+		network, err := parseNetwork(entry)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// Validate 检查可信代理配置是否有效
+func Validate(trustedProxies []string) error {
+	_, err := NewResolver(trustedProxies)
+	return err
+}
+
+// Overlap 返回与network重叠的可信代理配置项，没有重叠时返回空字符串；无效的配置项被忽略
+func Overlap(trustedProxies []string, network *net.IPNet) string {
+	for _, entry := range trustedProxies {
+		trusted, err := parseNetwork(entry)
+		if err != nil {
+			continue
+		}
+		if trusted.Contains(network.IP) || network.Contains(trusted.IP) {
+			return entry
+		}
+	}
+	return ""
+}
+
+// parseNetwork 解析IP或网段，单个IP视为只包含该地址的网段
+func parseNetwork(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s': %v", entry, err)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid trusted proxy '%s', expected an IP address or CIDR", entry)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// ClientIP 返回请求的客户端IP
+// 来源地址是可信代理时，从右向左检查X-Forwarded-For，跳过可信代理，第一个不可信的地址即为客户端；
+// 所有地址都可信时使用最左侧的地址；没有X-Forwarded-For时使用X-Real-IP
+func (r *Resolver) ClientIP(req *http.Request) string {
+	return r.clientIP(req, false)
+}
+
+// ForwardedClientIP 与ClientIP相同，但无论来源地址是否为可信代理都读取转发头，
+// 用于已知代理只能通过前面的负载均衡访问的部署；客户端IP至多为直接上游添加的地址，客户端自己添加的地址不会被采用
+func (r *Resolver) ForwardedClientIP(req *http.Request) string {
+	return r.clientIP(req, true)
+}
+
+// clientIP 解析客户端IP，trustPeer为true时总是信任连接来源的转发头
+func (r *Resolver) clientIP(req *http.Request, trustPeer bool) string {
+	peer := Peer(req)
+	if !trustPeer && !r.isTrusted(peer) {
+		return peer
+	}
+
+	hops := forwardedFor(req.Header)
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseIP(hops[i])
+		if ip == "" {
+			// 无效的地址之前的内容不可信，使用最后一个可信代理报告的地址
+			return client
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			return ip
+		}
+	}
+	if len(hops) > 0 {
+		return client
+	}
+
+	if ip := parseIP(req.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	return peer
+}
+
+// isTrusted 判断地址是否属于可信代理
+func (r *Resolver) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor 按顺序返回所有X-Forwarded-For请求头中的地址
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// parseIP 解析转发头中的地址，允许带端口（如 1.2.3.4:5678、[::1]:80），返回规范形式，无效时返回空字符串
+func parseIP(value string) string {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	ip := net.ParseIP(strings.Trim(value, "[]"))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// Peer 返回连接的来源地址（不含端口，IPv4映射的IPv6地址转换为IPv4），不读取任何请求头
+func Peer(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+var (
+	defaultResolver   = &Resolver{}
+	defaultResolverMu sync.RWMutex
+)
+
+// Configure 设置全局的可信代理，创建代理处理器和重新加载配置时调用
+func Configure(trustedProxies []string) error {
+	r, err := NewResolver(trustedProxies)
+	if err != nil {
+		return err
+	}
+	defaultResolverMu.Lock()
+	defaultResolver = r
+	defaultResolverMu.Unlock()
+	return nil
+}
+
+// GetDefaultResolver 获取按 advanced.trusted_proxies 配置的全局解析器
+func GetDefaultResolver() *Resolver {
+	defaultResolverMu.RLock()
+	defer defaultResolverMu.RUnlock()
+	return defaultResolver
+}
+
+// ClientIP 使用全局解析器返回请求的客户端IP
+func ClientIP(req *http.Request) string {
+	return GetDefaultResolver().ClientIP(req)
+}
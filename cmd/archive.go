@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"toyou-proxy/archive"
+)
+
+// runArchiveCommand 处理 archive 子命令，解密合规归档记录并输出JSON
+//
+//	toyou-proxy archive decrypt (-key base64 | -key-file file) record.tpa...
+func runArchiveCommand(args []string) int {
+	if len(args) == 0 || args[0] != "decrypt" {
+		fmt.Fprintln(os.Stderr, "Usage: toyou-proxy archive decrypt (-key base64 | -key-file file) record.tpa...")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("archive decrypt", flag.ExitOnError)
+	encodedKey := fs.String("key", "", "Base64 encoded encryption key")
+	keyFile := fs.String("key-file", "", "File containing the base64 encoded encryption key")
+	fs.Parse(args[1:])
+
+	key, err := archive.LoadKey(*encodedKey, *keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			data, err = archive.Open(key, data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		var out bytes.Buffer
+		if json.Indent(&out, data, "", "  ") != nil {
+			out.Reset()
+			out.Write(data)
+		}
+		out.WriteByte('\n')
+		os.Stdout.Write(out.Bytes())
+	}
+	return status
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"toyou-proxy/bench"
+	"toyou-proxy/config"
+	"toyou-proxy/proxy"
+)
+
+// runBenchCommand 处理 bench 子命令，按目标速率重放URL列表或记录的流量，按路由输出延迟百分位数
+//
+//	toyou-proxy bench (-urls file | -har file | -archive file) [-target url] [-rps n] [-duration 30s] [-config config.yaml]
+func runBenchCommand(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	urlsFile := fs.String("urls", "", "File with one '[METHOD] URL' per line, URL may be a path")
+	harFile := fs.String("har", "", "HAR file with recorded requests")
+	archiveFile := fs.String("archive", "", "Decrypted archive records (output of 'toyou-proxy archive decrypt')")
+	target := fs.String("target", "", "Address to send requests to, e.g. http://127.0.0.1:8080; the original host is sent in the Host header")
+	host := fs.String("host", "", "Host header for requests given as paths (default: the target address)")
+	rps := fs.Float64("rps", 0, "Target requests per second, 0 sends as fast as the concurrency allows")
+	duration := fs.Duration("duration", 0, "How long to send requests (default 30s)")
+	requests := fs.Int("n", 0, "Stop after sending this many requests")
+	concurrency := fs.Int("c", 0, "Maximum concurrent requests (default 100)")
+	timeout := fs.Duration("timeout", 0, "Timeout of a single request (default 10s)")
+	insecure := fs.Bool("insecure", false, "Skip TLS certificate verification")
+	configPath := fs.String("config", "", "Proxy configuration used to group results by host and route rule")
+	jsonOutput := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	targets, err := loadBenchTargets(*urlsFile, *harFile, *archiveFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	opts := bench.Options{
+		Host:        *host,
+		RPS:         *rps,
+		Duration:    *duration,
+		Requests:    *requests,
+		Concurrency: *concurrency,
+		Timeout:     *timeout,
+		Insecure:    *insecure,
+	}
+	if *target != "" {
+		opts.Target, err = url.Parse(*target)
+		if err != nil || (opts.Target.Scheme != "http" && opts.Target.Scheme != "https") || opts.Target.Host == "" {
+			fmt.Fprintf(os.Stderr, "Invalid target '%s', expected e.g. http://127.0.0.1:8080\n", *target)
+			return 2
+		}
+	}
+	if *configPath != "" {
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+			return 2
+		}
+		opts.Route = proxy.NewRouteLabeler(cfg.HostRules).Label
+	}
+
+	// Ctrl-C时停止压测，不输出结果
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := bench.Run(ctx, targets, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Interrupted")
+		return 1
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		report.WriteText(os.Stdout)
+	}
+	if report.Total.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadBenchTargets 从指定的一个文件加载压测请求
+func loadBenchTargets(urlsFile, harFile, archiveFile string) ([]bench.Target, error) {
+	var path string
+	var load func(io.Reader) ([]bench.Target, error)
+	for _, source := range []struct {
+		path string
+		load func(io.Reader) ([]bench.Target, error)
+	}{
+		{urlsFile, bench.LoadURLs},
+		{harFile, bench.LoadHAR},
+		{archiveFile, bench.LoadArchive},
+	} {
+		if source.path == "" {
+			continue
+		}
+		if path != "" {
+			return nil, fmt.Errorf("only one of -urls, -har and -archive can be given")
+		}
+		path, load = source.path, source.load
+	}
+	if path == "" {
+		return nil, fmt.Errorf("Usage: toyou-proxy bench (-urls file | -har file | -archive file) [-target url] [-rps n] [-duration 30s] [-config config.yaml]")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	targets, err := load(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no requests found", path)
+	}
+	return targets, nil
+}
@@ -11,16 +11,51 @@ import (
 )
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "plugins" {
+		os.Exit(runPluginsCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		os.Exit(runServiceCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "archive" {
+		os.Exit(runArchiveCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBenchCommand(os.Args[2:]))
+	}
+
 	// 解析命令行参数
 	var configPath string
+	var dryRun, probe bool
 	flag.StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	flag.BoolVar(&dryRun, "dry-run", false, "Load config, plugins and middleware chains, print a readiness report and exit")
+	flag.BoolVar(&probe, "probe", false, "Probe each service URL during --dry-run")
 	flag.Parse()
 
+	// 由Windows服务控制管理器启动
+	if isWindowsService() {
+		if err := runWindowsService(configPath); err != nil {
+			log.Fatalf("Windows service failed: %v", err)
+		}
+		return
+	}
+
 	// 检查配置文件是否存在
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		log.Fatalf("Configuration file not found: %s", configPath)
 	}
 
+	// 启动自检模式，不监听端口
+	if dryRun {
+		report := server.DryRun(configPath, probe)
+		report.Print(os.Stdout)
+		if !report.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 创建并启动服务器
 	srv, err := server.NewServer(configPath)
 	if err != nil {
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"toyou-proxy/middleware"
+)
+
+// runPluginsCommand 处理 plugins 子命令
+//
+//	toyou-proxy plugins build [-source dir] [-cache dir] [-workers n]
+func runPluginsCommand(args []string) int {
+	if len(args) == 0 || args[0] != "build" {
+		fmt.Fprintln(os.Stderr, "Usage: toyou-proxy plugins build [-source dir] [-cache dir] [-workers n]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("plugins build", flag.ExitOnError)
+	sourceDir := fs.String("source", middleware.DefaultPluginSourceDir, "Plugin source directory")
+	cacheDir := fs.String("cache", middleware.DefaultPluginCacheDir, "Plugin cache directory")
+	workers := fs.Int("workers", 0, "Number of parallel compile jobs (default: number of CPUs)")
+	fs.Parse(args[1:])
+
+	if !middleware.PluginsSupported {
+		log.Printf("Plugins are not supported on this platform")
+		return 1
+	}
+
+	apm := middleware.NewAutoPluginManager(*sourceDir, *cacheDir)
+	report, err := apm.BuildAll(*workers)
+	if report != nil {
+		report.Log()
+	}
+	if err != nil {
+		log.Printf("Plugin build failed: %v", err)
+		return 1
+	}
+	if len(report.Failed()) > 0 {
+		return 1
+	}
+
+	log.Printf("Plugins precompiled into %s", *cacheDir)
+	return 0
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// isWindowsService 非Windows平台始终返回false
+func isWindowsService() bool {
+	return false
+}
+
+// runWindowsService 非Windows平台不支持
+func runWindowsService(configPath string) error {
+	return fmt.Errorf("windows service mode is only supported on Windows")
+}
+
+// runServiceCommand 非Windows平台请使用systemd管理服务
+func runServiceCommand(args []string) int {
+	fmt.Fprintln(os.Stderr, "The service command is only supported on Windows; use systemd on Linux")
+	return 2
+}
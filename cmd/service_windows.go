@@ -0,0 +1,191 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"toyou-proxy/server"
+)
+
+// windowsServiceName Windows服务名称
+const windowsServiceName = "ToyouProxy"
+
+// proxyService Windows服务处理器
+type proxyService struct {
+	configPath string
+}
+
+// Execute 实现svc.Handler，在服务控制管理器的请求下启动和停止代理
+func (ps *proxyService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	srv, err := server.NewServer(ps.configPath)
+	if err != nil {
+		log.Printf("Failed to create server: %v", err)
+		return true, 1
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Start()
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("Server stopped with error: %v", err)
+				return true, 2
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				srv.Shutdown()
+				if err := <-done; err != nil {
+					log.Printf("Server stopped with error: %v", err)
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// isWindowsService 当前进程是否由服务控制管理器启动
+func isWindowsService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+// runWindowsService 以Windows服务方式运行
+// 服务的工作目录是System32，需要切换到程序所在目录以便使用相对路径的配置和插件目录，
+// 日志写入程序目录下的toyou-proxy.log
+func runWindowsService(configPath string) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(filepath.Dir(exePath)); err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile("toyou-proxy.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		log.SetOutput(logFile)
+		defer logFile.Close()
+	}
+
+	return svc.Run(windowsServiceName, &proxyService{configPath: configPath})
+}
+
+// runServiceCommand 处理 service 子命令
+//
+//	toyou-proxy service install [-config path]
+//	toyou-proxy service uninstall
+func runServiceCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: toyou-proxy service install [-config path] | uninstall")
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		configPath := "config.yaml"
+		if len(args) >= 3 && args[1] == "-config" {
+			configPath = args[2]
+		}
+		err = installWindowsService(configPath)
+	case "uninstall":
+		err = uninstallWindowsService()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown service command: %s\n", args[0])
+		return 2
+	}
+
+	if err != nil {
+		log.Printf("Service %s failed: %v", args[0], err)
+		return 1
+	}
+	log.Printf("Service %s succeeded", args[0])
+	return 0
+}
+
+// installWindowsService 注册Windows服务，开机自动启动
+func installWindowsService(configPath string) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(windowsServiceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", windowsServiceName)
+	}
+
+	s, err := m.CreateService(windowsServiceName, exePath, mgr.Config{
+		DisplayName: "Toyou Proxy",
+		Description: "Toyou reverse proxy server",
+		StartType:   mgr.StartAutomatic,
+	}, "-config", configPath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// 异常退出后自动重启
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	}
+	if err := s.SetRecoveryActions(recovery, 86400); err != nil {
+		log.Printf("Failed to set service recovery actions: %v", err)
+	}
+
+	if err := eventlog.InstallAsEventCreate(windowsServiceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		log.Printf("Failed to register event log source: %v", err)
+	}
+
+	return nil
+}
+
+// uninstallWindowsService 删除Windows服务
+func uninstallWindowsService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(windowsServiceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", windowsServiceName)
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	eventlog.Remove(windowsServiceName)
+	return nil
+}
@@ -1,32 +1,52 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"toyou-proxy/clientip"
+	"toyou-proxy/matcher"
 )
 
 // Config 表示整个代理服务的配置
 type Config struct {
 	// 配置文件目录
 	ConfigDir string `yaml:"config_dir"`
+	// 监听器声明，为空时按域名规则的端口监听
+	Listeners []Listener `yaml:"listeners,omitempty"`
 	// 域名匹配规则
 	HostRules []HostRule `yaml:"host_rules"`
 	// 路由匹配规则
 	RouteRules []RouteRule `yaml:"route_rules"`
 	// 服务定义
 	Services map[string]Service `yaml:"services"`
+	// 命名负载均衡器，多个服务通过load_balancer_ref共享同一组后端、健康检查和统计
+	LoadBalancers map[string]LoadBalancerConfig `yaml:"load_balancers,omitempty"`
 	// 中间件配置
 	Middlewares []Middleware `yaml:"middlewares"`
 	// 中间件服务注册（支持自定义名称注册）
 	MiddlewareServices []MiddlewareService `yaml:"middleware_services"`
 	// 高级配置
 	Advanced AdvancedConfig `yaml:"advanced"`
+	// 管理API配置
+	Admin AdminConfig `yaml:"admin"`
+	// 插件配置
+	Plugins PluginsConfig `yaml:"plugins"`
+	// 密钥提供方配置
+	Secrets SecretsConfig `yaml:"secrets"`
+	// 多个代理实例共享的状态存储
+	Stores StoresConfig `yaml:"stores,omitempty"`
 }
 
 // HostRule 域名匹配规则
@@ -36,6 +56,46 @@ type HostRule struct {
 	Target      string      `yaml:"target"`
 	Middlewares []string    `yaml:"middlewares,omitempty"` // 域名级中间件装配
 	RouteRules  []RouteRule `yaml:"route_rules,omitempty"`
+	// 生效时间窗口，配置后仅在窗口内生效
+	ActiveWindows []ActiveWindow `yaml:"active_windows,omitempty"`
+	// 暗发布配置，携带密钥的请求转发到替代服务
+	DarkLaunch *DarkLaunchConfig `yaml:"dark_launch,omitempty"`
+	// 请求超时（秒），覆盖全局的advanced.timeout.request_timeout
+	Timeout int `yaml:"timeout,omitempty"`
+	// 上游响应体大小上限，如 "10MB"，为空时不限制
+	MaxResponseSize string `yaml:"max_response_size,omitempty"`
+	// 允许的请求方法，为空时不限制，其余方法返回405
+	AllowedMethods []string `yaml:"allowed_methods,omitempty"`
+	// 访问所需的scopes/角色，调用方身份由认证中间件提供
+	Authorization *AuthorizationConfig `yaml:"authorization,omitempty"`
+	// 每个用户的并发会话/设备数限制，依赖会话中间件和认证中间件
+	SessionLimit *SessionLimitConfig `yaml:"session_limit,omitempty"`
+	// 响应压缩（HTML/CSS/JS minify）配置
+	Minify *MinifyConfig `yaml:"minify,omitempty"`
+	// 按Accept请求头选择目标服务，未命中时使用target
+	ContentTargets []ContentTarget `yaml:"content_targets,omitempty"`
+	// 代理标识响应头，覆盖advanced.proxy_headers
+	ProxyHeaders *ProxyHeadersConfig `yaml:"proxy_headers,omitempty"`
+	// 添加到上游响应的静态响应头，覆盖advanced.response_headers中的同名响应头，值为空时删除该响应头
+	ResponseHeaders map[string]string `yaml:"response_headers,omitempty"`
+	// 规则标签（如team、product、tier），附加到日志、指标和追踪记录
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// 域名证书，所在端口提供HTTPS，多个域名共享端口时按SNI选择证书
+	TLS *HostTLSConfig `yaml:"tls,omitempty"`
+	// 调试转储，记录采样请求的完整请求和上游响应
+	Dump *DumpConfig `yaml:"dump,omitempty"`
+	// 延迟SLO，统计滚动达标率和错误预算燃烧率，燃烧率超过阈值时通知webhook
+	SLO *SLOConfig `yaml:"slo,omitempty"`
+	// 上游请求失败时的重试策略
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+}
+
+// HostTLSConfig 域名规则的证书，使用证书文件或通过ACME自动申请
+type HostTLSConfig struct {
+	CertFile string `yaml:"cert_file"` // 证书文件（PEM，可包含中间证书）
+	KeyFile  string `yaml:"key_file"`  // 私钥文件（PEM）
+	// 通过advanced.tls.acme自动申请和续期证书，不能与cert_file/key_file同时配置，pattern不能包含通配符
+	ACME bool `yaml:"acme"`
 }
 
 // RouteRule 路由匹配规则
@@ -43,13 +103,166 @@ type RouteRule struct {
 	Pattern     string   `yaml:"pattern"`
 	Target      string   `yaml:"target"`
 	Middlewares []string `yaml:"middlewares,omitempty"` // 路由级中间件装配
+	// 生效时间窗口，配置后仅在窗口内生效（如维护期重定向）
+	ActiveWindows []ActiveWindow `yaml:"active_windows,omitempty"`
+	// 暗发布配置，优先于域名级暗发布配置
+	DarkLaunch *DarkLaunchConfig `yaml:"dark_launch,omitempty"`
+	// 请求超时（秒），优先于域名级超时
+	Timeout int `yaml:"timeout,omitempty"`
+	// 上游响应体大小上限，优先于域名级配置
+	MaxResponseSize string `yaml:"max_response_size,omitempty"`
+	// 允许的请求方法，优先于域名级配置
+	AllowedMethods []string `yaml:"allowed_methods,omitempty"`
+	// 访问所需的scopes/角色，优先于域名级配置
+	Authorization *AuthorizationConfig `yaml:"authorization,omitempty"`
+	// 响应压缩配置，优先于域名级配置
+	Minify *MinifyConfig `yaml:"minify,omitempty"`
+	// 按Accept请求头选择目标服务，优先于域名级配置
+	ContentTargets []ContentTarget `yaml:"content_targets,omitempty"`
+	// 添加到上游响应的静态响应头，覆盖域名级的同名响应头
+	ResponseHeaders map[string]string `yaml:"response_headers,omitempty"`
+	// 规则标签，与域名级标签合并，同名标签覆盖域名级的值
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// 调试转储，优先于域名级配置
+	Dump *DumpConfig `yaml:"dump,omitempty"`
+	// 重试策略，优先于域名级配置
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+}
+
+// RetryConfig 上游请求失败（连接失败、超时或返回指定状态码）时的重试策略，
+// 服务配置了负载均衡时优先重试其他后端
+type RetryConfig struct {
+	Attempts int      `yaml:"attempts"`          // 最多尝试次数（包括第一次），1表示不重试
+	On       []int    `yaml:"on,omitempty"`      // 需要重试的上游状态码，默认502、503、504
+	Methods  []string `yaml:"methods,omitempty"` // 允许重试的请求方法，默认GET、HEAD、OPTIONS、PUT、DELETE
+	// 第一次重试前的等待时间（毫秒），之后每次加倍，默认100
+	Backoff int `yaml:"backoff,omitempty"`
+	// 重试等待时间的上限（毫秒），默认2000
+	MaxBackoff int `yaml:"max_backoff,omitempty"`
+	// 每次尝试的超时时间（毫秒），超时后重试，0表示只受请求超时限制
+	PerTryTimeout int `yaml:"per_try_timeout,omitempty"`
+}
+
+// ContentTarget 内容协商目标，请求的Accept头最偏好该媒体类型时转发到对应服务
+type ContentTarget struct {
+	Type   string `yaml:"type"`   // 媒体类型，如 application/json、text/html
+	Target string `yaml:"target"` // 目标服务
+}
+
+// MinifyConfig 响应压缩配置，去除HTML/CSS/JS中的注释和多余空白
+type MinifyConfig struct {
+	Enabled *bool    `yaml:"enabled,omitempty"`  // 默认启用，路由级可设置为false关闭
+	Types   []string `yaml:"types,omitempty"`    // html、css、js，默认全部
+	MinSize string   `yaml:"min_size,omitempty"` // 小于该大小的响应不处理，默认1KB
+}
+
+// DumpConfig 调试转储配置，按比例采样或按调试请求头记录转发到上游的完整请求和上游响应
+type DumpConfig struct {
+	SampleRate float64 `yaml:"sample_rate,omitempty"` // 按比例采样（0-1）
+	// 调试令牌，请求携带 X-Debug-Dump: <token> 时总是转储，为空时不启用
+	Token string `yaml:"token,omitempty"`
+	// 每个请求体和响应体最多记录的大小，默认64KB，超出部分只计入大小
+	MaxBodySize string `yaml:"max_body_size,omitempty"`
+	// 同时把转储写入日志，默认只能通过管理API查看
+	Log bool `yaml:"log,omitempty"`
+	// 每个规则保留的最近转储数，默认100，可通过管理API导出为HAR文件
+	BufferSize int `yaml:"buffer_size,omitempty"`
+}
+
+// SLOConfig 延迟SLO：统计窗口内延迟不超过latency的请求比例应达到objective
+// 只统计普通HTTP请求，SSE和WebSocket长连接不计入
+type SLOConfig struct {
+	Objective float64 `yaml:"objective"` // 目标达标比例（0-1之间），如0.99
+	Latency   int     `yaml:"latency"`   // 延迟阈值（毫秒）
+	Window    int     `yaml:"window"`    // 达标率统计窗口（秒），默认86400，最长7天
+	// 燃烧率告警，任一窗口的燃烧率超过阈值时触发，回落后恢复
+	Alerts []SLOAlert `yaml:"alerts,omitempty"`
+	// 告警触发和恢复时POST通知的地址
+	Webhooks []string `yaml:"webhooks,omitempty"`
+}
+
+// SLOAlert 燃烧率告警：窗口内慢请求比例与错误预算（1-objective）之比超过burn_rate时触发
+type SLOAlert struct {
+	Window      int     `yaml:"window"`                 // 燃烧率统计窗口（秒），最长7天
+	BurnRate    float64 `yaml:"burn_rate"`              // 燃烧率阈值，如14.4表示按当前速度约2天耗尽30天的错误预算
+	MinRequests int     `yaml:"min_requests,omitempty"` // 窗口内请求数少于该值时不触发，避免流量很小时误报
+}
+
+// AuthorizationConfig 授权要求
+type AuthorizationConfig struct {
+	Scopes []string `yaml:"scopes,omitempty"` // 需要的scopes
+	Roles  []string `yaml:"roles,omitempty"`  // 需要的角色
+	// 匹配方式：all 需要全部scopes和角色（默认），any 满足其中任意一个即可
+	Match string `yaml:"match,omitempty"`
+}
+
+// SessionLimitConfig 并发会话限制
+type SessionLimitConfig struct {
+	MaxSessions int `yaml:"max_sessions,omitempty"` // 每个用户的最大并发会话数，0表示不限制
+	MaxDevices  int `yaml:"max_devices,omitempty"`  // 每个用户的最大设备数，0表示不限制
+	// 超出限制时的处理方式：reject 拒绝新会话（默认），evict_oldest 踢出最早登录的会话
+	Action string `yaml:"action,omitempty"`
+	// 会话空闲多久后不再计入（秒），默认1800
+	IdleTimeout int `yaml:"idle_timeout,omitempty"`
+}
+
+// DarkLaunchConfig 暗发布配置
+// 请求携带指定的请求头或Cookie且值与密钥一致时，转发到替代的目标服务，
+// 便于开发者在生产域名上安全地验证预发布代码
+type DarkLaunchConfig struct {
+	Header string `yaml:"header,omitempty"` // 触发暗发布的请求头名称
+	Cookie string `yaml:"cookie,omitempty"` // 触发暗发布的Cookie名称
+	Secret string `yaml:"secret"`           // 密钥值
+	Target string `yaml:"target"`           // 命中时转发的目标服务
+}
+
+// ActiveWindow 生效时间窗口
+// Schedule为cron风格表达式（分 时 日 月 周），当前时间满足表达式的分钟即处于窗口内，
+// 例如 "* 2-3 * * 0" 表示每周日02:00-03:59
+type ActiveWindow struct {
+	Schedule string `yaml:"schedule"`           // cron表达式
+	Timezone string `yaml:"timezone,omitempty"` // 时区，如 "Asia/Shanghai"，默认本地时区
 }
 
 // Service 服务定义
 type Service struct {
-	URL          string              `yaml:"url"`
-	ProxyHost    string              `yaml:"proxy_host,omitempty"`    // 反向代理时使用的Host头，可选
-	LoadBalancer *LoadBalancerConfig `yaml:"load_balancer,omitempty"` // 负载均衡配置，可选
+	URL          string              `yaml:"url" json:"url"`
+	ProxyHost    string              `yaml:"proxy_host,omitempty" json:"proxy_host,omitempty"`       // 反向代理时使用的Host头，可选
+	LoadBalancer *LoadBalancerConfig `yaml:"load_balancer,omitempty" json:"load_balancer,omitempty"` // 负载均衡配置，可选
+	// 使用load_balancers中的命名负载均衡器，与load_balancer不能同时配置
+	LoadBalancerRef string `yaml:"load_balancer_ref,omitempty" json:"load_balancer_ref,omitempty"`
+	// 服务类型：http（默认）或 s3，s3服务的url为对象存储服务地址
+	Type string           `yaml:"type,omitempty" json:"type,omitempty"`
+	S3   *S3ServiceConfig `yaml:"s3,omitempty" json:"s3,omitempty"`
+	// 启动后预先建立的空闲连接，可选
+	Warmup *WarmupConfig `yaml:"warmup,omitempty" json:"warmup,omitempty"`
+}
+
+// WarmupConfig 连接预热配置，启动后向每个后端并发发送HEAD请求，使连接池中保留空闲连接，
+// 第一批请求不需要等待TCP/TLS握手
+type WarmupConfig struct {
+	Connections int    `yaml:"connections" json:"connections"`             // 每个后端预先建立的连接数
+	Path        string `yaml:"path,omitempty" json:"path,omitempty"`       // 预热请求的路径，默认 /
+	Timeout     int    `yaml:"timeout,omitempty" json:"timeout,omitempty"` // 预热超时（秒），默认5
+}
+
+// 服务类型
+const (
+	ServiceTypeHTTP = "http"
+	ServiceTypeS3   = "s3"
+)
+
+// S3ServiceConfig S3兼容存储桶服务配置，代理使用配置的凭证为请求签名，客户端无需也无法获得凭证
+type S3ServiceConfig struct {
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	Region    string `yaml:"region,omitempty" json:"region,omitempty"` // 默认 us-east-1
+	Prefix    string `yaml:"prefix,omitempty" json:"prefix,omitempty"` // 请求路径映射到的对象键前缀
+	PathStyle bool   `yaml:"path_style,omitempty" json:"path_style,omitempty"`
+	// 请求路径以/结尾时读取的对象，默认 index.html
+	IndexDocument string `yaml:"index_document,omitempty" json:"index_document,omitempty"`
+	AccessKey     string `yaml:"access_key" json:"-"`
+	SecretKey     string `yaml:"secret_key" json:"-"`
+	SessionToken  string `yaml:"session_token,omitempty" json:"-"`
 }
 
 // Middleware 中间件配置
@@ -57,6 +270,8 @@ type Middleware struct {
 	Name    string                 `yaml:"name"`
 	Enabled bool                   `yaml:"enabled"`
 	Config  map[string]interface{} `yaml:"config"`
+	// 生效时间窗口，配置后仅在窗口内挂载该中间件
+	ActiveWindows []ActiveWindow `yaml:"active_windows,omitempty"`
 }
 
 // MiddlewareService 中间件服务定义，支持自定义名称注册
@@ -68,13 +283,254 @@ type MiddlewareService struct {
 	IsGlobal    bool                   `yaml:"is_global"`   // 是否全局加载（默认false）
 	Config      map[string]interface{} `yaml:"config"`      // 中间件配置
 	Description string                 `yaml:"description"` // 中间件描述（可选）
+	// 生效时间窗口，配置后仅在窗口内挂载该中间件服务
+	ActiveWindows []ActiveWindow `yaml:"active_windows,omitempty"`
 }
 
 // AdvancedConfig 高级配置
 type AdvancedConfig struct {
-	Timeout  TimeoutConfig  `yaml:"timeout"`
-	Port     int            `yaml:"port"`
-	Security SecurityConfig `yaml:"security"`
+	Timeout   TimeoutConfig   `yaml:"timeout"`
+	Port      int             `yaml:"port"`
+	Security  SecurityConfig  `yaml:"security"`
+	Normalize NormalizeConfig `yaml:"normalize"`
+	Limits    LimitsConfig    `yaml:"limits"`
+	Privacy   PrivacyConfig   `yaml:"privacy"`
+	// 中间件链追踪
+	ChainTrace ChainTraceConfig `yaml:"chain_trace"`
+	// 调试日志
+	DebugLog DebugLogConfig `yaml:"debug_log"`
+	// 过载保护
+	Admission AdmissionConfig `yaml:"admission"`
+	// HTTPS监听
+	TLS TLSConfig `yaml:"tls"`
+	// 代理标识响应头
+	ProxyHeaders ProxyHeadersConfig `yaml:"proxy_headers"`
+	// 添加到所有上游响应的静态响应头，值为空时删除该响应头
+	ResponseHeaders map[string]string `yaml:"response_headers,omitempty"`
+	// 内部路径（健康检查、指标采集等），来自内部网段的请求跳过认证、限流等中间件
+	InternalPaths []InternalPathRule `yaml:"internal_paths,omitempty"`
+	// 可信代理（IP或CIDR），只有来自这些地址的请求才使用X-Forwarded-For和X-Real-IP识别客户端IP，
+	// 用于限流、ip_hash负载均衡等；为空时只使用连接的来源地址
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	// 优雅关闭
+	Shutdown ShutdownConfig `yaml:"shutdown"`
+	// 配置热重载
+	Reload ReloadConfig `yaml:"reload"`
+}
+
+// ReloadConfig 配置热重载：收到SIGHUP或配置文件变化后重新加载路由、中间件和服务，正在处理的请求不受影响
+type ReloadConfig struct {
+	Watch    bool `yaml:"watch"`    // 监视主配置文件和config_dir中的配置文件，变化后自动重新加载
+	Interval int  `yaml:"interval"` // 检查配置文件是否变化的间隔（秒），默认5
+
+	// 重新加载后，旧配置上仍在进行的SSE和WebSocket流最多保留的时间（秒），
+	// 之后发送重新连接提示并关闭，客户端重新连接后使用新配置；默认0表示保留到流结束
+	StreamGracePeriod int `yaml:"stream_grace_period"`
+}
+
+// ShutdownConfig 优雅关闭配置：停止接受新请求后等待正在处理的请求完成，超时后强制关闭剩余连接
+type ShutdownConfig struct {
+	DrainTimeout   int `yaml:"drain_timeout"`   // 等待请求完成的最长时间（秒），默认30
+	ReportInterval int `yaml:"report_interval"` // 等待期间输出仍在处理的请求列表的间隔（秒），默认5
+}
+
+// InternalPathRule 内部路径规则，请求路径和来源地址都匹配时跳过中间件
+type InternalPathRule struct {
+	Paths []string `yaml:"paths"` // 路径模式，语法与route_rules相同
+	// 允许的来源网段（CIDR），按连接的来源地址判断；必须配置，不能与trusted_proxies重叠
+	Networks []string `yaml:"networks,omitempty"`
+	// 跳过的中间件名称，为空时跳过整个中间件链（包括授权检查和会话限制）
+	Middlewares []string `yaml:"middlewares,omitempty"`
+}
+
+// 代理标识响应头的添加方式
+const (
+	ProxyHeadersAlways   = "always"   // 总是添加（默认）
+	ProxyHeadersInternal = "internal" // 只对内部调用方添加
+	ProxyHeadersNever    = "never"    // 不添加
+)
+
+// ProxyHeadersConfig 代理标识响应头（X-Proxy-By、X-Target-Service、X-SSE-Proxy）配置
+// 这些响应头会暴露代理和后端服务名称，面向互联网的域名可以关闭或只对内部调用方添加
+type ProxyHeadersConfig struct {
+	Mode string `yaml:"mode"` // always（默认）、internal、never
+	// 内部调用方网段（CIDR），按连接的来源地址判断，不信任X-Forwarded-For；默认为回环和私有地址
+	InternalNetworks []string `yaml:"internal_networks,omitempty"`
+}
+
+// TLSConfig HTTPS监听配置，ports中的端口使用证书提供HTTPS，其余端口仍为HTTP
+type TLSConfig struct {
+	Ports []int `yaml:"ports"` // 提供HTTPS的端口
+	// 默认证书，客户端的SNI没有匹配到配置了tls的域名规则时使用
+	CertFile string `yaml:"cert_file"` // 证书文件（PEM，可包含中间证书）
+	KeyFile  string `yaml:"key_file"`  // 私钥文件（PEM）
+	// 检查证书和私钥文件是否变化的间隔（秒），默认10；文件变化后自动重新加载，无需重启
+	ReloadInterval int `yaml:"reload_interval"`
+	// 所有HTTPS端口默认的TLS策略
+	Policy TLSPolicy `yaml:"policy"`
+	// 按端口覆盖的TLS策略，只需设置与默认策略不同的字段
+	PortPolicies map[int]TLSPolicy `yaml:"port_policies"`
+	// 会话票据密钥，用于TLS会话恢复
+	SessionTickets SessionTicketConfig `yaml:"session_tickets"`
+	// 加密ClientHello密钥，在策略中设置ech: true的端口使用
+	ECH ECHConfig `yaml:"ech"`
+	// ACME自动证书，供tls中设置acme: true的域名规则使用
+	ACME ACMEConfig `yaml:"acme"`
+	// 按端口的域名证书，由EffectiveTLS根据域名规则的tls生成
+	HostCertificates map[int][]HostCertificate `yaml:"-"`
+}
+
+// HostCertificate 挂载到HTTPS端口的域名证书
+type HostCertificate struct {
+	Pattern  string
+	CertFile string
+	KeyFile  string
+	ACME     bool // 证书通过ACME申请，CertFile和KeyFile为空
+}
+
+// ACMEConfig ACME自动证书配置（如Let's Encrypt）
+// 同时支持TLS-ALPN-01（在HTTPS端口完成验证）和HTTP-01（在HTTP端口的 /.well-known/acme-challenge/ 完成验证）
+type ACMEConfig struct {
+	Email        string `yaml:"email"`         // 账户联系邮箱，用于接收到期提醒，可选
+	DirectoryURL string `yaml:"directory_url"` // ACME目录地址，默认Let's Encrypt生产环境
+	CacheDir     string `yaml:"cache_dir"`     // 账户密钥和证书的缓存目录，重启后继续使用已申请的证书
+	AcceptTOS    bool   `yaml:"accept_tos"`    // 同意CA的服务条款，必须显式开启
+	RenewBefore  int    `yaml:"renew_before"`  // 证书到期前多少天续期，默认30
+}
+
+// SessionTicketConfig 会话票据密钥配置
+// 密钥定期轮换，最新的密钥加密新票据，保留的旧密钥继续解密已发出的票据；
+// 多个实例通过同一个文件或Redis共享密钥后，客户端在任意实例上都可以恢复会话
+type SessionTicketConfig struct {
+	Disabled         bool   `yaml:"disabled"`          // 关闭会话票据，客户端每次都完整握手
+	RotationInterval int    `yaml:"rotation_interval"` // 轮换间隔（秒），默认3600
+	Keep             int    `yaml:"keep"`              // 保留的旧密钥数，默认2
+	KeyFile          string `yaml:"key_file"`          // 共享密钥文件，为空时不通过文件共享
+	// 通过Redis共享密钥，配置了addr时优先于key_file
+	Redis TicketRedisConfig `yaml:"redis"`
+}
+
+// TicketRedisConfig 会话票据密钥的Redis存储
+type TicketRedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Key      string `yaml:"key"` // 保存密钥的列表键，默认 toyou:tls:ticket_keys
+}
+
+// StoresConfig 共享状态存储配置
+type StoresConfig struct {
+	// 配置后会话保持的会话表和限流的令牌桶保存在Redis中，多个代理实例共享
+	Redis *RedisStoreConfig `yaml:"redis,omitempty"`
+}
+
+// RedisStoreConfig 共享状态的Redis存储
+type RedisStoreConfig struct {
+	Addr      string `yaml:"addr"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix"` // 键前缀，默认 toyou:
+	Timeout   int    `yaml:"timeout"`    // 单次操作的超时（毫秒），超时或出错时使用本地状态，默认100
+}
+
+// AdmissionConfig 过载保护配置，代理负载超过上限时直接返回503，各项为0时不检查
+type AdmissionConfig struct {
+	MaxInFlight   int    `yaml:"max_in_flight"`  // 正在处理的请求数上限（包括WebSocket和SSE长连接）
+	MaxGoroutines int    `yaml:"max_goroutines"` // goroutine数上限
+	MaxMemory     string `yaml:"max_memory"`     // 堆内存上限，如 "2GB"
+	RetryAfter    int    `yaml:"retry_after"`    // 503响应的Retry-After（秒），默认1
+}
+
+// DebugLogConfig 调试日志配置，控制域名匹配、中间件链构建等每个请求都会产生的日志
+type DebugLogConfig struct {
+	// 是否输出调试日志，默认关闭
+	Enabled bool `yaml:"enabled"`
+	// 按比例采样输出（0-1），0或1表示全部输出
+	SampleRate float64 `yaml:"sample_rate"`
+}
+
+// ChainTraceConfig 中间件链追踪配置，记录请求经过的中间件及每个中间件的耗时
+type ChainTraceConfig struct {
+	// 按比例采样请求（0-1），采样的追踪记录通过管理API查看
+	SampleRate float64 `yaml:"sample_rate"`
+	// 调试令牌，请求携带 X-Debug-Chain: <token> 时总是追踪并在响应头中返回中间件链，为空时不启用
+	Token string `yaml:"token"`
+	// 保留最近的追踪记录数，默认100
+	BufferSize int `yaml:"buffer_size"`
+}
+
+// PrivacyConfig 隐私配置，开启匿名模式后对访问日志中的客户端IP、敏感请求头和查询参数脱敏
+type PrivacyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 客户端IP的处理方式：hash（默认）、truncate 或 none
+	IPMode string `yaml:"ip_mode"`
+	// 哈希IP使用的盐，为空时每次启动随机生成
+	IPHashSalt string `yaml:"ip_hash_salt"`
+	// 在默认列表之外需要删除值的请求头和查询参数
+	RedactHeaders     []string `yaml:"redact_headers"`
+	RedactQueryParams []string `yaml:"redact_query_params"`
+}
+
+// LimitsConfig 请求限制配置，在路由匹配前拒绝异常请求，0或空表示不限制
+type LimitsConfig struct {
+	MaxURLLength   int    `yaml:"max_url_length"`   // 请求URI最大长度，超过返回414
+	MaxQueryParams int    `yaml:"max_query_params"` // 查询参数最大个数，超过返回414
+	MaxHeaderCount int    `yaml:"max_header_count"` // 请求头最大个数，超过返回431
+	MaxHeaderSize  string `yaml:"max_header_size"`  // 请求头总大小上限，如 "16KB"，超过返回431
+	MaxBodySize    string `yaml:"max_body_size"`    // 请求体大小上限，如 "10MB"，超过返回413
+	MaxRanges      int    `yaml:"max_ranges"`       // Range请求头中区间的最大个数，超过返回416
+}
+
+// NormalizeConfig 请求规范化的可选部分，在路由匹配前生效；路径总是规范化（合并重复斜杠、解析点段）
+type NormalizeConfig struct {
+	Enabled   bool `yaml:"enabled"`    // 域名转为小写并去掉末尾的点
+	SortQuery bool `yaml:"sort_query"` // 按参数名排序查询参数
+}
+
+// AdminConfig 管理API配置
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"` // 监听地址，默认 127.0.0.1:9090
+	Token   string `yaml:"token"`  // Bearer令牌，为空时不校验
+	// 允许通过 /middlewares/profile 对中间件执行进行CPU采样，默认关闭
+	Profiling bool `yaml:"profiling"`
+	// 保存运行时修改（注册的服务、摘除的后端）的文件，启动时恢复，为空时不保存
+	StateFile string `yaml:"state_file,omitempty"`
+}
+
+// PluginsConfig 插件配置
+type PluginsConfig struct {
+	SourceDir string `yaml:"source_dir"` // 插件源代码目录，相对路径相对于主配置文件所在目录，未配置时为工作目录下的 middleware/plugins
+	CacheDir  string `yaml:"cache_dir"`  // 插件缓存目录，相对路径相对于主配置文件所在目录，未配置时为工作目录下的 cache/plugins
+	// 只加载预编译的插件（由 toyou-proxy plugins build 生成），运行时不调用Go工具链
+	PrecompiledOnly bool `yaml:"precompiled_only"`
+}
+
+// SecretsConfig 密钥提供方配置，中间件配置中的 vault:、kms: 引用在加载配置时解析并缓存
+type SecretsConfig struct {
+	Vault *VaultConfig `yaml:"vault,omitempty"`
+	KMS   *KMSConfig   `yaml:"kms,omitempty"`
+	// 没有租期的密钥（如KV）重新读取的间隔（秒），默认300
+	RefreshInterval int `yaml:"refresh_interval"`
+}
+
+// VaultConfig HashiCorp Vault配置，使用令牌认证
+type VaultConfig struct {
+	Address   string `yaml:"address"`   // 为空时读取VAULT_ADDR环境变量
+	Token     string `yaml:"token"`     // 支持env:和file:引用，为空时读取VAULT_TOKEN环境变量
+	Namespace string `yaml:"namespace"` // Vault Enterprise命名空间
+	Timeout   int    `yaml:"timeout"`   // 请求超时（秒），默认10
+}
+
+// KMSConfig AWS KMS配置，kms: 引用为base64编码的密文，使用KMS Decrypt解密
+type KMSConfig struct {
+	Region   string `yaml:"region"`
+	Endpoint string `yaml:"endpoint"` // 为空时使用 https://kms.<region>.amazonaws.com
+	// 访问密钥支持env:和file:引用，为空时读取AWS_ACCESS_KEY_ID、AWS_SECRET_ACCESS_KEY和AWS_SESSION_TOKEN环境变量
+	AccessKey    string `yaml:"access_key"`
+	SecretKey    string `yaml:"secret_key"`
+	SessionToken string `yaml:"session_token"`
+	Timeout      int    `yaml:"timeout"` // 请求超时（秒），默认10
 }
 
 // TimeoutConfig 超时配置
@@ -82,11 +538,25 @@ type TimeoutConfig struct {
 	ReadTimeout  int `yaml:"read_timeout"`
 	WriteTimeout int `yaml:"write_timeout"`
 	DialTimeout  int `yaml:"dial_timeout"`
+	// 全局请求超时（秒），从收到请求到上游响应完成的总时长，0表示不限制
+	RequestTimeout int `yaml:"request_timeout"`
 }
 
 // SecurityConfig 安全配置
 type SecurityConfig struct {
 	DenyHiddenFiles bool `yaml:"deny_hidden_files"`
+	// 额外禁止访问的路径：以/开头的按路径前缀匹配，其余按路径段通配符匹配（如 "*.bak"）
+	DenyPaths []string `yaml:"deny_paths"`
+	// 绑定端口后切换到的用户和用户组，需以root启动
+	RunAs RunAsConfig `yaml:"run_as"`
+	// 绑定端口后切换到的根目录，需以root启动
+	Chroot string `yaml:"chroot"`
+}
+
+// RunAsConfig 运行用户配置，支持名称或数字ID
+type RunAsConfig struct {
+	User  string `yaml:"user"`
+	Group string `yaml:"group"` // 为空时使用用户的主组
 }
 
 // LoadConfig 从文件加载配置
@@ -99,12 +569,47 @@ func LoadConfig(filename string) (*Config, error) {
 
 	// 如果配置了config_dir，则加载多文件配置
 	if config.ConfigDir != "" {
-		return loadMultiFileConfig(filename, config.ConfigDir)
+		config, err = loadMultiFileConfig(filename, config.ConfigDir)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	// 插件目录的相对路径相对于主配置文件所在目录，与启动时的工作目录无关
+	config.Plugins.SourceDir = resolveConfigPath(filename, config.Plugins.SourceDir)
+	config.Plugins.CacheDir = resolveConfigPath(filename, config.Plugins.CacheDir)
+
 	return config, nil
 }
 
+// Files 返回配置加载时读取的文件：主配置文件和config_dir中的.yaml文件
+func (c *Config) Files(mainConfigFile string) []string {
+	files := []string{mainConfigFile}
+	if c.ConfigDir == "" {
+		return files
+	}
+
+	fullConfigDir := filepath.Join(filepath.Dir(mainConfigFile), c.ConfigDir)
+	entries, err := ioutil.ReadDir(fullConfigDir)
+	if err != nil {
+		return files
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".yaml") {
+			files = append(files, filepath.Join(fullConfigDir, entry.Name()))
+		}
+	}
+	return files
+}
+
+// resolveConfigPath 将相对路径解析为相对于主配置文件所在目录的路径，空路径保持不变
+func resolveConfigPath(mainConfigFile, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(mainConfigFile), path)
+}
+
 // loadSingleConfig 加载单个配置文件（不处理多文件配置）
 func loadSingleConfig(filename string) (*Config, error) {
 	data, err := ioutil.ReadFile(filename)
@@ -166,15 +671,24 @@ func loadMultiFileConfig(mainConfigFile, configDir string) (*Config, error) {
 	return mergedConfig, nil
 }
 
-// mergeConfigs 合并两个配置
+// mergeConfigs 合并两个配置，additional为nil时只复制base
 func mergeConfigs(base, additional *Config) *Config {
+	if additional == nil {
+		additional = &Config{}
+	}
+
 	merged := &Config{
 		ConfigDir:          base.ConfigDir,
+		Listeners:          append([]Listener{}, base.Listeners...),
 		HostRules:          append([]HostRule{}, base.HostRules...),
 		RouteRules:         append([]RouteRule{}, base.RouteRules...),
 		Middlewares:        append([]Middleware{}, base.Middlewares...),
 		MiddlewareServices: append([]MiddlewareService{}, base.MiddlewareServices...),
 		Advanced:           base.Advanced,
+		Admin:              base.Admin,
+		Plugins:            base.Plugins,
+		Secrets:            base.Secrets,
+		Stores:             base.Stores,
 	}
 
 	// 合并Services
@@ -188,6 +702,20 @@ func mergeConfigs(base, additional *Config) *Config {
 		merged.Services[k] = v
 	}
 
+	// 合并LoadBalancers
+	if len(base.LoadBalancers)+len(additional.LoadBalancers) > 0 {
+		merged.LoadBalancers = make(map[string]LoadBalancerConfig)
+	}
+	for k, v := range base.LoadBalancers {
+		merged.LoadBalancers[k] = v
+	}
+	for k, v := range additional.LoadBalancers {
+		merged.LoadBalancers[k] = v
+	}
+
+	// 合并Listeners
+	merged.Listeners = append(merged.Listeners, additional.Listeners...)
+
 	// 合并HostRules（包含嵌套的路由规则）
 	merged.HostRules = append(merged.HostRules, additional.HostRules...)
 
@@ -224,6 +752,714 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if redis := c.Stores.Redis; redis != nil {
+		if redis.Addr == "" {
+			return fmt.Errorf("stores.redis: addr is required")
+		}
+		if redis.Timeout < 0 {
+			return fmt.Errorf("stores.redis: timeout must not be negative")
+		}
+	}
+
+	for name, lb := range c.LoadBalancers {
+		if err := validateLoadBalancer(&lb); err != nil {
+			return fmt.Errorf("load balancer '%s': %v", name, err)
+		}
+		if service, exists := c.Services[name]; exists && service.LoadBalancer != nil {
+			return fmt.Errorf("load balancer '%s': name is already used by the load_balancer of service '%s'", name, name)
+		}
+	}
+
+	for name, service := range c.Services {
+		if err := ValidateService(service); err != nil {
+			return fmt.Errorf("service '%s': %v", name, err)
+		}
+		if ref := service.LoadBalancerRef; ref != "" {
+			if _, exists := c.LoadBalancers[ref]; !exists {
+				return fmt.Errorf("service '%s': load balancer '%s' is not defined in load_balancers", name, ref)
+			}
+		}
+	}
+
+	// 验证时间窗口表达式
+	for _, rule := range c.HostRules {
+		if err := validateHostPattern(rule.Pattern); err != nil {
+			return fmt.Errorf("host rule '%s': %v", rule.Pattern, err)
+		}
+		if rule.ProxyHeaders != nil {
+			if err := validateProxyHeaders(*rule.ProxyHeaders); err != nil {
+				return fmt.Errorf("host rule '%s': proxy_headers: %v", rule.Pattern, err)
+			}
+		}
+		if err := validateWindows(rule.ActiveWindows); err != nil {
+			return fmt.Errorf("host rule '%s': %v", rule.Pattern, err)
+		}
+		if err := c.validateDarkLaunch(rule.DarkLaunch); err != nil {
+			return fmt.Errorf("host rule '%s': %v", rule.Pattern, err)
+		}
+		if _, err := ParseSize(rule.MaxResponseSize); err != nil {
+			return fmt.Errorf("host rule '%s': max_response_size: %v", rule.Pattern, err)
+		}
+		if err := validateMethods(rule.AllowedMethods); err != nil {
+			return fmt.Errorf("host rule '%s': allowed_methods: %v", rule.Pattern, err)
+		}
+		if err := validateAuthorization(rule.Authorization); err != nil {
+			return fmt.Errorf("host rule '%s': authorization: %v", rule.Pattern, err)
+		}
+		if err := validateSessionLimit(rule.SessionLimit); err != nil {
+			return fmt.Errorf("host rule '%s': session_limit: %v", rule.Pattern, err)
+		}
+		if err := validateMinify(rule.Minify); err != nil {
+			return fmt.Errorf("host rule '%s': minify: %v", rule.Pattern, err)
+		}
+		if err := c.validateContentTargets(rule.ContentTargets); err != nil {
+			return fmt.Errorf("host rule '%s': content_targets: %v", rule.Pattern, err)
+		}
+		if err := validateResponseHeaders(rule.ResponseHeaders); err != nil {
+			return fmt.Errorf("host rule '%s': response_headers: %v", rule.Pattern, err)
+		}
+		if err := validateLabels(rule.Labels); err != nil {
+			return fmt.Errorf("host rule '%s': labels: %v", rule.Pattern, err)
+		}
+		if err := validateDump(rule.Dump); err != nil {
+			return fmt.Errorf("host rule '%s': dump: %v", rule.Pattern, err)
+		}
+		if err := validateSLO(rule.SLO); err != nil {
+			return fmt.Errorf("host rule '%s': slo: %v", rule.Pattern, err)
+		}
+		if err := validateRetry(rule.Retry); err != nil {
+			return fmt.Errorf("host rule '%s': retry: %v", rule.Pattern, err)
+		}
+		for _, routeRule := range rule.RouteRules {
+			if _, err := ParseSize(routeRule.MaxResponseSize); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': max_response_size: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := validateMethods(routeRule.AllowedMethods); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': allowed_methods: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := validateAuthorization(routeRule.Authorization); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': authorization: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := validateMinify(routeRule.Minify); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': minify: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := c.validateContentTargets(routeRule.ContentTargets); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': content_targets: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := validateResponseHeaders(routeRule.ResponseHeaders); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': response_headers: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := validateLabels(routeRule.Labels); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': labels: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := validateDump(routeRule.Dump); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': dump: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := validateRetry(routeRule.Retry); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': retry: %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := validateWindows(routeRule.ActiveWindows); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': %v", routeRule.Pattern, rule.Pattern, err)
+			}
+			if err := c.validateDarkLaunch(routeRule.DarkLaunch); err != nil {
+				return fmt.Errorf("route rule '%s' of host '%s': %v", routeRule.Pattern, rule.Pattern, err)
+			}
+		}
+	}
+	// 验证请求限制
+	if _, err := ParseSize(c.Advanced.Limits.MaxHeaderSize); err != nil {
+		return fmt.Errorf("limits: max_header_size: %v", err)
+	}
+	if _, err := ParseSize(c.Advanced.Limits.MaxBodySize); err != nil {
+		return fmt.Errorf("limits: max_body_size: %v", err)
+	}
+
+	// 验证隐私配置
+	switch c.Advanced.Privacy.IPMode {
+	case "", "hash", "truncate", "none":
+	default:
+		return fmt.Errorf("privacy: invalid ip_mode '%s', expected 'hash', 'truncate' or 'none'", c.Advanced.Privacy.IPMode)
+	}
+
+	// 验证中间件链追踪配置
+	if rate := c.Advanced.ChainTrace.SampleRate; rate < 0 || rate > 1 {
+		return fmt.Errorf("chain_trace: sample_rate must be between 0 and 1")
+	}
+
+	// 验证过载保护配置
+	if _, err := ParseSize(c.Advanced.Admission.MaxMemory); err != nil {
+		return fmt.Errorf("admission: max_memory: %v", err)
+	}
+	if a := c.Advanced.Admission; a.MaxInFlight < 0 || a.MaxGoroutines < 0 || a.RetryAfter < 0 {
+		return fmt.Errorf("admission: limits must not be negative")
+	}
+
+	// 验证代理标识响应头配置
+	if err := validateProxyHeaders(c.Advanced.ProxyHeaders); err != nil {
+		return fmt.Errorf("proxy_headers: %v", err)
+	}
+	if err := validateResponseHeaders(c.Advanced.ResponseHeaders); err != nil {
+		return fmt.Errorf("response_headers: %v", err)
+	}
+
+	// 验证可信代理
+	if err := clientip.Validate(c.Advanced.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %v", err)
+	}
+
+	// 验证优雅关闭配置
+	if c.Advanced.Shutdown.DrainTimeout < 0 || c.Advanced.Shutdown.ReportInterval < 0 {
+		return fmt.Errorf("shutdown: drain_timeout and report_interval must not be negative")
+	}
+	if c.Advanced.Reload.Interval < 0 || c.Advanced.Reload.StreamGracePeriod < 0 {
+		return fmt.Errorf("reload: interval and stream_grace_period must not be negative")
+	}
+	// chroot之后按原路径无法再读取配置文件，不能监视变化
+	if c.Advanced.Reload.Watch && c.Advanced.Security.Chroot != "" {
+		return fmt.Errorf("reload: watch cannot be used with security.chroot, the configuration files are not reachable after changing the root directory")
+	}
+
+	// 验证内部路径规则
+	for i, rule := range c.Advanced.InternalPaths {
+		if err := validateInternalPathRule(rule, c.Advanced.TrustedProxies); err != nil {
+			return fmt.Errorf("internal_paths[%d]: %v", i, err)
+		}
+	}
+
+	// 验证监听器声明
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+
+	// 验证HTTPS监听配置
+	tlsCfg := c.EffectiveTLS()
+	if err := c.validateHostCertificates(tlsCfg); err != nil {
+		return err
+	}
+	if len(tlsCfg.Ports) > 0 {
+		if tlsCfg.ReloadInterval < 0 {
+			return fmt.Errorf("tls: reload_interval must not be negative")
+		}
+		for port := range tlsCfg.PortPolicies {
+			if !tlsCfg.HasPort(port) {
+				return fmt.Errorf("tls: port_policies: port %d is not an HTTPS port", port)
+			}
+		}
+		for _, port := range tlsCfg.Ports {
+			policy := tlsCfg.Policy.Merge(tlsCfg.PortPolicies[port])
+			if err := validateTLSPolicy(policy); err != nil {
+				return fmt.Errorf("tls: policy for port %d: %v", port, err)
+			}
+			if policy.ECH && tlsCfg.ECH.KeyFile == "" {
+				return fmt.Errorf("tls: policy for port %d: ech requires tls.ech.key_file", port)
+			}
+		}
+		if tlsCfg.SessionTickets.RotationInterval < 0 || tlsCfg.SessionTickets.Keep < 0 {
+			return fmt.Errorf("tls: session_tickets rotation_interval and keep must not be negative")
+		}
+	}
+
+	// 验证调试日志配置
+	if rate := c.Advanced.DebugLog.SampleRate; rate < 0 || rate > 1 {
+		return fmt.Errorf("debug_log: sample_rate must be between 0 and 1")
+	}
+
+	// 验证禁止访问路径的通配符模式
+	for _, pattern := range c.Advanced.Security.DenyPaths {
+		if strings.HasPrefix(pattern, "/") {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("security: invalid deny_paths pattern '%s': %v", pattern, err)
+		}
+	}
+
+	// 验证密钥提供方配置
+	if err := validateSecrets(c.Secrets); err != nil {
+		return fmt.Errorf("secrets: %v", err)
+	}
+
+	for _, mw := range c.Middlewares {
+		if err := validateWindows(mw.ActiveWindows); err != nil {
+			return fmt.Errorf("middleware '%s': %v", mw.Name, err)
+		}
+	}
+	for _, service := range c.MiddlewareServices {
+		if err := validateWindows(service.ActiveWindows); err != nil {
+			return fmt.Errorf("middleware service '%s': %v", service.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateSecrets 验证密钥提供方配置
+func validateSecrets(cfg SecretsConfig) error {
+	if cfg.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval must not be negative")
+	}
+	if vault := cfg.Vault; vault != nil {
+		if vault.Address != "" {
+			if u, err := url.Parse(vault.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("vault: invalid address '%s'", vault.Address)
+			}
+		}
+		if vault.Timeout < 0 {
+			return fmt.Errorf("vault: timeout must not be negative")
+		}
+	}
+	if kms := cfg.KMS; kms != nil {
+		if kms.Region == "" {
+			return fmt.Errorf("kms: region is required")
+		}
+		if kms.Endpoint != "" {
+			if u, err := url.Parse(kms.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("kms: invalid endpoint '%s'", kms.Endpoint)
+			}
+		}
+		if kms.Timeout < 0 {
+			return fmt.Errorf("kms: timeout must not be negative")
+		}
+	}
+	return nil
+}
+
+// validateProxyHeaders 验证代理标识响应头配置
+func validateProxyHeaders(cfg ProxyHeadersConfig) error {
+	switch cfg.Mode {
+	case "", ProxyHeadersAlways, ProxyHeadersInternal, ProxyHeadersNever:
+	default:
+		return fmt.Errorf("invalid mode '%s', expected always, internal or never", cfg.Mode)
+	}
+	for _, network := range cfg.InternalNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return fmt.Errorf("invalid internal network '%s': %v", network, err)
+		}
+	}
+	return nil
+}
+
+// validateInternalPathRule 验证内部路径规则
+func validateInternalPathRule(rule InternalPathRule, trustedProxies []string) error {
+	if len(rule.Paths) == 0 {
+		return fmt.Errorf("paths is required")
+	}
+	for _, pattern := range rule.Paths {
+		if _, err := matcher.CompilePathPattern(pattern); err != nil {
+			return err
+		}
+	}
+	// 代理前的负载均衡或CDN通常位于私有网段，默认信任私有网段会让所有外部请求都跳过中间件
+	if len(rule.Networks) == 0 {
+		return fmt.Errorf("networks is required, list the networks of the internal callers")
+	}
+	for _, network := range rule.Networks {
+		_, parsed, err := net.ParseCIDR(network)
+		if err != nil {
+			return fmt.Errorf("invalid network '%s': %v", network, err)
+		}
+		// 经过可信代理转发的请求来源地址是代理的地址，与内部调用方无法区分
+		if proxy := clientip.Overlap(trustedProxies, parsed); proxy != "" {
+			return fmt.Errorf("network '%s' overlaps trusted proxy '%s', requests forwarded by the proxy would bypass the middlewares", network, proxy)
+		}
+	}
+	return nil
+}
+
+// validateHostPattern 验证域名模式，通配符*必须单独占一级标签
+func validateHostPattern(pattern string) error {
+	for _, label := range strings.Split(pattern, ".") {
+		if label == "" {
+			return fmt.Errorf("pattern must not contain empty labels")
+		}
+		if label != "*" && strings.Contains(label, "*") {
+			return fmt.Errorf("wildcard '*' must be a whole label, e.g. '*.example.com' or 'api.*.example.com'")
+		}
+	}
+	return nil
+}
+
+// validateMethods 验证请求方法名，方法名必须是合法的HTTP token
+func validateMethods(methods []string) error {
+	for _, method := range methods {
+		method = strings.TrimSpace(method)
+		if method == "" {
+			return fmt.Errorf("empty method")
+		}
+		if !isToken(method) {
+			return fmt.Errorf("invalid method '%s'", method)
+		}
+	}
+	return nil
+}
+
+// validateResponseHeaders 验证静态响应头：名称必须是合法的token，值不能包含换行
+func validateResponseHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || !isToken(name) {
+			return fmt.Errorf("invalid header name '%s'", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("header '%s' value must not contain line breaks", name)
+		}
+	}
+	return nil
+}
+
+// labelNamePattern 标签名格式，与Prometheus标签名一致
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// 指标中已使用的标签名，规则标签不能与之重名
+var reservedLabelNames = map[string]bool{"route": true, "service": true, "middleware": true, "result": true}
+
+// validateLabels 验证规则标签名
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name '%s'", name)
+		}
+		if reservedLabelNames[name] {
+			return fmt.Errorf("label name '%s' is reserved", name)
+		}
+	}
+	return nil
+}
+
+// isToken 判断字符串是否由HTTP token字符组成（RFC 7230）
+func isToken(s string) bool {
+	for _, c := range s {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune("()<>@,;:\\\"/[]?={}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateAuthorization 验证授权要求
+func validateAuthorization(authz *AuthorizationConfig) error {
+	if authz == nil {
+		return nil
+	}
+	switch authz.Match {
+	case "", "all", "any":
+	default:
+		return fmt.Errorf("invalid match '%s', expected 'all' or 'any'", authz.Match)
+	}
+	if len(authz.Scopes) == 0 && len(authz.Roles) == 0 {
+		return fmt.Errorf("at least one scope or role is required")
+	}
+	return nil
+}
+
+// ValidateService 验证服务类型相关的配置
+func ValidateService(service Service) error {
+	if w := service.Warmup; w != nil {
+		if w.Connections < 0 || w.Timeout < 0 {
+			return fmt.Errorf("warmup: connections and timeout must not be negative")
+		}
+		if w.Path != "" && !strings.HasPrefix(w.Path, "/") {
+			return fmt.Errorf("warmup: path must start with '/'")
+		}
+	}
+
+	switch service.Type {
+	case "", ServiceTypeHTTP:
+		if service.LoadBalancer != nil && service.LoadBalancerRef != "" {
+			return fmt.Errorf("load_balancer and load_balancer_ref cannot both be set")
+		}
+		if service.LoadBalancer != nil {
+			if err := validateLoadBalancer(service.LoadBalancer); err != nil {
+				return fmt.Errorf("load_balancer: %v", err)
+			}
+		}
+		return nil
+	case ServiceTypeS3:
+		if service.S3 == nil || service.S3.Bucket == "" {
+			return fmt.Errorf("s3.bucket is required for s3 services")
+		}
+		if service.S3.AccessKey == "" || service.S3.SecretKey == "" {
+			return fmt.Errorf("s3.access_key and s3.secret_key are required for s3 services")
+		}
+		if service.LoadBalancer != nil || service.LoadBalancerRef != "" {
+			return fmt.Errorf("load_balancer is not supported for s3 services")
+		}
+		if service.Warmup != nil && service.Warmup.Connections > 0 {
+			return fmt.Errorf("warmup is not supported for s3 services")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid type '%s', expected 'http' or 's3'", service.Type)
+	}
+}
+
+// validateLoadBalancer 验证负载均衡配置，策略为空时使用轮询
+func validateLoadBalancer(lb *LoadBalancerConfig) error {
+	switch lb.Strategy {
+	case "", RoundRobin, WeightedRoundRobin, IPHash, LeastConnections, ResponseTime, Random, WeightedRandom, LeastOutstanding:
+	default:
+		return fmt.Errorf("unknown strategy '%s'", lb.Strategy)
+	}
+	if lb.Subset != nil {
+		if lb.Strategy != LeastOutstanding {
+			return fmt.Errorf("subset is only supported by the %s strategy", LeastOutstanding)
+		}
+		if lb.Subset.Size < 0 {
+			return fmt.Errorf("subset: size must not be negative")
+		}
+	}
+	if len(lb.Backends) == 0 && lb.Discovery == nil {
+		return fmt.Errorf("at least one backend or discovery is required")
+	}
+	if err := validateDiscovery(lb.Discovery); err != nil {
+		return fmt.Errorf("discovery: %v", err)
+	}
+	if lb.DNSRefresh < 0 {
+		return fmt.Errorf("dns_refresh must not be negative")
+	}
+	if err := validateOutlierDetection(lb.OutlierDetection); err != nil {
+		return fmt.Errorf("outlier_detection: %v", err)
+	}
+	if err := validateLoadHint(lb.HealthCheck); err != nil {
+		return fmt.Errorf("health_check: %v", err)
+	}
+	for i, backend := range lb.Backends {
+		if err := validateLoadHint(backend.HealthCheck); err != nil {
+			return fmt.Errorf("backend %d: health_check: %v", i+1, err)
+		}
+		// dns+http://、dns+https:// 按域名解析出的每个IP地址创建一个后端
+		u, err := url.Parse(strings.TrimPrefix(backend.URL, "dns+"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("backend %d: invalid url '%s', expected e.g. http://backend:8080 or dns+http://backend:8080", i+1, backend.URL)
+		}
+		if backend.Weight < 0 {
+			return fmt.Errorf("backend %d: weight must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// validateDiscovery 验证服务注册中心配置
+func validateDiscovery(d *DiscoveryConfig) error {
+	if d == nil {
+		return nil
+	}
+	if (d.Consul == nil) == (d.Etcd == nil) {
+		return fmt.Errorf("exactly one of consul and etcd is required")
+	}
+
+	address, scheme := "", ""
+	if c := d.Consul; c != nil {
+		if c.Service == "" {
+			return fmt.Errorf("consul: service is required")
+		}
+		address, scheme = c.Address, c.Scheme
+	}
+	if e := d.Etcd; e != nil {
+		if e.Prefix == "" {
+			return fmt.Errorf("etcd: prefix is required")
+		}
+		if e.Password != "" && e.Username == "" {
+			return fmt.Errorf("etcd: username is required with password")
+		}
+		address, scheme = e.Endpoint, e.Scheme
+	}
+
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid address '%s', expected an http:// or https:// URL", address)
+		}
+	}
+	if scheme != "" && scheme != "http" && scheme != "https" {
+		return fmt.Errorf("invalid scheme '%s', expected 'http' or 'https'", scheme)
+	}
+	return nil
+}
+
+// validateOutlierDetection 验证被动健康检查配置
+func validateOutlierDetection(od *OutlierDetectionConfig) error {
+	if od == nil {
+		return nil
+	}
+	if od.ConsecutiveErrors < 0 || od.MinRequests < 0 {
+		return fmt.Errorf("consecutive_errors and min_requests must not be negative")
+	}
+	if od.ErrorRate < 0 || od.ErrorRate > 1 {
+		return fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if od.Window < 0 || od.EjectionTime < 0 || od.MaxEjectionTime < 0 || od.RampTime < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+		return fmt.Errorf("max_ejection_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// validateLoadHint 验证健康检查的负载提示配置
+func validateLoadHint(hc *HealthCheckConfig) error {
+	if hc == nil || hc.LoadHint == nil {
+		return nil
+	}
+	if hc.LoadHint.Header == "" && hc.LoadHint.JSONField == "" {
+		return fmt.Errorf("load_hint: header or json_field is required")
+	}
+	return nil
+}
+
+// validateSessionLimit 验证并发会话限制
+func validateSessionLimit(limit *SessionLimitConfig) error {
+	if limit == nil {
+		return nil
+	}
+	switch limit.Action {
+	case "", "reject", "evict_oldest":
+	default:
+		return fmt.Errorf("invalid action '%s', expected 'reject' or 'evict_oldest'", limit.Action)
+	}
+	if limit.MaxSessions < 0 || limit.MaxDevices < 0 || limit.IdleTimeout < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if limit.MaxSessions == 0 && limit.MaxDevices == 0 {
+		return fmt.Errorf("max_sessions or max_devices is required")
+	}
+	return nil
+}
+
+// maxRetryAttempts 重试策略允许的最多尝试次数
+const maxRetryAttempts = 10
+
+// validateRetry 验证重试策略
+func validateRetry(retry *RetryConfig) error {
+	if retry == nil {
+		return nil
+	}
+	if retry.Attempts < 1 || retry.Attempts > maxRetryAttempts {
+		return fmt.Errorf("attempts must be between 1 and %d", maxRetryAttempts)
+	}
+	for _, status := range retry.On {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid status code %d", status)
+		}
+	}
+	if err := validateMethods(retry.Methods); err != nil {
+		return fmt.Errorf("methods: %v", err)
+	}
+	if retry.Backoff < 0 || retry.MaxBackoff < 0 || retry.PerTryTimeout < 0 {
+		return fmt.Errorf("backoff, max_backoff and per_try_timeout must not be negative")
+	}
+	return nil
+}
+
+// validateMinify 验证响应压缩配置
+func validateMinify(m *MinifyConfig) error {
+	if m == nil {
+		return nil
+	}
+	for _, t := range m.Types {
+		switch t {
+		case "html", "css", "js":
+		default:
+			return fmt.Errorf("invalid type '%s', expected html, css or js", t)
+		}
+	}
+	if _, err := ParseSize(m.MinSize); err != nil {
+		return fmt.Errorf("min_size: %v", err)
+	}
+	return nil
+}
+
+// maxSLOWindow SLO统计窗口的上限，窗口内的计数保存在内存中
+const maxSLOWindow = 7 * 24 * 3600
+
+// validateSLO 验证延迟SLO配置
+func validateSLO(slo *SLOConfig) error {
+	if slo == nil {
+		return nil
+	}
+	if slo.Objective <= 0 || slo.Objective >= 1 {
+		return fmt.Errorf("objective must be between 0 and 1")
+	}
+	if slo.Latency <= 0 {
+		return fmt.Errorf("latency must be positive")
+	}
+	if slo.Window < 0 || slo.Window > maxSLOWindow {
+		return fmt.Errorf("window must be between 0 and %d seconds", maxSLOWindow)
+	}
+	for i, alert := range slo.Alerts {
+		if alert.Window <= 0 || alert.Window > maxSLOWindow {
+			return fmt.Errorf("alert %d: window must be between 1 and %d seconds", i+1, maxSLOWindow)
+		}
+		if alert.BurnRate <= 0 {
+			return fmt.Errorf("alert %d: burn_rate must be positive", i+1)
+		}
+		if alert.MinRequests < 0 {
+			return fmt.Errorf("alert %d: min_requests must not be negative", i+1)
+		}
+	}
+	for _, webhook := range slo.Webhooks {
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url '%s'", webhook)
+		}
+	}
+	return nil
+}
+
+// validateDump 验证调试转储配置
+func validateDump(d *DumpConfig) error {
+	if d == nil {
+		return nil
+	}
+	if d.SampleRate < 0 || d.SampleRate > 1 {
+		return fmt.Errorf("sample_rate must be between 0 and 1")
+	}
+	if d.SampleRate == 0 && d.Token == "" {
+		return fmt.Errorf("sample_rate or token is required")
+	}
+	if _, err := ParseSize(d.MaxBodySize); err != nil {
+		return fmt.Errorf("max_body_size: %v", err)
+	}
+	if d.BufferSize < 0 {
+		return fmt.Errorf("buffer_size must not be negative")
+	}
+	return nil
+}
+
+// validateDarkLaunch 验证暗发布配置
+func (c *Config) validateDarkLaunch(dl *DarkLaunchConfig) error {
+	if dl == nil {
+		return nil
+	}
+	if dl.Header == "" && dl.Cookie == "" {
+		return fmt.Errorf("dark_launch requires a header or cookie name")
+	}
+	if dl.Secret == "" {
+		return fmt.Errorf("dark_launch secret cannot be empty")
+	}
+	if _, exists := c.Services[dl.Target]; !exists {
+		return fmt.Errorf("dark_launch target service '%s' is not defined", dl.Target)
+	}
+	return nil
+}
+
+// validateContentTargets 验证内容协商目标，媒体类型必须为 type/subtype 形式且不能重复
+func (c *Config) validateContentTargets(targets []ContentTarget) error {
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		mediaType := strings.ToLower(strings.TrimSpace(target.Type))
+		parts := strings.Split(mediaType, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || parts[0] == "*" || parts[1] == "*" {
+			return fmt.Errorf("invalid media type '%s', expected type/subtype", target.Type)
+		}
+		if seen[mediaType] {
+			return fmt.Errorf("duplicate media type '%s'", target.Type)
+		}
+		seen[mediaType] = true
+		if _, exists := c.Services[target.Target]; !exists {
+			return fmt.Errorf("target service '%s' for '%s' is not defined", target.Target, target.Type)
+		}
+	}
 	return nil
 }
 
@@ -245,6 +1481,8 @@ const (
 	Random LoadBalancerStrategy = "random"
 	// WeightedRandom 加权随机策略
 	WeightedRandom LoadBalancerStrategy = "weighted_random"
+	// LeastOutstanding 子集内最少未完成请求策略
+	LeastOutstanding LoadBalancerStrategy = "least_outstanding"
 )
 
 // LoadBalancerBackend 后端服务器配置
@@ -256,23 +1494,84 @@ type LoadBalancerBackend struct {
 
 // HealthCheckConfig 健康检查配置
 type HealthCheckConfig struct {
-	Enabled  bool          `yaml:"enabled"`
-	Interval time.Duration `yaml:"interval"`
-	Timeout  time.Duration `yaml:"timeout"`
-	Path     string        `yaml:"path"`
+	Enabled  bool            `yaml:"enabled"`
+	Interval time.Duration   `yaml:"interval"`
+	Timeout  time.Duration   `yaml:"timeout"`
+	Path     string          `yaml:"path"`
+	LoadHint *LoadHintConfig `yaml:"load_hint,omitempty"` // 从健康检查响应中读取负载提示，可选
+}
+
+// LoadHintConfig 健康检查响应中的负载提示，后端用它报告当前能够接收的流量比例（0-100），
+// 负载均衡器按比例调整后端的有效权重，后端在预热或GC期间可以减少流量而不被标记为不健康
+type LoadHintConfig struct {
+	Header    string `yaml:"header,omitempty"`     // 响应头名称，如 X-Load-Hint
+	JSONField string `yaml:"json_field,omitempty"` // JSON响应体中的字段，嵌套字段用 . 分隔，如 status.capacity
 }
 
 // SessionAffinityConfig 会话保持配置
 type SessionAffinityConfig struct {
 	Enabled    bool          `yaml:"enabled"`
-	Timeout    time.Duration `yaml:"timeout"`
-	CookieName string        `yaml:"cookie_name"`
+	Timeout    time.Duration `yaml:"timeout"`          // 会话空闲超时，默认30分钟
+	CookieName string        `yaml:"cookie_name"`      // 默认LB_SESSION
+	Secret     string        `yaml:"secret,omitempty"` // cookie签名密钥，多个代理实例需要相同；为空时每次启动随机生成
 }
 
 // LoadBalancerConfig 负载均衡器配置
 type LoadBalancerConfig struct {
-	Strategy        LoadBalancerStrategy   `yaml:"strategy"`         // 负载均衡策略
-	Backends        []LoadBalancerBackend  `yaml:"backends"`         // 后端服务器列表
-	HealthCheck     *HealthCheckConfig     `yaml:"health_check"`     // 全局健康检查配置
-	SessionAffinity *SessionAffinityConfig `yaml:"session_affinity"` // 会话保持配置
+	Strategy        LoadBalancerStrategy   `yaml:"strategy"`             // 负载均衡策略
+	Backends        []LoadBalancerBackend  `yaml:"backends"`             // 后端服务器列表
+	HealthCheck     *HealthCheckConfig     `yaml:"health_check"`         // 全局健康检查配置
+	SessionAffinity *SessionAffinityConfig `yaml:"session_affinity"`     // 会话保持配置
+	Subset          *SubsetConfig          `yaml:"subset,omitempty"`     // 确定性子集，用于least_outstanding策略
+	DrainFile       string                 `yaml:"drain_file,omitempty"` // 部署标记文件，其中列出的后端不再接收新请求
+	// DNSRefresh 重新解析 dns+http://、dns+https:// 后端的最长间隔，记录TTL更短时按TTL，默认30s
+	DNSRefresh time.Duration `yaml:"dns_refresh,omitempty"`
+	// OutlierDetection 被动健康检查：按实际请求的结果摘除错误率过高的后端，可选
+	OutlierDetection *OutlierDetectionConfig `yaml:"outlier_detection,omitempty"`
+	// Discovery 从Consul或etcd同步后端，配置后backends可以为空，可选
+	Discovery *DiscoveryConfig `yaml:"discovery,omitempty"`
+}
+
+// DiscoveryConfig 从服务注册中心同步后端，consul和etcd只能配置一个
+type DiscoveryConfig struct {
+	Consul *ConsulDiscoveryConfig `yaml:"consul,omitempty"`
+	Etcd   *EtcdDiscoveryConfig   `yaml:"etcd,omitempty"`
+}
+
+// ConsulDiscoveryConfig 从Consul同步服务的健康实例，通过阻塞查询监视变化
+type ConsulDiscoveryConfig struct {
+	Address    string `yaml:"address,omitempty"`    // Consul HTTP API地址，默认 http://127.0.0.1:8500
+	Service    string `yaml:"service"`              // 服务名称
+	Tag        string `yaml:"tag,omitempty"`        // 只使用带有该标签的实例，可选
+	Datacenter string `yaml:"datacenter,omitempty"` // 数据中心，默认为Consul agent所在的数据中心
+	Token      string `yaml:"token,omitempty"`      // ACL令牌，可选
+	Scheme     string `yaml:"scheme,omitempty"`     // 访问实例使用的协议：http（默认）或 https
+}
+
+// EtcdDiscoveryConfig 从etcd同步前缀下登记的后端，通过v3 API的watch监视变化
+type EtcdDiscoveryConfig struct {
+	Endpoint string `yaml:"endpoint,omitempty"` // etcd HTTP API地址，默认 http://127.0.0.1:2379
+	Prefix   string `yaml:"prefix"`             // 后端所在的键前缀，如 /services/api/
+	Username string `yaml:"username,omitempty"` // 开启认证时的用户名，可选
+	Password string `yaml:"password,omitempty"`
+	Scheme   string `yaml:"scheme,omitempty"` // 值只有地址（host:port）时使用的协议：http（默认）或 https
+}
+
+// OutlierDetectionConfig 被动健康检查配置，上游返回5xx或连接失败计为错误；未配置的字段使用默认值
+type OutlierDetectionConfig struct {
+	ConsecutiveErrors  int           `yaml:"consecutive_errors"`   // 连续错误达到该数量时立即摘除，默认5
+	ErrorRate          float64       `yaml:"error_rate"`           // 统计窗口内错误率达到该值（0-1）时摘除，默认0.5
+	MinRequests        int           `yaml:"min_requests"`         // 统计窗口内请求数达到该值才按错误率判断，默认10
+	Window             time.Duration `yaml:"window"`               // 错误率统计窗口，默认10s
+	EjectionTime       time.Duration `yaml:"ejection_time"`        // 摘除时长，每次再被摘除时按次数加倍，默认30s
+	MaxEjectionTime    time.Duration `yaml:"max_ejection_time"`    // 摘除时长上限，默认5m
+	MaxEjectionPercent int           `yaml:"max_ejection_percent"` // 同时被摘除的后端比例上限（%），默认50
+	RampTime           time.Duration `yaml:"ramp_time"`            // 摘除结束后逐步恢复全部流量的时长，默认30s
+}
+
+// SubsetConfig 确定性子集配置，每个代理实例只在按实例标识选出的固定数量的后端之间分配请求，
+// 后端数量很多时每个请求不需要扫描全部后端，后端的连接数也不会随代理实例数增长
+type SubsetConfig struct {
+	Size int    `yaml:"size"`          // 子集大小，0表示不使用子集
+	Key  string `yaml:"key,omitempty"` // 实例标识，不同实例应使用不同的值，默认为主机名
 }
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fuzzSeeds 配置解析和合并的种子
+var fuzzSeeds = []string{
+	"",
+	"host_rules:\n  - pattern: example.com\n    target: web\nservices:\n  web:\n    url: http://127.0.0.1:8080\n",
+	"host_rules:\n  - pattern: '*.example.com'\n    target: web\n    route_rules:\n      - pattern: /api/*\n        target: api\n        middlewares: [auth]\n",
+	"middlewares:\n  - name: replace\n    config:\n      rules:\n        - pattern: a\n          replacement: b\n          max_replacements: 2\n          when: {status: [200], content_types: text/html}\n",
+	"services:\n  web:\n    load_balancer:\n      strategy: round_robin\n      backends:\n        - url: http://127.0.0.1:1\n          weight: 2\n",
+	"listeners:\n  - port: 8080\n  - port: 8443\n    protocol: https\n",
+	"config_dir: conf.d\n",
+	"advanced:\n  timeout:\n    request_timeout: -1\n",
+	"host_rules: {}\n",
+	"services: [1, 2]\n",
+}
+
+func FuzzLoadConfig(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dir := t.TempDir()
+		filename := filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := LoadConfig(filename)
+		if err != nil {
+			return
+		}
+		// 验证配置不能panic，无论配置是否有效
+		_ = cfg.Validate()
+		cfg.Files(filename)
+		cfg.EffectiveListeners()
+		if _, err := cfg.Redacted(); err != nil {
+			t.Fatalf("Redacted: %v", err)
+		}
+	})
+}
+
+func FuzzMergeConfigs(f *testing.F) {
+	for _, base := range fuzzSeeds {
+		for _, additional := range fuzzSeeds[:3] {
+			f.Add([]byte(base), []byte(additional))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, baseData, additionalData []byte) {
+		var base, additional Config
+		if yaml.Unmarshal(baseData, &base) != nil || yaml.Unmarshal(additionalData, &additional) != nil {
+			return
+		}
+		baseHostRules := len(base.HostRules)
+
+		merged := mergeConfigs(&base, &additional)
+		if len(merged.HostRules) != baseHostRules+len(additional.HostRules) {
+			t.Fatalf("merged %d host rules, want %d", len(merged.HostRules), baseHostRules+len(additional.HostRules))
+		}
+		if len(merged.Middlewares) != len(base.Middlewares)+len(additional.Middlewares) {
+			t.Fatalf("merged %d middlewares, want %d", len(merged.Middlewares), len(base.Middlewares)+len(additional.Middlewares))
+		}
+		// 合并不修改原配置
+		if len(base.HostRules) != baseHostRules {
+			t.Fatalf("mergeConfigs modified the base config")
+		}
+		// 后加载的服务覆盖同名服务
+		for name, service := range additional.Services {
+			if merged.Services[name].URL != service.URL {
+				t.Fatalf("service %s was not overridden", name)
+			}
+		}
+		for name := range base.Services {
+			if _, exists := merged.Services[name]; !exists {
+				t.Fatalf("service %s was lost", name)
+			}
+		}
+
+		// additional为nil时复制base
+		if copied := mergeConfigs(&base, nil); len(copied.HostRules) != baseHostRules {
+			t.Fatalf("mergeConfigs(base, nil) has %d host rules, want %d", len(copied.HostRules), baseHostRules)
+		}
+		_ = merged.Validate()
+	})
+}
+
+func TestValidateRejectsReloadWatchWithChroot(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	data := fuzzSeeds[1] + "advanced:\n  reload:\n    watch: true\n  security:\n    chroot: /var/lib/toyou-proxy\n"
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("reload.watch with security.chroot passed validation")
+	}
+
+	cfg.Advanced.Reload.Watch = false
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("chroot without reload.watch: %v", err)
+	}
+}
+
+func TestValidateInternalPathNetworks(t *testing.T) {
+	rule := InternalPathRule{Paths: []string{"/debug/*"}}
+	if err := validateInternalPathRule(rule, nil); err == nil {
+		t.Error("internal path rule without networks passed validation")
+	}
+
+	// 来自可信代理的请求来源地址是代理的地址，不能视为内部调用方
+	rule.Networks = []string{"10.0.0.0/8"}
+	for _, proxies := range [][]string{{"10.1.2.3"}, {"10.1.0.0/16"}, {"0.0.0.0/0"}} {
+		if err := validateInternalPathRule(rule, proxies); err == nil {
+			t.Errorf("networks overlapping trusted_proxies %v passed validation", proxies)
+		}
+	}
+	if err := validateInternalPathRule(rule, []string{"192.168.0.10", "fd00::/8"}); err != nil {
+		t.Errorf("networks disjoint from trusted_proxies: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// 监听器协议
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+)
+
+// defaultListenerPort 旧配置中未指定端口的域名规则使用的监听端口
+const defaultListenerPort = 80
+
+// Listener 监听器声明
+// 域名规则通过host_rules显式挂载到监听器，同一个域名可以挂载到多个监听器
+type Listener struct {
+	Name     string     `yaml:"name,omitempty"`     // 名称，用于日志
+	Address  string     `yaml:"address,omitempty"`  // 监听地址，如 127.0.0.1、::，默认所有地址
+	Port     int        `yaml:"port"`               // 监听端口，每个端口只能声明一个监听器
+	Protocol string     `yaml:"protocol,omitempty"` // http（默认）或 https
+	TLS      *TLSPolicy `yaml:"tls,omitempty"`      // 覆盖advanced.tls.policy的TLS策略，仅https监听器可用
+	// 挂载的域名规则（pattern），为空时挂载所有未指定端口或端口与之一致的域名规则
+	HostRules []string `yaml:"host_rules,omitempty"`
+}
+
+// String 返回监听器的描述，如 public-https (:443, https)
+func (l Listener) String() string {
+	desc := fmt.Sprintf("%s, %s", net.JoinHostPort(l.Address, fmt.Sprint(l.Port)), l.protocol())
+	if l.Name == "" {
+		return desc
+	}
+	return fmt.Sprintf("%s (%s)", l.Name, desc)
+}
+
+// IsHTTPS 判断监听器是否提供HTTPS
+func (l Listener) IsHTTPS() bool {
+	return l.protocol() == ProtocolHTTPS
+}
+
+func (l Listener) protocol() string {
+	if l.Protocol == "" {
+		return ProtocolHTTP
+	}
+	return l.Protocol
+}
+
+// Attaches 判断域名规则是否挂载到该监听器
+func (l Listener) Attaches(rule HostRule) bool {
+	if len(l.HostRules) > 0 {
+		for _, pattern := range l.HostRules {
+			if pattern == rule.Pattern {
+				return true
+			}
+		}
+		return false
+	}
+	return rule.Port == 0 || rule.Port == l.Port
+}
+
+// EffectiveListeners 返回实际使用的监听器，按端口排序
+// 配置了listeners时直接使用；否则按旧方式从域名规则的端口推导（未指定端口时为80），
+// advanced.tls.ports中的端口提供HTTPS
+func (c *Config) EffectiveListeners() []Listener {
+	if len(c.Listeners) > 0 {
+		listeners := append([]Listener(nil), c.Listeners...)
+		sort.SliceStable(listeners, func(i, j int) bool { return listeners[i].Port < listeners[j].Port })
+		return listeners
+	}
+
+	tlsCfg := c.EffectiveTLS()
+	seen := make(map[int]bool)
+	var listeners []Listener
+	for _, rule := range c.HostRules {
+		port := rule.Port
+		if port == 0 {
+			port = defaultListenerPort
+		}
+		if seen[port] {
+			continue
+		}
+		seen[port] = true
+
+		listener := Listener{Port: port, Protocol: ProtocolHTTP}
+		if tlsCfg.HasPort(port) {
+			listener.Protocol = ProtocolHTTPS
+		}
+		listeners = append(listeners, listener)
+	}
+
+	// 没有配置任何域名规则时使用默认端口
+	if len(listeners) == 0 {
+		listeners = append(listeners, Listener{Port: defaultListenerPort, Protocol: ProtocolHTTP})
+	}
+
+	sort.Slice(listeners, func(i, j int) bool { return listeners[i].Port < listeners[j].Port })
+	return listeners
+}
+
+// EffectiveTLS 返回实际使用的HTTPS监听配置
+// 配置了listeners时，https监听器的端口作为ports，监听器的tls策略作为port_policies；
+// 否则配置了tls的域名规则的端口也提供HTTPS。挂载到各HTTPS端口的域名证书写入HostCertificates
+func (c *Config) EffectiveTLS() TLSConfig {
+	tlsCfg := c.Advanced.TLS
+	listeners := make(map[int]Listener)
+
+	if len(c.Listeners) == 0 {
+		tlsCfg.Ports = append([]int(nil), tlsCfg.Ports...)
+		for _, rule := range c.HostRules {
+			if rule.TLS != nil && rule.Port > 0 && !tlsCfg.HasPort(rule.Port) {
+				tlsCfg.Ports = append(tlsCfg.Ports, rule.Port)
+			}
+		}
+		for _, port := range tlsCfg.Ports {
+			listeners[port] = Listener{Port: port, Protocol: ProtocolHTTPS}
+		}
+	} else {
+		tlsCfg.Ports = nil
+		tlsCfg.PortPolicies = make(map[int]TLSPolicy)
+		for _, listener := range c.Listeners {
+			if !listener.IsHTTPS() {
+				continue
+			}
+			tlsCfg.Ports = append(tlsCfg.Ports, listener.Port)
+			listeners[listener.Port] = listener
+			if listener.TLS != nil {
+				tlsCfg.PortPolicies[listener.Port] = *listener.TLS
+			}
+		}
+	}
+
+	tlsCfg.HostCertificates = make(map[int][]HostCertificate)
+	for _, port := range tlsCfg.Ports {
+		for _, rule := range c.HostRules {
+			if rule.TLS != nil && listeners[port].Attaches(rule) {
+				tlsCfg.HostCertificates[port] = append(tlsCfg.HostCertificates[port], HostCertificate{
+					Pattern:  rule.Pattern,
+					CertFile: rule.TLS.CertFile,
+					KeyFile:  rule.TLS.KeyFile,
+					ACME:     rule.TLS.ACME,
+				})
+			}
+		}
+	}
+	return tlsCfg
+}
+
+// validateHostCertificates 验证域名证书，并检查每个HTTPS端口上的域名都有可用的证书
+func (c *Config) validateHostCertificates(tlsCfg TLSConfig) error {
+	for _, rule := range c.HostRules {
+		if rule.TLS == nil {
+			continue
+		}
+		if rule.TLS.ACME {
+			if err := validateACMEHost(rule, tlsCfg.ACME); err != nil {
+				return fmt.Errorf("host rule '%s': tls: %v", rule.Pattern, err)
+			}
+		} else if rule.TLS.CertFile == "" || rule.TLS.KeyFile == "" {
+			return fmt.Errorf("host rule '%s': tls: cert_file and key_file are required", rule.Pattern)
+		}
+		if len(c.Listeners) == 0 && rule.Port == 0 {
+			return fmt.Errorf("host rule '%s': tls requires port", rule.Pattern)
+		}
+		attached := false
+		for _, certs := range tlsCfg.HostCertificates {
+			for _, cert := range certs {
+				attached = attached || cert.Pattern == rule.Pattern
+			}
+		}
+		if !attached {
+			return fmt.Errorf("host rule '%s': tls is set but the rule is not attached to an https listener", rule.Pattern)
+		}
+	}
+
+	if len(tlsCfg.Ports) == 0 {
+		return nil
+	}
+	if tlsCfg.CertFile != "" || tlsCfg.KeyFile != "" {
+		if tlsCfg.CertFile == "" || tlsCfg.KeyFile == "" {
+			return fmt.Errorf("tls: cert_file and key_file must be set together")
+		}
+		return nil
+	}
+
+	// 没有默认证书时，HTTPS端口上的每个域名规则都需要自己的证书
+	for _, port := range tlsCfg.Ports {
+		if len(tlsCfg.HostCertificates[port]) == 0 {
+			return fmt.Errorf("tls: cert_file and key_file are required for port %d, which has no host rule certificates", port)
+		}
+		listener := Listener{Port: port}
+		for _, l := range c.Listeners {
+			if l.Port == port {
+				listener = l
+			}
+		}
+		for _, rule := range c.HostRules {
+			if rule.TLS == nil && listener.Attaches(rule) {
+				return fmt.Errorf("tls: host rule '%s' on HTTPS port %d has no tls certificate and tls.cert_file is not set", rule.Pattern, port)
+			}
+		}
+	}
+	return nil
+}
+
+// validateACMEHost 验证通过ACME申请证书的域名规则
+// HTTP-01和TLS-ALPN-01验证都不能申请通配符证书，pattern必须是完整的域名
+func validateACMEHost(rule HostRule, acme ACMEConfig) error {
+	if rule.TLS.CertFile != "" || rule.TLS.KeyFile != "" {
+		return fmt.Errorf("acme cannot be used with cert_file and key_file")
+	}
+	if strings.Contains(rule.Pattern, "*") || net.ParseIP(rule.Pattern) != nil || !strings.Contains(rule.Pattern, ".") {
+		return fmt.Errorf("acme requires a fully qualified domain name without wildcards")
+	}
+	if acme.CacheDir == "" {
+		return fmt.Errorf("acme requires tls.acme.cache_dir")
+	}
+	if !acme.AcceptTOS {
+		return fmt.Errorf("acme requires tls.acme.accept_tos: true")
+	}
+	if acme.RenewBefore < 0 {
+		return fmt.Errorf("tls.acme.renew_before must not be negative")
+	}
+	return nil
+}
+
+// validateListeners 验证监听器声明，并检查每个域名规则都挂载到了监听器
+func (c *Config) validateListeners() error {
+	if len(c.Listeners) == 0 {
+		return nil
+	}
+	if len(c.Advanced.TLS.Ports) > 0 || len(c.Advanced.TLS.PortPolicies) > 0 {
+		return fmt.Errorf("tls.ports and tls.port_policies cannot be used with listeners, set protocol and tls on the listener instead")
+	}
+
+	patterns := make(map[string]bool, len(c.HostRules))
+	for _, rule := range c.HostRules {
+		patterns[rule.Pattern] = true
+	}
+
+	ports := make(map[int]bool, len(c.Listeners))
+	for _, listener := range c.Listeners {
+		if listener.Port <= 0 || listener.Port > 65535 {
+			return fmt.Errorf("listener %s: invalid port %d", listener, listener.Port)
+		}
+		if ports[listener.Port] {
+			return fmt.Errorf("listener %s: port %d is declared by more than one listener", listener, listener.Port)
+		}
+		ports[listener.Port] = true
+
+		if listener.Address != "" && net.ParseIP(listener.Address) == nil {
+			return fmt.Errorf("listener %s: address must be an IP address", listener)
+		}
+		switch listener.Protocol {
+		case "", ProtocolHTTP, ProtocolHTTPS:
+		default:
+			return fmt.Errorf("listener %s: invalid protocol '%s', expected http or https", listener, listener.Protocol)
+		}
+		if listener.TLS != nil && !listener.IsHTTPS() {
+			return fmt.Errorf("listener %s: tls requires protocol https", listener)
+		}
+		for _, pattern := range listener.HostRules {
+			if !patterns[pattern] {
+				return fmt.Errorf("listener %s: unknown host rule '%s'", listener, pattern)
+			}
+		}
+	}
+
+	// 域名规则没有挂载到任何监听器时不会收到请求，通常是端口或pattern写错
+	for _, rule := range c.HostRules {
+		attached := false
+		for _, listener := range c.Listeners {
+			if listener.Attaches(rule) {
+				attached = true
+				break
+			}
+		}
+		if !attached {
+			return fmt.Errorf("host rule '%s' is not attached to any listener", rule.Pattern)
+		}
+	}
+	return nil
+}
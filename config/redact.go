@@ -0,0 +1,83 @@
+package config
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue 替换敏感配置值的占位符
+const redactedValue = "[REDACTED]"
+
+// sensitiveKeys 名称中包含这些词的配置项视为敏感信息（不区分大小写）
+var sensitiveKeys = []string{
+	"password", "passwd", "secret", "token", "credential",
+	"private_key", "api_key", "apikey", "access_key", "authorization",
+}
+
+// secretReferencePrefixes 不包含密钥本身的引用，展示时保持原样
+var secretReferencePrefixes = []string{"env:", "file:", "vault:"}
+
+// Redacted 返回用于展示的配置，结构与配置文件一致，包括合并的config_dir文件和默认值之外的所有配置项
+// 敏感配置项（包括中间件配置中的同名配置项）的值替换为redactedValue，env:、file:、vault: 引用保持原样
+func (c *Config) Redacted() (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		values[key] = redactValue(key, value)
+	}
+	return values, nil
+}
+
+// redactValue 替换敏感配置项的值，key为值所在的配置项名称，列表中的元素使用列表的名称
+func redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = redactValue(k, child)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(key, item)
+		}
+		return v
+	case nil:
+		return nil
+	}
+
+	if !isSensitiveKey(key) {
+		return value
+	}
+	if s, ok := value.(string); ok && (s == "" || isSecretReference(s)) {
+		return s
+	}
+	return redactedValue
+}
+
+// isSensitiveKey 判断配置项名称是否表示敏感信息
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range sensitiveKeys {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecretReference 判断值是否为密钥引用
+func isSecretReference(value string) bool {
+	for _, prefix := range secretReferencePrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
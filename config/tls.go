@@ -0,0 +1,199 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// HasPort 判断端口是否提供HTTPS
+func (c TLSConfig) HasPort(port int) bool {
+	for _, p := range c.Ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSPolicy HTTPS监听的TLS策略，未设置的字段使用默认值
+type TLSPolicy struct {
+	MinVersion       string   `yaml:"min_version"`       // 最低TLS版本："1.0"、"1.1"、"1.2"、"1.3"，默认1.2
+	MaxVersion       string   `yaml:"max_version"`       // 最高TLS版本，默认不限制
+	CipherSuites     []string `yaml:"cipher_suites"`     // TLS 1.0-1.2可用的加密套件（如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256），TLS 1.3的套件不可配置
+	CurvePreferences []string `yaml:"curve_preferences"` // 密钥交换曲线，按优先级排列：X25519、P256、P384、P521
+	ALPN             []string `yaml:"alpn"`              // ALPN协议，默认 ["h2", "http/1.1"]
+	// 客户端证书（mTLS）：none（默认）、request、require、verify_if_given、require_and_verify
+	// verify_if_given和require_and_verify使用client_ca_file验证客户端证书
+	ClientAuth   string `yaml:"client_auth"`
+	ClientCAFile string `yaml:"client_ca_file"` // 签发客户端证书的CA（PEM，可包含多个证书）
+	// 优先使用混合后量子密钥交换X25519MLKEM768（仅TLS 1.3），不支持的客户端回退到传统曲线
+	PostQuantum bool `yaml:"post_quantum"`
+	// 启用加密ClientHello（ECH），密钥由tls.ech配置，要求min_version为1.3
+	ECH bool `yaml:"ech"`
+}
+
+// ECHConfig 加密ClientHello（ECH）密钥配置
+// 密钥文件不存在时自动生成，需要把日志中输出的ECHConfigList发布到域名的DNS HTTPS记录（ech参数）
+type ECHConfig struct {
+	KeyFile    string `yaml:"key_file"`    // 密钥文件（PEM，包含PRIVATE KEY和ECHCONFIG）
+	PublicName string `yaml:"public_name"` // 生成密钥时使用的外层SNI域名，客户端握手时明文可见
+}
+
+// Merge 使用override中已设置的字段覆盖当前策略
+func (p TLSPolicy) Merge(override TLSPolicy) TLSPolicy {
+	if override.MinVersion != "" {
+		p.MinVersion = override.MinVersion
+	}
+	if override.MaxVersion != "" {
+		p.MaxVersion = override.MaxVersion
+	}
+	if len(override.CipherSuites) > 0 {
+		p.CipherSuites = override.CipherSuites
+	}
+	if len(override.CurvePreferences) > 0 {
+		p.CurvePreferences = override.CurvePreferences
+	}
+	if len(override.ALPN) > 0 {
+		p.ALPN = override.ALPN
+	}
+	if override.ClientAuth != "" {
+		p.ClientAuth = override.ClientAuth
+	}
+	if override.ClientCAFile != "" {
+		p.ClientCAFile = override.ClientCAFile
+	}
+	if override.PostQuantum {
+		p.PostQuantum = true
+	}
+	if override.ECH {
+		p.ECH = true
+	}
+	return p
+}
+
+// TLS版本名称
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// 密钥交换曲线名称
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// 客户端证书验证方式名称
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// ParseClientAuth 解析客户端证书验证方式，空字符串表示不请求客户端证书
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	if s == "" {
+		return tls.NoClientCert, nil
+	}
+	clientAuth, exists := tlsClientAuthTypes[strings.ToLower(strings.TrimSpace(s))]
+	if !exists {
+		return 0, fmt.Errorf("unknown client_auth '%s'", s)
+	}
+	return clientAuth, nil
+}
+
+// ParseTLSVersion 解析TLS版本，如 "1.2"、"TLS1.2"，空字符串返回0
+func ParseTLSVersion(s string) (uint16, error) {
+	name := strings.TrimSpace(strings.ToUpper(s))
+	if name == "" {
+		return 0, nil
+	}
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "TLS"), "V")
+
+	version, exists := tlsVersions[strings.TrimSpace(name)]
+	if !exists {
+		return 0, fmt.Errorf("unknown TLS version '%s'", s)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites 解析加密套件名称（与crypto/tls中的常量名一致）
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, exists := suites[strings.ToUpper(strings.TrimSpace(name))]
+		if !exists {
+			return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseCurves 解析密钥交换曲线名称
+func ParseCurves(names []string) ([]tls.CurveID, error) {
+	curves := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		curve, exists := tlsCurves[strings.ToUpper(strings.TrimSpace(name))]
+		if !exists {
+			return nil, fmt.Errorf("unknown curve '%s'", name)
+		}
+		curves = append(curves, curve)
+	}
+	return curves, nil
+}
+
+// validateTLSPolicy 验证TLS策略
+func validateTLSPolicy(p TLSPolicy) error {
+	minVersion, err := ParseTLSVersion(p.MinVersion)
+	if err != nil {
+		return fmt.Errorf("min_version: %v", err)
+	}
+	maxVersion, err := ParseTLSVersion(p.MaxVersion)
+	if err != nil {
+		return fmt.Errorf("max_version: %v", err)
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return fmt.Errorf("min_version must not be greater than max_version")
+	}
+	if _, err := ParseCipherSuites(p.CipherSuites); err != nil {
+		return fmt.Errorf("cipher_suites: %v", err)
+	}
+	if _, err := ParseCurves(p.CurvePreferences); err != nil {
+		return fmt.Errorf("curve_preferences: %v", err)
+	}
+	clientAuth, err := ParseClientAuth(p.ClientAuth)
+	if err != nil {
+		return err
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && p.ClientCAFile == "" {
+		return fmt.Errorf("client_ca_file is required when client_auth is '%s'", p.ClientAuth)
+	}
+	if (p.PostQuantum || p.ECH) && !ModernTLSSupported {
+		return fmt.Errorf("post_quantum and ech require building with Go 1.24 or later")
+	}
+	if p.ECH && minVersion != tls.VersionTLS13 {
+		return fmt.Errorf("ech requires min_version 1.3")
+	}
+	for _, proto := range p.ALPN {
+		if proto == "" {
+			return fmt.Errorf("alpn: protocol must not be empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build go1.24
+
+package config
+
+import "crypto/tls"
+
+// ModernTLSSupported 当前Go版本是否支持ECH和后量子密钥交换（需要Go 1.24或更高版本编译）
+const ModernTLSSupported = true
+
+func init() {
+	tlsCurves["X25519MLKEM768"] = tls.X25519MLKEM768
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"toyou-proxy/schedule"
+)
+
+// IsActive 检查时间窗口列表在给定时间是否生效
+// 未配置任何窗口时始终生效；配置了多个窗口时满足其一即生效
+func IsActive(windows []ActiveWindow, now time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+
+	for _, w := range windows {
+		window, err := schedule.Compile(w.Schedule, w.Timezone)
+		if err != nil {
+			log.Printf("Invalid active window '%s': %v", w.Schedule, err)
+			continue
+		}
+		if window.Active(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateWindows 验证时间窗口配置
+func validateWindows(windows []ActiveWindow) error {
+	for _, w := range windows {
+		if _, err := schedule.Compile(w.Schedule, w.Timezone); err != nil {
+			return fmt.Errorf("invalid active window: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package debuglog
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+
+	"toyou-proxy/config"
+)
+
+// Logger 调试日志记录器，记录域名匹配、中间件链构建等每个请求都会产生的日志
+// 未开启时丢弃所有日志；开启后按采样比例逐条输出，避免高流量时日志写满磁盘
+type Logger struct {
+	enabled    bool
+	sampleRate float64
+}
+
+// NewLogger 根据调试日志配置创建记录器
+func NewLogger(cfg config.DebugLogConfig) *Logger {
+	sampleRate := cfg.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = 1
+	}
+	return &Logger{
+		enabled:    cfg.Enabled,
+		sampleRate: sampleRate,
+	}
+}
+
+// Enabled 判断本条日志是否需要输出，参数需要额外计算时可先调用以避免开销
+func (l *Logger) Enabled() bool {
+	if !l.enabled {
+		return false
+	}
+	return l.sampleRate >= 1 || rand.Float64() < l.sampleRate
+}
+
+// Printf 按采样比例输出调试日志
+func (l *Logger) Printf(format string, v ...interface{}) {
+	if l.Enabled() {
+		log.Output(2, "[debug] "+fmt.Sprintf(format, v...))
+	}
+}
+
+// 全局默认调试日志记录器，代理启动时根据配置设置，插件与主程序共享同一实例
+var (
+	defaultLogger   = NewLogger(config.DebugLogConfig{})
+	defaultLoggerMu sync.RWMutex
+)
+
+// Configure 根据调试日志配置设置默认记录器
+func Configure(cfg config.DebugLogConfig) {
+	logger := NewLogger(cfg)
+
+	defaultLoggerMu.Lock()
+	defaultLogger = logger
+	defaultLoggerMu.Unlock()
+}
+
+// GetDefaultLogger 获取默认调试日志记录器
+func GetDefaultLogger() *Logger {
+	defaultLoggerMu.RLock()
+	defer defaultLoggerMu.RUnlock()
+	return defaultLogger
+}
+
+// Enabled 使用默认记录器判断本条日志是否需要输出
+func Enabled() bool {
+	return GetDefaultLogger().Enabled()
+}
+
+// Printf 使用默认记录器输出调试日志
+func Printf(format string, v ...interface{}) {
+	logger := GetDefaultLogger()
+	if logger.Enabled() {
+		log.Output(2, "[debug] "+fmt.Sprintf(format, v...))
+	}
+}
@@ -0,0 +1,201 @@
+package dump
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"toyou-proxy/config"
+	"toyou-proxy/privacy"
+)
+
+// DebugHeader 调试请求头，携带与配置一致的令牌时总是转储
+const DebugHeader = "X-Debug-Dump"
+
+// CaptureKey 当前请求的转储在中间件上下文中的键
+const CaptureKey = "debug_dump"
+
+// defaultMaxBodySize 默认每个消息体最多记录64KB
+const defaultMaxBodySize = 64 << 10
+
+// Capture 一个采样请求的转储，请求体和上游响应体在转发时边读边记录，不影响转发
+type Capture struct {
+	dump    *Dump
+	log     bool
+	keep    int // 规则保留的记录数
+	start   time.Time
+	request *bodyCapture
+
+	mu       sync.Mutex
+	upstream *http.Request // 实际发往上游的请求（Director修改之后）
+	response *http.Response
+	body     *bodyCapture
+}
+
+// Begin 按配置决定是否转储请求，不转储时返回nil
+// 调试请求头总是从转发给上游的请求中删除
+func Begin(r *http.Request, cfg config.DumpConfig, requestID, route string) *Capture {
+	debugToken := r.Header.Get(DebugHeader)
+	r.Header.Del(DebugHeader)
+
+	debug := cfg.Token != "" && debugToken != "" && subtle.ConstantTimeCompare([]byte(debugToken), []byte(cfg.Token)) == 1
+	if !debug && (cfg.SampleRate <= 0 || rand.Float64() >= cfg.SampleRate) {
+		return nil
+	}
+
+	maxBodySize := int64(defaultMaxBodySize)
+	if size, err := config.ParseSize(cfg.MaxBodySize); err == nil && size > 0 {
+		maxBodySize = size
+	}
+	return &Capture{
+		dump: &Dump{
+			RequestID: requestID,
+			Route:     route,
+			Client:    privacy.ClientIP(r.RemoteAddr),
+			Time:      time.Now(),
+			Debug:     debug,
+		},
+		log:     cfg.Log,
+		keep:    cfg.BufferSize,
+		request: &bodyCapture{limit: maxBodySize},
+		body:    &bodyCapture{limit: maxBodySize},
+	}
+}
+
+// WrapRequest 在转发前包装请求体，记录发往上游的内容
+func (c *Capture) WrapRequest(r *http.Request) {
+	c.start = time.Now()
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &teeBody{ReadCloser: r.Body, capture: c.request}
+	}
+}
+
+// RecordResponse 记录上游响应并包装响应体，在ModifyResponse中其他处理之前调用，记录的是上游的原始响应
+func (c *Capture) RecordResponse(resp *http.Response) {
+	c.mu.Lock()
+	c.upstream = resp.Request
+	c.response = &http.Response{Proto: resp.Proto, StatusCode: resp.StatusCode, Header: resp.Header.Clone()}
+	c.mu.Unlock()
+
+	resp.Body = &teeBody{ReadCloser: resp.Body, capture: c.body}
+}
+
+// Finish 在转发完成后保存转储，r为客户端请求，上游没有返回响应时用于记录请求
+func (c *Capture) Finish(r *http.Request) {
+	c.mu.Lock()
+	upstream, response := c.upstream, c.response
+	c.mu.Unlock()
+
+	d := c.dump
+	d.DurationMs = float64(time.Since(c.start)) / float64(time.Millisecond)
+
+	sent := upstream
+	if sent == nil {
+		sent = r
+	}
+	d.Request = Message{
+		Method: sent.Method,
+		URL:    requestURL(sent),
+		Proto:  sent.Proto,
+		Header: privacy.Headers(sent.Header),
+	}
+	c.request.fill(&d.Request)
+
+	if response == nil {
+		d.Error = "no response from upstream"
+		if err := r.Context().Err(); err != nil {
+			d.Error += ": " + err.Error()
+		}
+	} else {
+		d.Response = &Message{
+			Proto:  response.Proto,
+			Status: response.StatusCode,
+			Header: privacy.Headers(response.Header),
+		}
+		c.body.fill(d.Response)
+	}
+
+	GetDefaultRecorder().Add(d, c.keep)
+	if c.log {
+		log.Printf("Debug dump #%d for %s (%.1fms):\n%s", d.ID, d.Route, d.DurationMs, d)
+	}
+}
+
+// requestURL 返回请求的完整URL，敏感查询参数按隐私配置脱敏
+func requestURL(r *http.Request) string {
+	uri := privacy.URI(r.URL)
+	if r.URL.Host == "" {
+		return uri
+	}
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.URL.Host + uri
+}
+
+// bodyCapture 记录消息体的开头部分和总大小
+type bodyCapture struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	size  int64
+	limit int64
+}
+
+// record 记录读取到的数据，超过上限的部分只计入大小
+func (b *bodyCapture) record(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.size += int64(len(p))
+	if room := b.limit - int64(b.buf.Len()); room > 0 {
+		if int64(len(p)) > room {
+			p = p[:room]
+		}
+		b.buf.Write(p)
+	}
+}
+
+// fill 把记录的消息体写入转储，非UTF-8内容使用base64编码
+func (b *bodyCapture) fill(m *Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m.BodySize = b.size
+	m.Truncated = b.size > int64(b.buf.Len())
+	data := b.buf.Bytes()
+	if m.Truncated {
+		// 截断可能切开多字节字符，去掉末尾不完整的部分后再判断
+		for i := 0; i < utf8.UTFMax-1 && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	if utf8.Valid(data) {
+		m.Body = string(data)
+		return
+	}
+	m.Body = base64.StdEncoding.EncodeToString(b.buf.Bytes())
+	m.BodyEncoding = "base64"
+}
+
+// teeBody 读取消息体时同时记录
+type teeBody struct {
+	io.ReadCloser
+	capture *bodyCapture
+}
+
+// Read 读取消息体
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.capture.record(p[:n])
+	}
+	return n, err
+}
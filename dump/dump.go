@@ -0,0 +1,182 @@
+package dump
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBufferSize 每个规则默认保留的转储记录数
+const defaultBufferSize = 100
+
+// Message 转储的请求或响应，请求头按隐私配置脱敏
+type Message struct {
+	Method string      `json:"method,omitempty"`
+	URL    string      `json:"url,omitempty"`
+	Proto  string      `json:"proto"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+	// 请求体不是有效的UTF-8文本时为base64
+	BodyEncoding string `json:"body_encoding,omitempty"`
+	BodySize     int64  `json:"body_size"` // 实际传输的大小
+	Truncated    bool   `json:"truncated"` // 超过max_body_size，只记录了开头部分
+}
+
+// Dump 一次转发到上游的请求和上游响应
+type Dump struct {
+	ID         uint64    `json:"id"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Route      string    `json:"route,omitempty"`
+	Client     string    `json:"client"`
+	Time       time.Time `json:"time"`
+	DurationMs float64   `json:"duration_ms"`
+	Debug      bool      `json:"debug"` // 由调试请求头触发
+	Request    Message   `json:"request"`
+	Response   *Message  `json:"response,omitempty"` // 上游没有返回响应时为nil
+	Error      string    `json:"error,omitempty"`
+}
+
+// String 格式化为类似HTTP报文的文本，用于写入日志
+func (d *Dump) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "> %s %s %s\n", d.Request.Method, d.Request.URL, d.Request.Proto)
+	writeMessage(&b, "> ", d.Request)
+	if d.Response == nil {
+		fmt.Fprintf(&b, "< (no response: %s)\n", d.Error)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "< %s %d %s\n", d.Response.Proto, d.Response.Status, http.StatusText(d.Response.Status))
+	writeMessage(&b, "< ", *d.Response)
+	return b.String()
+}
+
+// writeMessage 按名称顺序写入头部，之后是消息体
+func writeMessage(b *strings.Builder, prefix string, m Message) {
+	names := make([]string, 0, len(m.Header))
+	for name := range m.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range m.Header[name] {
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+	if m.BodySize == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s\n", prefix)
+	switch {
+	case m.BodyEncoding != "":
+		fmt.Fprintf(b, "%s[%d bytes, %s] %s\n", prefix, m.BodySize, m.BodyEncoding, m.Body)
+	default:
+		for _, line := range strings.Split(strings.TrimSuffix(m.Body, "\n"), "\n") {
+			fmt.Fprintf(b, "%s%s\n", prefix, line)
+		}
+	}
+	if m.Truncated {
+		fmt.Fprintf(b, "%s[truncated, %d bytes total]\n", prefix, m.BodySize)
+	}
+}
+
+// Recorder 按规则保留最近的转储记录，每个规则使用独立的环形缓冲区，采样频繁的规则不会挤掉其他规则的记录
+type Recorder struct {
+	mu     sync.Mutex
+	nextID uint64
+	routes map[string]*ring
+}
+
+// ring 一个规则的环形缓冲区
+type ring struct {
+	dumps []*Dump
+	next  int
+}
+
+// NewRecorder 创建转储记录器
+func NewRecorder() *Recorder {
+	return &Recorder{routes: make(map[string]*ring)}
+}
+
+// 全局默认转储记录器实例
+var defaultRecorder = NewRecorder()
+
+// GetDefaultRecorder 获取默认转储记录器实例
+func GetDefaultRecorder() *Recorder {
+	return defaultRecorder
+}
+
+// Add 保存转储记录并分配编号，size为规则保留的记录数，缓冲区满时覆盖该规则最早的记录
+func (r *Recorder) Add(d *Dump, size int) {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	d.ID = r.nextID
+
+	buf, exists := r.routes[d.Route]
+	if !exists {
+		buf = &ring{dumps: make([]*Dump, 0, size)}
+		r.routes[d.Route] = buf
+	}
+	if len(buf.dumps) < cap(buf.dumps) {
+		buf.dumps = append(buf.dumps, d)
+		return
+	}
+	buf.dumps[buf.next] = d
+	buf.next = (buf.next + 1) % len(buf.dumps)
+}
+
+// recent 返回缓冲区中的记录，最新的在前
+func (b *ring) recent() []*Dump {
+	n := len(b.dumps)
+	recent := make([]*Dump, 0, n)
+	for i := 0; i < n; i++ {
+		recent = append(recent, b.dumps[(b.next-1-i+2*n)%n])
+	}
+	return recent
+}
+
+// Recent 返回最近的转储记录，最新的在前；route不为空时只返回该规则的记录
+func (r *Recorder) Recent(route string) []*Dump {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if route != "" {
+		if buf, exists := r.routes[route]; exists {
+			return buf.recent()
+		}
+		return []*Dump{}
+	}
+
+	recent := make([]*Dump, 0)
+	for _, buf := range r.routes {
+		recent = append(recent, buf.recent()...)
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].ID > recent[j].ID
+	})
+	return recent
+}
+
+// Get 按编号查找转储记录
+func (r *Recorder) Get(id uint64) (*Dump, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, buf := range r.routes {
+		for _, d := range buf.dumps {
+			if d.ID == id {
+				return d, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,228 @@
+package dump
+
+import (
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HAR HTTP Archive 1.2格式，可以导入浏览器开发者工具的网络面板
+// 见 http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHAR 把转储记录转换为HAR，条目按时间顺序排列
+// 代理不单独记录发送和接收耗时，整个转发耗时计入wait
+func NewHAR(dumps []*Dump) *HAR {
+	har := &HAR{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "toyou-proxy", Version: version()},
+		Entries: make([]harEntry, 0, len(dumps)),
+	}}
+
+	sorted := append([]*Dump(nil), dumps...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	for _, d := range sorted {
+		har.Log.Entries = append(har.Log.Entries, harEntryOf(d))
+	}
+	return har
+}
+
+// harEntryOf 转换一条转储记录
+func harEntryOf(d *Dump) harEntry {
+	entry := harEntry{
+		StartedDateTime: d.Time.Format(time.RFC3339Nano),
+		Time:            d.DurationMs,
+		Request: harRequest{
+			Method:      d.Request.Method,
+			URL:         d.Request.URL,
+			HTTPVersion: d.Request.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(d.Request.Header),
+			QueryString: harQuery(d.Request.URL),
+			HeadersSize: -1,
+			BodySize:    d.Request.BodySize,
+		},
+		Timings: harTimings{Wait: d.DurationMs},
+		Comment: "route " + d.Route,
+	}
+	if d.RequestID != "" {
+		entry.Comment += ", request id " + d.RequestID
+	}
+
+	if d.Request.BodySize > 0 {
+		entry.Request.PostData = &harPostData{
+			MimeType: d.Request.Header.Get("Content-Type"),
+			Text:     d.Request.Body,
+			Comment:  bodyComment(d.Request),
+		}
+	}
+
+	if d.Response == nil {
+		// 没有响应时按浏览器的惯例使用状态码0
+		entry.Response = harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		entry.Response.Content.Comment = d.Error
+		entry.Comment += ", " + d.Error
+		return entry
+	}
+
+	entry.Response = harResponse{
+		Status:      d.Response.Status,
+		StatusText:  http.StatusText(d.Response.Status),
+		HTTPVersion: d.Response.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(d.Response.Header),
+		Content: harContent{
+			Size:     d.Response.BodySize,
+			MimeType: d.Response.Header.Get("Content-Type"),
+			Text:     d.Response.Body,
+			Encoding: d.Response.BodyEncoding,
+			Comment:  bodyComment(*d.Response),
+		},
+		RedirectURL: d.Response.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    d.Response.BodySize,
+	}
+	return entry
+}
+
+// harHeaders 按名称顺序转换头部
+func harHeaders(header http.Header) []harNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := make([]harNameValue, 0, len(header))
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// harQuery 解析URL中的查询参数，保持原有顺序
+func harQuery(rawURL string) []harNameValue {
+	query := []harNameValue{}
+	_, rawQuery, found := strings.Cut(rawURL, "?")
+	if !found {
+		return query
+	}
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		query = append(query, harNameValue{Name: name, Value: value})
+	}
+	return query
+}
+
+// bodyComment 说明消息体被截断或以base64记录
+func bodyComment(m Message) string {
+	var notes []string
+	if m.BodyEncoding != "" {
+		notes = append(notes, "body is "+m.BodyEncoding+" encoded")
+	}
+	if m.Truncated {
+		notes = append(notes, "body truncated by max_body_size")
+	}
+	return strings.Join(notes, ", ")
+}
+
+// version 返回构建信息中的模块版本
+func version() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
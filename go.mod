@@ -1,7 +1,37 @@
 module toyou-proxy
 
-go 1.21
+go 1.22.0
 
 require gopkg.in/yaml.v3 v3.0.1
 
 require github.com/gorilla/websocket v1.5.3
+
+require (
+	github.com/crewjam/saml v0.4.14
+	github.com/gen2brain/avif v0.4.2
+	github.com/gen2brain/webp v0.5.2
+	github.com/go-ldap/ldap/v3 v3.4.8
+	github.com/redis/go-redis/v9 v9.9.0
+	golang.org/x/crypto v0.21.0
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.22.0
+	golang.org/x/sys v0.28.0
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/beevik/etree v1.1.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/crewjam/httperr v0.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/ebitengine/purego v0.8.1 // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.5 // indirect
+	github.com/golang-jwt/jwt/v4 v4.4.3 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/jonboulle/clockwork v0.2.2 // indirect
+	github.com/mattermost/xml-roundtrip-validator v0.1.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/russellhaering/goxmldsig v1.3.0 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
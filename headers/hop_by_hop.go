@@ -0,0 +1,47 @@
+package headers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HopByHop 逐跳头部（RFC 7230 第6.1节），只对单个连接有意义，代理转发时必须删除
+var HopByHop = []string{
+	"Connection",
+	"Proxy-Connection", // 非标准，但常见于旧客户端
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopByHop 删除逐跳头部，包括Connection头中列出的自定义逐跳头部
+func RemoveHopByHop(h http.Header) {
+	// 先删除Connection头中声明的头部
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for _, name := range HopByHop {
+		h.Del(name)
+	}
+}
+
+// RemoveHopByHopKeepUpgrade 删除逐跳头部，但保留协议升级所需的Upgrade头，
+// 并将Connection头规范为"Upgrade"，用于WebSocket等协议升级的握手请求和响应
+func RemoveHopByHopKeepUpgrade(h http.Header) {
+	upgrade := h.Get("Upgrade")
+	RemoveHopByHop(h)
+
+	if upgrade != "" {
+		h.Set("Connection", "Upgrade")
+		h.Set("Upgrade", upgrade)
+	}
+}
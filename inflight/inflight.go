@@ -0,0 +1,235 @@
+package inflight
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"toyou-proxy/metrics"
+	"toyou-proxy/privacy"
+)
+
+// 请求类型
+const (
+	KindHTTP      = "http"
+	KindSSE       = "sse"
+	KindWebSocket = "websocket"
+)
+
+// 重新加载前开始的SSE和WebSocket流的结束方式
+const (
+	streamCompleted = "completed" // 在旧配置上正常结束
+	streamMigrated  = "migrated"  // 宽限期结束后发送了重新连接提示
+	streamDropped   = "dropped"   // 宽限期结束后没能发送提示，直接关闭
+)
+
+// 重新加载前开始的流的指标
+var reloadStreams = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_reload_streams_total",
+	"SSE and WebSocket streams started before a configuration reload, by kind and how they ended.",
+	"kind", "result",
+)
+
+// Request 正在处理的请求，客户端地址和URI按隐私配置脱敏
+// Generation为处理请求的代理处理器的配置版本，请求在结束前一直使用该版本的配置
+type Request struct {
+	ID         uint64    `json:"id"`
+	Kind       string    `json:"kind"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	URI        string    `json:"uri"`
+	Client     string    `json:"client"`
+	Generation uint64    `json:"generation"`
+	StartedAt  time.Time `json:"started_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+
+	cancel   context.CancelFunc
+	retire   func() // 流登记的关闭方式，见OnRetire
+	retiring bool
+	hinted   bool
+}
+
+// Tracker 记录正在处理的请求，用于在关闭期间查看阻塞排空的请求并选择性地强制关闭，
+// 以及在重新加载后关闭仍在使用旧配置的流
+type Tracker struct {
+	mu         sync.Mutex
+	nextID     uint64
+	requests   map[uint64]*Request
+	generation uint64 // 当前的配置版本
+}
+
+// trackedKey 请求上下文中登记的请求
+type trackedKey struct{}
+
+// tracked 上下文中的请求及其跟踪器
+type tracked struct {
+	tracker *Tracker
+	request *Request
+}
+
+// NewTracker 创建请求跟踪器
+func NewTracker() *Tracker {
+	return &Tracker{requests: make(map[uint64]*Request)}
+}
+
+// 全局默认请求跟踪器实例
+var defaultTracker = NewTracker()
+
+// GetDefaultTracker 获取默认请求跟踪器实例
+func GetDefaultTracker() *Tracker {
+	return defaultTracker
+}
+
+// Track 登记请求，generation为处理请求的配置版本，返回可以被强制关闭的请求和请求结束时调用的函数
+// 强制关闭通过取消请求的上下文实现，上游请求、SSE和WebSocket转发随之中止
+func (t *Tracker) Track(r *http.Request, generation uint64) (*http.Request, func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	req := &Request{
+		Kind:       kindOf(r),
+		Method:     r.Method,
+		Host:       r.Host,
+		URI:        privacy.URI(r.URL),
+		Client:     privacy.ClientIP(r.RemoteAddr),
+		Generation: generation,
+		StartedAt:  time.Now(),
+		cancel:     cancel,
+	}
+	ctx = context.WithValue(ctx, trackedKey{}, &tracked{tracker: t, request: req})
+
+	t.mu.Lock()
+	t.nextID++
+	req.ID = t.nextID
+	t.requests[req.ID] = req
+	t.mu.Unlock()
+
+	return r.WithContext(ctx), func() {
+		t.mu.Lock()
+		delete(t.requests, req.ID)
+		if req.Kind != KindHTTP && req.Generation < t.generation {
+			result := streamCompleted
+			if req.retiring {
+				result = streamDropped
+				if req.hinted {
+					result = streamMigrated
+				}
+			}
+			reloadStreams.Inc(req.Kind, result)
+		}
+		t.mu.Unlock()
+		cancel()
+	}
+}
+
+// SetGeneration 设置当前的配置版本，重新加载替换代理处理器后调用；
+// 之前版本上的流结束时计入toyou_proxy_reload_streams_total
+func (t *Tracker) SetGeneration(generation uint64) {
+	t.mu.Lock()
+	t.generation = generation
+	t.mu.Unlock()
+}
+
+// Retire 关闭配置版本早于generation的SSE和WebSocket流，返回关闭的数量
+// 流通过OnRetire登记了关闭方式时先向客户端发送重新连接提示，否则直接取消
+func (t *Tracker) Retire(generation uint64) int {
+	var retire []func()
+	t.mu.Lock()
+	for _, req := range t.requests {
+		if req.Kind == KindHTTP || req.Generation >= generation || req.retiring {
+			continue
+		}
+		req.retiring = true
+		if req.retire != nil {
+			retire = append(retire, req.retire)
+		} else {
+			retire = append(retire, req.cancel)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, fn := range retire {
+		fn()
+	}
+	return len(retire)
+}
+
+// OnRetire 为ctx所属的流登记重新加载后的关闭方式，retire应向客户端发送重新连接提示后结束流，
+// 发送成功时调用MarkHinted；登记之前已经被关闭的流（上下文已取消）不再调用retire
+func OnRetire(ctx context.Context, retire func()) {
+	if entry, ok := ctx.Value(trackedKey{}).(*tracked); ok {
+		entry.tracker.mu.Lock()
+		entry.request.retire = retire
+		entry.tracker.mu.Unlock()
+	}
+}
+
+// MarkHinted 记录ctx所属的流已经收到重新连接提示
+func MarkHinted(ctx context.Context) {
+	if entry, ok := ctx.Value(trackedKey{}).(*tracked); ok {
+		entry.tracker.mu.Lock()
+		entry.request.hinted = true
+		entry.tracker.mu.Unlock()
+	}
+}
+
+// kindOf 根据请求头判断请求类型
+func kindOf(r *http.Request) string {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return KindWebSocket
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return KindSSE
+	}
+	return KindHTTP
+}
+
+// List 返回正在处理的请求，最早开始的在前
+func (t *Tracker) List() []Request {
+	now := time.Now()
+
+	t.mu.Lock()
+	list := make([]Request, 0, len(t.requests))
+	for _, req := range t.requests {
+		snapshot := *req
+		snapshot.AgeSeconds = now.Sub(req.StartedAt).Seconds()
+		list = append(list, snapshot)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Count 返回正在处理的请求数
+func (t *Tracker) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.requests)
+}
+
+// Close 强制关闭指定请求，请求不存在时返回false
+func (t *Tracker) Close(id uint64) bool {
+	t.mu.Lock()
+	req, exists := t.requests[id]
+	t.mu.Unlock()
+	if !exists {
+		return false
+	}
+	req.cancel()
+	return true
+}
+
+// CloseMatching 强制关闭满足条件的请求，返回关闭的数量
+func (t *Tracker) CloseMatching(match func(Request) bool) int {
+	closed := 0
+	for _, req := range t.List() {
+		if match(req) && t.Close(req.ID) {
+			closed++
+		}
+	}
+	return closed
+}
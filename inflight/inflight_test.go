@@ -0,0 +1,68 @@
+package inflight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newStreamRequest(kind string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/stream", nil)
+	switch kind {
+	case KindSSE:
+		r.Header.Set("Accept", "text/event-stream")
+	case KindWebSocket:
+		r.Header.Set("Upgrade", "websocket")
+	}
+	return r
+}
+
+func TestTrackerRetire(t *testing.T) {
+	tracker := NewTracker()
+	before := func(result string) float64 { return reloadStreams.Value(KindSSE, result) }
+	completed, migrated, dropped := before(streamCompleted), before(streamMigrated), before(streamDropped)
+
+	// 旧配置上的三个流：正常结束、发送提示后关闭、没有登记关闭方式被直接取消
+	_, untrackFinished := tracker.Track(newStreamRequest(KindSSE), 1)
+	hinted, untrackHinted := tracker.Track(newStreamRequest(KindSSE), 1)
+	cancelled, untrackCancelled := tracker.Track(newStreamRequest(KindSSE), 1)
+	_, untrackHTTP := tracker.Track(newStreamRequest(KindHTTP), 1)
+	current, untrackCurrent := tracker.Track(newStreamRequest(KindSSE), 2)
+
+	retired := false
+	OnRetire(hinted.Context(), func() {
+		retired = true
+		MarkHinted(hinted.Context())
+	})
+
+	tracker.SetGeneration(2)
+	untrackFinished()
+	if n := tracker.Retire(2); n != 2 {
+		t.Fatalf("Retire = %d, want 2", n)
+	}
+	if !retired {
+		t.Error("registered retire function was not called")
+	}
+	if cancelled.Context().Err() == nil {
+		t.Error("stream without a retire function was not cancelled")
+	}
+	if current.Context().Err() != nil {
+		t.Error("stream on the current configuration was closed")
+	}
+	if n := tracker.Retire(2); n != 0 {
+		t.Errorf("streams were retired twice: %d", n)
+	}
+	untrackHinted()
+	untrackCancelled()
+	untrackHTTP()
+	untrackCurrent()
+
+	for result, want := range map[string]float64{streamCompleted: completed + 1, streamMigrated: migrated + 1, streamDropped: dropped + 1} {
+		if got := before(result); got != want {
+			t.Errorf("%s streams = %v, want %v", result, got, want)
+		}
+	}
+	if tracker.Count() != 0 {
+		t.Errorf("%d requests still tracked", tracker.Count())
+	}
+}
@@ -0,0 +1,225 @@
+package loadbalancer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"toyou-proxy/stores"
+)
+
+// affinityIDLength 后端标识的长度（十六进制字符）
+const affinityIDLength = 12
+
+// sessionIDLength 共享会话表中会话ID的长度（十六进制字符）
+const sessionIDLength = 32
+
+// SessionAffinityLoadBalancer 会话保持负载均衡器包装器。选中的后端以标识的形式写入签名cookie，
+// 之后带有该cookie的请求发往同一后端；后端不可用或会话超时时由内部负载均衡器重新选择，cookie随之更新。
+// 配置了共享存储时cookie中只有会话ID，会话所在的后端记录在Redis中，多个代理实例共享会话表
+type SessionAffinityLoadBalancer struct {
+	LoadBalancer
+	config LoadBalancerConfig
+	key    []byte
+}
+
+// NewSessionAffinityLoadBalancer 创建会话保持负载均衡器
+func NewSessionAffinityLoadBalancer(lb LoadBalancer, config LoadBalancerConfig) *SessionAffinityLoadBalancer {
+	key := []byte(config.SessionAffinity.Secret)
+	if len(key) == 0 {
+		// 没有配置密钥时重启后旧cookie失效，会话重新分配
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+
+	return &SessionAffinityLoadBalancer{
+		LoadBalancer: lb,
+		config:       config,
+		key:          key,
+	}
+}
+
+// affinityID 返回后端的标识，由URL的哈希得到，配置重载和重启后保持不变且不暴露后端地址
+func affinityID(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])[:affinityIDLength]
+}
+
+// NextBackend 选择下一个后端服务器，会话cookie有效且指向的后端可用时选择该后端
+func (lb *SessionAffinityLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
+	// 后端标识由URL计算，通过管理API或DNS发现加入的后端同样可以保持会话
+	if id := lb.stickyID(req); id != "" {
+		for _, backend := range lb.GetActiveBackends() {
+			if affinityID(backend.URL) == id {
+				return backend, nil
+			}
+		}
+	}
+
+	// 没有会话、会话超时或后端不可用（不健康、摘除）时重新选择，响应中的cookie指向新后端
+	return lb.LoadBalancer.NextBackend(req)
+}
+
+// stickyID 返回请求的会话所在的后端标识，没有有效的会话时返回空字符串
+func (lb *SessionAffinityLoadBalancer) stickyID(req *http.Request) string {
+	if store := stores.GetDefaultRedis(); store != nil {
+		return lb.sharedBackend(req, store)
+	}
+	id, _, ok := lb.session(req)
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// session 读取请求中的会话cookie，返回后端标识和过期时间；签名无效或已过期时返回false
+func (lb *SessionAffinityLoadBalancer) session(req *http.Request) (string, time.Time, bool) {
+	cookie, err := req.Cookie(lb.config.SessionAffinity.CookieName)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	idx := strings.LastIndex(cookie.Value, ".")
+	if idx == -1 {
+		return "", time.Time{}, false
+	}
+	payload := cookie.Value[:idx]
+	signature, err := base64.RawURLEncoding.DecodeString(cookie.Value[idx+1:])
+	if err != nil || !hmac.Equal(signature, lb.mac(payload)) {
+		return "", time.Time{}, false
+	}
+
+	id, expiry, _ := strings.Cut(payload, ".")
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	expires := time.Unix(seconds, 0)
+	if !time.Now().Before(expires) {
+		return "", time.Time{}, false
+	}
+	return id, expires, true
+}
+
+// mac 计算cookie载荷的签名，签名中包含cookie名称
+func (lb *SessionAffinityLoadBalancer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, lb.key)
+	h.Write([]byte(lb.config.SessionAffinity.CookieName))
+	h.Write([]byte{'|'})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// setCookie 在响应中设置指向实际处理请求的后端的会话cookie。请求已带有指向同一后端的cookie且剩余时间
+// 超过超时的一半时不重复设置，否则刷新过期时间，会话在超时时间内没有请求才过期；配置了共享存储时更新共享会话表
+func (lb *SessionAffinityLoadBalancer) setCookie(resp *http.Response, backendURL string) {
+	id := ""
+	for _, backend := range lb.GetBackends() {
+		if backend.URL == backendURL || strings.HasPrefix(backend.URL, backendURL+"/") {
+			id = affinityID(backend.URL)
+			break
+		}
+	}
+	if id == "" {
+		return
+	}
+
+	if store := stores.GetDefaultRedis(); store != nil {
+		lb.setShared(resp, store, id)
+		return
+	}
+
+	timeout := lb.config.SessionAffinity.Timeout
+	now := time.Now()
+	if req := resp.Request; req != nil {
+		if current, expires, ok := lb.session(req); ok && current == id && expires.Sub(now) > timeout/2 {
+			return
+		}
+	}
+
+	expires := now.Add(timeout)
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+	cookie := &http.Cookie{
+		Name:     lb.config.SessionAffinity.CookieName,
+		Value:    payload + "." + base64.RawURLEncoding.EncodeToString(lb.mac(payload)),
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(timeout / time.Second),
+		HttpOnly: true,
+		Secure:   resp.Request != nil && resp.Request.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	resp.Header.Add("Set-Cookie", cookie.String())
+}
+
+// sessionID 返回请求中共享会话表的会话ID，没有cookie或格式无效时返回空字符串
+func (lb *SessionAffinityLoadBalancer) sessionID(req *http.Request) string {
+	cookie, err := req.Cookie(lb.config.SessionAffinity.CookieName)
+	if err != nil || len(cookie.Value) != sessionIDLength {
+		return ""
+	}
+	if _, err := hex.DecodeString(cookie.Value); err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// sharedBackend 从共享会话表中读取会话所在的后端标识，Redis出错时按没有会话处理
+func (lb *SessionAffinityLoadBalancer) sharedBackend(req *http.Request, store *stores.Redis) string {
+	sessionID := lb.sessionID(req)
+	if sessionID == "" {
+		return ""
+	}
+
+	ctx, cancel := store.Context(req.Context())
+	defer cancel()
+	id, err := store.Client().Get(ctx, store.Key("affinity", lb.config.Name, sessionID)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			store.Failed("session_affinity", err)
+		}
+		return ""
+	}
+	return id
+}
+
+// setShared 在共享会话表中记录会话所在的后端并刷新过期时间，会话在超时时间内没有请求才过期；
+// 请求没有会话ID时创建新会话并设置cookie，cookie不设置过期时间，由会话表决定会话的有效期
+func (lb *SessionAffinityLoadBalancer) setShared(resp *http.Response, store *stores.Redis, id string) {
+	sessionID, parent := "", context.Background()
+	if resp.Request != nil {
+		sessionID, parent = lb.sessionID(resp.Request), resp.Request.Context()
+	}
+	if sessionID == "" {
+		buf := make([]byte, sessionIDLength/2)
+		rand.Read(buf)
+		sessionID = hex.EncodeToString(buf)
+
+		cookie := &http.Cookie{
+			Name:     lb.config.SessionAffinity.CookieName,
+			Value:    sessionID,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   resp.Request != nil && resp.Request.Header.Get("X-Forwarded-Proto") == "https",
+			SameSite: http.SameSiteLaxMode,
+		}
+		resp.Header.Add("Set-Cookie", cookie.String())
+	}
+
+	ctx, cancel := store.Context(parent)
+	defer cancel()
+	key := store.Key("affinity", lb.config.Name, sessionID)
+	if err := store.Client().Set(ctx, key, id, lb.config.SessionAffinity.Timeout).Err(); err != nil {
+		store.Failed("session_affinity", err)
+	}
+}
@@ -0,0 +1,109 @@
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrBackendNotFound 管理API指定的后端不存在
+var ErrBackendNotFound = errors.New("not found")
+
+// AddBackend 通过管理API增加后端服务器。启用健康检查时新后端先通过一次检查才接收请求，
+// 否则立即参与选择；没有指定权重时为1
+func (lb *BaseLoadBalancer) AddBackend(url string, weight int) error {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for _, backend := range lb.backends {
+		if backend.URL == url {
+			return fmt.Errorf("backend '%s' already exists", url)
+		}
+	}
+
+	lb.addBackend(&Backend{URL: url, Weight: weight})
+	return nil
+}
+
+// RemoveBackend 通过管理API删除后端服务器，不再转发新请求，已转发的请求正常完成；不能删除最后一个后端，
+// 也不能删除DNS发现的后端（下一次解析时会重新加入），这类后端需要通过摘除停止转发
+func (lb *BaseLoadBalancer) RemoveBackend(url string) error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	backend := lb.find(url)
+	if backend == nil {
+		return fmt.Errorf("backend '%s' %w", url, ErrBackendNotFound)
+	}
+	if backend.discoveredBy != "" {
+		return fmt.Errorf("backend '%s' is discovered from %s, drain it instead", backend.URL, backend.discoveredBy)
+	}
+	if len(lb.backends) == 1 {
+		return fmt.Errorf("cannot remove the last backend '%s'", backend.URL)
+	}
+
+	lb.removeBackend(backend)
+	return nil
+}
+
+// SetWeight 通过管理API修改后端服务器的权重，下一次选择时生效
+func (lb *BaseLoadBalancer) SetWeight(url string, weight int) error {
+	if weight <= 0 {
+		return fmt.Errorf("weight must be greater than 0")
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	backend := lb.find(url)
+	if backend == nil {
+		return fmt.Errorf("backend '%s' %w", url, ErrBackendNotFound)
+	}
+	if backend.Weight != weight {
+		log.Printf("Weight of backend %s changed from %d to %d", backend.URL, backend.Weight, weight)
+		backend.Weight = weight
+	}
+	return nil
+}
+
+// addBackend 加入后端服务器，启用健康检查时新后端先通过一次检查才接收请求，调用方需要持有写锁
+func (lb *BaseLoadBalancer) addBackend(backend *Backend) {
+	backend.Active = true
+	backend.LoadHint = fullLoadHint
+
+	// 复制后替换切片，健康检查遍历的旧切片不受影响
+	backends := make([]*Backend, len(lb.backends), len(lb.backends)+1)
+	copy(backends, lb.backends)
+	lb.backends = append(backends, backend)
+	if lb.onChange != nil {
+		lb.onChange()
+	}
+
+	if lb.healthCheck != nil && (backend.HealthCheck.Enabled || lb.config.HealthCheck.Enabled) {
+		backend.Active = false
+		go lb.healthCheck.checkBackend(backend)
+		log.Printf("Backend %s added, waiting for a health check", backend.URL)
+		return
+	}
+	log.Printf("Backend %s added", backend.URL)
+}
+
+// removeBackend 移除后端服务器，已转发的请求正常完成，调用方需要持有写锁
+func (lb *BaseLoadBalancer) removeBackend(backend *Backend) {
+	backends := make([]*Backend, 0, len(lb.backends))
+	for _, b := range lb.backends {
+		if b != backend {
+			backends = append(backends, b)
+		}
+	}
+	lb.backends = backends
+	if lb.onChange != nil {
+		lb.onChange()
+	}
+
+	log.Printf("Backend %s removed, %d requests in flight", backend.URL, backend.Connections)
+}
@@ -25,26 +25,11 @@ func ConvertConfig(cfg *config.LoadBalancerConfig) LoadBalancerConfig {
 		}
 
 		// 转换健康检查配置
-		if backend.HealthCheck != nil {
-			backends[i].HealthCheck = HealthCheckConfig{
-				Enabled:  backend.HealthCheck.Enabled,
-				Interval: backend.HealthCheck.Interval,
-				Timeout:  backend.HealthCheck.Timeout,
-				Path:     backend.HealthCheck.Path,
-			}
-		}
+		backends[i].HealthCheck = convertHealthCheck(backend.HealthCheck)
 	}
 
 	// 转换全局健康检查配置
-	var healthCheck HealthCheckConfig
-	if cfg.HealthCheck != nil {
-		healthCheck = HealthCheckConfig{
-			Enabled:  cfg.HealthCheck.Enabled,
-			Interval: cfg.HealthCheck.Interval,
-			Timeout:  cfg.HealthCheck.Timeout,
-			Path:     cfg.HealthCheck.Path,
-		}
-	}
+	healthCheck := convertHealthCheck(cfg.HealthCheck)
 
 	// 转换会话保持配置
 	var sessionAffinity *SessionAffinityConfig
@@ -53,6 +38,53 @@ func ConvertConfig(cfg *config.LoadBalancerConfig) LoadBalancerConfig {
 			Enabled:    cfg.SessionAffinity.Enabled,
 			Timeout:    cfg.SessionAffinity.Timeout,
 			CookieName: cfg.SessionAffinity.CookieName,
+			Secret:     cfg.SessionAffinity.Secret,
+		}
+	}
+
+	// 转换被动健康检查配置
+	var outlierDetection *OutlierDetectionConfig
+	if od := cfg.OutlierDetection; od != nil {
+		outlierDetection = &OutlierDetectionConfig{
+			ConsecutiveErrors:  od.ConsecutiveErrors,
+			ErrorRate:          od.ErrorRate,
+			MinRequests:        od.MinRequests,
+			Window:             od.Window,
+			EjectionTime:       od.EjectionTime,
+			MaxEjectionTime:    od.MaxEjectionTime,
+			MaxEjectionPercent: od.MaxEjectionPercent,
+			RampTime:           od.RampTime,
+		}
+	}
+
+	// 转换子集配置
+	var subset SubsetConfig
+	if cfg.Subset != nil {
+		subset = SubsetConfig{Size: cfg.Subset.Size, Key: cfg.Subset.Key}
+	}
+
+	// 转换服务注册中心配置
+	var discovery *DiscoveryConfig
+	if d := cfg.Discovery; d != nil {
+		discovery = &DiscoveryConfig{}
+		if c := d.Consul; c != nil {
+			discovery.Consul = &ConsulDiscoveryConfig{
+				Address:    c.Address,
+				Service:    c.Service,
+				Tag:        c.Tag,
+				Datacenter: c.Datacenter,
+				Token:      c.Token,
+				Scheme:     c.Scheme,
+			}
+		}
+		if e := d.Etcd; e != nil {
+			discovery.Etcd = &EtcdDiscoveryConfig{
+				Endpoint: e.Endpoint,
+				Prefix:   e.Prefix,
+				Username: e.Username,
+				Password: e.Password,
+				Scheme:   e.Scheme,
+			}
 		}
 	}
 
@@ -61,7 +93,32 @@ func ConvertConfig(cfg *config.LoadBalancerConfig) LoadBalancerConfig {
 		Backends:        backends,
 		HealthCheck:     healthCheck,
 		SessionAffinity: sessionAffinity,
+		Subset:          subset,
+		DrainFile:       cfg.DrainFile,
+		DNSRefresh:      cfg.DNSRefresh,
+
+		OutlierDetection: outlierDetection,
+		Discovery:        discovery,
+	}
+}
+
+// convertHealthCheck 转换健康检查配置，未配置时返回零值（未启用）
+func convertHealthCheck(cfg *config.HealthCheckConfig) HealthCheckConfig {
+	if cfg == nil {
+		return HealthCheckConfig{}
+	}
+
+	healthCheck := HealthCheckConfig{
+		Enabled:  cfg.Enabled,
+		Interval: cfg.Interval,
+		Timeout:  cfg.Timeout,
+		Path:     cfg.Path,
+	}
+	if cfg.LoadHint != nil {
+		healthCheck.LoadHintHeader = cfg.LoadHint.Header
+		healthCheck.LoadHintField = cfg.LoadHint.JSONField
 	}
+	return healthCheck
 }
 
 // ConvertServiceConfig 将服务配置转换为负载均衡器配置
@@ -97,6 +154,43 @@ func SetDefaultValues(cfg *LoadBalancerConfig) {
 			CookieName: "LB_SESSION",
 		}
 	}
+	if cfg.SessionAffinity.Timeout <= 0 {
+		cfg.SessionAffinity.Timeout = 30 * time.Minute
+	}
+	if cfg.SessionAffinity.CookieName == "" {
+		cfg.SessionAffinity.CookieName = "LB_SESSION"
+	}
+
+	// 设置被动健康检查默认值
+	if od := cfg.OutlierDetection; od != nil {
+		if od.ConsecutiveErrors == 0 {
+			od.ConsecutiveErrors = 5
+		}
+		if od.ErrorRate == 0 {
+			od.ErrorRate = 0.5
+		}
+		if od.MinRequests == 0 {
+			od.MinRequests = 10
+		}
+		if od.Window == 0 {
+			od.Window = 10 * time.Second
+		}
+		if od.EjectionTime == 0 {
+			od.EjectionTime = 30 * time.Second
+		}
+		if od.MaxEjectionTime == 0 {
+			od.MaxEjectionTime = 5 * time.Minute
+		}
+		if od.MaxEjectionTime < od.EjectionTime {
+			od.MaxEjectionTime = od.EjectionTime
+		}
+		if od.MaxEjectionPercent == 0 {
+			od.MaxEjectionPercent = 50
+		}
+		if od.RampTime == 0 {
+			od.RampTime = 30 * time.Second
+		}
+	}
 
 	// 设置后端服务器默认值
 	for i := range cfg.Backends {
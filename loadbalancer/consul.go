@@ -0,0 +1,126 @@
+package loadbalancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultConsulAddress Consul HTTP API的默认地址
+const defaultConsulAddress = "http://127.0.0.1:8500"
+
+// consulWait 阻塞查询的最长等待时间，超时后返回当前结果并重新查询
+const consulWait = 5 * time.Minute
+
+// consulSource 通过健康检查API的阻塞查询监视Consul服务，只使用所有检查都通过的实例
+type consulSource struct {
+	config ConsulDiscoveryConfig
+	client *http.Client
+	index  uint64 // 上一次查询返回的X-Consul-Index，为0时查询立即返回，否则阻塞到索引变化
+}
+
+// consulEntry /v1/health/service 返回的实例
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// newConsulSource 创建Consul服务发现
+func newConsulSource(config ConsulDiscoveryConfig) *consulSource {
+	if config.Address == "" {
+		config.Address = defaultConsulAddress
+	}
+	if config.Scheme == "" {
+		config.Scheme = "http"
+	}
+	return &consulSource{
+		config: config,
+		// 超时需要长于阻塞查询的等待时间（Consul会额外增加最多1/16的随机等待）
+		client: &http.Client{Timeout: consulWait + consulWait/16 + 30*time.Second},
+	}
+}
+
+// String 返回注册中心和服务名称
+func (s *consulSource) String() string {
+	return "consul service " + s.config.Service
+}
+
+// watch 查询服务的健康实例，之前查询过时阻塞到实例变化或等待超时
+func (s *consulSource) watch(ctx context.Context) ([]endpoint, error) {
+	query := url.Values{}
+	query.Set("passing", "true")
+	if s.config.Tag != "" {
+		query.Set("tag", s.config.Tag)
+	}
+	if s.config.Datacenter != "" {
+		query.Set("dc", s.config.Datacenter)
+	}
+	if s.index > 0 {
+		query.Set("index", strconv.FormatUint(s.index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(consulWait/time.Second)))
+	}
+	endpointURL := strings.TrimSuffix(s.config.Address, "/") + "/v1/health/service/" + url.PathEscape(s.config.Service) + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpointURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.config.Token != "" {
+		req.Header.Set("X-Consul-Token", s.config.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("consul returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid consul response: %v", err)
+	}
+
+	// 下一次查询按本次返回的索引等待变化。索引变小（如Consul重建了数据）时同样使用新索引，不能沿用旧索引；
+	// 没有返回索引时使用1，避免不带索引的查询立即返回
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if index == 0 {
+		index = 1
+	}
+	s.index = index
+
+	endpoints := make([]endpoint, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		if address == "" || entry.Service.Port == 0 {
+			continue
+		}
+		endpoints = append(endpoints, endpoint{
+			URL:    s.config.Scheme + "://" + net.JoinHostPort(address, strconv.Itoa(entry.Service.Port)),
+			Weight: entry.Service.Weights.Passing,
+		})
+	}
+	return sortEndpoints(endpoints), nil
+}
@@ -0,0 +1,197 @@
+package loadbalancer
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// DiscoveryConfig 服务注册中心配置，consul和etcd只配置一个
+type DiscoveryConfig struct {
+	Consul *ConsulDiscoveryConfig `yaml:"consul"`
+	Etcd   *EtcdDiscoveryConfig   `yaml:"etcd"`
+}
+
+// ConsulDiscoveryConfig Consul服务发现配置
+type ConsulDiscoveryConfig struct {
+	Address    string `yaml:"address"`    // HTTP API地址，默认 http://127.0.0.1:8500
+	Service    string `yaml:"service"`    // 服务名称
+	Tag        string `yaml:"tag"`        // 实例标签过滤
+	Datacenter string `yaml:"datacenter"` // 数据中心
+	Token      string `yaml:"token"`      // ACL令牌
+	Scheme     string `yaml:"scheme"`     // 访问实例的协议，默认http
+}
+
+// EtcdDiscoveryConfig etcd服务发现配置
+type EtcdDiscoveryConfig struct {
+	Endpoint string `yaml:"endpoint"` // HTTP API地址，默认 http://127.0.0.1:2379
+	Prefix   string `yaml:"prefix"`   // 后端所在的键前缀
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Scheme   string `yaml:"scheme"` // 值只有地址时使用的协议，默认http
+}
+
+// discoveryTimeout 创建负载均衡器时第一次查询注册中心的超时
+const discoveryTimeout = 5 * time.Second
+
+// 注册中心不可用时重试的间隔，每次失败后加倍
+const (
+	discoveryMinBackoff = time.Second
+	discoveryMaxBackoff = 30 * time.Second
+)
+
+// endpoint 注册中心中一个健康的实例
+type endpoint struct {
+	URL    string
+	Weight int
+}
+
+// discoverySource 服务注册中心。watch第一次调用时立即返回当前健康的实例，之后阻塞到实例变化或等待超时再返回；
+// 同一时间只在一个goroutine中调用
+type discoverySource interface {
+	watch(ctx context.Context) ([]endpoint, error)
+	String() string
+}
+
+// newDiscoverySource 按配置创建注册中心，没有配置时返回nil
+func newDiscoverySource(config *DiscoveryConfig) discoverySource {
+	switch {
+	case config == nil:
+		return nil
+	case config.Consul != nil:
+		return newConsulSource(*config.Consul)
+	case config.Etcd != nil:
+		return newEtcdSource(*config.Etcd)
+	}
+	return nil
+}
+
+// sortEndpoints 按URL排序并去掉重复的实例，重复时保留第一个
+func sortEndpoints(endpoints []endpoint) []endpoint {
+	seen := make(map[string]bool, len(endpoints))
+	result := make([]endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		if !seen[e.URL] {
+			seen[e.URL] = true
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].URL < result[j].URL })
+	return result
+}
+
+// newRegistryBackend 创建注册中心中的实例对应的后端，使用负载均衡器的健康检查配置
+func (lb *BaseLoadBalancer) newRegistryBackend(e endpoint) *Backend {
+	weight := e.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return &Backend{
+		URL:          e.URL,
+		Weight:       weight,
+		discoveredBy: lb.discovery.String(),
+	}
+}
+
+// discover 创建负载均衡器时查询一次注册中心，查询失败时没有对应的后端，之后由监视重试
+func (lb *BaseLoadBalancer) discover() {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	endpoints, err := lb.discovery.watch(ctx)
+	if err != nil {
+		log.Printf("Failed to discover backends from %s: %v", lb.discovery, err)
+		return
+	}
+	for _, e := range endpoints {
+		backend := lb.newRegistryBackend(e)
+		backend.Active = true
+		backend.LoadHint = fullLoadHint
+		lb.backends = append(lb.backends, backend)
+	}
+	log.Printf("Discovered %d backends from %s", len(endpoints), lb.discovery)
+}
+
+// startDiscoveryWatch 开始监视注册中心，实例变化时同步后端
+func (lb *BaseLoadBalancer) startDiscoveryWatch() {
+	if lb.discovery == nil || lb.discoveryWatch != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lb.discoveryWatch = cancel
+
+	go func() {
+		backoff := discoveryMinBackoff
+		for {
+			endpoints, err := lb.discovery.watch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to watch %s, keeping the current backends: %v", lb.discovery, err)
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+					return
+				}
+				if backoff *= 2; backoff > discoveryMaxBackoff {
+					backoff = discoveryMaxBackoff
+				}
+				continue
+			}
+			backoff = discoveryMinBackoff
+
+			// 注册中心没有健康的实例时保留当前的后端，避免注册中心故障或误操作时清空后端
+			if len(endpoints) == 0 {
+				log.Printf("No healthy instances in %s, keeping the current backends", lb.discovery)
+				continue
+			}
+			backends := make([]*Backend, len(endpoints))
+			for i, e := range endpoints {
+				backends[i] = lb.newRegistryBackend(e)
+			}
+			lb.applyDiscovered(lb.discovery.String(), backends)
+		}
+	}()
+}
+
+// stopDiscoveryWatch 停止监视注册中心
+func (lb *BaseLoadBalancer) stopDiscoveryWatch() {
+	if lb.discoveryWatch != nil {
+		lb.discoveryWatch()
+		lb.discoveryWatch = nil
+	}
+}
+
+// applyDiscovered 按发现的结果更新来源对应的后端：加入新的后端，移除不再出现的后端，先加入再移除；
+// 已有的后端保留健康状态、摘除状态和通过管理API修改的权重
+func (lb *BaseLoadBalancer) applyDiscovered(source string, discovered []*Backend) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	current := make(map[string]bool, len(discovered))
+	for _, backend := range discovered {
+		current[backend.URL] = true
+	}
+
+	// 同一地址已作为其他后端存在时不重复加入
+	existing := make(map[string]bool, len(lb.backends))
+	var stale []*Backend
+	for _, backend := range lb.backends {
+		existing[backend.URL] = true
+		if backend.discoveredBy == source && !current[backend.URL] {
+			stale = append(stale, backend)
+		}
+	}
+
+	for _, backend := range discovered {
+		if !existing[backend.URL] {
+			existing[backend.URL] = true
+			lb.addBackend(backend)
+		}
+	}
+	for _, backend := range stale {
+		lb.removeBackend(backend)
+	}
+}
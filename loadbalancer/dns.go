@@ -0,0 +1,149 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dnsSchemePrefix 后端URL带该前缀（dns+http、dns+https）时，按域名解析出的每个IP地址创建一个后端
+const dnsSchemePrefix = "dns+"
+
+// DefaultDNSRefresh 重新解析后端域名的默认间隔
+const DefaultDNSRefresh = 30 * time.Second
+
+// dnsResolveTimeout 单次解析的超时
+const dnsResolveTimeout = 5 * time.Second
+
+// dnsTemplate 需要DNS发现的后端配置，解析出的后端复制其权重和健康检查配置
+type dnsTemplate struct {
+	backend Backend
+	scheme  string
+	host    string
+	port    string
+	path    string
+}
+
+// isDNSBackend 后端URL是否需要DNS发现
+func isDNSBackend(backendURL string) bool {
+	return strings.HasPrefix(backendURL, dnsSchemePrefix)
+}
+
+// parseDNSTemplate 解析 dns+http://host:port/path 形式的后端
+func parseDNSTemplate(backend Backend) (dnsTemplate, error) {
+	u, err := url.Parse(strings.TrimPrefix(backend.URL, dnsSchemePrefix))
+	if err != nil {
+		return dnsTemplate{}, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return dnsTemplate{}, fmt.Errorf("invalid DNS backend '%s', expected e.g. dns+http://api.internal:8080", backend.URL)
+	}
+	return dnsTemplate{
+		backend: backend,
+		scheme:  u.Scheme,
+		host:    u.Hostname(),
+		port:    u.Port(),
+		path:    strings.TrimSuffix(u.EscapedPath(), "/"),
+	}, nil
+}
+
+// resolve 查询域名的A和AAAA记录，返回每个IP地址对应的后端URL（按地址排序）以及记录的TTL，TTL未知时为0
+func (t dnsTemplate) resolve() ([]string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsResolveTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, t.host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl, _ := lookupTTL(ctx, t.host)
+
+	seen := make(map[string]bool, len(addrs))
+	urls := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		host := addr.IP.String()
+		if t.port != "" {
+			host = net.JoinHostPort(host, t.port)
+		} else if addr.IP.To4() == nil {
+			host = "[" + host + "]"
+		}
+		backendURL := t.scheme + "://" + host + t.path
+		if !seen[backendURL] {
+			seen[backendURL] = true
+			urls = append(urls, backendURL)
+		}
+	}
+	sort.Strings(urls)
+	return urls, ttl, nil
+}
+
+// newDiscoveredBackend 创建解析出的后端
+func (t dnsTemplate) newDiscoveredBackend(backendURL string) *Backend {
+	return &Backend{
+		URL:          backendURL,
+		Weight:       t.backend.Weight,
+		HealthCheck:  t.backend.HealthCheck,
+		discoveredBy: t.backend.URL,
+	}
+}
+
+// refreshDNS 重新解析所有需要DNS发现的后端，加入新的IP地址，移除不再解析到的地址，返回记录中最小的TTL（未知时为0）；
+// 解析失败时保留上一次的结果，避免DNS故障时清空后端
+func (lb *BaseLoadBalancer) refreshDNS() time.Duration {
+	var minTTL time.Duration
+	for _, template := range lb.dnsTemplates {
+		urls, ttl, err := template.resolve()
+		if err != nil {
+			log.Printf("Failed to resolve backend %s, keeping the current backends: %v", template.backend.URL, err)
+			continue
+		}
+		if len(urls) == 0 {
+			log.Printf("Backend %s resolved to no addresses, keeping the current backends", template.backend.URL)
+			continue
+		}
+		backends := make([]*Backend, len(urls))
+		for i, backendURL := range urls {
+			backends[i] = template.newDiscoveredBackend(backendURL)
+		}
+		lb.applyDiscovered(template.backend.URL, backends)
+		if ttl > 0 && (minTTL == 0 || ttl < minTTL) {
+			minTTL = ttl
+		}
+	}
+	return minTTL
+}
+
+// startDNSWatch 开始定期重新解析需要DNS发现的后端，间隔按记录的TTL确定，不超过dns_refresh
+func (lb *BaseLoadBalancer) startDNSWatch() {
+	if len(lb.dnsTemplates) == 0 || lb.dnsWatch != nil {
+		return
+	}
+	lb.dnsWatch = make(chan struct{})
+
+	go func(stop chan struct{}, delay time.Duration) {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+				timer.Reset(lb.dnsRefreshDelay(lb.refreshDNS()))
+			case <-stop:
+				return
+			}
+		}
+	}(lb.dnsWatch, lb.dnsRefreshDelay(lb.dnsTTL))
+}
+
+// stopDNSWatch 停止重新解析
+func (lb *BaseLoadBalancer) stopDNSWatch() {
+	if lb.dnsWatch != nil {
+		close(lb.dnsWatch)
+		lb.dnsWatch = nil
+	}
+}
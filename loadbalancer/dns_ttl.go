@@ -0,0 +1,162 @@
+package loadbalancer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// minDNSRefresh 按TTL重新解析的最短间隔，TTL为0或很短的记录不会导致频繁解析
+const minDNSRefresh = time.Second
+
+// errNoTTL 查询不到记录的TTL
+var errNoTTL = errors.New("record TTL is not available")
+
+// dnsServers 返回查询TTL使用的DNS服务器地址（host:port），默认读取/etc/resolv.conf，测试时替换
+var dnsServers = func() []string {
+	return nameservers("/etc/resolv.conf")
+}
+
+// nameservers 读取resolv.conf中的nameserver，文件不存在（如Windows）时返回nil
+func nameservers(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	return servers
+}
+
+// lookupTTL 直接向DNS服务器查询域名的A和AAAA记录，返回应答中最小的TTL（包括CNAME）。
+// Go的解析器不返回TTL，后端地址仍由解析器提供（/etc/hosts、搜索域的处理不变），TTL只用于确定下一次解析的时间；
+// 域名只在/etc/hosts中或需要搜索域补全时查询不到记录，返回errNoTTL
+func lookupTTL(ctx context.Context, host string) (time.Duration, error) {
+	if net.ParseIP(host) != nil {
+		return 0, errNoTTL
+	}
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return 0, err
+	}
+
+	lastErr := errNoTTL
+	for _, server := range dnsServers() {
+		ttl, found, err := queryTTL(ctx, server, name)
+		if err != nil {
+			// 该服务器查询失败，尝试下一个
+			lastErr = err
+			continue
+		}
+		if !found {
+			return 0, errNoTTL
+		}
+		return ttl, nil
+	}
+	return 0, lastErr
+}
+
+// queryTTL 向一个DNS服务器查询A和AAAA记录，found表示是否有地址记录；
+// 返回的TTL不小于minDNSRefresh，0只表示TTL未知
+func queryTTL(ctx context.Context, server string, name dnsmessage.Name) (time.Duration, bool, error) {
+	var ttl time.Duration
+	found, answered := false, false
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, err := exchangeDNS(ctx, server, name, qtype)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, answer := range answers {
+			if answer.Header.Type == dnsmessage.TypeA || answer.Header.Type == dnsmessage.TypeAAAA {
+				found = true
+			}
+			if recordTTL := time.Duration(answer.Header.TTL) * time.Second; !answered || recordTTL < ttl {
+				ttl = recordTTL
+			}
+			answered = true
+		}
+	}
+	if ttl < minDNSRefresh {
+		ttl = minDNSRefresh
+	}
+	return ttl, found, nil
+}
+
+// exchangeDNS 通过UDP发送一次查询，返回应答记录；域名不存在时返回空应答
+func exchangeDNS(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	id := uint16(rand.Uint32())
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	query, err := builder.Finish()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1232)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || msg.ID != id || !msg.Response {
+			// 忽略无法解析或不属于本次查询的报文
+			continue
+		}
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess:
+			return msg.Answers, nil
+		case dnsmessage.RCodeNameError:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("DNS server %s returned %v", server, msg.RCode)
+		}
+	}
+}
+
+// dnsRefreshDelay 返回下一次重新解析的等待时间：记录的TTL短于dns_refresh时按TTL，
+// 否则或TTL未知（为0）时按dns_refresh
+func (lb *BaseLoadBalancer) dnsRefreshDelay(ttl time.Duration) time.Duration {
+	interval := lb.config.DNSRefresh
+	if interval <= 0 {
+		interval = DefaultDNSRefresh
+	}
+	if ttl <= 0 || ttl >= interval {
+		return interval
+	}
+	return ttl
+}
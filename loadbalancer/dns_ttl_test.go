@@ -0,0 +1,128 @@
+package loadbalancer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newTTLServer 启动UDP DNS服务器：app.example.test返回TTL为5秒的CNAME和TTL为30秒的A记录，其他域名返回NXDOMAIN
+func newTTLServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			question := query.Questions[0]
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: dnsmessage.RCodeNameError},
+				Questions: query.Questions,
+			}
+			if question.Name.String() == "app.example.test." {
+				reply.RCode = dnsmessage.RCodeSuccess
+				backend := dnsmessage.MustNewName("backend.example.test.")
+				reply.Answers = append(reply.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 5},
+					Body:   &dnsmessage.CNAMEResource{CNAME: backend},
+				})
+				if question.Type == dnsmessage.TypeA {
+					reply.Answers = append(reply.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: backend, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 30},
+						Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+					})
+				}
+			}
+			packed, err := reply.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// useDNSServers 在测试期间把TTL查询指向servers
+func useDNSServers(t *testing.T, servers ...string) {
+	t.Helper()
+	original := dnsServers
+	dnsServers = func() []string { return servers }
+	t.Cleanup(func() { dnsServers = original })
+}
+
+func TestLookupTTL(t *testing.T) {
+	useDNSServers(t, newTTLServer(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 取应答中最小的TTL，包括CNAME
+	ttl, err := lookupTTL(ctx, "app.example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != 5*time.Second {
+		t.Errorf("ttl = %v, want 5s", ttl)
+	}
+
+	for _, host := range []string{"missing.example.test", "10.0.0.1", "::1"} {
+		if _, err := lookupTTL(ctx, host); err != errNoTTL {
+			t.Errorf("lookupTTL(%q) error = %v, want errNoTTL", host, err)
+		}
+	}
+}
+
+func TestLookupTTLTriesNextServer(t *testing.T) {
+	// 第一个服务器不可达时查询下一个
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := closed.LocalAddr().String()
+	closed.Close()
+	useDNSServers(t, unreachable, newTTLServer(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if ttl, err := lookupTTL(ctx, "app.example.test"); err != nil || ttl != 5*time.Second {
+		t.Errorf("lookupTTL = %v, %v, want 5s", ttl, err)
+	}
+}
+
+func TestDNSRefreshDelay(t *testing.T) {
+	lb := &BaseLoadBalancer{config: LoadBalancerConfig{DNSRefresh: time.Minute}}
+	tests := []struct {
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{0, time.Minute},
+		{5 * time.Second, 5 * time.Second},
+		{time.Hour, time.Minute},
+	}
+	for _, test := range tests {
+		if got := lb.dnsRefreshDelay(test.ttl); got != test.want {
+			t.Errorf("dnsRefreshDelay(%v) = %v, want %v", test.ttl, got, test.want)
+		}
+	}
+
+	// 未配置dns_refresh时以默认间隔为上限
+	lb.config.DNSRefresh = 0
+	if got := lb.dnsRefreshDelay(time.Hour); got != DefaultDNSRefresh {
+		t.Errorf("dnsRefreshDelay without dns_refresh = %v, want %v", got, DefaultDNSRefresh)
+	}
+}
@@ -0,0 +1,143 @@
+package loadbalancer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// drainFilePollInterval 检查部署标记文件的间隔
+const drainFilePollInterval = 2 * time.Second
+
+// SetDraining 通过管理API摘除或恢复后端服务器。摘除的后端不再被选中，已转发的请求正常完成，
+// 健康检查暂停；恢复时如果启用了健康检查，后端需要先通过一次检查才重新接收请求
+func (lb *BaseLoadBalancer) SetDraining(url string, draining bool) error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	backend := lb.find(url)
+	if backend == nil {
+		return fmt.Errorf("backend '%s' not found", url)
+	}
+	backend.drainRequested = draining
+	lb.updateDraining(backend, "admin API")
+	return nil
+}
+
+// isDraining 返回后端是否已被摘除
+func (lb *BaseLoadBalancer) isDraining(backend *Backend) bool {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return backend.Draining
+}
+
+// updateDraining 按两个来源更新后端的摘除状态，调用方需要持有写锁
+func (lb *BaseLoadBalancer) updateDraining(backend *Backend, source string) {
+	draining := backend.drainRequested || backend.drainFlagged
+	if draining == backend.Draining {
+		return
+	}
+	backend.Draining = draining
+
+	if draining {
+		log.Printf("Backend %s is draining (%s), %d requests in flight", backend.URL, source, backend.Connections)
+		return
+	}
+
+	// 部署后的后端先通过健康检查再接收请求，不依赖检查失败或成功的时机
+	if lb.healthCheck != nil && (backend.HealthCheck.Enabled || lb.config.HealthCheck.Enabled) {
+		backend.Active = false
+		go lb.healthCheck.checkBackend(backend)
+		log.Printf("Backend %s is no longer draining (%s), waiting for a health check", backend.URL, source)
+		return
+	}
+	log.Printf("Backend %s is no longer draining (%s)", backend.URL, source)
+}
+
+// startDrainWatch 开始监视部署标记文件，立即读取一次
+func (lb *BaseLoadBalancer) startDrainWatch() {
+	if lb.config.DrainFile == "" || lb.drainWatch != nil {
+		return
+	}
+	lb.drainWatch = make(chan struct{})
+	lb.applyDrainFile()
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(drainFilePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				lb.applyDrainFile()
+			case <-stop:
+				return
+			}
+		}
+	}(lb.drainWatch)
+}
+
+// stopDrainWatch 停止监视部署标记文件
+func (lb *BaseLoadBalancer) stopDrainWatch() {
+	if lb.drainWatch != nil {
+		close(lb.drainWatch)
+		lb.drainWatch = nil
+	}
+}
+
+// applyDrainFile 读取部署标记文件，摘除其中列出的后端，恢复不再列出的后端；文件不存在时不摘除任何后端
+func (lb *BaseLoadBalancer) applyDrainFile() {
+	entries, err := readDrainFile(lb.config.DrainFile)
+	if err != nil {
+		log.Printf("Failed to read drain file %s: %v", lb.config.DrainFile, err)
+		return
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	flagged := make(map[*Backend]bool)
+	for _, entry := range entries {
+		backend := lb.find(entry)
+		if backend == nil && !strings.Contains(entry, "://") {
+			// 只写了host:port时按http和https查找
+			if backend = lb.find("http://" + entry); backend == nil {
+				backend = lb.find("https://" + entry)
+			}
+		}
+		if backend != nil {
+			flagged[backend] = true
+		}
+	}
+
+	for _, backend := range lb.backends {
+		backend.drainFlagged = flagged[backend]
+		lb.updateDraining(backend, "drain file")
+	}
+}
+
+// readDrainFile 读取部署标记文件，每行一个后端地址（URL或host:port），忽略空行和#开头的注释
+func readDrainFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.TrimSuffix(line, "/"))
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,281 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEtcdEndpoint etcd HTTP API的默认地址
+const defaultEtcdEndpoint = "http://127.0.0.1:2379"
+
+// etcdWatchTimeout 单次watch的最长时间，超时后重新读取前缀下的后端并重新watch，连接被静默断开时也能恢复
+const etcdWatchTimeout = 5 * time.Minute
+
+// etcdSource 通过etcd v3 API（gRPC网关的JSON接口）读取前缀下的后端并watch变化。
+// 每个键的值是后端URL（http://10.0.0.5:8080）、地址（10.0.0.5:8080），或JSON对象
+// {"url": "...", "weight": 2, "healthy": true}，其中url也可以写成addr；healthy为false的后端不使用
+type etcdSource struct {
+	config   EtcdDiscoveryConfig
+	client   *http.Client
+	revision int64 // 上一次读取时的修订号，为0时还没有读取过
+}
+
+// etcdKeyValue etcd返回的键值，键和值为base64编码
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// etcdHeader etcd响应头，64位整数编码为字符串
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+// etcdValue JSON格式的后端
+type etcdValue struct {
+	URL     string `json:"url"`
+	Addr    string `json:"addr"`
+	Weight  int    `json:"weight"`
+	Healthy *bool  `json:"healthy"`
+}
+
+// newEtcdSource 创建etcd服务发现
+func newEtcdSource(config EtcdDiscoveryConfig) *etcdSource {
+	if config.Endpoint == "" {
+		config.Endpoint = defaultEtcdEndpoint
+	}
+	if config.Scheme == "" {
+		config.Scheme = "http"
+	}
+	return &etcdSource{
+		config: config,
+		client: &http.Client{},
+	}
+}
+
+// String 返回注册中心和键前缀
+func (s *etcdSource) String() string {
+	return "etcd prefix " + s.config.Prefix
+}
+
+// watch 读取前缀下的后端，之前读取过时先watch到前缀下的键变化或watch超时
+func (s *etcdSource) watch(ctx context.Context) ([]endpoint, error) {
+	token, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.revision > 0 {
+		if err := s.waitForChange(ctx, token); err != nil {
+			return nil, err
+		}
+	}
+	return s.list(ctx, token)
+}
+
+// rangeEnd 返回前缀对应的范围结束键：前缀最后一个不为0xff的字节加1
+func (s *etcdSource) rangeEnd() []byte {
+	end := []byte(s.config.Prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// 前缀全部为0xff时读取之后的所有键
+	return []byte{0}
+}
+
+// list 读取前缀下的所有后端并记录修订号
+func (s *etcdSource) list(ctx context.Context, token string) ([]endpoint, error) {
+	var result struct {
+		Header etcdHeader     `json:"header"`
+		Kvs    []etcdKeyValue `json:"kvs"`
+	}
+	err := s.call(ctx, token, "/v3/kv/range", map[string]interface{}{
+		"key":       []byte(s.config.Prefix),
+		"range_end": s.rangeEnd(),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := strconv.ParseInt(result.Header.Revision, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid etcd revision '%s'", result.Header.Revision)
+	}
+	s.revision = revision
+
+	endpoints := make([]endpoint, 0, len(result.Kvs))
+	for _, kv := range result.Kvs {
+		key, _ := base64.StdEncoding.DecodeString(kv.Key)
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			continue
+		}
+		e, ok, err := s.parseValue(value)
+		if err != nil {
+			log.Printf("Ignoring etcd key %s: %v", key, err)
+			continue
+		}
+		if ok {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return sortEndpoints(endpoints), nil
+}
+
+// parseValue 解析键的值，后端不健康时返回false
+func (s *etcdSource) parseValue(value []byte) (endpoint, bool, error) {
+	value = bytes.TrimSpace(value)
+	var v etcdValue
+	if len(value) > 0 && value[0] == '{' {
+		if err := json.Unmarshal(value, &v); err != nil {
+			return endpoint{}, false, err
+		}
+		if v.Healthy != nil && !*v.Healthy {
+			return endpoint{}, false, nil
+		}
+	} else {
+		v.URL = string(value)
+	}
+
+	backendURL := v.URL
+	if backendURL == "" {
+		backendURL = v.Addr
+	}
+	if !strings.Contains(backendURL, "://") {
+		backendURL = s.config.Scheme + "://" + backendURL
+	}
+	u, err := url.Parse(backendURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return endpoint{}, false, fmt.Errorf("invalid backend '%s'", value)
+	}
+	return endpoint{URL: strings.TrimSuffix(backendURL, "/"), Weight: v.Weight}, true, nil
+}
+
+// waitForChange watch前缀下上一次读取之后的变化，收到变化、修订号已被压缩或watch超时时返回
+func (s *etcdSource) waitForChange(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, etcdWatchTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(s.config.Prefix),
+			"range_end":      s.rangeEnd(),
+			"start_revision": strconv.FormatInt(s.revision+1, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := s.post(ctx, token, "/v3/watch", body)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 响应是连续的JSON对象，第一个确认watch已创建，之后每个包含一批变化
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Result struct {
+				Events          []json.RawMessage `json:"events"`
+				Canceled        bool              `json:"canceled"`
+				CompactRevision string            `json:"compact_revision"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			// watch超时时重新读取，和收到变化一样
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil
+			}
+			return fmt.Errorf("etcd watch: %v", err)
+		}
+		if message.Error != nil {
+			return fmt.Errorf("etcd watch: %s", message.Error.Message)
+		}
+		result := message.Result
+		// 修订号已被压缩时无法从上一次读取的位置watch，重新读取即可
+		if len(result.Events) > 0 || result.CompactRevision != "" || result.Canceled {
+			return nil
+		}
+	}
+}
+
+// authenticate 配置了用户名时获取认证令牌
+func (s *etcdSource) authenticate(ctx context.Context) (string, error) {
+	if s.config.Username == "" {
+		return "", nil
+	}
+	var result struct {
+		Token string `json:"token"`
+	}
+	err := s.call(ctx, "", "/v3/auth/authenticate", map[string]string{
+		"name":     s.config.Username,
+		"password": s.config.Password,
+	}, &result)
+	if err != nil {
+		return "", fmt.Errorf("etcd authentication failed: %v", err)
+	}
+	return result.Token, nil
+}
+
+// call 调用etcd API并解析JSON响应
+func (s *etcdSource) call(ctx context.Context, token, path string, request, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+
+	resp, err := s.post(ctx, token, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid etcd response: %v", err)
+	}
+	return nil
+}
+
+// post 发送请求，非200响应返回错误
+func (s *etcdSource) post(ctx context.Context, token, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.config.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("etcd returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
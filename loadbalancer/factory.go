@@ -46,6 +46,8 @@ func (f *DefaultLoadBalancerFactory) CreateLoadBalancer(config LoadBalancerConfi
 		lb = NewRandomLoadBalancer(config)
 	case WeightedRandom:
 		lb = NewWeightedRandomLoadBalancer(config)
+	case LeastOutstanding:
+		lb = NewLeastOutstandingLoadBalancer(config)
 	default:
 		return nil, fmt.Errorf("unsupported load balancer strategy: %s", config.Strategy)
 	}
@@ -68,6 +70,7 @@ func (f *DefaultLoadBalancerFactory) GetSupportedStrategies() []LoadBalancerStra
 		ResponseTime,
 		Random,
 		WeightedRandom,
+		LeastOutstanding,
 	}
 }
 
@@ -88,8 +91,8 @@ func (f *DefaultLoadBalancerFactory) validateConfig(config LoadBalancerConfig) e
 	}
 
 	// 检查后端列表
-	if len(config.Backends) == 0 {
-		return fmt.Errorf("at least one backend is required")
+	if len(config.Backends) == 0 && config.Discovery == nil {
+		return fmt.Errorf("at least one backend or discovery is required")
 	}
 
 	// 检查每个后端
@@ -0,0 +1,106 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newHealthCheckedBackend 启动记录健康检查次数的后端，healthy为false时返回503
+func newHealthCheckedBackend(t *testing.T, healthy *atomic.Bool) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var checks atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &checks
+}
+
+// newHealthCheckedLoadBalancer 创建每10毫秒检查一次backend的负载均衡器
+func newHealthCheckedLoadBalancer(backend string) *BaseLoadBalancer {
+	return NewBaseLoadBalancer(LoadBalancerConfig{
+		Strategy: RoundRobin,
+		Backends: []Backend{{URL: backend, Weight: 1}},
+		HealthCheck: HealthCheckConfig{
+			Enabled:  true,
+			Interval: 10 * time.Millisecond,
+			Timeout:  time.Second,
+			Path:     "/health",
+		},
+	})
+}
+
+// waitFor 等待cond成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestHealthCheckerStopBeforeStart(t *testing.T) {
+	var healthy atomic.Bool
+	backend, checks := newHealthCheckedBackend(t, &healthy)
+	hc := NewHealthChecker(newHealthCheckedLoadBalancer(backend.URL))
+
+	// 未启动时停止不做任何操作，之后仍然可以启动
+	hc.Stop()
+	hc.Stop()
+	hc.Start()
+	defer hc.Stop()
+	waitFor(t, "the first health check", func() bool { return checks.Load() > 0 })
+}
+
+func TestHealthCheckerStopTwice(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	backend, checks := newHealthCheckedBackend(t, &healthy)
+	hc := NewHealthChecker(newHealthCheckedLoadBalancer(backend.URL))
+
+	hc.Start()
+	waitFor(t, "the first health check", func() bool { return checks.Load() > 0 })
+	hc.Stop()
+	hc.Stop()
+
+	// 停止后不再检查；已经发出的检查可能还在进行，等待其结束后再计数
+	time.Sleep(50 * time.Millisecond)
+	stopped := checks.Load()
+	time.Sleep(50 * time.Millisecond)
+	if n := checks.Load(); n != stopped {
+		t.Errorf("%d health checks after Stop", n-stopped)
+	}
+}
+
+func TestHealthCheckerRestart(t *testing.T) {
+	var healthy atomic.Bool
+	backend, checks := newHealthCheckedBackend(t, &healthy)
+	lb := newHealthCheckedLoadBalancer(backend.URL)
+	hc := NewHealthChecker(lb)
+	active := func() bool { return lb.GetBackends()[0].Active }
+
+	hc.Start()
+	waitFor(t, "the backend to be marked inactive", func() bool { return !active() })
+	hc.Stop()
+
+	// 再次启动时保留停止前的检查结果，不重新标记为活跃
+	hc.Start()
+	defer hc.Stop()
+	if active() {
+		t.Error("restart marked the unhealthy backend active")
+	}
+
+	// 重新启动后继续检查
+	healthy.Store(true)
+	before := checks.Load()
+	waitFor(t, "health checks to resume", func() bool { return checks.Load() > before })
+	waitFor(t, "the backend to recover", active)
+}
@@ -0,0 +1,157 @@
+package loadbalancer
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// LeastOutstandingLoadBalancer 子集内最少未完成请求负载均衡器。
+//
+// 每个代理实例按实例标识对后端做rendezvous哈希排序，只使用排在前面的Size个可用后端（确定性子集），
+// 在子集内选择未完成请求最少的后端。后端不可用时由排序中的下一个后端补上，其余后端的归属不变；
+// 不同实例使用不同的标识时，各实例的子集均匀覆盖全部后端。选择时只扫描子集，不需要遍历全部后端
+type LeastOutstandingLoadBalancer struct {
+	*BaseLoadBalancer
+	key     string // 实例标识
+	subset  int    // 配置的子集大小，0表示全部后端
+	size    int
+	ranking []*Backend // 按rendezvous哈希排序的后端
+	next    uint64     // 未完成请求数相同时轮流选择
+}
+
+// NewLeastOutstandingLoadBalancer 创建子集内最少未完成请求负载均衡器
+func NewLeastOutstandingLoadBalancer(config LoadBalancerConfig) *LeastOutstandingLoadBalancer {
+	key := config.Subset.Key
+	if key == "" {
+		key, _ = os.Hostname()
+	}
+
+	lb := &LeastOutstandingLoadBalancer{
+		BaseLoadBalancer: NewBaseLoadBalancer(config),
+		key:              key,
+		subset:           config.Subset.Size,
+	}
+	lb.rank()
+	lb.onChange = lb.rank
+
+	if lb.size < len(lb.backends) {
+		log.Printf("Load balancer subset for key '%s': %d of %d backends", key, lb.size, len(lb.backends))
+	}
+	return lb
+}
+
+// rank 按实例标识对后端排序并计算子集大小，创建时和通过管理API增删后端后调用，调用方需要持有写锁
+func (lb *LeastOutstandingLoadBalancer) rank() {
+	size := lb.subset
+	if size <= 0 || size > len(lb.backends) {
+		size = len(lb.backends)
+	}
+
+	// rendezvous哈希：按实例标识和后端地址的哈希值排序，增减后端只影响与其相关的实例
+	scores := make(map[*Backend]uint64, len(lb.backends))
+	ranking := make([]*Backend, len(lb.backends))
+	for i, backend := range lb.backends {
+		h := fnv.New64a()
+		h.Write([]byte(lb.key))
+		h.Write([]byte{0})
+		h.Write([]byte(backend.URL))
+		scores[backend] = h.Sum64()
+		ranking[i] = backend
+	}
+	sort.SliceStable(ranking, func(i, j int) bool {
+		return scores[ranking[i]] > scores[ranking[j]]
+	})
+
+	lb.size = size
+	lb.ranking = ranking
+}
+
+// NextBackend 选择下一个后端服务器
+func (lb *LeastOutstandingLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
+	return lb.selectBackend(nil)
+}
+
+// SelectBackend 在指定的后端中选择：优先选择子集内的后端，子集内的后端都不在candidates中时
+// 在candidates中选择未完成请求最少的后端
+func (lb *LeastOutstandingLoadBalancer) SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error) {
+	allowed := make(map[*Backend]bool, len(candidates))
+	for _, backend := range candidates {
+		allowed[backend] = true
+	}
+	if backend, err := lb.selectBackend(allowed); err == nil {
+		return backend, nil
+	}
+
+	var selected *Backend
+	for _, backend := range available(candidates) {
+		if selected == nil || backend.Connections < selected.Connections {
+			selected = backend
+		}
+	}
+	if selected == nil {
+		return nil, errors.New("no active backends available")
+	}
+	return selected, nil
+}
+
+// selectBackend 在子集内选择，allowed不为nil时只选择其中的后端
+func (lb *LeastOutstandingLoadBalancer) selectBackend(allowed map[*Backend]bool) (*Backend, error) {
+	next := atomic.AddUint64(&lb.next, 1)
+
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	if lb.size == 0 {
+		// dns+后端还没有解析到地址
+		return nil, errors.New("no active backends available")
+	}
+	start := int(next % uint64(lb.size))
+
+	// 负载提示为0或恢复期内本次未放行的后端不计入子集；没有剩余的后端时仍然从可用后端中选择
+	now := time.Now()
+	backend := lb.pick(now, start, true, allowed)
+	if backend == nil {
+		backend = lb.pick(now, start, false, allowed)
+	}
+	if backend == nil {
+		return nil, errors.New("no active backends available")
+	}
+	return backend, nil
+}
+
+// pick 在子集内选择未完成请求最少的后端，数量相同时从start开始轮流选择，调用方需要持有读锁
+// allowed不为nil时，不在其中的后端仍然占据子集中的位置，但不会被选择
+func (lb *LeastOutstandingLoadBalancer) pick(now time.Time, start int, strict bool, allowed map[*Backend]bool) *Backend {
+	var selected *Backend
+	selectedOrder := 0
+	position := 0
+	for _, backend := range lb.ranking {
+		if position >= lb.size {
+			break
+		}
+		if !backend.Active || backend.Draining || backend.ejected(now) {
+			continue
+		}
+		if strict && (backend.LoadHint <= 0 || !backend.admitted(now)) {
+			continue
+		}
+		if allowed != nil && !allowed[backend] {
+			position++
+			continue
+		}
+
+		order := (position - start + lb.size) % lb.size
+		if selected == nil || backend.Connections < selected.Connections ||
+			(backend.Connections == selected.Connections && order < selectedOrder) {
+			selected = backend
+			selectedOrder = order
+		}
+		position++
+	}
+	return selected
+}
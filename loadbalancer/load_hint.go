@@ -0,0 +1,123 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLoadHintBody 读取负载提示时健康检查响应体的大小上限
+const maxLoadHintBody = 64 << 10
+
+// readLoadHint 从健康检查响应中读取负载提示，响应头优先于JSON字段；
+// 没有报告或值无效时返回100（接收全部流量），超出范围的值截断到0-100
+func readLoadHint(resp *http.Response, config HealthCheckConfig) int {
+	if config.LoadHintHeader != "" {
+		if value := resp.Header.Get(config.LoadHintHeader); value != "" {
+			if hint, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "%")), 64); err == nil {
+				return clampLoadHint(hint)
+			}
+		}
+	}
+
+	if config.LoadHintField != "" {
+		var body interface{}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxLoadHintBody)).Decode(&body); err == nil {
+			if hint, ok := jsonNumber(body, strings.Split(config.LoadHintField, ".")); ok {
+				return clampLoadHint(hint)
+			}
+		}
+	}
+
+	return fullLoadHint
+}
+
+// jsonNumber 按字段路径查找JSON中的数字，数字形式的字符串同样接受
+func jsonNumber(value interface{}, path []string) (float64, bool) {
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		if value, ok = object[key]; !ok {
+			return 0, false
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// clampLoadHint 把负载提示截断到0-100
+func clampLoadHint(hint float64) int {
+	if math.IsNaN(hint) || hint < 0 {
+		return 0
+	}
+	if hint > fullLoadHint {
+		return fullLoadHint
+	}
+	return int(math.Round(hint))
+}
+
+// available 排除负载提示为0的后端以及被动健康检查恢复期内本次未放行的后端；
+// 没有剩余的后端时仍然返回全部后端，避免请求无处可去
+func available(backends []*Backend) []*Backend {
+	now := time.Now()
+	result := make([]*Backend, 0, len(backends))
+	for _, backend := range backends {
+		if backend.LoadHint > 0 && backend.admitted(now) {
+			result = append(result, backend)
+		}
+	}
+	if len(result) == 0 {
+		return backends
+	}
+	return result
+}
+
+// effectiveWeights 返回后端按负载提示调整后的权重及其总和，权重除以最大公约数，
+// 使加权轮询的周期不会因为百分比放大而变长
+func effectiveWeights(backends []*Backend) ([]int, int) {
+	weights := make([]int, len(backends))
+	divisor := 0
+	for i, backend := range backends {
+		weights[i] = backend.EffectiveWeight()
+		divisor = gcd(divisor, weights[i])
+	}
+	if divisor == 0 {
+		// 所有后端都报告为0时按配置的权重分配
+		for i, backend := range backends {
+			weights[i] = backend.Weight
+			if weights[i] <= 0 {
+				weights[i] = 1
+			}
+		}
+		divisor = 1
+	}
+
+	total := 0
+	for i := range weights {
+		weights[i] /= divisor
+		total += weights[i]
+	}
+	return weights, total
+}
+
+// gcd 最大公约数
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
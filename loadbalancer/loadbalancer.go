@@ -1,8 +1,12 @@
 package loadbalancer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,6 +29,8 @@ const (
 	Random LoadBalancerStrategy = "random"
 	// WeightedRandom 加权随机策略
 	WeightedRandom LoadBalancerStrategy = "weighted_random"
+	// LeastOutstanding 子集内最少未完成请求策略
+	LeastOutstanding LoadBalancerStrategy = "least_outstanding"
 )
 
 // Backend 后端服务器信息
@@ -34,30 +40,85 @@ type Backend struct {
 	Active       bool              `yaml:"active"`       // 是否活跃
 	Connections  int               `yaml:"-"`            // 当前连接数（内部使用）
 	ResponseTime time.Duration     `yaml:"-"`            // 平均响应时间（内部使用）
+	LoadHint     int               `yaml:"-"`            // 健康检查报告的可接收流量比例（0-100，内部使用）
+	Draining     bool              `yaml:"-"`            // 部署前摘除，不再接收新请求（内部使用）
+	EjectedUntil time.Time         `yaml:"-"`            // 被动健康检查摘除的截止时间（内部使用）
 	HealthCheck  HealthCheckConfig `yaml:"health_check"` // 健康检查配置
+
+	// 摘除的来源，管理API和标记文件分别记录，任一来源摘除时后端都不接收新请求
+	drainRequested bool
+	drainFlagged   bool
+	outlier        outlierStats // 被动健康检查的统计
+	discoveredBy   string       // 发现的后端的来源：DNS发现的配置URL（dns+http://...）或注册中心的服务
+}
+
+// fullLoadHint 后端没有报告负载提示时的流量比例
+const fullLoadHint = 100
+
+// EffectiveWeight 按负载提示调整后的权重，未配置权重时按1计算
+func (b *Backend) EffectiveWeight() int {
+	weight := b.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return weight * b.LoadHint
 }
 
 // HealthCheckConfig 健康检查配置
 type HealthCheckConfig struct {
-	Enabled  bool          `yaml:"enabled"`
-	Interval time.Duration `yaml:"interval"`
-	Timeout  time.Duration `yaml:"timeout"`
-	Path     string        `yaml:"path"`
+	Enabled        bool          `yaml:"enabled"`
+	Interval       time.Duration `yaml:"interval"`
+	Timeout        time.Duration `yaml:"timeout"`
+	Path           string        `yaml:"path"`
+	LoadHintHeader string        `yaml:"load_hint_header"` // 负载提示所在的响应头
+	LoadHintField  string        `yaml:"load_hint_field"`  // 负载提示所在的JSON字段，嵌套字段用 . 分隔
+}
+
+// reportsLoadHint 健康检查响应是否包含负载提示
+func (c HealthCheckConfig) reportsLoadHint() bool {
+	return c.LoadHintHeader != "" || c.LoadHintField != ""
 }
 
 // LoadBalancerConfig 负载均衡器配置
 type LoadBalancerConfig struct {
+	Name            string                 `yaml:"-"`                // 负载均衡器名称，由管理器创建时设置
 	Strategy        LoadBalancerStrategy   `yaml:"strategy"`         // 负载均衡策略
 	Backends        []Backend              `yaml:"backends"`         // 后端服务器列表
 	HealthCheck     HealthCheckConfig      `yaml:"health_check"`     // 全局健康检查配置
 	SessionAffinity *SessionAffinityConfig `yaml:"session_affinity"` // 会话保持配置
+	Subset          SubsetConfig           `yaml:"subset"`           // 确定性子集配置
+	DrainFile       string                 `yaml:"drain_file"`       // 部署标记文件
+	DNSRefresh      time.Duration          `yaml:"dns_refresh"`      // 重新解析dns+后端的最长间隔
+	// OutlierDetection 被动健康检查配置，为nil时不启用
+	OutlierDetection *OutlierDetectionConfig `yaml:"outlier_detection"`
+	// Discovery 服务注册中心配置，为nil时不启用
+	Discovery *DiscoveryConfig `yaml:"discovery"`
+}
+
+// OutlierDetectionConfig 被动健康检查配置
+type OutlierDetectionConfig struct {
+	ConsecutiveErrors  int           `yaml:"consecutive_errors"`   // 连续错误数阈值，0表示不按连续错误摘除
+	ErrorRate          float64       `yaml:"error_rate"`           // 错误率阈值（0-1），0表示不按错误率摘除
+	MinRequests        int           `yaml:"min_requests"`         // 按错误率判断所需的最少请求数
+	Window             time.Duration `yaml:"window"`               // 错误率统计窗口
+	EjectionTime       time.Duration `yaml:"ejection_time"`        // 第一次摘除的时长
+	MaxEjectionTime    time.Duration `yaml:"max_ejection_time"`    // 摘除时长上限
+	MaxEjectionPercent int           `yaml:"max_ejection_percent"` // 同时被摘除的后端比例上限（%）
+	RampTime           time.Duration `yaml:"ramp_time"`            // 摘除结束后逐步恢复流量的时长
+}
+
+// SubsetConfig 确定性子集配置
+type SubsetConfig struct {
+	Size int    `yaml:"size"` // 子集大小，0表示使用全部后端
+	Key  string `yaml:"key"`  // 实例标识，为空时使用主机名
 }
 
 // SessionAffinityConfig 会话保持配置
 type SessionAffinityConfig struct {
 	Enabled    bool          `yaml:"enabled"`
-	Timeout    time.Duration `yaml:"timeout"`
-	CookieName string        `yaml:"cookie_name"`
+	Timeout    time.Duration `yaml:"timeout"`          // 会话空闲超时，默认30分钟
+	CookieName string        `yaml:"cookie_name"`      // 默认LB_SESSION
+	Secret     string        `yaml:"secret,omitempty"` // cookie签名密钥，多个代理实例需要相同；为空时每次启动随机生成
 }
 
 // LoadBalancer 负载均衡器接口
@@ -88,6 +149,38 @@ type LoadBalancer interface {
 
 	// StopHealthCheck 停止健康检查
 	StopHealthCheck()
+
+	// RecordResult 记录发往后端的请求结果，用于被动健康检查
+	RecordResult(url string, failed bool)
+
+	// SetDraining 通过管理API摘除或恢复后端服务器
+	SetDraining(url string, draining bool) error
+
+	// AddBackend 通过管理API增加后端服务器
+	AddBackend(url string, weight int) error
+
+	// RemoveBackend 通过管理API删除后端服务器
+	RemoveBackend(url string) error
+
+	// SetWeight 通过管理API修改后端服务器的权重
+	SetWeight(url string, weight int) error
+}
+
+// BackendSelector 可以在指定的后端中按负载均衡策略选择的负载均衡器，
+// 重试时用于在尚未尝试的后端中选择，内置的策略都实现了该接口
+type BackendSelector interface {
+	SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error)
+}
+
+// SelectBackend 按lb的策略在candidates中选择后端，lb没有实现BackendSelector时返回第一个后端
+func SelectBackend(lb LoadBalancer, req *http.Request, candidates []*Backend) (*Backend, error) {
+	if len(candidates) == 0 {
+		return nil, errors.New("no active backends available")
+	}
+	if selector, ok := lb.(BackendSelector); ok {
+		return selector.SelectBackend(req, candidates)
+	}
+	return candidates[0], nil
 }
 
 // NewLoadBalancer 创建负载均衡器
@@ -107,6 +200,8 @@ func NewLoadBalancer(config LoadBalancerConfig) (LoadBalancer, error) {
 		return NewRandomLoadBalancer(config), nil
 	case WeightedRandom:
 		return NewWeightedRandomLoadBalancer(config), nil
+	case LeastOutstanding:
+		return NewLeastOutstandingLoadBalancer(config), nil
 	default:
 		return nil, fmt.Errorf("unsupported load balancer strategy: %s", config.Strategy)
 	}
@@ -118,20 +213,59 @@ type BaseLoadBalancer struct {
 	backends    []*Backend
 	mu          sync.RWMutex
 	healthCheck *HealthChecker
+	drainWatch  chan struct{} // 停止监视部署标记文件
+	onChange    func()        // 后端增加或删除后调用，策略据此更新缓存的状态，调用时持有写锁
+
+	dnsTemplates []dnsTemplate // 需要DNS发现的后端
+	dnsTTL       time.Duration // 创建时解析到的最小TTL，决定第一次重新解析的时间，未知时为0
+	dnsWatch     chan struct{} // 停止重新解析
+
+	discovery      discoverySource    // 服务注册中心，没有配置时为nil
+	discoveryWatch context.CancelFunc // 停止监视注册中心
 }
 
-// NewBaseLoadBalancer 创建基础负载均衡器
+// NewBaseLoadBalancer 创建基础负载均衡器，dns+后端在创建时解析一次，配置了服务注册中心时查询一次
 func NewBaseLoadBalancer(config LoadBalancerConfig) *BaseLoadBalancer {
+	lb := &BaseLoadBalancer{config: config, discovery: newDiscoverySource(config.Discovery)}
+
 	// 创建后端服务器指针切片
-	backends := make([]*Backend, len(config.Backends))
-	for i := range config.Backends {
-		backends[i] = &config.Backends[i]
+	for i := range lb.config.Backends {
+		backend := &lb.config.Backends[i]
+		if !isDNSBackend(backend.URL) {
+			backend.LoadHint = fullLoadHint
+			lb.backends = append(lb.backends, backend)
+			continue
+		}
+
+		template, err := parseDNSTemplate(*backend)
+		if err != nil {
+			log.Printf("Backend ignored: %v", err)
+			continue
+		}
+		lb.dnsTemplates = append(lb.dnsTemplates, template)
+
+		// 解析失败时没有对应的后端，之后定期重试
+		urls, ttl, err := template.resolve()
+		if err != nil {
+			log.Printf("Failed to resolve backend %s: %v", backend.URL, err)
+			continue
+		}
+		if ttl > 0 && (lb.dnsTTL == 0 || ttl < lb.dnsTTL) {
+			lb.dnsTTL = ttl
+		}
+		for _, backendURL := range urls {
+			discovered := template.newDiscoveredBackend(backendURL)
+			discovered.Active = true
+			discovered.LoadHint = fullLoadHint
+			lb.backends = append(lb.backends, discovered)
+		}
+		log.Printf("Backend %s resolved to %d addresses", backend.URL, len(urls))
 	}
 
-	return &BaseLoadBalancer{
-		config:   config,
-		backends: backends,
+	if lb.discovery != nil {
+		lb.discover()
 	}
+	return lb
 }
 
 // UpdateBackendStatus 更新后端服务器状态
@@ -139,24 +273,32 @@ func (lb *BaseLoadBalancer) UpdateBackendStatus(url string, active bool) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	for _, backend := range lb.backends {
-		if backend.URL == url {
-			backend.Active = active
-			break
-		}
+	if backend := lb.find(url); backend != nil {
+		backend.Active = active
 	}
 }
 
+// UpdateLoadHint 更新后端服务器报告的负载提示，返回之前的值
+func (lb *BaseLoadBalancer) UpdateLoadHint(url string, hint int) int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	backend := lb.find(url)
+	if backend == nil {
+		return hint
+	}
+	previous := backend.LoadHint
+	backend.LoadHint = hint
+	return previous
+}
+
 // IncrementConnection 增加后端服务器连接数
 func (lb *BaseLoadBalancer) IncrementConnection(url string) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	for _, backend := range lb.backends {
-		if backend.URL == url {
-			backend.Connections++
-			break
-		}
+	if backend := lb.find(url); backend != nil {
+		backend.Connections++
 	}
 }
 
@@ -165,13 +307,8 @@ func (lb *BaseLoadBalancer) DecrementConnection(url string) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	for _, backend := range lb.backends {
-		if backend.URL == url {
-			if backend.Connections > 0 {
-				backend.Connections--
-			}
-			break
-		}
+	if backend := lb.find(url); backend != nil && backend.Connections > 0 {
+		backend.Connections--
 	}
 }
 
@@ -180,18 +317,33 @@ func (lb *BaseLoadBalancer) UpdateResponseTime(url string, responseTime time.Dur
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
+	backend := lb.find(url)
+	if backend == nil {
+		return
+	}
+	// 使用指数移动平均计算响应时间
+	if backend.ResponseTime == 0 {
+		backend.ResponseTime = responseTime
+	} else {
+		// 使用0.7的平滑因子
+		backend.ResponseTime = time.Duration(float64(backend.ResponseTime)*0.7 + float64(responseTime)*0.3)
+	}
+}
+
+// find 按地址查找后端服务器，调用方需要持有锁；地址可以是配置的URL，
+// 也可以是传输层从请求中得到的scheme://host（后端URL带路径时）
+func (lb *BaseLoadBalancer) find(url string) *Backend {
 	for _, backend := range lb.backends {
 		if backend.URL == url {
-			// 使用指数移动平均计算响应时间
-			if backend.ResponseTime == 0 {
-				backend.ResponseTime = responseTime
-			} else {
-				// 使用0.7的平滑因子
-				backend.ResponseTime = time.Duration(float64(backend.ResponseTime)*0.7 + float64(responseTime)*0.3)
-			}
-			break
+			return backend
+		}
+	}
+	for _, backend := range lb.backends {
+		if strings.HasPrefix(backend.URL, url+"/") {
+			return backend
 		}
 	}
+	return nil
 }
 
 // GetBackends 获取所有后端服务器信息
@@ -206,12 +358,15 @@ func (lb *BaseLoadBalancer) GetBackends() []Backend {
 	return result
 }
 
-// StartHealthCheck 启动健康检查
+// StartHealthCheck 启动健康检查，配置了部署标记文件时同时开始监视
 func (lb *BaseLoadBalancer) StartHealthCheck() {
 	if lb.healthCheck == nil {
 		lb.healthCheck = NewHealthChecker(lb)
 	}
 	lb.healthCheck.Start()
+	lb.startDrainWatch()
+	lb.startDNSWatch()
+	lb.startDiscoveryWatch()
 }
 
 // StopHealthCheck 停止健康检查
@@ -219,6 +374,9 @@ func (lb *BaseLoadBalancer) StopHealthCheck() {
 	if lb.healthCheck != nil {
 		lb.healthCheck.Stop()
 	}
+	lb.stopDrainWatch()
+	lb.stopDNSWatch()
+	lb.stopDiscoveryWatch()
 }
 
 // GetActiveBackends 获取活跃的后端服务器
@@ -226,42 +384,63 @@ func (lb *BaseLoadBalancer) GetActiveBackends() []*Backend {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
 
+	now := time.Now()
 	var activeBackends []*Backend
 	for _, backend := range lb.backends {
-		if backend.Active {
+		if backend.Active && !backend.Draining && !backend.ejected(now) {
 			activeBackends = append(activeBackends, backend)
 		}
 	}
 	return activeBackends
 }
 
-// HealthChecker 健康检查器
+// HealthChecker 健康检查器，可以多次启动和停止
 type HealthChecker struct {
 	loadBalancer *BaseLoadBalancer
-	stopCh       chan struct{}
+	mu           sync.Mutex
+	stopCh       chan struct{} // 运行期间有效，停止后为nil
+	done         chan struct{} // 检查循环退出时关闭
+	started      bool          // 是否已经启动过
 }
 
 // NewHealthChecker 创建健康检查器
 func NewHealthChecker(loadBalancer *BaseLoadBalancer) *HealthChecker {
 	return &HealthChecker{
 		loadBalancer: loadBalancer,
-		stopCh:       make(chan struct{}),
 	}
 }
 
-// Start 启动健康检查
+// Start 启动健康检查，已在运行时不做任何操作；停止后可以再次启动
 func (hc *HealthChecker) Start() {
 	// 如果没有配置健康检查，则不启动
 	if !hc.loadBalancer.config.HealthCheck.Enabled {
 		return
 	}
 
-	// 初始化所有后端服务器状态为活跃
-	for _, backend := range hc.loadBalancer.backends {
-		backend.Active = true
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if hc.stopCh != nil {
+		return
 	}
 
+	// 第一次启动时初始化所有后端服务器状态为活跃，再次启动时保留停止前的检查结果
+	if !hc.started {
+		hc.loadBalancer.mu.Lock()
+		for _, backend := range hc.loadBalancer.backends {
+			backend.Active = true
+		}
+		hc.loadBalancer.mu.Unlock()
+		hc.started = true
+	}
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	hc.stopCh, hc.done = stopCh, done
+
 	go func() {
+		defer close(done)
+
 		ticker := time.NewTicker(hc.loadBalancer.config.HealthCheck.Interval)
 		defer ticker.Stop()
 
@@ -269,34 +448,53 @@ func (hc *HealthChecker) Start() {
 			select {
 			case <-ticker.C:
 				hc.checkAllBackends()
-			case <-hc.stopCh:
+			case <-stopCh:
 				return
 			}
 		}
 	}()
 }
 
-// Stop 停止健康检查
+// Stop 停止健康检查并等待检查循环退出，未启动或已停止时不做任何操作；
+// 已经发出的单个后端检查不会被中断
 func (hc *HealthChecker) Stop() {
-	close(hc.stopCh)
+	hc.mu.Lock()
+	stopCh, done := hc.stopCh, hc.done
+	hc.stopCh, hc.done = nil, nil
+	hc.mu.Unlock()
+
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-done
 }
 
 // checkAllBackends 检查所有后端服务器健康状态
 func (hc *HealthChecker) checkAllBackends() {
-	for _, backend := range hc.loadBalancer.backends {
+	hc.loadBalancer.mu.RLock()
+	backends := hc.loadBalancer.backends
+	hc.loadBalancer.mu.RUnlock()
+
+	for _, backend := range backends {
 		go hc.checkBackend(backend)
 	}
 }
 
 // checkBackend 检查单个后端服务器健康状态
 func (hc *HealthChecker) checkBackend(backend *Backend) {
+	// 摘除期间后端可能正在部署，不检查，恢复时重新检查
+	if hc.loadBalancer.isDraining(backend) {
+		return
+	}
+
 	// 使用后端自己的健康检查配置，如果没有则使用全局配置
 	config := backend.HealthCheck
 	if !config.Enabled {
 		config = hc.loadBalancer.config.HealthCheck
 		if !config.Enabled {
 			// 如果都没有启用健康检查，则认为始终健康
-			backend.Active = true
+			hc.setActive(backend, true)
 			return
 		}
 	}
@@ -314,18 +512,37 @@ func (hc *HealthChecker) checkBackend(backend *Backend) {
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		backend.Active = false
+		hc.setActive(backend, false)
 		return
 	}
 
 	// 发送请求
 	resp, err := client.Do(req)
 	if err != nil {
-		backend.Active = false
+		hc.setActive(backend, false)
 		return
 	}
 	defer resp.Body.Close()
 
 	// 检查响应状态码
-	backend.Active = resp.StatusCode >= 200 && resp.StatusCode < 300
+	active := resp.StatusCode >= 200 && resp.StatusCode < 300
+	hc.setActive(backend, active)
+
+	// 健康的后端可以通过负载提示减少流量，没有报告时恢复全部流量
+	if active && config.reportsLoadHint() {
+		hint := readLoadHint(resp, config)
+		if previous := hc.loadBalancer.UpdateLoadHint(backend.URL, hint); previous != hint {
+			log.Printf("Load hint of backend %s changed from %d%% to %d%%", backend.URL, previous, hint)
+		}
+	}
+}
+
+// setActive 记录健康检查结果；检查期间后端被摘除时丢弃结果，恢复时会重新检查
+func (hc *HealthChecker) setActive(backend *Backend, active bool) {
+	hc.loadBalancer.mu.Lock()
+	defer hc.loadBalancer.mu.Unlock()
+
+	if !backend.Draining {
+		backend.Active = active
+	}
 }
@@ -2,6 +2,8 @@ package loadbalancer
 
 import (
 	"fmt"
+	"log"
+	"strings"
 	"sync"
 )
 
@@ -22,6 +24,18 @@ type LoadBalancerManager interface {
 	// ListLoadBalancers 列出所有负载均衡器名称
 	ListLoadBalancers() []string
 
+	// AddBackend 通过管理API向负载均衡器增加后端服务器
+	AddBackend(name, url string, weight int) error
+
+	// RemoveBackend 通过管理API从负载均衡器删除后端服务器
+	RemoveBackend(name, url string) error
+
+	// SetBackendWeight 通过管理API修改后端服务器的权重
+	SetBackendWeight(name, url string, weight int) error
+
+	// BackendChanges 返回通过管理API对各负载均衡器后端做的修改
+	BackendChanges() map[string]BackendChanges
+
 	// StartAll 启动所有负载均衡器的健康检查
 	StartAll()
 
@@ -29,25 +43,31 @@ type LoadBalancerManager interface {
 	StopAll()
 }
 
+// BackendChanges 通过管理API对负载均衡器后端做的修改，负载均衡配置更新后重新应用，也保存在状态文件中
+type BackendChanges struct {
+	Added   map[string]int `yaml:"added,omitempty"`   // 增加的后端及其权重
+	Removed []string       `yaml:"removed,omitempty"` // 删除的配置中的后端
+	Weights map[string]int `yaml:"weights,omitempty"` // 修改了权重的配置中的后端
+}
+
 // DefaultLoadBalancerManager 默认负载均衡器管理器实现
 type DefaultLoadBalancerManager struct {
 	loadBalancers map[string]LoadBalancer
+	changes       map[string]*BackendChanges
 	factory       LoadBalancerFactory
 	mu            sync.RWMutex
 }
 
 // NewDefaultLoadBalancerManager 创建默认负载均衡器管理器
 func NewDefaultLoadBalancerManager() *DefaultLoadBalancerManager {
-	return &DefaultLoadBalancerManager{
-		loadBalancers: make(map[string]LoadBalancer),
-		factory:       NewDefaultLoadBalancerFactory(),
-	}
+	return NewLoadBalancerManagerWithFactory(NewDefaultLoadBalancerFactory())
 }
 
 // NewLoadBalancerManagerWithFactory 使用指定工厂创建负载均衡器管理器
 func NewLoadBalancerManagerWithFactory(factory LoadBalancerFactory) *DefaultLoadBalancerManager {
 	return &DefaultLoadBalancerManager{
 		loadBalancers: make(map[string]LoadBalancer),
+		changes:       make(map[string]*BackendChanges),
 		factory:       factory,
 	}
 }
@@ -58,20 +78,26 @@ func (m *DefaultLoadBalancerManager) CreateLoadBalancer(name string, config Load
 		return fmt.Errorf("load balancer name cannot be empty")
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	// 检查是否已存在
-	if _, exists := m.loadBalancers[name]; exists {
+	if _, err := m.GetLoadBalancer(name); err == nil {
 		return fmt.Errorf("load balancer with name '%s' already exists", name)
 	}
 
-	// 创建负载均衡器
+	// 创建负载均衡器，创建时可能解析DNS或查询服务发现，不持有锁以免阻塞请求
+	config.Name = name
 	lb, err := m.factory.CreateLoadBalancer(config)
 	if err != nil {
 		return fmt.Errorf("failed to create load balancer '%s': %w", name, err)
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 创建期间可能已有同名负载均衡器注册，新负载均衡器还没有启动，直接丢弃
+	if _, exists := m.loadBalancers[name]; exists {
+		return fmt.Errorf("load balancer with name '%s' already exists", name)
+	}
+
 	// 注册负载均衡器
 	m.loadBalancers[name] = lb
 
@@ -100,28 +126,43 @@ func (m *DefaultLoadBalancerManager) UpdateLoadBalancer(name string, config Load
 		return fmt.Errorf("load balancer name cannot be empty")
 	}
 
+	// 检查是否存在
+	if _, err := m.GetLoadBalancer(name); err != nil {
+		return err
+	}
+
+	// 创建新负载均衡器，不持有锁，旧负载均衡器在此期间继续处理请求和健康检查
+	config.Name = name
+	newLb, err := m.factory.CreateLoadBalancer(config)
+	if err != nil {
+		return fmt.Errorf("failed to create load balancer '%s': %w", name, err)
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// 检查是否存在
+	// 创建期间负载均衡器可能已被删除或替换，以当前注册的为准
 	oldLb, exists := m.loadBalancers[name]
 	if !exists {
 		return fmt.Errorf("load balancer with name '%s' not found", name)
 	}
 
-	// 停止旧负载均衡器的健康检查
-	oldLb.StopHealthCheck()
+	// 通过管理API摘除的后端在新负载均衡器中保持摘除，标记文件在启动时重新读取
+	for _, backend := range oldLb.GetBackends() {
+		if backend.drainRequested {
+			newLb.SetDraining(backend.URL, true)
+		}
+	}
 
-	// 创建新负载均衡器
-	newLb, err := m.factory.CreateLoadBalancer(config)
-	if err != nil {
-		// 如果创建失败，重新启动旧负载均衡器的健康检查
-		oldLb.StartHealthCheck()
-		return fmt.Errorf("failed to create load balancer '%s': %w", name, err)
+	// 通过管理API增删的后端和修改的权重在新负载均衡器中保持
+	if changes := m.changes[name]; changes != nil {
+		changes.apply(name, newLb)
 	}
 
-	// 替换负载均衡器
+	// 替换负载均衡器，停止旧负载均衡器并启动新负载均衡器的健康检查
 	m.loadBalancers[name] = newLb
+	oldLb.StopHealthCheck()
+	newLb.StartHealthCheck()
 
 	return nil
 }
@@ -146,6 +187,7 @@ func (m *DefaultLoadBalancerManager) DeleteLoadBalancer(name string) error {
 
 	// 删除负载均衡器
 	delete(m.loadBalancers, name)
+	delete(m.changes, name)
 
 	return nil
 }
@@ -163,6 +205,164 @@ func (m *DefaultLoadBalancerManager) ListLoadBalancers() []string {
 	return names
 }
 
+// AddBackend 向负载均衡器增加后端服务器并记录修改
+func (m *DefaultLoadBalancerManager) AddBackend(name, url string, weight int) error {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lb, exists := m.loadBalancers[name]
+	if !exists {
+		return fmt.Errorf("load balancer with name '%s' not found", name)
+	}
+	if err := lb.AddBackend(url, weight); err != nil {
+		return err
+	}
+
+	changes := m.changesOf(name)
+	if i := indexOf(changes.Removed, url); i != -1 {
+		// 重新加入之前删除的配置中的后端
+		changes.Removed = append(changes.Removed[:i], changes.Removed[i+1:]...)
+		changes.Weights[url] = weight
+	} else {
+		changes.Added[url] = weight
+	}
+	return nil
+}
+
+// RemoveBackend 从负载均衡器删除后端服务器并记录修改
+func (m *DefaultLoadBalancerManager) RemoveBackend(name, url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lb, exists := m.loadBalancers[name]
+	if !exists {
+		return fmt.Errorf("load balancer with name '%s' not found", name)
+	}
+	url = backendURL(lb, url)
+	if err := lb.RemoveBackend(url); err != nil {
+		return err
+	}
+
+	changes := m.changesOf(name)
+	delete(changes.Weights, url)
+	if _, added := changes.Added[url]; added {
+		delete(changes.Added, url)
+	} else {
+		changes.Removed = append(changes.Removed, url)
+	}
+	return nil
+}
+
+// SetBackendWeight 修改后端服务器的权重并记录修改
+func (m *DefaultLoadBalancerManager) SetBackendWeight(name, url string, weight int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lb, exists := m.loadBalancers[name]
+	if !exists {
+		return fmt.Errorf("load balancer with name '%s' not found", name)
+	}
+	url = backendURL(lb, url)
+	if err := lb.SetWeight(url, weight); err != nil {
+		return err
+	}
+
+	changes := m.changesOf(name)
+	if _, added := changes.Added[url]; added {
+		changes.Added[url] = weight
+	} else {
+		changes.Weights[url] = weight
+	}
+	return nil
+}
+
+// BackendChanges 返回通过管理API对各负载均衡器后端做的修改的副本
+func (m *DefaultLoadBalancerManager) BackendChanges() map[string]BackendChanges {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string]BackendChanges, len(m.changes))
+	for name, changes := range m.changes {
+		if len(changes.Added) == 0 && len(changes.Removed) == 0 && len(changes.Weights) == 0 {
+			continue
+		}
+		copied := BackendChanges{
+			Added:   make(map[string]int, len(changes.Added)),
+			Removed: append([]string(nil), changes.Removed...),
+			Weights: make(map[string]int, len(changes.Weights)),
+		}
+		for url, weight := range changes.Added {
+			copied.Added[url] = weight
+		}
+		for url, weight := range changes.Weights {
+			copied.Weights[url] = weight
+		}
+		result[name] = copied
+	}
+	return result
+}
+
+// changesOf 返回负载均衡器的修改记录，没有时创建，调用方需要持有写锁
+func (m *DefaultLoadBalancerManager) changesOf(name string) *BackendChanges {
+	changes := m.changes[name]
+	if changes == nil {
+		changes = &BackendChanges{
+			Added:   make(map[string]int),
+			Weights: make(map[string]int),
+		}
+		m.changes[name] = changes
+	}
+	return changes
+}
+
+// apply 在按配置新建的负载均衡器上重新应用修改；配置中已经删除或加入的后端跳过
+func (c *BackendChanges) apply(name string, lb LoadBalancer) {
+	for _, url := range c.Removed {
+		if err := lb.RemoveBackend(url); err != nil {
+			log.Printf("Removed backend of load balancer %s not reapplied: %v", name, err)
+		}
+	}
+	for url, weight := range c.Added {
+		if err := lb.AddBackend(url, weight); err != nil {
+			log.Printf("Added backend of load balancer %s not reapplied: %v", name, err)
+		}
+	}
+	for url, weight := range c.Weights {
+		if err := lb.SetWeight(url, weight); err != nil {
+			log.Printf("Weight of backend of load balancer %s not reapplied: %v", name, err)
+		}
+	}
+}
+
+// backendURL 返回地址对应的后端的配置URL，地址可以只写scheme://host；找不到时原样返回
+func backendURL(lb LoadBalancer, url string) string {
+	for _, backend := range lb.GetBackends() {
+		if backend.URL == url {
+			return url
+		}
+	}
+	for _, backend := range lb.GetBackends() {
+		if strings.HasPrefix(backend.URL, url+"/") {
+			return backend.URL
+		}
+	}
+	return url
+}
+
+// indexOf 返回字符串在切片中的位置，不存在时返回-1
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
 // StartAll 启动所有负载均衡器的健康检查
 func (m *DefaultLoadBalancerManager) StartAll() {
 	m.mu.RLock()
@@ -0,0 +1,89 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+)
+
+// blockingFactory 在release关闭前阻塞创建负载均衡器，模拟DNS解析或服务发现查询很慢
+type blockingFactory struct {
+	*DefaultLoadBalancerFactory
+	creating chan struct{}
+	release  chan struct{}
+}
+
+func (f *blockingFactory) CreateLoadBalancer(config LoadBalancerConfig) (LoadBalancer, error) {
+	f.creating <- struct{}{}
+	<-f.release
+	return f.DefaultLoadBalancerFactory.CreateLoadBalancer(config)
+}
+
+func TestManagerCreatesLoadBalancersOutsideLock(t *testing.T) {
+	factory := &blockingFactory{
+		DefaultLoadBalancerFactory: NewDefaultLoadBalancerFactory(),
+		creating:                   make(chan struct{}),
+		release:                    make(chan struct{}),
+	}
+	manager := NewLoadBalancerManagerWithFactory(factory)
+	config := LoadBalancerConfig{Strategy: RoundRobin, Backends: []Backend{{URL: "http://a.internal", Weight: 1}}}
+
+	created := make(chan error, 1)
+	go func() { created <- manager.CreateLoadBalancer("app", config) }()
+	<-factory.creating
+
+	// 创建期间其他负载均衡器的查询不被阻塞
+	lookup := make(chan struct{})
+	go func() {
+		manager.GetLoadBalancer("other")
+		manager.ListLoadBalancers()
+		close(lookup)
+	}()
+	select {
+	case <-lookup:
+	case <-time.After(time.Second):
+		t.Fatal("lookups blocked while a load balancer was being created")
+	}
+	factory.release <- struct{}{}
+	if err := <-created; err != nil {
+		t.Fatal(err)
+	}
+
+	// 更新期间旧负载均衡器继续可用，并发删除后更新失败
+	old, _ := manager.GetLoadBalancer("app")
+	updated := make(chan error, 1)
+	go func() { updated <- manager.UpdateLoadBalancer("app", config) }()
+	<-factory.creating
+	if lb, err := manager.GetLoadBalancer("app"); err != nil || lb != old {
+		t.Fatalf("GetLoadBalancer during update = %v, %v", lb, err)
+	}
+	if err := manager.DeleteLoadBalancer("app"); err != nil {
+		t.Fatal(err)
+	}
+	close(factory.release)
+	if err := <-updated; err == nil {
+		t.Error("update of a concurrently deleted load balancer succeeded")
+	}
+	if _, err := manager.GetLoadBalancer("app"); err == nil {
+		t.Error("deleted load balancer was registered again by the update")
+	}
+}
+
+func TestManagerUpdateKeepsBackendChanges(t *testing.T) {
+	manager := NewDefaultLoadBalancerManager()
+	config := LoadBalancerConfig{Strategy: RoundRobin, Backends: []Backend{{URL: "http://a.internal", Weight: 1}}}
+	if err := manager.CreateLoadBalancer("app", config); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.StopAll()
+	if err := manager.AddBackend("app", "http://b.internal", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.UpdateLoadBalancer("app", config); err != nil {
+		t.Fatal(err)
+	}
+	lb, _ := manager.GetLoadBalancer("app")
+	if backends := lb.GetBackends(); len(backends) != 2 || backends[1].URL != "http://b.internal" || backends[1].Weight != 2 {
+		t.Errorf("backends after update = %+v", backends)
+	}
+}
@@ -0,0 +1,122 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// minRampShare 摘除结束后恢复流量的起始比例
+const minRampShare = 0.1
+
+// outlierStats 后端在当前统计窗口内的请求结果
+type outlierStats struct {
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int       // 连续错误数
+	ejections   int       // 最近被摘除的次数，决定下次摘除的时长
+	rampUntil   time.Time // 逐步恢复流量的截止时间
+}
+
+// ejected 返回后端是否正被被动健康检查摘除
+func (b *Backend) ejected(now time.Time) bool {
+	return now.Before(b.EjectedUntil)
+}
+
+// admitted 摘除结束后的恢复期内按比例放行请求，比例从10%线性增加到100%；不在恢复期时总是放行
+func (b *Backend) admitted(now time.Time) bool {
+	if !now.Before(b.outlier.rampUntil) {
+		return true
+	}
+	if b.ejected(now) {
+		return false
+	}
+	share := float64(now.Sub(b.EjectedUntil)) / float64(b.outlier.rampUntil.Sub(b.EjectedUntil))
+	if share < minRampShare {
+		share = minRampShare
+	}
+	return rand.Float64() < share
+}
+
+// RecordResult 记录发往后端的请求结果（5xx或连接失败为失败），连续错误或窗口内错误率达到阈值时摘除该后端；
+// 没有配置被动健康检查时不做任何操作
+func (lb *BaseLoadBalancer) RecordResult(url string, failed bool) {
+	od := lb.config.OutlierDetection
+	if od == nil {
+		return
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	backend := lb.find(url)
+	if backend == nil {
+		return
+	}
+	now := time.Now()
+	if backend.ejected(now) {
+		// 摘除前已经转发的请求不再计入
+		return
+	}
+
+	stats := &backend.outlier
+	if stats.windowStart.IsZero() || now.Sub(stats.windowStart) >= od.Window {
+		// 恢复后整个窗口都没有再被摘除时减少摘除次数，下次摘除的时长随之缩短
+		if !stats.windowStart.IsZero() && stats.ejections > 0 && now.After(stats.rampUntil) {
+			stats.ejections--
+		}
+		stats.windowStart, stats.requests, stats.failures = now, 0, 0
+	}
+
+	stats.requests++
+	if !failed {
+		stats.consecutive = 0
+		return
+	}
+	stats.failures++
+	stats.consecutive++
+
+	var reason string
+	switch {
+	case od.ConsecutiveErrors > 0 && stats.consecutive >= od.ConsecutiveErrors:
+		reason = fmt.Sprintf("%d consecutive errors", stats.consecutive)
+	case od.ErrorRate > 0 && stats.requests >= od.MinRequests && float64(stats.failures) >= od.ErrorRate*float64(stats.requests):
+		reason = fmt.Sprintf("%d of %d requests failed", stats.failures, stats.requests)
+	default:
+		return
+	}
+	lb.eject(backend, now, reason)
+}
+
+// eject 摘除后端，摘除时长按最近被摘除的次数加倍；同时被摘除的后端不超过配置的比例，调用方需要持有写锁
+func (lb *BaseLoadBalancer) eject(backend *Backend, now time.Time, reason string) {
+	od := lb.config.OutlierDetection
+
+	ejected := 0
+	for _, b := range lb.backends {
+		if b.ejected(now) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(lb.backends)*od.MaxEjectionPercent {
+		log.Printf("Backend %s exceeds outlier thresholds (%s) but %d of %d backends are already ejected", backend.URL, reason, ejected, len(lb.backends))
+		return
+	}
+
+	stats := &backend.outlier
+	stats.ejections++
+	duration := od.EjectionTime
+	for i := 1; i < stats.ejections && duration < od.MaxEjectionTime; i++ {
+		duration *= 2
+	}
+	if duration > od.MaxEjectionTime {
+		duration = od.MaxEjectionTime
+	}
+
+	backend.EjectedUntil = now.Add(duration)
+	stats.rampUntil = backend.EjectedUntil.Add(od.RampTime)
+	stats.windowStart, stats.requests, stats.failures, stats.consecutive = time.Time{}, 0, 0, 0
+	log.Printf("Backend %s ejected for %v by outlier detection: %s", backend.URL, duration, reason)
+}
@@ -2,11 +2,17 @@ package loadbalancer
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
+
+	"toyou-proxy/headers"
 )
 
 // LoadBalancedProxy 负载均衡代理
@@ -44,9 +50,11 @@ func (p *LoadBalancedProxy) ServeHTTP(w http.ResponseWriter, req *http.Request)
 	// 记录开始时间
 	startTime := time.Now()
 
-	// 创建新的请求
+	// 创建新的请求，复制请求头并删除逐跳头部
 	outReq := new(http.Request)
 	*outReq = *req
+	outReq.Header = req.Header.Clone()
+	headers.RemoveHopByHop(outReq.Header)
 
 	// 更新URL
 	targetURL, err := url.Parse(backend.URL)
@@ -82,7 +90,8 @@ func (p *LoadBalancedProxy) ServeHTTP(w http.ResponseWriter, req *http.Request)
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头
+	// 复制响应头（不含逐跳头部）
+	headers.RemoveHopByHop(resp.Header)
 	for key, values := range resp.Header {
 		for _, value := range values {
 			recorder.Header().Add(key, value)
@@ -298,26 +307,67 @@ type LoadBalancerTransport struct {
 	Transport    http.RoundTripper
 }
 
-// RoundTrip 实现http.RoundTripper接口
+// RoundTrip 实现http.RoundTripper接口，连接数和响应时间计入请求实际发往的后端，
+// 重试时每次尝试由重试传输层重新调用，分别计入各自的后端
 func (t *LoadBalancerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// 使用默认传输器发送请求
 	if t.Transport == nil {
 		t.Transport = http.DefaultTransport
 	}
 
+	// 从URL中提取后端URL
+	backendURL := req.URL.Scheme + "://" + req.URL.Host
+
+	// 请求发出到响应体关闭期间计为后端的未完成请求
+	t.LoadBalancer.IncrementConnection(backendURL)
+
 	startTime := time.Now()
 	resp, err := t.Transport.RoundTrip(req)
+	if err != nil || resp == nil {
+		t.LoadBalancer.DecrementConnection(backendURL)
+		// 客户端取消的请求不计为后端的错误
+		if !errors.Is(err, context.Canceled) {
+			t.LoadBalancer.RecordResult(backendURL, true)
+		}
+		// 超时的请求按耗时计入响应时间，避免持续超时的后端因为没有记录而一直被选中
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			t.LoadBalancer.UpdateResponseTime(backendURL, time.Since(startTime))
+		}
+		return resp, err
+	}
 
 	// 更新响应时间
-	if err == nil && resp != nil {
-		responseTime := time.Since(startTime)
+	t.LoadBalancer.UpdateResponseTime(backendURL, time.Since(startTime))
+	t.LoadBalancer.RecordResult(backendURL, resp.StatusCode >= http.StatusInternalServerError)
 
-		// 从URL中提取后端URL
-		backendURL := req.URL.Scheme + "://" + req.URL.Host
-		t.LoadBalancer.UpdateResponseTime(backendURL, responseTime)
+	// 会话保持的cookie指向实际处理请求的后端，重试切换后端时随之更新
+	if affinity, ok := t.LoadBalancer.(*SessionAffinityLoadBalancer); ok {
+		affinity.setCookie(resp, backendURL)
 	}
 
-	return resp, err
+	// 协议升级后的连接不再计入，响应体需要保持可写以便转发WebSocket等数据
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		t.LoadBalancer.DecrementConnection(backendURL)
+		return resp, nil
+	}
+	resp.Body = &outstandingBody{ReadCloser: resp.Body, done: func() {
+		t.LoadBalancer.DecrementConnection(backendURL)
+	}}
+	return resp, nil
+}
+
+// outstandingBody 响应体第一次关闭时结束未完成请求的计数
+type outstandingBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *outstandingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
 }
 
 // ReverseProxy 反向代理，复制自标准库但添加了负载均衡支持
@@ -356,6 +406,7 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if p.Director != nil {
 		p.Director(outreq)
 	}
+	headers.RemoveHopByHop(outreq.Header)
 
 	// 发送请求
 	res, err := transport.RoundTrip(outreq)
@@ -373,7 +424,8 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	// 复制响应头
+	// 复制响应头（不含逐跳头部）
+	headers.RemoveHopByHop(res.Header)
 	for k, vv := range res.Header {
 		for _, v := range vv {
 			rw.Header().Add(k, v)
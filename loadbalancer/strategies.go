@@ -4,11 +4,13 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"math"
 	"math/rand"
-	"net"
 	"net/http"
 	"sync"
 	"time"
+
+	"toyou-proxy/clientip"
 )
 
 // RoundRobinLoadBalancer 轮询负载均衡器
@@ -28,7 +30,12 @@ func NewRoundRobinLoadBalancer(config LoadBalancerConfig) *RoundRobinLoadBalance
 
 // NextBackend 选择下一个后端服务器
 func (lb *RoundRobinLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
-	activeBackends := lb.GetActiveBackends()
+	return lb.SelectBackend(req, lb.GetActiveBackends())
+}
+
+// SelectBackend 在指定的后端中选择
+func (lb *RoundRobinLoadBalancer) SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error) {
+	activeBackends := available(candidates)
 	if len(activeBackends) == 0 {
 		return nil, errors.New("no active backends available")
 	}
@@ -62,22 +69,18 @@ func NewWeightedRoundRobinLoadBalancer(config LoadBalancerConfig) *WeightedRound
 
 // NextBackend 选择下一个后端服务器
 func (lb *WeightedRoundRobinLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
-	activeBackends := lb.GetActiveBackends()
+	return lb.SelectBackend(req, lb.GetActiveBackends())
+}
+
+// SelectBackend 在指定的后端中选择
+func (lb *WeightedRoundRobinLoadBalancer) SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error) {
+	activeBackends := available(candidates)
 	if len(activeBackends) == 0 {
 		return nil, errors.New("no active backends available")
 	}
 
-	// 计算总权重
-	totalWeight := 0
-	for _, backend := range activeBackends {
-		if backend.Weight <= 0 {
-			// 默认权重为1
-			totalWeight++
-		} else {
-			totalWeight += backend.Weight
-		}
-	}
-
+	// 计算按负载提示调整后的权重，未配置权重时默认为1
+	weights, totalWeight := effectiveWeights(activeBackends)
 	if totalWeight == 0 {
 		return nil, errors.New("invalid weights for backends")
 	}
@@ -90,13 +93,8 @@ func (lb *WeightedRoundRobinLoadBalancer) NextBackend(req *http.Request) (*Backe
 	lb.weight++
 
 	currentWeight := 0
-	for _, backend := range activeBackends {
-		weight := backend.Weight
-		if weight <= 0 {
-			weight = 1
-		}
-
-		currentWeight += weight
+	for i, backend := range activeBackends {
+		currentWeight += weights[i]
 		if targetWeight < currentWeight {
 			return backend, nil
 		}
@@ -120,13 +118,18 @@ func NewIPHashLoadBalancer(config LoadBalancerConfig) *IPHashLoadBalancer {
 
 // NextBackend 选择下一个后端服务器
 func (lb *IPHashLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
-	activeBackends := lb.GetActiveBackends()
+	return lb.SelectBackend(req, lb.GetActiveBackends())
+}
+
+// SelectBackend 在指定的后端中选择
+func (lb *IPHashLoadBalancer) SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error) {
+	activeBackends := available(candidates)
 	if len(activeBackends) == 0 {
 		return nil, errors.New("no active backends available")
 	}
 
-	// 获取客户端IP
-	clientIP := getClientIP(req)
+	// 获取客户端IP，只有来自可信代理的请求才使用X-Forwarded-For
+	clientIP := clientip.ClientIP(req)
 
 	// 计算哈希值
 	hash := sha256.Sum256([]byte(clientIP))
@@ -137,42 +140,6 @@ func (lb *IPHashLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
 	return activeBackends[index], nil
 }
 
-// getClientIP 获取客户端IP地址
-func getClientIP(req *http.Request) string {
-	// 尝试从X-Forwarded-For头获取
-	xForwardedFor := req.Header.Get("X-Forwarded-For")
-	if xForwardedFor != "" {
-		// X-Forwarded-For可能包含多个IP，取第一个
-		if idx := len(xForwardedFor); idx > 0 {
-			if commaIdx := 0; commaIdx < idx {
-				for i, c := range xForwardedFor {
-					if c == ',' {
-						commaIdx = i
-						break
-					}
-				}
-				if commaIdx > 0 {
-					return xForwardedFor[:commaIdx]
-				}
-			}
-			return xForwardedFor
-		}
-	}
-
-	// 尝试从X-Real-IP头获取
-	xRealIP := req.Header.Get("X-Real-IP")
-	if xRealIP != "" {
-		return xRealIP
-	}
-
-	// 从RemoteAddr获取
-	ip, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		return req.RemoteAddr
-	}
-	return ip
-}
-
 // LeastConnectionsLoadBalancer 最少连接负载均衡器
 type LeastConnectionsLoadBalancer struct {
 	*BaseLoadBalancer
@@ -187,12 +154,20 @@ func NewLeastConnectionsLoadBalancer(config LoadBalancerConfig) *LeastConnection
 
 // NextBackend 选择下一个后端服务器
 func (lb *LeastConnectionsLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
-	activeBackends := lb.GetActiveBackends()
+	return lb.SelectBackend(req, lb.GetActiveBackends())
+}
+
+// SelectBackend 在指定的后端中选择
+func (lb *LeastConnectionsLoadBalancer) SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error) {
+	activeBackends := available(candidates)
 	if len(activeBackends) == 0 {
 		return nil, errors.New("no active backends available")
 	}
 
-	// 找到连接数最少的后端
+	// 找到连接数最少的后端，连接数由传输层在请求期间更新
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
 	minConnections := int(^uint(0) >> 1) // 最大int值
 	var selectedBackend *Backend
 
@@ -220,14 +195,22 @@ func NewResponseTimeLoadBalancer(config LoadBalancerConfig) *ResponseTimeLoadBal
 
 // NextBackend 选择下一个后端服务器
 func (lb *ResponseTimeLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
-	activeBackends := lb.GetActiveBackends()
+	return lb.SelectBackend(req, lb.GetActiveBackends())
+}
+
+// SelectBackend 在指定的后端中选择
+func (lb *ResponseTimeLoadBalancer) SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error) {
+	activeBackends := available(candidates)
 	if len(activeBackends) == 0 {
 		return nil, errors.New("no active backends available")
 	}
 
-	// 找到响应时间最短的后端
+	// 找到响应时间最短的后端，响应时间由传输层在请求期间更新
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
 	var selectedBackend *Backend
-	minResponseTime := time.Duration(^int64(0)) // 最大time.Duration值
+	minResponseTime := time.Duration(math.MaxInt64) // 最大time.Duration值
 
 	for _, backend := range activeBackends {
 		// 如果响应时间为0，则认为是新的后端，给予默认值
@@ -262,7 +245,12 @@ func NewRandomLoadBalancer(config LoadBalancerConfig) *RandomLoadBalancer {
 
 // NextBackend 选择下一个后端服务器
 func (lb *RandomLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
-	activeBackends := lb.GetActiveBackends()
+	return lb.SelectBackend(req, lb.GetActiveBackends())
+}
+
+// SelectBackend 在指定的后端中选择
+func (lb *RandomLoadBalancer) SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error) {
+	activeBackends := available(candidates)
 	if len(activeBackends) == 0 {
 		return nil, errors.New("no active backends available")
 	}
@@ -292,21 +280,18 @@ func NewWeightedRandomLoadBalancer(config LoadBalancerConfig) *WeightedRandomLoa
 
 // NextBackend 选择下一个后端服务器
 func (lb *WeightedRandomLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
-	activeBackends := lb.GetActiveBackends()
+	return lb.SelectBackend(req, lb.GetActiveBackends())
+}
+
+// SelectBackend 在指定的后端中选择
+func (lb *WeightedRandomLoadBalancer) SelectBackend(req *http.Request, candidates []*Backend) (*Backend, error) {
+	activeBackends := available(candidates)
 	if len(activeBackends) == 0 {
 		return nil, errors.New("no active backends available")
 	}
 
-	// 计算总权重
-	totalWeight := 0
-	for _, backend := range activeBackends {
-		weight := backend.Weight
-		if weight <= 0 {
-			weight = 1
-		}
-		totalWeight += weight
-	}
-
+	// 计算按负载提示调整后的权重，未配置权重时默认为1
+	weights, totalWeight := effectiveWeights(activeBackends)
 	if totalWeight == 0 {
 		return nil, errors.New("invalid weights for backends")
 	}
@@ -318,13 +303,8 @@ func (lb *WeightedRandomLoadBalancer) NextBackend(req *http.Request) (*Backend,
 	targetWeight := lb.rand.Intn(totalWeight)
 
 	currentWeight := 0
-	for _, backend := range activeBackends {
-		weight := backend.Weight
-		if weight <= 0 {
-			weight = 1
-		}
-
-		currentWeight += weight
+	for i, backend := range activeBackends {
+		currentWeight += weights[i]
 		if targetWeight < currentWeight {
 			return backend, nil
 		}
@@ -333,67 +313,3 @@ func (lb *WeightedRandomLoadBalancer) NextBackend(req *http.Request) (*Backend,
 	// 如果没有找到，返回第一个
 	return activeBackends[0], nil
 }
-
-// SessionAffinityLoadBalancer 会话保持负载均衡器包装器
-type SessionAffinityLoadBalancer struct {
-	LoadBalancer
-	config LoadBalancerConfig
-}
-
-// NewSessionAffinityLoadBalancer 创建会话保持负载均衡器
-func NewSessionAffinityLoadBalancer(lb LoadBalancer, config LoadBalancerConfig) *SessionAffinityLoadBalancer {
-	return &SessionAffinityLoadBalancer{
-		LoadBalancer: lb,
-		config:       config,
-	}
-}
-
-// NextBackend 选择下一个后端服务器
-func (lb *SessionAffinityLoadBalancer) NextBackend(req *http.Request) (*Backend, error) {
-	// 如果没有启用会话保持，直接使用内部负载均衡器
-	if lb.config.SessionAffinity == nil || !lb.config.SessionAffinity.Enabled {
-		return lb.LoadBalancer.NextBackend(req)
-	}
-
-	// 尝试从Cookie获取会话信息
-	cookie, err := req.Cookie(lb.config.SessionAffinity.CookieName)
-	if err == nil && cookie.Value != "" {
-		// 如果有会话信息，尝试从会话映射中获取后端
-		backend := lb.getBackendFromSession(cookie.Value)
-		if backend != nil && backend.Active {
-			return backend, nil
-		}
-	}
-
-	// 如果没有会话信息或后端不可用，使用内部负载均衡器选择
-	backend, err := lb.LoadBalancer.NextBackend(req)
-	if err != nil {
-		return nil, err
-	}
-
-	// 设置会话Cookie
-	// 注意：这里不能直接设置响应，因为这是在请求处理阶段
-	// 需要在代理处理器的响应处理阶段设置Cookie
-
-	return backend, nil
-}
-
-// getBackendFromSession 从会话ID获取后端
-func (lb *SessionAffinityLoadBalancer) getBackendFromSession(sessionID string) *Backend {
-	// 这里简化实现，实际应用中可能需要使用Redis等存储会话映射
-	// 这里使用简单的哈希映射
-	activeBackends := lb.GetActiveBackends()
-	if len(activeBackends) == 0 {
-		return nil
-	}
-
-	hash := sha256.Sum256([]byte(sessionID))
-	index := binary.BigEndian.Uint32(hash[:4]) % uint32(len(activeBackends))
-	return activeBackends[index]
-}
-
-// GetActiveBackends 获取活跃的后端服务器列表
-func (lb *SessionAffinityLoadBalancer) GetActiveBackends() []*Backend {
-	// 直接调用内部负载均衡器的GetActiveBackends方法
-	return lb.LoadBalancer.GetActiveBackends()
-}
@@ -1,50 +1,184 @@
 package matcher
 
 import (
+	"sort"
 	"strings"
+	"unicode"
 )
 
 // HostMatcher 域名匹配器
+//
+// 支持的模式：
+//   - example.com           精确匹配
+//   - *.example.com         开头的*匹配一级或多级子域名，也匹配 example.com 本身
+//   - api.*.example.com     中间的*只匹配一级标签
+//   - *.*.internal          开头的*匹配一级或多级，其余*各匹配一级
+//
+// 域名在添加规则和匹配时都经过NormalizeHost规范化：不区分大小写，忽略末尾的点和端口，
+// 国际化域名转换为Punycode形式，Unicode和 xn-- 写法可以互相匹配
 type HostMatcher struct {
-	rules map[string]string // pattern -> target
+	rules     map[string]string // pattern -> target
+	exact     map[string]string // 规范化后的精确域名 -> target
+	wildcards []hostPattern     // 按优先级排列的通配符模式
+}
+
+// hostPattern 解析后的通配符模式
+type hostPattern struct {
+	pattern string
+	labels  []string // 规范化后的标签，"*"表示通配
+	key     string   // 规范化后的模式
+	literal int      // 非通配标签数，越多越具体
+	target  string
 }
 
 // NewHostMatcher 创建新的域名匹配器
 func NewHostMatcher() *HostMatcher {
 	return &HostMatcher{
 		rules: make(map[string]string),
+		exact: make(map[string]string),
 	}
 }
 
 // AddRule 添加域名匹配规则
 func (hm *HostMatcher) AddRule(pattern, target string) {
 	hm.rules[pattern] = target
+
+	normalized := NormalizeHost(pattern)
+	if !strings.Contains(normalized, "*") {
+		hm.exact[normalized] = target
+		return
+	}
+
+	labels := strings.Split(normalized, ".")
+	literal := 0
+	for _, label := range labels {
+		if label != "*" {
+			literal++
+		}
+	}
+
+	// 同一模式重复添加时覆盖原目标
+	for i := range hm.wildcards {
+		if hm.wildcards[i].pattern == pattern {
+			hm.wildcards[i].target = target
+			return
+		}
+	}
+	hm.wildcards = append(hm.wildcards, hostPattern{
+		pattern: pattern,
+		labels:  labels,
+		key:     normalized,
+		literal: literal,
+		target:  target,
+	})
+
+	// 更具体的模式优先：非通配标签多的优先，其次标签总数多的优先，最后按模式排序保证结果稳定
+	sort.SliceStable(hm.wildcards, func(i, j int) bool {
+		a, b := hm.wildcards[i], hm.wildcards[j]
+		if a.literal != b.literal {
+			return a.literal > b.literal
+		}
+		if len(a.labels) != len(b.labels) {
+			return len(a.labels) > len(b.labels)
+		}
+		return a.pattern < b.pattern
+	})
 }
 
-// Match 匹配域名，返回目标服务
+// Match 匹配域名，返回目标服务，host可以是带端口的Host请求头
 func (hm *HostMatcher) Match(host string) (string, bool) {
+	_, target, matched := hm.match(host)
+	return target, matched
+}
+
+// MatchPattern 匹配域名，返回匹配到的规则模式（经过NormalizeHost规范化），
+// 用于区分目标服务相同的多条规则
+func (hm *HostMatcher) MatchPattern(host string) (string, bool) {
+	pattern, _, matched := hm.match(host)
+	return pattern, matched
+}
+
+// match 匹配域名，返回规范化的模式和目标服务
+func (hm *HostMatcher) match(host string) (string, string, bool) {
+	host = NormalizeHost(host)
+
 	// 先尝试精确匹配
-	if target, exists := hm.rules[host]; exists {
-		return target, true
+	if target, exists := hm.exact[host]; exists {
+		return host, target, true
 	}
 
 	// 尝试通配符匹配
-	for pattern, target := range hm.rules {
-		if strings.HasPrefix(pattern, "*.") {
-			domain := pattern[2:] // 去掉 "*."
-			if strings.HasSuffix(host, domain) {
-				// 检查是否匹配子域名
-				if host == domain || strings.HasSuffix(host, "."+domain) {
-					return target, true
-				}
+	labels := strings.Split(host, ".")
+	for _, wildcard := range hm.wildcards {
+		if wildcard.matches(labels) {
+			return wildcard.key, wildcard.target, true
+		}
+	}
+
+	return "", "", false
+}
+
+// NormalizeHost 规范化域名：去除端口和末尾的点，转为小写，国际化域名转换为Punycode
+// 如 "EXAMPLE.com."、"example.com:8080" 都规范化为 "example.com"，"[::1]:8080" 规范化为 "::1"
+func NormalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if strings.HasPrefix(host, "[") {
+		// IPv6地址
+		if end := strings.Index(host, "]"); end != -1 {
+			host = host[1:end]
+		}
+	} else if idx := strings.LastIndex(host, ":"); idx != -1 && strings.Count(host, ":") == 1 {
+		host = host[:idx]
+	}
+	host = strings.TrimRightFunc(host, func(r rune) bool { return r == '.' || unicode.IsSpace(r) })
+	return strings.ToLower(toASCII(host))
+}
+
+// matches 检查域名标签是否匹配模式
+func (p hostPattern) matches(host []string) bool {
+	labels := p.labels
+
+	// 只有开头一个通配符的模式（*.example.com）同时匹配 example.com 本身
+	if p.literal == len(labels)-1 && labels[0] == "*" && equalLabels(labels[1:], host) {
+		return true
+	}
+
+	// 开头的*匹配一级或多级非空子域名
+	if labels[0] == "*" {
+		rest := labels[1:]
+		if len(host) <= len(rest) {
+			return false
+		}
+		for _, label := range host[:len(host)-len(rest)] {
+			if label == "" {
+				return false
 			}
 		}
+		return equalLabels(rest, host[len(host)-len(rest):])
 	}
+	return equalLabels(labels, host)
+}
 
-	return "", false
+// equalLabels 逐级比较标签，模式中的*匹配任意一级非空标签
+func equalLabels(pattern, host []string) bool {
+	if len(pattern) != len(host) {
+		return false
+	}
+	for i, label := range pattern {
+		if label == "*" {
+			if host[i] == "" {
+				return false
+			}
+			continue
+		}
+		if label != host[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // GetAllRules 获取所有规则
 func (hm *HostMatcher) GetAllRules() map[string]string {
 	return hm.rules
-}
\ No newline at end of file
+}
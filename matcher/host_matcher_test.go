@@ -0,0 +1,267 @@
+package matcher
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"EXAMPLE.com", "example.com"},
+		{"example.com.", "example.com"},
+		{"example.com:8080", "example.com"},
+		{"EXAMPLE.COM.:8080", "example.com"},
+		{" example.com ", "example.com"},
+		{"[::1]:8080", "::1"},
+		{"[2001:DB8::1]", "2001:db8::1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"例子.测试", "xn--fsqu00a.xn--0zwm56d"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeHost(tt.host); got != tt.want {
+			t.Errorf("NormalizeHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHostMatcherNormalizesHost(t *testing.T) {
+	hm := NewHostMatcher()
+	hm.AddRule("example.com", "exact")
+	hm.AddRule("*.wild.com", "wildcard")
+
+	tests := []struct {
+		host   string
+		target string
+	}{
+		{"example.com", "exact"},
+		{"EXAMPLE.com", "exact"},
+		{"example.com.", "exact"},
+		{"example.com:8080", "exact"},
+		{"Example.Com.:443", "exact"},
+		{"API.WILD.com", "wildcard"},
+		{"api.wild.com.", "wildcard"},
+		{"api.wild.com:8080", "wildcard"},
+	}
+
+	for _, tt := range tests {
+		target, ok := hm.Match(tt.host)
+		if !ok || target != tt.target {
+			t.Errorf("Match(%q) = %q, %v, want %q", tt.host, target, ok, tt.target)
+		}
+	}
+}
+
+func TestHostMatcherNormalizesPattern(t *testing.T) {
+	hm := NewHostMatcher()
+	hm.AddRule("EXAMPLE.com.", "exact")
+	hm.AddRule("*.Wild.COM", "wildcard")
+
+	for host, want := range map[string]string{
+		"example.com":      "exact",
+		"a.b.wild.com:80":  "wildcard",
+		"wild.com":         "wildcard",
+		"example.com.:443": "exact",
+	} {
+		if target, ok := hm.Match(host); !ok || target != want {
+			t.Errorf("Match(%q) = %q, %v, want %q", host, target, ok, want)
+		}
+	}
+}
+
+func TestHostMatcherOverlappingRules(t *testing.T) {
+	hm := NewHostMatcher()
+	hm.AddRule("*.example.com", "any-subdomain")
+	hm.AddRule("*.api.example.com", "api-subdomain")
+	hm.AddRule("api.*.example.com", "api-region")
+	hm.AddRule("*.*.example.com", "two-levels")
+	hm.AddRule("api.example.com", "api")
+	hm.AddRule("*.*.internal", "internal")
+
+	tests := []struct {
+		host   string
+		target string
+		ok     bool
+	}{
+		// 精确匹配优先于所有通配符
+		{"api.example.com", "api", true},
+		// 非通配标签多的优先
+		{"v1.api.example.com", "api-subdomain", true},
+		{"api.eu.example.com", "api-region", true},
+		// 非通配标签相同时标签多的优先
+		{"a.b.example.com", "two-levels", true},
+		{"www.example.com", "any-subdomain", true},
+		// 开头的*匹配多级，也匹配域名本身
+		{"a.b.c.d.example.com", "two-levels", true},
+		{"example.com", "any-subdomain", true},
+		{"a.b.internal", "internal", true},
+		{"a.b.c.internal", "internal", true},
+		// 中间的*只匹配一级非空标签
+		{"b.internal", "", false},
+		{"..example.com", "", false},
+		{"example.org", "", false},
+		{"notexample.com", "", false},
+	}
+
+	for _, tt := range tests {
+		target, ok := hm.Match(tt.host)
+		if ok != tt.ok || target != tt.target {
+			t.Errorf("Match(%q) = %q, %v, want %q, %v", tt.host, target, ok, tt.target, tt.ok)
+		}
+	}
+}
+
+func TestHostMatcherOrderIndependent(t *testing.T) {
+	rules := [][2]string{
+		{"*.example.com", "any"},
+		{"*.a.example.com", "a"},
+		{"x.*.example.com", "x"},
+	}
+	forward, backward := NewHostMatcher(), NewHostMatcher()
+	for i := range rules {
+		forward.AddRule(rules[i][0], rules[i][1])
+		backward.AddRule(rules[len(rules)-1-i][0], rules[len(rules)-1-i][1])
+	}
+
+	for _, host := range []string{"x.a.example.com", "y.a.example.com", "x.b.example.com", "example.com"} {
+		want, _ := forward.Match(host)
+		if got, _ := backward.Match(host); got != want {
+			t.Errorf("Match(%q) depends on rule order: %q vs %q", host, want, got)
+		}
+	}
+}
+
+func TestHostMatcherReplaceRule(t *testing.T) {
+	hm := NewHostMatcher()
+	hm.AddRule("*.example.com", "old")
+	hm.AddRule("*.example.com", "new")
+	hm.AddRule("example.org", "old")
+	hm.AddRule("example.org", "new")
+
+	for _, host := range []string{"www.example.com", "example.org"} {
+		if target, _ := hm.Match(host); target != "new" {
+			t.Errorf("Match(%q) = %q, want new", host, target)
+		}
+	}
+	if len(hm.wildcards) != 1 {
+		t.Errorf("expected 1 wildcard after replacing a rule, got %d", len(hm.wildcards))
+	}
+}
+
+func TestHostMatcherUnicode(t *testing.T) {
+	hm := NewHostMatcher()
+	hm.AddRule("bücher.example", "unicode-rule")
+	hm.AddRule("*.xn--fsqu00a.xn--0zwm56d", "punycode-rule")
+
+	tests := []struct {
+		host   string
+		target string
+		ok     bool
+	}{
+		{"bücher.example", "unicode-rule", true},
+		{"BÜCHER.example", "unicode-rule", true},
+		{"xn--bcher-kva.example", "unicode-rule", true},
+		{"XN--BCHER-KVA.example:443", "unicode-rule", true},
+		{"www.例子.测试", "punycode-rule", true},
+		{"例子.测试.", "punycode-rule", true},
+		{"bucher.example", "", false},
+	}
+
+	for _, tt := range tests {
+		target, ok := hm.Match(tt.host)
+		if ok != tt.ok || target != tt.target {
+			t.Errorf("Match(%q) = %q, %v, want %q, %v", tt.host, target, ok, tt.target, tt.ok)
+		}
+	}
+}
+
+func TestHostMatcherMatchPattern(t *testing.T) {
+	hm := NewHostMatcher()
+	hm.AddRule("A.example.com", "svc")
+	hm.AddRule("*.Example.com", "svc")
+
+	tests := map[string]string{
+		"a.example.com:80": "a.example.com",
+		"b.example.com":    "*.example.com",
+	}
+	for host, want := range tests {
+		if pattern, ok := hm.MatchPattern(host); !ok || pattern != want {
+			t.Errorf("MatchPattern(%q) = %q, %v, want %q", host, pattern, ok, want)
+		}
+	}
+	if _, ok := hm.MatchPattern("example.org"); ok {
+		t.Error("MatchPattern(example.org) matched")
+	}
+}
+
+func FuzzHostMatcher(f *testing.F) {
+	for _, seed := range []string{
+		"example.com", "EXAMPLE.com.", "example.com:8080", "[::1]:80", "a.b.example.com",
+		"例子.测试", "xn--bcher-kva.example", "", ".", "..", ":", "[", "*.example.com", "a..b",
+	} {
+		f.Add(seed)
+	}
+
+	hm := NewHostMatcher()
+	hm.AddRule("example.com", "exact")
+	hm.AddRule("*.example.com", "wildcard")
+	hm.AddRule("api.*.example.com", "middle")
+	hm.AddRule("*.*.internal", "internal")
+
+	f.Fuzz(func(t *testing.T, host string) {
+		normalized := NormalizeHost(host)
+		if again := NormalizeHost(normalized); again != normalized && !strings.Contains(normalized, ":") {
+			t.Fatalf("NormalizeHost not idempotent: %q -> %q -> %q", host, normalized, again)
+		}
+
+		target, ok := hm.Match(host)
+		if ok != (target != "") {
+			t.Fatalf("Match(%q) = %q, %v", host, target, ok)
+		}
+		// 规范化后的域名与原始Host匹配相同的规则
+		if ok {
+			if again, _ := hm.Match(normalized); again != target && !strings.Contains(normalized, ":") {
+				t.Fatalf("Match(%q) = %q but Match(%q) = %q", host, target, normalized, again)
+			}
+		}
+		if pattern, patternOK := hm.MatchPattern(host); patternOK != ok || (ok && pattern == "") {
+			t.Fatalf("MatchPattern(%q) = %q, %v, Match = %v", host, pattern, patternOK, ok)
+		}
+	})
+}
+
+func BenchmarkHostMatcher(b *testing.B) {
+	for _, n := range []int{10, 100, 10000} {
+		hm := NewHostMatcher()
+		for i := 0; i < n; i++ {
+			// 一半精确规则，一半通配规则
+			if i%2 == 0 {
+				hm.AddRule(fmt.Sprintf("host%d.example.com", i), "svc")
+			} else {
+				hm.AddRule(fmt.Sprintf("*.zone%d.example.com", i), "svc")
+			}
+		}
+
+		b.Run(fmt.Sprintf("exact/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				hm.Match("host0.example.com:8080")
+			}
+		})
+		b.Run(fmt.Sprintf("wildcard/%d", n), func(b *testing.B) {
+			host := fmt.Sprintf("www.zone%d.example.com", n-1)
+			for i := 0; i < b.N; i++ {
+				hm.Match(host)
+			}
+		})
+		b.Run(fmt.Sprintf("miss/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				hm.Match("www.example.org")
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package matcher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PatternKind 路径模式类型
+type PatternKind int
+
+const (
+	PatternExact  PatternKind = iota // /api/health：路径完全相等
+	PatternPrefix                    // /api/*：路径等于/api或以/api/开头
+	PatternGlob                      // /static/*.css、/files/**/raw：*匹配一级路径中的任意字符，**可跨越多级
+	PatternRegex                     // ^/api/v\d+/.*$ 或 ~/api/v\d+/.*：正则表达式，总是匹配整个路径
+)
+
+// String 返回模式类型名称
+func (k PatternKind) String() string {
+	switch k {
+	case PatternExact:
+		return "exact"
+	case PatternPrefix:
+		return "prefix"
+	case PatternGlob:
+		return "glob"
+	case PatternRegex:
+		return "regex"
+	}
+	return "unknown"
+}
+
+// PathPattern 编译后的路由路径模式
+// 模式语法：
+//   - ^...$         正则表达式（原有写法）
+//   - ~...          正则表达式，自动锚定到整个路径
+//   - .../*         前缀匹配，只能出现在末尾，按路径分段比较（/api/* 不匹配 /apiv2）
+//   - 其他含*的模式 通配：*不跨越"/"，**可跨越"/"
+//   - 其他         精确匹配
+type PathPattern struct {
+	raw    string
+	kind   PatternKind
+	prefix string         // PatternExact和PatternPrefix使用
+	re     *regexp.Regexp // PatternGlob和PatternRegex使用
+}
+
+// CompilePathPattern 编译路由路径模式
+func CompilePathPattern(pattern string) (*PathPattern, error) {
+	p := &PathPattern{raw: pattern}
+
+	switch {
+	case strings.HasPrefix(pattern, "~"):
+		re, err := regexp.Compile("^(?:" + pattern[1:] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern '%s': %v", pattern, err)
+		}
+		p.kind, p.re = PatternRegex, re
+	case strings.HasPrefix(pattern, "^") && strings.HasSuffix(pattern, "$"):
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern '%s': %v", pattern, err)
+		}
+		p.kind, p.re = PatternRegex, re
+	case strings.HasSuffix(pattern, "/*") && !strings.Contains(pattern[:len(pattern)-2], "*"):
+		p.kind, p.prefix = PatternPrefix, pattern[:len(pattern)-2]
+	case strings.Contains(pattern, "*"):
+		re, err := compileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob pattern '%s': %v", pattern, err)
+		}
+		p.kind, p.re = PatternGlob, re
+	default:
+		p.kind, p.prefix = PatternExact, pattern
+	}
+
+	return p, nil
+}
+
+// compileGlob 把通配模式转换为正则表达式，模式不是有效的UTF-8时返回错误
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '*' {
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			continue
+		}
+		if i+1 < len(pattern) && pattern[i+1] == '*' {
+			// "/**/" 同时匹配零级目录，/a/**/b 匹配 /a/b
+			if i+2 < len(pattern) && pattern[i+2] == '/' && i > 0 && pattern[i-1] == '/' {
+				b.WriteString("(?:.*/)?")
+				i += 2
+			} else {
+				b.WriteString(".*")
+				i++
+			}
+			continue
+		}
+		b.WriteString("[^/]*")
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Match 检查路径是否匹配
+func (p *PathPattern) Match(path string) bool {
+	switch p.kind {
+	case PatternExact:
+		return path == p.prefix
+	case PatternPrefix:
+		return path == p.prefix || strings.HasPrefix(path, p.prefix+"/")
+	default:
+		return p.re.MatchString(path)
+	}
+}
+
+// Kind 返回模式类型
+func (p *PathPattern) Kind() PatternKind {
+	return p.kind
+}
+
+// String 返回原始模式
+func (p *PathPattern) String() string {
+	return p.raw
+}
@@ -1,64 +1,91 @@
 package matcher
 
 import (
-	"regexp"
-	"strings"
+	"sort"
 )
 
-// RouteMatcher 路由匹配器
+// RouteMatcher 路由匹配器，模式语法见PathPattern，规则在添加时编译一次
+// 匹配顺序：精确匹配 > 前缀匹配（前缀越长越优先）> 通配和正则表达式（按添加顺序）；
+// 同一模式添加多次时按添加顺序依次匹配，调用方可以跳过不可用的规则（见Walk）
 type RouteMatcher struct {
-	rules map[string]string // pattern -> target
+	rules    map[string]string // pattern -> 第一次添加时的target
+	targets  []string          // 按添加顺序的目标，下标为规则序号
+	exact    map[string][]int  // 精确路径 -> 规则序号
+	patterns []routePattern    // 前缀、通配和正则模式，按匹配顺序排列
+}
+
+// routePattern 编译后的路由规则
+type routePattern struct {
+	pattern *PathPattern
+	index   int // 规则序号，同时是添加顺序
 }
 
 // NewRouteMatcher 创建新的路由匹配器
 func NewRouteMatcher() *RouteMatcher {
 	return &RouteMatcher{
 		rules: make(map[string]string),
+		exact: make(map[string][]int),
 	}
 }
 
-// AddRule 添加路由匹配规则
-func (rm *RouteMatcher) AddRule(pattern, target string) {
-	rm.rules[pattern] = target
-}
+// AddRule 添加路由匹配规则，模式无效时返回错误且不添加
+// 规则序号按成功添加的顺序从0开始编号
+func (rm *RouteMatcher) AddRule(pattern, target string) error {
+	compiled, err := CompilePathPattern(pattern)
+	if err != nil {
+		return err
+	}
+	index := len(rm.targets)
+	rm.targets = append(rm.targets, target)
+	if _, exists := rm.rules[pattern]; !exists {
+		rm.rules[pattern] = target
+	}
 
-// Match 匹配路由路径，返回目标服务
-func (rm *RouteMatcher) Match(path string) (string, bool) {
-	// 先尝试精确匹配
-	if target, exists := rm.rules[path]; exists {
-		return target, true
+	if compiled.Kind() == PatternExact {
+		rm.exact[pattern] = append(rm.exact[pattern], index)
+		return nil
 	}
 
-	// 尝试通配符匹配
-	for pattern, target := range rm.rules {
-		if strings.HasSuffix(pattern, "/*") {
-			prefix := pattern[:len(pattern)-2] // 去掉 "/*"
-			if strings.HasPrefix(path, prefix) {
-				// 检查是否匹配路径前缀
-				if path == prefix || strings.HasPrefix(path, prefix+"/") {
-					return target, true
-				}
-			}
+	rm.patterns = append(rm.patterns, routePattern{pattern: compiled, index: index})
+	sort.SliceStable(rm.patterns, func(i, j int) bool {
+		a, b := rm.patterns[i], rm.patterns[j]
+		aPrefix, bPrefix := a.pattern.Kind() == PatternPrefix, b.pattern.Kind() == PatternPrefix
+		if aPrefix != bPrefix {
+			return aPrefix
 		}
-	}
+		if aPrefix && len(a.pattern.prefix) != len(b.pattern.prefix) {
+			return len(a.pattern.prefix) > len(b.pattern.prefix)
+		}
+		return a.index < b.index
+	})
+	return nil
+}
 
-	// 尝试正则表达式匹配
-	for pattern, target := range rm.rules {
-		if strings.HasPrefix(pattern, "^") && strings.HasSuffix(pattern, "$") {
-			// 如果模式以^开头且以$结尾，尝试作为正则表达式匹配
-			re, err := regexp.Compile(pattern)
-			if err == nil {
-				if re.MatchString(path) {
-					return target, true
-				}
-			}
+// Walk 按匹配顺序依次访问匹配路径的规则序号，visit返回false时停止
+func (rm *RouteMatcher) Walk(path string, visit func(index int) bool) {
+	for _, index := range rm.exact[path] {
+		if !visit(index) {
+			return
+		}
+	}
+	for _, rule := range rm.patterns {
+		if rule.pattern.Match(path) && !visit(rule.index) {
+			return
 		}
 	}
+}
 
-	return "", false
+// Match 匹配路由路径，返回优先级最高的规则的目标服务
+func (rm *RouteMatcher) Match(path string) (string, bool) {
+	target, matched := "", false
+	rm.Walk(path, func(index int) bool {
+		target, matched = rm.targets[index], true
+		return false
+	})
+	return target, matched
 }
 
-// GetAllRules 获取所有规则
+// GetAllRules 获取所有规则，同一模式添加多次时为第一次添加的目标
 func (rm *RouteMatcher) GetAllRules() map[string]string {
 	return rm.rules
 }
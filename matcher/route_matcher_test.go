@@ -0,0 +1,325 @@
+package matcher
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRouteMatcherOverlappingRules(t *testing.T) {
+	rm := NewRouteMatcher()
+	for _, rule := range [][2]string{
+		{"/**", "catch-all"},
+		{"/api/*", "api"},
+		{"/api/v1/*", "api-v1"},
+		{"/api/v1/health", "health"},
+		{"~/api/v[0-9]+/users/[0-9]+", "user"},
+		{"/static/*.css", "css"},
+		{"/files/**/raw", "raw"},
+	} {
+		if err := rm.AddRule(rule[0], rule[1]); err != nil {
+			t.Fatalf("AddRule(%q): %v", rule[0], err)
+		}
+	}
+
+	tests := []struct {
+		path   string
+		target string
+	}{
+		// 精确匹配优先于前缀
+		{"/api/v1/health", "health"},
+		// 前缀越长越优先，且优先于通配和正则
+		{"/api/v1/users/42", "api-v1"},
+		{"/api/v2/users/42", "api"},
+		{"/api", "api"},
+		{"/api/", "api"},
+		// 前缀按路径分段比较
+		{"/apiv2", "catch-all"},
+		{"/api/v10", "api"},
+		// 通配和正则按添加顺序，/** 先添加
+		{"/static/site.css", "catch-all"},
+		{"/files/a/b/raw", "catch-all"},
+	}
+
+	for _, tt := range tests {
+		target, ok := rm.Match(tt.path)
+		if !ok || target != tt.target {
+			t.Errorf("Match(%q) = %q, %v, want %q", tt.path, target, ok, tt.target)
+		}
+	}
+}
+
+func TestRouteMatcherWalkDuplicatePatterns(t *testing.T) {
+	rm := NewRouteMatcher()
+	for _, rule := range [][2]string{
+		{"/**", "catch-all"},
+		{"/api/*", "night"},
+		{"/api/*", "api"},
+		{"/api/v1/*", "api-v1"},
+		{"/api/v1/health", "health"},
+	} {
+		if err := rm.AddRule(rule[0], rule[1]); err != nil {
+			t.Fatalf("AddRule(%q): %v", rule[0], err)
+		}
+	}
+
+	// 依次访问所有匹配的规则，同一模式按添加顺序
+	var order []int
+	rm.Walk("/api/v1/health", func(index int) bool {
+		order = append(order, index)
+		return true
+	})
+	if fmt.Sprint(order) != "[4 3 1 2 0]" {
+		t.Errorf("Walk order = %v, want [4 3 1 2 0]", order)
+	}
+
+	// visit返回false时停止
+	order = nil
+	rm.Walk("/api/users", func(index int) bool {
+		order = append(order, index)
+		return index != 1
+	})
+	if fmt.Sprint(order) != "[1]" {
+		t.Errorf("Walk stopped at %v, want [1]", order)
+	}
+	if target, _ := rm.Match("/api/users"); target != "night" {
+		t.Errorf("Match with a duplicate pattern = %q, want the first added", target)
+	}
+}
+
+func TestRouteMatcherGlobAndRegex(t *testing.T) {
+	rm := NewRouteMatcher()
+	for _, rule := range [][2]string{
+		{"~/api/v[0-9]+/users/[0-9]+", "user"},
+		{"^/legacy/.*$", "legacy"},
+		{"/static/*.css", "css"},
+		{"/files/**/raw", "raw"},
+	} {
+		if err := rm.AddRule(rule[0], rule[1]); err != nil {
+			t.Fatalf("AddRule(%q): %v", rule[0], err)
+		}
+	}
+
+	tests := []struct {
+		path   string
+		target string
+		ok     bool
+	}{
+		{"/api/v2/users/42", "user", true},
+		// 正则表达式总是匹配整个路径
+		{"/api/v2/users/42/posts", "", false},
+		{"/x/api/v2/users/42", "", false},
+		{"/legacy/anything/at/all", "legacy", true},
+		{"/static/site.css", "css", true},
+		// *不跨越/
+		{"/static/css/site.css", "", false},
+		{"/static/site.cssx", "", false},
+		// /**/ 匹配零级或多级目录
+		{"/files/raw", "raw", true},
+		{"/files/a/raw", "raw", true},
+		{"/files/a/b/c/raw", "raw", true},
+		{"/files/a/rawx", "", false},
+	}
+
+	for _, tt := range tests {
+		target, ok := rm.Match(tt.path)
+		if ok != tt.ok || target != tt.target {
+			t.Errorf("Match(%q) = %q, %v, want %q, %v", tt.path, target, ok, tt.target, tt.ok)
+		}
+	}
+}
+
+func TestRouteMatcherAdversarialPaths(t *testing.T) {
+	rm := NewRouteMatcher()
+	for _, rule := range [][2]string{
+		{"/admin/*", "admin"},
+		{"/public/*", "public"},
+		{"/static/*.css", "css"},
+		{"~/id/[0-9]+", "id"},
+	} {
+		if err := rm.AddRule(rule[0], rule[1]); err != nil {
+			t.Fatalf("AddRule(%q): %v", rule[0], err)
+		}
+	}
+
+	// 匹配器按原样比较路径，点段、重复斜杠和编码字符由代理在匹配前规范化（见proxy.normalizeRequest）
+	tests := []struct {
+		path   string
+		target string
+		ok     bool
+	}{
+		{"/public/../admin", "public", true},
+		{"//admin", "", false},
+		{"/ADMIN/x", "", false},
+		{"/admin%2Fx", "", false},
+		{"/admin\x00/x", "", false},
+		{"/adminx", "", false},
+		{"/static/../x.css", "", false},
+		{"/static/a\nb.css", "css", true},
+		{"/id/12\n", "", false},
+		{"/id/١٢", "", false},
+		{"", "", false},
+		{"/" + strings.Repeat("a/", 10000) + "admin", "", false},
+	}
+
+	for _, tt := range tests {
+		target, ok := rm.Match(tt.path)
+		if ok != tt.ok || target != tt.target {
+			t.Errorf("Match(%q) = %q, %v, want %q, %v", tt.path, target, ok, tt.target, tt.ok)
+		}
+	}
+}
+
+func TestRouteMatcherInvalidPattern(t *testing.T) {
+	rm := NewRouteMatcher()
+	for _, pattern := range []string{"~/api/(", "^/api/[$", "/api/\x8b*"} {
+		if err := rm.AddRule(pattern, "svc"); err == nil {
+			t.Errorf("AddRule(%q) accepted an invalid regex", pattern)
+		}
+		if _, err := CompilePathPattern(pattern); err == nil {
+			t.Errorf("CompilePathPattern(%q) accepted an invalid regex", pattern)
+		}
+	}
+	if len(rm.GetAllRules()) != 0 {
+		t.Errorf("invalid patterns were added: %v", rm.GetAllRules())
+	}
+}
+
+func TestCompilePathPatternKind(t *testing.T) {
+	tests := map[string]PatternKind{
+		"/api/health":     PatternExact,
+		"/api/*":          PatternPrefix,
+		"/*":              PatternPrefix,
+		"/api/*/users":    PatternGlob,
+		"/api/*/*":        PatternGlob,
+		"/files/**":       PatternGlob,
+		"~/api/v[0-9]+":   PatternRegex,
+		"^/api/v[0-9]+$":  PatternRegex,
+		"^/not-anchored":  PatternExact,
+		"/literal$":       PatternExact,
+		"/api/v1.0/(x)?+": PatternExact,
+	}
+
+	for pattern, want := range tests {
+		p, err := CompilePathPattern(pattern)
+		if err != nil {
+			t.Errorf("CompilePathPattern(%q): %v", pattern, err)
+			continue
+		}
+		if p.Kind() != want {
+			t.Errorf("CompilePathPattern(%q).Kind() = %v, want %v", pattern, p.Kind(), want)
+		}
+		if p.String() != pattern {
+			t.Errorf("CompilePathPattern(%q).String() = %q", pattern, p.String())
+		}
+	}
+}
+
+func FuzzRouteMatcher(f *testing.F) {
+	patterns := []string{"/api/*", "/static/*.css", "/files/**/raw", "~/id/[0-9]+", "/exact"}
+	for _, seed := range []string{
+		"/api", "/api/", "/api/x", "/apix", "/static/a.css", "/files/raw", "/files/a/b/raw",
+		"/id/1", "/exact", "", "/", "//", "/../", "/%2F", "/\x00",
+	} {
+		f.Add(seed)
+	}
+
+	rm := NewRouteMatcher()
+	compiled := make(map[string]*PathPattern, len(patterns))
+	for _, pattern := range patterns {
+		if err := rm.AddRule(pattern, pattern); err != nil {
+			f.Fatal(err)
+		}
+		compiled[pattern], _ = CompilePathPattern(pattern)
+	}
+
+	f.Fuzz(func(t *testing.T, path string) {
+		target, ok := rm.Match(path)
+		if ok != (target != "") {
+			t.Fatalf("Match(%q) = %q, %v", path, target, ok)
+		}
+		// 匹配结果必须与逐条检查的结果一致：匹配到的模式自身匹配路径
+		if ok && !compiled[target].Match(path) {
+			t.Fatalf("Match(%q) = %q but the pattern does not match", path, target)
+		}
+		if !ok {
+			for _, pattern := range patterns {
+				if compiled[pattern].Match(path) {
+					t.Fatalf("Match(%q) missed %q", path, pattern)
+				}
+			}
+		}
+		// 前缀模式按路径分段比较
+		if target == "/api/*" && path != "/api" && !strings.HasPrefix(path, "/api/") {
+			t.Fatalf("/api/* matched %q", path)
+		}
+	})
+}
+
+func FuzzCompilePathPattern(f *testing.F) {
+	for _, seed := range []string{"/api/*", "/a/**/b", "~/x/(", "^/x$", "*", "**", "/**/", "~", "^$", "/*/*"} {
+		f.Add(seed, "/api/x")
+	}
+
+	f.Fuzz(func(t *testing.T, pattern, path string) {
+		p, err := CompilePathPattern(pattern)
+		if err != nil {
+			if !strings.HasPrefix(pattern, "~") && !strings.HasPrefix(pattern, "^") && utf8.ValidString(pattern) {
+				t.Fatalf("CompilePathPattern(%q) failed for a non-regex pattern: %v", pattern, err)
+			}
+			return
+		}
+		// 没有通配符的普通模式只匹配自身
+		if p.Kind() == PatternExact && p.Match(path) != (path == pattern) {
+			t.Fatalf("exact pattern %q: Match(%q) = %v", pattern, path, p.Match(path))
+		}
+		p.Match(path)
+	})
+}
+
+func BenchmarkRouteMatcher(b *testing.B) {
+	for _, n := range []int{10, 100, 10000} {
+		rm := NewRouteMatcher()
+		lastGlob := 0
+		for i := 0; i < n; i++ {
+			// 精确、前缀和通配规则各占三分之一
+			var pattern string
+			switch i % 3 {
+			case 0:
+				pattern = fmt.Sprintf("/exact/%d", i)
+			case 1:
+				pattern = fmt.Sprintf("/prefix/%d/*", i)
+			default:
+				pattern = fmt.Sprintf("/glob/%d/*.json", i)
+				lastGlob = i
+			}
+			if err := rm.AddRule(pattern, "svc"); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.Run(fmt.Sprintf("exact/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rm.Match("/exact/0")
+			}
+		})
+		b.Run(fmt.Sprintf("prefix/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rm.Match("/prefix/1/users/42")
+			}
+		})
+		// 最后添加的通配规则，需要依次检查所有前缀和通配规则
+		b.Run(fmt.Sprintf("glob/%d", n), func(b *testing.B) {
+			path := fmt.Sprintf("/glob/%d/data.json", lastGlob)
+			for i := 0; i < b.N; i++ {
+				rm.Match(path)
+			}
+		})
+		b.Run(fmt.Sprintf("miss/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rm.Match("/missing/path")
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 指标类型
+const (
+	TypeCounter = "counter"
+	TypeGauge   = "gauge"
+)
+
+// Registry 指标注册表，以Prometheus文本格式输出所有指标
+type Registry struct {
+	collectors map[string]collector
+	mu         sync.RWMutex
+}
+
+// collector 可输出的指标
+type collector interface {
+	describe() (name, help, kind string)
+	write(w io.Writer)
+}
+
+// NewRegistry 创建指标注册表
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]collector),
+	}
+}
+
+// NewCounterVec 注册带标签的计数器，同名计数器已存在时返回已有实例
+// 插件重新加载时会重复注册，因此不把重复注册视为错误
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return r.register(name, TypeCounter, func() collector {
+		return &CounterVec{vec: newVec(name, help, TypeCounter, labelNames)}
+	}).(*CounterVec)
+}
+
+// NewGaugeVec 注册带标签的仪表盘指标，同名指标已存在时返回已有实例
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return r.register(name, TypeGauge, func() collector {
+		return &GaugeVec{vec: newVec(name, help, TypeGauge, labelNames)}
+	}).(*GaugeVec)
+}
+
+// NewInfoVec 注册信息指标，同名指标已存在时返回已有实例
+func (r *Registry) NewInfoVec(name, help string) *InfoVec {
+	return r.register(name, TypeGauge, func() collector {
+		return &InfoVec{name: name, help: help, samples: make(map[string]bool)}
+	}).(*InfoVec)
+}
+
+// register 注册指标，同名同类型时返回已有实例
+func (r *Registry) register(name, kind string, create func() collector) collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.collectors[name]; exists {
+		if _, _, existingKind := existing.describe(); existingKind != kind {
+			panic(fmt.Sprintf("metric '%s' already registered as %s", name, existingKind))
+		}
+		return existing
+	}
+
+	c := create()
+	r.collectors[name] = c
+	return c
+}
+
+// WritePrometheus 以Prometheus文本格式输出所有指标，按名称排序
+func (r *Registry) WritePrometheus(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.RUnlock()
+
+	for _, c := range collectors {
+		name, help, kind := c.describe()
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		c.write(w)
+	}
+}
+
+// vec 按标签值分组的指标值
+type vec struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	values     map[string]*sample
+	mu         sync.RWMutex
+}
+
+// sample 一组标签值对应的指标值
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func newVec(name, help, kind string, labelNames []string) *vec {
+	return &vec{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		values:     make(map[string]*sample),
+	}
+}
+
+func (v *vec) describe() (string, string, string) {
+	return v.name, v.help, v.kind
+}
+
+// update 修改标签值对应的指标值，标签值个数与标签名不一致时panic
+func (v *vec) update(labelValues []string, fn func(current float64) float64) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric '%s': expected %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, exists := v.values[key]
+	if !exists {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		v.values[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+// get 获取标签值对应的指标值
+func (v *vec) get(labelValues []string) float64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if s, exists := v.values[strings.Join(labelValues, "\xff")]; exists {
+		return s.value
+	}
+	return 0
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.RLock()
+	samples := make([]sample, 0, len(v.values))
+	for _, s := range v.values {
+		samples = append(samples, *s)
+	}
+	v.mu.RUnlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].labelValues, "\xff") < strings.Join(samples[j].labelValues, "\xff")
+	})
+
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labelNames, s.labelValues), strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+// CounterVec 带标签的计数器，只能增加
+type CounterVec struct {
+	*vec
+}
+
+// Inc 计数加1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 计数增加delta，delta不能为负数
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metric '%s': counter cannot decrease", c.name))
+	}
+	c.update(labelValues, func(current float64) float64 { return current + delta })
+}
+
+// Value 获取当前计数
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+// GaugeVec 带标签的仪表盘指标，可增可减
+type GaugeVec struct {
+	*vec
+}
+
+// Set 设置指标值
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add 指标值增加delta，delta可以为负数
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.update(labelValues, func(current float64) float64 { return current + delta })
+}
+
+// Value 获取当前指标值
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+// Reset 删除所有样本，配置重新加载后对象（如规则）可能已不存在
+func (g *GaugeVec) Reset() {
+	g.mu.Lock()
+	g.values = make(map[string]*sample)
+	g.mu.Unlock()
+}
+
+// InfoVec 信息指标，值总是1，每组样本可以有不同的标签名
+// 用于发布配置中的元数据（如规则标签），查询时按共同的标签与其他指标关联
+type InfoVec struct {
+	name    string
+	help    string
+	samples map[string]bool // 格式化后的标签，如 {route="api.example.com",team="payments"}
+	mu      sync.RWMutex
+}
+
+func (v *InfoVec) describe() (string, string, string) {
+	return v.name, v.help, TypeGauge
+}
+
+// Set 发布一组标签，相同的标签组合只输出一次
+func (v *InfoVec) Set(labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+
+	v.mu.Lock()
+	v.samples[formatLabels(names, values)] = true
+	v.mu.Unlock()
+}
+
+// Reset 删除所有样本，配置重新加载时使用
+func (v *InfoVec) Reset() {
+	v.mu.Lock()
+	v.samples = make(map[string]bool)
+	v.mu.Unlock()
+}
+
+func (v *InfoVec) write(w io.Writer) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.samples))
+	for key := range v.samples {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s 1\n", v.name, key)
+	}
+}
+
+// formatLabels 格式化标签，如 {reason="url_too_long"}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// escapeLabelValue 转义标签值中的反斜杠、双引号和换行
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// escapeHelp 转义帮助文本中的反斜杠和换行
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// 全局默认指标注册表实例
+var defaultRegistry = NewRegistry()
+
+// GetDefaultRegistry 获取默认指标注册表实例
+func GetDefaultRegistry() *Registry {
+	return defaultRegistry
+}
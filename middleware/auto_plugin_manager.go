@@ -1,79 +1,100 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"plugin"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+)
+
+// 插件目录默认值
+const (
+	DefaultPluginSourceDir = "middleware/plugins"
+	DefaultPluginCacheDir  = "cache/plugins"
 )
 
 // AutoPluginManager 自动插件管理器，负责自动编译和加载插件
 type AutoPluginManager struct {
-	plugins       map[string]*plugin.Plugin
+	plugins       map[string]PluginHandle
 	pluginSources map[string]string // 插件源代码路径
-	cacheDir      string             // 缓存目录
-	sourceDir     string             // 插件源代码目录
+	cacheDir      string            // 缓存目录
+	sourceDir     string            // 插件源代码目录
 	mu            sync.RWMutex
+
+	precompiledOnly bool            // 只加载预编译插件，不调用Go工具链
+	manifest        *PluginManifest // 预编译插件清单
+	manifestErr     error
+	manifestOnce    sync.Once
 }
 
 // NewAutoPluginManager 创建新的自动插件管理器
+// 缓存目录在第一次编译时创建，只加载预编译插件时不会写入任何目录
 func NewAutoPluginManager(sourceDir, cacheDir string) *AutoPluginManager {
-	// 确保缓存目录存在
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		log.Printf("Failed to create cache directory: %v", err)
-	}
-
 	return &AutoPluginManager{
-		plugins:       make(map[string]*plugin.Plugin),
+		plugins:       make(map[string]PluginHandle),
 		pluginSources: make(map[string]string),
 		cacheDir:      cacheDir,
 		sourceDir:     sourceDir,
 	}
 }
 
-// LoadPlugin 加载插件，如果缓存中没有则自动编译
-func (apm *AutoPluginManager) LoadPlugin(pluginName string) (*plugin.Plugin, error) {
+// SetPrecompiledOnly 设置是否只加载预编译插件
+// 开启后插件列表和so文件均来自缓存目录中的清单，运行时不会编译插件
+func (apm *AutoPluginManager) SetPrecompiledOnly(precompiledOnly bool) {
+	apm.precompiledOnly = precompiledOnly
+}
+
+// loadManifest 读取预编译插件清单，只读取一次
+func (apm *AutoPluginManager) loadManifest() (*PluginManifest, error) {
+	apm.manifestOnce.Do(func() {
+		apm.manifest, apm.manifestErr = ReadPluginManifest(apm.cacheDir)
+		if apm.manifestErr != nil {
+			apm.manifestErr = fmt.Errorf("failed to read precompiled plugin manifest in '%s': %v", apm.cacheDir, apm.manifestErr)
+		}
+	})
+	return apm.manifest, apm.manifestErr
+}
+
+// LoadPlugin 加载插件，如果缓存中没有与源代码匹配的so文件则自动编译
+func (apm *AutoPluginManager) LoadPlugin(pluginName string) (PluginHandle, error) {
 	apm.mu.Lock()
 	defer apm.mu.Unlock()
 
+	return apm.loadPlugin(pluginName)
+}
+
+// loadPlugin 加载插件，调用方需持有锁
+func (apm *AutoPluginManager) loadPlugin(pluginName string) (PluginHandle, error) {
 	// 检查插件是否已经加载
 	if p, exists := apm.plugins[pluginName]; exists {
 		return p, nil
 	}
 
-	// 检查缓存目录中是否有编译好的so文件
-	cachePath := filepath.Join(apm.cacheDir, pluginName+".so")
-	if _, err := os.Stat(cachePath); err == nil {
-		// 缓存文件存在，直接加载
-		log.Printf("Loading plugin '%s' from cache", pluginName)
-		return apm.loadPluginFromCache(pluginName, cachePath)
-	}
-
-	// 缓存文件不存在，尝试从源代码编译
-	sourcePath := filepath.Join(apm.sourceDir, pluginName)
-	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("plugin source directory '%s' does not exist", sourcePath)
+	result := apm.CompilePlugin(pluginName)
+	if result.Err != nil {
+		return nil, result.Err
 	}
 
-	// 编译插件
-	log.Printf("Compiling plugin '%s' from source", pluginName)
-	if err := apm.compilePlugin(pluginName, sourcePath, cachePath); err != nil {
-		return nil, fmt.Errorf("failed to compile plugin '%s': %v", pluginName, err)
+	if result.Cached {
+		log.Printf("Loading plugin '%s' from cache", pluginName)
 	}
-
-	// 从缓存加载编译好的插件
-	return apm.loadPluginFromCache(pluginName, cachePath)
+	return apm.loadPluginFromCache(pluginName, result.CachePath)
 }
 
 // loadPluginFromCache 从缓存加载插件
-func (apm *AutoPluginManager) loadPluginFromCache(pluginName, cachePath string) (*plugin.Plugin, error) {
-	p, err := plugin.Open(cachePath)
+func (apm *AutoPluginManager) loadPluginFromCache(pluginName, cachePath string) (PluginHandle, error) {
+	p, err := openPlugin(cachePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open plugin from cache: %v", err)
 	}
@@ -86,6 +107,240 @@ func (apm *AutoPluginManager) loadPluginFromCache(pluginName, cachePath string)
 	return p, nil
 }
 
+// PluginCompileResult 单个插件的编译结果
+type PluginCompileResult struct {
+	Name      string
+	CachePath string
+	Cached    bool // 缓存命中，未重新编译
+	Duration  time.Duration
+	Err       error
+}
+
+// PluginCompileReport 插件编译报告
+type PluginCompileReport struct {
+	Results  []PluginCompileResult
+	Duration time.Duration
+}
+
+// Failed 返回编译失败的插件
+func (r *PluginCompileReport) Failed() []PluginCompileResult {
+	var failed []PluginCompileResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Log 输出编译报告
+func (r *PluginCompileReport) Log() {
+	compiled, cached := 0, 0
+	for _, result := range r.Results {
+		switch {
+		case result.Err != nil:
+			log.Printf("  [FAILED]   %s: %v", result.Name, result.Err)
+		case result.Cached:
+			cached++
+			log.Printf("  [CACHED]   %s", result.Name)
+		default:
+			compiled++
+			log.Printf("  [COMPILED] %s (%v)", result.Name, result.Duration.Round(time.Millisecond))
+		}
+	}
+	log.Printf("Plugin compilation finished in %v: %d compiled, %d cached, %d failed",
+		r.Duration.Round(time.Millisecond), compiled, cached, len(r.Failed()))
+}
+
+// CompileAll 并行编译插件，源代码未变化的插件直接使用缓存
+// workers小于等于0时使用CPU核数
+func (apm *AutoPluginManager) CompileAll(pluginNames []string, workers int) *PluginCompileReport {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	startTime := time.Now()
+	results := make([]PluginCompileResult, len(pluginNames))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = apm.CompilePlugin(pluginNames[idx])
+			}
+		}()
+	}
+
+	for idx := range pluginNames {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	return &PluginCompileReport{
+		Results:  results,
+		Duration: time.Since(startTime),
+	}
+}
+
+// CompilePlugin 确保插件已编译到缓存目录
+// 缓存文件以源代码哈希命名，哈希一致时跳过编译
+func (apm *AutoPluginManager) CompilePlugin(pluginName string) PluginCompileResult {
+	startTime := time.Now()
+	result := PluginCompileResult{Name: pluginName}
+
+	// 只使用预编译插件时从清单中查找so文件
+	if apm.precompiledOnly {
+		manifest, err := apm.loadManifest()
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		file, exists := manifest.Plugins[pluginName]
+		if !exists {
+			result.Err = fmt.Errorf("plugin '%s' is not precompiled, run 'toyou-proxy plugins build' first", pluginName)
+			return result
+		}
+		result.CachePath = filepath.Join(apm.cacheDir, file)
+		result.Cached = true
+		result.Duration = time.Since(startTime)
+		return result
+	}
+
+	sourcePath := filepath.Join(apm.sourceDir, pluginName)
+	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
+		result.Err = fmt.Errorf("plugin source directory '%s' does not exist", sourcePath)
+		return result
+	}
+
+	hash, err := apm.sourceHash(sourcePath)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to hash plugin '%s': %v", pluginName, err)
+		return result
+	}
+
+	result.CachePath = apm.cachePath(pluginName, hash)
+	if _, err := os.Stat(result.CachePath); err == nil {
+		result.Cached = true
+		result.Duration = time.Since(startTime)
+		return result
+	}
+
+	log.Printf("Compiling plugin '%s' from source", pluginName)
+	if err := apm.compilePlugin(pluginName, sourcePath, result.CachePath); err != nil {
+		result.Err = fmt.Errorf("failed to compile plugin '%s': %v", pluginName, err)
+		return result
+	}
+
+	// 清理该插件旧版本的缓存文件
+	apm.removeStaleCache(pluginName, result.CachePath)
+
+	result.Duration = time.Since(startTime)
+	return result
+}
+
+// cachePath 返回插件缓存文件路径
+func (apm *AutoPluginManager) cachePath(pluginName, hash string) string {
+	return filepath.Join(apm.cacheDir, pluginName+"-"+hash+".so")
+}
+
+// sourceHash 计算插件源代码哈希
+// 除插件自身的Go文件外，还包含Go版本、go.mod/go.sum以及插件依赖的主程序中间件包，
+// 任意一项变化都会导致插件与主程序不兼容，需要重新编译
+func (apm *AutoPluginManager) sourceHash(sourcePath string) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, runtime.Version())
+
+	hashFiles := func(dir string) error {
+		goFiles, err := apm.findGoFiles(dir)
+		if err != nil {
+			return err
+		}
+		sort.Strings(goFiles)
+		for _, goFile := range goFiles {
+			if err := hashFile(h, filepath.Join(dir, goFile)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := hashFiles(sourcePath); err != nil {
+		return "", err
+	}
+
+	// 插件目录的上级目录即主程序的中间件包
+	if err := hashFiles(filepath.Dir(apm.sourceDir)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	for _, name := range []string{"go.mod", "go.sum"} {
+		if err := hashFile(h, name); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// hashFile 将文件名和内容写入哈希
+func hashFile(h io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	io.WriteString(h, filepath.Base(path))
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// removeStaleCache 删除插件旧版本的缓存文件
+func (apm *AutoPluginManager) removeStaleCache(pluginName, currentPath string) {
+	matches, err := filepath.Glob(filepath.Join(apm.cacheDir, pluginName+"-*.so"))
+	if err != nil {
+		return
+	}
+
+	for _, path := range matches {
+		if path == currentPath {
+			continue
+		}
+		// 其他插件名可能以该插件名为前缀，确认后缀是哈希
+		suffix := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), pluginName+"-"), ".so")
+		if len(suffix) != 16 || strings.Contains(suffix, "-") {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove stale cache file '%s': %v", path, err)
+		}
+	}
+}
+
+// BuildAll 编译所有发现的插件并写入预编译插件清单
+// 用于CI或镜像构建阶段预先填充缓存目录，生产环境启动时无需Go工具链
+func (apm *AutoPluginManager) BuildAll(workers int) (*PluginCompileReport, error) {
+	plugins, err := apm.DiscoverPlugins()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(apm.cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	report := apm.CompileAll(plugins, workers)
+	if err := writePluginManifest(apm.cacheDir, report); err != nil {
+		return report, fmt.Errorf("failed to write plugin manifest: %v", err)
+	}
+
+	return report, nil
+}
+
 // compilePlugin 编译插件
 func (apm *AutoPluginManager) compilePlugin(pluginName, sourcePath, cachePath string) error {
 	// 查找插件源文件
@@ -109,8 +364,10 @@ func (apm *AutoPluginManager) compilePlugin(pluginName, sourcePath, cachePath st
 		return fmt.Errorf("failed to get absolute path for cache: %v", err)
 	}
 
-	args := []string{"build", "-buildmode=plugin", "-o", absCachePath}
-	
+	// 先输出到临时文件再重命名，避免并发加载到未写完的文件
+	tmpPath := fmt.Sprintf("%s.%d.tmp", absCachePath, os.Getpid())
+	args := []string{"build", "-buildmode=plugin", "-o", tmpPath}
+
 	// 添加源文件的完整路径
 	for _, goFile := range goFiles {
 		absGoFile := filepath.Join(sourcePath, goFile)
@@ -123,9 +380,15 @@ func (apm *AutoPluginManager) compilePlugin(pluginName, sourcePath, cachePath st
 	// 捕获输出
 	output, err := cmd.CombinedOutput()
 	if err != nil {
+		os.Remove(tmpPath)
 		return fmt.Errorf("compilation failed: %v\nOutput: %s", err, string(output))
 	}
 
+	if err := os.Rename(tmpPath, absCachePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move compiled plugin into cache: %v", err)
+	}
+
 	log.Printf("Successfully compiled plugin '%s' to %s", pluginName, cachePath)
 	return nil
 }
@@ -203,6 +466,14 @@ func (apm *AutoPluginManager) GetPluginMetadata(pluginName string) (*PluginMetad
 
 // DiscoverPlugins 发现所有可用的插件
 func (apm *AutoPluginManager) DiscoverPlugins() ([]string, error) {
+	if apm.precompiledOnly {
+		manifest, err := apm.loadManifest()
+		if err != nil {
+			return nil, err
+		}
+		return manifest.Names(), nil
+	}
+
 	if _, err := os.Stat(apm.sourceDir); os.IsNotExist(err) {
 		return nil, fmt.Errorf("plugin source directory '%s' does not exist", apm.sourceDir)
 	}
@@ -242,18 +513,29 @@ func (apm *AutoPluginManager) ReloadPlugin(pluginName string) error {
 	apm.mu.Lock()
 	defer apm.mu.Unlock()
 
+	// 预编译插件不能在运行时重新编译，也不能删除
+	if apm.precompiledOnly {
+		return fmt.Errorf("cannot reload plugin '%s': only precompiled plugins are allowed", pluginName)
+	}
+
 	// 从内存中移除插件
 	delete(apm.plugins, pluginName)
 	delete(apm.pluginSources, pluginName)
 
 	// 删除缓存文件
-	cachePath := filepath.Join(apm.cacheDir, pluginName+".so")
-	if err := os.Remove(cachePath); err != nil && !os.IsNotExist(err) {
-		log.Printf("Failed to remove cache file for plugin '%s': %v", pluginName, err)
+	matches, _ := filepath.Glob(filepath.Join(apm.cacheDir, pluginName+"-*.so"))
+	matches = append(matches, filepath.Join(apm.cacheDir, pluginName+".so"))
+	for _, cachePath := range matches {
+		if err := os.Remove(cachePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove cache file for plugin '%s': %v", pluginName, err)
+		}
 	}
 
+	// 停止旧插件的共享状态，之后的请求使用新插件重新创建
+	ResetSharedState(pluginName)
+
 	// 重新加载插件
-	_, err := apm.LoadPlugin(pluginName)
+	_, err := apm.loadPlugin(pluginName)
 	return err
 }
 
@@ -262,8 +544,12 @@ func (apm *AutoPluginManager) ClearCache() error {
 	apm.mu.Lock()
 	defer apm.mu.Unlock()
 
+	if apm.precompiledOnly {
+		return fmt.Errorf("cannot clear plugin cache: only precompiled plugins are allowed")
+	}
+
 	// 清空内存中的插件引用
-	apm.plugins = make(map[string]*plugin.Plugin)
+	apm.plugins = make(map[string]PluginHandle)
 	apm.pluginSources = make(map[string]string)
 
 	// 删除缓存目录中的所有文件
@@ -283,4 +569,4 @@ func (apm *AutoPluginManager) ClearCache() error {
 
 	log.Println("Plugin cache cleared")
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// 内置中间件注册表，内置中间件编译进主程序，不依赖插件机制，在所有平台上可用
+var (
+	builtinCreators   = make(map[string]func(config map[string]interface{}) (Middleware, error))
+	builtinCreatorsMu sync.RWMutex
+)
+
+// RegisterBuiltin 注册内置中间件，通常在内置中间件包的init函数中调用
+func RegisterBuiltin(name string, creator func(config map[string]interface{}) (Middleware, error)) {
+	builtinCreatorsMu.Lock()
+	defer builtinCreatorsMu.Unlock()
+
+	if _, exists := builtinCreators[name]; exists {
+		log.Printf("Warning: built-in middleware '%s' registered twice", name)
+	}
+	builtinCreators[name] = creator
+}
+
+// BuiltinNames 返回所有内置中间件名称，按名称排序
+func BuiltinNames() []string {
+	builtinCreatorsMu.RLock()
+	defer builtinCreatorsMu.RUnlock()
+
+	names := make([]string, 0, len(builtinCreators))
+	for name := range builtinCreators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsBuiltin 检查是否是内置中间件
+func IsBuiltin(name string) bool {
+	builtinCreatorsMu.RLock()
+	defer builtinCreatorsMu.RUnlock()
+
+	_, exists := builtinCreators[name]
+	return exists
+}
+
+// RegisterBuiltins 将所有内置中间件注册到工厂
+func RegisterBuiltins(factory MiddlewareFactory) {
+	builtinCreatorsMu.RLock()
+	defer builtinCreatorsMu.RUnlock()
+
+	for name, creator := range builtinCreators {
+		factory.RegisterMiddleware(name, creator)
+	}
+}
@@ -0,0 +1,221 @@
+package archiver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"toyou-proxy/archive"
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+	"toyou-proxy/objectstore"
+	"toyou-proxy/privacy"
+)
+
+// defaultMaxBodySize 每个请求体/响应体默认归档的最大字节数
+const defaultMaxBodySize = 1 << 20
+
+// ArchiveMiddleware 合规归档中间件，把经过路由的完整请求/响应对加密后保存到磁盘或S3兼容存储
+type ArchiveMiddleware struct {
+	archiver    *archive.Archiver
+	maxBodySize int64
+}
+
+// NewArchiveMiddleware 创建归档中间件
+func NewArchiveMiddleware(cfg map[string]interface{}) (middleware.Middleware, error) {
+	am := &ArchiveMiddleware{maxBodySize: defaultMaxBodySize}
+	if size, ok := cfg["max_body_size"].(string); ok && size != "" {
+		parsed, err := config.ParseSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_body_size: %v", err)
+		}
+		am.maxBodySize = parsed
+	}
+
+	state, err := middleware.SharedState("archive", cfg, func() (interface{}, error) {
+		return newArchiver(cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	am.archiver = state.(*archive.Archiver)
+
+	return am, nil
+}
+
+// newArchiver 根据配置创建存储和归档器
+func newArchiver(cfg map[string]interface{}) (*archive.Archiver, error) {
+	encoded, _ := cfg["encryption_key"].(string)
+	keyFile, _ := cfg["encryption_key_file"].(string)
+	key, err := archive.LoadKey(encoded, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var store archive.Store
+	storeType, _ := cfg["store"].(string)
+	switch storeType {
+	case "", "disk":
+		dir, _ := cfg["dir"].(string)
+		days, _ := middleware.ConfigInt(cfg, "retention_days")
+		store, err = archive.NewDiskStore(dir, time.Duration(days)*24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+	case "s3":
+		s3cfg, _ := cfg["s3"].(map[string]interface{})
+		client, err := objectstore.NewClient(objectstore.ConfigFromMap(s3cfg))
+		if err != nil {
+			return nil, fmt.Errorf("s3: %v", err)
+		}
+		prefix, _ := s3cfg["prefix"].(string)
+		store = archive.NewS3Store(client, prefix)
+	default:
+		return nil, fmt.Errorf("invalid store '%s', expected 'disk' or 's3'", storeType)
+	}
+
+	return archive.NewArchiver(store, key), nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("archive", NewArchiveMiddleware)
+}
+
+// Name 返回中间件名称
+func (am *ArchiveMiddleware) Name() string {
+	return "archive"
+}
+
+// Handle 记录请求体和响应，请求处理完成后提交归档
+func (am *ArchiveMiddleware) Handle(context *middleware.Context) bool {
+	request := context.Request
+	start := time.Now()
+
+	record := &archive.Record{
+		Time:     start,
+		ClientIP: privacy.ClientIP(request.RemoteAddr),
+		Request: archive.RequestRecord{
+			Method: request.Method,
+			Host:   request.Host,
+			URI:    request.URL.RequestURI(),
+			Proto:  request.Proto,
+			Header: request.Header.Clone(),
+		},
+	}
+
+	// 请求体在转发给后端时边读边记录
+	var requestBody *capture
+	if request.Body != nil && request.Body != http.NoBody {
+		requestBody = &capture{limit: am.maxBodySize}
+		request.Body = &teeBody{ReadCloser: request.Body, capture: requestBody}
+	}
+
+	writer := &captureWriter{ResponseWriter: context.Response, body: &capture{limit: am.maxBodySize}}
+	context.Response = writer
+
+	context.OnComplete(func() {
+		if requestBody != nil {
+			record.Request.Body = requestBody.data
+			record.Request.BodyTruncated = requestBody.truncated
+		}
+		if identity := context.Identity(); identity != nil {
+			record.Subject = identity.Subject
+		}
+
+		status := writer.status
+		if status == 0 {
+			status = context.StatusCode
+		}
+		record.Response = archive.ResponseRecord{
+			Status:        status,
+			Header:        writer.Header().Clone(),
+			Body:          writer.body.data,
+			BodySize:      writer.body.size,
+			BodyTruncated: writer.body.truncated,
+		}
+		record.DurationMs = time.Since(start).Milliseconds()
+
+		am.archiver.Submit(record)
+	})
+	return true
+}
+
+// capture 记录不超过上限的数据
+type capture struct {
+	data      []byte
+	size      int64
+	limit     int64
+	truncated bool
+}
+
+// write 追加数据，超过上限的部分被丢弃
+func (c *capture) write(p []byte) {
+	c.size += int64(len(p))
+	if remaining := c.limit - int64(len(c.data)); remaining > 0 {
+		if int64(len(p)) > remaining {
+			p = p[:remaining]
+			c.truncated = true
+		}
+		c.data = append(c.data, p...)
+	} else if len(p) > 0 {
+		c.truncated = true
+	}
+}
+
+// teeBody 读取请求体时同时记录
+type teeBody struct {
+	io.ReadCloser
+	capture *capture
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.capture.write(p[:n])
+	return n, err
+}
+
+// captureWriter 写入响应时同时记录状态码和响应体
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   *capture
+}
+
+func (w *captureWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.body.write(data[:n])
+	return n, err
+}
+
+// Flush 支持流式响应
+func (w *captureWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 支持WebSocket升级，升级后的数据不归档
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijacking")
+}
+
+// Unwrap 返回原始响应写入器
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,141 @@
+package authorization
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+)
+
+// RequirementKey 当前路由授权要求在上下文中的键，由代理根据域名/路由规则设置
+const RequirementKey = "authorization"
+
+// AuthorizationMiddleware 授权中间件，根据调用方身份中的scopes和角色判断是否允许访问
+// 授权要求优先使用路由规则或域名规则中的authorization配置，其次使用中间件自身的配置
+type AuthorizationMiddleware struct {
+	requirement *config.AuthorizationConfig
+}
+
+// authorizationError 结构化的授权错误响应
+type authorizationError struct {
+	Error          string   `json:"error"`
+	Message        string   `json:"message"`
+	RequiredScopes []string `json:"required_scopes,omitempty"`
+	RequiredRoles  []string `json:"required_roles,omitempty"`
+	MissingScopes  []string `json:"missing_scopes,omitempty"`
+	MissingRoles   []string `json:"missing_roles,omitempty"`
+}
+
+// NewAuthorizationMiddleware 创建授权中间件
+func NewAuthorizationMiddleware(cfg map[string]interface{}) (middleware.Middleware, error) {
+	am := &AuthorizationMiddleware{}
+
+	scopes := middleware.ConfigStrings(cfg, "scopes")
+	roles := middleware.ConfigStrings(cfg, "roles")
+	match, _ := cfg["match"].(string)
+	if len(scopes) > 0 || len(roles) > 0 {
+		if match != "" && match != "all" && match != "any" {
+			return nil, fmt.Errorf("invalid match '%s', expected 'all' or 'any'", match)
+		}
+		am.requirement = &config.AuthorizationConfig{
+			Scopes: scopes,
+			Roles:  roles,
+			Match:  match,
+		}
+	}
+
+	return am, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("authorization", NewAuthorizationMiddleware)
+}
+
+// Name 返回中间件名称
+func (am *AuthorizationMiddleware) Name() string {
+	return "authorization"
+}
+
+// Handle 检查调用方是否满足授权要求
+func (am *AuthorizationMiddleware) Handle(context *middleware.Context) bool {
+	requirement := am.requirement
+	if value, exists := context.Get(RequirementKey); exists {
+		if routeRequirement, ok := value.(*config.AuthorizationConfig); ok && routeRequirement != nil {
+			requirement = routeRequirement
+		}
+	}
+	if requirement == nil {
+		return true
+	}
+
+	identity := context.Identity()
+	if identity == nil {
+		context.Response.Header().Set("WWW-Authenticate", `Bearer realm="toyou-proxy"`)
+		writeError(context, http.StatusUnauthorized, authorizationError{
+			Error:   "unauthorized",
+			Message: "authentication required",
+		})
+		return false
+	}
+
+	missingScopes, missingRoles := missing(identity, requirement)
+	if len(missingScopes) == 0 && len(missingRoles) == 0 {
+		return true
+	}
+
+	log.Printf("Authorization denied for '%s': %s %s (missing scopes %v, roles %v)",
+		identity.Subject, context.Request.Method, context.Request.URL.Path, missingScopes, missingRoles)
+
+	if len(requirement.Scopes) > 0 {
+		context.Response.Header().Set("WWW-Authenticate",
+			fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(requirement.Scopes, " ")))
+	}
+	writeError(context, http.StatusForbidden, authorizationError{
+		Error:          "insufficient_scope",
+		Message:        "caller lacks the scopes or roles required for this resource",
+		RequiredScopes: requirement.Scopes,
+		RequiredRoles:  requirement.Roles,
+		MissingScopes:  missingScopes,
+		MissingRoles:   missingRoles,
+	})
+	return false
+}
+
+// missing 返回未满足的scopes和角色
+// match为any时只要拥有任意一个要求的scope或角色即视为满足
+func missing(identity *middleware.Identity, requirement *config.AuthorizationConfig) ([]string, []string) {
+	var missingScopes, missingRoles []string
+	for _, scope := range requirement.Scopes {
+		if !identity.HasScope(scope) {
+			missingScopes = append(missingScopes, scope)
+		}
+	}
+	for _, role := range requirement.Roles {
+		if !identity.HasRole(role) {
+			missingRoles = append(missingRoles, role)
+		}
+	}
+
+	if requirement.Match == "any" {
+		required := len(requirement.Scopes) + len(requirement.Roles)
+		if len(missingScopes)+len(missingRoles) < required {
+			return nil, nil
+		}
+	}
+
+	return missingScopes, missingRoles
+}
+
+// writeError 写入JSON格式的错误响应
+func writeError(context *middleware.Context, status int, body authorizationError) {
+	context.StatusCode = status
+	context.Response.Header().Set("Content-Type", "application/json")
+	context.Response.WriteHeader(status)
+	if err := json.NewEncoder(context.Response).Encode(body); err != nil {
+		log.Printf("Authorization: failed to encode error response: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package clientcert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"toyou-proxy/middleware"
+)
+
+// 默认请求头前缀，与常见的 X-SSL-Client-* 约定一致
+const defaultHeaderPrefix = "X-SSL-Client-"
+
+// 可转发的证书字段
+const (
+	fieldVerify      = "verify"
+	fieldSubject     = "subject"
+	fieldIssuer      = "issuer"
+	fieldSANs        = "sans"
+	fieldFingerprint = "fingerprint"
+	fieldSerial      = "serial"
+	fieldNotBefore   = "not_before"
+	fieldNotAfter    = "not_after"
+	fieldCert        = "cert"
+)
+
+// 字段对应的请求头名称（不含前缀）
+var fieldHeaders = map[string]string{
+	fieldVerify:      "Verify",
+	fieldSubject:     "Subject",
+	fieldIssuer:      "Issuer",
+	fieldSANs:        "SAN",
+	fieldFingerprint: "Fingerprint",
+	fieldSerial:      "Serial",
+	fieldNotBefore:   "Not-Before",
+	fieldNotAfter:    "Not-After",
+	fieldCert:        "Cert",
+}
+
+// 默认转发的字段，PEM较大，需要时显式配置
+var defaultFields = []string{fieldVerify, fieldSubject, fieldIssuer, fieldSANs, fieldFingerprint}
+
+// 客户端证书验证结果
+const (
+	verifySuccess    = "SUCCESS"    // 证书已通过client_ca_file验证
+	verifyUnverified = "UNVERIFIED" // 客户端提供了证书，但监听器未验证（client_auth为request或require）
+	verifyNone       = "NONE"       // 客户端未提供证书
+)
+
+// ClientCertMiddleware 客户端证书信息转发中间件
+// 把HTTPS连接上客户端证书（mTLS）的主题、SAN、指纹等转发给根据证书做授权的后端
+type ClientCertMiddleware struct {
+	prefix string
+	fields []string
+}
+
+// NewClientCertMiddleware 创建客户端证书信息转发中间件
+//
+//	header_prefix  请求头前缀，默认 X-SSL-Client-
+//	fields         转发的字段：verify、subject、issuer、sans、fingerprint、serial、not_before、not_after、cert
+//	               默认 verify、subject、issuer、sans、fingerprint
+func NewClientCertMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	cm := &ClientCertMiddleware{
+		prefix: defaultHeaderPrefix,
+		fields: defaultFields,
+	}
+
+	if prefix, ok := config["header_prefix"].(string); ok && prefix != "" {
+		cm.prefix = prefix
+	}
+
+	if fields := middleware.ConfigStrings(config, "fields"); len(fields) > 0 {
+		for _, field := range fields {
+			if _, exists := fieldHeaders[field]; !exists {
+				return nil, fmt.Errorf("unknown client certificate field '%s'", field)
+			}
+		}
+		cm.fields = fields
+	}
+
+	return cm, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("client_cert", NewClientCertMiddleware)
+}
+
+// Name 返回中间件名称
+func (cm *ClientCertMiddleware) Name() string {
+	return "client_cert"
+}
+
+// Handle 设置客户端证书请求头
+func (cm *ClientCertMiddleware) Handle(context *middleware.Context) bool {
+	request := context.Request
+
+	// 证书请求头只能由代理设置，移除客户端自带的同前缀请求头，避免伪造身份
+	for name := range request.Header {
+		if len(name) >= len(cm.prefix) && strings.EqualFold(name[:len(cm.prefix)], cm.prefix) {
+			request.Header.Del(name)
+		}
+	}
+
+	var cert *x509.Certificate
+	verify := verifyNone
+	if request.TLS != nil && len(request.TLS.PeerCertificates) > 0 {
+		cert = request.TLS.PeerCertificates[0]
+		verify = verifyUnverified
+		if len(request.TLS.VerifiedChains) > 0 {
+			verify = verifySuccess
+		}
+	}
+
+	for _, field := range cm.fields {
+		if field != fieldVerify && cert == nil {
+			continue
+		}
+		if value := fieldValue(field, cert, verify); value != "" {
+			request.Header.Set(cm.prefix+fieldHeaders[field], value)
+		}
+	}
+	return true
+}
+
+// fieldValue 获取证书字段的请求头值
+func fieldValue(field string, cert *x509.Certificate, verify string) string {
+	switch field {
+	case fieldVerify:
+		return verify
+	case fieldSubject:
+		return cert.Subject.String()
+	case fieldIssuer:
+		return cert.Issuer.String()
+	case fieldSANs:
+		return strings.Join(subjectAltNames(cert), ",")
+	case fieldFingerprint:
+		sum := sha256.Sum256(cert.Raw)
+		return hex.EncodeToString(sum[:])
+	case fieldSerial:
+		return strings.ToUpper(cert.SerialNumber.Text(16))
+	case fieldNotBefore:
+		return cert.NotBefore.UTC().Format(time.RFC3339)
+	case fieldNotAfter:
+		return cert.NotAfter.UTC().Format(time.RFC3339)
+	case fieldCert:
+		// PEM包含换行，URL编码后放入请求头（与nginx的$ssl_client_escaped_cert一致）
+		return url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	}
+	return ""
+}
+
+// subjectAltNames 列出证书的SAN，带类型前缀，如 DNS:api.example.com、email:a@example.com
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.IPAddresses)+len(cert.URIs))
+	for _, name := range cert.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	return names
+}
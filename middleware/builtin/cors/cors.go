@@ -0,0 +1,232 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"toyou-proxy/middleware"
+)
+
+// CORSMiddleware CORS中间件
+type CORSMiddleware struct {
+	allowAllOrigins     bool
+	origins             []originMatcher
+	allowedMethods      []string
+	allowedHeaders      []string
+	allowAllHeaders     bool
+	exposeHeaders       []string
+	maxAge              int
+	allowCredentials    bool
+	allowPrivateNetwork bool
+}
+
+// originMatcher 来源匹配函数
+type originMatcher func(origin string) bool
+
+// configAliases 其他CORS实现使用的配置键
+var configAliases = []middleware.ConfigKeyAlias{
+	{Old: "origins", New: "allowed_origins"},
+	{Old: "allow_origins", New: "allowed_origins"},
+	{Old: "methods", New: "allowed_methods"},
+	{Old: "allow_methods", New: "allowed_methods"},
+	{Old: "headers", New: "allowed_headers"},
+	{Old: "allow_headers", New: "allowed_headers"},
+	{Old: "exposed_headers", New: "expose_headers"},
+	{Old: "credentials", New: "allow_credentials"},
+	{Old: "max_age_seconds", New: "max_age"},
+}
+
+// NewCORSMiddleware 创建CORS中间件
+//
+// allowed_origins 支持三种写法：
+//   - "*"                        允许所有来源
+//   - "https://*.example.com"    通配子域名（不匹配 example.com 本身）
+//   - "~^https://.*\.example\.com$"  以~开头的正则表达式
+func NewCORSMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	config = middleware.NormalizeConfigKeys("cors", config, configAliases)
+
+	cm := &CORSMiddleware{
+		allowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		allowCredentials: true,
+	}
+
+	for _, origin := range middleware.ConfigStrings(config, "allowed_origins") {
+		if origin == "*" {
+			cm.allowAllOrigins = true
+			continue
+		}
+		matcher, err := newOriginMatcher(origin)
+		if err != nil {
+			return nil, err
+		}
+		cm.origins = append(cm.origins, matcher)
+	}
+	// 未配置来源时允许所有来源，与原插件行为一致
+	if len(cm.origins) == 0 {
+		cm.allowAllOrigins = true
+	}
+
+	if methods := middleware.ConfigStrings(config, "allowed_methods"); len(methods) > 0 {
+		cm.allowedMethods = methods
+	}
+
+	for _, header := range middleware.ConfigStrings(config, "allowed_headers") {
+		if header == "*" {
+			cm.allowAllHeaders = true
+			continue
+		}
+		cm.allowedHeaders = append(cm.allowedHeaders, header)
+	}
+
+	cm.exposeHeaders = middleware.ConfigStrings(config, "expose_headers")
+
+	if maxAge, ok := middleware.ConfigInt(config, "max_age"); ok {
+		if maxAge < 0 {
+			return nil, fmt.Errorf("max_age must not be negative")
+		}
+		cm.maxAge = maxAge
+	}
+
+	if credentials, ok := config["allow_credentials"].(bool); ok {
+		cm.allowCredentials = credentials
+	}
+	if privateNetwork, ok := config["allow_private_network"].(bool); ok {
+		cm.allowPrivateNetwork = privateNetwork
+	}
+
+	return cm, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("cors", NewCORSMiddleware)
+}
+
+// Name 返回中间件名称
+func (cm *CORSMiddleware) Name() string {
+	return "cors"
+}
+
+// Handle 处理CORS逻辑
+func (cm *CORSMiddleware) Handle(context *middleware.Context) bool {
+	request := context.Request
+	header := context.Response.Header()
+
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	// 响应内容随Origin变化，避免共享缓存把一个来源的响应返回给其他来源
+	header.Add("Vary", "Origin")
+
+	if !cm.originAllowed(origin) {
+		return true
+	}
+
+	// 允许携带凭证时不能返回通配符，只能回显来源
+	if cm.allowAllOrigins && !cm.allowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if cm.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	// 非预检请求只需要来源和暴露的响应头
+	preflight := request.Method == http.MethodOptions && request.Header.Get("Access-Control-Request-Method") != ""
+	if !preflight {
+		if len(cm.exposeHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(cm.exposeHeaders, ", "))
+		}
+		return true
+	}
+
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	header.Set("Access-Control-Allow-Methods", strings.Join(cm.allowedMethods, ", "))
+
+	if cm.allowAllHeaders {
+		if requested := request.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+	} else if len(cm.allowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(cm.allowedHeaders, ", "))
+	}
+
+	if cm.maxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(cm.maxAge))
+	}
+
+	// 私有网络访问（Private Network Access）预检
+	if cm.allowPrivateNetwork && request.Header.Get("Access-Control-Request-Private-Network") == "true" {
+		header.Set("Access-Control-Allow-Private-Network", "true")
+	}
+
+	context.StatusCode = http.StatusNoContent
+	context.Response.WriteHeader(http.StatusNoContent)
+	return false
+}
+
+// corsResponseHeaders 非预检请求中由中间件设置的CORS响应头
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Expose-Headers",
+}
+
+// HandleResponse 中间件设置的CORS响应头优先，删除上游返回的同名响应头，避免客户端收到重复的值而拒绝响应
+func (cm *CORSMiddleware) HandleResponse(context *middleware.Context, resp *http.Response) error {
+	header := context.Response.Header()
+	for _, name := range corsResponseHeaders {
+		if header.Get(name) != "" {
+			resp.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// originAllowed 检查来源是否允许
+func (cm *CORSMiddleware) originAllowed(origin string) bool {
+	if cm.allowAllOrigins {
+		return true
+	}
+	for _, matches := range cm.origins {
+		if matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// newOriginMatcher 根据配置创建来源匹配函数
+func newOriginMatcher(pattern string) (originMatcher, error) {
+	// 正则表达式
+	if strings.HasPrefix(pattern, "~") {
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin pattern '%s': %v", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	// 通配子域名，如 https://*.example.com
+	if idx := strings.Index(pattern, "://*."); idx != -1 {
+		scheme := strings.ToLower(pattern[:idx+3])
+		suffix := strings.ToLower(pattern[idx+4:])
+		return func(origin string) bool {
+			origin = strings.ToLower(origin)
+			return strings.HasPrefix(origin, scheme) &&
+				strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(scheme)+len(suffix)
+		}, nil
+	}
+
+	return func(origin string) bool {
+		return strings.EqualFold(origin, pattern)
+	}, nil
+}
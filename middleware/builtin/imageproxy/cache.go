@@ -0,0 +1,85 @@
+package imageproxy
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cacheEntry 缓存的处理结果
+type cacheEntry struct {
+	key         string
+	contentType string
+	body        []byte
+	expiresAt   time.Time
+}
+
+// imageCache 处理结果的LRU缓存，按条目数和总字节数淘汰
+type imageCache struct {
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+	mu      sync.Mutex
+}
+
+// newImageCache 创建缓存
+func newImageCache(maxEntries int, maxBytes int64, ttl time.Duration) *imageCache {
+	return &imageCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// get 读取缓存
+func (c *imageCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry, true
+}
+
+// put 写入缓存，超过上限时淘汰最久未使用的条目
+func (c *imageCache) put(key, contentType string, body []byte) {
+	if int64(len(body)) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, exists := c.entries[key]; exists {
+		c.remove(element)
+	}
+	entry := &cacheEntry{key: key, contentType: contentType, body: body, expiresAt: time.Now().Add(c.ttl)}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += int64(len(body))
+
+	for c.order.Len() > c.maxEntries || c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove 删除条目
+func (c *imageCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.body))
+}
@@ -0,0 +1,86 @@
+package imageproxy
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
+
+	// 注册可以解码的源图片格式，webp和avif包同时注册各自格式的解码器
+	_ "image/gif"
+
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+)
+
+// Encoder 输出格式编码器
+type Encoder struct {
+	ContentType string
+	Encode      func(w io.Writer, img image.Image, quality int) error
+}
+
+// 已注册的输出格式，内置JPEG、PNG、WebP和AVIF
+// WebP和AVIF优先使用系统中的libwebp、libavif动态库，没有时使用内嵌的WebAssembly版本，不需要cgo
+var (
+	encoders = map[string]Encoder{
+		"jpeg": {
+			ContentType: "image/jpeg",
+			Encode: func(w io.Writer, img image.Image, quality int) error {
+				return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+			},
+		},
+		"png": {
+			ContentType: "image/png",
+			Encode: func(w io.Writer, img image.Image, quality int) error {
+				return png.Encode(w, img)
+			},
+		},
+		"webp": {
+			ContentType: "image/webp",
+			Encode: func(w io.Writer, img image.Image, quality int) error {
+				return webp.Encode(w, img, webp.Options{Quality: quality, Method: webp.DefaultMethod})
+			},
+		},
+		"avif": {
+			ContentType: "image/avif",
+			Encode: func(w io.Writer, img image.Image, quality int) error {
+				// 编码速度取最快的一档，AVIF编码比其他格式慢得多
+				return avif.Encode(w, img, avif.Options{Quality: quality, QualityAlpha: quality, Speed: avif.DefaultSpeed})
+			},
+		},
+	}
+	encodersMu sync.RWMutex
+)
+
+// RegisterEncoder 注册输出格式编码器，插件可以在初始化时注册其他格式或替换内置的编码器
+func RegisterEncoder(format string, encoder Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[format] = encoder
+}
+
+// lookupEncoder 查找输出格式编码器
+func lookupEncoder(format string) (Encoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	encoder, ok := encoders[format]
+	return encoder, ok
+}
+
+// formatOfContentType 源图片的格式
+func formatOfContentType(contentType string) string {
+	switch contentType {
+	case "image/jpeg", "image/jpg":
+		return "jpeg"
+	case "image/png":
+		return "png"
+	case "image/webp":
+		return "webp"
+	case "image/avif":
+		return "avif"
+	case "image/gif":
+		return "gif"
+	}
+	return ""
+}
@@ -0,0 +1,427 @@
+package imageproxy
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+	"toyou-proxy/middleware"
+)
+
+// TransformerKey 当前请求的图片处理器在上下文中的键，代理在转发响应前调用
+const TransformerKey = "image_transformer"
+
+// 查询参数
+const (
+	paramWidth   = "w"
+	paramHeight  = "h"
+	paramFit     = "fit"
+	paramQuality = "q"
+	paramFormat  = "fmt"
+)
+
+// 默认配置
+const (
+	defaultMaxDimension  = 4096
+	defaultQuality       = 80
+	defaultMaxSourceSize = 20 << 20
+	defaultMaxPixels     = 50000000
+	defaultCacheEntries  = 1000
+	defaultCacheSize     = 256 << 20
+	defaultCacheTTL      = time.Hour
+)
+
+// imageTransforms 图片处理次数，按结果分类
+var imageTransforms = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_image_transforms_total",
+	"Image transformations served by the image middleware.",
+	"result",
+)
+
+// ImageMiddleware 图片处理中间件，按查询参数缩放图片并转换格式，处理结果缓存在内存中
+type ImageMiddleware struct {
+	maxWidth      int
+	maxHeight     int
+	allowedWidths map[int]bool
+	quality       int
+	maxSourceSize int64
+	cache         *imageCache
+}
+
+// params 图片处理参数
+type params struct {
+	width   int
+	height  int
+	fit     string
+	quality int
+	format  string // 为空时保持源格式，auto 时按Accept请求头协商
+}
+
+// Transformer 单个请求的图片处理器
+type Transformer struct {
+	params        params
+	accept        string
+	maxSourceSize int64
+	cache         *imageCache
+	cacheKey      string
+}
+
+// NewImageMiddleware 创建图片处理中间件
+func NewImageMiddleware(cfg map[string]interface{}) (middleware.Middleware, error) {
+	im := &ImageMiddleware{
+		maxWidth:      defaultMaxDimension,
+		maxHeight:     defaultMaxDimension,
+		quality:       defaultQuality,
+		maxSourceSize: defaultMaxSourceSize,
+	}
+	if v, ok := middleware.ConfigInt(cfg, "max_width"); ok && v > 0 {
+		im.maxWidth = v
+	}
+	if v, ok := middleware.ConfigInt(cfg, "max_height"); ok && v > 0 {
+		im.maxHeight = v
+	}
+	if v, ok := middleware.ConfigInt(cfg, "default_quality"); ok {
+		if v < 1 || v > 100 {
+			return nil, fmt.Errorf("default_quality must be between 1 and 100")
+		}
+		im.quality = v
+	}
+	if size, ok := cfg["max_source_size"].(string); ok && size != "" {
+		parsed, err := config.ParseSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_source_size: %v", err)
+		}
+		im.maxSourceSize = parsed
+	}
+	if widths, ok := cfg["allowed_widths"].([]interface{}); ok {
+		// 限制可选宽度，避免任意尺寸请求击穿缓存
+		im.allowedWidths = make(map[int]bool)
+		for _, w := range widths {
+			switch v := w.(type) {
+			case int:
+				im.allowedWidths[v] = true
+			case float64:
+				im.allowedWidths[int(v)] = true
+			}
+		}
+	}
+
+	cacheCfg, _ := cfg["cache"].(map[string]interface{})
+	cache, err := middleware.SharedState("image", cfg, func() (interface{}, error) {
+		entries := defaultCacheEntries
+		if v, ok := middleware.ConfigInt(cacheCfg, "max_entries"); ok && v > 0 {
+			entries = v
+		}
+		var size int64 = defaultCacheSize
+		if v, ok := cacheCfg["max_size"].(string); ok && v != "" {
+			parsed, err := config.ParseSize(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cache.max_size: %v", err)
+			}
+			size = parsed
+		}
+		ttl := defaultCacheTTL
+		if v, ok := middleware.ConfigInt(cacheCfg, "ttl"); ok && v > 0 {
+			ttl = time.Duration(v) * time.Second
+		}
+		return newImageCache(entries, size, ttl), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	im.cache = cache.(*imageCache)
+
+	return im, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("image", NewImageMiddleware)
+}
+
+// Name 返回中间件名称
+func (im *ImageMiddleware) Name() string {
+	return "image"
+}
+
+// Handle 解析处理参数，命中缓存时直接返回，否则在响应返回时处理图片
+func (im *ImageMiddleware) Handle(context *middleware.Context) bool {
+	request := context.Request
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return true
+	}
+
+	query := request.URL.Query()
+	p, present, err := im.parseParams(query)
+	if !present {
+		return true
+	}
+	if err != nil {
+		return context.RespondText(http.StatusBadRequest, err.Error())
+	}
+
+	// 处理参数不转发给上游，上游看到的是原始图片地址
+	for _, name := range []string{paramWidth, paramHeight, paramFit, paramQuality, paramFormat} {
+		query.Del(name)
+	}
+	request.URL.RawQuery = query.Encode()
+
+	t := &Transformer{
+		params:        p,
+		accept:        request.Header.Get("Accept"),
+		maxSourceSize: im.maxSourceSize,
+		cache:         im.cache,
+	}
+	t.cacheKey = cacheKey(request.Host, request.URL, p, t.negotiate(""))
+
+	if entry, ok := im.cache.get(t.cacheKey); ok {
+		imageTransforms.Inc("hit")
+		header := http.Header{}
+		header.Set("Content-Type", entry.contentType)
+		header.Set("X-Image-Cache", "HIT")
+		if p.format == "auto" {
+			header.Set("Vary", "Accept")
+		}
+		return context.Respond(http.StatusOK, header, entry.body)
+	}
+
+	// 需要完整的原始图片
+	request.Header.Del("Range")
+	request.Header.Del("If-None-Match")
+	request.Header.Del("If-Modified-Since")
+	context.Set(TransformerKey, t)
+	return true
+}
+
+// parseParams 解析并校验处理参数
+func (im *ImageMiddleware) parseParams(query url.Values) (params, bool, error) {
+	p := params{fit: "contain", quality: im.quality}
+	present := false
+
+	for name, target := range map[string]*int{paramWidth: &p.width, paramHeight: &p.height, paramQuality: &p.quality} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		present = true
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return p, true, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		*target = n
+	}
+	if v := query.Get(paramFit); v != "" {
+		present = true
+		p.fit = v
+	}
+	if v := query.Get(paramFormat); v != "" {
+		present = true
+		p.format = strings.ToLower(v)
+		if p.format == "jpg" {
+			p.format = "jpeg"
+		}
+	}
+	if !present {
+		return p, false, nil
+	}
+
+	if p.width > im.maxWidth || p.height > im.maxHeight {
+		return p, true, fmt.Errorf("requested size exceeds %dx%d", im.maxWidth, im.maxHeight)
+	}
+	if im.allowedWidths != nil && p.width > 0 && !im.allowedWidths[p.width] {
+		return p, true, fmt.Errorf("width %d is not allowed", p.width)
+	}
+	if p.quality > 100 {
+		return p, true, fmt.Errorf("q must be between 1 and 100")
+	}
+	switch p.fit {
+	case "contain", "cover", "fill":
+	default:
+		return p, true, fmt.Errorf("invalid fit '%s', expected contain, cover or fill", p.fit)
+	}
+	if p.format != "" && p.format != "auto" {
+		if _, ok := lookupEncoder(p.format); !ok {
+			return p, true, fmt.Errorf("unsupported output format '%s'", p.format)
+		}
+	}
+	return p, true, nil
+}
+
+// negotiate 确定输出格式，sourceFormat为空时只根据请求确定（用于缓存键）
+func (t *Transformer) negotiate(sourceFormat string) string {
+	switch t.params.format {
+	case "auto":
+		for _, format := range []string{"avif", "webp"} {
+			if _, ok := lookupEncoder(format); ok && strings.Contains(t.accept, "image/"+format) {
+				return format
+			}
+		}
+	case "":
+	default:
+		return t.params.format
+	}
+
+	if sourceFormat == "" {
+		return "source"
+	}
+	if _, ok := lookupEncoder(sourceFormat); ok {
+		return sourceFormat
+	}
+	return "png"
+}
+
+// Applies 检查响应是否是可以处理的图片
+func (t *Transformer) Applies(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	if resp.ContentLength > t.maxSourceSize {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	format := formatOfContentType(mediaType)
+	// GIF可能是动画，保持原样
+	return format != "" && format != "gif"
+}
+
+// Transform 处理图片并更新响应头，失败时返回错误，由调用方转发原始图片
+func (t *Transformer) Transform(body []byte, header http.Header) ([]byte, error) {
+	out, contentType, err := t.transform(body)
+	if err != nil {
+		imageTransforms.Inc("error")
+		return nil, err
+	}
+
+	imageTransforms.Inc("miss")
+	t.cache.put(t.cacheKey, contentType, out)
+	applyResult(header, contentType, len(out), t.params.format == "auto")
+	return out, nil
+}
+
+// transform 解码、缩放并重新编码图片
+func (t *Transformer) transform(body []byte) ([]byte, string, error) {
+	if int64(len(body)) > t.maxSourceSize {
+		return nil, "", fmt.Errorf("source image exceeds %d bytes", t.maxSourceSize)
+	}
+
+	// 先读取尺寸，拒绝解码后过大的图片
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width*cfg.Height > defaultMaxPixels {
+		return nil, "", fmt.Errorf("source image is too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	src, sourceFormat, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+
+	format := t.negotiate(sourceFormat)
+	encoder, ok := lookupEncoder(format)
+	if !ok {
+		return nil, "", fmt.Errorf("output format '%s' is not available", format)
+	}
+
+	var out bytes.Buffer
+	if err := encoder.Encode(&out, resize(src, t.params, format == "jpeg"), t.params.quality); err != nil {
+		return nil, "", err
+	}
+	return out.Bytes(), encoder.ContentType, nil
+}
+
+// resize 按参数缩放和裁剪图片，opaque为true时透明区域填充白色（JPEG不支持透明）
+func resize(src image.Image, p params, opaque bool) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	width, height := p.width, p.height
+	switch {
+	case width == 0 && height == 0:
+		width, height = srcW, srcH
+	case width == 0:
+		width = max(1, srcW*height/srcH)
+	case height == 0:
+		height = max(1, srcH*width/srcW)
+	}
+
+	srcRect := bounds
+	switch p.fit {
+	case "contain":
+		// 保持比例放入目标尺寸，不放大
+		scale := minFloat(float64(width)/float64(srcW), float64(height)/float64(srcH))
+		if scale > 1 {
+			scale = 1
+		}
+		width = max(1, int(float64(srcW)*scale+0.5))
+		height = max(1, int(float64(srcH)*scale+0.5))
+	case "cover":
+		// 保持比例填满目标尺寸，居中裁剪多余部分
+		cropW, cropH := srcW, srcW*height/width
+		if cropH > srcH {
+			cropW, cropH = srcH*width/height, srcH
+		}
+		x := bounds.Min.X + (srcW-cropW)/2
+		y := bounds.Min.Y + (srcH-cropH)/2
+		srcRect = image.Rect(x, y, x+cropW, y+cropH)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if opaque {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Over, nil)
+	return dst
+}
+
+// cacheKey 处理结果的缓存键
+func cacheKey(host string, u *url.URL, p params, format string) string {
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(host)
+	b.WriteString(u.Path)
+	for _, key := range keys {
+		b.WriteString("&" + key + "=" + strings.Join(query[key], ","))
+	}
+	fmt.Fprintf(&b, "|%dx%d|%s|%d|%s", p.width, p.height, p.fit, p.quality, format)
+	return b.String()
+}
+
+// minFloat 返回较小值
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// applyResult 处理后的响应头
+func applyResult(header http.Header, contentType string, length int, negotiated bool) {
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.Itoa(length))
+	header.Set("X-Image-Cache", "MISS")
+	header.Del("ETag")
+	header.Del("Content-Range")
+	header.Del("Accept-Ranges")
+	if negotiated {
+		header.Add("Vary", "Accept")
+	}
+}
@@ -0,0 +1,90 @@
+package imageproxy
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"testing"
+)
+
+// testPNG 生成宽w高h的PNG图片
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTransformEncodesWebPAndAVIF(t *testing.T) {
+	source := testPNG(t, 64, 32)
+	for _, test := range []struct {
+		format      string
+		contentType string
+	}{
+		{"webp", "image/webp"},
+		{"avif", "image/avif"},
+	} {
+		tr := &Transformer{params: params{width: 32, fit: "contain", quality: 80, format: test.format}, maxSourceSize: defaultMaxSourceSize}
+		out, contentType, err := tr.transform(source)
+		if err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if contentType != test.contentType {
+			t.Errorf("%s: content type = %q", test.format, contentType)
+		}
+
+		// 输出可以按目标格式解码，尺寸按参数缩放
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%s: decoding the output: %v", test.format, err)
+		}
+		if format != test.format || cfg.Width != 32 || cfg.Height != 16 {
+			t.Errorf("%s: output is %s %dx%d, want 32x16", test.format, format, cfg.Width, cfg.Height)
+		}
+
+		// 转换后的图片可以作为源图片再次处理
+		tr = &Transformer{params: params{fit: "contain", quality: 80, format: "png"}, maxSourceSize: defaultMaxSourceSize}
+		if _, _, err := tr.transform(out); err != nil {
+			t.Errorf("%s source: %v", test.format, err)
+		}
+	}
+}
+
+func TestNegotiatePrefersAVIFThenWebP(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"image/avif,image/webp,*/*", "avif"},
+		{"image/webp,*/*", "webp"},
+		{"*/*", "jpeg"},
+	}
+	for _, test := range tests {
+		tr := &Transformer{params: params{format: "auto"}, accept: test.accept}
+		if got := tr.negotiate("jpeg"); got != test.want {
+			t.Errorf("negotiate with Accept %q = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestParseParamsAcceptsWebPAndAVIF(t *testing.T) {
+	im := &ImageMiddleware{maxWidth: defaultMaxDimension, maxHeight: defaultMaxDimension, quality: defaultQuality}
+	for _, format := range []string{"webp", "avif", "WEBP"} {
+		if _, _, err := im.parseParams(url.Values{paramFormat: {format}}); err != nil {
+			t.Errorf("fmt=%s: %v", format, err)
+		}
+	}
+	if _, _, err := im.parseParams(url.Values{paramFormat: {"bmp"}}); err == nil {
+		t.Error("fmt=bmp was accepted")
+	}
+}
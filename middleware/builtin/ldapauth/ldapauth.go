@@ -0,0 +1,352 @@
+package ldapauth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"toyou-proxy/middleware"
+	"toyou-proxy/privacy"
+)
+
+// 默认配置
+const (
+	defaultUserFilter = "(&(objectClass=person)(uid=%s))"
+	defaultPoolSize   = 4
+	defaultCacheTTL   = 5 * time.Minute
+	defaultTimeout    = 5 * time.Second
+	defaultRealm      = "toyou-proxy"
+)
+
+// errInvalidCredentials 用户名或密码错误
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// LDAPAuthMiddleware LDAP/Active Directory认证中间件
+// 使用HTTP Basic认证获取用户名密码，通过服务账号查找用户后以用户DN绑定验证密码
+type LDAPAuthMiddleware struct {
+	auth *authenticator
+}
+
+// authenticator 按配置共享的认证器，包含连接池和认证缓存
+type authenticator struct {
+	pool               *connPool
+	baseDN             string
+	userFilter         string
+	groupBaseDN        string
+	groupFilter        string
+	requiredGroups     []string
+	realm              string
+	userHeader         string
+	groupsHeader       string
+	stripAuthorization bool
+	cacheTTL           time.Duration
+
+	cache   map[string]cachedAuth
+	cacheMu sync.Mutex
+}
+
+// cachedAuth 缓存的认证结果，只缓存成功的认证
+type cachedAuth struct {
+	user      *ldapUser
+	expiresAt time.Time
+}
+
+// ldapUser 认证成功的目录用户
+type ldapUser struct {
+	username string
+	dn       string
+	groups   []string // 组的完整DN
+}
+
+// NewLDAPAuthMiddleware 创建LDAP认证中间件
+func NewLDAPAuthMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	auth, err := middleware.SharedState("ldap_auth", config, func() (interface{}, error) {
+		return newAuthenticator(config)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LDAPAuthMiddleware{auth: auth.(*authenticator)}, nil
+}
+
+// newAuthenticator 根据配置创建认证器
+func newAuthenticator(config map[string]interface{}) (*authenticator, error) {
+	url, _ := config["url"].(string)
+	if url == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	baseDN, _ := config["base_dn"].(string)
+	if baseDN == "" {
+		return nil, fmt.Errorf("base_dn is required")
+	}
+
+	a := &authenticator{
+		baseDN:             baseDN,
+		userFilter:         defaultUserFilter,
+		realm:              defaultRealm,
+		stripAuthorization: true,
+		cacheTTL:           defaultCacheTTL,
+		cache:              make(map[string]cachedAuth),
+	}
+
+	if filter, ok := config["user_filter"].(string); ok && filter != "" {
+		if strings.Count(filter, "%s") != 1 {
+			return nil, fmt.Errorf("user_filter must contain exactly one %%s placeholder")
+		}
+		a.userFilter = filter
+	}
+	a.groupBaseDN, _ = config["group_base_dn"].(string)
+	a.groupFilter, _ = config["group_filter"].(string)
+	if a.groupFilter != "" && strings.Count(a.groupFilter, "%s") != 1 {
+		return nil, fmt.Errorf("group_filter must contain exactly one %%s placeholder")
+	}
+	a.requiredGroups = middleware.ConfigStrings(config, "required_groups")
+	if realm, ok := config["realm"].(string); ok && realm != "" {
+		a.realm = realm
+	}
+	a.userHeader, _ = config["user_header"].(string)
+	a.groupsHeader, _ = config["groups_header"].(string)
+	if strip, ok := config["strip_authorization"].(bool); ok {
+		a.stripAuthorization = strip
+	}
+	if seconds, ok := middleware.ConfigInt(config, "cache_ttl"); ok {
+		a.cacheTTL = time.Duration(seconds) * time.Second
+	}
+
+	poolSize := defaultPoolSize
+	if size, ok := middleware.ConfigInt(config, "pool_size"); ok && size > 0 {
+		poolSize = size
+	}
+	timeout := defaultTimeout
+	if seconds, ok := middleware.ConfigInt(config, "timeout"); ok && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	tlsConfig := &tls.Config{}
+	if skip, ok := config["insecure_skip_verify"].(bool); ok {
+		tlsConfig.InsecureSkipVerify = skip
+	}
+	startTLS, _ := config["start_tls"].(bool)
+	bindDN, _ := config["bind_dn"].(string)
+	bindPassword, _ := config["bind_password"].(string)
+
+	a.pool = newConnPool(url, startTLS, tlsConfig, bindDN, bindPassword, poolSize, timeout)
+	return a, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("ldap_auth", NewLDAPAuthMiddleware)
+}
+
+// Name 返回中间件名称
+func (lm *LDAPAuthMiddleware) Name() string {
+	return "ldap_auth"
+}
+
+// Handle 验证Basic认证凭证
+func (lm *LDAPAuthMiddleware) Handle(context *middleware.Context) bool {
+	a := lm.auth
+	request := context.Request
+
+	username, password, ok := request.BasicAuth()
+	if !ok || username == "" || password == "" {
+		a.challenge(context)
+		return false
+	}
+
+	user, err := a.authenticate(username, password)
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			log.Printf("LDAP auth: invalid credentials for '%s' from %s", username, privacy.ClientIP(request.RemoteAddr))
+			a.challenge(context)
+		} else {
+			log.Printf("LDAP auth: directory error for '%s': %v", username, err)
+			context.StatusCode = http.StatusServiceUnavailable
+			http.Error(context.Response, "Authentication service unavailable", http.StatusServiceUnavailable)
+		}
+		return false
+	}
+
+	if !a.inRequiredGroups(user) {
+		log.Printf("LDAP auth: '%s' is not a member of required groups %v", username, a.requiredGroups)
+		context.StatusCode = http.StatusForbidden
+		http.Error(context.Response, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	roles := make([]string, 0, len(user.groups))
+	for _, group := range user.groups {
+		roles = append(roles, groupName(group))
+	}
+	context.SetIdentity(&middleware.Identity{
+		Subject: user.username,
+		Method:  "ldap",
+		Roles:   roles,
+		Claims:  map[string]interface{}{"dn": user.dn, "groups": user.groups},
+	})
+
+	// 目录密码不转发给后端
+	if a.stripAuthorization {
+		request.Header.Del("Authorization")
+	}
+	if a.userHeader != "" {
+		request.Header.Set(a.userHeader, user.username)
+	}
+	if a.groupsHeader != "" {
+		request.Header.Set(a.groupsHeader, strings.Join(roles, ","))
+	}
+
+	return true
+}
+
+// challenge 要求客户端提供Basic认证凭证
+func (a *authenticator) challenge(context *middleware.Context) {
+	context.Response.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, a.realm))
+	context.StatusCode = http.StatusUnauthorized
+	http.Error(context.Response, "Unauthorized", http.StatusUnauthorized)
+}
+
+// authenticate 验证用户名密码，成功的结果在cache_ttl内缓存
+func (a *authenticator) authenticate(username, password string) (*ldapUser, error) {
+	key := cacheKey(username, password)
+	if user := a.cached(key); user != nil {
+		return user, nil
+	}
+
+	conn, err := a.pool.get()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.lookup(conn, username, password)
+	if err != nil && !errors.Is(err, errInvalidCredentials) {
+		a.pool.discard(conn)
+		return nil, err
+	}
+
+	// 用户绑定改变了连接身份，归还前恢复为服务账号
+	if rebindErr := a.pool.bindService(conn); rebindErr != nil {
+		a.pool.discard(conn)
+	} else {
+		a.pool.put(conn)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if a.cacheTTL > 0 {
+		a.cacheMu.Lock()
+		a.cache[key] = cachedAuth{user: user, expiresAt: time.Now().Add(a.cacheTTL)}
+		a.cacheMu.Unlock()
+	}
+	return user, nil
+}
+
+// lookup 查找用户DN、以用户身份绑定验证密码并读取所属的组
+func (a *authenticator) lookup(conn *ldap.Conn, username, password string) (*ldapUser, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.userFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", "memberOf"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("user search failed: %v", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, errInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind failed: %v", err)
+	}
+
+	user := &ldapUser{
+		username: username,
+		dn:       entry.DN,
+		groups:   entry.GetAttributeValues("memberOf"),
+	}
+
+	// 目录不支持memberOf时按组查询（如OpenLDAP的groupOfNames）
+	if a.groupFilter != "" {
+		if err := a.pool.bindService(conn); err != nil {
+			return nil, err
+		}
+		groupBaseDN := a.groupBaseDN
+		if groupBaseDN == "" {
+			groupBaseDN = a.baseDN
+		}
+		groups, err := conn.Search(ldap.NewSearchRequest(
+			groupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf(a.groupFilter, ldap.EscapeFilter(entry.DN)),
+			[]string{"dn"}, nil,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("group search failed: %v", err)
+		}
+		for _, group := range groups.Entries {
+			user.groups = append(user.groups, group.DN)
+		}
+	}
+
+	return user, nil
+}
+
+// cached 获取未过期的缓存结果
+func (a *authenticator) cached(key string) *ldapUser {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	entry, exists := a.cache[key]
+	if !exists {
+		return nil
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(a.cache, key)
+		return nil
+	}
+	return entry.user
+}
+
+// inRequiredGroups 检查用户是否属于任意一个要求的组，组可以写完整DN或CN
+func (a *authenticator) inRequiredGroups(user *ldapUser) bool {
+	if len(a.requiredGroups) == 0 {
+		return true
+	}
+	for _, required := range a.requiredGroups {
+		for _, group := range user.groups {
+			if strings.EqualFold(required, group) || strings.EqualFold(required, groupName(group)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groupName 从组DN中提取CN，如 cn=admins,ou=groups,dc=example,dc=com 返回 admins
+func groupName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// cacheKey 认证缓存键，不在内存中保存明文密码
+func cacheKey(username, password string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,98 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// connPool LDAP连接池，池中的连接都以服务账号绑定
+type connPool struct {
+	url          string
+	startTLS     bool
+	tlsConfig    *tls.Config
+	bindDN       string
+	bindPassword string
+	timeout      time.Duration
+	conns        chan *ldap.Conn
+}
+
+// newConnPool 创建连接池，连接在首次使用时建立
+func newConnPool(url string, startTLS bool, tlsConfig *tls.Config, bindDN, bindPassword string, size int, timeout time.Duration) *connPool {
+	return &connPool{
+		url:          url,
+		startTLS:     startTLS,
+		tlsConfig:    tlsConfig,
+		bindDN:       bindDN,
+		bindPassword: bindPassword,
+		timeout:      timeout,
+		conns:        make(chan *ldap.Conn, size),
+	}
+}
+
+// get 从池中取出连接，池为空时新建连接
+func (p *connPool) get() (*ldap.Conn, error) {
+	for {
+		select {
+		case conn := <-p.conns:
+			if conn.IsClosing() {
+				continue
+			}
+			return conn, nil
+		default:
+			return p.dial()
+		}
+	}
+}
+
+// put 归还连接，连接已恢复为服务账号绑定；池已满时关闭连接
+func (p *connPool) put(conn *ldap.Conn) {
+	select {
+	case p.conns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// discard 关闭出错的连接
+func (p *connPool) discard(conn *ldap.Conn) {
+	conn.Close()
+}
+
+// dial 建立连接并以服务账号绑定
+func (p *connPool) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(p.url, ldap.DialWithTLSConfig(p.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", p.url, err)
+	}
+	conn.SetTimeout(p.timeout)
+
+	if p.startTLS {
+		if err := conn.StartTLS(p.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+
+	if err := p.bindService(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// bindService 以服务账号绑定，未配置服务账号时匿名绑定
+func (p *connPool) bindService(conn *ldap.Conn) error {
+	if p.bindDN == "" {
+		if err := conn.UnauthenticatedBind(""); err != nil {
+			return fmt.Errorf("anonymous bind failed: %v", err)
+		}
+		return nil
+	}
+	if err := conn.Bind(p.bindDN, p.bindPassword); err != nil {
+		return fmt.Errorf("service account bind failed: %v", err)
+	}
+	return nil
+}
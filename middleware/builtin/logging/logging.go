@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"toyou-proxy/middleware"
+	"toyou-proxy/privacy"
+)
+
+// LoggingMiddleware 日志中间件
+type LoggingMiddleware struct {
+	level      string
+	headers    []string // 需要记录的请求头
+	connection bool     // 是否记录连接信息（连接ID、监听端口、协议、TLS版本）
+}
+
+// NewLoggingMiddleware 创建日志中间件
+func NewLoggingMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	level := "info"
+	if l, ok := config["level"].(string); ok {
+		level = l
+	}
+
+	connection, _ := config["log_connection"].(bool)
+
+	return &LoggingMiddleware{
+		level:      level,
+		headers:    middleware.ConfigStrings(config, "log_headers"),
+		connection: connection,
+	}, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("logging", NewLoggingMiddleware)
+}
+
+// Name 返回中间件名称
+func (lm *LoggingMiddleware) Name() string {
+	return "logging"
+}
+
+// Handle 处理日志逻辑
+func (lm *LoggingMiddleware) Handle(context *middleware.Context) bool {
+
+	start := time.Now()
+
+	// 记录请求开始
+	if lm.level == "debug" {
+		log.Printf("[%s] %s %s %s - Started", lm.level, privacy.ClientIP(context.Request.RemoteAddr), context.Request.Method, privacy.URI(context.Request.URL))
+	}
+
+	// 继续处理请求
+	result := true
+
+	// 记录请求结束
+	if lm.level == "info" || lm.level == "debug" {
+		duration := time.Since(start)
+		statusCode := context.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+
+		log.Printf("[%s] %s %s %s - %d - %v%s%s%s", lm.level, privacy.ClientIP(context.Request.RemoteAddr), context.Request.Method, privacy.URI(context.Request.URL), statusCode, duration, lm.formatConnection(context), lm.formatHeaders(context.Request.Header), formatLabels(context))
+	}
+
+	return result
+}
+
+// formatConnection 格式化连接信息，如 ` - conn=12 port=443 proto=HTTP/2.0 tls="TLS 1.3"`
+func (lm *LoggingMiddleware) formatConnection(context *middleware.Context) string {
+	if !lm.connection {
+		return ""
+	}
+	conn := context.Connection()
+	if conn == nil {
+		return ""
+	}
+
+	parts := []string{
+		fmt.Sprintf("conn=%d", conn.ID),
+		fmt.Sprintf("port=%d", conn.ListenerPort),
+		"proto=" + conn.Protocol,
+	}
+	if conn.TLS != nil {
+		parts = append(parts, fmt.Sprintf("tls=%q", tls.VersionName(conn.TLS.Version)))
+	}
+	return " - " + strings.Join(parts, " ")
+}
+
+// formatHeaders 格式化需要记录的请求头，敏感值在匿名模式下被删除
+func (lm *LoggingMiddleware) formatHeaders(header http.Header) string {
+	if len(lm.headers) == 0 {
+		return ""
+	}
+
+	scrubbed := privacy.Headers(header)
+	parts := make([]string, 0, len(lm.headers))
+	for _, name := range lm.headers {
+		if value := scrubbed.Get(name); value != "" {
+			parts = append(parts, fmt.Sprintf("%s=%q", http.CanonicalHeaderKey(name), value))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " - " + strings.Join(parts, " ")
+}
+
+// formatLabels 格式化匹配规则的标签
+func formatLabels(context *middleware.Context) string {
+	if labels := context.LabelString(); labels != "" {
+		return " - " + labels
+	}
+	return ""
+}
+
+// 辅助函数，用于格式化日志
+func (lm *LoggingMiddleware) formatLog(message string) string {
+	return fmt.Sprintf("[%s] %s", lm.level, message)
+}
@@ -0,0 +1,156 @@
+package masking
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"toyou-proxy/metrics"
+	"toyou-proxy/middleware"
+)
+
+// MaskerKey 当前请求的响应遮盖器在上下文中的键，代理在转发响应前调用
+const MaskerKey = "response_masker"
+
+// maskedValues 被遮盖的值的数量，按规则分类，用于审计
+var maskedValues = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_masked_values_total",
+	"Values masked in upstream responses by the mask middleware.",
+	"rule",
+)
+
+// MaskingMiddleware 响应数据遮盖中间件，遮盖响应中配置的JSON字段和匹配正则的内容（信用卡号、邮箱等）
+// 可以只对指定的调用方生效，或豁免指定角色
+type MaskingMiddleware struct {
+	rules       *ruleSet
+	consumers   []string
+	exemptRoles []string
+}
+
+// Masker 单个请求的响应遮盖器
+type Masker struct {
+	rules *ruleSet
+	path  string
+}
+
+// NewMaskingMiddleware 创建响应数据遮盖中间件
+func NewMaskingMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	rules, err := middleware.SharedState("mask", config, func() (interface{}, error) {
+		replacement, _ := config["replacement"].(string)
+		patterns, _ := config["patterns"].([]interface{})
+		return newRuleSet(middleware.ConfigStrings(config, "fields"), replacement, patterns)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MaskingMiddleware{
+		rules:       rules.(*ruleSet),
+		consumers:   middleware.ConfigStrings(config, "consumers"),
+		exemptRoles: middleware.ConfigStrings(config, "exempt_roles"),
+	}, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("mask", NewMaskingMiddleware)
+}
+
+// Name 返回中间件名称
+func (mm *MaskingMiddleware) Name() string {
+	return "mask"
+}
+
+// Handle 为需要遮盖的请求注册响应遮盖器
+func (mm *MaskingMiddleware) Handle(context *middleware.Context) bool {
+	if !mm.appliesTo(context.Identity()) {
+		return true
+	}
+
+	// 要求上游返回未压缩的响应，才能检查响应内容
+	context.Request.Header.Del("Accept-Encoding")
+	context.Set(MaskerKey, &Masker{rules: mm.rules, path: context.Request.URL.Path})
+	return true
+}
+
+// appliesTo 检查是否需要对当前调用方遮盖
+func (mm *MaskingMiddleware) appliesTo(identity *middleware.Identity) bool {
+	if identity != nil {
+		for _, role := range mm.exemptRoles {
+			if identity.HasRole(role) {
+				return false
+			}
+		}
+	}
+	if len(mm.consumers) == 0 {
+		return true
+	}
+	if identity == nil {
+		return false
+	}
+	for _, consumer := range mm.consumers {
+		if consumer == identity.Subject {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies 检查响应是否需要遮盖，无法检查的压缩响应返回错误，避免敏感数据原样返回
+func (m *Masker) Applies(header http.Header) (bool, error) {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if !isTextual(mediaType) {
+		return false, nil
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false, fmt.Errorf("cannot mask response with content encoding '%s'", encoding)
+	}
+	return true, nil
+}
+
+// Mask 遮盖响应体，JSON响应按字段和字符串值遮盖，其余文本（包括无法解析的JSON）按正则遮盖
+func (m *Masker) Mask(body []byte, header http.Header) []byte {
+	counts := make(map[string]int)
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	if len(body) == 0 {
+		return body
+	}
+
+	var masked []byte
+	if isJSON(mediaType) {
+		var err error
+		masked, err = m.rules.maskJSON(body, counts)
+		if err != nil {
+			// 无法解析的JSON按文本遮盖
+			masked = nil
+		}
+	}
+	if masked == nil {
+		masked = []byte(m.rules.maskText(string(body), counts))
+	}
+
+	for rule, count := range counts {
+		maskedValues.Add(float64(count), rule)
+	}
+	if n := total(counts); n > 0 {
+		log.Printf("Masked %d value(s) in response for %s", n, m.path)
+	}
+	return masked
+}
+
+// isJSON 是否是JSON响应
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isTextual 是否是需要检查的文本响应，SSE等流式响应不做遮盖
+func isTextual(mediaType string) bool {
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	return isJSON(mediaType) || strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/x-www-form-urlencoded"
+}
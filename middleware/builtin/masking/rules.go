@@ -0,0 +1,224 @@
+package masking
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultFieldReplacement JSON字段被遮盖后的值
+const defaultFieldReplacement = "****"
+
+// patternRule 按正则表达式遮盖的规则
+type patternRule struct {
+	name    string
+	re      *regexp.Regexp
+	replace func(match string) string
+}
+
+// preset 内置的遮盖规则
+type preset struct {
+	expr    string
+	replace func(match string) string
+}
+
+// 内置规则：信用卡号保留后4位，邮箱保留首字母和域名
+var presets = map[string]preset{
+	"credit_card": {
+		expr:    `\b(?:\d[ -]?){12,18}\d\b`,
+		replace: maskCardNumber,
+	},
+	"email": {
+		expr:    `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+		replace: maskEmail,
+	},
+}
+
+// ruleSet 编译后的遮盖规则，相同配置的中间件实例共享
+type ruleSet struct {
+	fields           map[string]bool // 字段名或以.连接的字段路径（小写）
+	fieldReplacement string
+	patterns         []*patternRule
+}
+
+// newRuleSet 编译遮盖规则
+func newRuleSet(fields []string, fieldReplacement string, patterns []interface{}) (*ruleSet, error) {
+	rs := &ruleSet{
+		fields:           make(map[string]bool),
+		fieldReplacement: fieldReplacement,
+	}
+	if rs.fieldReplacement == "" {
+		rs.fieldReplacement = defaultFieldReplacement
+	}
+	for _, field := range fields {
+		rs.fields[strings.ToLower(field)] = true
+	}
+
+	for i, item := range patterns {
+		var name, expr, replacement string
+		switch p := item.(type) {
+		case string:
+			name = p
+		case map[string]interface{}:
+			name, _ = p["name"].(string)
+			expr, _ = p["regex"].(string)
+			replacement, _ = p["replacement"].(string)
+		default:
+			return nil, fmt.Errorf("patterns[%d]: expected a preset name or a mapping", i)
+		}
+
+		rule := &patternRule{name: name}
+		if expr == "" {
+			ps, ok := presets[name]
+			if !ok {
+				return nil, fmt.Errorf("patterns[%d]: unknown preset '%s' and no regex given", i, name)
+			}
+			expr = ps.expr
+			rule.replace = ps.replace
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("patterns[%d]: invalid regex: %v", i, err)
+		}
+		rule.re = re
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("pattern_%d", i)
+		}
+		if rule.replace == nil {
+			if replacement == "" {
+				replacement = defaultFieldReplacement
+			}
+			rule.replace = func(string) string { return replacement }
+		}
+		rs.patterns = append(rs.patterns, rule)
+	}
+
+	if len(rs.fields) == 0 && len(rs.patterns) == 0 {
+		return nil, fmt.Errorf("at least one field or pattern is required")
+	}
+	return rs, nil
+}
+
+// maskJSON 遮盖JSON响应中的字段和匹配的字符串值，没有遮盖任何内容时返回原始响应体
+func (rs *ruleSet) maskJSON(body []byte, counts map[string]int) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	before := total(counts)
+	document = rs.maskValue(document, "", counts)
+	if total(counts) == before {
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// maskValue 递归遮盖JSON值，path为以.连接的字段路径
+func (rs *ruleSet) maskValue(value interface{}, path string, counts map[string]int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := strings.ToLower(key)
+			if path != "" {
+				childPath = path + "." + childPath
+			}
+			if rs.fields[strings.ToLower(key)] || rs.fields[childPath] {
+				if child != nil {
+					v[key] = rs.fieldReplacement
+					counts["field:"+key]++
+				}
+				continue
+			}
+			v[key] = rs.maskValue(child, childPath, counts)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = rs.maskValue(child, path, counts)
+		}
+		return v
+	case string:
+		return rs.maskText(v, counts)
+	}
+	return value
+}
+
+// maskText 按正则规则遮盖文本
+func (rs *ruleSet) maskText(text string, counts map[string]int) string {
+	for _, rule := range rs.patterns {
+		text = rule.re.ReplaceAllStringFunc(text, func(match string) string {
+			if rule.name == "credit_card" && !luhnValid(match) {
+				return match
+			}
+			counts[rule.name]++
+			return rule.replace(match)
+		})
+	}
+	return text
+}
+
+// total 遮盖总次数
+func total(counts map[string]int) int {
+	n := 0
+	for _, count := range counts {
+		n += count
+	}
+	return n
+}
+
+// digitsOf 提取字符串中的数字
+func digitsOf(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// luhnValid 使用Luhn校验排除订单号等普通数字
+func luhnValid(s string) bool {
+	digits := digitsOf(s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// maskCardNumber 信用卡号只保留后4位
+func maskCardNumber(match string) string {
+	digits := digitsOf(match)
+	return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
+
+// maskEmail 邮箱只保留用户名首字母和域名
+func maskEmail(match string) string {
+	at := strings.LastIndex(match, "@")
+	return match[:1] + "***" + match[at:]
+}
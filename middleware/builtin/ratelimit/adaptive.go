@@ -0,0 +1,301 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"toyou-proxy/metrics"
+	"toyou-proxy/middleware"
+	"toyou-proxy/privacy"
+)
+
+// 自适应限流默认配置
+const (
+	defaultAdaptiveWindow       = 10 * time.Second
+	defaultAdaptiveMinRequests  = 20
+	defaultAdaptiveNoisyClients = 5
+	defaultAdaptiveMinFactor    = 0.1
+)
+
+// 每个压力窗口将最繁忙客户端的限额减半，每个健康窗口加倍，直到恢复原限额
+const (
+	tightenMultiplier = 0.5
+	relaxMultiplier   = 2.0
+)
+
+// 自适应限流事件
+const (
+	eventTighten = "tighten"
+	eventRelax   = "relax"
+	eventRestore = "restore"
+)
+
+// 自适应限流指标
+var (
+	adaptiveThrottledClients = metrics.GetDefaultRegistry().NewGaugeVec(
+		"toyou_proxy_ratelimit_throttled_clients",
+		"Clients whose rate limit is currently tightened because of backend pressure.",
+		"service",
+	)
+	adaptiveEvents = metrics.GetDefaultRegistry().NewCounterVec(
+		"toyou_proxy_ratelimit_adaptive_events_total",
+		"Adaptive rate limit adjustments by service and event.",
+		"service", "event",
+	)
+)
+
+// adaptiveConfig 自适应限流配置
+type adaptiveConfig struct {
+	latencyThreshold   time.Duration // 平均上游延迟超过该值视为后端压力，0表示不检查
+	errorRateThreshold float64       // 上游错误率超过该值视为后端压力，0表示不检查
+	window             time.Duration // 统计窗口
+	minRequests        int           // 窗口内请求数少于该值时不判断压力
+	noisyClients       int           // 每次收紧的最繁忙客户端数
+	minFactor          float64       // 限额最多收紧到的比例
+}
+
+// parseAdaptiveConfig 解析 adaptive 配置，未配置时返回nil
+func parseAdaptiveConfig(config map[string]interface{}) (*adaptiveConfig, error) {
+	raw, exists := config["adaptive"]
+	if !exists || raw == nil {
+		return nil, nil
+	}
+	adaptive, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("adaptive must be a map")
+	}
+	if enabled, ok := adaptive["enabled"].(bool); ok && !enabled {
+		return nil, nil
+	}
+
+	cfg := &adaptiveConfig{
+		window:       defaultAdaptiveWindow,
+		minRequests:  defaultAdaptiveMinRequests,
+		noisyClients: defaultAdaptiveNoisyClients,
+		minFactor:    defaultAdaptiveMinFactor,
+	}
+
+	if ms, ok := middleware.ConfigInt(adaptive, "latency_threshold_ms"); ok {
+		if ms < 0 {
+			return nil, fmt.Errorf("adaptive.latency_threshold_ms must not be negative")
+		}
+		cfg.latencyThreshold = time.Duration(ms) * time.Millisecond
+	}
+	if rate, ok := configFloat(adaptive, "error_rate_threshold"); ok {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("adaptive.error_rate_threshold must be between 0 and 1")
+		}
+		cfg.errorRateThreshold = rate
+	}
+	if cfg.latencyThreshold == 0 && cfg.errorRateThreshold == 0 {
+		return nil, fmt.Errorf("adaptive requires latency_threshold_ms or error_rate_threshold")
+	}
+
+	if seconds, ok := middleware.ConfigInt(adaptive, "window"); ok {
+		if seconds < 1 {
+			return nil, fmt.Errorf("adaptive.window must be at least 1 second")
+		}
+		cfg.window = time.Duration(seconds) * time.Second
+	}
+	if n, ok := middleware.ConfigInt(adaptive, "min_requests"); ok {
+		if n < 1 {
+			return nil, fmt.Errorf("adaptive.min_requests must be at least 1")
+		}
+		cfg.minRequests = n
+	}
+	if n, ok := middleware.ConfigInt(adaptive, "noisy_clients"); ok {
+		if n < 1 {
+			return nil, fmt.Errorf("adaptive.noisy_clients must be at least 1")
+		}
+		cfg.noisyClients = n
+	}
+	if factor, ok := configFloat(adaptive, "min_factor"); ok {
+		if factor <= 0 || factor > 1 {
+			return nil, fmt.Errorf("adaptive.min_factor must be greater than 0 and at most 1")
+		}
+		cfg.minFactor = factor
+	}
+
+	return cfg, nil
+}
+
+// backendWindow 单个后端服务在当前统计窗口内的数据和被收紧的客户端
+type backendWindow struct {
+	start     time.Time
+	requests  int
+	errors    int
+	latency   time.Duration
+	clients   map[string]int     // 客户端在窗口内的请求数
+	throttled map[string]float64 // 被收紧客户端的限额比例
+}
+
+// adaptiveLimiter 按后端压力调整客户端限额
+// 每个统计窗口结束时检查后端的平均延迟和错误率：超过阈值时收紧窗口内请求最多的客户端，
+// 恢复健康后逐步放宽，直到恢复原限额
+type adaptiveLimiter struct {
+	config   adaptiveConfig
+	backends map[string]*backendWindow
+	mu       sync.Mutex
+}
+
+// 按配置共享的自适应限流状态
+var (
+	adaptiveLimiters   = make(map[string]*adaptiveLimiter)
+	adaptiveLimitersMu sync.Mutex
+)
+
+// getAdaptiveLimiter 获取或创建指定配置的自适应限流状态
+func getAdaptiveLimiter(key string, cfg adaptiveConfig) *adaptiveLimiter {
+	adaptiveLimitersMu.Lock()
+	defer adaptiveLimitersMu.Unlock()
+
+	limiter, exists := adaptiveLimiters[key]
+	if !exists {
+		limiter = &adaptiveLimiter{
+			config:   cfg,
+			backends: make(map[string]*backendWindow),
+		}
+		adaptiveLimiters[key] = limiter
+	}
+	return limiter
+}
+
+// factor 返回客户端访问后端时的限额比例，未被收紧时为1
+func (al *adaptiveLimiter) factor(service, client string) float64 {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	backend, exists := al.backends[service]
+	if !exists {
+		return 1
+	}
+	if factor, throttled := backend.throttled[client]; throttled {
+		return factor
+	}
+	return 1
+}
+
+// observe 记录一次上游响应，窗口结束时调整限额
+func (al *adaptiveLimiter) observe(service, client string, result middleware.UpstreamResult, now time.Time) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	backend, exists := al.backends[service]
+	if !exists {
+		backend = &backendWindow{
+			start:     now,
+			clients:   make(map[string]int),
+			throttled: make(map[string]float64),
+		}
+		al.backends[service] = backend
+	}
+
+	if now.Sub(backend.start) >= al.config.window {
+		al.evaluate(service, backend)
+		backend.start = now
+		backend.requests = 0
+		backend.errors = 0
+		backend.latency = 0
+		backend.clients = make(map[string]int)
+	}
+
+	backend.requests++
+	backend.latency += result.Latency
+	if result.Failed() {
+		backend.errors++
+	}
+	backend.clients[client]++
+}
+
+// evaluate 根据窗口统计收紧或放宽限额
+func (al *adaptiveLimiter) evaluate(service string, backend *backendWindow) {
+	defer func() {
+		adaptiveThrottledClients.Set(float64(len(backend.throttled)), service)
+	}()
+
+	if backend.requests < al.config.minRequests {
+		al.relax(service, backend)
+		return
+	}
+
+	avgLatency := backend.latency / time.Duration(backend.requests)
+	errorRate := float64(backend.errors) / float64(backend.requests)
+	pressure := (al.config.latencyThreshold > 0 && avgLatency > al.config.latencyThreshold) ||
+		(al.config.errorRateThreshold > 0 && errorRate > al.config.errorRateThreshold)
+	if !pressure {
+		al.relax(service, backend)
+		return
+	}
+
+	// 收紧窗口内请求最多的客户端
+	clients := make([]string, 0, len(backend.clients))
+	for client := range backend.clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if backend.clients[clients[i]] != backend.clients[clients[j]] {
+			return backend.clients[clients[i]] > backend.clients[clients[j]]
+		}
+		return clients[i] < clients[j]
+	})
+	if len(clients) > al.config.noisyClients {
+		clients = clients[:al.config.noisyClients]
+	}
+
+	for _, client := range clients {
+		factor, throttled := backend.throttled[client]
+		if !throttled {
+			factor = 1
+		}
+		factor *= tightenMultiplier
+		if factor < al.config.minFactor {
+			factor = al.config.minFactor
+		}
+		backend.throttled[client] = factor
+		adaptiveEvents.Inc(service, eventTighten)
+		log.Printf("Adaptive rate limit: backend '%s' under pressure (avg latency %v, error rate %.2f), tightening client %s to %.0f%% (%d requests in window)",
+			service, avgLatency.Round(time.Millisecond), errorRate, clientLabel(client), factor*100, backend.clients[client])
+	}
+}
+
+// relax 后端健康时逐步放宽被收紧的客户端，恢复原限额后移除
+func (al *adaptiveLimiter) relax(service string, backend *backendWindow) {
+	for client, factor := range backend.throttled {
+		factor *= relaxMultiplier
+		if factor >= 1 {
+			delete(backend.throttled, client)
+			adaptiveEvents.Inc(service, eventRestore)
+			log.Printf("Adaptive rate limit: backend '%s' recovered, restored client %s to full limit", service, clientLabel(client))
+			continue
+		}
+		backend.throttled[client] = factor
+		adaptiveEvents.Inc(service, eventRelax)
+		log.Printf("Adaptive rate limit: backend '%s' healthy, relaxing client %s to %.0f%%", service, clientLabel(client), factor*100)
+	}
+}
+
+// configFloat 读取数字类型的配置值
+func configFloat(config map[string]interface{}, key string) (float64, bool) {
+	switch v := config[key].(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// clientLabel 返回日志中使用的客户端标识，IP按隐私配置处理，请求头的值只记录哈希
+func clientLabel(key string) string {
+	if ip := strings.TrimPrefix(key, "ip:"); ip != key {
+		return "ip:" + privacy.ClientIP(ip)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "header#" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval 清理空闲令牌桶的间隔
+const sweepInterval = time.Minute
+
+// bucket 单个客户端的令牌桶
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketStore 令牌桶集合
+type bucketStore struct {
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// 按配置共享的令牌桶集合
+var (
+	bucketStores   = make(map[string]*bucketStore)
+	bucketStoresMu sync.Mutex
+)
+
+// getBucketStore 获取或创建指定配置的令牌桶集合
+func getBucketStore(key string) *bucketStore {
+	bucketStoresMu.Lock()
+	defer bucketStoresMu.Unlock()
+
+	store, exists := bucketStores[key]
+	if !exists {
+		store = &bucketStore{
+			buckets:   make(map[string]*bucket),
+			lastSweep: time.Now(),
+		}
+		bucketStores[key] = store
+	}
+	return store
+}
+
+// take 从令牌桶中取出一个令牌，返回是否允许、剩余令牌数以及被拒绝时需要等待的时间
+func (s *bucketStore) take(key string, rate, capacity float64, now time.Time) (bool, int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(rate, capacity, now)
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+
+	// 按经过的时间补充令牌
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, 0, wait
+	}
+
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// sweep 定期删除已经补满的令牌桶，避免客户端过多时内存持续增长
+func (s *bucketStore) sweep(rate, capacity float64, now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= capacity {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"toyou-proxy/clientip"
+	"toyou-proxy/middleware"
+	"toyou-proxy/tlsserver"
+)
+
+// 默认配置
+const (
+	defaultRequestsPerMinute = 100
+	defaultBurstSize         = 20
+)
+
+// configAliases 旧版本限流实现使用的配置键
+var configAliases = []middleware.ConfigKeyAlias{
+	{Old: "rate", New: "requests_per_minute"},
+	{Old: "limit", New: "requests_per_minute"},
+	{Old: "requests_per_second", New: "requests_per_minute", Convert: perSecondToPerMinute},
+	{Old: "burst", New: "burst_size"},
+	{Old: "key", New: "key_by"},
+}
+
+// RateLimitMiddleware 限流中间件，按客户端使用令牌桶限流
+// 令牌以 requests_per_minute 的速率补充，桶容量为 requests_per_minute + burst_size
+type RateLimitMiddleware struct {
+	requestsPerMinute int
+	burstSize         int
+	keyHeader         string // 为空时按客户端IP限流
+	keyFingerprint    string // ja3或ja4，按客户端TLS指纹限流
+	trustForwardedFor bool   // 总是信任连接来源的转发头，否则只信任advanced.trusted_proxies
+	stateKey          string // 限流状态的共享键，相同配置的中间件共享令牌桶
+	buckets           *bucketStore
+	adaptive          *adaptiveLimiter // 为nil时不根据后端压力调整限额
+}
+
+// NewRateLimitMiddleware 创建限流中间件
+func NewRateLimitMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	config = middleware.NormalizeConfigKeys("rate_limit", config, configAliases)
+
+	rlm := &RateLimitMiddleware{
+		requestsPerMinute: defaultRequestsPerMinute,
+		burstSize:         defaultBurstSize,
+	}
+
+	if rpm, ok := middleware.ConfigInt(config, "requests_per_minute"); ok {
+		if rpm < 1 {
+			return nil, fmt.Errorf("requests_per_minute must be at least 1")
+		}
+		rlm.requestsPerMinute = rpm
+	}
+	if burst, ok := middleware.ConfigInt(config, "burst_size"); ok {
+		if burst < 0 {
+			return nil, fmt.Errorf("burst_size must not be negative")
+		}
+		rlm.burstSize = burst
+	}
+
+	if keyBy, ok := config["key_by"].(string); ok && keyBy != "" && keyBy != "ip" {
+		switch {
+		case keyBy == "ja3" || keyBy == "ja4":
+			rlm.keyFingerprint = keyBy
+		case strings.HasPrefix(keyBy, "header:") && strings.TrimPrefix(keyBy, "header:") != "":
+			rlm.keyHeader = strings.TrimPrefix(keyBy, "header:")
+		default:
+			return nil, fmt.Errorf("invalid key_by '%s', expected 'ip', 'header:<name>', 'ja3' or 'ja4'", keyBy)
+		}
+	}
+
+	if trust, ok := config["trust_forwarded_for"].(bool); ok {
+		rlm.trustForwardedFor = trust
+	}
+
+	adaptive, err := parseAdaptiveConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// 中间件实例可能重新创建，限流状态按配置共享
+	key := fmt.Sprintf("%d|%d|%s|%s|%t", rlm.requestsPerMinute, rlm.burstSize, rlm.keyHeader, rlm.keyFingerprint, rlm.trustForwardedFor)
+	if adaptive != nil {
+		key += fmt.Sprintf("|%+v", *adaptive)
+		rlm.adaptive = getAdaptiveLimiter(key, *adaptive)
+	}
+	rlm.stateKey = key
+	rlm.buckets = getBucketStore(key)
+
+	return rlm, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("rate_limit", NewRateLimitMiddleware)
+}
+
+// Name 返回中间件名称
+func (rlm *RateLimitMiddleware) Name() string {
+	return "rate_limit"
+}
+
+// Handle 处理限流逻辑
+func (rlm *RateLimitMiddleware) Handle(context *middleware.Context) bool {
+	key := rlm.clientKey(context.Request)
+
+	// 后端压力下被收紧的客户端按比例降低补充速率和桶容量
+	factor := 1.0
+	if rlm.adaptive != nil {
+		service := context.ServiceName
+		factor = rlm.adaptive.factor(service, key)
+		context.OnComplete(func() {
+			if result, ok := context.UpstreamResult(); ok {
+				rlm.adaptive.observe(service, key, result, time.Now())
+			}
+		})
+	}
+
+	limit := int(math.Max(1, math.Round(float64(rlm.requestsPerMinute)*factor)))
+	capacity := float64(limit) + float64(rlm.burstSize)*factor
+	rate := float64(limit) / 60
+	allowed, remaining, retryAfter := rlm.take(context.Request.Context(), key, rate, capacity)
+
+	header := context.Response.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+	if !allowed {
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		context.StatusCode = http.StatusTooManyRequests
+		http.Error(context.Response, "Rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+// clientKey 获取限流键
+func (rlm *RateLimitMiddleware) clientKey(r *http.Request) string {
+	if rlm.keyHeader != "" {
+		if value := r.Header.Get(rlm.keyHeader); value != "" {
+			return "header:" + value
+		}
+	}
+	// 非HTTPS请求没有TLS指纹，按客户端IP限流
+	if rlm.keyFingerprint != "" {
+		if fingerprint := tlsserver.ClientFingerprint(r.Context()); fingerprint != nil {
+			if rlm.keyFingerprint == "ja3" {
+				return "ja3:" + fingerprint.JA3Hash
+			}
+			return "ja4:" + fingerprint.JA4
+		}
+	}
+	return "ip:" + rlm.clientIP(r)
+}
+
+// clientIP 获取客户端IP，按可信代理从X-Forwarded-For中选取客户端的地址而不是使用整个请求头，
+// 客户端在请求头中伪造的地址不会改变限流键
+func (rlm *RateLimitMiddleware) clientIP(r *http.Request) string {
+	resolver := clientip.GetDefaultResolver()
+	if rlm.trustForwardedFor {
+		return resolver.ForwardedClientIP(r)
+	}
+	return resolver.ClientIP(r)
+}
+
+// perSecondToPerMinute 将每秒请求数换算为每分钟请求数
+func perSecondToPerMinute(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return v * 60
+	case float64:
+		return v * 60
+	}
+	return value
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"toyou-proxy/stores"
+)
+
+// takeScript 在Redis中原子地执行令牌桶的补充和取出，使用Redis服务器的时间，多个实例的时钟偏差不影响补充速率；
+// 桶补满后键自动过期
+var takeScript = redis.NewScript(`
+local now = redis.call('TIME')
+now = tonumber(now[1]) + tonumber(now[2]) / 1000000
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1]) or capacity
+local last = tonumber(state[2]) or now
+if now > last then
+  tokens = math.min(capacity, tokens + (now - last) * rate)
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = (1 - tokens) / rate
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate * 1000) + 1000)
+return {allowed, math.floor(tokens), tostring(wait)}
+`)
+
+// take 取出一个令牌，配置了共享存储时使用Redis中的令牌桶，Redis出错或超时时使用本地令牌桶
+func (rlm *RateLimitMiddleware) take(ctx context.Context, key string, rate, capacity float64) (bool, int, time.Duration) {
+	if store := stores.GetDefaultRedis(); store != nil {
+		allowed, remaining, retryAfter, err := takeShared(ctx, store, rlm.stateKey, key, rate, capacity)
+		if err == nil {
+			return allowed, remaining, retryAfter
+		}
+		store.Failed("rate_limit", err)
+	}
+	return rlm.buckets.take(key, rate, capacity, time.Now())
+}
+
+// takeShared 从Redis中的令牌桶取出一个令牌，所有使用同一Redis的代理实例共享限额；
+// 键中的客户端标识使用哈希，Redis中不保存客户端IP和API密钥
+func takeShared(ctx context.Context, store *stores.Redis, config, client string, rate, capacity float64) (bool, int, time.Duration, error) {
+	sum := sha256.Sum256([]byte(config + "|" + client))
+	key := store.Key("ratelimit", hex.EncodeToString(sum[:16]))
+
+	ctx, cancel := store.Context(ctx)
+	defer cancel()
+
+	result, err := takeScript.Run(ctx, store.Client(), []string{key}, rate, capacity).Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if len(result) != 3 {
+		return false, 0, 0, fmt.Errorf("unexpected rate limit script result: %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	remaining, _ := result[1].(int64)
+	wait, _ := result[2].(string)
+	seconds, err := strconv.ParseFloat(wait, 64)
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("unexpected rate limit script result: %v", result)
+	}
+	return allowed == 1, int(remaining), time.Duration(seconds * float64(time.Second)), nil
+}
@@ -0,0 +1,134 @@
+package requestsigning
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+	"toyou-proxy/objectstore"
+)
+
+// SignerKey 当前请求的签名器在上下文中的键，代理在转发请求前调用
+const SignerKey = "request_signer"
+
+// 签名方式
+const (
+	schemeSigV4 = "sigv4"
+	schemeHMAC  = "hmac"
+)
+
+// defaultMaxBodySize 为计算请求体哈希缓冲的最大请求体
+const defaultMaxBodySize = 10 << 20
+
+// scheme 签名算法，payloadHash为请求体的十六进制SHA256
+type scheme interface {
+	sign(req *http.Request, payloadHash string, now time.Time)
+}
+
+// RequestSigningMiddleware 上游请求签名中间件
+// 代理使用配置的凭证为发往后端的请求签名（AWS SigV4或自定义HMAC），客户端无需持有后端凭证
+type RequestSigningMiddleware struct {
+	scheme      scheme
+	signBody    bool // 是否对请求体签名，为false时使用UNSIGNED-PAYLOAD
+	maxBodySize int64
+}
+
+// Signer 单个请求的签名器
+type Signer struct {
+	scheme      scheme
+	payloadHash string
+}
+
+// NewRequestSigningMiddleware 创建上游请求签名中间件
+func NewRequestSigningMiddleware(cfg map[string]interface{}) (middleware.Middleware, error) {
+	rm := &RequestSigningMiddleware{
+		signBody:    true,
+		maxBodySize: defaultMaxBodySize,
+	}
+	if size, ok := cfg["max_body_size"].(string); ok && size != "" {
+		parsed, err := config.ParseSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_body_size: %v", err)
+		}
+		rm.maxBodySize = parsed
+	}
+
+	schemeName, _ := cfg["scheme"].(string)
+	switch schemeName {
+	case schemeSigV4:
+		sigv4Cfg, _ := cfg["sigv4"].(map[string]interface{})
+		s, err := newSigV4Scheme(sigv4Cfg)
+		if err != nil {
+			return nil, fmt.Errorf("sigv4: %v", err)
+		}
+		rm.scheme = s
+		if unsigned, _ := sigv4Cfg["unsigned_payload"].(bool); unsigned {
+			rm.signBody = false
+		}
+	case schemeHMAC:
+		hmacCfg, _ := cfg["hmac"].(map[string]interface{})
+		s, err := newHMACScheme(hmacCfg)
+		if err != nil {
+			return nil, fmt.Errorf("hmac: %v", err)
+		}
+		rm.scheme = s
+	default:
+		return nil, fmt.Errorf("invalid scheme '%s', expected sigv4 or hmac", schemeName)
+	}
+
+	return rm, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("request_signing", NewRequestSigningMiddleware)
+}
+
+// Name 返回中间件名称
+func (rm *RequestSigningMiddleware) Name() string {
+	return "request_signing"
+}
+
+// Handle 计算请求体哈希，由代理在确定上游地址后签名
+func (rm *RequestSigningMiddleware) Handle(context *middleware.Context) bool {
+	payloadHash := objectstore.UnsignedPayload
+	if rm.signBody {
+		body, err := rm.readBody(context.Request)
+		if err != nil {
+			context.Logger().Printf("Request signing failed: %v", err)
+			return context.RespondText(http.StatusRequestEntityTooLarge, "request body too large to sign")
+		}
+		payloadHash = objectstore.PayloadHash(body)
+	}
+
+	context.Set(SignerKey, &Signer{scheme: rm.scheme, payloadHash: payloadHash})
+	return true
+}
+
+// readBody 读取请求体用于计算哈希，并替换为可重新读取的副本
+func (rm *RequestSigningMiddleware) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, rm.maxBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > rm.maxBodySize {
+		return nil, fmt.Errorf("request body exceeds %d bytes", rm.maxBodySize)
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return body, nil
+}
+
+// Sign 为发往上游的请求签名，需要在设置好上游地址和Host之后调用
+func (s *Signer) Sign(req *http.Request) {
+	s.scheme.sign(req, s.payloadHash, time.Now())
+}
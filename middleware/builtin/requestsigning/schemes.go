@@ -0,0 +1,158 @@
+package requestsigning
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"toyou-proxy/middleware"
+	"toyou-proxy/objectstore"
+)
+
+// sigV4Scheme AWS Signature Version 4
+type sigV4Scheme struct {
+	creds   objectstore.Credentials
+	region  string
+	service string
+}
+
+// newSigV4Scheme 读取SigV4配置，凭证支持env:和file:引用
+func newSigV4Scheme(cfg map[string]interface{}) (*sigV4Scheme, error) {
+	s := &sigV4Scheme{region: "us-east-1"}
+	if region, _ := cfg["region"].(string); region != "" {
+		s.region = region
+	}
+	s.service, _ = cfg["service"].(string)
+	if s.service == "" {
+		return nil, fmt.Errorf("service is required")
+	}
+
+	var err error
+	for key, target := range map[string]*string{
+		"access_key":    &s.creds.AccessKey,
+		"secret_key":    &s.creds.SecretKey,
+		"session_token": &s.creds.SessionToken,
+	} {
+		value, _ := cfg[key].(string)
+		if *target, err = middleware.ResolveSecret(value); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	if s.creds.AccessKey == "" || s.creds.SecretKey == "" {
+		return nil, fmt.Errorf("access_key and secret_key are required")
+	}
+	return s, nil
+}
+
+func (s *sigV4Scheme) sign(req *http.Request, payloadHash string, now time.Time) {
+	// 客户端传入的AWS请求头不参与签名
+	for name := range req.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			req.Header.Del(name)
+		}
+	}
+	objectstore.SignV4(req, s.creds, s.region, s.service, payloadHash, now)
+}
+
+// hmacScheme 自定义HMAC签名
+// 签名字符串为以下各行用换行连接：请求方法、路径和查询参数、时间戳、请求体SHA256、
+// 按配置顺序的签名请求头（小写名称:值）
+type hmacScheme struct {
+	key             []byte
+	keyID           string
+	newHash         func() hash.Hash
+	base64          bool
+	signatureHeader string
+	keyIDHeader     string
+	timestampHeader string
+	signedHeaders   []string
+}
+
+// newHMACScheme 读取HMAC签名配置，密钥支持env:和file:引用
+func newHMACScheme(cfg map[string]interface{}) (*hmacScheme, error) {
+	s := &hmacScheme{
+		signatureHeader: "X-Signature",
+		keyIDHeader:     "X-Key-Id",
+		timestampHeader: "X-Timestamp",
+	}
+
+	value, _ := cfg["key"].(string)
+	key, err := middleware.ResolveSecret(value)
+	if err != nil {
+		return nil, fmt.Errorf("key: %v", err)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	s.key = []byte(key)
+	s.keyID, _ = cfg["key_id"].(string)
+
+	algorithm, _ := cfg["algorithm"].(string)
+	switch strings.ToLower(algorithm) {
+	case "", "sha256":
+		s.newHash = sha256.New
+	case "sha512":
+		s.newHash = sha512.New
+	case "sha1":
+		s.newHash = sha1.New
+	default:
+		return nil, fmt.Errorf("invalid algorithm '%s', expected sha256, sha512 or sha1", algorithm)
+	}
+
+	encoding, _ := cfg["encoding"].(string)
+	switch encoding {
+	case "", "hex":
+	case "base64":
+		s.base64 = true
+	default:
+		return nil, fmt.Errorf("invalid encoding '%s', expected hex or base64", encoding)
+	}
+
+	for key, target := range map[string]*string{
+		"signature_header": &s.signatureHeader,
+		"key_id_header":    &s.keyIDHeader,
+		"timestamp_header": &s.timestampHeader,
+	} {
+		if header, _ := cfg[key].(string); header != "" {
+			*target = header
+		}
+	}
+	s.signedHeaders = middleware.ConfigStrings(cfg, "signed_headers")
+	return s, nil
+}
+
+func (s *hmacScheme) sign(req *http.Request, payloadHash string, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(s.timestampHeader, timestamp)
+
+	lines := []string{req.Method, req.URL.RequestURI(), timestamp, payloadHash}
+	for _, name := range s.signedHeaders {
+		value := req.Header.Get(name)
+		if strings.EqualFold(name, "host") {
+			value = req.Host
+		}
+		lines = append(lines, strings.ToLower(name)+":"+strings.TrimSpace(value))
+	}
+
+	mac := hmac.New(s.newHash, s.key)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	sum := mac.Sum(nil)
+
+	signature := hex.EncodeToString(sum)
+	if s.base64 {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	}
+	req.Header.Set(s.signatureHeader, signature)
+	if s.keyID != "" {
+		req.Header.Set(s.keyIDHeader, s.keyID)
+	}
+}
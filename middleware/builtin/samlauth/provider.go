@@ -0,0 +1,115 @@
+package samlauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+// metadataRetryInterval IdP元数据加载失败后的重试间隔
+const metadataRetryInterval = 30 * time.Second
+
+// provider SAML服务提供方（SP），IdP元数据在首次使用时加载，失败后定期重试
+type provider struct {
+	sp           saml.ServiceProvider
+	metadataURL  string
+	metadataFile string
+
+	loaded     bool
+	lastErr    error
+	lastLoadAt time.Time
+	mu         sync.Mutex
+}
+
+// newProvider 创建SP，加载SP证书和私钥
+func newProvider(entityID string, rootURL *url.URL, acsPath, metadataPath, certFile, keyFile, idpMetadataURL, idpMetadataFile string, allowIDPInitiated bool) (*provider, error) {
+	keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SP certificate: %v", err)
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("SP private key must be an RSA key")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SP certificate: %v", err)
+	}
+
+	metadataURL := *rootURL
+	metadataURL.Path = metadataPath
+	acsURL := *rootURL
+	acsURL.Path = acsPath
+	if entityID == "" {
+		entityID = metadataURL.String()
+	}
+
+	return &provider{
+		sp: saml.ServiceProvider{
+			EntityID:          entityID,
+			Key:               key,
+			Certificate:       cert,
+			MetadataURL:       metadataURL,
+			AcsURL:            acsURL,
+			AllowIDPInitiated: allowIDPInitiated,
+		},
+		metadataURL:  idpMetadataURL,
+		metadataFile: idpMetadataFile,
+	}, nil
+}
+
+// serviceProvider 返回已加载IdP元数据的SP
+func (p *provider) serviceProvider(ctx context.Context) (*saml.ServiceProvider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.loaded {
+		return &p.sp, nil
+	}
+	if p.lastErr != nil && time.Since(p.lastLoadAt) < metadataRetryInterval {
+		return nil, p.lastErr
+	}
+
+	p.lastLoadAt = time.Now()
+	metadata, err := p.loadMetadata(ctx)
+	if err != nil {
+		p.lastErr = fmt.Errorf("failed to load IdP metadata: %v", err)
+		return nil, p.lastErr
+	}
+
+	p.sp.IDPMetadata = metadata
+	p.loaded = true
+	p.lastErr = nil
+	log.Printf("SAML: loaded metadata for IdP '%s'", metadata.EntityID)
+	return &p.sp, nil
+}
+
+// loadMetadata 从文件或URL加载IdP元数据
+func (p *provider) loadMetadata(ctx context.Context) (*saml.EntityDescriptor, error) {
+	if p.metadataFile != "" {
+		data, err := os.ReadFile(p.metadataFile)
+		if err != nil {
+			return nil, err
+		}
+		return samlsp.ParseMetadata(data)
+	}
+
+	metadataURL, err := url.Parse(p.metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return samlsp.FetchMetadata(ctx, http.DefaultClient, *metadataURL)
+}
@@ -0,0 +1,369 @@
+package samlauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+
+	"toyou-proxy/middleware"
+	"toyou-proxy/session"
+)
+
+// 会话中保存的键
+const (
+	sessionSubject    = "saml_subject"
+	sessionAttributes = "saml_attributes"
+	sessionExpires    = "saml_expires"
+	sessionPending    = "saml_pending"
+)
+
+// maxPendingRequests 会话中保留的未完成登录请求数
+const maxPendingRequests = 5
+
+// SAMLMiddleware SAML服务提供方中间件，为不支持OIDC的企业SSO保护域名规则
+// 依赖会话中间件保存登录状态，链中必须在它之前配置session
+type SAMLMiddleware struct {
+	provider         *provider
+	acsPath          string
+	metadataPath     string
+	attributeHeaders map[string]string
+	userHeader       string
+	rolesAttribute   string
+	sessionDuration  time.Duration
+}
+
+// NewSAMLMiddleware 创建SAML中间件
+func NewSAMLMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	sm := &SAMLMiddleware{
+		acsPath:          "/saml/acs",
+		metadataPath:     "/saml/metadata",
+		attributeHeaders: make(map[string]string),
+		sessionDuration:  8 * time.Hour,
+	}
+
+	if acsPath, ok := config["acs_path"].(string); ok && acsPath != "" {
+		sm.acsPath = acsPath
+	}
+	if metadataPath, ok := config["metadata_path"].(string); ok && metadataPath != "" {
+		sm.metadataPath = metadataPath
+	}
+	if headers, ok := config["attribute_headers"].(map[string]interface{}); ok {
+		for attribute, header := range headers {
+			if h, ok := header.(string); ok && h != "" {
+				sm.attributeHeaders[attribute] = h
+			}
+		}
+	}
+	sm.userHeader, _ = config["user_header"].(string)
+	sm.rolesAttribute, _ = config["roles_attribute"].(string)
+	if seconds, ok := middleware.ConfigInt(config, "session_duration"); ok && seconds > 0 {
+		sm.sessionDuration = time.Duration(seconds) * time.Second
+	}
+
+	p, err := middleware.SharedState("saml", config, func() (interface{}, error) {
+		rootURL, _ := config["root_url"].(string)
+		parsedRoot, err := url.Parse(rootURL)
+		if rootURL == "" || err != nil || parsedRoot.Host == "" {
+			return nil, fmt.Errorf("root_url is required and must be an absolute URL")
+		}
+		certFile, _ := config["certificate"].(string)
+		keyFile, _ := config["private_key"].(string)
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("certificate and private_key are required")
+		}
+		metadataURL, _ := config["idp_metadata_url"].(string)
+		metadataFile, _ := config["idp_metadata_file"].(string)
+		if metadataURL == "" && metadataFile == "" {
+			return nil, fmt.Errorf("idp_metadata_url or idp_metadata_file is required")
+		}
+		entityID, _ := config["entity_id"].(string)
+		allowIDPInitiated, _ := config["allow_idp_initiated"].(bool)
+
+		return newProvider(entityID, parsedRoot, sm.acsPath, sm.metadataPath, certFile, keyFile, metadataURL, metadataFile, allowIDPInitiated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sm.provider = p.(*provider)
+
+	return sm, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("saml", NewSAMLMiddleware)
+}
+
+// Name 返回中间件名称
+func (sm *SAMLMiddleware) Name() string {
+	return "saml"
+}
+
+// Handle 处理SP元数据、断言消费以及已登录请求的属性映射
+func (sm *SAMLMiddleware) Handle(context *middleware.Context) bool {
+	request := context.Request
+
+	// 客户端不能伪造映射到后端的身份请求头
+	sm.stripIdentityHeaders(request)
+
+	s := context.Session()
+	if s == nil {
+		log.Printf("SAML: session middleware is required before saml")
+		context.StatusCode = http.StatusInternalServerError
+		http.Error(context.Response, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+
+	sp, err := sm.provider.serviceProvider(request.Context())
+	if err != nil {
+		log.Printf("SAML: %v", err)
+		context.StatusCode = http.StatusServiceUnavailable
+		http.Error(context.Response, "Authentication service unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+
+	switch request.URL.Path {
+	case sm.metadataPath:
+		sm.serveMetadata(context, sp)
+		return false
+	case sm.acsPath:
+		sm.consumeAssertion(context, sp, s)
+		return false
+	}
+
+	if subject := s.GetString(sessionSubject); subject != "" && !sm.expired(s) {
+		sm.applyIdentity(context, s, subject)
+		return true
+	}
+
+	// 只有页面导航可以跳转到IdP，API请求直接返回401
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		context.StatusCode = http.StatusUnauthorized
+		http.Error(context.Response, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	sm.startLogin(context, sp, s)
+	return false
+}
+
+// serveMetadata 输出SP元数据，供IdP管理员注册
+func (sm *SAMLMiddleware) serveMetadata(context *middleware.Context, sp *saml.ServiceProvider) {
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		log.Printf("SAML: failed to marshal metadata: %v", err)
+		context.StatusCode = http.StatusInternalServerError
+		http.Error(context.Response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	context.StatusCode = http.StatusOK
+	context.Response.Header().Set("Content-Type", "application/samlmetadata+xml")
+	context.Response.WriteHeader(http.StatusOK)
+	context.Response.Write(data)
+}
+
+// startLogin 创建认证请求并跳转到IdP
+func (sm *SAMLMiddleware) startLogin(context *middleware.Context, sp *saml.ServiceProvider, s *session.Session) {
+	authnRequest, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		log.Printf("SAML: failed to create authentication request: %v", err)
+		context.StatusCode = http.StatusInternalServerError
+		http.Error(context.Response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	relayState := randomToken()
+	redirectURL, err := authnRequest.Redirect(relayState, sp)
+	if err != nil {
+		log.Printf("SAML: failed to build redirect: %v", err)
+		context.StatusCode = http.StatusInternalServerError
+		http.Error(context.Response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// 记录请求ID和登录后返回的地址，ACS据此校验InResponseTo
+	pending := pendingRequests(s)
+	pending[relayState] = map[string]interface{}{
+		"id":     authnRequest.ID,
+		"return": context.Request.URL.RequestURI(),
+	}
+	for len(pending) > maxPendingRequests {
+		for key := range pending {
+			delete(pending, key)
+			break
+		}
+	}
+	s.Set(sessionPending, pending)
+
+	context.StatusCode = http.StatusFound
+	http.Redirect(context.Response, context.Request, redirectURL.String(), http.StatusFound)
+}
+
+// consumeAssertion 校验IdP返回的断言并建立登录会话
+func (sm *SAMLMiddleware) consumeAssertion(context *middleware.Context, sp *saml.ServiceProvider, s *session.Session) {
+	request := context.Request
+	if request.Method != http.MethodPost {
+		context.Response.Header().Set("Allow", http.MethodPost)
+		context.StatusCode = http.StatusMethodNotAllowed
+		http.Error(context.Response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := request.ParseForm(); err != nil {
+		context.StatusCode = http.StatusBadRequest
+		http.Error(context.Response, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	pending := pendingRequests(s)
+	relayState := request.PostForm.Get("RelayState")
+	var requestIDs []string
+	returnTo := "/"
+	if entry, ok := pending[relayState].(map[string]interface{}); ok {
+		if id, ok := entry["id"].(string); ok {
+			requestIDs = append(requestIDs, id)
+		}
+		if target, ok := entry["return"].(string); ok && strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+			returnTo = target
+		}
+		delete(pending, relayState)
+	}
+
+	assertion, err := sp.ParseResponse(request, requestIDs)
+	if err != nil {
+		if invalid, ok := err.(*saml.InvalidResponseError); ok {
+			log.Printf("SAML: invalid response: %v", invalid.PrivateErr)
+		} else {
+			log.Printf("SAML: invalid response: %v", err)
+		}
+		context.StatusCode = http.StatusForbidden
+		http.Error(context.Response, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		log.Printf("SAML: assertion has no subject")
+		context.StatusCode = http.StatusForbidden
+		http.Error(context.Response, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// 登录后更换会话ID，防止会话固定攻击
+	s.RenewID()
+	s.Set(sessionPending, pending)
+	s.Set(sessionSubject, assertion.Subject.NameID.Value)
+	s.Set(sessionAttributes, assertionAttributes(assertion))
+	s.Set(sessionExpires, sm.sessionExpiry(assertion).Unix())
+
+	log.Printf("SAML: '%s' logged in via IdP '%s'", assertion.Subject.NameID.Value, assertion.Issuer.Value)
+
+	context.StatusCode = http.StatusFound
+	http.Redirect(context.Response, request, returnTo, http.StatusFound)
+}
+
+// applyIdentity 将登录用户和属性写入调用方身份及转发给后端的请求头
+func (sm *SAMLMiddleware) applyIdentity(context *middleware.Context, s *session.Session, subject string) {
+	attributes, _ := s.Get(sessionAttributes)
+	attributeMap, _ := attributes.(map[string]interface{})
+
+	identity := &middleware.Identity{
+		Subject: subject,
+		Method:  "saml",
+		Claims:  attributeMap,
+	}
+	if sm.rolesAttribute != "" {
+		identity.Roles = middleware.ConfigStrings(attributeMap, sm.rolesAttribute)
+	}
+	context.SetIdentity(identity)
+
+	if sm.userHeader != "" {
+		context.Request.Header.Set(sm.userHeader, subject)
+	}
+	for attribute, header := range sm.attributeHeaders {
+		if values := middleware.ConfigStrings(attributeMap, attribute); len(values) > 0 {
+			context.Request.Header.Set(header, strings.Join(values, ","))
+		}
+	}
+}
+
+// stripIdentityHeaders 删除客户端请求中的身份请求头
+func (sm *SAMLMiddleware) stripIdentityHeaders(request *http.Request) {
+	if sm.userHeader != "" {
+		request.Header.Del(sm.userHeader)
+	}
+	for _, header := range sm.attributeHeaders {
+		request.Header.Del(header)
+	}
+}
+
+// expired 检查SAML登录是否过期
+func (sm *SAMLMiddleware) expired(s *session.Session) bool {
+	value, _ := s.Get(sessionExpires)
+	var expires int64
+	switch v := value.(type) {
+	case int64:
+		expires = v
+	case float64:
+		expires = int64(v)
+	default:
+		return true
+	}
+	return time.Now().Unix() >= expires
+}
+
+// sessionExpiry 登录有效期，取session_duration与IdP声明的SessionNotOnOrAfter中较早者
+func (sm *SAMLMiddleware) sessionExpiry(assertion *saml.Assertion) time.Time {
+	expiry := time.Now().Add(sm.sessionDuration)
+	for _, statement := range assertion.AuthnStatements {
+		if statement.SessionNotOnOrAfter != nil && statement.SessionNotOnOrAfter.Before(expiry) {
+			expiry = *statement.SessionNotOnOrAfter
+		}
+	}
+	return expiry
+}
+
+// assertionAttributes 提取断言中的属性，同时以Name和FriendlyName为键
+func assertionAttributes(assertion *saml.Assertion) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			values := make([]string, 0, len(attribute.Values))
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+			attributes[attribute.Name] = values
+			if attribute.FriendlyName != "" {
+				attributes[attribute.FriendlyName] = values
+			}
+		}
+	}
+	return attributes
+}
+
+// pendingRequests 获取会话中未完成的登录请求
+func pendingRequests(s *session.Session) map[string]interface{} {
+	pending := make(map[string]interface{})
+	if value, ok := s.Get(sessionPending); ok {
+		if existing, ok := value.(map[string]interface{}); ok {
+			for key, entry := range existing {
+				pending[key] = entry
+			}
+		}
+	}
+	return pending
+}
+
+// randomToken 生成RelayState
+func randomToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("failed to generate relay state: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,192 @@
+package sessionlimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+	"toyou-proxy/middleware"
+)
+
+// RequirementKey 当前域名规则的会话限制在上下文中的键，由代理根据域名规则设置
+const RequirementKey = "session_limit"
+
+// defaultIdleTimeout 会话空闲多久后不再计入限制
+const defaultIdleTimeout = 30 * time.Minute
+
+// sessionLimitEvents 超出并发会话限制的次数，按处理结果分类
+var sessionLimitEvents = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_session_limit_total",
+	"Sessions rejected or evicted by per-user concurrent session limits.",
+	"result",
+)
+
+// Requirement 域名规则的会话限制，Scope区分不同域名规则的会话计数
+type Requirement struct {
+	Scope string
+	Limit *config.SessionLimitConfig
+}
+
+// limits 解析后的会话限制
+type limits struct {
+	maxSessions int
+	maxDevices  int
+	evictOldest bool
+	idleTimeout time.Duration
+}
+
+// newLimits 根据配置创建会话限制
+func newLimits(cfg *config.SessionLimitConfig) *limits {
+	l := &limits{
+		maxSessions: cfg.MaxSessions,
+		maxDevices:  cfg.MaxDevices,
+		evictOldest: cfg.Action == "evict_oldest",
+		idleTimeout: defaultIdleTimeout,
+	}
+	if cfg.IdleTimeout > 0 {
+		l.idleTimeout = time.Duration(cfg.IdleTimeout) * time.Second
+	}
+	return l
+}
+
+// within 检查加入候选会话后是否仍满足限制
+func (l *limits) within(sessions map[string]*trackedSession, candidate *trackedSession) bool {
+	if l.maxSessions > 0 && len(sessions)+1 > l.maxSessions {
+		return false
+	}
+	if l.maxDevices > 0 {
+		devices := map[string]bool{candidate.device: true}
+		for _, session := range sessions {
+			devices[session.device] = true
+		}
+		if len(devices) > l.maxDevices {
+			return false
+		}
+	}
+	return true
+}
+
+// SessionLimitMiddleware 并发会话限制中间件，限制每个已认证用户同时活跃的会话和设备数
+// 需要会话中间件提供会话，认证中间件提供调用方身份
+type SessionLimitMiddleware struct {
+	limit *config.SessionLimitConfig
+}
+
+// limitError 超出会话限制时的响应
+type limitError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// NewSessionLimitMiddleware 创建并发会话限制中间件
+func NewSessionLimitMiddleware(cfg map[string]interface{}) (middleware.Middleware, error) {
+	sm := &SessionLimitMiddleware{}
+
+	maxSessions, _ := middleware.ConfigInt(cfg, "max_sessions")
+	maxDevices, _ := middleware.ConfigInt(cfg, "max_devices")
+	if maxSessions > 0 || maxDevices > 0 {
+		action, _ := cfg["action"].(string)
+		if action != "" && action != "reject" && action != "evict_oldest" {
+			return nil, fmt.Errorf("invalid action '%s', expected 'reject' or 'evict_oldest'", action)
+		}
+		idleTimeout, _ := middleware.ConfigInt(cfg, "idle_timeout")
+		sm.limit = &config.SessionLimitConfig{
+			MaxSessions: maxSessions,
+			MaxDevices:  maxDevices,
+			Action:      action,
+			IdleTimeout: idleTimeout,
+		}
+	}
+
+	return sm, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("session_limit", NewSessionLimitMiddleware)
+}
+
+// Name 返回中间件名称
+func (sm *SessionLimitMiddleware) Name() string {
+	return "session_limit"
+}
+
+// Handle 记录已认证用户的会话，超出限制时拒绝新会话或踢出最早的会话
+func (sm *SessionLimitMiddleware) Handle(context *middleware.Context) bool {
+	scope, limit := sm.requirement(context)
+	if limit == nil {
+		return true
+	}
+
+	// 未认证的请求由认证和授权中间件处理
+	identity := context.Identity()
+	if identity == nil || identity.Subject == "" {
+		return true
+	}
+
+	s := context.Session()
+	if s == nil {
+		log.Printf("Session limit: session middleware is required before session_limit")
+		context.StatusCode = http.StatusInternalServerError
+		http.Error(context.Response, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+
+	key := scope + "\x00" + identity.Subject
+	sessionID := s.ID()
+	result, evicted := defaultTracker.check(key, sessionID, deviceID(context.Request), newLimits(limit), time.Now())
+
+	switch result {
+	case resultEvicted:
+		defaultTracker.release(key, sessionID)
+		s.Destroy()
+		writeLimitError(context, http.StatusUnauthorized, "session_evicted", "session was ended because the account signed in elsewhere")
+		return false
+	case resultRejected:
+		s.Destroy()
+		sessionLimitEvents.Inc("rejected")
+		log.Printf("Session limit: rejected new session for '%s'", identity.Subject)
+		writeLimitError(context, http.StatusForbidden, "session_limit_exceeded", "too many active sessions for this account")
+		return false
+	}
+
+	if evicted > 0 {
+		sessionLimitEvents.Add(float64(evicted), "evicted")
+		log.Printf("Session limit: evicted %d oldest session(s) of '%s'", evicted, identity.Subject)
+	}
+
+	// 确保会话被保存，后续请求携带同一会话ID
+	if s.GetString("session_limit_subject") != identity.Subject {
+		s.Set("session_limit_subject", identity.Subject)
+	}
+	return true
+}
+
+// requirement 返回生效的会话限制，优先使用域名规则中的配置
+func (sm *SessionLimitMiddleware) requirement(context *middleware.Context) (string, *config.SessionLimitConfig) {
+	if value, exists := context.Get(RequirementKey); exists {
+		if req, ok := value.(*Requirement); ok && req.Limit != nil {
+			return req.Scope, req.Limit
+		}
+	}
+	return "", sm.limit
+}
+
+// deviceID 根据User-Agent识别设备
+func deviceID(r *http.Request) string {
+	sum := sha256.Sum256([]byte(r.UserAgent()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// writeLimitError 写入JSON格式的错误响应
+func writeLimitError(context *middleware.Context, status int, code, message string) {
+	context.StatusCode = status
+	context.Response.Header().Set("Content-Type", "application/json")
+	context.Response.WriteHeader(status)
+	json.NewEncoder(context.Response).Encode(limitError{Error: code, Message: message})
+}
@@ -0,0 +1,145 @@
+package sessionlimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// evictedRetention 被踢出的会话ID保留时间，期间再次出现时直接结束该会话
+const evictedRetention = 24 * time.Hour
+
+// 会话检查结果
+const (
+	resultAllowed = iota
+	resultRejected
+	resultEvicted
+)
+
+// trackedSession 活跃会话
+type trackedSession struct {
+	id        string
+	device    string
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// userSessions 单个用户的活跃会话和已踢出的会话
+type userSessions struct {
+	sessions    map[string]*trackedSession
+	evicted     map[string]time.Time
+	idleTimeout time.Duration
+}
+
+// tracker 按作用域（域名规则）和用户记录活跃会话
+type tracker struct {
+	users     map[string]*userSessions
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// 全局会话跟踪器，所有中间件实例共享
+var defaultTracker = &tracker{
+	users:     make(map[string]*userSessions),
+	lastSweep: time.Now(),
+}
+
+// check 记录会话并判断是否超出限制，返回结果和被踢出的会话数
+func (t *tracker) check(key, sessionID, device string, limit *limits, now time.Time) (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	user, exists := t.users[key]
+	if !exists {
+		user = &userSessions{
+			sessions: make(map[string]*trackedSession),
+			evicted:  make(map[string]time.Time),
+		}
+		t.users[key] = user
+	}
+	user.idleTimeout = limit.idleTimeout
+
+	if _, evicted := user.evicted[sessionID]; evicted {
+		return resultEvicted, 0
+	}
+
+	// 清理空闲会话，不再计入限制
+	for id, session := range user.sessions {
+		if now.Sub(session.lastSeen) > limit.idleTimeout {
+			delete(user.sessions, id)
+		}
+	}
+
+	if session, exists := user.sessions[sessionID]; exists {
+		session.lastSeen = now
+		return resultAllowed, 0
+	}
+
+	candidate := &trackedSession{id: sessionID, device: device, firstSeen: now, lastSeen: now}
+	if limit.within(user.sessions, candidate) {
+		user.sessions[sessionID] = candidate
+		return resultAllowed, 0
+	}
+
+	if !limit.evictOldest {
+		return resultRejected, 0
+	}
+
+	// 按登录时间从早到晚踢出会话，直到新会话满足限制
+	ordered := make([]*trackedSession, 0, len(user.sessions))
+	for _, session := range user.sessions {
+		ordered = append(ordered, session)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].firstSeen.Before(ordered[j].firstSeen)
+	})
+
+	evictedCount := 0
+	for _, session := range ordered {
+		if limit.within(user.sessions, candidate) {
+			break
+		}
+		delete(user.sessions, session.id)
+		user.evicted[session.id] = now
+		evictedCount++
+	}
+	user.sessions[sessionID] = candidate
+	return resultAllowed, evictedCount
+}
+
+// release 会话登出或被拒绝后不再计入
+func (t *tracker) release(key, sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if user, exists := t.users[key]; exists {
+		delete(user.sessions, sessionID)
+		delete(user.evicted, sessionID)
+	}
+}
+
+// sweep 定期清理没有活跃会话的用户
+func (t *tracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+
+	for key, user := range t.users {
+		for id, evictedAt := range user.evicted {
+			if now.Sub(evictedAt) > evictedRetention {
+				delete(user.evicted, id)
+			}
+		}
+		for id, session := range user.sessions {
+			if now.Sub(session.lastSeen) > user.idleTimeout {
+				delete(user.sessions, id)
+			}
+		}
+		if len(user.sessions) == 0 && len(user.evicted) == 0 {
+			delete(t.users, key)
+		}
+	}
+}
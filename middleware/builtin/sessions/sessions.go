@@ -0,0 +1,171 @@
+package sessions
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"toyou-proxy/middleware"
+	"toyou-proxy/session"
+)
+
+// SessionMiddleware 会话中间件，加载请求对应的会话并在写入响应头之前保存
+// 其他中间件通过 ctx.Session() 读写会话
+type SessionMiddleware struct {
+	manager *session.Manager
+}
+
+// NewSessionMiddleware 创建会话中间件
+// 会话管理器按配置共享，中间件实例重新创建后继续使用原有的内存存储和Redis连接
+func NewSessionMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	manager, err := middleware.SharedState("session", config, func() (interface{}, error) {
+		return newManager(config)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionMiddleware{manager: manager.(*session.Manager)}, nil
+}
+
+// newManager 根据配置创建会话管理器
+func newManager(config map[string]interface{}) (*session.Manager, error) {
+	options := session.Options{
+		Secure: true,
+	}
+
+	options.Secrets = middleware.ConfigStrings(config, "secrets")
+	if secret, ok := config["secret"].(string); ok && secret != "" {
+		options.Secrets = append([]string{secret}, options.Secrets...)
+	}
+
+	options.CookieName, _ = config["cookie_name"].(string)
+	options.Path, _ = config["cookie_path"].(string)
+	options.Domain, _ = config["cookie_domain"].(string)
+	if secure, ok := config["secure"].(bool); ok {
+		options.Secure = secure
+	}
+
+	switch sameSite, _ := config["same_site"].(string); strings.ToLower(sameSite) {
+	case "", "lax":
+		options.SameSite = http.SameSiteLaxMode
+	case "strict":
+		options.SameSite = http.SameSiteStrictMode
+	case "none":
+		options.SameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("invalid same_site '%s', expected lax, strict or none", sameSite)
+	}
+
+	if seconds, ok := middleware.ConfigInt(config, "idle_timeout"); ok {
+		options.IdleTimeout = time.Duration(seconds) * time.Second
+	}
+	if seconds, ok := middleware.ConfigInt(config, "absolute_timeout"); ok {
+		options.AbsoluteTimeout = time.Duration(seconds) * time.Second
+	}
+
+	var store session.Store
+	switch storeType, _ := config["store"].(string); storeType {
+	case "", "cookie":
+		store = session.NewCookieStore()
+	case "memory":
+		store = session.NewMemoryStore()
+	case "redis":
+		redisConfig, _ := config["redis"].(map[string]interface{})
+		addr, _ := redisConfig["addr"].(string)
+		if addr == "" {
+			return nil, fmt.Errorf("redis.addr is required for redis session store")
+		}
+		password, _ := redisConfig["password"].(string)
+		db, _ := middleware.ConfigInt(redisConfig, "db")
+		prefix, _ := redisConfig["key_prefix"].(string)
+		store = session.NewRedisStore(session.RedisOptions{
+			Addr:      addr,
+			Password:  password,
+			DB:        db,
+			KeyPrefix: prefix,
+		})
+	default:
+		return nil, fmt.Errorf("unknown session store '%s', expected cookie, memory or redis", storeType)
+	}
+
+	return session.NewManager(store, options)
+}
+
+func init() {
+	middleware.RegisterBuiltin("session", NewSessionMiddleware)
+}
+
+// Name 返回中间件名称
+func (sm *SessionMiddleware) Name() string {
+	return "session"
+}
+
+// Handle 加载会话，并包装响应以便在写入响应头之前保存会话
+func (sm *SessionMiddleware) Handle(context *middleware.Context) bool {
+	request := context.Request
+	s := sm.manager.Load(request)
+	context.Set(middleware.SessionKey, s)
+
+	context.Response = &sessionWriter{
+		ResponseWriter: context.Response,
+		save: func(w http.ResponseWriter) {
+			if err := sm.manager.Save(w, request, s); err != nil {
+				log.Printf("Session: failed to save session: %v", err)
+			}
+		},
+	}
+	return true
+}
+
+// sessionWriter 在第一次写入响应头时保存会话
+type sessionWriter struct {
+	http.ResponseWriter
+	save  func(w http.ResponseWriter)
+	saved bool
+}
+
+func (w *sessionWriter) saveOnce() {
+	if !w.saved {
+		w.saved = true
+		w.save(w.ResponseWriter)
+	}
+}
+
+// WriteHeader 保存会话后写入响应头
+func (w *sessionWriter) WriteHeader(statusCode int) {
+	w.saveOnce()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write 保存会话后写入响应体
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.saveOnce()
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush 支持流式响应（SSE）
+func (w *sessionWriter) Flush() {
+	w.saveOnce()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 支持协议升级
+func (w *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap 返回底层ResponseWriter，供http.ResponseController使用
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
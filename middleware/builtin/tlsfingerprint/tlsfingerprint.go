@@ -0,0 +1,100 @@
+package tlsfingerprint
+
+import (
+	"net/http"
+	"strings"
+
+	"toyou-proxy/middleware"
+	"toyou-proxy/tlsserver"
+)
+
+// 指纹在中间件上下文中的键，供后续中间件（如日志、限流）使用
+const (
+	ValueJA3     = "tls_ja3"
+	ValueJA3Hash = "tls_ja3_hash"
+	ValueJA4     = "tls_ja4"
+)
+
+// TLSFingerprintMiddleware 客户端TLS指纹中间件
+// 读取HTTPS连接握手时计算的JA3/JA4指纹，保存到上下文，可选地转发给后端，并拦截已知的恶意指纹
+type TLSFingerprintMiddleware struct {
+	ja3Header string
+	ja4Header string
+	blockJA3  map[string]bool // JA3字符串或其MD5
+	blockJA4  map[string]bool
+	status    int
+}
+
+// NewTLSFingerprintMiddleware 创建TLS指纹中间件
+//
+//	ja3_header / ja4_header  转发给后端的请求头，如 X-TLS-JA3；客户端自带的同名请求头总是被移除
+//	block_ja3 / block_ja4    拦截的指纹列表，JA3可以是原始字符串或MD5
+//	block_status             拦截时的状态码，默认403
+func NewTLSFingerprintMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	fm := &TLSFingerprintMiddleware{
+		blockJA3: make(map[string]bool),
+		blockJA4: make(map[string]bool),
+		status:   http.StatusForbidden,
+	}
+
+	fm.ja3Header, _ = config["ja3_header"].(string)
+	fm.ja4Header, _ = config["ja4_header"].(string)
+
+	for _, fingerprint := range middleware.ConfigStrings(config, "block_ja3") {
+		fm.blockJA3[strings.ToLower(fingerprint)] = true
+	}
+	for _, fingerprint := range middleware.ConfigStrings(config, "block_ja4") {
+		fm.blockJA4[strings.ToLower(fingerprint)] = true
+	}
+
+	if status, ok := middleware.ConfigInt(config, "block_status"); ok && status > 0 {
+		fm.status = status
+	}
+
+	return fm, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("tls_fingerprint", NewTLSFingerprintMiddleware)
+}
+
+// Name 返回中间件名称
+func (fm *TLSFingerprintMiddleware) Name() string {
+	return "tls_fingerprint"
+}
+
+// Handle 记录并检查客户端TLS指纹
+func (fm *TLSFingerprintMiddleware) Handle(context *middleware.Context) bool {
+	request := context.Request
+
+	// 指纹请求头只能由代理设置，避免客户端伪造
+	if fm.ja3Header != "" {
+		request.Header.Del(fm.ja3Header)
+	}
+	if fm.ja4Header != "" {
+		request.Header.Del(fm.ja4Header)
+	}
+
+	// 非HTTPS请求没有指纹
+	fingerprint := tlsserver.ClientFingerprint(request.Context())
+	if fingerprint == nil {
+		return true
+	}
+
+	context.Set(ValueJA3, fingerprint.JA3)
+	context.Set(ValueJA3Hash, fingerprint.JA3Hash)
+	context.Set(ValueJA4, fingerprint.JA4)
+
+	if fm.blockJA3[fingerprint.JA3Hash] || fm.blockJA3[fingerprint.JA3] || fm.blockJA4[strings.ToLower(fingerprint.JA4)] {
+		context.Logger().Printf("Blocked client TLS fingerprint ja3=%s ja4=%s", fingerprint.JA3Hash, fingerprint.JA4)
+		return context.RespondText(fm.status, http.StatusText(fm.status))
+	}
+
+	if fm.ja3Header != "" {
+		request.Header.Set(fm.ja3Header, fingerprint.JA3Hash)
+	}
+	if fm.ja4Header != "" {
+		request.Header.Set(fm.ja4Header, fingerprint.JA4)
+	}
+	return true
+}
@@ -0,0 +1,254 @@
+package uploadoffload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+	"toyou-proxy/objectstore"
+)
+
+// 默认配置
+const (
+	defaultMinRequestSize = 1 << 20 // 请求体小于该值时不卸载
+	defaultMaxInlineSize  = 1 << 20 // 非文件字段总大小上限
+	defaultMaxFileSize    = 5 << 30 // 单个文件大小上限（S3单次PUT上限）
+)
+
+// errTooLarge 文件或表单字段超过大小上限
+var errTooLarge = errors.New("upload too large")
+
+// UploadOffloadMiddleware 上传卸载中间件，把multipart请求中的文件直接写入S3兼容存储，
+// 转发给后端的请求中文件被替换为对象引用，后端不再接收大文件
+type UploadOffloadMiddleware struct {
+	client         *objectstore.Client
+	prefix         string
+	tempDir        string
+	minRequestSize int64
+	maxFileSize    int64
+}
+
+// ObjectReference 替换文件内容转发给后端的对象引用
+type ObjectReference struct {
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	URL         string `json:"url"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// NewUploadOffloadMiddleware 创建上传卸载中间件
+func NewUploadOffloadMiddleware(cfg map[string]interface{}) (middleware.Middleware, error) {
+	um := &UploadOffloadMiddleware{
+		minRequestSize: defaultMinRequestSize,
+		maxFileSize:    defaultMaxFileSize,
+	}
+	um.tempDir, _ = cfg["temp_dir"].(string)
+
+	for key, target := range map[string]*int64{"min_request_size": &um.minRequestSize, "max_file_size": &um.maxFileSize} {
+		if value, ok := cfg[key].(string); ok && value != "" {
+			size, err := config.ParseSize(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			*target = size
+		}
+	}
+
+	s3cfg, _ := cfg["s3"].(map[string]interface{})
+	client, err := middleware.SharedState("upload_offload", s3cfg, func() (interface{}, error) {
+		return objectstore.NewClient(objectstore.ConfigFromMap(s3cfg))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3: %v", err)
+	}
+	um.client = client.(*objectstore.Client)
+	um.prefix, _ = s3cfg["prefix"].(string)
+
+	return um, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("upload_offload", NewUploadOffloadMiddleware)
+}
+
+// Name 返回中间件名称
+func (um *UploadOffloadMiddleware) Name() string {
+	return "upload_offload"
+}
+
+// Handle 卸载multipart请求中的文件
+func (um *UploadOffloadMiddleware) Handle(context *middleware.Context) bool {
+	request := context.Request
+	mediaType, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return true
+	}
+	// 长度已知且较小的请求直接转发
+	if request.ContentLength >= 0 && request.ContentLength < um.minRequestSize {
+		return true
+	}
+
+	body, contentType, err := um.rewrite(context, multipart.NewReader(request.Body, params["boundary"]))
+	request.Body.Close()
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, errTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.As(err, new(*http.MaxBytesError)):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, errMalformed):
+			status = http.StatusBadRequest
+		}
+		log.Printf("Upload offload: %s %s: %v", request.Method, request.URL.Path, err)
+		context.StatusCode = status
+		http.Error(context.Response, http.StatusText(status), status)
+		return false
+	}
+
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Del("Expect")
+	request.Header.Set("X-Upload-Offloaded", "true")
+	return true
+}
+
+// errMalformed 无法解析的multipart请求
+var errMalformed = errors.New("malformed multipart request")
+
+// rewrite 重写multipart请求体：文件上传到存储桶，替换为JSON格式的对象引用，其余字段原样保留
+func (um *UploadOffloadMiddleware) rewrite(context *middleware.Context, reader *multipart.Reader) ([]byte, string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	var inlineSize int64
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.As(err, new(*http.MaxBytesError)) {
+				return nil, "", err
+			}
+			return nil, "", fmt.Errorf("%w: %v", errMalformed, err)
+		}
+
+		if part.FileName() == "" {
+			// 普通字段原样复制
+			dst, err := writer.CreatePart(part.Header)
+			if err != nil {
+				return nil, "", err
+			}
+			n, err := io.Copy(dst, io.LimitReader(part, defaultMaxInlineSize-inlineSize+1))
+			inlineSize += n
+			if err != nil {
+				return nil, "", err
+			}
+			if inlineSize > defaultMaxInlineSize {
+				return nil, "", fmt.Errorf("%w: form fields exceed %d bytes", errTooLarge, defaultMaxInlineSize)
+			}
+			continue
+		}
+
+		ref, err := um.offload(context, part)
+		if err != nil {
+			return nil, "", err
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(part.FormName())))
+		header.Set("Content-Type", "application/json")
+		dst, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := json.NewEncoder(dst).Encode(ref); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), writer.FormDataContentType(), nil
+}
+
+// offload 把文件写入临时文件并计算哈希，然后上传到存储桶
+func (um *UploadOffloadMiddleware) offload(context *middleware.Context, part *multipart.Part) (*ObjectReference, error) {
+	tmp, err := os.CreateTemp(um.tempDir, "toyou-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(part, um.maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > um.maxFileSize {
+		return nil, fmt.Errorf("%w: file '%s' exceeds %d bytes", errTooLarge, part.FileName(), um.maxFileSize)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	filename := path.Base(strings.ReplaceAll(part.FileName(), "\\", "/"))
+	contentType := part.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	key := path.Join(strings.Trim(um.prefix, "/"), time.Now().UTC().Format("2006/01/02"), objectID(), filename)
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	if err := um.client.PutObjectStream(context.Request.Context(), key, tmp, size, sum, contentType); err != nil {
+		return nil, fmt.Errorf("failed to upload '%s': %v", filename, err)
+	}
+	log.Printf("Upload offload: stored '%s' (%d bytes) as %s", filename, size, key)
+
+	return &ObjectReference{
+		Bucket:      um.client.Bucket(),
+		Key:         key,
+		URL:         um.client.ObjectURL(key).String(),
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      sum,
+	}, nil
+}
+
+// escapeQuotes 转义Content-Disposition中的引号
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
+}
+
+// objectID 生成对象键中的随机部分，避免同名文件互相覆盖
+func objectID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
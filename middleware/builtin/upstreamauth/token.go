@@ -0,0 +1,213 @@
+package upstreamauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 客户端凭证的传递方式
+const (
+	authStyleHeader = "header" // HTTP Basic认证（RFC 6749推荐）
+	authStyleBody   = "body"   // client_id和client_secret放在表单参数中
+)
+
+// defaultTokenLifetime 令牌响应没有expires_in时的缓存时间
+const defaultTokenLifetime = 5 * time.Minute
+
+// token 缓存的访问令牌
+type token struct {
+	accessToken string
+	tokenType   string
+	expiresAt   time.Time
+	refreshAt   time.Time // 进入刷新窗口的时间
+}
+
+// authScheme 令牌类型对应的认证方案，bearer统一写作Bearer
+func (t *token) authScheme() string {
+	if t.tokenType == "" || strings.EqualFold(t.tokenType, "bearer") {
+		return "Bearer"
+	}
+	return t.tokenType
+}
+
+// tokenResponse 令牌端点的响应（RFC 6749 5.1/5.2）
+type tokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	TokenType        string      `json:"token_type"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+// tokenSource 通过client credentials模式获取并缓存访问令牌
+// 令牌进入刷新窗口后由一个请求在后台刷新，其他请求继续使用未过期的旧令牌；
+// 令牌已过期时请求等待刷新完成
+type tokenSource struct {
+	tokenURL      string
+	clientID      string
+	clientSecret  string
+	authStyle     string
+	params        url.Values
+	refreshBefore time.Duration
+	client        *http.Client
+
+	mu         sync.Mutex
+	current    *token
+	refreshing bool
+	fetchMu    sync.Mutex // 串行化令牌请求，避免并发请求同时访问令牌端点
+}
+
+// 按配置共享的令牌
+var (
+	tokenSources   = make(map[string]*tokenSource)
+	tokenSourcesMu sync.Mutex
+)
+
+// getTokenSource 获取或创建指定配置的令牌
+func getTokenSource(key string, create func() *tokenSource) *tokenSource {
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+
+	source, exists := tokenSources[key]
+	if !exists {
+		source = create()
+		tokenSources[key] = source
+	}
+	return source
+}
+
+// Token 返回有效的访问令牌
+func (ts *tokenSource) Token() (*token, error) {
+	now := time.Now()
+
+	ts.mu.Lock()
+	current := ts.current
+	if current != nil && now.Before(current.expiresAt) {
+		if now.After(current.refreshAt) && !ts.refreshing {
+			ts.refreshing = true
+			go ts.refreshAsync()
+		}
+		ts.mu.Unlock()
+		return current, nil
+	}
+	ts.mu.Unlock()
+
+	return ts.refresh(current)
+}
+
+// refreshAsync 在后台提前刷新令牌，失败时保留旧令牌直到过期
+func (ts *tokenSource) refreshAsync() {
+	ts.mu.Lock()
+	stale := ts.current
+	ts.mu.Unlock()
+
+	if _, err := ts.refresh(stale); err != nil {
+		log.Printf("Upstream auth: background token refresh failed: %v", err)
+	}
+
+	ts.mu.Lock()
+	ts.refreshing = false
+	ts.mu.Unlock()
+}
+
+// refresh 获取新令牌，stale为调用方看到的旧令牌，其他请求已经刷新过时直接返回新令牌
+func (ts *tokenSource) refresh(stale *token) (*token, error) {
+	ts.fetchMu.Lock()
+	defer ts.fetchMu.Unlock()
+
+	ts.mu.Lock()
+	current := ts.current
+	ts.mu.Unlock()
+	if current != stale && current != nil && time.Now().Before(current.expiresAt) {
+		return current, nil
+	}
+
+	tok, err := ts.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	ts.current = tok
+	ts.mu.Unlock()
+	return tok, nil
+}
+
+// fetch 请求令牌端点
+func (ts *tokenSource) fetch() (*token, error) {
+	form := url.Values{}
+	for key, values := range ts.params {
+		form[key] = values
+	}
+	form.Set("grant_type", "client_credentials")
+	if ts.authStyle == authStyleBody {
+		form.Set("client_id", ts.clientID)
+		form.Set("client_secret", ts.clientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if ts.authStyle == authStyleHeader {
+		req.SetBasicAuth(url.QueryEscape(ts.clientID), url.QueryEscape(ts.clientSecret))
+	}
+
+	requested := time.Now()
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %v", err)
+	}
+
+	var parsed tokenResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid token response (status %d): %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || parsed.Error != "" {
+		if parsed.Error != "" {
+			return nil, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, parsed.Error, parsed.ErrorDescription)
+		}
+		return nil, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	if parsed.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+
+	// 过期时间从发出请求时算起，抵消网络延迟
+	lifetime := defaultTokenLifetime
+	if parsed.ExpiresIn != "" {
+		seconds, err := parsed.ExpiresIn.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires_in '%s'", parsed.ExpiresIn)
+		}
+		lifetime = time.Duration(seconds) * time.Second
+	}
+
+	// 有效期较短的令牌最多提前一半有效期刷新
+	refreshBefore := ts.refreshBefore
+	if refreshBefore > lifetime/2 {
+		refreshBefore = lifetime / 2
+	}
+
+	return &token{
+		accessToken: parsed.AccessToken,
+		tokenType:   parsed.TokenType,
+		expiresAt:   requested.Add(lifetime),
+		refreshAt:   requested.Add(lifetime - refreshBefore),
+	}, nil
+}
@@ -0,0 +1,138 @@
+package upstreamauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"toyou-proxy/middleware"
+)
+
+// 默认配置
+const (
+	defaultRefreshBefore = 60 * time.Second
+	defaultTimeout       = 10 * time.Second
+	defaultHeader        = "Authorization"
+)
+
+// UpstreamAuthMiddleware 上游OAuth2认证中间件
+// 使用client credentials模式从身份提供方获取访问令牌并缓存，在令牌过期前刷新，
+// 转发请求时以配置的请求头（默认Authorization: Bearer <token>）传给后端
+type UpstreamAuthMiddleware struct {
+	source    *tokenSource
+	header    string
+	prefix    string // 令牌前缀，为空时只传令牌本身
+	tokenType bool   // 使用令牌响应中的token_type作为前缀
+}
+
+// NewUpstreamAuthMiddleware 创建上游OAuth2认证中间件
+func NewUpstreamAuthMiddleware(cfg map[string]interface{}) (middleware.Middleware, error) {
+	tokenURL, _ := cfg["token_url"].(string)
+	if tokenURL == "" {
+		return nil, fmt.Errorf("token_url is required")
+	}
+	if parsed, err := url.Parse(tokenURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid token_url '%s'", tokenURL)
+	}
+
+	clientID, _ := cfg["client_id"].(string)
+	clientID, err := middleware.ResolveSecret(clientID)
+	if err != nil {
+		return nil, fmt.Errorf("client_id: %v", err)
+	}
+	clientSecret, _ := cfg["client_secret"].(string)
+	clientSecret, err = middleware.ResolveSecret(clientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("client_secret: %v", err)
+	}
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("client_id and client_secret are required")
+	}
+
+	authStyle, _ := cfg["auth_style"].(string)
+	switch authStyle {
+	case "":
+		authStyle = authStyleHeader
+	case authStyleHeader, authStyleBody:
+	default:
+		return nil, fmt.Errorf("invalid auth_style '%s', expected 'header' or 'body'", authStyle)
+	}
+
+	// 附加的令牌请求参数，如audience、resource
+	params := url.Values{}
+	if scopes := middleware.ConfigStrings(cfg, "scopes"); len(scopes) > 0 {
+		params.Set("scope", strings.Join(scopes, " "))
+	}
+	if extra, ok := cfg["params"].(map[string]interface{}); ok {
+		for key, value := range extra {
+			params.Set(key, fmt.Sprint(value))
+		}
+	}
+
+	refreshBefore, timeout := defaultRefreshBefore, defaultTimeout
+	if seconds, ok := middleware.ConfigInt(cfg, "refresh_before"); ok && seconds >= 0 {
+		refreshBefore = time.Duration(seconds) * time.Second
+	}
+	if seconds, ok := middleware.ConfigInt(cfg, "timeout"); ok && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	// 中间件实例可能重新创建，令牌按配置共享
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", tokenURL, clientID, clientSecret, authStyle, params.Encode(), refreshBefore, timeout)
+	source := getTokenSource(key, func() *tokenSource {
+		return &tokenSource{
+			tokenURL:      tokenURL,
+			clientID:      clientID,
+			clientSecret:  clientSecret,
+			authStyle:     authStyle,
+			params:        params,
+			refreshBefore: refreshBefore,
+			client:        &http.Client{Timeout: timeout},
+		}
+	})
+
+	um := &UpstreamAuthMiddleware{
+		source: source,
+		header: defaultHeader,
+	}
+	if header, _ := cfg["header"].(string); header != "" {
+		um.header = http.CanonicalHeaderKey(header)
+	}
+	// 未配置前缀时，Authorization请求头使用令牌类型，自定义请求头只传令牌本身
+	if prefix, ok := cfg["prefix"].(string); ok {
+		um.prefix = prefix
+	} else {
+		um.tokenType = um.header == defaultHeader
+	}
+
+	return um, nil
+}
+
+func init() {
+	middleware.RegisterBuiltin("upstream_auth", NewUpstreamAuthMiddleware)
+}
+
+// Name 返回中间件名称
+func (um *UpstreamAuthMiddleware) Name() string {
+	return "upstream_auth"
+}
+
+// Handle 为转发给后端的请求设置访问令牌，客户端传入的同名请求头被覆盖
+func (um *UpstreamAuthMiddleware) Handle(context *middleware.Context) bool {
+	tok, err := um.source.Token()
+	if err != nil {
+		context.Logger().Printf("Upstream auth failed: %v", err)
+		return context.RespondText(http.StatusBadGateway, "failed to obtain upstream access token")
+	}
+
+	value := tok.accessToken
+	if um.tokenType {
+		value = tok.authScheme() + " " + value
+	} else if um.prefix != "" {
+		value = um.prefix + " " + value
+	}
+	context.Request.Header.Set(um.header, value)
+	return true
+}
@@ -1,9 +1,15 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"runtime/pprof"
 	"sync"
+	"time"
+
+	"toyou-proxy/debuglog"
+	"toyou-proxy/profiler"
 )
 
 // DefaultMiddlewareChain 默认中间件链实现
@@ -25,7 +31,7 @@ func (dmc *DefaultMiddlewareChain) Add(middleware Middleware) {
 	defer dmc.mu.Unlock()
 
 	dmc.middlewares = append(dmc.middlewares, middleware)
-	log.Printf("Added middleware '%s' to chain", middleware.Name())
+	debuglog.Printf("Added middleware '%s' to chain", middleware.Name())
 }
 
 // Execute 执行中间件链
@@ -34,16 +40,49 @@ func (dmc *DefaultMiddlewareChain) Execute(ctx *Context) bool {
 	defer dmc.mu.RUnlock()
 
 	for _, middleware := range dmc.middlewares {
-		log.Printf("Executing middleware '%s'", middleware.Name())
-		if !middleware.Handle(ctx) {
+		// 请求已取消（客户端断开或超时）时不再执行后续中间件
+		if err := ctx.Err(); err != nil {
+			log.Printf("Request cancelled before middleware '%s': %v", middleware.Name(), err)
+			return false
+		}
+
+		debuglog.Printf("Executing middleware '%s'", middleware.Name())
+		start := time.Now()
+		next := handle(ctx, middleware)
+		duration := time.Since(start)
+
+		// 中间件已直接返回响应但未中断链时同样停止
+		if ctx.Responded() {
+			recordStep(ctx, middleware.Name(), duration, ResultResponded)
+			log.Printf("Middleware '%s' answered the request", middleware.Name())
+			return false
+		}
+		if !next {
+			recordStep(ctx, middleware.Name(), duration, ResultAbort)
 			log.Printf("Middleware '%s' interrupted the chain", middleware.Name())
 			return false
 		}
+		recordStep(ctx, middleware.Name(), duration, ResultContinue)
+		ctx.addResponseHandler(middleware)
 	}
 
 	return true
 }
 
+// handle 执行中间件，CPU采样期间为goroutine附加中间件和规则标签，采样结果可以按中间件汇总
+func handle(ctx *Context, middleware Middleware) bool {
+	if !profiler.Active() {
+		return middleware.Handle(ctx)
+	}
+
+	var next bool
+	labels := pprof.Labels(profiler.LabelMiddleware, middleware.Name(), profiler.LabelRoute, ctx.Route)
+	pprof.Do(ctx.Request.Context(), labels, func(context.Context) {
+		next = middleware.Handle(ctx)
+	})
+	return next
+}
+
 // GetMiddlewareNames 获取中间件名称列表
 func (dmc *DefaultMiddlewareChain) GetMiddlewareNames() []string {
 	dmc.mu.RLock()
@@ -0,0 +1,20 @@
+package middleware
+
+// completeKey 请求完成回调在上下文中的键
+const completeKey = "on_complete"
+
+// OnComplete 注册请求处理完成（响应已写完）后执行的回调，如归档、审计
+// 回调按注册的相反顺序执行
+func (c *Context) OnComplete(fn func()) {
+	callbacks, _ := c.Values[completeKey].([]func())
+	c.Set(completeKey, append(callbacks, fn))
+}
+
+// Complete 执行请求完成回调，由代理在请求处理结束时调用
+func (c *Context) Complete() {
+	callbacks, _ := c.Values[completeKey].([]func())
+	delete(c.Values, completeKey)
+	for i := len(callbacks) - 1; i >= 0; i-- {
+		callbacks[i]()
+	}
+}
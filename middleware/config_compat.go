@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"toyou-proxy/secrets"
+)
+
+// ConfigKeyAlias 旧配置键到规范配置键的转换
+type ConfigKeyAlias struct {
+	Old string
+	New string
+	// Convert 可选，旧值需要换算时使用（如每秒请求数换算为每分钟请求数）
+	Convert func(value interface{}) interface{}
+}
+
+// 已输出过的弃用警告，中间件实例可能多次创建，每个旧配置键只警告一次
+var deprecatedKeyWarnings sync.Map
+
+// NormalizeConfigKeys 将旧配置键转换为规范配置键，返回新的配置，不修改原配置
+// 新旧配置键同时存在时以新键为准
+func NormalizeConfigKeys(name string, config map[string]interface{}, aliases []ConfigKeyAlias) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		normalized[key] = value
+	}
+
+	for _, alias := range aliases {
+		value, exists := normalized[alias.Old]
+		if !exists {
+			continue
+		}
+		delete(normalized, alias.Old)
+
+		if _, loaded := deprecatedKeyWarnings.LoadOrStore(name+"|"+alias.Old, true); !loaded {
+			log.Printf("Warning: middleware '%s': config key '%s' is deprecated, use '%s' instead", name, alias.Old, alias.New)
+		}
+
+		if _, exists := normalized[alias.New]; exists {
+			continue
+		}
+		if alias.Convert != nil {
+			value = alias.Convert(value)
+		}
+		normalized[alias.New] = value
+	}
+
+	return normalized
+}
+
+// ConfigInt 读取整数配置，兼容YAML整数和JSON数字
+func ConfigInt(config map[string]interface{}, key string) (int, bool) {
+	switch v := config[key].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// ConfigStrings 读取字符串数组配置，也接受逗号分隔的字符串
+func ConfigStrings(config map[string]interface{}, key string) []string {
+	var result []string
+	switch v := config[key].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+	case []string:
+		result = append(result, v...)
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// ResolveSecret 解析凭证配置，避免把密钥明文写在配置文件中
+// env:NAME 读取环境变量，file:/path 读取文件内容（去除首尾空白），
+// vault:、kms: 引用从secrets中配置的密钥提供方读取，其他值原样返回
+func ResolveSecret(value string) (string, error) {
+	return secrets.Resolve(value)
+}
@@ -372,6 +372,23 @@ func getCORSSchema() *ConfigSchema {
 		Default:  []interface{}{"*"},
 	})
 
+	schema.AddRule("expose_headers", ConfigRule{
+		Type: "array",
+	})
+
+	schema.AddRule("max_age", ConfigRule{
+		Type: "int",
+		Min:  0.0,
+	})
+
+	schema.AddRule("allow_credentials", ConfigRule{
+		Type: "bool",
+	})
+
+	schema.AddRule("allow_private_network", ConfigRule{
+		Type: "bool",
+	})
+
 	return schema
 }
 
@@ -396,15 +413,24 @@ func getRateLimitSchema() *ConfigSchema {
 	schema.AddRule("requests_per_minute", ConfigRule{
 		Required: true,
 		Type:     "int",
-		Default:  60.0,
+		Default:  100.0,
 		Min:      1.0,
 	})
 
 	schema.AddRule("burst_size", ConfigRule{
 		Required: true,
 		Type:     "int",
-		Default:  10.0,
-		Min:      1.0,
+		Default:  20.0,
+		Min:      0.0,
+	})
+
+	schema.AddRule("key_by", ConfigRule{
+		Type:    "string",
+		Pattern: `^(ip|header:.+)$`,
+	})
+
+	schema.AddRule("trust_forwarded_for", ConfigRule{
+		Type: "bool",
 	})
 
 	return schema
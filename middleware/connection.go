@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ConnInfo 请求所在客户端连接的信息
+type ConnInfo struct {
+	ID           uint64               // 连接ID，进程内唯一，同一连接上的请求（keep-alive、HTTP/2多路复用）相同
+	AcceptedAt   time.Time            // 连接建立时间
+	ListenerPort int                  // 接受连接的监听端口
+	LocalAddr    string               // 连接的本地地址
+	RemoteAddr   string               // 连接的来源地址
+	Protocol     string               // 请求使用的HTTP协议，如 HTTP/1.1、HTTP/2.0
+	TLS          *tls.ConnectionState // HTTPS连接的TLS状态（版本、SNI、ALPN、客户端证书），HTTP连接为nil
+}
+
+// connKey 连接记录在请求上下文中的键
+type connKey struct{}
+
+// connRecord 连接建立时记录的信息
+type connRecord struct {
+	id         uint64
+	acceptedAt time.Time
+}
+
+// connCounter 连接ID计数器
+var connCounter uint64
+
+// ConnContext 为新连接分配连接ID，用作http.Server.ConnContext
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, &connRecord{
+		id:         atomic.AddUint64(&connCounter, 1),
+		acceptedAt: time.Now(),
+	})
+}
+
+// Connection 获取请求所在连接的信息
+// 未通过ConnContext接受的连接（如测试中构造的请求）ID为0
+func (c *Context) Connection() *ConnInfo {
+	r := c.Request
+	if r == nil {
+		return nil
+	}
+
+	info := &ConnInfo{
+		RemoteAddr: r.RemoteAddr,
+		Protocol:   r.Proto,
+		TLS:        r.TLS,
+	}
+	if record, ok := r.Context().Value(connKey{}).(*connRecord); ok {
+		info.ID = record.id
+		info.AcceptedAt = record.acceptedAt
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		info.LocalAddr = addr.String()
+		if _, port, err := net.SplitHostPort(info.LocalAddr); err == nil {
+			info.ListenerPort, _ = strconv.Atoi(port)
+		}
+	}
+	return info
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"toyou-proxy/matcher"
+)
+
+// DynamicRouteMapping 外部推送的域名到服务的映射
+type DynamicRouteMapping struct {
+	Host      string     `json:"host"`
+	Service   string     `json:"service"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // 为nil时永不过期
+}
+
+// DynamicRouteStore 动态路由映射存储
+// 外部系统通过管理API推送的映射保存在这里，优先于dynamic_route插件的拉取结果；
+// 插件与主程序共享同一个包实例，因此可以通过失效回调清理插件内部缓存
+type DynamicRouteStore struct {
+	mappings  map[string]DynamicRouteMapping
+	listeners []func(host string)
+	mu        sync.RWMutex
+}
+
+// 全局动态路由映射存储实例
+var globalDynamicRouteStore = &DynamicRouteStore{
+	mappings: make(map[string]DynamicRouteMapping),
+}
+
+// GetDynamicRouteStore 获取全局动态路由映射存储
+func GetDynamicRouteStore() *DynamicRouteStore {
+	return globalDynamicRouteStore
+}
+
+// Set 设置域名映射，ttl为0时永不过期
+func (s *DynamicRouteStore) Set(host, service string, ttl time.Duration) {
+	host = normalizeDynamicHost(host)
+	mapping := DynamicRouteMapping{
+		Host:    host,
+		Service: service,
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		mapping.ExpiresAt = &expiresAt
+	}
+
+	s.mu.Lock()
+	s.mappings[host] = mapping
+	s.mu.Unlock()
+
+	s.notify(host)
+}
+
+// Delete 删除域名映射，返回映射是否存在
+func (s *DynamicRouteStore) Delete(host string) bool {
+	host = normalizeDynamicHost(host)
+
+	s.mu.Lock()
+	_, exists := s.mappings[host]
+	delete(s.mappings, host)
+	s.mu.Unlock()
+
+	s.notify(host)
+	return exists
+}
+
+// Lookup 查询域名映射
+func (s *DynamicRouteStore) Lookup(host string) (string, bool) {
+	host = normalizeDynamicHost(host)
+
+	s.mu.RLock()
+	mapping, exists := s.mappings[host]
+	s.mu.RUnlock()
+
+	if !exists {
+		return "", false
+	}
+
+	if mapping.ExpiresAt != nil && time.Now().After(*mapping.ExpiresAt) {
+		s.mu.Lock()
+		delete(s.mappings, host)
+		s.mu.Unlock()
+		return "", false
+	}
+
+	return mapping.Service, true
+}
+
+// List 列出所有未过期的映射
+func (s *DynamicRouteStore) List() []DynamicRouteMapping {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	mappings := make([]DynamicRouteMapping, 0, len(s.mappings))
+	for _, mapping := range s.mappings {
+		if mapping.ExpiresAt == nil || now.Before(*mapping.ExpiresAt) {
+			mappings = append(mappings, mapping)
+		}
+	}
+
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].Host < mappings[j].Host
+	})
+	return mappings
+}
+
+// Invalidate 通知缓存失效，host为空时表示全部失效
+func (s *DynamicRouteStore) Invalidate(host string) {
+	s.notify(normalizeDynamicHost(host))
+}
+
+// OnInvalidate 注册缓存失效回调
+func (s *DynamicRouteStore) OnInvalidate(listener func(host string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, listener)
+}
+
+// notify 调用所有失效回调
+func (s *DynamicRouteStore) notify(host string) {
+	s.mu.RLock()
+	listeners := make([]func(string), len(s.listeners))
+	copy(listeners, s.listeners)
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(host)
+	}
+}
+
+// normalizeDynamicHost 规范化域名，与域名匹配器的规则一致（去除端口和末尾的点并转为小写）
+func normalizeDynamicHost(host string) string {
+	return matcher.NormalizeHost(host)
+}
@@ -5,6 +5,9 @@ import (
 	"log"
 	"reflect"
 	"sync"
+
+	"toyou-proxy/debuglog"
+	"toyou-proxy/secrets"
 )
 
 // DefaultMiddlewareFactory 默认中间件工厂实现
@@ -30,12 +33,18 @@ func (dmf *DefaultMiddlewareFactory) CreateMiddleware(name string, config map[st
 		return nil, fmt.Errorf("middleware creator for '%s' not found", name)
 	}
 
+	// 替换配置中的vault:、kms:密钥引用，密钥更新后新创建的实例使用新值
+	config, err := secrets.ResolveConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create middleware '%s': %v", name, err)
+	}
+
 	middleware, err := creator(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create middleware '%s': %v", name, err)
 	}
 
-	log.Printf("Successfully created middleware '%s'", name)
+	debuglog.Printf("Successfully created middleware '%s'", name)
 	return middleware, nil
 }
 
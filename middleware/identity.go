@@ -0,0 +1,77 @@
+package middleware
+
+import "strings"
+
+// IdentityKey 认证后的调用方身份在上下文中的键
+const IdentityKey = "identity"
+
+// Identity 认证中间件（JWT、API Key、LDAP等）识别出的调用方身份
+// 授权中间件根据其中的scopes和roles判断是否允许访问
+type Identity struct {
+	Subject string                 `json:"subject"`          // 调用方标识，如用户ID或API Key名称
+	Method  string                 `json:"method,omitempty"` // 认证方式，如 jwt、api_key
+	Scopes  []string               `json:"scopes,omitempty"`
+	Roles   []string               `json:"roles,omitempty"`
+	Claims  map[string]interface{} `json:"claims,omitempty"` // 原始声明
+}
+
+// SetIdentity 设置调用方身份，认证成功后由认证中间件调用
+func (c *Context) SetIdentity(identity *Identity) {
+	c.Set(IdentityKey, identity)
+}
+
+// Identity 获取调用方身份，未认证时返回nil
+func (c *Context) Identity() *Identity {
+	value, exists := c.Get(IdentityKey)
+	if !exists {
+		return nil
+	}
+	identity, _ := value.(*Identity)
+	return identity
+}
+
+// HasScope 检查是否拥有指定scope
+func (id *Identity) HasScope(scope string) bool {
+	return containsString(id.Scopes, scope)
+}
+
+// HasRole 检查是否拥有指定角色
+func (id *Identity) HasRole(role string) bool {
+	return containsString(id.Roles, role)
+}
+
+// IdentityFromClaims 根据令牌声明创建身份
+// scopes 读取 scope（空格分隔的字符串，RFC 8693）或 scp（数组）；roles 读取 roles 或 groups
+func IdentityFromClaims(method string, claims map[string]interface{}) *Identity {
+	identity := &Identity{
+		Method: method,
+		Claims: claims,
+	}
+
+	if subject, ok := claims["sub"].(string); ok {
+		identity.Subject = subject
+	}
+
+	if scope, ok := claims["scope"].(string); ok {
+		identity.Scopes = strings.Fields(scope)
+	} else {
+		identity.Scopes = ConfigStrings(claims, "scp")
+	}
+
+	identity.Roles = ConfigStrings(claims, "roles")
+	if len(identity.Roles) == 0 {
+		identity.Roles = ConfigStrings(claims, "groups")
+	}
+
+	return identity
+}
+
+// containsString 检查切片中是否包含指定字符串
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -23,6 +23,9 @@ type Context struct {
 	TargetURL   string                 // 目标服务URL
 	ServiceName string                 // 服务名称
 	StatusCode  int                    // 状态码，用于中间件设置响应状态
+	RequestID   string                 // 请求ID，来自X-Request-ID请求头或由代理生成
+	Route       string                 // 匹配的规则，如 api.example.com 或 api.example.com/v1/*
+	Labels      map[string]string      // 匹配规则的标签，如 team、product、tier
 }
 
 // Get 从上下文中获取值
@@ -34,6 +37,23 @@ func (c *Context) Get(key string) (interface{}, bool) {
 	return value, exists
 }
 
+// Done 返回请求取消通道，客户端断开或请求超时时关闭
+// 耗时的中间件应监听该通道，及时放弃后续处理
+func (c *Context) Done() <-chan struct{} {
+	if c.Request == nil {
+		return nil
+	}
+	return c.Request.Context().Done()
+}
+
+// Err 返回请求被取消的原因，未取消时返回nil
+func (c *Context) Err() error {
+	if c.Request == nil {
+		return nil
+	}
+	return c.Request.Context().Err()
+}
+
 // Set 在上下文中设置值
 func (c *Context) Set(key string, value interface{}) {
 	if c.Values == nil {
@@ -63,6 +83,12 @@ type Plugin interface {
 	Stop() error
 }
 
+// PluginHandle 已加载的插件
+type PluginHandle interface {
+	// Lookup 查找插件导出的符号
+	Lookup(symName string) (interface{}, error)
+}
+
 // PluginManager 插件管理器接口
 type PluginManager interface {
 	// LoadPlugin 加载插件
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+)
+
+// Lifecycle 可选的生命周期接口
+// 中间件实例按配置共享，配置变化、密钥轮换或插件重新加载后重新创建，实例本身不会被通知停止，
+// 因此清理、缓存刷新等后台任务应放在共享状态（SharedState）中，不要在创建中间件时启动协程；
+// 共享状态实现该接口时，服务器启动时调用Start，关闭或插件重新加载时调用Stop。
+// 服务器启动之后才创建的共享状态在创建时立即启动
+type Lifecycle interface {
+	// Start 启动后台任务，ctx在服务器关闭时取消
+	Start(ctx context.Context) error
+
+	// Stop 停止后台任务并释放资源，如写完队列中的数据
+	Stop() error
+}
+
+// HealthChecker 可选的健康检查接口，结果通过管理API输出
+type HealthChecker interface {
+	// HealthCheck 检查依赖（外部服务、后台队列等）是否正常，正常时返回nil
+	HealthCheck() error
+}
+
+// ComponentHealth 组件健康状态
+type ComponentHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// component 已登记的共享状态
+type component struct {
+	name    string // 中间件名称
+	id      string // 名称和配置摘要，如 archive#3f2a9c1d
+	value   interface{}
+	started bool
+}
+
+// LifecycleManager 管理实现了Lifecycle或HealthChecker的共享状态
+type LifecycleManager struct {
+	components []*component
+	ctx        context.Context
+	cancel     context.CancelFunc
+	running    bool
+	mu         sync.Mutex
+}
+
+// 全局生命周期管理器实例
+var globalLifecycleManager = &LifecycleManager{}
+
+// GetLifecycleManager 获取全局生命周期管理器
+func GetLifecycleManager() *LifecycleManager {
+	return globalLifecycleManager
+}
+
+// Register 登记共享状态，value未实现Lifecycle和HealthChecker时忽略
+// key用于区分相同中间件的不同配置，只保存其摘要，避免在管理API中暴露配置
+func (m *LifecycleManager) Register(name, key string, value interface{}) {
+	_, isLifecycle := value.(Lifecycle)
+	_, isChecker := value.(HealthChecker)
+	if !isLifecycle && !isChecker {
+		return
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	c := &component{
+		name:  name,
+		id:    name + "#" + hex.EncodeToString(sum[:4]),
+		value: value,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.components = append(m.components, c)
+	if m.running {
+		m.start(c)
+	}
+}
+
+// Start 启动所有已登记的组件，服务器开始处理请求前调用
+func (m *LifecycleManager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running {
+		return
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.running = true
+	for _, c := range m.components {
+		m.start(c)
+	}
+}
+
+// Stop 按登记的相反顺序停止所有组件，服务器停止处理请求后调用
+func (m *LifecycleManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running {
+		return
+	}
+	m.running = false
+	m.cancel()
+	for i := len(m.components) - 1; i >= 0; i-- {
+		m.stop(m.components[i])
+	}
+}
+
+// Remove 停止并移除指定中间件的所有组件，返回被移除的组件ID
+func (m *LifecycleManager) Remove(name string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	kept := m.components[:0]
+	for _, c := range m.components {
+		if c.name != name {
+			kept = append(kept, c)
+			continue
+		}
+		m.stop(c)
+		removed = append(removed, c.id)
+	}
+	m.components = kept
+	return removed
+}
+
+// HealthCheck 检查所有实现了HealthChecker的组件
+func (m *LifecycleManager) HealthCheck() []ComponentHealth {
+	m.mu.Lock()
+	components := make([]*component, len(m.components))
+	copy(components, m.components)
+	m.mu.Unlock()
+
+	var results []ComponentHealth
+	for _, c := range components {
+		checker, ok := c.value.(HealthChecker)
+		if !ok {
+			continue
+		}
+		result := ComponentHealth{Name: c.id, Healthy: true}
+		if err := checker.HealthCheck(); err != nil {
+			result.Healthy = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// start 启动单个组件，调用方持有锁
+func (m *LifecycleManager) start(c *component) {
+	lc, ok := c.value.(Lifecycle)
+	if !ok || c.started {
+		return
+	}
+	if err := lc.Start(m.ctx); err != nil {
+		log.Printf("Failed to start middleware component %s: %v", c.id, err)
+		return
+	}
+	c.started = true
+	log.Printf("Started middleware component %s", c.id)
+}
+
+// stop 停止单个组件，调用方持有锁
+func (m *LifecycleManager) stop(c *component) {
+	lc, ok := c.value.(Lifecycle)
+	if !ok || !c.started {
+		return
+	}
+	c.started = false
+	if err := lc.Stop(); err != nil {
+		log.Printf("Failed to stop middleware component %s: %v", c.id, err)
+		return
+	}
+	log.Printf("Stopped middleware component %s", c.id)
+}
@@ -7,7 +7,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"plugin"
 	"sync"
 )
 
@@ -64,7 +63,7 @@ func (dpm *DefaultPluginManager) LoadPlugin(pluginPath string) error {
 	}
 
 	// 加载插件
-	p, err := plugin.Open(soPath)
+	p, err := openPlugin(soPath)
 	if err != nil {
 		return fmt.Errorf("failed to open plugin: %v", err)
 	}
@@ -182,6 +181,9 @@ func (dpm *DefaultPluginManager) ReloadPlugin(pluginName string) error {
 		return fmt.Errorf("failed to unload plugin '%s': %v", pluginName, err)
 	}
 
+	// 停止旧插件的共享状态
+	ResetSharedState(pluginName)
+
 	// 重新加载插件
 	if err := dpm.LoadPlugin(pluginPath); err != nil {
 		return fmt.Errorf("failed to reload plugin '%s': %v", pluginName, err)
@@ -265,7 +267,7 @@ type PluginWrapper struct {
 	description string
 	middleware  Middleware
 	config      map[string]interface{}
-	plugin      *plugin.Plugin
+	plugin      PluginHandle
 }
 
 // Name 返回插件名称
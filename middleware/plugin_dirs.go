@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// CheckPluginDirs 启动时检查插件目录的权限
+// 插件以服务进程的权限执行，缓存目录对其他用户可写时任何本地用户都可以替换so文件，因此直接拒绝启动；
+// 只加载预编译插件时缓存目录只需可读，支持只读文件系统部署；否则缓存目录需要可写，不存在时自动创建
+func CheckPluginDirs(sourceDir, cacheDir string, precompiledOnly bool) error {
+	if precompiledOnly {
+		if err := checkPluginDir("cache", cacheDir); err != nil {
+			return err
+		}
+		if _, err := ReadPluginManifest(cacheDir); err != nil {
+			return fmt.Errorf("plugin cache directory '%s': precompiled_only requires a readable %s: %v", cacheDir, PluginManifestFile, err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create plugin cache directory '%s': %v", cacheDir, err)
+	}
+	if err := checkPluginDir("cache", cacheDir); err != nil {
+		return err
+	}
+	if err := checkWritable(cacheDir); err != nil {
+		return fmt.Errorf("plugin cache directory '%s' is not writable, enable plugins.precompiled_only for read-only deployments: %v", cacheDir, err)
+	}
+
+	// 源代码目录不存在时只使用内置中间件
+	if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
+		log.Printf("Plugin source directory '%s' does not exist, no plugins will be compiled", sourceDir)
+		return nil
+	}
+	return checkPluginDir("source", sourceDir)
+}
+
+// checkPluginDir 检查插件目录存在、可读且不能被其他用户写入
+func checkPluginDir(kind, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("plugin %s directory '%s': %v", kind, dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("plugin %s directory '%s' is not a directory", kind, dir)
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		return fmt.Errorf("plugin %s directory '%s' is world-writable (%v), refusing to load plugins from it", kind, dir, info.Mode().Perm())
+	}
+	if _, err := ioutil.ReadDir(dir); err != nil {
+		return fmt.Errorf("plugin %s directory '%s' is not readable: %v", kind, dir, err)
+	}
+	return nil
+}
+
+// checkWritable 通过创建临时文件检查目录是否可写
+func checkWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	name := file.Name()
+	file.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// PluginManifestFile 预编译插件清单文件名，位于插件缓存目录中
+const PluginManifestFile = "manifest.json"
+
+// PluginManifest 预编译插件清单，由 toyou-proxy plugins build 生成
+type PluginManifest struct {
+	GoVersion string            `json:"go_version"`
+	BuiltAt   time.Time         `json:"built_at"`
+	Plugins   map[string]string `json:"plugins"` // 插件名 -> 缓存目录中的so文件名
+}
+
+// Names 返回清单中的插件名，按名称排序
+func (m *PluginManifest) Names() []string {
+	names := make([]string, 0, len(m.Plugins))
+	for name := range m.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadPluginManifest 读取缓存目录中的插件清单
+func ReadPluginManifest(cacheDir string) (*PluginManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cacheDir, PluginManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest PluginManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse plugin manifest: %v", err)
+	}
+
+	if manifest.GoVersion != runtime.Version() {
+		return nil, fmt.Errorf("plugins were built with %s, but running %s", manifest.GoVersion, runtime.Version())
+	}
+
+	return &manifest, nil
+}
+
+// writePluginManifest 根据编译报告写入插件清单，只记录编译成功的插件
+func writePluginManifest(cacheDir string, report *PluginCompileReport) error {
+	manifest := PluginManifest{
+		GoVersion: runtime.Version(),
+		BuiltAt:   time.Now(),
+		Plugins:   make(map[string]string),
+	}
+	for _, result := range report.Results {
+		if result.Err == nil {
+			manifest.Plugins[result.Name] = filepath.Base(result.CachePath)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免正在启动的进程读到不完整的清单
+	manifestPath := filepath.Join(cacheDir, PluginManifestFile)
+	tmpPath := manifestPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, manifestPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build (linux || darwin || freebsd) && cgo
+
+package middleware
+
+import (
+	"plugin"
+)
+
+// PluginsSupported 当前平台是否支持动态加载插件
+const PluginsSupported = true
+
+// goPlugin 对plugin.Plugin的包装
+type goPlugin struct {
+	p *plugin.Plugin
+}
+
+// Lookup 查找插件导出的符号
+func (gp *goPlugin) Lookup(symName string) (interface{}, error) {
+	return gp.p.Lookup(symName)
+}
+
+// openPlugin 打开插件so文件
+func openPlugin(path string) (PluginHandle, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &goPlugin{p: p}, nil
+}
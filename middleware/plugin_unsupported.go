@@ -0,0 +1,17 @@
+//go:build !((linux || darwin || freebsd) && cgo)
+
+package middleware
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// PluginsSupported 当前平台是否支持动态加载插件
+// Windows以及未启用cgo的构建不支持plugin包，只能使用内置中间件
+const PluginsSupported = false
+
+// openPlugin 当前平台不支持插件
+func openPlugin(path string) (PluginHandle, error) {
+	return nil, fmt.Errorf("plugins are not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
@@ -2,34 +2,212 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 	"toyou-proxy/middleware"
 )
 
 // DynamicRouteMiddleware 动态路由中间件
 type DynamicRouteMiddleware struct {
-	apiURL             string
-	timeout            time.Duration
-	cacheExpiry        time.Duration
-	lastCacheUpdate    time.Time
-	cachedHostMappings map[string]string
-	httpClient         *http.Client
+	apiURL            string
+	timeout           time.Duration
+	cacheExpiry       time.Duration
+	negativeExpiry    time.Duration
+	backgroundRefresh bool
+	cache             *hostCache
+	httpClient        *http.Client
 }
 
 // APIResponse 外部API响应结构
 type APIResponse struct {
 	Data struct {
 		GotoServices string `json:"goto_services"`
+		TTL          int    `json:"ttl"` // 可选，单条映射的缓存秒数
 	} `json:"data"`
 	Code int    `json:"code"`
 	Msg  string `json:"msg"`
 }
 
+// BatchAPIResponse 批量映射接口响应结构
+type BatchAPIResponse struct {
+	Data struct {
+		Mappings []struct {
+			Host         string `json:"host"`
+			GotoServices string `json:"goto_services"`
+			TTL          int    `json:"ttl"`
+		} `json:"mappings"`
+	} `json:"data"`
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// cacheEntry 单个域名的缓存条目
+type cacheEntry struct {
+	target    string
+	expiresAt time.Time
+	negative  bool // 查询失败的负缓存，期间不再请求外部API
+}
+
+// lookup 进行中的外部API查询，done关闭后target和err可读
+type lookup struct {
+	done   chan struct{}
+	target string
+	err    error
+}
+
+// hostCache 线程安全的域名映射缓存
+type hostCache struct {
+	entries    map[string]*cacheEntry
+	refreshing map[string]*lookup // 正在查询外部API的域名
+	prefetcher *prefetcher        // 生效的批量预取任务，nil表示不预取
+	mu         sync.RWMutex
+}
+
+// 中间件实例可能重新创建，缓存按API地址在插件内共享
+var (
+	sharedCaches   = make(map[string]*hostCache)
+	sharedCachesMu sync.Mutex
+)
+
+// getSharedCache 获取指定API地址的共享缓存
+func getSharedCache(apiURL string) *hostCache {
+	sharedCachesMu.Lock()
+	defer sharedCachesMu.Unlock()
+
+	cache, exists := sharedCaches[apiURL]
+	if !exists {
+		cache = &hostCache{
+			entries:    make(map[string]*cacheEntry),
+			refreshing: make(map[string]*lookup),
+		}
+		sharedCaches[apiURL] = cache
+
+		// 外部推送映射变更时清理对应的缓存条目
+		middleware.GetDynamicRouteStore().OnInvalidate(cache.invalidate)
+	}
+	return cache
+}
+
+// invalidate 清理缓存条目，host为空时清空全部缓存
+func (hc *hostCache) invalidate(host string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if host == "" {
+		hc.entries = make(map[string]*cacheEntry)
+		return
+	}
+	delete(hc.entries, host)
+}
+
+// set 写入缓存条目
+func (hc *hostCache) set(host, target string, ttl time.Duration, negative bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	hc.entries[host] = &cacheEntry{
+		target:    target,
+		expiresAt: time.Now().Add(ttl),
+		negative:  negative,
+	}
+}
+
+// usePrefetcher 设置缓存生效的批量预取任务，之前的任务不再预取
+func (hc *hostCache) usePrefetcher(p *prefetcher) {
+	hc.mu.Lock()
+	previous := hc.prefetcher
+	hc.prefetcher = p
+	hc.mu.Unlock()
+
+	if p != nil && p != previous {
+		p.wakeUp()
+	}
+}
+
+// activePrefetcher 返回缓存生效的批量预取任务
+func (hc *hostCache) activePrefetcher() *prefetcher {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.prefetcher
+}
+
+// prefetcher 批量预取任务，作为共享状态由生命周期管理器在服务器启动后开始、关闭或插件重新加载时停止。
+// 相同配置只有一个任务；同一缓存只有最近创建的中间件所用的任务预取，
+// 重新加载配置后按新的地址和间隔预取，旧配置的任务不再请求外部接口
+type prefetcher struct {
+	url         string
+	interval    time.Duration
+	cacheExpiry time.Duration
+	cache       *hostCache
+	client      *http.Client
+	wake        chan struct{} // 任务重新生效时立即预取
+	cancel      context.CancelFunc
+	done        chan struct{}
+	mu          sync.Mutex
+}
+
+// Start 在后台开始预取，第一次预取立即执行
+func (p *prefetcher) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.done = make(chan struct{})
+	go p.run(ctx, p.done)
+	return nil
+}
+
+// Stop 停止预取并等待正在进行的请求结束
+func (p *prefetcher) Stop() error {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return nil
+}
+
+// wakeUp 通知任务立即预取
+func (p *prefetcher) wakeUp() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 按间隔预取，任务不再生效时跳过
+func (p *prefetcher) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if p.cache.activePrefetcher() == p {
+			if err := p.prefetchAll(ctx); err != nil && ctx.Err() == nil {
+				fmt.Printf("Dynamic route middleware: Prefetch failed: %v\n", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // NewDynamicRouteMiddleware 创建动态路由中间件
 func NewDynamicRouteMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
 	// 获取API URL，默认为 http://127.0.0.1:7080/api/host
@@ -50,16 +228,97 @@ func NewDynamicRouteMiddleware(config map[string]interface{}) (middleware.Middle
 		cacheExpirySeconds = ces
 	}
 
-	return &DynamicRouteMiddleware{
-		apiURL:             apiURL,
-		timeout:            time.Duration(timeoutSeconds) * time.Second,
-		cacheExpiry:        time.Duration(cacheExpirySeconds) * time.Second,
-		lastCacheUpdate:    time.Time{},
-		cachedHostMappings: make(map[string]string),
+	// 获取负缓存过期时间，默认为10秒
+	negativeExpirySeconds := 10.0
+	if nes, ok := config["negative_cache_seconds"].(float64); ok {
+		negativeExpirySeconds = nes
+	}
+
+	// 是否在缓存过期后先返回旧值并在后台刷新
+	backgroundRefresh, _ := config["background_refresh"].(bool)
+
+	// 批量预取接口地址，为空时不预取
+	prefetchURL, _ := config["prefetch_api_url"].(string)
+
+	// 获取批量预取间隔，默认为300秒
+	prefetchIntervalSeconds := 300.0
+	if pis, ok := config["prefetch_interval_seconds"].(float64); ok && pis > 0 {
+		prefetchIntervalSeconds = pis
+	}
+
+	drm := &DynamicRouteMiddleware{
+		apiURL:            apiURL,
+		timeout:           time.Duration(timeoutSeconds) * time.Second,
+		cacheExpiry:       time.Duration(cacheExpirySeconds) * time.Second,
+		negativeExpiry:    time.Duration(negativeExpirySeconds) * time.Second,
+		backgroundRefresh: backgroundRefresh,
+		cache:             getSharedCache(apiURL),
 		httpClient: &http.Client{
 			Timeout: time.Duration(timeoutSeconds) * time.Second,
 		},
-	}, nil
+	}
+
+	// 批量预取全部映射，避免每个域名的首个请求同步调用外部API；
+	// 预取在服务器启动后由生命周期管理器在后台执行，创建中间件时不请求外部接口
+	var p *prefetcher
+	if prefetchURL != "" {
+		state, err := middleware.SharedState("dynamic_route", config, func() (interface{}, error) {
+			return &prefetcher{
+				url:         prefetchURL,
+				interval:    time.Duration(prefetchIntervalSeconds * float64(time.Second)),
+				cacheExpiry: drm.cacheExpiry,
+				cache:       drm.cache,
+				client:      drm.httpClient,
+				wake:        make(chan struct{}, 1),
+			}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p = state.(*prefetcher)
+	}
+	drm.cache.usePrefetcher(p)
+
+	return drm, nil
+}
+
+// prefetchAll 从批量接口获取全部映射并写入缓存
+func (p *prefetcher) prefetchAll(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var batchResp BatchAPIResponse
+	if err := json.Unmarshal(respBody, &batchResp); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	if batchResp.Code != 200 {
+		return fmt.Errorf("API returned error: %s", batchResp.Msg)
+	}
+
+	// 预取的映射保持有效直到下一次预取之后，避免两次预取之间出现空窗
+	for _, mapping := range batchResp.Data.Mappings {
+		ttl := time.Duration(mapping.TTL) * time.Second
+		if ttl <= 0 {
+			ttl = p.interval + p.cacheExpiry
+		}
+		p.cache.set(strings.ToLower(mapping.Host), mapping.GotoServices, ttl, false)
+	}
+
+	fmt.Printf("Dynamic route middleware: Prefetched %d host mappings\n", len(batchResp.Data.Mappings))
+	return nil
 }
 
 // PluginMain 插件入口函数
@@ -82,21 +341,22 @@ func (drm *DynamicRouteMiddleware) Handle(context *middleware.Context) bool {
 	}
 
 	// 提取主机名部分（去除端口）
-	hostName := strings.Split(host, ":")[0]
+	hostName := strings.ToLower(strings.Split(host, ":")[0])
 
-	// 检查缓存是否有效
-	targetService, found := drm.getCachedTarget(hostName)
+	// 外部推送的映射优先，其次检查缓存是否有效
+	targetService, found := middleware.GetDynamicRouteStore().Lookup(hostName)
+	if !found {
+		targetService, found = drm.getCachedTarget(hostName)
+	}
 	if !found {
 		// 缓存未命中或已过期，调用外部API
-		newTarget, err := drm.queryExternalAPI(hostName)
+		newTarget, err := drm.refresh(hostName)
 		if err != nil {
 			// API调用失败，记录日志但继续执行原始路由
-			fmt.Printf("Dynamic route middleware: Failed to query external API for host '%s': %v\n", hostName, err)
+			context.Logger().Printf("Dynamic route middleware: Failed to query external API for host '%s': %v", hostName, err)
+			context.Metrics().Counter("toyou_proxy_dynamic_route_lookups_total", "Dynamic route lookups by result.", "result").Inc("error")
 			return true
 		}
-
-		// 更新缓存
-		drm.updateCache(hostName, newTarget)
 		targetService = newTarget
 	}
 
@@ -108,68 +368,168 @@ func (drm *DynamicRouteMiddleware) Handle(context *middleware.Context) bool {
 		}
 		context.Values["dynamic_target_service"] = targetService
 
-		fmt.Printf("Dynamic route middleware: Rerouting host '%s' to service '%s'\n", hostName, targetService)
+		context.Logger().Printf("Dynamic route middleware: Rerouting host '%s' to service '%s'", hostName, targetService)
+		context.Metrics().Counter("toyou_proxy_dynamic_route_lookups_total", "Dynamic route lookups by result.", "result").Inc("rerouted")
 	}
 
 	return true
 }
 
 // getCachedTarget 从缓存中获取目标服务
+// 负缓存命中时返回空目标，表示沿用原始路由
 func (drm *DynamicRouteMiddleware) getCachedTarget(host string) (string, bool) {
-	// 检查缓存是否已过期
-	if time.Since(drm.lastCacheUpdate) > drm.cacheExpiry {
+	drm.cache.mu.RLock()
+	entry, exists := drm.cache.entries[host]
+	drm.cache.mu.RUnlock()
+
+	if !exists {
 		return "", false
 	}
 
-	target, exists := drm.cachedHostMappings[host]
-	return target, exists
+	if time.Now().Before(entry.expiresAt) {
+		if entry.negative {
+			return "", true
+		}
+		return entry.target, true
+	}
+
+	// 已过期：开启后台刷新时先返回旧值，避免阻塞请求
+	if drm.backgroundRefresh && !entry.negative {
+		drm.refreshAsync(host)
+		return entry.target, true
+	}
+
+	return "", false
+}
+
+// refresh 同步查询外部API并更新缓存，失败时写入负缓存
+// 同一域名同时只有一个查询，并发的缓存未命中等待并共享其结果
+func (drm *DynamicRouteMiddleware) refresh(host string) (string, error) {
+	l, started := drm.startLookup(host)
+	if !started {
+		<-l.done
+		return l.target, l.err
+	}
+	defer drm.finishLookup(host, l)
+
+	target, ttl, err := drm.queryExternalAPI(host)
+	if err != nil {
+		drm.updateCache(host, "", drm.negativeExpiry, true)
+		l.err = err
+		return "", err
+	}
+
+	if ttl <= 0 {
+		ttl = drm.cacheExpiry
+	}
+	drm.updateCache(host, target, ttl, false)
+	l.target = target
+	return target, nil
+}
+
+// refreshAsync 在后台刷新指定域名的映射，同一域名已有查询时直接返回
+func (drm *DynamicRouteMiddleware) refreshAsync(host string) {
+	l, started := drm.startLookup(host)
+	if !started {
+		return
+	}
+
+	go func() {
+		defer drm.finishLookup(host, l)
+
+		target, ttl, err := drm.queryExternalAPI(host)
+		if err != nil {
+			// 刷新失败时保留旧值，稍后重试
+			fmt.Printf("Dynamic route middleware: Background refresh failed for host '%s': %v\n", host, err)
+			drm.extendCache(host, drm.negativeExpiry)
+			l.err = err
+			return
+		}
+
+		if ttl <= 0 {
+			ttl = drm.cacheExpiry
+		}
+		drm.updateCache(host, target, ttl, false)
+		l.target = target
+	}()
+}
+
+// startLookup 登记域名的查询，已有进行中的查询时返回该查询且started为false
+func (drm *DynamicRouteMiddleware) startLookup(host string) (l *lookup, started bool) {
+	drm.cache.mu.Lock()
+	defer drm.cache.mu.Unlock()
+
+	if l, exists := drm.cache.refreshing[host]; exists {
+		return l, false
+	}
+	l = &lookup{done: make(chan struct{})}
+	drm.cache.refreshing[host] = l
+	return l, true
+}
+
+// finishLookup 结束查询并唤醒等待者，调用前缓存已更新
+func (drm *DynamicRouteMiddleware) finishLookup(host string, l *lookup) {
+	drm.cache.mu.Lock()
+	delete(drm.cache.refreshing, host)
+	drm.cache.mu.Unlock()
+	close(l.done)
 }
 
 // updateCache 更新缓存
-func (drm *DynamicRouteMiddleware) updateCache(host, target string) {
-	drm.cachedHostMappings[host] = target
-	drm.lastCacheUpdate = time.Now()
+func (drm *DynamicRouteMiddleware) updateCache(host, target string, ttl time.Duration, negative bool) {
+	drm.cache.set(host, target, ttl, negative)
+}
+
+// extendCache 延长已有缓存条目的有效期
+func (drm *DynamicRouteMiddleware) extendCache(host string, ttl time.Duration) {
+	drm.cache.mu.Lock()
+	defer drm.cache.mu.Unlock()
+
+	if entry, exists := drm.cache.entries[host]; exists {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
 }
 
 // queryExternalAPI 查询外部API获取目标服务
-func (drm *DynamicRouteMiddleware) queryExternalAPI(host string) (string, error) {
+// 返回目标服务以及API指定的缓存时长（未指定时为0）
+func (drm *DynamicRouteMiddleware) queryExternalAPI(host string) (string, time.Duration, error) {
 	// 准备请求体
 	requestBody := map[string]string{"host": host}
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %v", err)
+		return "", 0, fmt.Errorf("failed to marshal request body: %v", err)
 	}
 
 	// 创建HTTP请求
 	req, err := http.NewRequest("POST", drm.apiURL, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return "", 0, fmt.Errorf("failed to create request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	// 发送请求
 	resp, err := drm.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return "", 0, fmt.Errorf("failed to send request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// 读取响应
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return "", 0, fmt.Errorf("failed to read response: %v", err)
 	}
 
 	// 解析响应
 	var apiResp APIResponse
 	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
+		return "", 0, fmt.Errorf("failed to parse response: %v", err)
 	}
 
 	// 检查响应状态
 	if apiResp.Code != 200 {
-		return "", fmt.Errorf("API returned error: %s", apiResp.Msg)
+		return "", 0, fmt.Errorf("API returned error: %s", apiResp.Msg)
 	}
 
-	return apiResp.Data.GotoServices, nil
+	return apiResp.Data.GotoServices, time.Duration(apiResp.Data.TTL) * time.Second, nil
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"toyou-proxy/middleware"
+)
+
+// newPrefetchServer 启动批量映射接口，返回的映射把a.example.test指向target
+func newPrefetchServer(t *testing.T, target string) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprintf(w, `{"code": 200, "data": {"mappings": [{"host": "a.example.test", "goto_services": %q}]}}`, target)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// waitFor 等待cond成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestPrefetchFollowsLifecycleAndReloads(t *testing.T) {
+	first, firstRequests := newPrefetchServer(t, "first")
+	second, secondRequests := newPrefetchServer(t, "second")
+	apiURL := "http://127.0.0.1:1/api/host"
+
+	mw, err := NewDynamicRouteMiddleware(map[string]interface{}{"api_url": apiURL, "prefetch_api_url": first.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drm := mw.(*DynamicRouteMiddleware)
+	target := func() string {
+		target, _ := drm.getCachedTarget("a.example.test")
+		return target
+	}
+
+	// 创建中间件时不请求接口，服务器启动后在后台预取
+	if n := firstRequests.Load(); n != 0 {
+		t.Fatalf("%d prefetch requests while creating the middleware", n)
+	}
+	lifecycle := middleware.GetLifecycleManager()
+	lifecycle.Start()
+	defer lifecycle.Stop()
+	waitFor(t, "the first prefetch", func() bool { return target() == "first" })
+
+	// 重新加载后使用新的预取地址，旧配置的任务不再请求
+	if _, err := NewDynamicRouteMiddleware(map[string]interface{}{"api_url": apiURL, "prefetch_api_url": second.URL, "prefetch_interval_seconds": 0.05}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the prefetch of the new configuration", func() bool { return target() == "second" })
+	before := firstRequests.Load()
+	waitFor(t, "repeated prefetches", func() bool { return secondRequests.Load() >= 3 })
+	if n := firstRequests.Load(); n != before {
+		t.Errorf("the replaced prefetch sent %d more requests", n-before)
+	}
+
+	// 关闭后不再预取
+	lifecycle.Stop()
+	stopped := secondRequests.Load()
+	time.Sleep(150 * time.Millisecond)
+	if n := secondRequests.Load(); n != stopped {
+		t.Errorf("%d prefetch requests after the server stopped", n-stopped)
+	}
+}
+
+func TestConcurrentCacheMissesShareOneLookup(t *testing.T) {
+	// 接口在release关闭前不返回，期间到达的请求都是缓存未命中
+	var requests atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		fmt.Fprint(w, `{"code": 200, "data": {"goto_services": "cold-service"}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	mw, err := NewDynamicRouteMiddleware(map[string]interface{}{"api_url": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 20
+	contexts := make([]*middleware.Context, n)
+	var wg sync.WaitGroup
+	for i := range contexts {
+		contexts[i] = &middleware.Context{Request: httptest.NewRequest(http.MethodGet, "http://cold.example.test/", nil)}
+		wg.Add(1)
+		go func(context *middleware.Context) {
+			defer wg.Done()
+			mw.Handle(context)
+		}(contexts[i])
+	}
+	waitFor(t, "the first lookup", func() bool { return requests.Load() > 0 })
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("%d API requests for %d concurrent cache misses, want 1", got, n)
+	}
+	for i, context := range contexts {
+		if target, _ := context.Get("dynamic_target_service"); target != "cold-service" {
+			t.Errorf("request %d routed to %v, want cold-service", i, target)
+		}
+	}
+}
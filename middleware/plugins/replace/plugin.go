@@ -1,38 +1,23 @@
 package main
 
 import (
-	"bytes"
-	"net/http"
-	"regexp"
 	"toyou-proxy/middleware"
 )
 
 // ReplaceMiddleware 响应内容替换中间件
 type ReplaceMiddleware struct {
-	rules []ReplaceRule
+	rules []ReplaceRule // 创建时编译
 }
 
-// ReplaceRule 替换规则
-type ReplaceRule struct {
-	Pattern     string `json:"pattern"`
-	Replacement string `json:"replacement"`
-	Global      bool   `json:"global"`
-}
+// ReplaceRule 替换规则，与代理应用的替换规则相同
+type ReplaceRule = middleware.ReplaceRule
 
 // NewReplaceMiddleware 创建替换中间件
 func NewReplaceMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
-	var rules []ReplaceRule
-	if rulesData, ok := config["rules"].([]interface{}); ok {
-		for _, ruleData := range rulesData {
-			if rule, ok := ruleData.(map[string]interface{}); ok {
-				replaceRule := ReplaceRule{
-					Pattern:     getString(rule, "pattern"),
-					Replacement: getString(rule, "replacement"),
-					Global:      getBool(rule, "global"),
-				}
-				rules = append(rules, replaceRule)
-			}
-		}
+	// 无效的正则表达式和生效条件在加载时报告，不在处理请求时崩溃
+	rules, err := middleware.ParseReplaceRules(config)
+	if err != nil {
+		return nil, err
 	}
 
 	return &ReplaceMiddleware{
@@ -50,93 +35,16 @@ func (rm *ReplaceMiddleware) Name() string {
 	return "replace"
 }
 
-// Handle 处理替换逻辑
+// Handle 设置替换规则，代理收到上游响应后应用满足生效条件的规则
 func (rm *ReplaceMiddleware) Handle(context *middleware.Context) bool {
-
-	// 检查是否有替换规则
-	if len(rm.rules) == 0 {
-		return true
-	}
-
-	// 保存原始响应写入器
-	originalWriter := context.Response
-
-	// 创建缓冲区来捕获响应
-	var buf bytes.Buffer
-	context.Response = &responseWriter{
-		ResponseWriter: originalWriter,
-		body:           &buf,
-	}
-
-	// 继续处理请求
-	result := true
-
-	// 处理完成后，应用替换规则
-	if buf.Len() > 0 {
-		content := buf.String()
-		modifiedContent := rm.applyReplaceRules(content)
-
-		// 写入修改后的内容
-		originalWriter.Header().Set("Content-Length", string(len(modifiedContent)))
-		originalWriter.Write([]byte(modifiedContent))
-	}
-
-	return result
-}
-
-// applyReplaceRules 应用替换规则
-func (rm *ReplaceMiddleware) applyReplaceRules(content string) string {
-	result := content
-	for _, rule := range rm.rules {
-		if rule.Global {
-			// 全局替换
-			re := regexp.MustCompile(rule.Pattern)
-			result = re.ReplaceAllString(result, rule.Replacement)
-		} else {
-			// 单次替换
-			re := regexp.MustCompile(rule.Pattern)
-			result = re.ReplaceAllString(result, rule.Replacement)
-		}
-	}
-	return result
-}
-
-// responseWriter 自定义响应写入器
-type responseWriter struct {
-	http.ResponseWriter
-	body *bytes.Buffer
-}
-
-func (rw *responseWriter) Write(b []byte) (int, error) {
-	return rw.body.Write(b)
-}
-
-// 辅助函数
-func getString(data map[string]interface{}, key string) string {
-	if value, ok := data[key].(string); ok {
-		return value
-	}
-	return ""
-}
-
-func getBool(data map[string]interface{}, key string) bool {
-	if value, ok := data[key].(bool); ok {
-		return value
+	if len(rm.rules) > 0 {
+		context.Set("replaceRules", rm.rules)
 	}
-	return false
+	return true
 }
 
-// ApplyReplaceRules 应用替换规则的公共函数
-func ApplyReplaceRules(content string, rules []ReplaceRule) string {
-	result := content
-	for _, rule := range rules {
-		if rule.Global {
-			re := regexp.MustCompile(rule.Pattern)
-			result = re.ReplaceAllString(result, rule.Replacement)
-		} else {
-			re := regexp.MustCompile(rule.Pattern)
-			result = re.ReplaceAllString(result, rule.Replacement)
-		}
-	}
-	return result
+// ApplyReplaceRules 应用替换规则的公共函数；规则的正则表达式无效时返回错误和原始内容
+func ApplyReplaceRules(content string, rules []ReplaceRule) (string, error) {
+	result, err := middleware.ApplyReplaceRules([]byte(content), rules)
+	return string(result), err
 }
@@ -11,9 +11,33 @@ import (
 	"sync/atomic"
 	"time"
 
+	"toyou-proxy/matcher"
 	"toyou-proxy/middleware"
 )
 
+// ssePatterns 视为SSE请求的路径模式，与路由规则使用同一套模式语法，加载插件时编译一次
+var ssePatterns = mustCompilePatterns(
+	"/events/*",
+	"/stream/*",
+	"/sse/*",
+	"/api/events/*",
+	"/api/stream/*",
+	"/api/sse/*",
+)
+
+// mustCompilePatterns 编译内置的路径模式
+func mustCompilePatterns(patterns ...string) []*matcher.PathPattern {
+	compiled := make([]*matcher.PathPattern, len(patterns))
+	for i, pattern := range patterns {
+		p, err := matcher.CompilePathPattern(pattern)
+		if err != nil {
+			panic(err)
+		}
+		compiled[i] = p
+	}
+	return compiled
+}
+
 // SSEMiddleware 自动检测并处理SSE请求的中间件
 type SSEMiddleware struct {
 	// 连接统计
@@ -68,7 +92,8 @@ func (sm *SSEMiddleware) Handle(ctx *middleware.Context) bool {
 		}()
 
 		// 记录SSE连接
-		fmt.Printf("[SSE] New connection established: %s %s\n", req.Method, req.URL.Path)
+		ctx.Logger().Printf("[SSE] New connection established: %s %s", req.Method, req.URL.Path)
+		ctx.Metrics().Counter("toyou_proxy_sse_connections_total", "SSE connections established through the sse plugin.").Inc()
 	}
 
 	return true
@@ -84,17 +109,8 @@ func (sm *SSEMiddleware) isSSERequest(req *http.Request) bool {
 
 	// 检查特定路径模式
 	path := req.URL.Path
-	ssePatterns := []string{
-		"/events/*",
-		"/stream/*",
-		"/sse/*",
-		"/api/events/*",
-		"/api/stream/*",
-		"/api/sse/*",
-	}
-
 	for _, pattern := range ssePatterns {
-		if matched := sm.matchPath(pattern, path); matched {
+		if pattern.Match(path) {
 			return true
 		}
 	}
@@ -107,16 +123,6 @@ func (sm *SSEMiddleware) isSSERequest(req *http.Request) bool {
 	return false
 }
 
-// matchPath 匹配路径模式
-func (sm *SSEMiddleware) matchPath(pattern, path string) bool {
-	// 简单的通配符匹配
-	if strings.HasSuffix(pattern, "/*") {
-		prefix := strings.TrimSuffix(pattern, "/*")
-		return strings.HasPrefix(path, prefix)
-	}
-	return pattern == path
-}
-
 // setupSSEResponseHeaders 设置SSE响应头
 func (sm *SSEMiddleware) setupSSEResponseHeaders(resp http.ResponseWriter) {
 	// 设置内容类型
@@ -129,9 +135,11 @@ func (sm *SSEMiddleware) setupSSEResponseHeaders(resp http.ResponseWriter) {
 	// 保持连接
 	resp.Header().Set("Connection", "keep-alive")
 
-	// 设置CORS头（如果需要）
-	resp.Header().Set("Access-Control-Allow-Origin", "*")
-	resp.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
+	// 设置CORS头（如果需要），已由cors中间件处理时不覆盖
+	if resp.Header().Get("Access-Control-Allow-Origin") == "" {
+		resp.Header().Set("Access-Control-Allow-Origin", "*")
+		resp.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
+	}
 }
 
 // GetStats 获取SSE统计信息
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"sync/atomic"
 
+	"toyou-proxy/matcher"
 	"toyou-proxy/middleware"
 )
 
@@ -16,7 +18,7 @@ type WebSocketMiddleware struct {
 	errors            int64
 
 	// 配置参数
-	pathPatterns   []string
+	pathPatterns   []*matcher.PathPattern
 	maxConnections int64
 }
 
@@ -41,6 +43,16 @@ func NewWebSocketMiddleware(config map[string]interface{}) (middleware.Middlewar
 		}
 	}
 
+	// 编译路径模式，与路由规则使用同一套模式语法
+	compiled := make([]*matcher.PathPattern, 0, len(pathPatterns))
+	for _, pattern := range pathPatterns {
+		p, err := matcher.CompilePathPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern: %v", err)
+		}
+		compiled = append(compiled, p)
+	}
+
 	// 解析最大连接数
 	maxConnections := int64(1000) // 默认值
 	if mc, ok := config["max_connections"].(float64); ok {
@@ -48,7 +60,7 @@ func NewWebSocketMiddleware(config map[string]interface{}) (middleware.Middlewar
 	}
 
 	return &WebSocketMiddleware{
-		pathPatterns:   pathPatterns,
+		pathPatterns:   compiled,
 		maxConnections: maxConnections,
 	}, nil
 }
@@ -103,7 +115,7 @@ func (wm *WebSocketMiddleware) isWebSocketRequest(req *http.Request) bool {
 	// 检查特定路径模式
 	path := req.URL.Path
 	for _, pattern := range wm.pathPatterns {
-		if wm.matchPath(pattern, path) {
+		if pattern.Match(path) {
 			return true
 		}
 	}
@@ -117,16 +129,6 @@ func (wm *WebSocketMiddleware) isWebSocketRequest(req *http.Request) bool {
 	return false
 }
 
-// matchPath 匹配路径模式
-func (wm *WebSocketMiddleware) matchPath(pattern, path string) bool {
-	// 简单的通配符匹配
-	if strings.HasSuffix(pattern, "/*") {
-		prefix := strings.TrimSuffix(pattern, "/*")
-		return strings.HasPrefix(path, prefix)
-	}
-	return pattern == path
-}
-
 // GetStats 获取WebSocket统计信息
 func (wm *WebSocketMiddleware) GetStats() map[string]int64 {
 	return map[string]int64{
@@ -58,10 +58,9 @@ func (d *DefaultMiddlewareServiceRegistry) List() []config.MiddlewareService {
 func (d *DefaultMiddlewareServiceRegistry) Init(cfg *config.Config) error {
 	// 从配置中加载中间件服务
 	if cfg != nil && len(cfg.MiddlewareServices) > 0 {
+		// 禁用的中间件服务也会载入，以便通过管理API在运行时启用
 		for _, service := range cfg.MiddlewareServices {
-			if service.Enabled {
-				d.Register(service.Name, service)
-			}
+			d.Register(service.Name, service)
 		}
 	}
 	return nil
@@ -1,29 +1,318 @@
 package middleware
 
 import (
+	"fmt"
+	"mime"
+	"net/http"
 	"regexp"
+	"strings"
 )
 
 // ReplaceRule 替换规则
 type ReplaceRule struct {
-	Pattern     string `json:"pattern"`
-	Replacement string `json:"replacement"`
-	Global      bool   `json:"global"`
+	Pattern         string `json:"pattern"`
+	Replacement     string `json:"replacement"`      // 可以引用请求变量，如 {{host}}、{{header.X-Forwarded-Host}}
+	Global          bool   `json:"global"`           // 替换所有匹配，否则只替换第一个匹配
+	Literal         bool   `json:"literal"`          // pattern和replacement按普通字符串处理，不是正则表达式和$1引用
+	MaxReplacements int    `json:"max_replacements"` // 最多替换的次数，大于0时优先于global
+	// When 生效条件，为nil时替换所有响应
+	When *ReplaceCondition `json:"when,omitempty"`
+
+	re       *regexp.Regexp   // CompileReplaceRules编译的正则表达式
+	template []replaceSegment // 替换值中引用了请求变量时的分段，没有变量时为nil
 }
 
-// ApplyReplaceRules 应用替换规则的公共函数
-func ApplyReplaceRules(content []byte, rules []ReplaceRule) []byte {
-	result := string(content)
-	for _, rule := range rules {
-		if rule.Global {
-			// 全局替换
-			re := regexp.MustCompile(rule.Pattern)
-			result = re.ReplaceAllString(result, rule.Replacement)
+// ReplaceCondition 替换规则的生效条件，按上游响应判断，配置的各项都满足时才替换。
+// 同一路由上的图片、压缩包等二进制资源不满足条件时不读取响应体，原样转发
+type ReplaceCondition struct {
+	Status       []int    `json:"status"`        // 上游响应的状态码之一
+	ContentTypes []string `json:"content_types"` // 响应的媒体类型之一，如 text/html，text/* 匹配所有文本类型
+	Header       string   `json:"header"`        // 响应中需要带有的响应头，如上游标记需要替换的响应
+	HeaderValue  string   `json:"header_value"`  // 响应头的值，为空时只要求响应头存在
+}
+
+// Matches 判断上游响应是否满足条件
+func (c *ReplaceCondition) Matches(resp *http.Response) bool {
+	if c == nil {
+		return true
+	}
+
+	if len(c.Status) > 0 {
+		matched := false
+		for _, status := range c.Status {
+			if resp.StatusCode == status {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(c.ContentTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil {
+			return false
+		}
+		matched := false
+		for _, contentType := range c.ContentTypes {
+			contentType = strings.ToLower(contentType)
+			if contentType == mediaType || (strings.HasSuffix(contentType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(contentType, "*"))) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if c.Header != "" {
+		values := resp.Header.Values(c.Header)
+		if len(values) == 0 {
+			return false
+		}
+		if c.HeaderValue != "" {
+			matched := false
+			for _, value := range values {
+				if strings.TrimSpace(value) == c.HeaderValue {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ReplaceRulesFor 返回对上游响应生效的规则，没有生效的规则时代理不读取响应体
+func ReplaceRulesFor(rules []ReplaceRule, resp *http.Response) []ReplaceRule {
+	for i, rule := range rules {
+		if rule.When.Matches(resp) {
+			continue
+		}
+		// 存在不生效的规则时才复制
+		matched := append([]ReplaceRule(nil), rules[:i]...)
+		for _, rule := range rules[i+1:] {
+			if rule.When.Matches(resp) {
+				matched = append(matched, rule)
+			}
+		}
+		return matched
+	}
+	return rules
+}
+
+// limit 返回最多替换的次数，-1表示不限
+func (r ReplaceRule) limit() int {
+	if r.MaxReplacements > 0 {
+		return r.MaxReplacements
+	}
+	if r.Global {
+		return -1
+	}
+	return 1
+}
+
+// replace 按规则替换content中的匹配，替换值中的请求变量取自ctx
+func (r ReplaceRule) replace(content string, ctx *Context) string {
+	matches := r.re.FindAllStringSubmatchIndex(content, r.limit())
+	if len(matches) == 0 {
+		return content
+	}
+
+	result := make([]byte, 0, len(content))
+	last := 0
+	for _, match := range matches {
+		result = append(result, content[last:match[0]]...)
+		if r.template == nil {
+			result = r.expand(result, r.Replacement, content, match)
 		} else {
-			// 单次替换
-			re := regexp.MustCompile(rule.Pattern)
-			result = re.ReplaceAllString(result, rule.Replacement)
+			// 变量的值原样插入，其中的 $ 不作为分组引用
+			for _, segment := range r.template {
+				if segment.variable != "" {
+					result = append(result, replaceVariable(ctx, segment.variable)...)
+				} else {
+					result = r.expand(result, segment.text, content, match)
+				}
+			}
 		}
+		last = match[1]
 	}
-	return []byte(result)
-}
\ No newline at end of file
+	result = append(result, content[last:]...)
+	return string(result)
+}
+
+// expand 追加替换文本，非literal规则展开其中的$1等分组引用
+func (r ReplaceRule) expand(dst []byte, text, content string, match []int) []byte {
+	if r.Literal {
+		return append(dst, text...)
+	}
+	return r.re.ExpandString(dst, text, content, match)
+}
+
+// ParseReplaceRules 解析replace中间件配置中的rules并编译，规则无效时返回错误
+func ParseReplaceRules(config map[string]interface{}) ([]ReplaceRule, error) {
+	items, _ := config["rules"].([]interface{})
+	rules := make([]ReplaceRule, 0, len(items))
+	for i, item := range items {
+		data, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("replace rule %d: must be a mapping", i)
+		}
+		rule := ReplaceRule{}
+		rule.Pattern, _ = data["pattern"].(string)
+		rule.Replacement, _ = data["replacement"].(string)
+		rule.Global, _ = data["global"].(bool)
+		rule.Literal, _ = data["literal"].(bool)
+		rule.MaxReplacements, _ = toInt(data["max_replacements"])
+
+		if when, exists := data["when"]; exists {
+			condition, err := parseReplaceCondition(when)
+			if err != nil {
+				return nil, fmt.Errorf("replace rule %d: when: %v", i, err)
+			}
+			rule.When = condition
+		}
+		rules = append(rules, rule)
+	}
+	return CompileReplaceRules(rules)
+}
+
+// parseReplaceCondition 解析规则的when，status和content_types可以是单个值或列表
+func parseReplaceCondition(value interface{}) (*ReplaceCondition, error) {
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be a mapping")
+	}
+	for key := range data {
+		switch key {
+		case "status", "content_types", "header", "header_value":
+		default:
+			return nil, fmt.Errorf("unknown condition '%s'", key)
+		}
+	}
+
+	condition := &ReplaceCondition{}
+	for _, item := range toList(data["status"]) {
+		status, ok := toInt(item)
+		if !ok {
+			return nil, fmt.Errorf("invalid status %v", item)
+		}
+		condition.Status = append(condition.Status, status)
+	}
+	for _, item := range toList(data["content_types"]) {
+		contentType, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid content type %v", item)
+		}
+		condition.ContentTypes = append(condition.ContentTypes, contentType)
+	}
+	condition.Header, _ = data["header"].(string)
+	condition.HeaderValue, _ = data["header_value"].(string)
+	return condition, nil
+}
+
+// toList 将单个值转换为列表
+func toList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	}
+	return []interface{}{value}
+}
+
+// toInt 转换YAML（int）和JSON（float64）中的整数
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), v == float64(int(v))
+	}
+	return 0, false
+}
+
+// CompileReplaceRules 编译替换规则的正则表达式，中间件创建时调用一次，之后每个请求复用编译结果；
+// 正则表达式无效时返回错误，配置错误在加载时报告
+func CompileReplaceRules(rules []ReplaceRule) ([]ReplaceRule, error) {
+	compiled := make([]ReplaceRule, len(rules))
+	for i, rule := range rules {
+		if rule.MaxReplacements < 0 {
+			return nil, fmt.Errorf("replace rule %d: max_replacements must not be negative", i)
+		}
+		if err := rule.When.validate(); err != nil {
+			return nil, fmt.Errorf("replace rule %d: when: %v", i, err)
+		}
+		pattern := rule.Pattern
+		if rule.Literal {
+			if pattern == "" {
+				return nil, fmt.Errorf("replace rule %d: pattern is required", i)
+			}
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("replace rule %d: invalid pattern %q: %v", i, rule.Pattern, err)
+		}
+		rule.re = re
+		if rule.template, err = parseReplaceTemplate(rule.Replacement); err != nil {
+			return nil, fmt.Errorf("replace rule %d: replacement: %v", i, err)
+		}
+		compiled[i] = rule
+	}
+	return compiled, nil
+}
+
+// validate 检查生效条件
+func (c *ReplaceCondition) validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, status := range c.Status {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid status %d", status)
+		}
+	}
+	for _, contentType := range c.ContentTypes {
+		if !strings.Contains(contentType, "/") {
+			return fmt.Errorf("invalid content type '%s', expected e.g. text/html or text/*", contentType)
+		}
+	}
+	if c.HeaderValue != "" && c.Header == "" {
+		return fmt.Errorf("header is required with header_value")
+	}
+	return nil
+}
+
+// ApplyReplaceRules 应用替换规则的公共函数，替换值中的请求变量为空字符串。没有经过CompileReplaceRules的规则
+// 在每次调用时编译，正则表达式无效时返回错误和原始内容，不应用任何规则
+func ApplyReplaceRules(content []byte, rules []ReplaceRule) ([]byte, error) {
+	return ApplyReplaceRulesFor(content, rules, nil)
+}
+
+// ApplyReplaceRulesFor 对请求的响应应用替换规则，替换值中的请求变量取自ctx
+func ApplyReplaceRulesFor(content []byte, rules []ReplaceRule, ctx *Context) ([]byte, error) {
+	for _, rule := range rules {
+		if rule.re == nil {
+			compiled, err := CompileReplaceRules(rules)
+			if err != nil {
+				return content, err
+			}
+			rules = compiled
+			break
+		}
+	}
+
+	result := string(content)
+	for _, rule := range rules {
+		result = rule.replace(result, ctx)
+	}
+	return []byte(result), nil
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// replaceVariablePattern 替换值中的请求变量，如 {{host}}、{{header.X-Forwarded-Host}}、{{label.tier}}；
+// 其他 {{ 按普通文本处理
+var replaceVariablePattern = regexp.MustCompile(`\{\{\s*([a-z]+(?:\.[A-Za-z0-9_-]+)?)\s*\}\}`)
+
+// isReplaceVariable 判断是否为替换值支持的请求变量
+func isReplaceVariable(name string) bool {
+	switch name {
+	case "host", "hostname", "scheme", "path":
+		return true
+	}
+	return strings.HasPrefix(name, "header.") || strings.HasPrefix(name, "label.")
+}
+
+// replaceSegment 替换值的一段：普通文本（非literal规则中可以包含$1等分组引用）或请求变量
+type replaceSegment struct {
+	text     string
+	variable string // 变量名，如 host、header.X-Forwarded-Host
+}
+
+// parseReplaceTemplate 解析替换值中的请求变量，没有变量时返回nil
+func parseReplaceTemplate(replacement string) ([]replaceSegment, error) {
+	locations := replaceVariablePattern.FindAllStringSubmatchIndex(replacement, -1)
+	if len(locations) == 0 {
+		return nil, nil
+	}
+
+	var segments []replaceSegment
+	last := 0
+	for _, loc := range locations {
+		name := replacement[loc[2]:loc[3]]
+		if !isReplaceVariable(name) {
+			return nil, fmt.Errorf("unknown variable {{%s}}, expected host, hostname, scheme, path, header.<name> or label.<name>", name)
+		}
+		if loc[0] > last {
+			segments = append(segments, replaceSegment{text: replacement[last:loc[0]]})
+		}
+		segments = append(segments, replaceSegment{variable: name})
+		last = loc[1]
+	}
+	if last < len(replacement) {
+		segments = append(segments, replaceSegment{text: replacement[last:]})
+	}
+	return segments, nil
+}
+
+// replaceVariable 返回请求变量的值，请求中没有时为空字符串。
+// host为客户端请求的Host（可能带端口），hostname不带端口，scheme按客户端连接是否为TLS
+func replaceVariable(ctx *Context, name string) string {
+	if ctx == nil || ctx.Request == nil {
+		return ""
+	}
+	req := ctx.Request
+
+	switch name {
+	case "host":
+		return req.Host
+	case "hostname":
+		if host, _, err := net.SplitHostPort(req.Host); err == nil {
+			return host
+		}
+		return req.Host
+	case "scheme":
+		if req.TLS != nil {
+			return "https"
+		}
+		return "http"
+	case "path":
+		return req.URL.Path
+	}
+
+	if header, ok := strings.CutPrefix(name, "header."); ok {
+		return req.Header.Get(http.CanonicalHeaderKey(header))
+	}
+	if label, ok := strings.CutPrefix(name, "label."); ok {
+		return ctx.Labels[label]
+	}
+	return ""
+}
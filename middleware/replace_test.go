@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseReplaceRules(t *testing.T) {
+	// YAML中的整数为int，JSON（管理API、插件配置）中为float64
+	for _, max := range []interface{}{2, 2.0} {
+		rules, err := ParseReplaceRules(map[string]interface{}{"rules": []interface{}{
+			map[string]interface{}{
+				"pattern": "a", "replacement": "{{host}}b", "max_replacements": max,
+				"when": map[string]interface{}{"status": []interface{}{200.0, 203}, "content_types": "text/html"},
+			},
+		}})
+		if err != nil {
+			t.Fatalf("max_replacements %T: %v", max, err)
+		}
+		if rules[0].MaxReplacements != 2 || len(rules[0].When.Status) != 2 {
+			t.Errorf("max_replacements %T: parsed %+v", max, rules[0])
+		}
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"invalid pattern":      {"pattern": "("},
+		"empty literal":        {"literal": true},
+		"negative max":         {"pattern": "a", "max_replacements": -1},
+		"unknown variable":     {"pattern": "a", "replacement": "{{hots}}"},
+		"unknown condition":    {"pattern": "a", "when": map[string]interface{}{"stauts": 200}},
+		"invalid status":       {"pattern": "a", "when": map[string]interface{}{"status": 99}},
+		"fractional status":    {"pattern": "a", "when": map[string]interface{}{"status": 200.5}},
+		"invalid content type": {"pattern": "a", "when": map[string]interface{}{"content_types": "html"}},
+		"header_value only":    {"pattern": "a", "when": map[string]interface{}{"header_value": "x"}},
+	}
+	for name, rule := range invalid {
+		if _, err := ParseReplaceRules(map[string]interface{}{"rules": []interface{}{rule}}); err == nil {
+			t.Errorf("%s: accepted %v", name, rule)
+		}
+	}
+}
+
+func FuzzApplyReplaceRules(f *testing.F) {
+	f.Add("hello world hello", "hello", "bye", false, false, 0)
+	f.Add("hello world hello", "h(el)lo", "$1-${1}", true, false, 0)
+	f.Add("a.b.c", ".", "-", true, true, 0)
+	f.Add("aaaa", "a", "b", false, false, 3)
+	f.Add("x", "", "y", true, false, 0)
+	f.Add("x", "(", "y", true, false, 0)
+	f.Add("http://old.example.com/", "old.example.com", "{{host}}", true, true, 0)
+	f.Add("x", "x", "{{unknown}}", false, false, 0)
+
+	f.Fuzz(func(t *testing.T, content, pattern, replacement string, global, literal bool, max int) {
+		rules := []ReplaceRule{{
+			Pattern:         pattern,
+			Replacement:     replacement,
+			Global:          global,
+			Literal:         literal,
+			MaxReplacements: max,
+		}}
+
+		result, err := ApplyReplaceRules([]byte(content), rules)
+		if err != nil {
+			if string(result) != content {
+				t.Fatalf("ApplyReplaceRules changed the content on error %v", err)
+			}
+			if _, compileErr := CompileReplaceRules(rules); compileErr == nil {
+				t.Fatalf("ApplyReplaceRules failed with %v but the rules compile", err)
+			}
+			return
+		}
+
+		// literal规则与strings.Replace的结果一致
+		if literal && !strings.Contains(replacement, "{{") && utf8.ValidString(content) {
+			n := 1
+			if max > 0 {
+				n = max
+			} else if global {
+				n = -1
+			}
+			if want := strings.Replace(content, pattern, replacement, n); string(result) != want {
+				t.Fatalf("literal %q -> %q in %q = %q, want %q", pattern, replacement, content, result, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// respondedKey 中间件已直接返回响应的标记在上下文中的键
+const respondedKey = "responded"
+
+// Respond 由中间件直接返回完整响应（认证失败、缓存命中、模拟数据等），并标记请求已处理
+// 之后中间件链停止执行，代理不再写入响应或转发到上游；返回false，便于在Handle中直接 return ctx.Respond(...)
+func (c *Context) Respond(status int, header http.Header, body []byte) bool {
+	if c.Responded() {
+		log.Printf("Middleware response ignored, request already answered: %d", status)
+		return false
+	}
+	c.Set(respondedKey, true)
+	c.StatusCode = status
+
+	h := c.Response.Header()
+	for key, values := range header {
+		h[key] = append([]string(nil), values...)
+	}
+	if body != nil && h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(body))
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+
+	c.Response.WriteHeader(status)
+	if c.Request == nil || c.Request.Method != http.MethodHead {
+		c.Response.Write(body)
+	}
+	return false
+}
+
+// RespondJSON 以JSON格式直接返回响应
+func (c *Context) RespondJSON(status int, data interface{}) bool {
+	body, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode middleware response: %v", err)
+		return c.Respond(http.StatusInternalServerError, nil, nil)
+	}
+	return c.Respond(status, http.Header{"Content-Type": {"application/json"}}, body)
+}
+
+// RespondText 以纯文本直接返回响应
+func (c *Context) RespondText(status int, text string) bool {
+	return c.Respond(status, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, []byte(text))
+}
+
+// Responded 检查中间件是否已直接返回响应
+func (c *Context) Responded() bool {
+	responded, _ := c.Values[respondedKey].(bool)
+	return responded
+}
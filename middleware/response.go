@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ResponseHandler 可选的响应阶段接口，中间件实现该接口时可以在上游响应转发给客户端之前检查或修改响应
+// （响应头、状态码、响应体），不需要包装ResponseWriter。
+//
+// 只有执行完Handle并继续了链的中间件才会被调用，按链中相反的顺序执行（最后执行Handle的中间件最先处理响应）；
+// 请求被中间件中断或直接返回响应、转发失败、缓存命中以及WebSocket升级时不会调用。
+// 调用发生在配置的响应头之后、图片处理、数据遮盖、缓存和替换规则之前，缓存中保存处理后的响应。
+// 读取或替换resp.Body时需要同时更新resp.ContentLength和Content-Length响应头
+type ResponseHandler interface {
+	// HandleResponse 处理上游响应，返回错误时不再调用其余中间件，客户端收到502
+	HandleResponse(ctx *Context, resp *http.Response) error
+}
+
+// responseHandlersKey 响应阶段处理在上下文中的键
+const responseHandlersKey = "response_handlers"
+
+// ResponseHandlerError 中间件处理上游响应失败
+type ResponseHandlerError struct {
+	Middleware string
+	Err        error
+}
+
+func (e *ResponseHandlerError) Error() string {
+	return fmt.Sprintf("middleware '%s' failed to handle response: %v", e.Middleware, e.Err)
+}
+
+func (e *ResponseHandlerError) Unwrap() error {
+	return e.Err
+}
+
+// addResponseHandler 登记实现了ResponseHandler的中间件，由中间件链在中间件继续链后调用
+func (c *Context) addResponseHandler(mw Middleware) {
+	if _, ok := mw.(ResponseHandler); !ok {
+		return
+	}
+	handlers, _ := c.Values[responseHandlersKey].([]Middleware)
+	c.Set(responseHandlersKey, append(handlers, mw))
+}
+
+// HandleResponse 按登记的相反顺序调用中间件的响应阶段处理，由代理在收到上游响应时调用
+func (c *Context) HandleResponse(resp *http.Response) error {
+	handlers, _ := c.Values[responseHandlersKey].([]Middleware)
+	for i := len(handlers) - 1; i >= 0; i-- {
+		if err := handlers[i].(ResponseHandler).HandleResponse(c, resp); err != nil {
+			return &ResponseHandlerError{Middleware: handlers[i].Name(), Err: err}
+		}
+	}
+	return nil
+}
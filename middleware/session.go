@@ -0,0 +1,16 @@
+package middleware
+
+import "toyou-proxy/session"
+
+// SessionKey 会话在上下文中的键
+const SessionKey = "session"
+
+// Session 获取会话中间件加载的会话，链中没有会话中间件时返回nil
+func (c *Context) Session() *session.Session {
+	value, exists := c.Get(SessionKey)
+	if !exists {
+		return nil
+	}
+	s, _ := value.(*session.Session)
+	return s
+}
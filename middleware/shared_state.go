@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 中间件实例在重新加载配置或密钥变化后重新创建，连接池、缓存等状态需要在相同配置的实例之间共享
+var (
+	sharedStates   = make(map[string]interface{})
+	sharedStatesMu sync.Mutex
+)
+
+// resetGeneration 共享状态被丢弃的次数，见SharedStateGeneration
+var resetGeneration atomic.Uint64
+
+// SharedStateGeneration 返回共享状态的版本，插件重新加载后增加，
+// 代理按配置复用的中间件实例在版本变化后使用新的插件代码重新创建
+func SharedStateGeneration() uint64 {
+	return resetGeneration.Load()
+}
+
+// SharedState 获取中间件的共享状态，相同名称和配置只创建一次
+// 状态实现了Lifecycle或HealthChecker时登记到生命周期管理器
+func SharedState(name string, config map[string]interface{}, create func() (interface{}, error)) (interface{}, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s config: %v", name, err)
+	}
+	key := name + "|" + string(data)
+
+	sharedStatesMu.Lock()
+	defer sharedStatesMu.Unlock()
+
+	if state, exists := sharedStates[key]; exists {
+		return state, nil
+	}
+
+	state, err := create()
+	if err != nil {
+		return nil, err
+	}
+	sharedStates[key] = state
+	GetLifecycleManager().Register(name, key, state)
+	return state, nil
+}
+
+// ResetSharedState 停止并丢弃指定中间件的所有共享状态，之后的请求按新的代码和配置重新创建
+// 插件重新加载时调用
+func ResetSharedState(name string) {
+	sharedStatesMu.Lock()
+	for key := range sharedStates {
+		if strings.HasPrefix(key, name+"|") {
+			delete(sharedStates, key)
+		}
+	}
+	sharedStatesMu.Unlock()
+	resetGeneration.Add(1)
+
+	GetLifecycleManager().Remove(name)
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"toyou-proxy/metrics"
+)
+
+// Logger 请求级日志记录器，每条日志带有请求ID、匹配规则和目标服务
+type Logger struct {
+	ctx *Context
+}
+
+// Logger 返回当前请求的日志记录器，插件应使用它代替fmt.Printf
+func (c *Context) Logger() *Logger {
+	return &Logger{ctx: c}
+}
+
+// Printf 按格式记录日志
+func (l *Logger) Printf(format string, v ...interface{}) {
+	log.Print(l.prefix() + fmt.Sprintf(format, v...))
+}
+
+// Println 记录日志
+func (l *Logger) Println(v ...interface{}) {
+	log.Print(l.prefix() + strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+// prefix 日志前缀，如 [request_id=3f2a route=api.example.com service=api team=payments]
+func (l *Logger) prefix() string {
+	var tags []string
+	if l.ctx.RequestID != "" {
+		tags = append(tags, "request_id="+l.ctx.RequestID)
+	}
+	if l.ctx.Route != "" {
+		tags = append(tags, "route="+l.ctx.Route)
+	}
+	if l.ctx.ServiceName != "" {
+		tags = append(tags, "service="+l.ctx.ServiceName)
+	}
+	tags = append(tags, l.ctx.labelTags()...)
+	if len(tags) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(tags, " ") + "] "
+}
+
+// labelTags 按名称排序的规则标签，如 [team=payments tier=gold]
+func (c *Context) labelTags() []string {
+	if len(c.Labels) == 0 {
+		return nil
+	}
+	tags := make([]string, 0, len(c.Labels))
+	for name, value := range c.Labels {
+		tags = append(tags, name+"="+value)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// LabelString 格式化规则标签，用于访问日志等，如 "team=payments tier=gold"，没有标签时返回空字符串
+func (c *Context) LabelString() string {
+	return strings.Join(c.labelTags(), " ")
+}
+
+// 请求级指标自动附加的标签
+// 规则标签不直接作为指标标签（各规则的标签名不同），通过 toyou_proxy_route_labels 按route关联
+var requestLabelNames = []string{"route", "service"}
+
+// RequestMetrics 请求级指标，注册到全局指标注册表，由管理API的 /metrics 输出
+type RequestMetrics struct {
+	ctx *Context
+}
+
+// Metrics 返回当前请求的指标辅助对象
+func (c *Context) Metrics() *RequestMetrics {
+	return &RequestMetrics{ctx: c}
+}
+
+// Counter 获取计数器，同名计数器只注册一次；除labelNames外自动带有route和service标签
+// 名称已被其他类型的指标使用时记录日志，返回的计数器不做任何操作
+func (m *RequestMetrics) Counter(name, help string, labelNames ...string) (counter *RequestCounter) {
+	counter = &RequestCounter{ctx: m.ctx}
+	defer recoverMetric(m.ctx, "registration")
+	counter.vec = metrics.GetDefaultRegistry().NewCounterVec(name, help, append(append([]string(nil), requestLabelNames...), labelNames...)...)
+	return counter
+}
+
+// Gauge 获取仪表盘指标，同名指标只注册一次；除labelNames外自动带有route和service标签
+// 名称已被其他类型的指标使用时记录日志，返回的指标不做任何操作
+func (m *RequestMetrics) Gauge(name, help string, labelNames ...string) (gauge *RequestGauge) {
+	gauge = &RequestGauge{ctx: m.ctx}
+	defer recoverMetric(m.ctx, "registration")
+	gauge.vec = metrics.GetDefaultRegistry().NewGaugeVec(name, help, append(append([]string(nil), requestLabelNames...), labelNames...)...)
+	return gauge
+}
+
+// labelValues 在插件提供的标签值前加上route和service
+func labelValues(ctx *Context, values []string) []string {
+	return append([]string{ctx.Route, ctx.ServiceName}, values...)
+}
+
+// recoverMetric 名称冲突、标签个数不一致等错误只记录日志，不影响请求处理
+func recoverMetric(ctx *Context, action string) {
+	if r := recover(); r != nil {
+		ctx.Logger().Printf("Metric %s failed: %v", action, r)
+	}
+}
+
+// RequestCounter 带请求标签的计数器
+type RequestCounter struct {
+	vec *metrics.CounterVec // 注册失败时为nil
+	ctx *Context
+}
+
+// Inc 计数加1
+func (c *RequestCounter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add 计数增加delta
+func (c *RequestCounter) Add(delta float64, values ...string) {
+	if c.vec == nil {
+		return
+	}
+	defer recoverMetric(c.ctx, "update")
+	c.vec.Add(delta, labelValues(c.ctx, values)...)
+}
+
+// RequestGauge 带请求标签的仪表盘指标
+type RequestGauge struct {
+	vec *metrics.GaugeVec // 注册失败时为nil
+	ctx *Context
+}
+
+// Set 设置指标值
+func (g *RequestGauge) Set(value float64, values ...string) {
+	if g.vec == nil {
+		return
+	}
+	defer recoverMetric(g.ctx, "update")
+	g.vec.Set(value, labelValues(g.ctx, values)...)
+}
+
+// Add 指标值增加delta，delta可以为负数
+func (g *RequestGauge) Add(delta float64, values ...string) {
+	if g.vec == nil {
+		return
+	}
+	defer recoverMetric(g.ctx, "update")
+	g.vec.Add(delta, labelValues(g.ctx, values)...)
+}
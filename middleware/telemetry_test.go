@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"toyou-proxy/metrics"
+)
+
+func TestRequestMetricsNameConflicts(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	registry := metrics.GetDefaultRegistry()
+	registry.NewInfoVec("test_telemetry_info", "Info metric registered by the proxy.")
+	registry.NewCounterVec("test_telemetry_counter", "Counter registered by the proxy.")
+	ctx := &Context{RequestID: "r1", Route: "example.com", ServiceName: "web"}
+
+	// 名称已被其他类型的指标使用时不影响请求，只记录日志
+	ctx.Metrics().Gauge("test_telemetry_info", "Gauge reusing an info metric name.").Set(1)
+	ctx.Metrics().Gauge("test_telemetry_counter", "Gauge reusing a counter name.").Add(1)
+	ctx.Metrics().Counter("test_telemetry_info", "Counter reusing an info metric name.").Inc()
+	if n := strings.Count(logs.String(), "Metric registration failed"); n != 3 {
+		t.Errorf("logged %d registration failures, want 3:\n%s", n, logs.String())
+	}
+
+	// 标签个数不一致同样只记录日志
+	ctx.Metrics().Counter("test_telemetry_ok", "Counter registered by a plugin.", "result").Inc("hit", "extra")
+	if !strings.Contains(logs.String(), "Metric update failed") {
+		t.Errorf("label mismatch was not logged:\n%s", logs.String())
+	}
+
+	ctx.Metrics().Counter("test_telemetry_ok", "Counter registered by a plugin.", "result").Inc("hit")
+	var out bytes.Buffer
+	registry.WritePrometheus(&out)
+	if want := `test_telemetry_ok{route="example.com",service="web",result="hit"} 1`; !strings.Contains(out.String(), want) {
+		t.Errorf("metrics output does not contain %s:\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"sort"
+	"sync"
+)
+
+// 中间件种类
+const (
+	KindMiddleware = "middleware" // middlewares 中配置的中间件
+	KindService    = "service"    // middleware_services 中定义的中间件服务
+)
+
+// MiddlewareStatus 中间件的启用状态
+type MiddlewareStatus struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Configured bool   `json:"configured_enabled"` // 配置文件中的enabled
+	Enabled    bool   `json:"enabled"`            // 当前生效的状态
+	Overridden bool   `json:"overridden"`         // 是否被运行时覆盖
+}
+
+// MiddlewareToggles 中间件启用状态的运行时覆盖
+// 中间件链在每个请求时按启用状态解析，覆盖在下一个请求生效，不需要重新加载配置
+type MiddlewareToggles struct {
+	configured map[string]MiddlewareStatus
+	overrides  map[string]bool
+	mu         sync.RWMutex
+}
+
+// 全局中间件启用状态实例
+var globalMiddlewareToggles = &MiddlewareToggles{
+	configured: make(map[string]MiddlewareStatus),
+	overrides:  make(map[string]bool),
+}
+
+// GetMiddlewareToggles 获取全局中间件启用状态
+func GetMiddlewareToggles() *MiddlewareToggles {
+	return globalMiddlewareToggles
+}
+
+// LoadConfigured 载入配置文件中的中间件和中间件服务，保留已有的运行时覆盖
+func (t *MiddlewareToggles) LoadConfigured(middlewares map[string]bool, services map[string]bool) {
+	configured := make(map[string]MiddlewareStatus, len(middlewares)+len(services))
+	for name, enabled := range services {
+		configured[name] = MiddlewareStatus{Name: name, Kind: KindService, Configured: enabled}
+	}
+	for name, enabled := range middlewares {
+		configured[name] = MiddlewareStatus{Name: name, Kind: KindMiddleware, Configured: enabled}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.configured = configured
+}
+
+// Set 覆盖中间件的启用状态，中间件不在配置中时返回false
+func (t *MiddlewareToggles) Set(name string, enabled bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.configured[name]; !exists {
+		return false
+	}
+	t.overrides[name] = enabled
+	return true
+}
+
+// Reset 清除运行时覆盖，恢复配置文件中的状态，返回是否存在覆盖
+func (t *MiddlewareToggles) Reset(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, exists := t.overrides[name]
+	delete(t.overrides, name)
+	return exists
+}
+
+// Enabled 返回中间件当前是否启用，configured为配置文件中的状态
+func (t *MiddlewareToggles) Enabled(name string, configured bool) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if enabled, exists := t.overrides[name]; exists {
+		return enabled
+	}
+	return configured
+}
+
+// Get 获取单个中间件的状态
+func (t *MiddlewareToggles) Get(name string) (MiddlewareStatus, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	status, exists := t.configured[name]
+	if !exists {
+		return status, false
+	}
+	return t.resolve(status), true
+}
+
+// List 列出所有中间件的状态，按名称排序
+func (t *MiddlewareToggles) List() []MiddlewareStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	list := make([]MiddlewareStatus, 0, len(t.configured))
+	for _, status := range t.configured {
+		list = append(list, t.resolve(status))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// resolve 计算生效状态，调用方持有锁
+func (t *MiddlewareToggles) resolve(status MiddlewareStatus) MiddlewareStatus {
+	status.Enabled = status.Configured
+	if enabled, exists := t.overrides[status.Name]; exists {
+		status.Enabled = enabled
+		status.Overridden = true
+	}
+	return status
+}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+)
+
+// 中间件链追踪使用的请求头和响应头
+const (
+	DebugChainHeader = "X-Debug-Chain"
+	ChainTraceHeader = "X-Middleware-Chain"
+)
+
+// traceKey 当前请求的追踪记录在上下文中的键
+const traceKey = "chain_trace"
+
+// defaultTraceBufferSize 默认保留的追踪记录数
+const defaultTraceBufferSize = 100
+
+// 中间件执行结果
+const (
+	ResultContinue  = "continue"  // 继续执行下一个中间件
+	ResultAbort     = "abort"     // 中断请求
+	ResultResponded = "responded" // 直接返回了响应
+)
+
+// 每个中间件在每个规则上的执行次数和累计耗时，平均耗时为两者之比
+var (
+	middlewareExecutions = metrics.GetDefaultRegistry().NewCounterVec(
+		"toyou_proxy_middleware_executions_total",
+		"Middleware executions by middleware, route and result.",
+		"middleware", "route", "result",
+	)
+	middlewareDuration = metrics.GetDefaultRegistry().NewCounterVec(
+		"toyou_proxy_middleware_duration_seconds_total",
+		"Total time spent in middleware Handle by middleware and route.",
+		"middleware", "route",
+	)
+)
+
+// TraceStep 中间件链中的一步
+type TraceStep struct {
+	Middleware string        `json:"middleware"`
+	Duration   time.Duration `json:"duration_ns"`
+	Result     string        `json:"result"`
+}
+
+// ChainTrace 一个请求的中间件链追踪记录
+type ChainTrace struct {
+	RequestID string            `json:"request_id,omitempty"`
+	Route     string            `json:"route,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Time      time.Time         `json:"time"`
+	Debug     bool              `json:"debug"` // 由调试请求头触发
+	Steps     []TraceStep       `json:"steps"`
+}
+
+// Header 格式化为响应头的值，如 auth;dur=0.412ms;result=continue, cache;dur=0.051ms;result=responded
+func (t *ChainTrace) Header() string {
+	parts := make([]string, len(t.Steps))
+	for i, step := range t.Steps {
+		parts[i] = fmt.Sprintf("%s;dur=%.3fms;result=%s", step.Middleware, float64(step.Duration)/float64(time.Millisecond), step.Result)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ChainTracer 中间件链追踪器，按比例采样或按调试请求头追踪，保留最近的追踪记录
+type ChainTracer struct {
+	sampleRate float64
+	token      string
+	traces     []*ChainTrace
+	next       int
+	mu         sync.Mutex
+}
+
+// 全局中间件链追踪器实例
+var globalChainTracer = NewChainTracer(config.ChainTraceConfig{})
+
+// NewChainTracer 创建中间件链追踪器
+func NewChainTracer(cfg config.ChainTraceConfig) *ChainTracer {
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = defaultTraceBufferSize
+	}
+	return &ChainTracer{
+		sampleRate: cfg.SampleRate,
+		token:      cfg.Token,
+		traces:     make([]*ChainTrace, 0, size),
+	}
+}
+
+// ConfigureChainTrace 根据配置替换全局中间件链追踪器
+func ConfigureChainTrace(cfg config.ChainTraceConfig) {
+	globalChainTracer = NewChainTracer(cfg)
+}
+
+// GetChainTracer 获取全局中间件链追踪器
+func GetChainTracer() *ChainTracer {
+	return globalChainTracer
+}
+
+// Begin 决定是否追踪当前请求，追踪时在上下文中附加追踪记录
+// 调试请求头总是从转发给上游的请求中删除
+func (t *ChainTracer) Begin(ctx *Context) {
+	r := ctx.Request
+	debugToken := r.Header.Get(DebugChainHeader)
+	r.Header.Del(DebugChainHeader)
+
+	debug := t.token != "" && debugToken != "" && subtle.ConstantTimeCompare([]byte(debugToken), []byte(t.token)) == 1
+	if !debug && (t.sampleRate <= 0 || rand.Float64() >= t.sampleRate) {
+		return
+	}
+
+	ctx.Set(traceKey, &ChainTrace{
+		RequestID: ctx.RequestID,
+		Route:     ctx.Route,
+		Labels:    ctx.Labels,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Time:      time.Now(),
+		Debug:     debug,
+	})
+}
+
+// Finish 保存追踪记录，调试请求在响应尚未写出时返回中间件链响应头
+func (t *ChainTracer) Finish(ctx *Context) {
+	trace := ctx.Trace()
+	if trace == nil {
+		return
+	}
+	if trace.Debug && !ctx.Responded() && ctx.StatusCode == 0 {
+		ctx.Response.Header().Set(ChainTraceHeader, trace.Header())
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.traces) < cap(t.traces) {
+		t.traces = append(t.traces, trace)
+		return
+	}
+	t.traces[t.next] = trace
+	t.next = (t.next + 1) % len(t.traces)
+}
+
+// Recent 返回最近的追踪记录，最新的在前
+func (t *ChainTracer) Recent() []*ChainTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.traces)
+	recent := make([]*ChainTrace, 0, n)
+	for i := 0; i < n; i++ {
+		recent = append(recent, t.traces[(t.next-1-i+2*n)%n])
+	}
+	return recent
+}
+
+// Trace 获取当前请求的追踪记录，未追踪时返回nil
+func (c *Context) Trace() *ChainTrace {
+	trace, _ := c.Values[traceKey].(*ChainTrace)
+	return trace
+}
+
+// recordStep 记录中间件的执行结果和耗时
+func recordStep(ctx *Context, name string, duration time.Duration, result string) {
+	middlewareExecutions.Inc(name, ctx.Route, result)
+	middlewareDuration.Add(duration.Seconds(), name, ctx.Route)
+
+	if trace := ctx.Trace(); trace != nil {
+		trace.Steps = append(trace.Steps, TraceStep{Middleware: name, Duration: duration, Result: result})
+	}
+}
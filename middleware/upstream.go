@@ -0,0 +1,30 @@
+package middleware
+
+import "time"
+
+// upstreamKey 上游响应结果在上下文中的键
+const upstreamKey = "upstream_result"
+
+// UpstreamResult 上游服务的响应结果，由代理在收到响应头或转发失败时记录
+type UpstreamResult struct {
+	Status  int           // 上游响应状态码，转发失败时为代理返回的状态码
+	Latency time.Duration // 从发出请求到收到响应头的耗时
+	Err     error         // 转发失败的原因，成功时为nil
+}
+
+// Failed 判断上游是否出错（转发失败或返回5xx）
+func (u UpstreamResult) Failed() bool {
+	return u.Err != nil || u.Status >= 500
+}
+
+// SetUpstreamResult 记录上游响应结果，由代理调用
+func (c *Context) SetUpstreamResult(result UpstreamResult) {
+	c.Set(upstreamKey, result)
+}
+
+// UpstreamResult 获取上游响应结果，请求未转发到上游（如被中间件拒绝、客户端取消）时返回false
+// 在 OnComplete 回调中调用，可用于统计后端的延迟和错误率
+func (c *Context) UpstreamResult() (UpstreamResult, bool) {
+	result, exists := c.Values[upstreamKey].(UpstreamResult)
+	return result, exists
+}
@@ -0,0 +1,127 @@
+package minify
+
+import "bytes"
+
+// CSS处理状态
+const (
+	cssNormal = iota
+	cssSlash
+	cssComment
+	cssCommentStar
+	cssString
+)
+
+// cssProcessor 去除CSS注释和多余空白，保留 /*! */ 版权注释和字符串内容
+type cssProcessor struct {
+	out          *bytes.Buffer
+	state        int
+	quote        byte
+	escaped      bool
+	keepComment  bool
+	commentStart bool
+	pendingSpace bool
+	pendingSemi  bool
+	last         byte
+}
+
+// write 处理一个字节
+func (p *cssProcessor) write(c byte) {
+	switch p.state {
+	case cssSlash:
+		if c == '*' {
+			p.state = cssComment
+			p.commentStart = true
+			return
+		}
+		p.state = cssNormal
+		p.emit('/')
+		p.write(c)
+	case cssComment, cssCommentStar:
+		if p.commentStart {
+			p.commentStart = false
+			if c == '!' {
+				p.keepComment = true
+				p.emit('/')
+				p.out.WriteString("*")
+			}
+		}
+		if p.keepComment {
+			p.out.WriteByte(c)
+		}
+		if p.state == cssCommentStar && c == '/' {
+			p.state = cssNormal
+			if p.keepComment {
+				p.keepComment = false
+				p.last = '/'
+			} else {
+				// 注释视为空白，避免前后内容相连
+				p.pendingSpace = true
+			}
+			return
+		}
+		if c == '*' {
+			p.state = cssCommentStar
+		} else {
+			p.state = cssComment
+		}
+	case cssString:
+		p.out.WriteByte(c)
+		if p.escaped {
+			p.escaped = false
+		} else if c == '\\' {
+			p.escaped = true
+		} else if c == p.quote || c == '\n' {
+			p.state = cssNormal
+		}
+		p.last = c
+	default:
+		switch {
+		case isSpace(c):
+			p.pendingSpace = true
+		case c == '/':
+			p.state = cssSlash
+		case c == ';':
+			// 分号延迟输出，紧跟 } 时省略
+			if p.pendingSemi {
+				p.emit(';')
+			}
+			p.pendingSemi = true
+			p.pendingSpace = false
+		case c == '"' || c == '\'':
+			p.emit(c)
+			p.state = cssString
+			p.quote = c
+		default:
+			p.emit(c)
+		}
+	}
+}
+
+// emit 输出一个非空白字符，按需补上之前省略的空格和分号
+func (p *cssProcessor) emit(c byte) {
+	if p.pendingSemi {
+		p.pendingSemi = false
+		if c != '}' {
+			p.out.WriteByte(';')
+			p.last = ';'
+		}
+	}
+	if p.pendingSpace {
+		p.pendingSpace = false
+		if p.last != 0 && !bytes.ContainsRune([]byte("{};,>:("), rune(p.last)) && !bytes.ContainsRune([]byte("{};,>)!"), rune(c)) {
+			p.out.WriteByte(' ')
+		}
+	}
+	p.out.WriteByte(c)
+	p.last = c
+}
+
+// flush 输出剩余内容
+func (p *cssProcessor) flush() {
+	if p.state == cssSlash {
+		p.emit('/')
+	}
+	if p.pendingSemi {
+		p.out.WriteByte(';')
+	}
+}
@@ -0,0 +1,225 @@
+package minify
+
+import (
+	"bytes"
+	"strings"
+)
+
+// HTML处理状态
+const (
+	htmlText = iota
+	htmlLt
+	htmlLtBang
+	htmlLtBangDash
+	htmlCommentStart
+	htmlComment
+	htmlTag
+	htmlTagQuote
+	htmlRaw
+)
+
+// rawElements 内容原样输出的元素
+var rawElements = map[string]bool{
+	"pre": true, "textarea": true, "script": true, "style": true,
+}
+
+// htmlProcessor 去除HTML注释（保留条件注释）并合并文本和标签中的连续空白
+// pre、textarea、script、style元素的内容原样输出
+type htmlProcessor struct {
+	out          *bytes.Buffer
+	state        int
+	pendingSpace byte // 待输出的空白，换行优先
+	tagName      []byte
+	nameDone     bool
+	closing      bool
+	quote        byte
+	keepComment  bool
+	dashes       int
+	rawEnd       string // 原样输出区域的结束标签，如 </script
+	rawMatched   int
+}
+
+// write 处理一个字节
+func (p *htmlProcessor) write(c byte) {
+	switch p.state {
+	case htmlLt:
+		switch {
+		case c == '!':
+			p.state = htmlLtBang
+		case c == '/' || isLetter(c):
+			p.flushSpace()
+			p.startTag()
+			p.out.WriteByte('<')
+			p.state = htmlTag
+			p.closing = c == '/'
+			p.writeTag(c)
+		default:
+			p.flushSpace()
+			p.state = htmlText
+			p.out.WriteByte('<')
+			p.write(c)
+		}
+	case htmlLtBang:
+		if c == '-' {
+			p.state = htmlLtBangDash
+			return
+		}
+		// <!DOCTYPE> 等声明按标签处理
+		p.flushSpace()
+		p.startTag()
+		p.out.WriteString("<!")
+		p.state = htmlTag
+		p.nameDone = true
+		p.writeTag(c)
+	case htmlLtBangDash:
+		if c == '-' {
+			p.state = htmlCommentStart
+			return
+		}
+		p.flushSpace()
+		p.startTag()
+		p.out.WriteString("<!-")
+		p.state = htmlTag
+		p.nameDone = true
+		p.writeTag(c)
+	case htmlCommentStart:
+		// 条件注释 <!--[if IE]> 保留
+		p.keepComment = c == '['
+		if p.keepComment {
+			p.flushSpace()
+			p.out.WriteString("<!--")
+		}
+		p.dashes = 0
+		p.state = htmlComment
+		p.write(c)
+	case htmlComment:
+		if p.keepComment {
+			p.out.WriteByte(c)
+		}
+		if c == '>' && p.dashes >= 2 {
+			p.state = htmlText
+			return
+		}
+		if c == '-' {
+			p.dashes++
+		} else {
+			p.dashes = 0
+		}
+	case htmlTag:
+		p.writeTag(c)
+	case htmlTagQuote:
+		p.out.WriteByte(c)
+		if c == p.quote {
+			p.state = htmlTag
+		}
+	case htmlRaw:
+		p.out.WriteByte(c)
+		if lower(c) == p.rawEnd[p.rawMatched] {
+			p.rawMatched++
+			if p.rawMatched == len(p.rawEnd) {
+				// 结束标签的剩余部分按标签处理
+				p.startTag()
+				p.tagName = append(p.tagName, p.rawEnd[2:]...)
+				p.closing = true
+				p.state = htmlTag
+			}
+		} else if c == '<' {
+			p.rawMatched = 1
+		} else {
+			p.rawMatched = 0
+		}
+	default:
+		switch {
+		case c == '<':
+			// 注释被去除时前后的空白合并
+			p.state = htmlLt
+		case isSpace(c):
+			if p.pendingSpace != '\n' {
+				p.pendingSpace = ' '
+				if c == '\n' {
+					p.pendingSpace = '\n'
+				}
+			}
+		default:
+			p.flushSpace()
+			p.out.WriteByte(c)
+		}
+	}
+}
+
+// writeTag 处理标签中的字节
+func (p *htmlProcessor) writeTag(c byte) {
+	switch {
+	case isSpace(c):
+		p.nameDone = true
+		p.pendingSpace = ' '
+	case c == '>':
+		p.pendingSpace = 0
+		p.out.WriteByte(c)
+		p.state = htmlText
+		name := strings.ToLower(string(p.tagName))
+		if !p.closing && rawElements[name] {
+			p.state = htmlRaw
+			p.rawEnd = "</" + name
+			p.rawMatched = 0
+		}
+	case c == '"' || c == '\'':
+		p.flushSpace()
+		p.out.WriteByte(c)
+		p.quote = c
+		p.state = htmlTagQuote
+	default:
+		p.flushSpace()
+		if !p.nameDone {
+			if c == '/' && len(p.tagName) == 0 {
+				p.closing = true
+			} else if c == '/' {
+				p.nameDone = true
+			} else {
+				p.tagName = append(p.tagName, c)
+			}
+		}
+		p.out.WriteByte(c)
+	}
+}
+
+// startTag 开始新的标签
+func (p *htmlProcessor) startTag() {
+	p.tagName = p.tagName[:0]
+	p.nameDone = false
+	p.closing = false
+}
+
+// flushSpace 输出待输出的空白
+func (p *htmlProcessor) flushSpace() {
+	if p.pendingSpace != 0 {
+		p.out.WriteByte(p.pendingSpace)
+		p.pendingSpace = 0
+	}
+}
+
+// flush 输出剩余内容
+func (p *htmlProcessor) flush() {
+	switch p.state {
+	case htmlLt:
+		p.out.WriteByte('<')
+	case htmlLtBang:
+		p.out.WriteString("<!")
+	case htmlLtBangDash:
+		p.out.WriteString("<!-")
+	}
+	p.flushSpace()
+}
+
+// isLetter 是否是ASCII字母
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// lower 转为小写ASCII字母
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,262 @@
+package minify
+
+import "bytes"
+
+// JavaScript处理状态
+const (
+	jsNormal = iota
+	jsSlash
+	jsLineComment
+	jsBlockComment
+	jsBlockCommentStar
+	jsString
+	jsTemplate
+	jsRegexp
+)
+
+// regexpKeywords 之后出现的 / 是正则表达式开头的关键字
+var regexpKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true,
+	"new": true, "delete": true, "void": true, "throw": true, "case": true,
+	"do": true, "else": true, "yield": true, "await": true,
+}
+
+// jsProcessor 去除JavaScript注释和多余空白
+// 换行保留以免改变自动分号插入的语义，只合并连续的空行和缩进；
+// 字符串、模板字符串和正则表达式原样输出，无法确定 / 是除号还是正则时按正则处理
+type jsProcessor struct {
+	out            *bytes.Buffer
+	state          int
+	quote          byte
+	escaped        bool
+	inClass        bool
+	keepComment    bool
+	commentStart   bool
+	commentNewline bool
+	pendingSpace   bool
+	pendingNewline bool
+	last           byte
+	word           []byte
+	lastWord       string
+	templateDepth  []int // 模板字符串 ${} 嵌套时外层的括号深度
+	braceDepth     int
+}
+
+// write 处理一个字节
+func (p *jsProcessor) write(c byte) {
+	switch p.state {
+	case jsSlash:
+		switch c {
+		case '/':
+			p.state = jsLineComment
+		case '*':
+			p.state = jsBlockComment
+			p.commentStart = true
+			p.commentNewline = false
+		default:
+			regexp := p.regexpAllowed()
+			p.emit('/')
+			if regexp {
+				p.state = jsRegexp
+				p.inClass = false
+				p.escaped = false
+				p.writeRegexp(c)
+				return
+			}
+			p.state = jsNormal
+			p.write(c)
+		}
+	case jsLineComment:
+		if c == '\n' {
+			p.state = jsNormal
+			p.pendingNewline = true
+		}
+	case jsBlockComment, jsBlockCommentStar:
+		if p.commentStart {
+			p.commentStart = false
+			if c == '!' {
+				p.keepComment = true
+				p.emit('/')
+				p.out.WriteString("*")
+			}
+		}
+		if p.keepComment {
+			p.out.WriteByte(c)
+		}
+		if c == '\n' {
+			p.commentNewline = true
+		}
+		if p.state == jsBlockCommentStar && c == '/' {
+			p.state = jsNormal
+			if p.keepComment {
+				p.keepComment = false
+				p.last = '/'
+				p.pendingNewline = true
+			} else if p.commentNewline {
+				p.pendingNewline = true
+			} else {
+				p.pendingSpace = true
+			}
+			return
+		}
+		if c == '*' {
+			p.state = jsBlockCommentStar
+		} else {
+			p.state = jsBlockComment
+		}
+	case jsString:
+		p.out.WriteByte(c)
+		if p.escaped {
+			p.escaped = false
+		} else if c == '\\' {
+			p.escaped = true
+		} else if c == p.quote || c == '\n' {
+			p.state = jsNormal
+			p.last = p.quote
+		}
+	case jsTemplate:
+		p.out.WriteByte(c)
+		if p.escaped {
+			// 转义的 $ 不能开始表达式
+			p.escaped = false
+			p.last = 0
+			return
+		} else if c == '\\' {
+			p.escaped = true
+		} else if c == '`' {
+			p.state = jsNormal
+			p.last = '`'
+		} else if c == '{' && p.last == '$' {
+			// 进入 ${} 表达式
+			p.templateDepth = append(p.templateDepth, p.braceDepth)
+			p.braceDepth++
+			p.state = jsNormal
+			p.last = '{'
+			return
+		}
+		p.last = c
+	case jsRegexp:
+		p.writeRegexp(c)
+	default:
+		p.writeNormal(c)
+	}
+}
+
+// writeNormal 处理代码中的字节
+func (p *jsProcessor) writeNormal(c byte) {
+	switch {
+	case c == '\n' || c == '\r':
+		p.endWord()
+		p.pendingNewline = true
+	case isSpace(c):
+		p.endWord()
+		p.pendingSpace = true
+	case c == '/':
+		p.endWord()
+		p.state = jsSlash
+	case c == '"' || c == '\'':
+		p.endWord()
+		p.emit(c)
+		p.state = jsString
+		p.quote = c
+		p.escaped = false
+	case c == '`':
+		p.endWord()
+		p.emit(c)
+		p.state = jsTemplate
+		p.escaped = false
+	default:
+		if isWordChar(c) {
+			p.word = append(p.word, c)
+		} else {
+			p.endWord()
+		}
+		switch c {
+		case '{':
+			p.braceDepth++
+		case '}':
+			p.braceDepth--
+			if n := len(p.templateDepth); n > 0 && p.templateDepth[n-1] == p.braceDepth {
+				// ${} 表达式结束，回到模板字符串
+				p.templateDepth = p.templateDepth[:n-1]
+				p.emit(c)
+				p.state = jsTemplate
+				p.escaped = false
+				return
+			}
+		}
+		p.emit(c)
+	}
+}
+
+// writeRegexp 处理正则表达式中的字节
+func (p *jsProcessor) writeRegexp(c byte) {
+	p.out.WriteByte(c)
+	p.last = c
+	switch {
+	case p.escaped:
+		p.escaped = false
+	case c == '\\':
+		p.escaped = true
+	case c == '[':
+		p.inClass = true
+	case c == ']':
+		p.inClass = false
+	case c == '/' && !p.inClass, c == '\n':
+		p.state = jsNormal
+		p.lastWord = ""
+	}
+}
+
+// regexpAllowed 根据前一个记号判断 / 是否是正则表达式开头
+func (p *jsProcessor) regexpAllowed() bool {
+	if p.last == ')' || p.last == ']' {
+		return false
+	}
+	if isWordChar(p.last) {
+		return regexpKeywords[p.lastWord]
+	}
+	return true
+}
+
+// endWord 记录刚结束的标识符
+func (p *jsProcessor) endWord() {
+	if len(p.word) > 0 {
+		p.lastWord = string(p.word)
+		p.word = p.word[:0]
+	}
+}
+
+// emit 输出一个非空白字符，按需补上之前省略的换行或空格
+func (p *jsProcessor) emit(c byte) {
+	if p.pendingNewline {
+		if p.last != 0 {
+			p.out.WriteByte('\n')
+		}
+	} else if p.pendingSpace && p.last != 0 {
+		// 标识符之间、以及 + + 和 - - 之间的空格不能省略
+		if (isWordChar(p.last) && isWordChar(c)) || (p.last == c && (c == '+' || c == '-')) || (p.last == '/' && c == '/') {
+			p.out.WriteByte(' ')
+		}
+	}
+	p.pendingNewline = false
+	p.pendingSpace = false
+	if !isWordChar(c) {
+		p.lastWord = ""
+	}
+	p.out.WriteByte(c)
+	p.last = c
+}
+
+// flush 输出剩余内容
+func (p *jsProcessor) flush() {
+	if p.state == jsSlash {
+		p.emit('/')
+	}
+}
+
+// isWordChar 是否是标识符或数字的字符，非ASCII字符按标识符处理
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,171 @@
+package minify
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+)
+
+// MinifierKey 当前请求的响应压缩器在上下文中的键，代理在转发响应时调用
+const MinifierKey = "response_minifier"
+
+// defaultMinSize 默认处理的最小响应大小
+const defaultMinSize = 1024
+
+// 内容类型
+const (
+	TypeHTML = "html"
+	TypeCSS  = "css"
+	TypeJS   = "js"
+)
+
+// bytesSaved 压缩节省的字节数，按内容类型分类
+var bytesSaved = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_minified_bytes_saved_total",
+	"Bytes removed from responses by the minifier.",
+	"type",
+)
+
+// Minifier 响应压缩器，按内容类型流式去除注释和多余空白
+type Minifier struct {
+	types   map[string]bool
+	minSize int64
+}
+
+// processor 逐字节处理内容的状态机，输出写入out
+type processor interface {
+	write(c byte)
+	flush()
+}
+
+// New 根据配置创建响应压缩器，配置为空或被关闭时返回nil
+func New(cfg *config.MinifyConfig) (*Minifier, error) {
+	if cfg == nil || (cfg.Enabled != nil && !*cfg.Enabled) {
+		return nil, nil
+	}
+
+	m := &Minifier{
+		types:   make(map[string]bool),
+		minSize: defaultMinSize,
+	}
+	types := cfg.Types
+	if len(types) == 0 {
+		types = []string{TypeHTML, TypeCSS, TypeJS}
+	}
+	for _, t := range types {
+		m.types[t] = true
+	}
+	if cfg.MinSize != "" {
+		size, err := config.ParseSize(cfg.MinSize)
+		if err != nil {
+			return nil, err
+		}
+		m.minSize = size
+	}
+	return m, nil
+}
+
+// typeOf 根据Content-Type确定内容类型
+func typeOf(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/html":
+		return TypeHTML
+	case "text/css":
+		return TypeCSS
+	case "application/javascript", "text/javascript", "application/x-javascript", "application/ecmascript":
+		return TypeJS
+	}
+	return ""
+}
+
+// Wrap 响应需要处理时替换为流式压缩的响应体，返回是否处理
+func (m *Minifier) Wrap(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || resp.Request == nil || resp.Request.Method == http.MethodHead {
+		return false
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+	// 未知长度的响应（分块传输）也处理
+	if resp.ContentLength >= 0 && resp.ContentLength < m.minSize {
+		return false
+	}
+	kind := typeOf(resp.Header.Get("Content-Type"))
+	if kind == "" || !m.types[kind] {
+		return false
+	}
+
+	r := &minifyReader{src: resp.Body, kind: kind, buf: make([]byte, 32*1024)}
+	switch kind {
+	case TypeHTML:
+		r.proc = &htmlProcessor{out: &r.out}
+	case TypeCSS:
+		r.proc = &cssProcessor{out: &r.out}
+	case TypeJS:
+		r.proc = &jsProcessor{out: &r.out}
+	}
+	resp.Body = r
+
+	// 长度在处理后才能确定，改为分块传输
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+	return true
+}
+
+// minifyReader 流式压缩的响应体
+type minifyReader struct {
+	src     io.ReadCloser
+	kind    string
+	proc    processor
+	out     bytes.Buffer
+	buf     []byte
+	err     error
+	read    int64
+	written int64
+}
+
+// Read 从上游读取数据并输出压缩后的内容
+func (r *minifyReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := r.src.Read(r.buf)
+		r.read += int64(n)
+		for _, c := range r.buf[:n] {
+			r.proc.write(c)
+		}
+		if err != nil {
+			if err == io.EOF {
+				r.proc.flush()
+				r.written += int64(r.out.Len())
+				if saved := r.read - r.written; saved > 0 {
+					bytesSaved.Add(float64(saved), r.kind)
+				}
+			}
+			r.err = err
+			continue
+		}
+		r.written += int64(r.out.Len())
+	}
+	return r.out.Read(p)
+}
+
+// Close 关闭上游响应体
+func (r *minifyReader) Close() error {
+	return r.src.Close()
+}
+
+// isSpace 是否是空白字符
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
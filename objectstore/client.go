@@ -0,0 +1,143 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config S3兼容对象存储配置
+type Config struct {
+	Endpoint  string // 如 https://s3.amazonaws.com 或 http://minio:9000
+	Region    string // 默认 us-east-1
+	Bucket    string
+	PathStyle bool // 使用 endpoint/bucket/key 形式的地址（MinIO等需要）
+	Credentials
+}
+
+// Client S3兼容对象存储客户端，只实现代理需要的操作
+type Client struct {
+	config     Config
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+// NewClient 创建对象存储客户端
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("access_key and secret_key are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid endpoint '%s'", cfg.Endpoint)
+	}
+
+	return &Client{
+		config:     cfg,
+		endpoint:   endpoint,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// Bucket 返回存储桶名称
+func (c *Client) Bucket() string {
+	return c.config.Bucket
+}
+
+// ObjectURL 返回对象地址
+func (c *Client) ObjectURL(key string) *url.URL {
+	u := *c.endpoint
+	key = strings.TrimPrefix(key, "/")
+	if c.config.PathStyle {
+		u.Path = "/" + c.config.Bucket + "/" + key
+	} else {
+		u.Host = c.config.Bucket + "." + c.endpoint.Host
+		u.Path = "/" + key
+	}
+	return &u
+}
+
+// Sign 为发往存储桶的请求签名
+func (c *Client) Sign(req *http.Request, payloadHash string) {
+	SignV4(req, c.config.Credentials, c.config.Region, "s3", payloadHash, time.Now())
+}
+
+// PutObject 上传对象
+func (c *Client) PutObject(ctx context.Context, key string, data []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.ObjectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.Sign(req, hashHex(data))
+	return c.do(req)
+}
+
+// PutObjectStream 流式上传对象，size为请求体长度，payloadHash为请求体的十六进制SHA256，未知时使用UnsignedPayload
+func (c *Client) PutObjectStream(ctx context.Context, key string, body io.Reader, size int64, payloadHash, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.ObjectURL(key).String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.Sign(req, payloadHash)
+	return c.do(req)
+}
+
+// DeleteObject 删除对象
+func (c *Client) DeleteObject(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.ObjectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	c.Sign(req, EmptyPayloadHash)
+	return c.do(req)
+}
+
+// do 发送请求并检查响应状态
+func (c *Client) do(req *http.Request) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// ConfigFromMap 从中间件配置中读取对象存储配置
+func ConfigFromMap(m map[string]interface{}) Config {
+	cfg := Config{}
+	cfg.Endpoint, _ = m["endpoint"].(string)
+	cfg.Region, _ = m["region"].(string)
+	cfg.Bucket, _ = m["bucket"].(string)
+	cfg.PathStyle, _ = m["path_style"].(bool)
+	cfg.AccessKey, _ = m["access_key"].(string)
+	cfg.SecretKey, _ = m["secret_key"].(string)
+	cfg.SessionToken, _ = m["session_token"].(string)
+	return cfg
+}
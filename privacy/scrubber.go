@@ -0,0 +1,218 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"toyou-proxy/config"
+)
+
+// Redacted 被删除的敏感值在日志中的替代文本
+const Redacted = "[REDACTED]"
+
+// 客户端IP匿名化方式
+const (
+	IPModeHash     = "hash"     // 替换为带盐的哈希，同一客户端在同一进程内哈希值不变
+	IPModeTruncate = "truncate" // IPv4保留前24位，IPv6保留前48位
+	IPModeNone     = "none"     // 保留原始IP
+)
+
+// 默认的敏感请求头
+var defaultSensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+// 默认的敏感查询参数
+var defaultSensitiveParams = []string{
+	"token",
+	"access_token",
+	"id_token",
+	"refresh_token",
+	"api_key",
+	"apikey",
+	"password",
+	"secret",
+	"email",
+}
+
+// Scrubber 日志脱敏器，在匿名模式下对客户端IP、请求头和查询参数脱敏
+type Scrubber struct {
+	enabled bool
+	ipMode  string
+	salt    []byte
+	headers map[string]bool
+	params  map[string]bool
+}
+
+// NewScrubber 根据隐私配置创建脱敏器
+func NewScrubber(cfg config.PrivacyConfig) (*Scrubber, error) {
+	s := &Scrubber{
+		enabled: cfg.Enabled,
+		ipMode:  cfg.IPMode,
+		headers: make(map[string]bool),
+		params:  make(map[string]bool),
+	}
+	if !s.enabled {
+		return s, nil
+	}
+
+	switch s.ipMode {
+	case "":
+		s.ipMode = IPModeHash
+	case IPModeHash, IPModeTruncate, IPModeNone:
+	default:
+		return nil, fmt.Errorf("invalid ip_mode '%s', expected 'hash', 'truncate' or 'none'", cfg.IPMode)
+	}
+
+	if cfg.IPHashSalt != "" {
+		s.salt = []byte(cfg.IPHashSalt)
+	} else {
+		// 未配置盐时每次启动随机生成，哈希值无法跨进程关联
+		s.salt = make([]byte, 32)
+		if _, err := rand.Read(s.salt); err != nil {
+			return nil, fmt.Errorf("failed to generate ip hash salt: %v", err)
+		}
+	}
+
+	for _, name := range append(defaultSensitiveHeaders, cfg.RedactHeaders...) {
+		s.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range append(defaultSensitiveParams, cfg.RedactQueryParams...) {
+		s.params[strings.ToLower(name)] = true
+	}
+	return s, nil
+}
+
+// Enabled 是否开启匿名模式
+func (s *Scrubber) Enabled() bool {
+	return s.enabled
+}
+
+// ClientIP 返回日志中使用的客户端地址，remoteAddr可以带端口
+func (s *Scrubber) ClientIP(remoteAddr string) string {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	if !s.enabled || s.ipMode == IPModeNone {
+		return host
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return s.hash(host)
+	}
+
+	if s.ipMode == IPModeTruncate {
+		if v4 := ip.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+	return s.hash(ip.String())
+}
+
+// hash 计算带盐的哈希
+func (s *Scrubber) hash(value string) string {
+	mac := hmac.New(sha256.New, s.salt)
+	mac.Write([]byte(value))
+	return "ip-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Query 删除查询字符串中敏感参数的值，保持参数顺序
+func (s *Scrubber) Query(rawQuery string) string {
+	if !s.enabled || rawQuery == "" {
+		return rawQuery
+	}
+
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		rawName := param
+		if idx := strings.Index(param, "="); idx != -1 {
+			rawName = param[:idx]
+		}
+		name := rawName
+		if unescaped, err := url.QueryUnescape(rawName); err == nil {
+			name = unescaped
+		}
+		if s.params[strings.ToLower(name)] {
+			params[i] = rawName + "=" + Redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// URI 返回日志中使用的请求URI，敏感查询参数被删除
+func (s *Scrubber) URI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	return u.Path + "?" + s.Query(u.RawQuery)
+}
+
+// Headers 返回删除敏感值后的请求头副本
+func (s *Scrubber) Headers(header http.Header) http.Header {
+	scrubbed := header.Clone()
+	if !s.enabled {
+		return scrubbed
+	}
+	for name := range scrubbed {
+		if s.headers[name] {
+			scrubbed[name] = []string{Redacted}
+		}
+	}
+	return scrubbed
+}
+
+// 全局默认脱敏器，代理启动时根据配置设置，插件与主程序共享同一实例
+var (
+	defaultScrubber, _ = NewScrubber(config.PrivacyConfig{})
+	defaultScrubberMu  sync.RWMutex
+)
+
+// Configure 根据隐私配置设置默认脱敏器
+func Configure(cfg config.PrivacyConfig) error {
+	scrubber, err := NewScrubber(cfg)
+	if err != nil {
+		return err
+	}
+
+	defaultScrubberMu.Lock()
+	defaultScrubber = scrubber
+	defaultScrubberMu.Unlock()
+	return nil
+}
+
+// GetDefaultScrubber 获取默认脱敏器
+func GetDefaultScrubber() *Scrubber {
+	defaultScrubberMu.RLock()
+	defer defaultScrubberMu.RUnlock()
+	return defaultScrubber
+}
+
+// ClientIP 使用默认脱敏器处理客户端地址
+func ClientIP(remoteAddr string) string {
+	return GetDefaultScrubber().ClientIP(remoteAddr)
+}
+
+// URI 使用默认脱敏器处理请求URI
+func URI(u *url.URL) string {
+	return GetDefaultScrubber().URI(u)
+}
+
+// Headers 使用默认脱敏器处理请求头
+func Headers(header http.Header) http.Header {
+	return GetDefaultScrubber().Headers(header)
+}
@@ -0,0 +1,234 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// 只解析runtime/pprof输出的CPU profile中统计需要的字段，见
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+
+// profile 解码后的CPU profile
+type profile struct {
+	sampleTypes []valueType
+	samples     []profileSample
+	locations   map[uint64][]uint64 // location id -> function id，第一个是被内联的最内层函数
+	functions   map[uint64]int64    // function id -> 函数名在字符串表中的位置
+	strings     []string
+}
+
+type valueType struct {
+	typ  int64
+	unit int64
+}
+
+// profileSample 一个采样，第一个location是栈顶
+type profileSample struct {
+	locations []uint64
+	values    []int64
+	labels    map[int64]int64 // 标签名 -> 标签值，都是字符串表中的位置
+}
+
+// decodeProfile 解码gzip压缩的profile
+func decodeProfile(data []byte) (*profile, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &profile{
+		locations: make(map[uint64][]uint64),
+		functions: make(map[uint64]int64),
+	}
+	err = eachField(raw, func(field int, wire int, value uint64, data []byte) error {
+		switch field {
+		case 1: // sample_type
+			var vt valueType
+			err := eachField(data, func(field int, _ int, value uint64, _ []byte) error {
+				switch field {
+				case 1:
+					vt.typ = int64(value)
+				case 2:
+					vt.unit = int64(value)
+				}
+				return nil
+			})
+			p.sampleTypes = append(p.sampleTypes, vt)
+			return err
+		case 2: // sample
+			s, err := decodeSample(data)
+			p.samples = append(p.samples, s)
+			return err
+		case 4: // location
+			return p.decodeLocation(data)
+		case 5: // function
+			var id uint64
+			var name int64
+			err := eachField(data, func(field int, _ int, value uint64, _ []byte) error {
+				switch field {
+				case 1:
+					id = value
+				case 2:
+					name = int64(value)
+				}
+				return nil
+			})
+			p.functions[id] = name
+			return err
+		case 6: // string_table
+			p.strings = append(p.strings, string(data))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile: %v", err)
+	}
+	return p, nil
+}
+
+// decodeSample 解码一个采样
+func decodeSample(data []byte) (profileSample, error) {
+	s := profileSample{labels: make(map[int64]int64)}
+	err := eachField(data, func(field int, wire int, value uint64, data []byte) error {
+		switch field {
+		case 1: // location_id
+			ids, err := repeatedVarints(wire, value, data)
+			s.locations = append(s.locations, ids...)
+			return err
+		case 2: // value
+			values, err := repeatedVarints(wire, value, data)
+			for _, v := range values {
+				s.values = append(s.values, int64(v))
+			}
+			return err
+		case 3: // label
+			var key, str int64
+			err := eachField(data, func(field int, _ int, value uint64, _ []byte) error {
+				switch field {
+				case 1:
+					key = int64(value)
+				case 2:
+					str = int64(value)
+				}
+				return nil
+			})
+			if str != 0 {
+				s.labels[key] = str
+			}
+			return err
+		}
+		return nil
+	})
+	return s, err
+}
+
+// decodeLocation 解码一个location，只保留其中的函数
+func (p *profile) decodeLocation(data []byte) error {
+	var id uint64
+	var functions []uint64
+	err := eachField(data, func(field int, _ int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			id = value
+		case 4: // line
+			return eachField(data, func(field int, _ int, value uint64, _ []byte) error {
+				if field == 1 {
+					functions = append(functions, value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	p.locations[id] = functions
+	return err
+}
+
+// str 返回字符串表中的字符串
+func (p *profile) str(i int64) string {
+	if i < 0 || int(i) >= len(p.strings) {
+		return ""
+	}
+	return p.strings[i]
+}
+
+// protobuf wire类型
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// eachField 依次处理消息中的字段，varint字段的值在value中，长度前缀字段的内容在data中
+func eachField(msg []byte, fn func(field int, wire int, value uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("malformed field key")
+		}
+		msg = msg[n:]
+		field, wire := int(key>>3), int(key&7)
+
+		var value uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			value, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return fmt.Errorf("malformed varint in field %d", field)
+			}
+			msg = msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			value = binary.LittleEndian.Uint64(msg)
+			msg = msg[8:]
+		case wireBytes:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data = msg[n : n+int(length)]
+			msg = msg[n+int(length):]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			value = uint64(binary.LittleEndian.Uint32(msg))
+			msg = msg[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", wire, field)
+		}
+
+		if err := fn(field, wire, value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repeatedVarints 解码重复的整数字段，兼容packed和非packed编码
+func repeatedVarints(wire int, value uint64, data []byte) ([]uint64, error) {
+	if wire != wireBytes {
+		return []uint64{value}, nil
+	}
+	var values []uint64
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed packed varint")
+		}
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values, nil
+}
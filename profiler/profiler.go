@@ -0,0 +1,277 @@
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 采样期间中间件执行时附加到goroutine上的pprof标签
+const (
+	LabelMiddleware = "middleware"
+	LabelRoute      = "route"
+)
+
+// topFunctions 每个中间件列出的自身耗时最多的函数数
+const topFunctions = 5
+
+// ErrBusy 已经有CPU采样在进行
+var ErrBusy = errors.New("a CPU profile is already running")
+
+var (
+	captureMu sync.Mutex
+	active    atomic.Bool
+)
+
+// Active 是否正在采样，只有采样期间中间件才附加标签，平时没有额外开销
+func Active() bool {
+	return active.Load()
+}
+
+// Profile 一次采样的结果
+type Profile struct {
+	Start    time.Time
+	Duration time.Duration
+	Raw      []byte // gzip压缩的pprof格式，中间件执行期间的采样带有middleware和route标签
+
+	prof    *profile
+	samples int // 采样次数在values中的位置
+	cpu     int // CPU时间（纳秒）在values中的位置
+}
+
+// Capture 在duration内对进程进行CPU采样，ctx取消时提前结束并返回错误
+// 同一时间只能进行一个CPU采样（包括其他方式启动的runtime/pprof采样），否则返回ErrBusy
+func Capture(ctx context.Context, duration time.Duration) (*Profile, error) {
+	if !captureMu.TryLock() {
+		return nil, ErrBusy
+	}
+	defer captureMu.Unlock()
+
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return nil, ErrBusy
+	}
+	active.Store(true)
+	start := time.Now()
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	active.Store(false)
+	pprof.StopCPUProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	prof, err := decodeProfile(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{
+		Start:    start,
+		Duration: time.Since(start),
+		Raw:      buf.Bytes(),
+		prof:     prof,
+		samples:  0,
+		cpu:      1,
+	}
+	for i, vt := range prof.sampleTypes {
+		switch prof.str(vt.typ) {
+		case "samples":
+			p.samples = i
+		case "cpu":
+			p.cpu = i
+		}
+	}
+	return p, nil
+}
+
+// Summary 按中间件汇总的CPU时间
+type Summary struct {
+	Start           time.Time         `json:"start"`
+	DurationMs      float64           `json:"duration_ms"`
+	Samples         int64             `json:"samples"`
+	CPUMs           float64           `json:"cpu_ms"`            // 整个进程的CPU时间
+	MiddlewareCPUMs float64           `json:"middleware_cpu_ms"` // 中间件执行期间的CPU时间
+	Middlewares     []MiddlewareUsage `json:"middlewares"`       // CPU时间最多的在前
+}
+
+// MiddlewareUsage 一个规则中一个中间件的CPU时间
+type MiddlewareUsage struct {
+	Middleware   string          `json:"middleware"`
+	Route        string          `json:"route"`
+	Samples      int64           `json:"samples"`
+	CPUMs        float64         `json:"cpu_ms"`
+	Share        float64         `json:"share"`         // 占整个进程CPU时间的比例
+	TopFunctions []FunctionUsage `json:"top_functions"` // 自身CPU时间最多的函数
+}
+
+// FunctionUsage 函数自身（不包括调用的函数）的CPU时间
+type FunctionUsage struct {
+	Function string  `json:"function"`
+	CPUMs    float64 `json:"cpu_ms"`
+}
+
+// usageKey 中间件和规则
+type usageKey struct {
+	middleware string
+	route      string
+}
+
+// Summary 按中间件和规则汇总CPU时间，中间件启动的goroutine同样计入该中间件
+func (p *Profile) Summary() *Summary {
+	summary := &Summary{
+		Start:       p.Start,
+		DurationMs:  milliseconds(int64(p.Duration)),
+		Middlewares: []MiddlewareUsage{},
+	}
+
+	type usage struct {
+		samples   int64
+		cpu       int64
+		functions map[string]int64
+	}
+	usages := make(map[usageKey]*usage)
+	var total int64
+
+	for _, s := range p.prof.samples {
+		samples, cpu := p.value(s, p.samples), p.value(s, p.cpu)
+		summary.Samples += samples
+		total += cpu
+
+		key, ok := p.middleware(s)
+		if !ok {
+			continue
+		}
+		u, exists := usages[key]
+		if !exists {
+			u = &usage{functions: make(map[string]int64)}
+			usages[key] = u
+		}
+		u.samples += samples
+		u.cpu += cpu
+		if stack := p.stack(s); len(stack) > 0 {
+			u.functions[stack[len(stack)-1]] += cpu
+		}
+	}
+	summary.CPUMs = milliseconds(total)
+
+	for key, u := range usages {
+		entry := MiddlewareUsage{
+			Middleware: key.middleware,
+			Route:      key.route,
+			Samples:    u.samples,
+			CPUMs:      milliseconds(u.cpu),
+		}
+		if total > 0 {
+			entry.Share = float64(u.cpu) / float64(total)
+		}
+		for function, cpu := range u.functions {
+			entry.TopFunctions = append(entry.TopFunctions, FunctionUsage{Function: function, CPUMs: milliseconds(cpu)})
+		}
+		sort.Slice(entry.TopFunctions, func(i, j int) bool {
+			return entry.TopFunctions[i].CPUMs > entry.TopFunctions[j].CPUMs
+		})
+		if len(entry.TopFunctions) > topFunctions {
+			entry.TopFunctions = entry.TopFunctions[:topFunctions]
+		}
+		summary.MiddlewareCPUMs += entry.CPUMs
+		summary.Middlewares = append(summary.Middlewares, entry)
+	}
+	sort.Slice(summary.Middlewares, func(i, j int) bool {
+		a, b := summary.Middlewares[i], summary.Middlewares[j]
+		if a.CPUMs != b.CPUMs {
+			return a.CPUMs > b.CPUMs
+		}
+		return a.Middleware+a.Route < b.Middleware+b.Route
+	})
+	return summary
+}
+
+// WriteFolded 以折叠栈格式输出中间件执行期间的采样，可用flamegraph.pl、speedscope等工具生成火焰图
+// 每行是 "中间件;规则;调用栈（从外到内） 采样次数"，all为true时同时输出中间件之外的采样（以"-;-"开头）
+func (p *Profile) WriteFolded(w io.Writer, all bool) error {
+	stacks := make(map[string]int64)
+	for _, s := range p.prof.samples {
+		key, ok := p.middleware(s)
+		if !ok {
+			if !all {
+				continue
+			}
+			key = usageKey{middleware: "-", route: "-"}
+		}
+		frames := append([]string{key.middleware, key.route}, p.stack(s)...)
+		for i, frame := range frames {
+			frames[i] = foldedFrame.Replace(frame)
+		}
+		stacks[strings.Join(frames, ";")] += p.value(s, p.samples)
+	}
+
+	lines := make([]string, 0, len(stacks))
+	for stack := range stacks {
+		lines = append(lines, stack)
+	}
+	sort.Strings(lines)
+	for _, stack := range lines {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, stacks[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// foldedFrame 折叠栈格式中分号分隔调用栈，换行分隔记录
+var foldedFrame = strings.NewReplacer(";", ":", "\n", " ")
+
+// middleware 返回采样所属的中间件和规则，不是在中间件执行期间采集的返回false
+func (p *Profile) middleware(s profileSample) (usageKey, bool) {
+	var key usageKey
+	for k, v := range s.labels {
+		switch p.prof.str(k) {
+		case LabelMiddleware:
+			key.middleware = p.prof.str(v)
+		case LabelRoute:
+			key.route = p.prof.str(v)
+		}
+	}
+	return key, key.middleware != ""
+}
+
+// stack 返回采样的调用栈，从外到内
+func (p *Profile) stack(s profileSample) []string {
+	var stack []string
+	for i := len(s.locations) - 1; i >= 0; i-- {
+		functions := p.prof.locations[s.locations[i]]
+		for j := len(functions) - 1; j >= 0; j-- {
+			stack = append(stack, p.prof.str(p.prof.functions[functions[j]]))
+		}
+	}
+	return stack
+}
+
+// value 返回采样的第i个值
+func (p *Profile) value(s profileSample, i int) int64 {
+	if i < len(s.values) {
+		return s.values[i]
+	}
+	return 0
+}
+
+// milliseconds 把纳秒转换为毫秒
+func milliseconds(ns int64) float64 {
+	return float64(ns) / float64(time.Millisecond)
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/debuglog"
+	proxymetrics "toyou-proxy/metrics"
+)
+
+// 过载保护拒绝请求的原因，用作指标标签
+const (
+	rejectOverloadInFlight   = "overload_in_flight"
+	rejectOverloadGoroutines = "overload_goroutines"
+	rejectOverloadMemory     = "overload_memory"
+)
+
+// admissionSampleInterval goroutine数和堆内存的采样间隔，避免每个请求都读取运行时统计
+const admissionSampleInterval = 100 * time.Millisecond
+
+// heapMetric 堆上存活和尚未回收的对象占用的内存
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// inFlightRequests 正在处理的请求数
+var inFlightRequests = proxymetrics.GetDefaultRegistry().NewGaugeVec(
+	"toyou_proxy_in_flight_requests",
+	"Requests currently being handled by the proxy.",
+)
+
+// admissionController 过载保护，在路由匹配之前按正在处理的请求数、goroutine数和堆内存拒绝请求
+type admissionController struct {
+	maxInFlight   int64
+	maxGoroutines int
+	maxMemory     uint64
+	retryAfter    string
+
+	inFlight int64
+
+	sampledAt  time.Time
+	goroutines int
+	heapBytes  uint64
+	sample     []metrics.Sample
+	mu         sync.Mutex
+}
+
+// newAdmissionController 根据配置创建过载保护，未配置任何上限时返回nil
+func newAdmissionController(cfg config.AdmissionConfig) (*admissionController, error) {
+	maxMemory, err := config.ParseSize(cfg.MaxMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admission max_memory: %v", err)
+	}
+	if cfg.MaxInFlight == 0 && cfg.MaxGoroutines == 0 && maxMemory == 0 {
+		return nil, nil
+	}
+
+	retryAfter := cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = 1
+	}
+
+	return &admissionController{
+		maxInFlight:   int64(cfg.MaxInFlight),
+		maxGoroutines: cfg.MaxGoroutines,
+		maxMemory:     uint64(maxMemory),
+		retryAfter:    strconv.Itoa(retryAfter),
+		sample:        []metrics.Sample{{Name: heapMetric}},
+	}, nil
+}
+
+// admit 检查是否接受请求，接受时返回空字符串，调用方处理完成后需要调用release
+func (a *admissionController) admit() string {
+	inFlight := atomic.AddInt64(&a.inFlight, 1)
+	if a.maxInFlight > 0 && inFlight > a.maxInFlight {
+		atomic.AddInt64(&a.inFlight, -1)
+		return rejectOverloadInFlight
+	}
+
+	if a.maxGoroutines > 0 || a.maxMemory > 0 {
+		goroutines, heapBytes := a.load()
+		reason := ""
+		if a.maxGoroutines > 0 && goroutines > a.maxGoroutines {
+			reason = rejectOverloadGoroutines
+		} else if a.maxMemory > 0 && heapBytes > a.maxMemory {
+			reason = rejectOverloadMemory
+		}
+		if reason != "" {
+			atomic.AddInt64(&a.inFlight, -1)
+			return reason
+		}
+	}
+
+	inFlightRequests.Set(float64(inFlight))
+	return ""
+}
+
+// release 请求处理完成
+func (a *admissionController) release() {
+	inFlightRequests.Set(float64(atomic.AddInt64(&a.inFlight, -1)))
+}
+
+// load 返回最近采样的goroutine数和堆内存
+func (a *admissionController) load() (int, uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now := time.Now(); now.Sub(a.sampledAt) >= admissionSampleInterval {
+		a.sampledAt = now
+		a.goroutines = runtime.NumGoroutine()
+		if a.maxMemory > 0 {
+			metrics.Read(a.sample)
+			if a.sample[0].Value.Kind() == metrics.KindUint64 {
+				a.heapBytes = a.sample[0].Value.Uint64()
+			}
+		}
+	}
+	return a.goroutines, a.heapBytes
+}
+
+// reject 返回503，Retry-After告诉客户端稍后重试
+// 过载时每个被拒绝的请求都输出日志会加重负载，因此只输出调试日志，以指标为准
+func (a *admissionController) reject(w http.ResponseWriter, r *http.Request, reason string) {
+	rejectedRequests.Inc(reason)
+	debuglog.Printf("Rejected request: %s %s [%s]: %s", r.Method, r.URL.Path, r.Host, reason)
+	w.Header().Set("Retry-After", a.retryAfter)
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
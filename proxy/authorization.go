@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"log"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+	"toyou-proxy/middleware/builtin/authorization"
+)
+
+// authorizationRequirement 返回授权要求，优先级：路由级 > 域名级
+func (ph *ProxyHandler) authorizationRequirement(hostRule *config.HostRule, routeRule *config.RouteRule) *config.AuthorizationConfig {
+	if routeRule != nil && routeRule.Authorization != nil {
+		return routeRule.Authorization
+	}
+	if hostRule != nil && hostRule.Authorization != nil {
+		return hostRule.Authorization
+	}
+	return nil
+}
+
+// applyAuthorization 将授权要求放入上下文，链中没有授权中间件时追加到链尾，
+// 保证授权检查在所有认证中间件设置身份之后执行
+func (ph *ProxyHandler) applyAuthorization(chain middleware.MiddlewareChain, ctx *middleware.Context, hostRule *config.HostRule, routeRule *config.RouteRule) bool {
+	requirement := ph.authorizationRequirement(hostRule, routeRule)
+	if requirement == nil {
+		return true
+	}
+	ctx.Set(authorization.RequirementKey, requirement)
+
+	for _, name := range chain.GetMiddlewareNames() {
+		if name == "authorization" {
+			return true
+		}
+	}
+
+	mw, err := ph.factory.CreateMiddleware("authorization", nil)
+	if err != nil {
+		// 无法执行授权检查时拒绝请求，而不是放行
+		log.Printf("Failed to create authorization middleware: %v", err)
+		return false
+	}
+	chain.Add(mw)
+	return true
+}
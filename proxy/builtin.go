@@ -0,0 +1,21 @@
+package proxy
+
+// 内置中间件，在包初始化时注册到内置中间件注册表
+import (
+	_ "toyou-proxy/middleware/builtin/archiver"
+	_ "toyou-proxy/middleware/builtin/authorization"
+	_ "toyou-proxy/middleware/builtin/clientcert"
+	_ "toyou-proxy/middleware/builtin/cors"
+	_ "toyou-proxy/middleware/builtin/imageproxy"
+	_ "toyou-proxy/middleware/builtin/ldapauth"
+	_ "toyou-proxy/middleware/builtin/logging"
+	_ "toyou-proxy/middleware/builtin/masking"
+	_ "toyou-proxy/middleware/builtin/ratelimit"
+	_ "toyou-proxy/middleware/builtin/requestsigning"
+	_ "toyou-proxy/middleware/builtin/samlauth"
+	_ "toyou-proxy/middleware/builtin/sessionlimit"
+	_ "toyou-proxy/middleware/builtin/sessions"
+	_ "toyou-proxy/middleware/builtin/tlsfingerprint"
+	_ "toyou-proxy/middleware/builtin/uploadoffload"
+	_ "toyou-proxy/middleware/builtin/upstreamauth"
+)
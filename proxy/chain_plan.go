@@ -0,0 +1,378 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+	"toyou-proxy/secrets"
+)
+
+// chainPlan 规则的中间件链计划：按优先级解析好的候选中间件（路由级、域名级、全局中间件、全局中间件服务），
+// 同名中间件只保留优先级最高的一个。计划按规则的中间件装配和所用中间件配置的哈希索引，
+// 重新加载配置时哈希不变的计划直接沿用，其中的中间件实例不重新创建
+type chainPlan struct {
+	hash       string
+	candidates []chainCandidate
+}
+
+// chainCandidate 计划中的一个中间件，启用状态和生效时间窗口可以在运行时变化，按请求判断
+type chainCandidate struct {
+	name     string
+	source   string
+	toggled  bool                    // 是否受管理API的启用状态覆盖影响，直接按名称创建的内置中间件和插件总是启用
+	enabled  bool                    // 配置中的启用状态
+	windows  [][]config.ActiveWindow // 中间件配置和同名中间件服务的生效时间窗口，都在窗口内时才挂载
+	key      string                  // 中间件实例的索引，见middlewarePool
+	instance *pooledMiddleware       // nil表示规则引用的中间件不存在
+}
+
+// applies 判断中间件当前是否启用并在生效时间窗口内
+func (c *chainCandidate) applies(toggles *middleware.MiddlewareToggles, now time.Time) bool {
+	if c.toggled && !toggles.Enabled(c.name, c.enabled) {
+		return false
+	}
+	for _, windows := range c.windows {
+		if !config.IsActive(windows, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// pooledMiddleware 按配置共享的中间件实例，配置相同的规则以及重新加载前后的处理器使用同一个实例。
+// 实例在加载配置时或第一次使用时创建，密钥版本（见secrets.Generation）或共享状态版本
+// （插件重新加载，见middleware.SharedStateGeneration）变化后重新创建
+type pooledMiddleware struct {
+	create  func() (middleware.Middleware, error)
+	current atomic.Pointer[pooledInstance]
+	mu      sync.Mutex
+}
+
+// pooledInstance 已创建的实例及创建时的版本；创建失败时mw为nil，
+// 同一版本在failedAt之后的createRetryInterval内直接返回err，避免每个请求都重新解析无效的配置
+type pooledInstance struct {
+	mw       middleware.Middleware
+	err      error
+	failedAt time.Time
+	version  instanceVersion
+}
+
+// createRetryInterval 中间件创建失败后重试的间隔
+const createRetryInterval = 5 * time.Second
+
+// instanceVersion 创建实例时的密钥版本和共享状态版本
+type instanceVersion struct {
+	secrets uint64
+	shared  uint64
+}
+
+// get 返回中间件实例，创建失败时返回错误，createRetryInterval之后重新创建
+func (p *pooledMiddleware) get() (middleware.Middleware, error) {
+	version := instanceVersion{secrets: secrets.Generation(), shared: middleware.SharedStateGeneration()}
+	if instance, ok := p.load(version); ok {
+		return instance.mw, instance.err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if instance, ok := p.load(version); ok {
+		return instance.mw, instance.err
+	}
+	mw, err := p.create()
+	if err != nil {
+		p.current.Store(&pooledInstance{err: err, failedAt: time.Now(), version: version})
+		return nil, err
+	}
+	p.current.Store(&pooledInstance{mw: mw, version: version})
+	return mw, nil
+}
+
+// load 返回当前版本可以直接使用的实例或创建错误
+func (p *pooledMiddleware) load(version instanceVersion) (*pooledInstance, bool) {
+	instance := p.current.Load()
+	if instance == nil || instance.version != version {
+		return nil, false
+	}
+	return instance, instance.err == nil || time.Since(instance.failedAt) < createRetryInterval
+}
+
+// middlewarePool 按中间件配置索引的共享实例，索引包含中间件名称和配置的哈希。
+// 创建时传入之前的实例，配置未变化的中间件沿用之前的实例
+type middlewarePool struct {
+	previous map[string]*pooledMiddleware
+	entries  map[string]*pooledMiddleware
+}
+
+// newMiddlewarePool 创建实例池，previous为nil时所有中间件重新创建
+func newMiddlewarePool(previous *middlewarePool) *middlewarePool {
+	pool := &middlewarePool{entries: make(map[string]*pooledMiddleware)}
+	if previous != nil {
+		pool.previous = previous.entries
+	}
+	return pool
+}
+
+// get 返回索引对应的共享实例，不存在时沿用之前的实例或登记新的实例
+func (p *middlewarePool) get(key string, create func() (middleware.Middleware, error)) *pooledMiddleware {
+	if pooled, exists := p.entries[key]; exists {
+		return pooled
+	}
+	pooled, exists := p.previous[key]
+	if !exists {
+		pooled = &pooledMiddleware{create: create}
+	}
+	p.entries[key] = pooled
+	return pooled
+}
+
+// ruleKey 规则在配置中的位置，routeRule为nil表示没有匹配路由规则时的域名级链
+type ruleKey struct {
+	hostRule  *config.HostRule
+	routeRule *config.RouteRule
+}
+
+// chainPlans 处理器所有规则的中间件链计划，创建后只读
+type chainPlans struct {
+	rules  map[ruleKey]*chainPlan
+	byHash map[string]*chainPlan
+	pool   *middlewarePool
+}
+
+// get 返回规则的链计划，规则不属于当前配置时返回nil
+func (cp *chainPlans) get(hostRule *config.HostRule, routeRule *config.RouteRule) *chainPlan {
+	if cp == nil {
+		return nil
+	}
+	return cp.rules[ruleKey{hostRule, routeRule}]
+}
+
+// buildChainPlans 为配置中的每个域名规则和路由规则创建链计划，哈希与之前的计划相同时沿用之前的计划，
+// 然后创建当前启用的中间件，中间件配置错误在加载时报告而不是在请求时
+func (ph *ProxyHandler) buildChainPlans(previous *chainPlans) *chainPlans {
+	var previousPool *middlewarePool
+	if previous != nil {
+		previousPool = previous.pool
+	}
+	plans := &chainPlans{
+		rules:  make(map[ruleKey]*chainPlan),
+		byHash: make(map[string]*chainPlan),
+		pool:   newMiddlewarePool(previousPool),
+	}
+
+	reused := 0
+	add := func(hostRule *config.HostRule, routeRule *config.RouteRule) {
+		plan := ph.newChainPlan(hostRule, routeRule, plans.pool)
+		if existing, exists := plans.byHash[plan.hash]; exists {
+			plan = existing
+		} else if old := previous.lookup(plan.hash); old != nil {
+			plan = old
+			reused++
+		}
+		plans.byHash[plan.hash] = plan
+		plans.rules[ruleKey{hostRule, routeRule}] = plan
+	}
+	for i := range ph.cfg.HostRules {
+		hostRule := &ph.cfg.HostRules[i]
+		add(hostRule, nil)
+		for j := range hostRule.RouteRules {
+			add(hostRule, &hostRule.RouteRules[j])
+		}
+	}
+	plans.pool.previous = nil
+
+	// 创建当前启用的中间件，被禁用或不在时间窗口内的中间件在第一次使用时创建
+	now := time.Now()
+	toggles := middleware.GetMiddlewareToggles()
+	created := make(map[*pooledMiddleware]bool)
+	for _, plan := range plans.byHash {
+		for i := range plan.candidates {
+			candidate := &plan.candidates[i]
+			if candidate.instance == nil || created[candidate.instance] || !candidate.applies(toggles, now) {
+				continue
+			}
+			created[candidate.instance] = true
+			if _, err := candidate.instance.get(); err != nil {
+				log.Printf("Failed to create %s middleware %s: %v", candidate.source, candidate.name, err)
+			}
+		}
+	}
+
+	log.Printf("Middleware chain plans: %d rules, %d plans (%d reused), %d middleware instances",
+		len(plans.rules), len(plans.byHash), reused, len(plans.pool.entries))
+	return plans
+}
+
+// lookup 按哈希查找之前的计划
+func (cp *chainPlans) lookup(hash string) *chainPlan {
+	if cp == nil {
+		return nil
+	}
+	return cp.byHash[hash]
+}
+
+// newChainPlan 按优先级解析规则的候选中间件：路由级、域名级、全局中间件、全局中间件服务，
+// 规则中已引用的中间件不再作为全局中间件重复添加
+func (ph *ProxyHandler) newChainPlan(hostRule *config.HostRule, routeRule *config.RouteRule, pool *middlewarePool) *chainPlan {
+	plan := &chainPlan{}
+	added := make(map[string]bool)
+
+	// 路由级中间件（优先级最高），然后是域名级中间件
+	addNamed := func(names []string, source string) {
+		for _, name := range names {
+			if added[name] {
+				continue
+			}
+			added[name] = true
+			candidate := ph.namedCandidate(name, pool)
+			candidate.source = source
+			plan.candidates = append(plan.candidates, candidate)
+		}
+	}
+	if routeRule != nil {
+		addNamed(routeRule.Middlewares, chainSourceRoute)
+	}
+	if hostRule != nil {
+		addNamed(hostRule.Middlewares, chainSourceHost)
+	}
+
+	// 全局中间件（优先级最低），启用状态可以在运行时覆盖，因此包括配置中禁用的中间件
+	for _, mwConfig := range ph.cfg.Middlewares {
+		if added[mwConfig.Name] {
+			continue
+		}
+		plan.candidates = append(plan.candidates, chainCandidate{
+			name:    mwConfig.Name,
+			source:  chainSourceGlobal,
+			toggled: true,
+			enabled: mwConfig.Enabled,
+			windows: [][]config.ActiveWindow{mwConfig.ActiveWindows},
+		})
+		ph.bindMiddleware(&plan.candidates[len(plan.candidates)-1], mwConfig, pool)
+	}
+
+	// 全局中间件服务（优先级最低），只添加明确标记为全局的中间件服务，按配置顺序排列
+	globalServices := make(map[string]bool)
+	for _, service := range ph.cfg.MiddlewareServices {
+		if !service.IsGlobal || added[service.Name] || globalServices[service.Name] {
+			continue
+		}
+		globalServices[service.Name] = true
+		service, _ := ph.middlewareService(service.Name)
+		plan.candidates = append(plan.candidates, chainCandidate{
+			name:    service.Name,
+			source:  chainSourceGlobalService,
+			toggled: true,
+			enabled: service.Enabled,
+			windows: [][]config.ActiveWindow{service.ActiveWindows},
+		})
+		ph.bindService(&plan.candidates[len(plan.candidates)-1], service, pool)
+	}
+
+	plan.hash = plan.computeHash()
+	return plan
+}
+
+// namedCandidate 解析规则按名称引用的中间件
+// 查找顺序：middlewares 中的配置、middleware_services 中的中间件服务、直接按名称创建（内置中间件或插件）；
+// 同名的中间件配置和中间件服务的生效时间窗口都需要满足
+func (ph *ProxyHandler) namedCandidate(name string, pool *middlewarePool) chainCandidate {
+	candidate := chainCandidate{name: name}
+	var mwConfig *config.Middleware
+	for i := range ph.cfg.Middlewares {
+		if ph.cfg.Middlewares[i].Name != name {
+			continue
+		}
+		candidate.windows = append(candidate.windows, ph.cfg.Middlewares[i].ActiveWindows)
+		if mwConfig == nil {
+			mwConfig = &ph.cfg.Middlewares[i]
+		}
+	}
+	service, hasService := ph.middlewareService(name)
+	if hasService {
+		candidate.windows = append(candidate.windows, service.ActiveWindows)
+	}
+
+	switch {
+	case mwConfig != nil:
+		candidate.toggled, candidate.enabled = true, mwConfig.Enabled
+		ph.bindMiddleware(&candidate, *mwConfig, pool)
+	case hasService:
+		candidate.toggled, candidate.enabled = true, service.Enabled
+		ph.bindService(&candidate, service, pool)
+	case containsName(ph.factory.GetRegisteredMiddlewares(), name):
+		factory := ph.factory
+		candidate.key = "builtin\x00" + name
+		candidate.instance = pool.get(candidate.key, func() (middleware.Middleware, error) {
+			return factory.CreateMiddleware(name, nil)
+		})
+	}
+	return candidate
+}
+
+// bindMiddleware 为候选中间件绑定middlewares中的配置对应的共享实例
+func (ph *ProxyHandler) bindMiddleware(candidate *chainCandidate, mwConfig config.Middleware, pool *middlewarePool) {
+	factory := ph.factory
+	candidate.key = "middleware\x00" + mwConfig.Name + "\x00" + configHash(mwConfig.Config)
+	candidate.instance = pool.get(candidate.key, func() (middleware.Middleware, error) {
+		return factory.CreateMiddleware(mwConfig.Name, mwConfig.Config)
+	})
+}
+
+// bindService 为候选中间件绑定中间件服务对应的共享实例，配置了type时按类型创建并使用服务的配置
+func (ph *ProxyHandler) bindService(candidate *chainCandidate, service config.MiddlewareService, pool *middlewarePool) {
+	factory := ph.factory
+	kind := service.Type
+	if kind == "" {
+		kind = service.Name
+	}
+	candidate.key = "service\x00" + service.Name + "\x00" + kind + "\x00" + configHash(service.Config)
+	candidate.instance = pool.get(candidate.key, func() (middleware.Middleware, error) {
+		return factory.CreateMiddleware(kind, service.Config)
+	})
+}
+
+// middlewareService 按名称查找中间件服务，同名时使用最后一个，与中间件服务注册表一致
+func (ph *ProxyHandler) middlewareService(name string) (config.MiddlewareService, bool) {
+	for i := len(ph.cfg.MiddlewareServices) - 1; i >= 0; i-- {
+		if ph.cfg.MiddlewareServices[i].Name == name {
+			return ph.cfg.MiddlewareServices[i], true
+		}
+	}
+	return config.MiddlewareService{}, false
+}
+
+// computeHash 计算计划的哈希，包含候选中间件的顺序、来源、启用状态、生效时间窗口和配置
+func (p *chainPlan) computeHash() string {
+	type hashed struct {
+		Name    string
+		Source  string
+		Toggled bool
+		Enabled bool
+		Windows [][]config.ActiveWindow
+		Key     string
+	}
+	items := make([]hashed, len(p.candidates))
+	for i, c := range p.candidates {
+		items[i] = hashed{c.name, c.source, c.toggled, c.enabled, c.windows, c.key}
+	}
+	return configHash(items)
+}
+
+// configHash 返回配置的哈希，JSON编码时map按键排序，相同的配置得到相同的哈希
+func configHash(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		// 配置中有JSON不支持的值（如非字符串键的map）时按Go语法格式化，同样按键排序
+		data = []byte(fmt.Sprintf("%#v", value))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
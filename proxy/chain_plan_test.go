@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+)
+
+// countingMiddleware 记录创建次数的中间件
+type countingMiddleware struct {
+	name   string
+	config map[string]interface{}
+}
+
+func (m *countingMiddleware) Name() string                    { return m.name }
+func (m *countingMiddleware) Handle(*middleware.Context) bool { return true }
+
+// newCountingFactory 创建注册了names中各中间件的工厂，created记录每个中间件的创建次数
+func newCountingFactory(created map[string]*atomic.Int32, names ...string) middleware.MiddlewareFactory {
+	factory := middleware.NewMiddlewareFactory()
+	for _, name := range names {
+		name := name
+		created[name] = &atomic.Int32{}
+		factory.RegisterMiddleware(name, func(cfg map[string]interface{}) (middleware.Middleware, error) {
+			created[name].Add(1)
+			return &countingMiddleware{name: name, config: cfg}, nil
+		})
+	}
+	return factory
+}
+
+func planTestConfig(limit int) *config.Config {
+	return &config.Config{
+		HostRules: []config.HostRule{
+			{
+				Pattern:     "a.example.com",
+				Target:      "web",
+				Middlewares: []string{"auth"},
+				RouteRules: []config.RouteRule{
+					{Pattern: "/api/*", Target: "web", Middlewares: []string{"limit"}},
+				},
+			},
+			{Pattern: "b.example.com", Target: "web", Middlewares: []string{"limit", "builtin"}},
+		},
+		Middlewares: []config.Middleware{
+			{Name: "auth", Enabled: true, Config: map[string]interface{}{"realm": "a"}},
+			{Name: "limit", Enabled: true, Config: map[string]interface{}{"rps": limit}},
+			{Name: "log", Enabled: true},
+			{Name: "off", Enabled: false},
+		},
+		MiddlewareServices: []config.MiddlewareService{
+			{Name: "audit", Type: "log", Enabled: true, IsGlobal: true, Config: map[string]interface{}{"target": "audit"}},
+		},
+		Services: map[string]config.Service{"web": {URL: "http://127.0.0.1:1"}},
+	}
+}
+
+func chainNames(chain middleware.MiddlewareChain) []string {
+	return chain.GetMiddlewareNames()
+}
+
+func TestChainPlansResolveOnce(t *testing.T) {
+	created := make(map[string]*atomic.Int32)
+	factory := newCountingFactory(created, "auth", "limit", "log", "off", "builtin")
+	ph, err := newProxyHandler(planTestConfig(10), factory, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostRule := &ph.cfg.HostRules[0]
+	routeRule := &hostRule.RouteRules[0]
+	want := []string{"limit", "auth", "log", "log"}
+	for i := 0; i < 100; i++ {
+		names := chainNames(ph.createDynamicMiddlewareChain(hostRule, routeRule, nil))
+		if len(names) != len(want) {
+			t.Fatalf("chain = %v, want %v", names, want)
+		}
+		for j := range want {
+			if names[j] != want[j] {
+				t.Fatalf("chain = %v, want %v", names, want)
+			}
+		}
+	}
+
+	// 每种配置只创建一次，规则之间共享实例，禁用的中间件不创建
+	for name, count := range map[string]int32{"auth": 1, "limit": 1, "log": 2, "off": 0, "builtin": 1} {
+		if got := created[name].Load(); got != count {
+			t.Errorf("%s created %d times, want %d", name, got, count)
+		}
+	}
+}
+
+func TestChainPlansToggleAndBypass(t *testing.T) {
+	created := make(map[string]*atomic.Int32)
+	factory := newCountingFactory(created, "auth", "limit", "log", "off", "builtin")
+	ph, err := newProxyHandler(planTestConfig(10), factory, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostRule := &ph.cfg.HostRules[1]
+
+	toggles := middleware.GetMiddlewareToggles()
+	toggles.Set("off", true)
+	toggles.Set("limit", false)
+	defer toggles.Reset("off")
+	defer toggles.Reset("limit")
+
+	names := chainNames(ph.createDynamicMiddlewareChain(hostRule, nil, nil))
+	want := []string{"builtin", "auth", "log", "off", "log"}
+	if len(names) != len(want) {
+		t.Fatalf("chain = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("chain = %v, want %v", names, want)
+		}
+	}
+	if created["off"].Load() != 1 {
+		t.Errorf("middleware enabled at runtime was created %d times, want 1", created["off"].Load())
+	}
+
+	bypass := &middlewareBypass{names: map[string]bool{"auth": true}}
+	for _, name := range chainNames(ph.createDynamicMiddlewareChain(hostRule, nil, bypass)) {
+		if name == "auth" {
+			t.Error("bypassed middleware in chain")
+		}
+	}
+	if entries := ph.resolveChain(hostRule, nil, &middlewareBypass{all: true}, time.Now()); len(entries) != 0 {
+		t.Errorf("bypass all resolved %d middlewares", len(entries))
+	}
+}
+
+func TestChainPlansReuseOnReload(t *testing.T) {
+	created := make(map[string]*atomic.Int32)
+	factory := newCountingFactory(created, "auth", "limit", "log", "off", "builtin")
+	ph, err := newProxyHandler(planTestConfig(10), factory, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置未变化时沿用所有计划，不创建中间件
+	reloaded, err := ph.Reload(planTestConfig(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, count := range map[string]int32{"auth": 1, "limit": 1, "log": 2, "builtin": 1} {
+		if got := created[name].Load(); got != count {
+			t.Errorf("%s created %d times after reload, want %d", name, got, count)
+		}
+	}
+	for key, plan := range reloaded.plans.rules {
+		if ph.plans.lookup(plan.hash) != plan {
+			t.Errorf("plan for %s was rebuilt", ruleLabel(key.hostRule, key.routeRule))
+		}
+	}
+
+	// 只有使用了变化的中间件配置的计划重新创建，其余中间件沿用原有实例
+	changed, err := reloaded.Reload(planTestConfig(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, count := range map[string]int32{"auth": 1, "limit": 2, "log": 2, "builtin": 1} {
+		if got := created[name].Load(); got != count {
+			t.Errorf("%s created %d times after changing limit, want %d", name, got, count)
+		}
+	}
+
+	// 中间件配置是所有规则的全局候选，计划都重新解析，但只有limit重新创建
+	hostRule := &changed.cfg.HostRules[0]
+	if changed.plans.get(hostRule, nil) == reloaded.plans.get(&reloaded.cfg.HostRules[0], nil) {
+		t.Error("plan with a changed global middleware was reused")
+	}
+
+	// 只修改一个规则的中间件装配时，其他规则的计划沿用
+	cfg := planTestConfig(20)
+	cfg.HostRules[1].Middlewares = []string{"builtin"}
+	rewired, err := changed.Reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewired.plans.get(&rewired.cfg.HostRules[0], nil) != changed.plans.get(hostRule, nil) ||
+		rewired.plans.get(&rewired.cfg.HostRules[0], &rewired.cfg.HostRules[0].RouteRules[0]) != changed.plans.get(hostRule, &hostRule.RouteRules[0]) {
+		t.Error("plans of unchanged rules were rebuilt")
+	}
+	if rewired.plans.get(&rewired.cfg.HostRules[1], nil) == changed.plans.get(&changed.cfg.HostRules[1], nil) {
+		t.Error("plan of the changed rule was reused")
+	}
+
+	auth := func(ph *ProxyHandler) middleware.Middleware {
+		for _, mw := range ph.createDynamicMiddlewareChain(&ph.cfg.HostRules[0], nil, nil).GetMiddlewares() {
+			if mw.Name() == "auth" {
+				return mw
+			}
+		}
+		return nil
+	}
+	if auth(ph) == nil || auth(ph) != auth(changed) {
+		t.Error("unchanged middleware instance was not shared across reloads")
+	}
+}
+
+func TestChainPlanHashIgnoresUnrelatedFields(t *testing.T) {
+	factory := newCountingFactory(make(map[string]*atomic.Int32), "auth", "limit", "log", "off", "builtin")
+	base, err := newProxyHandler(planTestConfig(10), factory, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := planTestConfig(10)
+	cfg.HostRules[0].Target = "other"
+	cfg.HostRules[0].Timeout = 30
+	moved, err := base.Reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.plans.get(&moved.cfg.HostRules[0], nil).hash != base.plans.get(&base.cfg.HostRules[0], nil).hash {
+		t.Error("plan hash depends on fields that do not affect the chain")
+	}
+
+	cfg = planTestConfig(10)
+	cfg.HostRules[0].Middlewares = []string{"limit"}
+	rewired, err := base.Reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewired.plans.get(&rewired.cfg.HostRules[0], nil).hash == base.plans.get(&base.cfg.HostRules[0], nil).hash {
+		t.Error("plan hash did not change with the host rule middlewares")
+	}
+}
+
+func TestChainPlansRecreateAfterPluginReset(t *testing.T) {
+	created := make(map[string]*atomic.Int32)
+	factory := newCountingFactory(created, "auth", "limit", "log", "off", "builtin")
+	ph, err := newProxyHandler(planTestConfig(10), factory, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostRule := &ph.cfg.HostRules[1]
+
+	ph.createDynamicMiddlewareChain(hostRule, nil, nil)
+	middleware.ResetSharedState("builtin")
+	ph.createDynamicMiddlewareChain(hostRule, nil, nil)
+	ph.createDynamicMiddlewareChain(hostRule, nil, nil)
+
+	// 插件重新加载后使用的实例重新创建一次
+	if got := created["builtin"].Load(); got != 2 {
+		t.Errorf("builtin created %d times after reset, want 2", got)
+	}
+}
+
+func TestChainPlansCacheCreateErrors(t *testing.T) {
+	created := make(map[string]*atomic.Int32)
+	factory := newCountingFactory(created, "auth", "limit", "log", "off", "builtin")
+	attempts := 0
+	factory.RegisterMiddleware("broken", func(map[string]interface{}) (middleware.Middleware, error) {
+		attempts++
+		return nil, fmt.Errorf("invalid config")
+	})
+	cfg := planTestConfig(10)
+	cfg.MiddlewareServices = append(cfg.MiddlewareServices, config.MiddlewareService{Name: "rewrite", Type: "broken", Enabled: true})
+	cfg.HostRules[1].Middlewares = []string{"rewrite"}
+	ph, err := newProxyHandler(cfg, factory, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 加载时创建失败一次，之后的请求在重试间隔内不重新创建
+	for i := 0; i < 10; i++ {
+		for _, name := range chainNames(ph.createDynamicMiddlewareChain(&ph.cfg.HostRules[1], nil, nil)) {
+			if name == "broken" {
+				t.Fatal("broken middleware in chain")
+			}
+		}
+	}
+	if attempts != 1 {
+		t.Errorf("broken middleware created %d times, want 1", attempts)
+	}
+
+	// 自检报告创建失败的中间件服务
+	found := false
+	for _, problem := range ph.SelfCheck() {
+		if strings.Contains(problem.Error(), "middleware service 'rewrite'") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("SelfCheck did not report the broken middleware service: %v", ph.SelfCheck())
+	}
+}
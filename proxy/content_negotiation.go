@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"toyou-proxy/config"
+	"toyou-proxy/debuglog"
+)
+
+// contentTargets 返回生效的内容协商目标，优先级：路由级 > 域名级
+func contentTargets(hostRule *config.HostRule, routeRule *config.RouteRule) []config.ContentTarget {
+	if routeRule != nil && len(routeRule.ContentTargets) > 0 {
+		return routeRule.ContentTargets
+	}
+	if hostRule != nil {
+		return hostRule.ContentTargets
+	}
+	return nil
+}
+
+// resolveContentTarget 根据Accept请求头选择目标服务，返回服务名称和服务，未命中时返回nil，继续使用规则的target
+// 配置了内容协商的规则在响应中加上 Vary: Accept，避免共享缓存混用不同服务的响应
+func (ph *ProxyHandler) resolveContentTarget(w http.ResponseWriter, r *http.Request, hostRule *config.HostRule, routeRule *config.RouteRule) (string, *config.Service) {
+	targets := contentTargets(hostRule, routeRule)
+	if len(targets) == 0 {
+		return "", nil
+	}
+	w.Header().Add("Vary", "Accept")
+
+	target := negotiateContentTarget(r.Header.Values("Accept"), targets)
+	if target == nil {
+		return "", nil
+	}
+
+	service, exists := ph.services.Get(target.Target)
+	if !exists {
+		debuglog.Printf("Content negotiation: service '%s' not found, using original target", target.Target)
+		return "", nil
+	}
+
+	debuglog.Printf("Content negotiation: %s %s (%s) -> %s", r.Method, r.URL.Path, target.Type, target.Target)
+	return target.Target, &service
+}
+
+// acceptRange Accept请求头中的一个媒体范围
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// negotiateContentTarget 选择Accept请求头中偏好度最高的目标
+// 媒体类型精确匹配优先于 type/* 匹配，*/* 不选择任何目标；偏好度相同时按配置顺序
+func negotiateContentTarget(accept []string, targets []config.ContentTarget) *config.ContentTarget {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	var best *config.ContentTarget
+	bestQuality := 0.0
+	for i := range targets {
+		quality := acceptQuality(ranges, strings.ToLower(strings.TrimSpace(targets[i].Type)))
+		if quality > bestQuality {
+			best = &targets[i]
+			bestQuality = quality
+		}
+	}
+	return best
+}
+
+// acceptQuality 返回媒体类型在Accept中的偏好度，精确匹配优先于 type/* 匹配
+func acceptQuality(ranges []acceptRange, mediaType string) float64 {
+	wildcard := mediaType[:strings.Index(mediaType, "/")+1] + "*"
+	quality, matched := 0.0, false
+	for _, r := range ranges {
+		if r.mediaType == mediaType {
+			return r.quality
+		}
+		if r.mediaType == wildcard && !matched {
+			quality, matched = r.quality, true
+		}
+	}
+	return quality
+}
+
+// parseAccept 解析Accept请求头，忽略 */* 和无效的媒体范围
+func parseAccept(values []string) []acceptRange {
+	var ranges []acceptRange
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			params := strings.Split(part, ";")
+			mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+			if mediaType == "*/*" || strings.Count(mediaType, "/") != 1 {
+				continue
+			}
+
+			quality := 1.0
+			for _, param := range params[1:] {
+				key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(key), "q") {
+					continue
+				}
+				if q, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil && q >= 0 && q <= 1 {
+					quality = q
+				}
+			}
+			ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+)
+
+// upstreamRequest 后端收到的请求
+type upstreamRequest struct {
+	service string
+	header  http.Header
+}
+
+func TestDarkLaunchStripsSecretFromUpstreamRequest(t *testing.T) {
+	received := make(chan upstreamRequest, 1)
+	newBackend := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- upstreamRequest{service: name, header: r.Header.Clone()}
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	cfg := &config.Config{
+		HostRules: []config.HostRule{{
+			Pattern: "app.example.test",
+			Target:  "web",
+			DarkLaunch: &config.DarkLaunchConfig{
+				Header: "X-Dark-Launch",
+				Cookie: "dark_launch",
+				Secret: "s3cret",
+				Target: "staging",
+			},
+		}},
+		Services: map[string]config.Service{
+			"web":     {URL: newBackend("web").URL},
+			"staging": {URL: newBackend("staging").URL},
+		},
+	}
+	ph, err := newProxyHandler(cfg, middleware.NewMiddlewareFactory(), nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		service string
+		cookie  string
+	}{
+		{"cookie", http.Header{"Cookie": {"session=abc; dark_launch=s3cret; theme=dark"}}, "staging", "session=abc; theme=dark"},
+		{"only cookie", http.Header{"Cookie": {"dark_launch=s3cret"}}, "staging", ""},
+		{"separate cookie headers", http.Header{"Cookie": {"session=abc", "dark_launch=s3cret"}}, "staging", "session=abc"},
+		{"header", http.Header{"X-Dark-Launch": {"s3cret"}, "Cookie": {"dark_launch=s3cret; session=abc"}}, "staging", "session=abc"},
+		{"wrong secret", http.Header{"Cookie": {"session=abc; dark_launch=guess"}}, "web", "session=abc; dark_launch=guess"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = "app.example.test"
+		r.Header = tt.header
+		ph.ServeHTTP(httptest.NewRecorder(), r)
+
+		got := <-received
+		if got.service != tt.service {
+			t.Errorf("%s: forwarded to %s, want %s", tt.name, got.service, tt.service)
+		}
+		if cookie := got.header.Get("Cookie"); cookie != tt.cookie {
+			t.Errorf("%s: upstream Cookie = %q, want %q", tt.name, cookie, tt.cookie)
+		}
+		if value := got.header.Get("X-Dark-Launch"); value != "" {
+			t.Errorf("%s: upstream X-Dark-Launch = %q", tt.name, value)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"toyou-proxy/config"
+	"toyou-proxy/dump"
+	"toyou-proxy/middleware"
+)
+
+// beginDump 根据规则配置决定是否转储当前请求，优先级：路由级 > 域名级
+func (ph *ProxyHandler) beginDump(ctx *middleware.Context, hostRule *config.HostRule, routeRule *config.RouteRule) *dump.Capture {
+	var cfg *config.DumpConfig
+	if hostRule != nil {
+		cfg = hostRule.Dump
+	}
+	if routeRule != nil && routeRule.Dump != nil {
+		cfg = routeRule.Dump
+	}
+	if cfg == nil {
+		return nil
+	}
+
+	capture := dump.Begin(ctx.Request, *cfg, ctx.RequestID, ctx.Route)
+	if capture != nil {
+		ctx.Set(dump.CaptureKey, capture)
+	}
+	return capture
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+
+	"toyou-proxy/config"
+	"toyou-proxy/debuglog"
+	"toyou-proxy/matcher"
+	"toyou-proxy/middleware"
+)
+
+// middlewareBypass 内部路径请求跳过的中间件
+type middlewareBypass struct {
+	all   bool            // 跳过整个中间件链
+	names map[string]bool // 跳过的中间件名称
+}
+
+// skips 判断是否跳过指定中间件，bypass为nil时不跳过任何中间件
+func (b *middlewareBypass) skips(name string) bool {
+	return b != nil && (b.all || b.names[name])
+}
+
+// internalPath 编译好的内部路径规则，随代理处理器在加载配置时创建
+type internalPath struct {
+	patterns []*matcher.PathPattern
+	config.InternalPathRule
+}
+
+// compileInternalPaths 编译内部路径规则的路径模式，无效的模式已在配置验证时拒绝，这里跳过
+func compileInternalPaths(rules []config.InternalPathRule) []internalPath {
+	compiled := make([]internalPath, 0, len(rules))
+	for _, rule := range rules {
+		ip := internalPath{InternalPathRule: rule}
+		for _, pattern := range rule.Paths {
+			if p, err := matcher.CompilePathPattern(pattern); err == nil {
+				ip.patterns = append(ip.patterns, p)
+			}
+		}
+		compiled = append(compiled, ip)
+	}
+	return compiled
+}
+
+// internalPathBypass 查找请求匹配的内部路径规则，返回需要跳过的中间件，未匹配时返回nil
+// 多条规则匹配时合并跳过的中间件；按规范化后的路径匹配，/debug/../admin 不会匹配 /debug/*
+func (ph *ProxyHandler) internalPathBypass(r *http.Request) *middlewareBypass {
+	var bypass *middlewareBypass
+	path := cleanPath(r.URL.Path)
+	for _, rule := range ph.internalPaths {
+		// 没有配置网段的规则不生效，不使用代理标识响应头的默认私有网段
+		if len(rule.Networks) == 0 || !matchesAnyPath(rule.patterns, path) || !isInternalCaller(r, rule.Networks) {
+			continue
+		}
+		if bypass == nil {
+			bypass = &middlewareBypass{names: make(map[string]bool)}
+		}
+		if len(rule.Middlewares) == 0 {
+			bypass.all = true
+		}
+		for _, name := range rule.Middlewares {
+			bypass.names[name] = true
+		}
+	}
+
+	if bypass != nil {
+		debuglog.Printf("Internal path %s from %s bypasses middlewares (all: %v)", path, r.RemoteAddr, bypass.all)
+	}
+	return bypass
+}
+
+// matchesAnyPath 判断路径是否匹配任一模式
+func matchesAnyPath(patterns []*matcher.PathPattern, path string) bool {
+	for _, pattern := range patterns {
+		if pattern.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRuleChecks 在链尾加入规则要求的授权检查和会话限制，内部路径可以跳过它们
+func (ph *ProxyHandler) applyRuleChecks(chain middleware.MiddlewareChain, ctx *middleware.Context, hostRule *config.HostRule, routeRule *config.RouteRule, bypass *middlewareBypass) bool {
+	if !bypass.skips("authorization") && !ph.applyAuthorization(chain, ctx, hostRule, routeRule) {
+		return false
+	}
+	return bypass.skips("session_limit") || ph.applySessionLimit(chain, ctx, hostRule)
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"toyou-proxy/config"
+)
+
+func TestInternalPathBypassMatchesCleanPath(t *testing.T) {
+	ph := &ProxyHandler{internalPaths: compileInternalPaths([]config.InternalPathRule{
+		{Paths: []string{"/debug/*"}, Networks: []string{"10.0.0.0/8"}},
+		{Paths: []string{"/healthz"}},
+	})}
+	bypass := func(target, remoteAddr string) *middlewareBypass {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = remoteAddr
+		return ph.internalPathBypass(r)
+	}
+
+	if b := bypass("/debug/pprof", "10.1.2.3:4567"); b == nil || !b.all {
+		t.Errorf("internal caller on /debug/pprof: bypass = %+v", b)
+	}
+	// 点段和重复斜杠规范化后再匹配，上游会把/debug/../admin解析为/admin
+	for _, target := range []string{"/debug/../admin", "/debug/./../admin", "//debug/..//admin"} {
+		if b := bypass(target, "10.1.2.3:4567"); b != nil {
+			t.Errorf("%s bypassed the middlewares", target)
+		}
+	}
+	if b := bypass("/debug//pprof", "10.1.2.3:4567"); b == nil {
+		t.Error("/debug//pprof did not match /debug/*")
+	}
+	if b := bypass("/debug/pprof", "203.0.113.7:4567"); b != nil {
+		t.Error("external caller bypassed the middlewares")
+	}
+	// 没有配置网段的规则不使用默认的私有网段
+	if b := bypass("/healthz", "127.0.0.1:4567"); b != nil {
+		t.Error("rule without networks bypassed the middlewares")
+	}
+}
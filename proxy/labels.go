@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+)
+
+// routeLabelsInfo 规则标签信息指标，其他指标按route标签与之关联即可按团队、产品等维度汇总
+var routeLabelsInfo = metrics.GetDefaultRegistry().NewInfoVec(
+	"toyou_proxy_route_labels",
+	"Labels configured on host and route rules, join on the route label.",
+)
+
+// ruleLabels 合并域名级和路由级标签，路由级同名标签优先，没有标签时返回nil
+func ruleLabels(hostRule *config.HostRule, routeRule *config.RouteRule) map[string]string {
+	var labels map[string]string
+	merge := func(values map[string]string) {
+		for name, value := range values {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[name] = value
+		}
+	}
+
+	if hostRule != nil {
+		merge(hostRule.Labels)
+	}
+	if routeRule != nil {
+		merge(routeRule.Labels)
+	}
+	return labels
+}
+
+// publishRouteLabels 发布所有规则的标签，配置重新加载时替换原有的样本
+func publishRouteLabels(hostRules []config.HostRule) {
+	routeLabelsInfo.Reset()
+
+	publish := func(hostRule *config.HostRule, routeRule *config.RouteRule) {
+		labels := ruleLabels(hostRule, routeRule)
+		if labels == nil {
+			return
+		}
+		labels["route"] = ruleLabel(hostRule, routeRule)
+		routeLabelsInfo.Set(labels)
+	}
+
+	for i := range hostRules {
+		hostRule := &hostRules[i]
+		publish(hostRule, nil)
+		for j := range hostRule.RouteRules {
+			publish(hostRule, &hostRule.RouteRules[j])
+		}
+	}
+}
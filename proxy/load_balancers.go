@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+
+	"toyou-proxy/config"
+	"toyou-proxy/loadbalancer"
+	"toyou-proxy/registry"
+)
+
+// lbState 当前生效的负载均衡配置，重新加载和运行时服务变化时只替换配置变化的负载均衡器
+var lbState struct {
+	sync.Mutex
+	mgr      loadbalancer.LoadBalancerManager
+	named    map[string]config.LoadBalancerConfig // 配置文件中的命名负载均衡器
+	applied  map[string]config.LoadBalancerConfig // 已创建的负载均衡器及其配置
+	watching bool
+}
+
+// loadBalancerName 返回服务使用的负载均衡器名称：引用的命名负载均衡器或服务自己的负载均衡器（与服务同名），
+// 服务没有配置负载均衡时返回空字符串
+func loadBalancerName(serviceName string, service *config.Service) string {
+	if service.LoadBalancerRef != "" {
+		return service.LoadBalancerRef
+	}
+	if service.LoadBalancer != nil {
+		return serviceName
+	}
+	return ""
+}
+
+// configureLoadBalancers 设置配置文件中的命名负载均衡器，并按服务注册表同步负载均衡器；
+// 之后通过管理API注册或注销运行时服务时自动重新同步
+func configureLoadBalancers(mgr loadbalancer.LoadBalancerManager, named map[string]config.LoadBalancerConfig, services registry.ServiceRegistry) {
+	lbState.Lock()
+	lbState.mgr = mgr
+	lbState.named = named
+	watch := !lbState.watching
+	lbState.watching = true
+	lbState.Unlock()
+
+	if watch {
+		services.Watch(func() { reconcileLoadBalancers(services) })
+	}
+	reconcileLoadBalancers(services)
+}
+
+// reconcileLoadBalancers 计算命名负载均衡器和所有服务（含运行时服务）需要的负载均衡器，与已创建的比较后同步
+func reconcileLoadBalancers(services registry.ServiceRegistry) {
+	lbState.Lock()
+	defer lbState.Unlock()
+
+	desired := make(map[string]config.LoadBalancerConfig, len(lbState.named))
+	for name, lb := range lbState.named {
+		desired[name] = lb
+	}
+	for name, service := range services.List() {
+		if service.LoadBalancer == nil {
+			continue
+		}
+		if _, exists := lbState.named[name]; exists {
+			log.Printf("Load balancer of service %s ignored: the name is used by a load balancer in load_balancers", name)
+			continue
+		}
+		desired[name] = *service.LoadBalancer
+	}
+
+	lbState.applied = syncLoadBalancers(lbState.mgr, lbState.applied, desired)
+}
+
+// syncLoadBalancers 按需要的配置创建、替换或删除负载均衡器，返回同步后生效的配置；
+// 负载均衡配置没有变化的负载均衡器保持不变，后端的健康状态和统计不会丢失
+func syncLoadBalancers(mgr loadbalancer.LoadBalancerManager, applied, desired map[string]config.LoadBalancerConfig) map[string]config.LoadBalancerConfig {
+	result := make(map[string]config.LoadBalancerConfig, len(desired))
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cfg := desired[name]
+		lbConfig := loadbalancer.ConvertConfig(&cfg)
+		// 设置默认值
+		loadbalancer.SetDefaultValues(&lbConfig)
+
+		if old, exists := applied[name]; exists {
+			if reflect.DeepEqual(old, cfg) {
+				result[name] = cfg
+				continue
+			}
+			if err := mgr.UpdateLoadBalancer(name, lbConfig); err != nil {
+				log.Printf("Failed to update load balancer %s: %v", name, err)
+				result[name] = old
+				continue
+			}
+			log.Printf("Load balancer updated for %s with strategy %s", name, lbConfig.Strategy)
+			result[name] = cfg
+			continue
+		}
+
+		// 创建负载均衡器
+		if err := mgr.CreateLoadBalancer(name, lbConfig); err != nil {
+			log.Printf("Failed to create load balancer %s: %v", name, err)
+			continue
+		}
+		log.Printf("Load balancer created for %s with strategy %s", name, lbConfig.Strategy)
+		result[name] = cfg
+	}
+
+	// 删除不再需要的负载均衡器
+	for name := range applied {
+		if _, exists := desired[name]; exists {
+			continue
+		}
+		if err := mgr.DeleteLoadBalancer(name); err != nil {
+			log.Printf("Failed to delete load balancer %s: %v", name, err)
+			continue
+		}
+		log.Printf("Load balancer removed for %s", name)
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"toyou-proxy/config"
+)
+
+// allowedMethods 返回允许的请求方法，优先级：路由级 > 域名级，为空表示不限制
+// 允许GET时隐含允许HEAD
+func (ph *ProxyHandler) allowedMethods(hostRule *config.HostRule, routeRule *config.RouteRule) []string {
+	var methods []string
+	if hostRule != nil && len(hostRule.AllowedMethods) > 0 {
+		methods = hostRule.AllowedMethods
+	}
+	if routeRule != nil && len(routeRule.AllowedMethods) > 0 {
+		methods = routeRule.AllowedMethods
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+
+	allowed := make([]string, 0, len(methods)+1)
+	seen := make(map[string]bool, len(methods)+1)
+	add := func(method string) {
+		if !seen[method] {
+			seen[method] = true
+			allowed = append(allowed, method)
+		}
+	}
+	for _, method := range methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		add(method)
+		if method == http.MethodGet {
+			add(http.MethodHead)
+		}
+	}
+	return allowed
+}
+
+// checkMethod 检查请求方法是否允许，不允许时返回405并在Allow头中列出允许的方法
+func (ph *ProxyHandler) checkMethod(w http.ResponseWriter, r *http.Request, hostRule *config.HostRule, routeRule *config.RouteRule) bool {
+	allowed := ph.allowedMethods(hostRule, routeRule)
+	if allowed == nil {
+		return true
+	}
+
+	for _, method := range allowed {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	rejectRequest(w, r, http.StatusMethodNotAllowed, rejectMethodNotAllowed)
+	return false
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"log"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+	"toyou-proxy/minify"
+)
+
+// applyMinify 根据规则配置启用响应压缩，优先级：路由级 > 域名级
+func (ph *ProxyHandler) applyMinify(ctx *middleware.Context, hostRule *config.HostRule, routeRule *config.RouteRule) {
+	var cfg *config.MinifyConfig
+	if hostRule != nil {
+		cfg = hostRule.Minify
+	}
+	if routeRule != nil && routeRule.Minify != nil {
+		cfg = routeRule.Minify
+	}
+
+	minifier, err := minify.New(cfg)
+	if err != nil {
+		log.Printf("Invalid minify config: %v", err)
+		return
+	}
+	if minifier == nil {
+		return
+	}
+
+	ctx.Set(minify.MinifierKey, minifier)
+	// 需要未编码的响应体，上游到代理之间的压缩由Transport透明处理
+	ctx.Request.Header.Del("Accept-Encoding")
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"toyou-proxy/config"
+)
+
+// normalizeRequest 在路由匹配前规范化请求
+// 路径总是规范化：合并重复斜杠、解析 /./ 和 /../，避免通过非规范路径绕过路由规则、路径黑名单和认证中间件；
+// 开启enabled时域名转为小写并去掉末尾的点，开启sort_query时按参数名排序查询参数，便于缓存
+func normalizeRequest(r *http.Request, cfg config.NormalizeConfig) {
+	normalizePath(r.URL)
+
+	if cfg.Enabled {
+		r.Host = strings.TrimSuffix(strings.ToLower(r.Host), ".")
+	}
+	if cfg.SortQuery && r.URL.RawQuery != "" {
+		r.URL.RawQuery = sortQuery(r.URL.RawQuery)
+	}
+}
+
+// normalizePath 规范化URL的路径，OPTIONS * 请求的路径保持不变
+func normalizePath(u *url.URL) {
+	if u.Path == "*" {
+		return
+	}
+
+	cleaned := cleanPath(u.Path)
+	if u.RawPath != "" {
+		// 路径中含有编码字符（如%2F）时同时规范化编码形式，保证两者一致
+		cleanedRaw := cleanPath(u.RawPath)
+		if unescaped, err := url.PathUnescape(cleanedRaw); err == nil && unescaped == cleaned {
+			u.RawPath = cleanedRaw
+		} else {
+			u.RawPath = ""
+		}
+	}
+	u.Path = cleaned
+}
+
+// cleanPath 合并重复斜杠并解析点段，保留末尾斜杠
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// sortQuery 按参数名稳定排序查询参数，保留原始编码和同名参数的顺序
+func sortQuery(rawQuery string) string {
+	params := strings.Split(rawQuery, "&")
+	sort.SliceStable(params, func(i, j int) bool {
+		return queryKey(params[i]) < queryKey(params[j])
+	})
+	return strings.Join(params, "&")
+}
+
+// queryKey 返回查询参数的参数名部分
+func queryKey(param string) string {
+	if idx := strings.Index(param, "="); idx != -1 {
+		return param[:idx]
+	}
+	return param
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+)
+
+func TestNormalizeRequestAlwaysCleansPath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/public/../admin", "/admin"},
+		{"//admin//users/", "/admin/users/"},
+		{"/a/./b/../c", "/a/c"},
+		{"/files/a%2Fb/../c", "/files/a/c"}, // 按解码后的路径规范化，与路由匹配一致
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://Example.COM."+test.target+"?b=2&a=1", nil)
+		normalizeRequest(r, config.NormalizeConfig{})
+		if r.URL.Path != test.want {
+			t.Errorf("path of %s = %q, want %q", test.target, r.URL.Path, test.want)
+		}
+		// 可选部分默认关闭
+		if r.Host != "Example.COM." || r.URL.RawQuery != "b=2&a=1" {
+			t.Errorf("disabled options changed the request: host %q, query %q", r.Host, r.URL.RawQuery)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://Example.COM./x?b=2&a=1", nil)
+	normalizeRequest(r, config.NormalizeConfig{Enabled: true, SortQuery: true})
+	if r.Host != "example.com" || r.URL.RawQuery != "a=1&b=2" {
+		t.Errorf("enabled options: host %q, query %q", r.Host, r.URL.RawQuery)
+	}
+
+	r = httptest.NewRequest(http.MethodOptions, "*", nil)
+	normalizeRequest(r, config.NormalizeConfig{})
+	if r.URL.Path != "*" {
+		t.Errorf("OPTIONS * path = %q", r.URL.Path)
+	}
+}
+
+func TestDotSegmentsCannotBypassRouteRules(t *testing.T) {
+	paths := make(chan string, 2)
+	newBackend := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths <- name + " " + r.URL.Path
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	cfg := &config.Config{
+		HostRules: []config.HostRule{{
+			Pattern:    "app.example.test",
+			Target:     "web",
+			RouteRules: []config.RouteRule{{Pattern: "/admin/*", Target: "admin"}},
+		}},
+		Services: map[string]config.Service{
+			"web":   {URL: newBackend("web").URL},
+			"admin": {URL: newBackend("admin").URL},
+		},
+	}
+	ph, err := newProxyHandler(cfg, middleware.NewMiddlewareFactory(), nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 未开启advanced.normalize时，/public/../admin/ 同样匹配 /admin/* 的路由规则
+	r := httptest.NewRequest(http.MethodGet, "/public/../admin/users", nil)
+	r.Host = "app.example.test"
+	ph.ServeHTTP(httptest.NewRecorder(), r)
+	if got := <-paths; got != "admin /admin/users" {
+		t.Errorf("upstream request = %q, want the admin service with the clean path", got)
+	}
+}
@@ -2,119 +2,276 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"toyou-proxy/clientip"
 	"toyou-proxy/config"
+	"toyou-proxy/debuglog"
+	"toyou-proxy/dump"
+	"toyou-proxy/inflight"
 	"toyou-proxy/loadbalancer"
-	"toyou-proxy/matcher"
 	"toyou-proxy/middleware"
+	"toyou-proxy/middleware/builtin/imageproxy"
+	"toyou-proxy/middleware/builtin/masking"
+	"toyou-proxy/middleware/builtin/requestsigning"
+	"toyou-proxy/minify"
+	"toyou-proxy/privacy"
+	"toyou-proxy/registry"
+	"toyou-proxy/secrets"
+	"toyou-proxy/security"
+	"toyou-proxy/slo"
+	"toyou-proxy/stores"
 )
 
 // ProxyHandler 代理处理器
 type ProxyHandler struct {
-	hostMatcher     *matcher.HostMatcher
-	services        map[string]config.Service
+	routes          *routeTable              // 不区分端口的路由表
+	services        registry.ServiceRegistry // 运行时服务注册表
 	middlewareChain middleware.MiddlewareChain
+	plans           *chainPlans // 每个规则的中间件链计划
 	factory         middleware.MiddlewareFactory
 	autoPluginMgr   *middleware.AutoPluginManager // 自动插件管理器
+	plugins         []string                      // 注册成功的插件
+	pluginErrors    map[string]error              // 编译或加载失败的插件
+	pathFilter      *security.PathFilter          // 请求路径过滤器
+	limits          *requestLimits                // 请求大小限制
+	internalPaths   []internalPath                // 编译好的内部路径规则
+	admission       *admissionController          // 过载保护，未配置时为nil
 	cfg             *config.Config
 	loadBalancerMgr loadbalancer.LoadBalancerManager // 负载均衡器管理器
+	generation      uint64                           // 配置版本，每次重新加载加1，记录在正在处理的请求中
 }
 
 // NewProxyHandler 创建新的代理处理器
 func NewProxyHandler(cfg *config.Config) (*ProxyHandler, error) {
-	// 初始化中间件服务注册表
-	if err := middleware.InitMiddlewareServiceRegistry(cfg); err != nil {
-		log.Printf("Failed to initialize middleware service registry: %v", err)
-	}
-
 	// 创建中间件工厂
 	factory := middleware.NewMiddlewareFactory()
 
-	// 确保缓存目录存在
-	cacheDir := "cache/plugins"
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		log.Printf("Failed to create cache directory: %v", err)
+	// 插件目录
+	cacheDir := cfg.Plugins.CacheDir
+	if cacheDir == "" {
+		cacheDir = middleware.DefaultPluginCacheDir
+	}
+	pluginSourceDir := cfg.Plugins.SourceDir
+	if pluginSourceDir == "" {
+		pluginSourceDir = middleware.DefaultPluginSourceDir
+	}
+
+	// 检查插件目录权限，不满足要求时拒绝启动
+	if middleware.PluginsSupported {
+		if err := middleware.CheckPluginDirs(pluginSourceDir, cacheDir, cfg.Plugins.PrecompiledOnly); err != nil {
+			return nil, err
+		}
 	}
 
 	// 创建自动插件管理器
-	pluginSourceDir := "middleware/plugins"
 	autoPluginMgr := middleware.NewAutoPluginManager(pluginSourceDir, cacheDir)
+	autoPluginMgr.SetPrecompiledOnly(cfg.Plugins.PrecompiledOnly)
+
+	// 注册内置中间件，同名插件会覆盖内置实现
+	middleware.RegisterBuiltins(factory)
 
 	// 自动发现并注册所有插件
-	if err := registerAllPlugins(factory, autoPluginMgr); err != nil {
+	plugins, pluginErrors, err := registerAllPlugins(factory, autoPluginMgr)
+	if err != nil {
 		log.Printf("Failed to register some plugins: %v", err)
 	}
 
-	// 创建域名匹配器
-	hostMatcher := matcher.NewHostMatcher()
+	return newProxyHandler(cfg, factory, autoPluginMgr, plugins, pluginErrors, nil)
+}
+
+// Reload 根据新配置创建代理处理器，复用已注册的内置中间件和插件（插件配置的变化需要重启）
+// 新处理器创建成功后才会更新全局状态（密钥、脱敏规则、服务注册表、负载均衡器等），失败时当前处理器不受影响；
+// 中间件装配和配置未变化的规则沿用当前的中间件链计划和中间件实例
+func (ph *ProxyHandler) Reload(cfg *config.Config) (*ProxyHandler, error) {
+	next, err := newProxyHandler(cfg, ph.factory, ph.autoPluginMgr, ph.plugins, ph.pluginErrors, ph.plans)
+	if err != nil {
+		return nil, err
+	}
+	next.generation = ph.generation + 1
+	return next, nil
+}
+
+// Generation 返回处理器的配置版本，正在处理的请求（见inflight.Request）记录开始时的版本
+func (ph *ProxyHandler) Generation() uint64 {
+	return ph.generation
+}
+
+// newProxyHandler 使用已注册中间件的工厂创建代理处理器，重新加载时复用插件、中间件工厂和未变化的中间件链计划
+// 先完成所有可能失败的步骤，再更新全局状态
+func newProxyHandler(cfg *config.Config, factory middleware.MiddlewareFactory, autoPluginMgr *middleware.AutoPluginManager, plugins []string, pluginErrors map[string]error, previousPlans *chainPlans) (*ProxyHandler, error) {
+	// 创建路径过滤器
+	pathFilter, err := security.NewPathFilter(cfg.Advanced.Security)
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查日志脱敏规则
+	if _, err := privacy.NewScrubber(cfg.Advanced.Privacy); err != nil {
+		return nil, err
+	}
+
+	// 过载保护
+	admission, err := newAdmissionController(cfg.Advanced.Admission)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析请求限制
+	limits, err := newRequestLimits(cfg.Advanced.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	// 设置密钥提供方，加载时解析中间件配置中的所有密钥引用
+	if err := secrets.Configure(cfg.Secrets, middlewareConfigs(cfg)...); err != nil {
+		return nil, fmt.Errorf("secrets: %v", err)
+	}
+
+	// 初始化中间件服务注册表
+	if err := middleware.InitMiddlewareServiceRegistry(cfg); err != nil {
+		log.Printf("Failed to initialize middleware service registry: %v", err)
+	}
+
+	// 登记配置中的中间件，启用状态可以通过管理API在运行时覆盖
+	configuredMiddlewares := make(map[string]bool, len(cfg.Middlewares))
+	for _, mwConfig := range cfg.Middlewares {
+		configuredMiddlewares[mwConfig.Name] = mwConfig.Enabled
+	}
+	configuredServices := make(map[string]bool, len(cfg.MiddlewareServices))
+	for _, service := range cfg.MiddlewareServices {
+		configuredServices[service.Name] = service.Enabled
+	}
+	middleware.GetMiddlewareToggles().LoadConfigured(configuredMiddlewares, configuredServices)
+
+	// 设置日志脱敏规则
+	if err := privacy.Configure(cfg.Advanced.Privacy); err != nil {
+		return nil, err
+	}
+
+	// 设置识别客户端IP的可信代理
+	if err := clientip.Configure(cfg.Advanced.TrustedProxies); err != nil {
+		return nil, err
+	}
+
+	// 设置多个实例共享的状态存储
+	stores.Configure(cfg.Stores)
+
+	// 设置中间件链追踪和调试日志
+	middleware.ConfigureChainTrace(cfg.Advanced.ChainTrace)
+	debuglog.Configure(cfg.Advanced.DebugLog)
+
+	// 创建不区分监听器的路由表，监听器级视图通过ForListener创建
+	routes := newRouteTable(cfg.HostRules)
 	for _, rule := range cfg.HostRules {
-		hostMatcher.AddRule(rule.Pattern, rule.Target)
 		log.Printf("Added host rule: %s -> %s (port: %d)", rule.Pattern, rule.Target, rule.Port)
 	}
+	publishRouteLabels(cfg.HostRules)
+
+	// 设置域名规则的延迟SLO，配置未变化的规则保留已有的统计
+	slo.GetDefaultTracker().Configure(cfg.HostRules)
+
+	// 使用配置文件中的服务初始化服务注册表，运行时注册的服务会被保留
+	serviceRegistry := registry.GetDefaultRegistry()
+	serviceRegistry.LoadStatic(cfg.Services)
+
+	// 为连接预热调整连接池大小
+	configureWarmupTransport(cfg.Services)
+
+	// 为命名负载均衡器和所有配置了负载均衡的服务创建负载均衡器，重新加载时只替换配置变化的负载均衡器
+	loadBalancerMgr := loadbalancer.GetDefaultManager()
+	configureLoadBalancers(loadBalancerMgr, cfg.LoadBalancers, serviceRegistry)
 
-	// 创建中间件链
-	middlewareChain := middleware.NewMiddlewareChain()
+	ph := &ProxyHandler{
+		routes:          routes,
+		services:        serviceRegistry,
+		factory:         factory,
+		autoPluginMgr:   autoPluginMgr,
+		plugins:         plugins,
+		pluginErrors:    pluginErrors,
+		pathFilter:      pathFilter,
+		limits:          limits,
+		internalPaths:   compileInternalPaths(cfg.Advanced.InternalPaths),
+		admission:       admission,
+		cfg:             cfg,
+		loadBalancerMgr: loadBalancerMgr,
+	}
 
+	// 创建每个规则的中间件链计划和其中启用的中间件
+	ph.plans = ph.buildChainPlans(previousPlans)
+
+	// 全局中间件链，与链计划共享中间件实例
+	ph.middlewareChain = middleware.NewMiddlewareChain()
 	for _, mwConfig := range cfg.Middlewares {
 		if !mwConfig.Enabled {
 			continue
 		}
 
-		mw, err := factory.CreateMiddleware(mwConfig.Name, mwConfig.Config)
+		var candidate chainCandidate
+		ph.bindMiddleware(&candidate, mwConfig, ph.plans.pool)
+		mw, err := candidate.instance.get()
 		if err != nil {
 			log.Printf("Failed to create middleware %s: %v", mwConfig.Name, err)
 			continue
 		}
 
-		middlewareChain.Add(mw)
+		ph.middlewareChain.Add(mw)
 		log.Printf("Middleware %s loaded", mwConfig.Name)
 	}
 
-	// 创建负载均衡器管理器
-	loadBalancerMgr := loadbalancer.GetDefaultManager()
+	return ph, nil
+}
 
-	// 为所有配置了负载均衡的服务创建负载均衡器
-	for serviceName, service := range cfg.Services {
-		if lbConfig, hasLB := loadbalancer.ConvertServiceConfig(&service); hasLB {
-			// 设置默认值
-			loadbalancer.SetDefaultValues(&lbConfig)
+// ServeHTTP 处理HTTP请求，使用不区分端口的路由表
+func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ph.serve(w, r, ph.routes)
+}
 
-			// 创建负载均衡器
-			err := loadBalancerMgr.CreateLoadBalancer(serviceName, lbConfig)
-			if err != nil {
-				log.Printf("Failed to create load balancer for service %s: %v", serviceName, err)
-				continue
-			}
+// serve 使用指定的路由表处理HTTP请求
+func (ph *ProxyHandler) serve(w http.ResponseWriter, r *http.Request, routes *routeTable) {
+	startTime := time.Now()
 
-			log.Printf("Load balancer created for service %s with strategy %s", serviceName, lbConfig.Strategy)
+	// 代理过载时尽早拒绝，避免排队的请求拖垮进程
+	if ph.admission != nil {
+		if reason := ph.admission.admit(); reason != "" {
+			ph.admission.reject(w, r, reason)
+			return
 		}
+		defer ph.admission.release()
 	}
 
-	return &ProxyHandler{
-		hostMatcher:     hostMatcher,
-		services:        cfg.Services,
-		middlewareChain: middlewareChain,
-		factory:         factory,
-		autoPluginMgr:   autoPluginMgr,
-		cfg:             cfg,
-		loadBalancerMgr: loadBalancerMgr,
-	}, nil
-}
+	// 登记正在处理的请求，关闭期间可以通过管理API查看和强制关闭，重新加载后可以关闭仍在使用旧配置的流
+	r, untrack := inflight.GetDefaultTracker().Track(r, ph.generation)
+	defer untrack()
 
-// ServeHTTP 处理HTTP请求
-func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
+	// 拒绝超过大小限制的异常请求
+	if status, reason := ph.limits.check(r); status != 0 {
+		rejectRequest(w, r, status, reason)
+		return
+	}
+	ph.limits.limitBody(w, r)
+
+	// 路由匹配前规范化请求
+	normalizeRequest(r, ph.cfg.Advanced.Normalize)
+
+	// 拒绝访问隐藏文件和黑名单路径
+	if denied, reason := ph.pathFilter.Denied(r.URL.Path); denied {
+		rejectedRequests.Inc(rejectPathDenied)
+		log.Printf("Denied request: %s %s [%s]: %s", r.Method, r.URL.Path, r.Host, reason)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	// 创建中间件上下文
 	ctx := &middleware.Context{
@@ -122,27 +279,31 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Response: w,
 		Values:   make(map[string]interface{}),
 	}
+	defer ctx.Complete()
+
+	// 请求ID贯穿日志、指标和上游请求
+	ctx.RequestID = ensureRequestID(w, r)
 
 	// 检测是否是WebSocket请求
-	isWebSocketRequest := ph.detectWebSocketRequest(r)
+	isWebSocketRequest := isWebSocketUpgrade(r)
 	if isWebSocketRequest {
 		ctx.Set("isWebSocketConnection", true)
-		log.Printf("WebSocket request detected: %s %s", r.Method, r.URL.Path)
+		debuglog.Printf("WebSocket request detected: %s %s", r.Method, r.URL.Path)
 	}
 
 	// 自动检测SSE请求
 	isSSE := ph.detectSSERequest(r)
 	if isSSE {
 		ctx.Set("isSSEConnection", true)
-		log.Printf("SSE connection detected for: %s %s", r.Method, r.URL.Path)
+		debuglog.Printf("SSE connection detected for: %s %s", r.Method, r.URL.Path)
 	}
 
 	// 确定目标服务和匹配的路由规则
-	targetService, hostRule, routeRule, err := ph.determineTarget(r)
+	targetService, hostRule, routeRule, err := ph.determineTarget(r, routes)
 	if err != nil {
 		// 为WebSocket连接提供特殊错误处理
 		if isWebSocketRequest {
-			ph.handleWebSocketError(w, fmt.Sprintf("Target service not found: %v", err))
+			ph.handleWebSocketError(w, http.StatusBadRequest, fmt.Sprintf("Target service not found: %v", err))
 			return
 		}
 
@@ -155,24 +316,91 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Failed to determine target: %v", err)
 		return
 	}
+	ctx.Route = ruleLabel(hostRule, routeRule)
+	ctx.Labels = ruleLabels(hostRule, routeRule)
+	serviceName := ruleTarget(hostRule, routeRule)
+
+	// 请求完成后计入域名规则的延迟SLO，SSE和WebSocket长连接不计入
+	if hostRule != nil && hostRule.SLO != nil && !isSSE && !isWebSocketRequest {
+		defer func() {
+			slo.GetDefaultTracker().Observe(hostRule.Pattern, time.Since(startTime))
+		}()
+	}
+
+	// 按采样比例或调试请求头追踪中间件链
+	chainTracer := middleware.GetChainTracer()
+	chainTracer.Begin(ctx)
+
+	// 在中间件和后端之前拒绝不允许的请求方法
+	if !ph.checkMethod(w, r, hostRule, routeRule) {
+		return
+	}
+
+	// 按Accept请求头选择目标服务
+	if name, contentService := ph.resolveContentTarget(w, r, hostRule, routeRule); contentService != nil {
+		serviceName, targetService = name, contentService
+	}
+
+	// 检查暗发布规则
+	if name, darkService := ph.resolveDarkLaunch(r, hostRule, routeRule); darkService != nil {
+		serviceName, targetService = name, darkService
+	}
+
+	// 设置请求截止时间，超时或客户端断开时取消中间件和上游请求
+	// SSE和WebSocket是长连接，不设置截止时间
+	if timeout := ph.requestTimeout(hostRule, routeRule); timeout > 0 && !isSSE && !isWebSocketRequest {
+		reqCtx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(reqCtx)
+		ctx.Request = r
+	}
+
+	// 上游响应体大小上限，由反向代理在转发响应时检查
+	if limit := ph.maxResponseSize(hostRule, routeRule); limit > 0 {
+		ctx.Set("maxResponseSize", limit)
+	}
+
+	// 上游请求失败时的重试策略，由反向代理的传输层执行
+	if policy := ph.retryPolicy(hostRule, routeRule); policy != nil {
+		ctx.Set("retryPolicy", policy)
+	}
+
+	// 是否添加代理标识响应头（X-Proxy-By等）
+	ctx.Set("proxyHeaders", ph.proxyHeadersEnabled(r, hostRule))
+
+	// 配置的静态响应头，由反向代理在转发响应时添加
+	if headers := ph.responseHeaders(hostRule, routeRule); headers != nil {
+		ctx.Set("responseHeaders", headers)
+	}
+
+	// 响应压缩（去除HTML/CSS/JS中的注释和空白），SSE和WebSocket不处理
+	if !isSSE && !isWebSocketRequest {
+		ph.applyMinify(ctx, hostRule, routeRule)
+	}
+
+	// 按采样比例或调试请求头转储完整的请求和上游响应，SSE和WebSocket不处理
+	var capture *dump.Capture
+	if !isSSE && !isWebSocketRequest {
+		capture = ph.beginDump(ctx, hostRule, routeRule)
+	}
 
 	// 设置初始目标服务到上下文
 	ctx.TargetURL = targetService.URL
-	ctx.ServiceName = ph.getServiceName(targetService.URL)
+	ctx.ServiceName = serviceName
 
-	// 如果是WebSocket请求，直接处理协议升级
-	if isWebSocketRequest {
-		err := ph.HandleWebSocketUpgrade(w, r, targetService)
-		if err != nil {
-			log.Printf("WebSocket upgrade failed: %v", err)
-			ph.handleWebSocketError(w, fmt.Sprintf("WebSocket upgrade failed: %v", err))
-		}
+	// 内部路径（健康检查、指标采集等）跳过配置的中间件
+	bypass := ph.internalPathBypass(r)
+
+	// 创建动态中间件链，WebSocket升级请求同样在升级前执行完整的中间件链
+	dynamicMiddlewareChain := ph.createDynamicMiddlewareChain(hostRule, routeRule, bypass)
+
+	// 路由或域名规则配置了授权要求时，在链尾执行授权检查
+	// 域名规则配置了并发会话限制时，在授权检查之后记录会话
+	if !ph.applyRuleChecks(dynamicMiddlewareChain, ctx, hostRule, routeRule, bypass) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// 创建动态中间件链
-	dynamicMiddlewareChain := ph.createDynamicMiddlewareChain(hostRule, routeRule)
-
 	// 获取缓存中间件实例并存储在上下文中
 	for _, mw := range dynamicMiddlewareChain.GetMiddlewares() {
 		if mw.Name() == "cache" {
@@ -182,9 +410,21 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 执行中间件链
-	if !dynamicMiddlewareChain.Execute(ctx) {
+	completed := dynamicMiddlewareChain.Execute(ctx)
+	chainTracer.Finish(ctx)
+	if !completed {
+		if err := ctx.Err(); err != nil {
+			ph.handleCancelledRequest(w, r, err)
+			return
+		}
+		if ctx.Responded() {
+			log.Printf("Request answered by middleware: %s %s %d", r.Method, r.URL.Path, ctx.StatusCode)
+			return
+		}
 		if ctx.StatusCode != 0 {
 			w.WriteHeader(ctx.StatusCode)
+		} else if isWebSocketRequest {
+			http.Error(w, "Forbidden", http.StatusForbidden)
 		}
 		log.Printf("Request aborted by middleware: %s %s", r.Method, r.URL.Path)
 		return
@@ -193,10 +433,10 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 检查中间件是否修改了目标服务
 	if dynamicTarget, exists := ctx.Values["dynamic_target_service"]; exists {
 		if dynamicTargetServiceName, ok := dynamicTarget.(string); ok {
-			if service, serviceExists := ph.services[dynamicTargetServiceName]; serviceExists {
+			if service, serviceExists := ph.services.Get(dynamicTargetServiceName); serviceExists {
 				targetService = &service
 				ctx.TargetURL = targetService.URL
-				ctx.ServiceName = ph.getServiceName(targetService.URL)
+				ctx.ServiceName = dynamicTargetServiceName
 				log.Printf("Dynamic routing: redirected to service '%s'", dynamicTargetServiceName)
 			} else {
 				log.Printf("Dynamic routing: service '%s' not found, using original target", dynamicTargetServiceName)
@@ -204,6 +444,22 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// WebSocket升级请求转发到（可能被中间件修改的）目标服务，之后双向转发数据
+	if isWebSocketRequest {
+		if err := ph.HandleWebSocketUpgrade(w, ctx.Request, ctx.ServiceName, targetService); err != nil {
+			log.Printf("WebSocket upgrade failed: %v", err)
+			// 连接上游或升级失败时返回502，请求本身不是升级请求时返回400
+			status := http.StatusBadGateway
+			if errors.Is(err, errNotWebSocketUpgrade) {
+				status = http.StatusBadRequest
+			}
+			ph.handleWebSocketError(w, status, fmt.Sprintf("WebSocket upgrade failed: %v", err))
+			return
+		}
+		log.Printf("WebSocket closed: %s -> %s [%s] %v", r.URL.Path, targetService.URL, r.Host, time.Since(startTime))
+		return
+	}
+
 	// 创建反向代理，传递中间件上下文以支持replace中间件
 	proxy, err := ph.createReverseProxy(targetService, ctx)
 	if err != nil {
@@ -218,7 +474,13 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 执行代理，使用中间件上下文中的Response（可能已被包装）
+	if capture != nil {
+		capture.WrapRequest(r)
+	}
 	proxy.ServeHTTP(ctx.Response, r)
+	if capture != nil {
+		capture.Finish(r)
+	}
 
 	// 注意：finalize()方法不再需要在这里调用，因为httputil.ReverseProxy
 	// 会在请求处理完成后自动完成所有写入操作。我们的replaceResponseWrapper
@@ -230,44 +492,62 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.Method, r.URL.Path, targetService.URL, r.Host, duration)
 }
 
-// registerAllPlugins 自动发现并注册所有插件
-func registerAllPlugins(factory middleware.MiddlewareFactory, autoPluginMgr *middleware.AutoPluginManager) error {
+// registerAllPlugins 自动发现并注册所有插件，返回注册成功的插件和编译或加载失败的插件
+func registerAllPlugins(factory middleware.MiddlewareFactory, autoPluginMgr *middleware.AutoPluginManager) ([]string, map[string]error, error) {
+	if !middleware.PluginsSupported {
+		log.Printf("Plugins are not supported on this platform, using built-in middlewares only: %v", middleware.BuiltinNames())
+		return nil, nil, nil
+	}
+
 	// 发现所有插件
 	plugins, err := autoPluginMgr.DiscoverPlugins()
 	if err != nil {
-		return fmt.Errorf("failed to discover plugins: %v", err)
+		return nil, nil, fmt.Errorf("failed to discover plugins: %v", err)
 	}
 
 	log.Printf("Discovered %d plugins: %v", len(plugins), plugins)
 
-	// 注册每个插件
-	for _, pluginName := range plugins {
+	// 并行编译所有插件，源代码未变化的插件直接使用缓存
+	report := autoPluginMgr.CompileAll(plugins, 0)
+	report.Log()
+
+	// 注册每个编译成功的插件
+	var registered []string
+	pluginErrors := make(map[string]error)
+	for _, result := range report.Results {
+		if result.Err != nil {
+			pluginErrors[result.Name] = result.Err
+			continue
+		}
+		pluginName := result.Name
+
 		// 获取插件创建函数
 		creator, err := autoPluginMgr.GetPluginCreator(pluginName)
 		if err != nil {
 			log.Printf("Failed to get creator for plugin '%s': %v", pluginName, err)
+			pluginErrors[pluginName] = err
 			continue
 		}
 
 		// 注册插件到工厂
+		if middleware.IsBuiltin(pluginName) {
+			log.Printf("Plugin '%s' overrides built-in middleware", pluginName)
+		}
 		factory.RegisterMiddleware(pluginName, creator)
+		registered = append(registered, pluginName)
 		log.Printf("Registered plugin '%s'", pluginName)
 	}
 
-	return nil
+	return registered, pluginErrors, nil
 }
 
 // determineTarget 确定目标服务，返回匹配的服务和路由规则信息
-func (ph *ProxyHandler) determineTarget(r *http.Request) (*config.Service, *config.HostRule, *config.RouteRule, error) {
+func (ph *ProxyHandler) determineTarget(r *http.Request, routes *routeTable) (*config.Service, *config.HostRule, *config.RouteRule, error) {
 	// 1. 先尝试域名匹配（策略：域名匹配优先）
-	host := r.Host
-	// 移除端口号
-	if colonIndex := strings.Index(host, ":"); colonIndex != -1 {
-		host = host[:colonIndex]
-	}
-
-	// 使用域名匹配器查找匹配的域名
-	targetServiceName, matched := ph.hostMatcher.Match(host)
+	// 使用域名匹配器查找匹配的域名，匹配器会忽略大小写、末尾的点和端口号
+	// 路由表只包含挂载到当前监听器的域名规则（见config.Listener.Attaches），并跳过不在生效时间窗口内的规则
+	now := time.Now()
+	matchedHostRule, matched := routes.matchHost(r.Host, now)
 	if !matched {
 		// 检查是否是SSE请求，如果是则提供特殊错误处理
 		if ph.detectSSERequest(r) {
@@ -276,67 +556,22 @@ func (ph *ProxyHandler) determineTarget(r *http.Request) (*config.Service, *conf
 		return nil, nil, nil, fmt.Errorf("no matching rule found for host: %s, path: %s", r.Host, r.URL.Path)
 	}
 
-	// 查找对应的域名配置
-	var matchedHostRule *config.HostRule
-	for _, hostRule := range ph.cfg.HostRules {
-		if hostRule.Target == targetServiceName {
-			// 检查端口号是否匹配
-			// 重要：域名规则的端口配置应该表示该规则只在特定端口上生效
-			// 如果域名规则指定了端口（Port != 0），那么该规则只在该端口上生效
-			// 如果域名规则没有指定端口（Port为0），那么该规则在所有端口上都生效
-
-			// 调试日志：显示域名匹配信息
-			log.Printf("Host matching: target=%s, hostRule.Port=%d, r.Host=%s",
-				targetServiceName, hostRule.Port, r.Host)
-
-			// 如果域名规则指定了端口，我们需要检查当前请求是否来自正确的端口
-			// 但由于HTTP请求的Host头通常不包含端口信息，我们无法从Host头获取端口
-			// 因此，我们应该放宽端口检查：只有当域名规则明确指定端口时才进行严格检查
-			// 但实际上，更好的做法是：域名规则的端口应该表示该规则只在特定端口上生效
-			// 如果域名规则指定了端口，但当前服务器端口不匹配，则跳过
-
-			// 注意：这里我们无法直接获取当前服务器端口，因为请求可能来自任何监听端口
-			// 所以我们应该简化逻辑：如果域名规则指定了端口，就接受该规则
-			// 因为服务器已经在正确的端口上监听
-
-			matchedHostRule = &hostRule
-			log.Printf("Host rule matched: %s -> %s (port: %d)", hostRule.Pattern, hostRule.Target, hostRule.Port)
-			break
-		}
-	}
-
 	if matchedHostRule != nil {
-		// 2. 在匹配的域名规则中尝试路由匹配
-		for _, routeRule := range matchedHostRule.RouteRules {
-			// 简单的路径匹配逻辑
-			if routeRule.Pattern == "/" && r.URL.Path == "/" {
-				// 精确匹配根路径
-				if service, exists := ph.services[routeRule.Target]; exists {
-					return &service, matchedHostRule, &routeRule, nil
-				}
-			} else if strings.HasSuffix(routeRule.Pattern, "/*") {
-				// 通配符匹配
-				prefix := routeRule.Pattern[:len(routeRule.Pattern)-2]
-				if strings.HasPrefix(r.URL.Path, prefix) {
-					if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
-						if service, exists := ph.services[routeRule.Target]; exists {
-							return &service, matchedHostRule, &routeRule, nil
-						}
-					}
-				}
-			} else if strings.HasPrefix(routeRule.Pattern, "^") && strings.HasSuffix(routeRule.Pattern, "$") {
-				// 正则表达式匹配
-				re, err := regexp.Compile(routeRule.Pattern)
-				if err == nil && re.MatchString(r.URL.Path) {
-					if service, exists := ph.services[routeRule.Target]; exists {
-						return &service, matchedHostRule, &routeRule, nil
-					}
-				}
-			}
+		debuglog.Printf("Host rule matched: %s -> %s (port: %d)", matchedHostRule.Pattern, matchedHostRule.Target, matchedHostRule.Port)
+
+		// 2. 在匹配的域名规则中尝试路由匹配，跳过目标服务不存在的路由规则
+		var service config.Service
+		routeRule := routes.matchRoute(matchedHostRule, r.URL.Path, now, func(routeRule *config.RouteRule) bool {
+			var exists bool
+			service, exists = ph.services.Get(routeRule.Target)
+			return exists
+		})
+		if routeRule != nil {
+			return &service, matchedHostRule, routeRule, nil
 		}
 
 		// 3. 如果没有匹配的路由规则，使用域名的默认目标
-		if service, exists := ph.services[matchedHostRule.Target]; exists {
+		if service, exists := ph.services.Get(matchedHostRule.Target); exists {
 			return &service, matchedHostRule, nil, nil
 		}
 	}
@@ -344,175 +579,196 @@ func (ph *ProxyHandler) determineTarget(r *http.Request) (*config.Service, *conf
 	return nil, nil, nil, fmt.Errorf("no matching rule found for host: %s, path: %s", r.Host, r.URL.Path)
 }
 
-// createDynamicMiddlewareChain 根据路由规则创建动态中间件链
-func (ph *ProxyHandler) createDynamicMiddlewareChain(hostRule *config.HostRule, routeRule *config.RouteRule) middleware.MiddlewareChain {
-	chain := middleware.NewMiddlewareChain()
-	factory := ph.factory // 使用已注册的工厂实例
+// ruleTarget 返回匹配规则的目标服务名称，路由规则优先于域名规则
+func ruleTarget(hostRule *config.HostRule, routeRule *config.RouteRule) string {
+	if routeRule != nil {
+		return routeRule.Target
+	}
+	return hostRule.Target
+}
 
-	// 获取所有已启用的中间件配置
-	enabledMiddlewares := make(map[string]config.Middleware)
-	for _, mwConfig := range ph.cfg.Middlewares {
-		if mwConfig.Enabled {
-			enabledMiddlewares[mwConfig.Name] = mwConfig
+// createDynamicMiddlewareChain 根据路由规则和域名规则创建中间件链
+// 中间件实例来自规则的链计划（见chainPlan），启用状态可以通过管理API在运行时覆盖，覆盖在下一个请求生效
+func (ph *ProxyHandler) createDynamicMiddlewareChain(hostRule *config.HostRule, routeRule *config.RouteRule, bypass *middlewareBypass) middleware.MiddlewareChain {
+	chain := middleware.NewMiddlewareChain()
+	for _, entry := range ph.resolveChain(hostRule, routeRule, bypass, time.Now()) {
+		if entry.create == nil {
+			log.Printf("Warning: middleware %s not found or disabled", entry.name)
+			continue
 		}
+		mw, err := entry.create()
+		if err != nil {
+			log.Printf("Failed to create %s middleware %s: %v", entry.source, entry.name, err)
+			continue
+		}
+		chain.Add(mw)
+		debuglog.Printf("%s middleware %s loaded for %s", entry.source, entry.name, ruleLabel(hostRule, routeRule))
 	}
+	return chain
+}
 
-	// 添加路由级中间件（优先级最高）
-	if routeRule != nil && len(routeRule.Middlewares) > 0 {
-		for _, mwName := range routeRule.Middlewares {
-			// 首先检查是否是注册的中间件服务
-			mw, err := factory.CreateMiddleware(mwName, nil)
-			if err == nil {
-				chain.Add(mw)
-				log.Printf("Route-level middleware service %s loaded for path: %s", mwName, routeRule.Pattern)
-				continue
-			}
+// 中间件在链中的来源
+const (
+	chainSourceRoute         = "route"
+	chainSourceHost          = "host"
+	chainSourceGlobal        = "global"
+	chainSourceGlobalService = "global service"
+)
 
-			// 如果不是注册的中间件服务，检查标准中间件配置
-			if mwConfig, exists := enabledMiddlewares[mwName]; exists {
-				mw, err := factory.CreateMiddleware(mwConfig.Name, mwConfig.Config)
-				if err != nil {
-					log.Printf("Failed to create route-level middleware %s: %v", mwConfig.Name, err)
-					continue
-				}
-				chain.Add(mw)
-				log.Printf("Route-level middleware %s loaded for path: %s", mwConfig.Name, routeRule.Pattern)
-			} else {
-				log.Printf("Warning: Route-level middleware %s not found or disabled", mwName)
-			}
-		}
+// chainEntry 解析后的中间件链中的一项，create为nil表示规则引用的中间件不存在
+type chainEntry struct {
+	name   string
+	source string
+	create func() (middleware.Middleware, error)
+}
+
+// resolveChain 按规则的链计划解析当前生效的中间件链：路由级、域名级、全局中间件、全局中间件服务，
+// 跳过被禁用、不在生效时间窗口内和被内部路径跳过的中间件。create返回链计划中共享的中间件实例
+func (ph *ProxyHandler) resolveChain(hostRule *config.HostRule, routeRule *config.RouteRule, bypass *middlewareBypass, now time.Time) []chainEntry {
+	// 内部路径跳过整个中间件链
+	if bypass != nil && bypass.all {
+		return nil
 	}
 
-	// 添加域名级中间件（优先级次之）
-	if hostRule != nil && len(hostRule.Middlewares) > 0 {
-		for _, mwName := range hostRule.Middlewares {
-			// 首先检查是否是注册的中间件服务
-			mw, err := factory.CreateMiddleware(mwName, nil)
-			if err == nil {
-				chain.Add(mw)
-				log.Printf("Host-level middleware service %s loaded for host: %s", mwName, hostRule.Pattern)
-				continue
-			}
+	plan := ph.plans.get(hostRule, routeRule)
+	if plan == nil {
+		// 不属于当前配置的规则（如测试中构造的规则），按需创建计划
+		plan = ph.newChainPlan(hostRule, routeRule, newMiddlewarePool(nil))
+	}
 
-			// 如果不是注册的中间件服务，检查标准中间件配置
-			if mwConfig, exists := enabledMiddlewares[mwName]; exists {
-				mw, err := factory.CreateMiddleware(mwConfig.Name, mwConfig.Config)
-				if err != nil {
-					log.Printf("Failed to create host-level middleware %s: %v", mwConfig.Name, err)
-					continue
-				}
-				chain.Add(mw)
-				log.Printf("Host-level middleware %s loaded for host: %s", mwConfig.Name, hostRule.Pattern)
-			} else {
-				log.Printf("Warning: Host-level middleware %s not found or disabled", mwName)
-			}
+	toggles := middleware.GetMiddlewareToggles()
+	var entries []chainEntry
+	for i := range plan.candidates {
+		candidate := &plan.candidates[i]
+		if bypass.skips(candidate.name) || !candidate.applies(toggles, now) {
+			continue
+		}
+		entry := chainEntry{name: candidate.name, source: candidate.source}
+		if candidate.instance != nil {
+			entry.create = candidate.instance.get
 		}
+		entries = append(entries, entry)
 	}
+	return entries
+}
 
-	// 添加全局中间件（优先级最低）
-	for _, mwConfig := range ph.cfg.Middlewares {
-		if mwConfig.Enabled {
-			// 检查是否已经在路由级或域名级添加过
-			alreadyAdded := false
-			if routeRule != nil {
-				for _, mwName := range routeRule.Middlewares {
-					if mwName == mwConfig.Name {
-						alreadyAdded = true
-						break
-					}
-				}
-			}
-			if !alreadyAdded && hostRule != nil {
-				for _, mwName := range hostRule.Middlewares {
-					if mwName == mwConfig.Name {
-						alreadyAdded = true
-						break
-					}
-				}
-			}
-
-			if !alreadyAdded {
-				mw, err := factory.CreateMiddleware(mwConfig.Name, mwConfig.Config)
-				if err != nil {
-					log.Printf("Failed to create global middleware %s: %v", mwConfig.Name, err)
-					continue
-				}
-				chain.Add(mw)
-				log.Printf("Global middleware %s loaded", mwConfig.Name)
-			}
+// containsName 检查名称列表中是否包含指定名称
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
 		}
 	}
+	return false
+}
 
-	// 添加全局中间件服务（优先级最低）
-	// 注意：这里只添加明确标记为全局的中间件服务
-	if registry := middleware.GetMiddlewareServiceRegistry(); registry != nil {
-		for _, service := range registry.List() {
-			// 只有明确标记为全局的中间件服务才会被全局加载
-			if service.IsGlobal {
-				// 检查是否已经在路由级或域名级添加过
-				alreadyAdded := false
-				if routeRule != nil {
-					for _, mwName := range routeRule.Middlewares {
-						if mwName == service.Name {
-							alreadyAdded = true
-							break
-						}
-					}
-				}
-				if !alreadyAdded && hostRule != nil {
-					for _, mwName := range hostRule.Middlewares {
-						if mwName == service.Name {
-							alreadyAdded = true
-							break
-						}
-					}
-				}
+// resolveDarkLaunch 检查请求是否命中暗发布规则，命中时返回替代的服务名称和服务
+// 路由级配置优先于域名级配置；命中后移除密钥请求头和密钥Cookie，避免泄露到后端
+func (ph *ProxyHandler) resolveDarkLaunch(r *http.Request, hostRule *config.HostRule, routeRule *config.RouteRule) (string, *config.Service) {
+	var dl *config.DarkLaunchConfig
+	if routeRule != nil && routeRule.DarkLaunch != nil {
+		dl = routeRule.DarkLaunch
+	} else if hostRule != nil && hostRule.DarkLaunch != nil {
+		dl = hostRule.DarkLaunch
+	}
+	if dl == nil || dl.Secret == "" {
+		return "", nil
+	}
 
-				if !alreadyAdded {
-					mw, err := factory.CreateMiddleware(service.Name, nil)
-					if err != nil {
-						log.Printf("Failed to create global middleware service %s: %v", service.Name, err)
-						continue
-					}
-					chain.Add(mw)
-					log.Printf("Global middleware service %s loaded", service.Name)
-				}
-			}
+	matched := dl.Header != "" && secretEquals(r.Header.Get(dl.Header), dl.Secret)
+	if !matched && dl.Cookie != "" {
+		if cookie, err := r.Cookie(dl.Cookie); err == nil && secretEquals(cookie.Value, dl.Secret) {
+			matched = true
 		}
 	}
+	if !matched {
+		return "", nil
+	}
+	if dl.Header != "" {
+		r.Header.Del(dl.Header)
+	}
+	if dl.Cookie != "" {
+		removeCookie(r, dl.Cookie)
+	}
 
-	return chain
-}
-
-// createReverseProxy 创建反向代理
-func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middleware.Context) (*httputil.ReverseProxy, error) {
-	// 检查服务是否配置了负载均衡
-	serviceName := ph.getServiceName(service.URL)
-	lb, err := ph.loadBalancerMgr.GetLoadBalancer(serviceName)
-	hasLB := err == nil
+	service, exists := ph.services.Get(dl.Target)
+	if !exists {
+		log.Printf("Dark launch: service '%s' not found, using original target", dl.Target)
+		return "", nil
+	}
 
-	var targetURL *url.URL
+	log.Printf("Dark launch: %s %s -> %s", r.Method, r.URL.Path, dl.Target)
+	return dl.Target, &service
+}
 
-	if hasLB {
-		// 使用负载均衡器选择后端
-		backend, err := lb.NextBackend(ctx.Request)
-		if err != nil {
-			return nil, fmt.Errorf("load balancer failed to select backend: %v", err)
+// removeCookie 从Cookie请求头中移除指定名称的Cookie，其他Cookie按原样保留，没有剩余的Cookie时删除请求头
+func removeCookie(r *http.Request, name string) {
+	var kept []string
+	for _, line := range r.Header.Values("Cookie") {
+		for _, part := range strings.Split(line, ";") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if cookieName, _, _ := strings.Cut(part, "="); strings.TrimSpace(cookieName) == name {
+				continue
+			}
+			kept = append(kept, part)
 		}
+	}
+	if len(kept) == 0 {
+		r.Header.Del("Cookie")
+		return
+	}
+	r.Header.Set("Cookie", strings.Join(kept, "; "))
+}
 
-		targetURL, err = url.Parse(backend.URL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid backend URL: %s", backend.URL)
-		}
+// secretEquals 以常量时间比较密钥，避免时序攻击
+func secretEquals(value, secret string) bool {
+	if value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(value), []byte(secret)) == 1
+}
 
-		log.Printf("Load balancer selected backend: %s for service: %s", backend.URL, serviceName)
-	} else {
+// backendURL 返回请求转发的地址，服务配置了负载均衡时由负载均衡器选择后端并返回该负载均衡器，否则返回的负载均衡器为nil
+// 负载均衡器按服务名称（或引用的命名负载均衡器）查找，多个服务的url相同或负载均衡服务没有配置url时也能选中正确的负载均衡器
+func (ph *ProxyHandler) backendURL(serviceName string, service *config.Service, r *http.Request) (*url.URL, loadbalancer.LoadBalancer, error) {
+	var lb loadbalancer.LoadBalancer
+	err := fmt.Errorf("service '%s' has no load balancer", serviceName)
+	if name := loadBalancerName(serviceName, service); name != "" {
+		lb, err = ph.loadBalancerMgr.GetLoadBalancer(name)
+	}
+	if err != nil {
 		// 使用传统单一目标URL
-		targetURL, err = url.Parse(service.URL)
+		targetURL, err := url.Parse(service.URL)
 		if err != nil {
-			return nil, fmt.Errorf("invalid target URL: %s", service.URL)
+			return nil, nil, fmt.Errorf("invalid target URL: %s", service.URL)
 		}
+		return targetURL, nil, nil
+	}
+
+	// 使用负载均衡器选择后端
+	backend, err := lb.NextBackend(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load balancer failed to select backend: %v", err)
 	}
+	targetURL, err := url.Parse(backend.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid backend URL: %s", backend.URL)
+	}
+	debuglog.Printf("Load balancer selected backend: %s for service: %s", backend.URL, serviceName)
+	return targetURL, lb, nil
+}
+
+// createReverseProxy 创建反向代理
+func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middleware.Context) (*httputil.ReverseProxy, error) {
+	serviceName := ctx.ServiceName
+	targetURL, lb, err := ph.backendURL(serviceName, service, ctx.Request)
+	if err != nil {
+		return nil, err
+	}
+	hasLB := lb != nil
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 
@@ -527,11 +783,14 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 	// 为SSE连接设置刷新间隔
 	if isSSE {
 		proxy.FlushInterval = 100 * time.Millisecond
-		log.Printf("SSE connection detected, enabling streaming mode")
+		debuglog.Printf("SSE connection detected, enabling streaming mode")
 	}
 
-	// 自定义修改请求 - 设置正确的Host头（二级代理场景）
-	proxy.Director = func(req *http.Request) {
+	// 记录发往上游的时间，用于计算上游延迟
+	var upstreamStart time.Time
+
+	// direct 把请求指向后端，重试时用于切换到其他后端
+	direct := func(req *http.Request, targetURL *url.URL) {
 		// 保留原始请求的URL路径和查询参数
 		req.URL.Scheme = targetURL.Scheme
 		req.URL.Host = targetURL.Host
@@ -545,7 +804,11 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 		req.Host = hostHeader
 
 		// 设置其他必要的头
-		req.Header.Set("X-Forwarded-Proto", "http")
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
 		req.Header.Set("X-Forwarded-Host", req.Host)
 		req.Header.Set("X-Forwarded-For", req.RemoteAddr)
 
@@ -559,6 +822,19 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 			req.Header.Set("X-Load-Balancer", serviceName)
 			req.Header.Set("X-Backend-URL", targetURL.String())
 		}
+
+		// 上游请求签名在所有请求头设置完成后进行
+		if ctx != nil {
+			if value, exists := ctx.Get(requestsigning.SignerKey); exists {
+				value.(*requestsigning.Signer).Sign(req)
+			}
+		}
+	}
+
+	// 自定义修改请求 - 设置正确的Host头（二级代理场景）
+	proxy.Director = func(req *http.Request) {
+		upstreamStart = time.Now()
+		direct(req, targetURL)
 	}
 
 	// 如果使用负载均衡，包装传输层以记录响应时间和连接状态
@@ -569,15 +845,63 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 		}
 	}
 
+	// 配置了重试策略时在传输层重试失败的请求，使用负载均衡时优先选择其他后端
+	if ctx != nil {
+		if policy, exists := ctx.Get("retryPolicy"); exists {
+			transport := proxy.Transport
+			if transport == nil {
+				transport = http.DefaultTransport
+			}
+			proxy.Transport = &retryTransport{
+				next:       transport,
+				policy:     policy.(*retryPolicy),
+				service:    serviceName,
+				nextTarget: retryTarget(lb),
+				direct:     direct,
+			}
+		}
+	}
+
 	// 自定义修改响应
 	proxy.ModifyResponse = func(resp *http.Response) error {
-		// 添加代理相关响应头
-		resp.Header.Set("X-Proxy-By", "toyou-proxy")
-		resp.Header.Set("X-Target-Service", ph.getServiceName(service.URL))
+		if ctx != nil {
+			ctx.SetUpstreamResult(middleware.UpstreamResult{Status: resp.StatusCode, Latency: time.Since(upstreamStart)})
+		}
+
+		// 调试转储记录上游的原始响应，在其他处理之前执行
+		if ctx != nil {
+			if value, exists := ctx.Get(dump.CaptureKey); exists {
+				value.(*dump.Capture).RecordResponse(resp)
+			}
+		}
+
+		// 限制上游响应体大小，缓冲和流式转发都会受到限制
+		if ctx != nil {
+			if limit, exists := ctx.Get("maxResponseSize"); exists {
+				if err := checkDeclaredSize(resp, limit.(int64)); err != nil {
+					return err
+				}
+				limitResponseBody(resp, limit.(int64))
+			}
+		}
+
+		// 添加代理相关响应头，可按域名关闭或只对内部调用方添加
+		proxyHeaders := true
+		if ctx != nil {
+			if enabled, exists := ctx.Get("proxyHeaders"); exists {
+				proxyHeaders = enabled.(bool)
+			}
+		}
+		if proxyHeaders {
+			resp.Header.Set("X-Proxy-By", "toyou-proxy")
+			resp.Header.Set("X-Target-Service", serviceName)
+		}
 
 		// 为SSE响应设置特殊头
 		if isSSE {
-			resp.Header.Set("X-SSE-Proxy", "toyou-proxy")
+			if proxyHeaders {
+				resp.Header.Set("X-SSE-Proxy", "toyou-proxy")
+			}
 			// 确保不缓存SSE响应
 			resp.Header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
 			resp.Header.Set("Pragma", "no-cache")
@@ -585,6 +909,80 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 
 			// 禁用缓冲（适用于某些代理服务器）
 			resp.Header.Set("X-Accel-Buffering", "no")
+
+			// 重新加载的宽限期结束后发送重新连接提示并结束流
+			if resp.StatusCode == http.StatusOK {
+				watchSSEReload(resp)
+			}
+		}
+
+		// 添加配置的静态响应头
+		if ctx != nil {
+			if headers, exists := ctx.Get("responseHeaders"); exists {
+				applyResponseHeaders(resp.Header, headers.(map[string]string))
+			}
+		}
+
+		// 中间件的响应阶段处理，在图片处理、遮盖和缓存之前执行，缓存中保存处理后的响应
+		if ctx != nil {
+			if err := ctx.HandleResponse(resp); err != nil {
+				return err
+			}
+		}
+
+		// 按请求参数处理图片，处理失败时转发原始图片
+		if ctx != nil {
+			if value, exists := ctx.Get(imageproxy.TransformerKey); exists {
+				if transformer, ok := value.(*imageproxy.Transformer); ok && transformer.Applies(resp) {
+					body, err := io.ReadAll(resp.Body)
+					if err != nil {
+						return &responseBufferError{err: err}
+					}
+					resp.Body.Close()
+
+					if transformed, err := transformer.Transform(body, resp.Header); err != nil {
+						log.Printf("Image transformation failed for %s: %v", ctx.Request.URL.Path, err)
+					} else {
+						body = transformed
+					}
+					resp.Body = io.NopCloser(bytes.NewReader(body))
+					resp.ContentLength = int64(len(body))
+					resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+				}
+			}
+		}
+
+		// 遮盖响应中的敏感数据，在缓存之前执行，缓存中只保存遮盖后的内容
+		if ctx != nil {
+			if value, exists := ctx.Get(masking.MaskerKey); exists {
+				if masker, ok := value.(*masking.Masker); ok {
+					applies, err := masker.Applies(resp.Header)
+					if err != nil {
+						return err
+					}
+					if applies {
+						body, err := io.ReadAll(resp.Body)
+						if err != nil {
+							return &responseBufferError{err: err}
+						}
+						resp.Body.Close()
+
+						masked := masker.Mask(body, resp.Header)
+						resp.Body = io.NopCloser(bytes.NewReader(masked))
+						resp.ContentLength = int64(len(masked))
+						resp.Header.Set("Content-Length", strconv.Itoa(len(masked)))
+					}
+				}
+			}
+		}
+
+		// 流式压缩HTML/CSS/JS响应，缓存中保存压缩后的内容
+		if ctx != nil {
+			if value, exists := ctx.Get(minify.MinifierKey); exists {
+				if minifier, ok := value.(*minify.Minifier); ok {
+					minifier.Wrap(resp)
+				}
+			}
 		}
 
 		// 检查是否需要缓存响应
@@ -600,7 +998,7 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 							// 读取响应体
 							body, err := io.ReadAll(resp.Body)
 							if err != nil {
-								return err
+								return &responseBufferError{err: err}
 							}
 							resp.Body.Close()
 
@@ -651,16 +1049,21 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 		// 从上下文中获取替换规则
 		if ctx != nil {
 			if rules, exists := ctx.Get("replaceRules"); exists {
-				if replaceRules, ok := rules.([]middleware.ReplaceRule); ok && len(replaceRules) > 0 {
+				// 只应用满足生效条件的规则，没有时不读取响应体
+				replaceRules, _ := rules.([]middleware.ReplaceRule)
+				if replaceRules = middleware.ReplaceRulesFor(replaceRules, resp); len(replaceRules) > 0 {
 					// 读取响应体
 					body, err := io.ReadAll(resp.Body)
 					if err != nil {
-						return err
+						return &responseBufferError{err: err}
 					}
 					resp.Body.Close()
 
-					// 应用替换规则
-					modifiedBody := applyReplaceRules(body, replaceRules)
+					// 应用替换规则，规则无效时返回原始响应体
+					modifiedBody, err := applyReplaceRules(body, replaceRules, ctx)
+					if err != nil {
+						log.Printf("Replace rules not applied: %v", err)
+					}
 
 					// 重新设置响应体
 					resp.Body = io.NopCloser(bytes.NewReader(modifiedBody))
@@ -673,10 +1076,66 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 		return nil
 	}
 
+	// S3服务：映射对象键并签名
+	if service.Type == config.ServiceTypeS3 {
+		if err := ph.configureS3Proxy(proxy, service); err != nil {
+			return nil, err
+		}
+	}
+
 	// 自定义错误处理
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// 请求超时或客户端已断开，超时计入上游错误
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			if ctx != nil && errors.Is(ctxErr, context.DeadlineExceeded) {
+				ctx.SetUpstreamResult(middleware.UpstreamResult{Status: http.StatusGatewayTimeout, Latency: time.Since(upstreamStart), Err: ctxErr})
+			}
+			ph.handleCancelledRequest(w, r, ctxErr)
+			return
+		}
+
 		log.Printf("Proxy error: %v", err)
 
+		// 分块传输的请求体超过大小上限
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			rejectedRequests.Inc(rejectBodyTooLarge)
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// 响应体超过大小上限：缓冲时返回507，其余返回502
+		if errors.Is(err, errResponseTooLarge) {
+			var bufferErr *responseBufferError
+			if errors.As(err, &bufferErr) {
+				http.Error(w, "Insufficient Storage", http.StatusInsufficientStorage)
+			} else {
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			}
+			return
+		}
+
+		// 重试策略的单次尝试超时，且没有更多重试
+		if errors.Is(err, context.DeadlineExceeded) {
+			if ctx != nil {
+				ctx.SetUpstreamResult(middleware.UpstreamResult{Status: http.StatusGatewayTimeout, Latency: time.Since(upstreamStart), Err: err})
+			}
+			http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+			return
+		}
+
+		// 中间件处理上游响应失败，不计入上游错误
+		var handlerErr *middleware.ResponseHandlerError
+		if errors.As(err, &handlerErr) {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+
+		// 连接失败等上游错误
+		if ctx != nil {
+			ctx.SetUpstreamResult(middleware.UpstreamResult{Status: http.StatusBadGateway, Latency: time.Since(upstreamStart), Err: err})
+		}
+
 		// 为SSE连接提供特殊错误处理
 		if isSSE {
 			ph.handleSSEError(w, fmt.Sprintf("Proxy error: %v", err))
@@ -689,19 +1148,33 @@ func (ph *ProxyHandler) createReverseProxy(service *config.Service, ctx *middlew
 	return proxy, nil
 }
 
-// getServiceName 根据URL获取服务名称
-func (ph *ProxyHandler) getServiceName(url string) string {
-	for name, service := range ph.services {
-		if service.URL == url {
-			return name
-		}
+// requestTimeout 返回请求超时时间，优先级：路由级 > 域名级 > 全局
+func (ph *ProxyHandler) requestTimeout(hostRule *config.HostRule, routeRule *config.RouteRule) time.Duration {
+	seconds := ph.cfg.Advanced.Timeout.RequestTimeout
+	if hostRule != nil && hostRule.Timeout > 0 {
+		seconds = hostRule.Timeout
+	}
+	if routeRule != nil && routeRule.Timeout > 0 {
+		seconds = routeRule.Timeout
 	}
-	return "unknown"
+	return time.Duration(seconds) * time.Second
 }
 
-// applyReplaceRules 应用替换规则到响应内容
-func applyReplaceRules(content []byte, rules []middleware.ReplaceRule) []byte {
-	return middleware.ApplyReplaceRules(content, rules)
+// handleCancelledRequest 处理超时或客户端断开的请求
+// 超时返回504；客户端已断开时响应无人接收，只记录日志
+func (ph *ProxyHandler) handleCancelledRequest(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Request timed out: %s %s [%s]", r.Method, r.URL.Path, r.Host)
+		http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		return
+	}
+
+	log.Printf("Client cancelled request: %s %s [%s]", r.Method, r.URL.Path, r.Host)
+}
+
+// applyReplaceRules 应用替换规则到响应内容，替换值中的请求变量取自请求的上下文
+func applyReplaceRules(content []byte, rules []middleware.ReplaceRule, ctx *middleware.Context) ([]byte, error) {
+	return middleware.ApplyReplaceRulesFor(content, rules, ctx)
 }
 
 // detectSSERequest 检测是否是SSE请求
@@ -749,42 +1222,15 @@ func (ph *ProxyHandler) detectSSERequest(r *http.Request) bool {
 	return false
 }
 
-// detectWebSocketRequest 检测是否是WebSocket请求
-func (ph *ProxyHandler) detectWebSocketRequest(r *http.Request) bool {
-	// 1. 检查Connection头
-	connection := strings.ToLower(r.Header.Get("Connection"))
-	if !strings.Contains(connection, "upgrade") {
-		return false
-	}
-
-	// 2. 检查Upgrade头
-	upgrade := strings.ToLower(r.Header.Get("Upgrade"))
-	if upgrade != "websocket" {
-		return false
-	}
-
-	// 3. 检查WebSocket版本
-	if r.Header.Get("Sec-WebSocket-Version") != "13" {
-		return false
-	}
-
-	// 4. 检查WebSocket密钥
-	if r.Header.Get("Sec-WebSocket-Key") == "" {
-		return false
-	}
-
-	return true
-}
-
 // handleWebSocketError 处理WebSocket连接的错误
-func (ph *ProxyHandler) handleWebSocketError(w http.ResponseWriter, errorMsg string) {
+func (ph *ProxyHandler) handleWebSocketError(w http.ResponseWriter, status int, errorMsg string) {
 	// 设置WebSocket错误响应头
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("Connection", "close")
 	w.Header().Set("X-WebSocket-Error", "true")
 
 	// 发送错误响应
-	w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(status)
 	fmt.Fprintf(w, "WebSocket Error: %s", errorMsg)
 }
 
@@ -816,5 +1262,5 @@ func (ph *ProxyHandler) GetMiddlewareInfo() []string {
 // GetRulesInfo 获取规则信息
 func (ph *ProxyHandler) GetRulesInfo() (map[string]string, map[string]string) {
 	// 返回域名规则和空的路由规则（路由规则现在属于域名配置的子节点）
-	return ph.hostMatcher.GetAllRules(), make(map[string]string)
+	return ph.routes.hostMatcher.GetAllRules(), make(map[string]string)
 }
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"toyou-proxy/clientip"
+	"toyou-proxy/config"
+)
+
+// defaultInternalNetworks 未配置internal_networks时视为内部调用方的网段：回环和私有地址
+var defaultInternalNetworks = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+}
+
+// 已解析网段的缓存，按配置的网段列表缓存
+var (
+	internalNetworkCache   = make(map[string][]*net.IPNet)
+	internalNetworkCacheMu sync.RWMutex
+)
+
+// proxyHeadersEnabled 判断是否为本次请求添加代理标识响应头，域名级配置覆盖全局配置
+func (ph *ProxyHandler) proxyHeadersEnabled(r *http.Request, hostRule *config.HostRule) bool {
+	cfg := ph.cfg.Advanced.ProxyHeaders
+	if hostRule != nil && hostRule.ProxyHeaders != nil {
+		networks := cfg.InternalNetworks
+		cfg = *hostRule.ProxyHeaders
+		if len(cfg.InternalNetworks) == 0 {
+			cfg.InternalNetworks = networks
+		}
+	}
+
+	switch cfg.Mode {
+	case config.ProxyHeadersNever:
+		return false
+	case config.ProxyHeadersInternal:
+		return isInternalCaller(r, cfg.InternalNetworks)
+	default:
+		return true
+	}
+}
+
+// isInternalCaller 按连接的来源地址判断是否为内部调用方
+// 不使用X-Forwarded-For等请求头，外部调用方可以伪造这些请求头
+func isInternalCaller(r *http.Request, networks []string) bool {
+	ip := net.ParseIP(clientip.Peer(r))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range parseInternalNetworks(networks) {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseInternalNetworks 解析内部网段并缓存结果，无效网段已在配置验证时拒绝
+func parseInternalNetworks(networks []string) []*net.IPNet {
+	if len(networks) == 0 {
+		networks = defaultInternalNetworks
+	}
+	key := strings.Join(networks, ",")
+
+	internalNetworkCacheMu.RLock()
+	parsed, exists := internalNetworkCache[key]
+	internalNetworkCacheMu.RUnlock()
+	if exists {
+		return parsed
+	}
+
+	for _, network := range networks {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil {
+			parsed = append(parsed, ipNet)
+		}
+	}
+
+	internalNetworkCacheMu.Lock()
+	internalNetworkCache[key] = parsed
+	internalNetworkCacheMu.Unlock()
+
+	return parsed
+}
+
+// responseHeaders 合并静态响应头，优先级：路由级 > 域名级 > 全局，没有配置时返回nil
+func (ph *ProxyHandler) responseHeaders(hostRule *config.HostRule, routeRule *config.RouteRule) map[string]string {
+	var merged map[string]string
+	merge := func(headers map[string]string) {
+		for name, value := range headers {
+			if merged == nil {
+				merged = make(map[string]string)
+			}
+			merged[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+
+	merge(ph.cfg.Advanced.ResponseHeaders)
+	if hostRule != nil {
+		merge(hostRule.ResponseHeaders)
+	}
+	if routeRule != nil {
+		merge(routeRule.ResponseHeaders)
+	}
+	return merged
+}
+
+// applyResponseHeaders 把静态响应头写入上游响应，值为空时删除该响应头
+func applyResponseHeaders(header http.Header, headers map[string]string) {
+	for name, value := range headers {
+		if value == "" {
+			header.Del(name)
+			continue
+		}
+		header.Set(name, value)
+	}
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"toyou-proxy/config"
+)
+
+// RequestIDHeader 请求ID请求头，转发给上游并在响应中返回
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength 客户端提供的请求ID最大长度，超过时重新生成
+const maxRequestIDLength = 128
+
+// ensureRequestID 沿用客户端提供的请求ID，没有或格式不合法时生成新的ID
+func ensureRequestID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		buf := make([]byte, 16)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+		r.Header.Set(RequestIDHeader, id)
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+// validRequestID 只接受可见ASCII字符，避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleLabel 匹配规则的标识，用于日志和指标
+func ruleLabel(hostRule *config.HostRule, routeRule *config.RouteRule) string {
+	if hostRule == nil {
+		return ""
+	}
+	if routeRule == nil {
+		return hostRule.Pattern
+	}
+	if strings.HasPrefix(routeRule.Pattern, "/") {
+		return hostRule.Pattern + routeRule.Pattern
+	}
+	// 正则路由规则
+	return hostRule.Pattern + " " + routeRule.Pattern
+}
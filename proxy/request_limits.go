@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+)
+
+// rejectedRequests 在路由匹配和中间件之前被拒绝的请求数，按原因分类
+var rejectedRequests = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_rejected_requests_total",
+	"Requests rejected by the proxy before reaching middlewares or backends.",
+	"reason",
+)
+
+// 请求被拒绝的原因，用作指标标签
+const (
+	rejectURLTooLong       = "url_too_long"
+	rejectTooManyParams    = "too_many_query_params"
+	rejectTooManyHeaders   = "too_many_headers"
+	rejectHeadersTooLarge  = "headers_too_large"
+	rejectBodyTooLarge     = "body_too_large"
+	rejectTooManyRanges    = "too_many_ranges"
+	rejectPathDenied       = "path_denied"
+	rejectMethodNotAllowed = "method_not_allowed"
+)
+
+// requestLimits 解析后的请求限制，0表示不限制
+type requestLimits struct {
+	maxURLLength   int
+	maxQueryParams int
+	maxHeaderCount int
+	maxHeaderSize  int64
+	maxBodySize    int64
+	maxRanges      int
+}
+
+// newRequestLimits 根据配置创建请求限制
+func newRequestLimits(cfg config.LimitsConfig) (*requestLimits, error) {
+	maxHeaderSize, err := config.ParseSize(cfg.MaxHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max_header_size: %v", err)
+	}
+	maxBodySize, err := config.ParseSize(cfg.MaxBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max_body_size: %v", err)
+	}
+
+	return &requestLimits{
+		maxURLLength:   cfg.MaxURLLength,
+		maxQueryParams: cfg.MaxQueryParams,
+		maxHeaderCount: cfg.MaxHeaderCount,
+		maxHeaderSize:  maxHeaderSize,
+		maxBodySize:    maxBodySize,
+		maxRanges:      cfg.MaxRanges,
+	}, nil
+}
+
+// check 检查请求是否超过限制，返回拒绝时的状态码和原因，未超过时状态码为0
+func (l *requestLimits) check(r *http.Request) (int, string) {
+	if l.maxURLLength > 0 && len(r.RequestURI) > l.maxURLLength {
+		return http.StatusRequestURITooLong, rejectURLTooLong
+	}
+
+	if l.maxQueryParams > 0 && countQueryParams(r.URL.RawQuery) > l.maxQueryParams {
+		return http.StatusRequestURITooLong, rejectTooManyParams
+	}
+
+	if l.maxHeaderCount > 0 || l.maxHeaderSize > 0 {
+		count, size := 0, int64(0)
+		for name, values := range r.Header {
+			for _, value := range values {
+				count++
+				size += int64(len(name) + len(value) + 4) // ": " 和 "\r\n"
+			}
+		}
+		if l.maxHeaderCount > 0 && count > l.maxHeaderCount {
+			return http.StatusRequestHeaderFieldsTooLarge, rejectTooManyHeaders
+		}
+		if l.maxHeaderSize > 0 && size > l.maxHeaderSize {
+			return http.StatusRequestHeaderFieldsTooLarge, rejectHeadersTooLarge
+		}
+	}
+
+	if l.maxBodySize > 0 && r.ContentLength > l.maxBodySize {
+		return http.StatusRequestEntityTooLarge, rejectBodyTooLarge
+	}
+
+	if l.maxRanges > 0 && countRanges(r.Header.Get("Range")) > l.maxRanges {
+		return http.StatusRequestedRangeNotSatisfiable, rejectTooManyRanges
+	}
+
+	return 0, ""
+}
+
+// limitBody 限制未声明长度（分块传输）的请求体，读取超过上限时反向代理返回413
+func (l *requestLimits) limitBody(w http.ResponseWriter, r *http.Request) {
+	if l.maxBodySize > 0 && r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, l.maxBodySize)
+	}
+}
+
+// countQueryParams 统计查询参数个数，不解码参数
+func countQueryParams(rawQuery string) int {
+	count := 0
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// countRanges 统计Range请求头中的区间个数
+func countRanges(header string) int {
+	if header == "" {
+		return 0
+	}
+	return strings.Count(header, ",") + 1
+}
+
+// rejectRequest 拒绝请求，记录日志和指标
+func rejectRequest(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	rejectedRequests.Inc(reason)
+	log.Printf("Rejected request: %s %s [%s]: %s", r.Method, r.URL.Path, r.Host, reason)
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"toyou-proxy/config"
+)
+
+// errResponseTooLarge 上游响应体超过大小上限
+var errResponseTooLarge = errors.New("upstream response exceeds size limit")
+
+// responseBufferError 缓冲响应体（缓存、内容替换）时超过大小上限
+type responseBufferError struct {
+	err error
+}
+
+func (e *responseBufferError) Error() string { return e.err.Error() }
+func (e *responseBufferError) Unwrap() error { return e.err }
+
+// maxResponseSize 返回上游响应体大小上限，优先级：路由级 > 域名级，0表示不限制
+func (ph *ProxyHandler) maxResponseSize(hostRule *config.HostRule, routeRule *config.RouteRule) int64 {
+	var size string
+	if hostRule != nil && hostRule.MaxResponseSize != "" {
+		size = hostRule.MaxResponseSize
+	}
+	if routeRule != nil && routeRule.MaxResponseSize != "" {
+		size = routeRule.MaxResponseSize
+	}
+
+	limit, err := config.ParseSize(size)
+	if err != nil {
+		log.Printf("Invalid max_response_size '%s': %v", size, err)
+		return 0
+	}
+	return limit
+}
+
+// limitedBody 限制读取大小的响应体，超过上限时返回errResponseTooLarge
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+	request   *http.Request
+}
+
+// limitResponseBody 为响应体加上大小限制
+func limitResponseBody(resp *http.Response, limit int64) {
+	resp.Body = &limitedBody{
+		ReadCloser: resp.Body,
+		remaining:  limit,
+		limit:      limit,
+		request:    resp.Request,
+	}
+}
+
+// Read 读取响应体，多读一个字节以判断是否超过上限
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > lb.remaining+1 {
+		p = p[:lb.remaining+1]
+	}
+
+	n, err := lb.ReadCloser.Read(p)
+	lb.remaining -= int64(n)
+	if lb.remaining < 0 {
+		n += int(lb.remaining)
+		if lb.request != nil {
+			log.Printf("Upstream response for %s %s exceeded %d bytes, aborting", lb.request.Method, lb.request.URL.Path, lb.limit)
+		}
+		return n, errResponseTooLarge
+	}
+	return n, err
+}
+
+// checkDeclaredSize 检查响应声明的Content-Length是否超过上限
+func checkDeclaredSize(resp *http.Response, limit int64) error {
+	if resp.ContentLength > limit {
+		return fmt.Errorf("%w: content length %d > %d", errResponseTooLarge, resp.ContentLength, limit)
+	}
+	return nil
+}
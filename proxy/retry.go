@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/loadbalancer"
+	"toyou-proxy/metrics"
+)
+
+// upstreamRetries 重试的上游请求数，按服务和原因（状态码或error）分类
+var upstreamRetries = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_upstream_retries_total",
+	"Upstream requests retried by the retry policy.",
+	"service", "reason",
+)
+
+// 重试策略的默认值
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+// maxRetryBodySize 可以重试的请求体大小上限，请求体需要缓冲以便重新发送，超过上限或长度未知时不重试
+const maxRetryBodySize = 1 << 20
+
+// defaultRetryStatuses 默认重试的上游状态码
+var defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// defaultRetryMethods 默认允许重试的请求方法（幂等方法）
+var defaultRetryMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+
+// retryPolicy 解析后的重试策略
+type retryPolicy struct {
+	attempts      int
+	statuses      map[int]bool
+	methods       map[string]bool
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	perTryTimeout time.Duration
+}
+
+// newRetryPolicy 按配置创建重试策略，未配置的字段使用默认值
+func newRetryPolicy(cfg *config.RetryConfig) *retryPolicy {
+	policy := &retryPolicy{
+		attempts:      cfg.Attempts,
+		statuses:      make(map[int]bool),
+		methods:       make(map[string]bool),
+		backoff:       time.Duration(cfg.Backoff) * time.Millisecond,
+		maxBackoff:    time.Duration(cfg.MaxBackoff) * time.Millisecond,
+		perTryTimeout: time.Duration(cfg.PerTryTimeout) * time.Millisecond,
+	}
+
+	statuses := cfg.On
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, status := range statuses {
+		policy.statuses[status] = true
+	}
+
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = defaultRetryMethods
+	}
+	for _, method := range methods {
+		policy.methods[strings.ToUpper(strings.TrimSpace(method))] = true
+	}
+
+	if policy.backoff == 0 {
+		policy.backoff = defaultRetryBackoff
+	}
+	if policy.maxBackoff == 0 {
+		policy.maxBackoff = defaultRetryMaxBackoff
+	}
+	if policy.maxBackoff < policy.backoff {
+		policy.maxBackoff = policy.backoff
+	}
+	return policy
+}
+
+// retryPolicy 返回生效的重试策略，优先级：路由级 > 域名级，未配置时返回nil
+func (ph *ProxyHandler) retryPolicy(hostRule *config.HostRule, routeRule *config.RouteRule) *retryPolicy {
+	var cfg *config.RetryConfig
+	if hostRule != nil && hostRule.Retry != nil {
+		cfg = hostRule.Retry
+	}
+	if routeRule != nil && routeRule.Retry != nil {
+		cfg = routeRule.Retry
+	}
+	if cfg == nil {
+		return nil
+	}
+	return newRetryPolicy(cfg)
+}
+
+// delay 返回第n次重试（从1开始）前的等待时间：指数退避，在后一半区间内随机，避免多个请求同时重试
+func (p *retryPolicy) delay(retry int) time.Duration {
+	d := p.backoff
+	for i := 1; i < retry && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryTransport 按重试策略重新发送失败的上游请求
+type retryTransport struct {
+	next    http.RoundTripper
+	policy  *retryPolicy
+	service string
+	// nextTarget 选择重试的后端，tried为已尝试的后端地址；返回nil时重试同一后端
+	nextTarget func(req *http.Request, tried []string) *url.URL
+	// direct 把请求指向新的后端（URL、Host头、签名等）
+	direct func(req *http.Request, target *url.URL)
+}
+
+// RoundTrip 发送请求，连接失败、单次尝试超时或返回需要重试的状态码时等待后重试，
+// 最后一次尝试的结果返回给反向代理；客户端断开或请求超时后不再重试
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := t.policy.attempts
+	if !t.policy.methods[req.Method] {
+		attempts = 1
+	}
+
+	body, replayable, err := bufferRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		attempts = 1
+	}
+
+	var tried []string
+	current := req
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			current = req.Clone(req.Context())
+			if body != nil {
+				current.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			if target := t.nextTarget(current, tried); target != nil {
+				t.direct(current, target)
+			}
+		}
+		tried = append(tried, current.URL.Scheme+"://"+current.URL.Host)
+
+		resp, err := t.roundTrip(current)
+		reason, retry := t.shouldRetry(req, resp, err)
+		if !retry || attempt >= attempts {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		upstreamRetries.Inc(t.service, reason)
+		delay := t.policy.delay(attempt)
+		log.Printf("Retrying %s %s to service '%s' in %v (attempt %d/%d failed: %s)", req.Method, req.URL.Path, t.service, delay, attempt, attempts, reason)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// roundTrip 发送一次请求，配置了单次超时时为请求设置截止时间，响应体关闭时释放
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.policy.perTryTimeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.policy.perTryTimeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		cancel()
+		return resp, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// shouldRetry 判断结果是否需要重试，返回用于指标和日志的原因
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response, err error) (string, bool) {
+	if req.Context().Err() != nil {
+		return "", false
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return "", false
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "timeout", true
+		}
+		return "error", true
+	}
+	if t.policy.statuses[resp.StatusCode] {
+		return strconv.Itoa(resp.StatusCode), true
+	}
+	return "", false
+}
+
+// retryTarget 返回选择重试后端的函数：按负载均衡策略在尚未尝试的活跃后端中选择，
+// 没有负载均衡或所有后端都已尝试时返回nil，重试同一后端
+func retryTarget(lb loadbalancer.LoadBalancer) func(req *http.Request, tried []string) *url.URL {
+	// 会话保持总是选择会话所在的后端，重试时由内部负载均衡器选择，会话cookie随之指向处理请求的后端
+	if affinity, ok := lb.(*loadbalancer.SessionAffinityLoadBalancer); ok {
+		lb = affinity.LoadBalancer
+	}
+	return func(req *http.Request, tried []string) *url.URL {
+		if lb == nil {
+			return nil
+		}
+		var candidates []*loadbalancer.Backend
+		for _, backend := range lb.GetActiveBackends() {
+			target, err := url.Parse(backend.URL)
+			if err == nil && !containsName(tried, target.Scheme+"://"+target.Host) {
+				candidates = append(candidates, backend)
+			}
+		}
+		backend, err := loadbalancer.SelectBackend(lb, req, candidates)
+		if err != nil {
+			return nil
+		}
+		target, err := url.Parse(backend.URL)
+		if err != nil {
+			return nil
+		}
+		return target
+	}
+}
+
+// bufferRequestBody 读取请求体以便重试时重新发送，没有请求体时返回nil；
+// 请求体长度未知或超过上限时不缓冲，返回的replayable为false
+func bufferRequestBody(req *http.Request) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if req.ContentLength <= 0 || req.ContentLength > maxRetryBodySize {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, false, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true, nil
+}
+
+// cancelOnClose 关闭响应体时取消单次尝试的上下文
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
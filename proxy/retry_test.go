@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/loadbalancer"
+)
+
+// newTestLoadBalancer 创建包含backends的负载均衡器
+func newTestLoadBalancer(t *testing.T, strategy loadbalancer.LoadBalancerStrategy, backends ...string) loadbalancer.LoadBalancer {
+	t.Helper()
+	cfg := loadbalancer.LoadBalancerConfig{Strategy: strategy}
+	for _, backend := range backends {
+		cfg.Backends = append(cfg.Backends, loadbalancer.Backend{URL: backend, Weight: 1, Active: true})
+	}
+	lb, err := loadbalancer.NewLoadBalancer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lb
+}
+
+func TestRetryTargetSkipsTriedBackends(t *testing.T) {
+	backends := []string{"http://a.internal", "http://b.internal", "http://c.internal"}
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+
+	for _, strategy := range []loadbalancer.LoadBalancerStrategy{
+		loadbalancer.RoundRobin, loadbalancer.WeightedRoundRobin, loadbalancer.IPHash, loadbalancer.LeastConnections,
+		loadbalancer.ResponseTime, loadbalancer.Random, loadbalancer.WeightedRandom, loadbalancer.LeastOutstanding,
+	} {
+		next := retryTarget(newTestLoadBalancer(t, strategy, backends...))
+
+		// 无论策略的状态如何，每次都选择尚未尝试的后端
+		for i := 0; i < 20; i++ {
+			target := next(req, []string{backends[0], backends[2]})
+			if target == nil || target.String() != backends[1] {
+				t.Fatalf("%s: retry target = %v, want %s", strategy, target, backends[1])
+			}
+		}
+		if target := next(req, backends); target != nil {
+			t.Errorf("%s: retry target = %v after trying all backends", strategy, target)
+		}
+	}
+}
+
+func TestRetryTargetAppliesStrategyToUntriedBackends(t *testing.T) {
+	backends := []string{"http://a.internal", "http://b.internal", "http://c.internal"}
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+
+	// 最少连接：在未尝试的后端中选择连接数最少的
+	lb := newTestLoadBalancer(t, loadbalancer.LeastConnections, backends...)
+	lb.IncrementConnection(backends[1])
+	if target := retryTarget(lb)(req, []string{backends[0]}); target == nil || target.String() != backends[2] {
+		t.Errorf("least connections retry target = %v, want %s", target, backends[2])
+	}
+
+	// 轮询：在未尝试的后端中轮流选择
+	next := retryTarget(newTestLoadBalancer(t, loadbalancer.RoundRobin, backends...))
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		seen[next(req, []string{backends[0]}).String()] = true
+	}
+	if len(seen) != 2 || seen[backends[0]] {
+		t.Errorf("round robin retry targets = %v, want b and c", seen)
+	}
+}
+
+// newLoadBalancedRetryTransport 创建与反向代理相同组合的传输层：重试传输层包装负载均衡传输层
+func newLoadBalancedRetryTransport(lb loadbalancer.LoadBalancer, retry config.RetryConfig) *retryTransport {
+	return &retryTransport{
+		next:       &loadbalancer.LoadBalancerTransport{LoadBalancer: lb, Transport: http.DefaultTransport},
+		policy:     newRetryPolicy(&retry),
+		service:    "app",
+		nextTarget: retryTarget(lb),
+		direct: func(req *http.Request, target *url.URL) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+		},
+	}
+}
+
+// backendStats 返回后端当前的连接数和响应时间
+func backendStats(t *testing.T, lb loadbalancer.LoadBalancer, url string) (int, time.Duration) {
+	t.Helper()
+	for _, backend := range lb.GetBackends() {
+		if backend.URL == url {
+			return backend.Connections, backend.ResponseTime
+		}
+	}
+	t.Fatalf("backend %s not found", url)
+	return 0, 0
+}
+
+func TestRetryTransportAccountsEachAttempt(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer healthy.Close()
+
+	for _, tc := range []struct {
+		name    string
+		failing http.HandlerFunc
+		retry   config.RetryConfig
+		minTime time.Duration // 失败后端至少计入的响应时间
+	}{
+		{
+			name:    "status",
+			failing: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) },
+			retry:   config.RetryConfig{Attempts: 2, Backoff: 1},
+		},
+		{
+			name: "timeout",
+			failing: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+			retry:   config.RetryConfig{Attempts: 2, Backoff: 1, PerTryTimeout: 50},
+			minTime: 50 * time.Millisecond,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failing := httptest.NewServer(tc.failing)
+			defer failing.Close()
+			lb := newTestLoadBalancer(t, loadbalancer.RoundRobin, failing.URL, healthy.URL)
+			transport := newLoadBalancedRetryTransport(lb, tc.retry)
+
+			req := httptest.NewRequest(http.MethodGet, failing.URL+"/", nil)
+			req.RequestURI = ""
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 失败的尝试结束后不再计为连接，响应时间计入失败的后端
+			if connections, responseTime := backendStats(t, lb, failing.URL); connections != 0 || responseTime < tc.minTime || responseTime == 0 {
+				t.Errorf("failed backend: connections = %d, response time = %v", connections, responseTime)
+			}
+			// 返回的响应在响应体关闭前计为重试后端的连接
+			if connections, responseTime := backendStats(t, lb, healthy.URL); connections != 1 || responseTime == 0 {
+				t.Errorf("retried backend: connections = %d, response time = %v", connections, responseTime)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if connections, _ := backendStats(t, lb, healthy.URL); connections != 0 {
+				t.Errorf("retried backend: %d connections after closing the body", connections)
+			}
+		})
+	}
+}
+
+func TestRetryTransportReleasesUpgradedConnections(t *testing.T) {
+	// 上游返回101后回显收到的数据
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		buf.Flush()
+		line, _ := buf.ReadString('\n')
+		buf.WriteString(line)
+		buf.Flush()
+	}))
+	defer backend.Close()
+	lb := newTestLoadBalancer(t, loadbalancer.RoundRobin, backend.URL)
+	transport := newLoadBalancedRetryTransport(lb, config.RetryConfig{Attempts: 2, Backoff: 1, PerTryTimeout: 100})
+
+	req := httptest.NewRequest(http.MethodGet, backend.URL+"/", nil)
+	req.RequestURI = ""
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+
+	// 升级后的连接不计入，响应时间按握手计算
+	if connections, responseTime := backendStats(t, lb, backend.URL); connections != 0 || responseTime == 0 {
+		t.Errorf("connections = %d, response time = %v after the upgrade", connections, responseTime)
+	}
+
+	// 单次尝试的超时不影响升级后的连接
+	time.Sleep(150 * time.Millisecond)
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("upgraded body %T is not writable", resp.Body)
+	}
+	if _, err := io.WriteString(conn, "ping\n"); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "ping\n" {
+		t.Errorf("echo = %q, %v", line, err)
+	}
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/matcher"
+)
+
+// routeTable 路由表，包含某个监听器上生效的域名规则
+type routeTable struct {
+	port        int // 为0时表示不区分监听器
+	hostMatcher *matcher.HostMatcher
+	hostRules   []*config.HostRule // 指向配置中的域名规则，所有路由表共享，中间件链计划按规则查找
+	patterns    []string           // 与hostRules对应的规范化模式
+	routes      map[*config.HostRule]*hostRoutes
+}
+
+// hostRoutes 域名规则下编译好的路由规则，随路由表在加载配置时创建
+type hostRoutes struct {
+	matcher *matcher.RouteMatcher
+	rules   []*config.RouteRule // 下标为匹配器中的规则序号
+}
+
+// newHostRoutes 编译域名规则的路由规则，无效的模式已在配置验证时拒绝，这里跳过
+func newHostRoutes(hostRule *config.HostRule) *hostRoutes {
+	hr := &hostRoutes{matcher: matcher.NewRouteMatcher()}
+	for i := range hostRule.RouteRules {
+		routeRule := &hostRule.RouteRules[i]
+		if err := hr.matcher.AddRule(routeRule.Pattern, routeRule.Target); err != nil {
+			continue
+		}
+		hr.rules = append(hr.rules, routeRule)
+	}
+	return hr
+}
+
+// newRouteTable 创建包含全部域名规则的路由表
+func newRouteTable(hostRules []config.HostRule) *routeTable {
+	return buildRouteTable(0, hostRules, func(config.HostRule) bool { return true })
+}
+
+// newListenerRouteTable 创建监听器的路由表，只包含挂载到该监听器的域名规则
+func newListenerRouteTable(listener config.Listener, hostRules []config.HostRule) *routeTable {
+	return buildRouteTable(listener.Port, hostRules, listener.Attaches)
+}
+
+// buildRouteTable 使用attached筛选的域名规则创建路由表
+func buildRouteTable(port int, hostRules []config.HostRule, attached func(config.HostRule) bool) *routeTable {
+	rt := &routeTable{
+		port:        port,
+		hostMatcher: matcher.NewHostMatcher(),
+		routes:      make(map[*config.HostRule]*hostRoutes),
+	}
+
+	for i := range hostRules {
+		rule := &hostRules[i]
+		if !attached(*rule) {
+			continue
+		}
+		rt.hostRules = append(rt.hostRules, rule)
+		rt.patterns = append(rt.patterns, matcher.NormalizeHost(rule.Pattern))
+		rt.hostMatcher.AddRule(rule.Pattern, rule.Target)
+		rt.routes[rule] = newHostRoutes(rule)
+	}
+
+	return rt
+}
+
+// matchHost 返回域名匹配的、在生效时间窗口内的域名规则，matched表示是否有模式匹配域名。
+// 按匹配到的模式而不是目标服务查找规则，目标服务相同的多条规则各自使用自己的路由规则；
+// 同一模式有多条规则时（如按时间窗口切换目标）使用第一条生效的规则
+func (rt *routeTable) matchHost(host string, now time.Time) (hostRule *config.HostRule, matched bool) {
+	pattern, matched := rt.hostMatcher.MatchPattern(host)
+	if !matched {
+		return nil, false
+	}
+	for i := range rt.hostRules {
+		if rt.patterns[i] == pattern && config.IsActive(rt.hostRules[i].ActiveWindows, now) {
+			return rt.hostRules[i], true
+		}
+	}
+	return nil, true
+}
+
+// matchRoute 返回路径匹配的、在生效时间窗口内并且accept接受的路由规则，没有时返回nil；accept为nil时接受所有规则。
+// 代理和RouteLabeler都通过这里匹配，优先级见matcher.RouteMatcher：精确 > 最长前缀 > 通配和正则（按配置顺序），
+// 跳过的规则不影响其他规则，依次尝试下一个匹配的规则
+func (rt *routeTable) matchRoute(hostRule *config.HostRule, path string, now time.Time, accept func(*config.RouteRule) bool) *config.RouteRule {
+	routes, exists := rt.routes[hostRule]
+	if !exists {
+		// 不属于路由表的规则（如测试中构造的规则），临时编译
+		routes = newHostRoutes(hostRule)
+	}
+
+	var matched *config.RouteRule
+	routes.matcher.Walk(path, func(index int) bool {
+		routeRule := routes.rules[index]
+		if !config.IsActive(routeRule.ActiveWindows, now) || (accept != nil && !accept(routeRule)) {
+			return true
+		}
+		matched = routeRule
+		return false
+	})
+	return matched
+}
+
+// PortHandler 监听器级处理器
+// 插件、中间件工厂、服务注册表和负载均衡器等状态由所有监听器共享，
+// 每个监听器只持有自己的路由表
+type PortHandler struct {
+	handler *ProxyHandler
+	routes  *routeTable
+}
+
+// ForListener 创建指定监听器的处理器视图
+func (ph *ProxyHandler) ForListener(listener config.Listener) *PortHandler {
+	return &PortHandler{
+		handler: ph,
+		routes:  newListenerRouteTable(listener, ph.cfg.HostRules),
+	}
+}
+
+// ServeHTTP 处理HTTP请求
+func (h *PortHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.serve(w, r, h.routes)
+}
+
+// Port 返回监听端口
+func (h *PortHandler) Port() int {
+	return h.routes.port
+}
+
+// Handler 返回共享的代理处理器
+func (h *PortHandler) Handler() *ProxyHandler {
+	return h.handler
+}
+
+// RouteLabeler 按域名规则和路由规则匹配请求，返回与指标中route标签一致的规则名，用于离线分析（如压测报告）
+type RouteLabeler struct {
+	routes *routeTable
+}
+
+// NewRouteLabeler 创建规则匹配器
+func NewRouteLabeler(hostRules []config.HostRule) *RouteLabeler {
+	return &RouteLabeler{routes: newRouteTable(hostRules)}
+}
+
+// Label 返回请求匹配的规则名，与代理使用同一个匹配器但不检查目标服务是否存在，没有匹配的规则时返回空字符串
+func (l *RouteLabeler) Label(host, path string) string {
+	now := time.Now()
+	hostRule, _ := l.routes.matchHost(host, now)
+	if hostRule == nil {
+		return ""
+	}
+	return ruleLabel(hostRule, l.routes.matchRoute(hostRule, path, now, nil))
+}
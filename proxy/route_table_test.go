@@ -0,0 +1,381 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"toyou-proxy/config"
+	"toyou-proxy/registry"
+)
+
+// never 永远不生效的时间窗口（2月30日）
+var never = []config.ActiveWindow{{Schedule: "* * 30 2 *"}}
+
+// newTestHandler 创建只包含服务注册表的代理处理器，用于测试路由匹配
+func newTestHandler(services ...string) *ProxyHandler {
+	reg := registry.NewServiceRegistry()
+	static := make(map[string]config.Service, len(services))
+	for _, name := range services {
+		static[name] = config.Service{URL: "http://" + name + ".internal"}
+	}
+	reg.LoadStatic(static)
+	return &ProxyHandler{services: reg}
+}
+
+// targetOf 返回请求匹配的目标服务、域名规则模式和路由规则模式，没有匹配时返回错误
+func targetOf(ph *ProxyHandler, routes *routeTable, host, path string) (string, string, string, error) {
+	r := httptest.NewRequest(http.MethodGet, "http://placeholder"+path, nil)
+	r.Host = host
+	service, hostRule, routeRule, err := ph.determineTarget(r, routes)
+	if err != nil {
+		return "", "", "", err
+	}
+	routePattern := ""
+	if routeRule != nil {
+		routePattern = routeRule.Pattern
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(service.URL, "http://"), ".internal"), hostRule.Pattern, routePattern, nil
+}
+
+func TestDetermineTargetOverlappingRules(t *testing.T) {
+	ph := newTestHandler("web", "api", "users", "admin", "docs")
+	routes := newRouteTable([]config.HostRule{
+		{
+			Pattern: "*.example.com",
+			Target:  "web",
+			RouteRules: []config.RouteRule{
+				{Pattern: "/api/*", Target: "api"},
+			},
+		},
+		{
+			Pattern: "api.example.com",
+			Target:  "api",
+			RouteRules: []config.RouteRule{
+				{Pattern: "/admin/*", Target: "admin"},
+				// 较长的前缀优先，与配置顺序无关
+				{Pattern: "/users/*", Target: "users"},
+				{Pattern: "/users/admin/*", Target: "admin"},
+			},
+		},
+		{
+			// 目标服务与 *.example.com 相同，但有自己的路由规则
+			Pattern: "docs.example.com",
+			Target:  "web",
+			RouteRules: []config.RouteRule{
+				{Pattern: "/**", Target: "docs"},
+			},
+		},
+		{
+			// 路由规则的目标服务不存在时回退到域名的目标
+			Pattern: "*.missing.example.com",
+			Target:  "web",
+			RouteRules: []config.RouteRule{
+				{Pattern: "/*", Target: "unknown"},
+			},
+		},
+	})
+
+	tests := []struct {
+		host, path           string
+		service, hostPattern string
+		routePattern         string
+	}{
+		{"www.example.com", "/", "web", "*.example.com", ""},
+		{"www.example.com", "/api/v1", "api", "*.example.com", "/api/*"},
+		{"example.com", "/api", "api", "*.example.com", "/api/*"},
+		{"api.example.com", "/api/v1", "api", "api.example.com", ""},
+		{"api.example.com", "/admin/users", "admin", "api.example.com", "/admin/*"},
+		{"api.example.com", "/users/admin/x", "admin", "api.example.com", "/users/admin/*"},
+		{"api.example.com", "/users/42", "users", "api.example.com", "/users/*"},
+		{"docs.example.com", "/guide", "docs", "docs.example.com", "/**"},
+		{"a.missing.example.com", "/x", "web", "*.missing.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		service, hostPattern, routePattern, err := targetOf(ph, routes, tt.host, tt.path)
+		if err != nil {
+			t.Errorf("%s%s: %v", tt.host, tt.path, err)
+			continue
+		}
+		if service != tt.service || hostPattern != tt.hostPattern || routePattern != tt.routePattern {
+			t.Errorf("%s%s = %s via %q %q, want %s via %q %q",
+				tt.host, tt.path, service, hostPattern, routePattern, tt.service, tt.hostPattern, tt.routePattern)
+		}
+	}
+}
+
+func TestDetermineTargetRoutePrecedence(t *testing.T) {
+	ph := newTestHandler("web", "catch-all", "api", "v1", "health", "night")
+	hostRules := []config.HostRule{{
+		Pattern: "example.com",
+		Target:  "web",
+		RouteRules: []config.RouteRule{
+			// 通配和正则按配置顺序，排在前缀之后
+			{Pattern: "/**", Target: "catch-all"},
+			{Pattern: "/api/*", Target: "api"},
+			{Pattern: "/api/v1/*", Target: "v1"},
+			{Pattern: "/api/v1/health", Target: "health"},
+			// 优先级更高的规则不在生效时间窗口内或目标服务不存在时，依次尝试下一个匹配的规则
+			{Pattern: "/api/v2/*", Target: "night", ActiveWindows: never},
+			{Pattern: "/api/v3/*", Target: "missing"},
+		},
+	}}
+	routes := newRouteTable(hostRules)
+	labeler := NewRouteLabeler(hostRules)
+
+	tests := []struct {
+		path, service, routePattern string
+	}{
+		{"/api/v1/health", "health", "/api/v1/health"},
+		{"/api/v1/users", "v1", "/api/v1/*"},
+		{"/api/users", "api", "/api/*"},
+		{"/api/v2/users", "api", "/api/*"},
+		{"/api/v3/users", "api", "/api/*"},
+		{"/other", "catch-all", "/**"},
+	}
+	for _, tt := range tests {
+		service, _, routePattern, err := targetOf(ph, routes, "example.com", tt.path)
+		if err != nil || service != tt.service || routePattern != tt.routePattern {
+			t.Errorf("%s = %s via %q, %v, want %s via %q", tt.path, service, routePattern, err, tt.service, tt.routePattern)
+		}
+		// 规则名使用同一个匹配器，只有目标服务不存在的规则结果不同
+		if tt.path == "/api/v3/users" {
+			continue
+		}
+		if label, want := labeler.Label("example.com", tt.path), "example.com"+tt.routePattern; label != want {
+			t.Errorf("Label(%s) = %q, want %q", tt.path, label, want)
+		}
+	}
+}
+
+func TestDetermineTargetActiveWindows(t *testing.T) {
+	ph := newTestHandler("old", "new", "maintenance", "api")
+	routes := newRouteTable([]config.HostRule{
+		// 同一模式按时间窗口切换目标，使用第一条生效的规则
+		{Pattern: "switch.example.com", Target: "old", ActiveWindows: never},
+		{Pattern: "switch.example.com", Target: "new"},
+		{
+			Pattern: "api.example.com",
+			Target:  "api",
+			RouteRules: []config.RouteRule{
+				{Pattern: "/*", Target: "maintenance", ActiveWindows: never},
+			},
+		},
+		{Pattern: "off.example.com", Target: "old", ActiveWindows: never},
+	})
+
+	for _, tt := range []struct{ host, service string }{
+		{"switch.example.com", "new"},
+		{"api.example.com", "api"},
+	} {
+		service, _, _, err := targetOf(ph, routes, tt.host, "/x")
+		if err != nil || service != tt.service {
+			t.Errorf("%s = %q, %v, want %q", tt.host, service, err, tt.service)
+		}
+	}
+
+	if _, _, _, err := targetOf(ph, routes, "off.example.com", "/"); err == nil {
+		t.Error("inactive host rule matched")
+	}
+}
+
+func TestDetermineTargetUnicodeHosts(t *testing.T) {
+	ph := newTestHandler("books", "cn")
+	routes := newRouteTable([]config.HostRule{
+		{Pattern: "bücher.example", Target: "books"},
+		{Pattern: "*.例子.测试", Target: "cn"},
+	})
+
+	tests := map[string]string{
+		"bücher.example":              "books",
+		"xn--bcher-kva.example":       "books",
+		"XN--BCHER-KVA.EXAMPLE.:8443": "books",
+		"www.xn--fsqu00a.xn--0zwm56d": "cn",
+		"例子.测试":                       "cn",
+	}
+	for host, want := range tests {
+		service, _, _, err := targetOf(ph, routes, host, "/")
+		if err != nil || service != want {
+			t.Errorf("%s = %q, %v, want %q", host, service, err, want)
+		}
+	}
+
+	if _, _, _, err := targetOf(ph, routes, "bucher.example", "/"); err == nil {
+		t.Error("bucher.example matched bücher.example")
+	}
+}
+
+func TestDetermineTargetAdversarialPaths(t *testing.T) {
+	ph := newTestHandler("public", "admin")
+	routes := newRouteTable([]config.HostRule{
+		{
+			Pattern: "example.com",
+			Target:  "public",
+			RouteRules: []config.RouteRule{
+				{Pattern: "/admin/*", Target: "admin"},
+			},
+		},
+	})
+
+	// 启用请求规范化后，非规范路径按规范化后的路径匹配，不能绕过 /admin/* 上的规则
+	tests := []struct {
+		rawPath string
+		service string
+	}{
+		{"/admin/x", "admin"},
+		{"/public/../admin/x", "admin"},
+		{"//admin/x", "admin"},
+		{"/./admin/./x", "admin"},
+		{"/admin%2Fx", "admin"},
+		{"/%61dmin/x", "admin"},
+		{"/../../admin", "admin"},
+		{"/adminx", "public"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com"+tt.rawPath, nil)
+		normalizeRequest(r, config.NormalizeConfig{Enabled: true})
+		service, _, _, err := ph.determineTarget(r, routes)
+		if err != nil {
+			t.Errorf("%s: %v", tt.rawPath, err)
+			continue
+		}
+		if got := strings.TrimSuffix(strings.TrimPrefix(service.URL, "http://"), ".internal"); got != tt.service {
+			t.Errorf("%s (normalized to %s) = %s, want %s", tt.rawPath, r.URL.Path, got, tt.service)
+		}
+	}
+}
+
+func TestDetermineTargetNoMatch(t *testing.T) {
+	ph := newTestHandler("web")
+	routes := newRouteTable([]config.HostRule{
+		{Pattern: "example.com", Target: "web"},
+		// 目标服务不存在
+		{Pattern: "broken.example.com", Target: "missing"},
+	})
+
+	for _, host := range []string{"example.org", "", "broken.example.com", "..example.com"} {
+		if _, _, _, err := targetOf(ph, routes, host, "/"); err == nil {
+			t.Errorf("%q matched", host)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.org/events", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	if _, _, _, err := ph.determineTarget(r, routes); err == nil || !strings.HasPrefix(err.Error(), "SSE connection failed") {
+		t.Errorf("unexpected error for SSE request: %v", err)
+	}
+}
+
+func TestRouteLabelerMatchesDetermineTarget(t *testing.T) {
+	hostRules := []config.HostRule{
+		{Pattern: "*.example.com", Target: "web", RouteRules: []config.RouteRule{{Pattern: "/api/*", Target: "api"}}},
+		{Pattern: "docs.example.com", Target: "web", RouteRules: []config.RouteRule{{Pattern: "/**", Target: "docs"}}},
+	}
+	ph := newTestHandler("web", "api", "docs")
+	routes := newRouteTable(hostRules)
+	labeler := NewRouteLabeler(hostRules)
+
+	for _, tt := range [][2]string{
+		{"www.example.com", "/api/x"},
+		{"www.example.com", "/"},
+		{"docs.example.com", "/api/x"},
+		{"example.org", "/"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://placeholder"+tt[1], nil)
+		r.Host = tt[0]
+		want := ""
+		if _, hostRule, routeRule, err := ph.determineTarget(r, routes); err == nil {
+			want = ruleLabel(hostRule, routeRule)
+		}
+		if got := labeler.Label(tt[0], tt[1]); got != want {
+			t.Errorf("Label(%s, %s) = %q, want %q", tt[0], tt[1], got, want)
+		}
+	}
+}
+
+func FuzzDetermineTarget(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"www.example.com", "/api/x"},
+		{"EXAMPLE.com.:80", "/"},
+		{"例子.测试", "/a/../b"},
+		{"[::1]:8080", "//x"},
+		{"", ""},
+		{"..example.com", "/%2e%2e/"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+
+	ph := newTestHandler("web", "api", "docs", "cn")
+	routes := newRouteTable([]config.HostRule{
+		{Pattern: "*.example.com", Target: "web", RouteRules: []config.RouteRule{{Pattern: "/api/*", Target: "api"}}},
+		{Pattern: "docs.example.com", Target: "web", RouteRules: []config.RouteRule{{Pattern: "~/v[0-9]+/.*", Target: "docs"}}},
+		{Pattern: "*.例子.测试", Target: "cn"},
+	})
+
+	f.Fuzz(func(t *testing.T, host, path string) {
+		r := httptest.NewRequest(http.MethodGet, "http://placeholder/", nil)
+		r.Host = host
+		r.URL.Path = path
+		normalizeRequest(r, config.NormalizeConfig{Enabled: true})
+
+		service, hostRule, routeRule, err := ph.determineTarget(r, routes)
+		if err != nil {
+			if service != nil || hostRule != nil || routeRule != nil {
+				t.Fatalf("determineTarget(%q, %q) returned a match with error %v", host, path, err)
+			}
+			return
+		}
+		if service == nil || hostRule == nil {
+			t.Fatalf("determineTarget(%q, %q) returned no service or host rule", host, path)
+		}
+		if service.URL != "http://"+ruleTarget(hostRule, routeRule)+".internal" {
+			t.Fatalf("determineTarget(%q, %q) = %s, want the target of %s", host, path, service.URL, ruleLabel(hostRule, routeRule))
+		}
+	})
+}
+
+func BenchmarkDetermineTarget(b *testing.B) {
+	for _, n := range []int{10, 100, 10000} {
+		services := []string{"default"}
+		hostRules := make([]config.HostRule, 0, n)
+		for i := 0; i < n; i++ {
+			name := fmt.Sprintf("svc%d", i)
+			services = append(services, name)
+			pattern := fmt.Sprintf("host%d.example.com", i)
+			if i%2 == 1 {
+				pattern = fmt.Sprintf("*.zone%d.example.com", i)
+			}
+			hostRules = append(hostRules, config.HostRule{
+				Pattern: pattern,
+				Target:  name,
+				RouteRules: []config.RouteRule{
+					{Pattern: "/api/*", Target: "default"},
+					{Pattern: "/static/**/*.css", Target: "default"},
+				},
+			})
+		}
+		ph := newTestHandler(services...)
+		routes := newRouteTable(hostRules)
+
+		b.Run(fmt.Sprintf("exact/%d", n), func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, "http://host0.example.com/static/a/b/site.css", nil)
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := ph.determineTarget(r, routes); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("wildcard/%d", n), func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://www.zone%d.example.com/api/x", n-1), nil)
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := ph.determineTarget(r, routes); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
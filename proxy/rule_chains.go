@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"sort"
+	"time"
+
+	"toyou-proxy/config"
+)
+
+// HostRuleChains 域名规则及其路由规则解析后的中间件链
+type HostRuleChains struct {
+	Pattern string `json:"pattern"`
+	Target  string `json:"target"`
+	Port    int    `json:"port,omitempty"`
+	Active  bool   `json:"active"` // 当前是否在生效时间窗口内
+	// 没有匹配的路由规则时的规则名和中间件链
+	Route       string            `json:"route"`
+	Middlewares []ChainMiddleware `json:"middlewares"`
+	Routes      []RouteChain      `json:"routes"`
+}
+
+// RouteChain 路由规则解析后的中间件链
+type RouteChain struct {
+	Route       string            `json:"route"` // 规则名，与指标中的route标签一致
+	Pattern     string            `json:"pattern"`
+	Target      string            `json:"target"`
+	Active      bool              `json:"active"`
+	Middlewares []ChainMiddleware `json:"middlewares"`
+}
+
+// ChainMiddleware 中间件链中的一个中间件，按执行顺序排列
+type ChainMiddleware struct {
+	Name   string `json:"name"`
+	Source string `json:"source"` // route、host、global 或 global service
+}
+
+// RuleChains 按当前的配置和中间件启用状态解析每个域名规则和路由规则的中间件链，不创建中间件
+// 规则引用但不存在的中间件不包括在链中，由SelfCheck报告
+func (ph *ProxyHandler) RuleChains() []HostRuleChains {
+	now := time.Now()
+	chains := func(hostRule *config.HostRule, routeRule *config.RouteRule) []ChainMiddleware {
+		middlewares := []ChainMiddleware{}
+		for _, entry := range ph.resolveChain(hostRule, routeRule, nil, now) {
+			if entry.create != nil {
+				middlewares = append(middlewares, ChainMiddleware{Name: entry.name, Source: entry.source})
+			}
+		}
+		return middlewares
+	}
+
+	rules := make([]HostRuleChains, 0, len(ph.cfg.HostRules))
+	for i := range ph.cfg.HostRules {
+		hostRule := &ph.cfg.HostRules[i]
+		rule := HostRuleChains{
+			Pattern:     hostRule.Pattern,
+			Target:      hostRule.Target,
+			Port:        hostRule.Port,
+			Active:      config.IsActive(hostRule.ActiveWindows, now),
+			Route:       ruleLabel(hostRule, nil),
+			Middlewares: chains(hostRule, nil),
+			Routes:      make([]RouteChain, 0, len(hostRule.RouteRules)),
+		}
+		for j := range hostRule.RouteRules {
+			routeRule := &hostRule.RouteRules[j]
+			rule.Routes = append(rule.Routes, RouteChain{
+				Route:       ruleLabel(hostRule, routeRule),
+				Pattern:     routeRule.Pattern,
+				Target:      routeRule.Target,
+				Active:      config.IsActive(routeRule.ActiveWindows, now),
+				Middlewares: chains(hostRule, routeRule),
+			})
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Plugins 返回注册成功的插件（按名称排序）和编译或加载失败的插件
+func (ph *ProxyHandler) Plugins() (loaded []string, failed map[string]error) {
+	loaded = append([]string{}, ph.plugins...)
+	sort.Strings(loaded)
+	failed = make(map[string]error, len(ph.pluginErrors))
+	for name, err := range ph.pluginErrors {
+		failed[name] = err
+	}
+	return loaded, failed
+}
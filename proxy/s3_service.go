@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+
+	"toyou-proxy/config"
+	"toyou-proxy/objectstore"
+)
+
+// defaultIndexDocument 请求路径以/结尾时读取的对象
+const defaultIndexDocument = "index.html"
+
+// configureS3Proxy 将反向代理改为访问S3兼容存储桶：请求路径映射为对象键，
+// 使用服务配置的凭证签名，客户端的凭证和x-amz-*请求头不会转发
+func (ph *ProxyHandler) configureS3Proxy(proxy *httputil.ReverseProxy, service *config.Service) error {
+	s3cfg := service.S3
+	client, err := objectstore.NewClient(objectstore.Config{
+		Endpoint:  service.URL,
+		Region:    s3cfg.Region,
+		Bucket:    s3cfg.Bucket,
+		PathStyle: s3cfg.PathStyle,
+		Credentials: objectstore.Credentials{
+			AccessKey:    s3cfg.AccessKey,
+			SecretKey:    s3cfg.SecretKey,
+			SessionToken: s3cfg.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid s3 service: %v", err)
+	}
+
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+
+		target := client.ObjectURL(s3ObjectKey(s3cfg, req.URL.Path))
+		req.URL = target
+		req.Host = target.Host
+
+		for name := range req.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+				req.Header.Del(name)
+			}
+		}
+		req.Header.Del("Authorization")
+		req.Header.Del("Cookie")
+
+		client.Sign(req, objectstore.EmptyPayloadHash)
+	}
+
+	transport := proxy.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	proxy.Transport = &s3ReadOnlyTransport{next: transport}
+
+	modifyResponse := proxy.ModifyResponse
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		for name := range resp.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+				resp.Header.Del(name)
+			}
+		}
+
+		// 没有ListBucket权限时不存在的对象返回403，统一按404处理，不暴露存储桶的错误详情
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound {
+			if resp.StatusCode == http.StatusForbidden {
+				log.Printf("S3 service: access denied for %s, returning 404", resp.Request.URL.Path)
+			}
+			resp.Body.Close()
+			setPlainResponse(resp, http.StatusNotFound)
+		}
+
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
+	return nil
+}
+
+// s3ObjectKey 将请求路径映射为对象键
+func s3ObjectKey(s3cfg *config.S3ServiceConfig, requestPath string) string {
+	key := strings.TrimPrefix(requestPath, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		index := s3cfg.IndexDocument
+		if index == "" {
+			index = defaultIndexDocument
+		}
+		key += index
+	}
+	if prefix := strings.Trim(s3cfg.Prefix, "/"); prefix != "" {
+		key = prefix + "/" + key
+	}
+	return key
+}
+
+// s3ReadOnlyTransport 只允许读取对象，其余方法不发往存储桶
+type s3ReadOnlyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *s3ReadOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	setPlainResponse(resp, http.StatusMethodNotAllowed)
+	resp.Header.Set("Allow", "GET, HEAD")
+	return resp, nil
+}
+
+// setPlainResponse 将响应替换为纯文本错误
+func setPlainResponse(resp *http.Response, status int) {
+	body := http.StatusText(status) + "\n"
+	for name := range resp.Header {
+		resp.Header.Del(name)
+	}
+	resp.StatusCode = status
+	resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(strings.NewReader(body))
+}
@@ -0,0 +1,17 @@
+package proxy
+
+import (
+	"toyou-proxy/config"
+)
+
+// middlewareConfigs 返回配置中所有中间件和中间件服务的config，用于加载时解析密钥引用
+func middlewareConfigs(cfg *config.Config) []map[string]interface{} {
+	configs := make([]map[string]interface{}, 0, len(cfg.Middlewares)+len(cfg.MiddlewareServices))
+	for _, mw := range cfg.Middlewares {
+		configs = append(configs, mw.Config)
+	}
+	for _, service := range cfg.MiddlewareServices {
+		configs = append(configs, service.Config)
+	}
+	return configs
+}
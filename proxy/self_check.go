@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+
+	"toyou-proxy/config"
+	"toyou-proxy/matcher"
+)
+
+// SelfCheck 检查插件、路由规则和中间件链能否正常工作，返回发现的问题
+// 中间件会按配置实际创建一次，用于启动前的自检
+func (ph *ProxyHandler) SelfCheck() []error {
+	var problems []error
+
+	// 插件编译或加载失败
+	pluginNames := make([]string, 0, len(ph.pluginErrors))
+	for name := range ph.pluginErrors {
+		pluginNames = append(pluginNames, name)
+	}
+	sort.Strings(pluginNames)
+	for _, name := range pluginNames {
+		problems = append(problems, fmt.Errorf("plugin '%s': %v", name, ph.pluginErrors[name]))
+	}
+
+	enabledMiddlewares := make(map[string]config.Middleware)
+	for _, mwConfig := range ph.cfg.Middlewares {
+		if mwConfig.Enabled {
+			enabledMiddlewares[mwConfig.Name] = mwConfig
+		}
+	}
+
+	// 全局中间件
+	for _, mwConfig := range ph.cfg.Middlewares {
+		if !mwConfig.Enabled {
+			continue
+		}
+		if _, err := ph.factory.CreateMiddleware(mwConfig.Name, mwConfig.Config); err != nil {
+			problems = append(problems, fmt.Errorf("middleware '%s': %v", mwConfig.Name, err))
+		}
+	}
+
+	// 中间件服务，配置了type时按类型创建
+	for _, service := range ph.cfg.MiddlewareServices {
+		if !service.Enabled {
+			continue
+		}
+		kind := service.Type
+		if kind == "" {
+			kind = service.Name
+		}
+		if _, err := ph.factory.CreateMiddleware(kind, service.Config); err != nil {
+			problems = append(problems, fmt.Errorf("middleware service '%s': %v", service.Name, err))
+		}
+	}
+
+	// 域名规则及其路由规则
+	for _, hostRule := range ph.cfg.HostRules {
+		scope := fmt.Sprintf("host rule '%s'", hostRule.Pattern)
+		problems = append(problems, ph.checkTarget(scope, hostRule.Target)...)
+		problems = append(problems, ph.checkMiddlewares(scope, hostRule.Middlewares, enabledMiddlewares)...)
+
+		for _, routeRule := range hostRule.RouteRules {
+			scope := fmt.Sprintf("route rule '%s' of host '%s'", routeRule.Pattern, hostRule.Pattern)
+			problems = append(problems, ph.checkRoutePattern(scope, routeRule.Pattern)...)
+			problems = append(problems, ph.checkTarget(scope, routeRule.Target)...)
+			problems = append(problems, ph.checkMiddlewares(scope, routeRule.Middlewares, enabledMiddlewares)...)
+		}
+	}
+
+	for _, routeRule := range ph.cfg.RouteRules {
+		scope := fmt.Sprintf("route rule '%s'", routeRule.Pattern)
+		problems = append(problems, ph.checkRoutePattern(scope, routeRule.Pattern)...)
+		problems = append(problems, ph.checkTarget(scope, routeRule.Target)...)
+		problems = append(problems, ph.checkMiddlewares(scope, routeRule.Middlewares, enabledMiddlewares)...)
+	}
+
+	return problems
+}
+
+// checkTarget 检查目标服务是否存在
+func (ph *ProxyHandler) checkTarget(scope, target string) []error {
+	if _, exists := ph.services.Get(target); !exists {
+		return []error{fmt.Errorf("%s: target service '%s' is not defined", scope, target)}
+	}
+	return nil
+}
+
+// checkRoutePattern 检查路由模式能否编译
+func (ph *ProxyHandler) checkRoutePattern(scope, pattern string) []error {
+	if _, err := matcher.CompilePathPattern(pattern); err != nil {
+		return []error{fmt.Errorf("%s: invalid pattern: %v", scope, err)}
+	}
+	return nil
+}
+
+// checkMiddlewares 检查规则引用的中间件能否创建，解析顺序与createDynamicMiddlewareChain一致
+func (ph *ProxyHandler) checkMiddlewares(scope string, names []string, enabledMiddlewares map[string]config.Middleware) []error {
+	var problems []error
+	for _, name := range names {
+		if _, err := ph.factory.CreateMiddleware(name, nil); err == nil {
+			continue
+		}
+
+		mwConfig, exists := enabledMiddlewares[name]
+		if !exists {
+			problems = append(problems, fmt.Errorf("%s: middleware '%s' not found or disabled", scope, name))
+			continue
+		}
+		if _, err := ph.factory.CreateMiddleware(mwConfig.Name, mwConfig.Config); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", scope, err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"log"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+	"toyou-proxy/middleware/builtin/sessionlimit"
+)
+
+// applySessionLimit 将域名规则的并发会话限制放入上下文，链中没有会话限制中间件时追加到链尾，
+// 保证在认证中间件设置身份之后执行
+func (ph *ProxyHandler) applySessionLimit(chain middleware.MiddlewareChain, ctx *middleware.Context, hostRule *config.HostRule) bool {
+	if hostRule == nil || hostRule.SessionLimit == nil {
+		return true
+	}
+	ctx.Set(sessionlimit.RequirementKey, &sessionlimit.Requirement{
+		Scope: hostRule.Pattern,
+		Limit: hostRule.SessionLimit,
+	})
+
+	for _, name := range chain.GetMiddlewareNames() {
+		if name == "session_limit" {
+			return true
+		}
+	}
+
+	mw, err := ph.factory.CreateMiddleware("session_limit", nil)
+	if err != nil {
+		// 无法执行会话限制时拒绝请求，而不是放行
+		log.Printf("Failed to create session_limit middleware: %v", err)
+		return false
+	}
+	chain.Add(mw)
+	return true
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"toyou-proxy/inflight"
+)
+
+// sseReconnectDelay 重新加载后关闭SSE流时建议客户端等待的重新连接时间
+const sseReconnectDelay = time.Second
+
+// sseReconnectHint 关闭SSE流前发送的提示，retry字段设置EventSource的重新连接间隔
+var sseReconnectHint = fmt.Sprintf(": configuration reloaded, reconnect\nretry: %d\n\n", sseReconnectDelay.Milliseconds())
+
+// reloadAwareSSEBody 上游SSE响应体，重新加载的宽限期结束后关闭上游连接，
+// 向客户端发送重新连接提示后正常结束响应，EventSource随之重新连接到新配置
+type reloadAwareSSEBody struct {
+	body     io.ReadCloser
+	resp     *http.Response
+	retiring atomic.Bool
+	lastByte byte
+	hint     []byte // 尚未发送的提示，nil表示还没有开始发送
+}
+
+// watchSSEReload 包装上游SSE响应体并登记重新加载后的关闭方式
+func watchSSEReload(resp *http.Response) {
+	body := &reloadAwareSSEBody{body: resp.Body, resp: resp, lastByte: '\n'}
+	resp.Body = body
+	inflight.OnRetire(resp.Request.Context(), body.retire)
+}
+
+// retire 关闭上游连接，正在进行的Read随之返回，之后发送提示
+func (b *reloadAwareSSEBody) retire() {
+	b.retiring.Store(true)
+	b.body.Close()
+}
+
+// Read 转发上游数据；关闭后发送提示，提示发送完后返回io.EOF
+func (b *reloadAwareSSEBody) Read(p []byte) (int, error) {
+	if b.hint != nil {
+		n := copy(p, b.hint)
+		b.hint = b.hint[n:]
+		if len(b.hint) > 0 {
+			return n, nil
+		}
+		inflight.MarkHinted(b.resp.Request.Context())
+		return n, io.EOF
+	}
+
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.lastByte = p[n-1]
+	}
+	if err != nil && b.retiring.Load() {
+		// 上游的事件可能只发送了一部分，先结束当前行
+		b.hint = []byte(sseReconnectHint)
+		if b.lastByte != '\n' {
+			b.hint = append([]byte("\n"), b.hint...)
+		}
+		if n > 0 {
+			return n, nil
+		}
+		return b.Read(p)
+	}
+	return n, err
+}
+
+// Close 关闭上游响应体
+func (b *reloadAwareSSEBody) Close() error {
+	return b.body.Close()
+}
+
+// wsRetireTimeout 重新加载后关闭WebSocket连接时，等待上游正在发送的帧发送完的最长时间
+const wsRetireTimeout = time.Second
+
+// wsFrameTracker 跟踪上游发往客户端的数据是否停在WebSocket帧的边界，
+// 只有在两帧之间才能插入关闭帧
+type wsFrameTracker struct {
+	header    [14]byte
+	headerLen int    // 已读取的帧头字节数
+	remaining uint64 // 当前帧剩余的负载字节数
+}
+
+// consume 处理转发的数据
+func (f *wsFrameTracker) consume(data []byte) {
+	for len(data) > 0 {
+		if f.remaining > 0 {
+			n := uint64(len(data))
+			if n > f.remaining {
+				n = f.remaining
+			}
+			f.remaining -= n
+			data = data[n:]
+			continue
+		}
+
+		f.header[f.headerLen] = data[0]
+		f.headerLen++
+		data = data[1:]
+		if f.headerLen < 2 || f.headerLen < f.headerSize() {
+			continue
+		}
+
+		// 帧头完整，读取负载长度
+		length := uint64(f.header[1] & 0x7f)
+		switch length {
+		case 126:
+			length = uint64(f.header[2])<<8 | uint64(f.header[3])
+		case 127:
+			length = 0
+			for _, b := range f.header[2:10] {
+				length = length<<8 | uint64(b)
+			}
+		}
+		f.remaining = length
+		f.headerLen = 0
+	}
+}
+
+// headerSize 根据帧头的前两个字节计算帧头长度
+func (f *wsFrameTracker) headerSize() int {
+	size := 2
+	switch f.header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if f.header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size
+}
+
+// atBoundary 判断已转发的数据是否以完整的帧结束
+func (f *wsFrameTracker) atBoundary() bool {
+	return f.headerLen == 0 && f.remaining == 0
+}
+
+// wsServiceRestartFrame 服务器发往客户端的关闭帧（不加掩码），状态码1012（Service Restart）提示客户端重新连接
+var wsServiceRestartFrame = func() []byte {
+	reason := "configuration reloaded"
+	frame := []byte{0x88, byte(2 + len(reason)), 0x03, 0xf4}
+	return append(frame, reason...)
+}()
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"toyou-proxy/config"
+	"toyou-proxy/inflight"
+	"toyou-proxy/middleware"
+)
+
+func TestWSFrameTracker(t *testing.T) {
+	frame := func(masked bool, payload int) []byte {
+		data := []byte{0x81}
+		mask := byte(0)
+		if masked {
+			mask = 0x80
+		}
+		switch {
+		case payload < 126:
+			data = append(data, mask|byte(payload))
+		case payload < 1<<16:
+			data = append(data, mask|126, byte(payload>>8), byte(payload))
+		default:
+			data = append(data, mask|127, 0, 0, 0, 0, byte(payload>>24), byte(payload>>16), byte(payload>>8), byte(payload))
+		}
+		if masked {
+			data = append(data, 1, 2, 3, 4)
+		}
+		return append(data, make([]byte, payload)...)
+	}
+
+	var stream []byte
+	for _, f := range [][]byte{frame(false, 0), frame(false, 5), frame(true, 300), frame(false, 70000), frame(true, 125)} {
+		stream = append(stream, f...)
+	}
+
+	// 按任意大小分块转发，只有在完整的帧之后才处于边界
+	for _, chunk := range []int{1, 3, 7, 4096, len(stream)} {
+		var tracker wsFrameTracker
+		for offset := 0; offset < len(stream); offset += chunk {
+			end := offset + chunk
+			if end > len(stream) {
+				end = len(stream)
+			}
+			tracker.consume(stream[offset:end])
+		}
+		if !tracker.atBoundary() {
+			t.Errorf("chunk %d: not at a boundary after complete frames", chunk)
+		}
+		tracker.consume(frame(false, 10)[:5])
+		if tracker.atBoundary() {
+			t.Errorf("chunk %d: at a boundary inside a frame", chunk)
+		}
+	}
+}
+
+// newStreamTestHandler 创建转发到backend的代理处理器
+func newStreamTestHandler(t *testing.T, backend string) *ProxyHandler {
+	t.Helper()
+	cfg := &config.Config{
+		HostRules: []config.HostRule{{Pattern: "stream.example.test", Target: "app"}},
+		Services:  map[string]config.Service{"app": {URL: backend}},
+	}
+	ph, err := newProxyHandler(cfg, middleware.NewMiddlewareFactory(), nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ph
+}
+
+// waitForStreams 等待处理器上登记的流达到n个
+func waitForStreams(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		streams := 0
+		for _, req := range inflight.GetDefaultTracker().List() {
+			if req.Kind != inflight.KindHTTP {
+				streams++
+			}
+		}
+		if streams == n {
+			return
+		}
+	}
+	t.Fatalf("expected %d streams", n)
+}
+
+func TestRetireSSEStreamSendsReconnectHint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+	ph := newStreamTestHandler(t, backend.URL)
+	proxy := httptest.NewServer(ph)
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/events", nil)
+	req.Host = "stream.example.test"
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != "data: first\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	waitForStreams(t, 1)
+
+	if retired := inflight.GetDefaultTracker().Retire(ph.Generation() + 1); retired != 1 {
+		t.Fatalf("retired %d streams, want 1", retired)
+	}
+	rest := make([]byte, 0, 128)
+	for {
+		line, err := reader.ReadString('\n')
+		rest = append(rest, line...)
+		if err != nil {
+			break
+		}
+	}
+	if !strings.HasSuffix(string(rest), sseReconnectHint) {
+		t.Errorf("stream ended with %q, want the reconnect hint", rest)
+	}
+}
+
+func TestRetireWebSocketSendsServiceRestart(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+	ph := newStreamTestHandler(t, backend.URL)
+	proxy := httptest.NewServer(ph)
+	defer proxy.Close()
+
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/socket", http.Header{"Host": {"stream.example.test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, message, err := conn.ReadMessage(); err != nil || string(message) != "hello" {
+		t.Fatalf("ReadMessage = %q, %v", message, err)
+	}
+	waitForStreams(t, 1)
+
+	// 当前配置上的流不受影响
+	if retired := inflight.GetDefaultTracker().Retire(ph.Generation()); retired != 0 {
+		t.Fatalf("retired %d streams of the current configuration", retired)
+	}
+	if retired := inflight.GetDefaultTracker().Retire(ph.Generation() + 1); retired != 1 {
+		t.Fatalf("retired %d streams, want 1", retired)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("ReadMessage error = %v, want close 1012", err)
+	}
+}
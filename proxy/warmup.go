@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"toyou-proxy/config"
+)
+
+// defaultWarmupTimeout 默认预热超时
+const defaultWarmupTimeout = 5 * time.Second
+
+// WarmUp 为配置了warmup的服务预先建立空闲连接，所有后端预热完成或超时后返回
+// 负载均衡服务只预热当前活跃的后端；预热失败只记录日志，不影响启动
+func (ph *ProxyHandler) WarmUp() {
+	services := ph.services.List()
+	names := make([]string, 0, len(services))
+	for name, service := range services {
+		if service.Warmup != nil && service.Warmup.Connections > 0 && service.Type != config.ServiceTypeS3 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		service := services[name]
+		for _, backend := range ph.warmupBackends(name, service) {
+			wg.Add(1)
+			go func(name, backend string, cfg config.WarmupConfig) {
+				defer wg.Done()
+				warmUpBackend(name, backend, cfg)
+			}(name, backend, *service.Warmup)
+		}
+	}
+	wg.Wait()
+}
+
+// configureWarmupTransport 代理使用http.DefaultTransport转发请求，每个后端保留的空闲连接数不能少于预热连接数，
+// 否则预热的连接会被立即关闭；在开始处理请求之前调用
+func configureWarmupTransport(services map[string]config.Service) {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return
+	}
+	for _, service := range services {
+		if service.Warmup != nil && service.Warmup.Connections > transport.MaxIdleConnsPerHost {
+			transport.MaxIdleConnsPerHost = service.Warmup.Connections
+		}
+	}
+}
+
+// warmupBackends 返回需要预热的后端地址
+func (ph *ProxyHandler) warmupBackends(name string, service config.Service) []string {
+	lbName := loadBalancerName(name, &service)
+	if lbName == "" {
+		return []string{service.URL}
+	}
+	lb, err := ph.loadBalancerMgr.GetLoadBalancer(lbName)
+	if err != nil || lb == nil {
+		return []string{service.URL}
+	}
+
+	var backends []string
+	for _, backend := range lb.GetActiveBackends() {
+		backends = append(backends, backend.URL)
+	}
+	return backends
+}
+
+// warmUpBackend 向后端并发发送HEAD请求，响应读完后连接回到连接池
+func warmUpBackend(service, backend string, cfg config.WarmupConfig) {
+	timeout := defaultWarmupTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	path := cfg.Path
+	if path == "" {
+		path = "/"
+	}
+	url := strings.TrimSuffix(backend, "/") + path
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	results := make(chan error, cfg.Connections)
+	for i := 0; i < cfg.Connections; i++ {
+		go func() {
+			results <- warmUpConnection(ctx, url)
+		}()
+	}
+
+	warmed := 0
+	var lastErr error
+	for i := 0; i < cfg.Connections; i++ {
+		if err := <-results; err != nil {
+			lastErr = err
+			continue
+		}
+		warmed++
+	}
+
+	if lastErr != nil {
+		log.Printf("Warm-up of service '%s' backend %s: %d/%d connections ready in %v, last error: %v",
+			service, backend, warmed, cfg.Connections, time.Since(start).Round(time.Millisecond), lastErr)
+		return
+	}
+	log.Printf("Warm-up of service '%s' backend %s: %d connections ready in %v",
+		service, backend, warmed, time.Since(start).Round(time.Millisecond))
+}
+
+// warmUpConnection 发送一个HEAD请求，任何状态码都说明连接已建立
+func warmUpConnection(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "toyou-proxy-warmup")
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
@@ -1,14 +1,21 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"toyou-proxy/headers"
+	"toyou-proxy/inflight"
 )
 
 // WebSocketProxy WebSocket代理处理器
@@ -55,8 +62,9 @@ func NewWebSocketProxy() *WebSocketProxy {
 	}
 }
 
-// ProxyWebSocket 代理WebSocket请求
-func (wp *WebSocketProxy) ProxyWebSocket(w http.ResponseWriter, r *http.Request, targetURL string) error {
+// ProxyWebSocket 代理WebSocket请求，proxyHost不为空时用作发往上游的Host头
+// 先连接上游并完成升级，再劫持客户端连接，返回错误时尚未向客户端写入响应
+func (wp *WebSocketProxy) ProxyWebSocket(w http.ResponseWriter, r *http.Request, targetURL, proxyHost string) error {
 	// 解析目标URL
 	target, err := url.Parse(targetURL)
 	if err != nil {
@@ -77,13 +85,6 @@ func (wp *WebSocketProxy) ProxyWebSocket(w http.ResponseWriter, r *http.Request,
 		RawQuery: r.URL.RawQuery, // 使用原始请求的查询参数
 	}
 
-	// 劫持客户端连接
-	clientConn, _, err := HijackConnection(w)
-	if err != nil {
-		return fmt.Errorf("failed to hijack client connection: %v", err)
-	}
-	defer clientConn.Close()
-
 	// 连接到目标WebSocket服务器
 	serverConn, err := ConnectToTargetServer(wsTarget, wp.handshakeTimeout)
 	if err != nil {
@@ -92,22 +93,39 @@ func (wp *WebSocketProxy) ProxyWebSocket(w http.ResponseWriter, r *http.Request,
 	defer serverConn.Close()
 
 	// 创建升级请求
-	upgradeReq, err := CreateWebSocketUpgradeRequest(r, wsTarget)
+	upgradeReq, err := CreateWebSocketUpgradeRequest(r, wsTarget, proxyHost)
 	if err != nil {
 		return fmt.Errorf("failed to create upgrade request: %v", err)
 	}
 
-	// 发送升级请求到目标服务器
-	resp, err := SendUpgradeRequest(serverConn, upgradeReq)
+	// 发送升级请求到目标服务器，握手超时后放弃
+	serverConn.SetDeadline(time.Now().Add(wp.handshakeTimeout))
+	resp, upstream, err := SendUpgradeRequest(serverConn, upgradeReq)
 	if err != nil {
 		return fmt.Errorf("failed to send upgrade request: %v", err)
 	}
 	defer resp.Body.Close()
+	serverConn.SetDeadline(time.Time{})
 
-	// 将升级响应直接写入客户端连接
-	err = resp.Write(clientConn)
+	// 上游升级成功后劫持客户端连接
+	clientConn, _, err := HijackConnection(w)
 	if err != nil {
-		return fmt.Errorf("failed to send upgrade response to client: %v", err)
+		return fmt.Errorf("failed to hijack client connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	// 将升级响应直接写入客户端连接，只保留协议升级相关的逐跳头部
+	// 中间件在升级前设置的响应头（如限流、会话Cookie）一并返回，上游的同名响应头优先
+	headers.RemoveHopByHopKeepUpgrade(resp.Header)
+	for name, values := range w.Header() {
+		if _, exists := resp.Header[name]; !exists {
+			resp.Header[name] = values
+		}
+	}
+	if err := resp.Write(clientConn); err != nil {
+		// 连接已被劫持，无法再返回错误响应
+		log.Printf("Failed to send WebSocket upgrade response to client: %v", err)
+		return nil
 	}
 
 	// 创建连接信息
@@ -132,16 +150,33 @@ func (wp *WebSocketProxy) ProxyWebSocket(w http.ResponseWriter, r *http.Request,
 	}()
 
 	// 启动双向数据转发
-	wp.bidirectionalCopy(clientConn, serverConn)
+	wp.bidirectionalCopy(r.Context(), clientConn, upstream)
 
 	return nil
 }
 
 // bidirectionalCopy 双向复制数据，使用自定义的复制逻辑
-func (wp *WebSocketProxy) bidirectionalCopy(clientConn, serverConn net.Conn) {
+// 请求被强制关闭（上下文取消）时关闭两端的连接；重新加载的宽限期结束后，
+// 在上游的两帧之间向客户端发送1012关闭帧，提示客户端重新连接到新配置
+func (wp *WebSocketProxy) bidirectionalCopy(ctx context.Context, clientConn, serverConn net.Conn) {
 	// 设置错误通道
 	errChan := make(chan error, 2)
 
+	stop := context.AfterFunc(ctx, func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	defer stop()
+
+	var retiring atomic.Bool
+	var retireDeadline atomic.Int64
+	inflight.OnRetire(ctx, func() {
+		retireDeadline.Store(time.Now().Add(wsRetireTimeout).UnixNano())
+		retiring.Store(true)
+		// 中断正在等待的读取，由转发协程在帧边界发送关闭帧
+		serverConn.SetReadDeadline(time.Now())
+	})
+
 	// 客户端到服务器的复制
 	go func() {
 		buf := make([]byte, 32*1024) // 32KB buffer
@@ -164,15 +199,32 @@ func (wp *WebSocketProxy) bidirectionalCopy(clientConn, serverConn net.Conn) {
 	// 服务器到客户端的复制
 	go func() {
 		buf := make([]byte, 32*1024) // 32KB buffer
+		var frames wsFrameTracker
 		for {
 			n, err := serverConn.Read(buf)
-			if err != nil {
-				errChan <- err
-				return
+			if n > 0 {
+				// 写入到客户端连接
+				if _, err := clientConn.Write(buf[:n]); err != nil {
+					errChan <- err
+					return
+				}
+				frames.consume(buf[:n])
 			}
 
-			// 写入到客户端连接
-			_, err = clientConn.Write(buf[:n])
+			if retiring.Load() {
+				if frames.atBoundary() {
+					if _, err := clientConn.Write(wsServiceRestartFrame); err == nil {
+						inflight.MarkHinted(ctx)
+					}
+					errChan <- nil
+					return
+				}
+				// 等待上游把当前帧发送完
+				if deadline := time.Unix(0, retireDeadline.Load()); isTimeout(err) && time.Now().Before(deadline) {
+					serverConn.SetReadDeadline(deadline)
+					continue
+				}
+			}
 			if err != nil {
 				errChan <- err
 				return
@@ -193,6 +245,12 @@ func (wp *WebSocketProxy) bidirectionalCopy(clientConn, serverConn net.Conn) {
 	}
 }
 
+// isTimeout 判断是否为读取超时
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // generateConnectionID 生成连接ID
 func generateConnectionID(r *http.Request) string {
 	return fmt.Sprintf("%s-%s-%d", r.RemoteAddr, r.Header.Get("Sec-WebSocket-Key"), time.Now().UnixNano())
@@ -3,6 +3,7 @@ package proxy
 import (
 	"bufio"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,26 +13,37 @@ import (
 	"time"
 
 	"toyou-proxy/config"
+	"toyou-proxy/headers"
 )
 
-// HandleWebSocketUpgrade 处理WebSocket协议升级
-func (ph *ProxyHandler) HandleWebSocketUpgrade(w http.ResponseWriter, r *http.Request, service *config.Service) error {
+// errNotWebSocketUpgrade 请求不是WebSocket升级请求
+var errNotWebSocketUpgrade = errors.New("not a WebSocket upgrade request")
+
+// HandleWebSocketUpgrade 处理WebSocket协议升级，服务配置了负载均衡时由负载均衡器选择后端
+// 返回错误时尚未向客户端写入响应，连接建立后的错误只记录日志，连接关闭后返回nil
+func (ph *ProxyHandler) HandleWebSocketUpgrade(w http.ResponseWriter, r *http.Request, serviceName string, service *config.Service) error {
 	// 检查是否是WebSocket升级请求
 	if !isWebSocketUpgrade(r) {
-		return fmt.Errorf("not a WebSocket upgrade request")
+		return errNotWebSocketUpgrade
 	}
 
-	// 解析目标URL
-	targetURL, err := url.Parse(service.URL)
+	targetURL, lb, err := ph.backendURL(serviceName, service, r)
 	if err != nil {
-		return fmt.Errorf("invalid target URL: %s", service.URL)
+		return err
+	}
+
+	// WebSocket连接在整个连接期间计为后端的连接，最少连接等策略据此选择后端
+	if lb != nil {
+		backendURL := targetURL.Scheme + "://" + targetURL.Host
+		lb.IncrementConnection(backendURL)
+		defer lb.DecrementConnection(backendURL)
 	}
 
 	// 创建WebSocket代理
 	wsProxy := NewWebSocketProxy()
 
 	// 代理WebSocket连接
-	return wsProxy.ProxyWebSocket(w, r, targetURL.String())
+	return wsProxy.ProxyWebSocket(w, r, targetURL.String(), service.ProxyHost)
 }
 
 // isWebSocketUpgrade 检查是否是WebSocket升级请求
@@ -76,39 +88,31 @@ func HijackConnection(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error
 	return conn, buf, nil
 }
 
-// CreateWebSocketUpgradeRequest 创建WebSocket升级请求
-func CreateWebSocketUpgradeRequest(r *http.Request, targetURL *url.URL) (*http.Request, error) {
+// CreateWebSocketUpgradeRequest 创建WebSocket升级请求，转发客户端（及中间件修改后）的请求头，
+// proxyHost不为空时用作发往上游的Host头
+func CreateWebSocketUpgradeRequest(r *http.Request, targetURL *url.URL, proxyHost string) (*http.Request, error) {
 	// 创建新的请求
 	req, err := http.NewRequest("GET", targetURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-
-	// 复制必要的头
-	headersToCopy := []string{
-		"Upgrade",
-		"Connection",
-		"Sec-WebSocket-Key",
-		"Sec-WebSocket-Version",
-		"Sec-WebSocket-Protocol",
-		"Sec-WebSocket-Extensions",
-		"Origin",
-		"User-Agent",
-		"Cookie",
-		"Authorization",
-	}
-
-	for _, header := range headersToCopy {
-		if value := r.Header.Get(header); value != "" {
-			req.Header.Set(header, value)
-		}
+	req.Header = r.Header.Clone()
+	if proxyHost != "" {
+		req.Host = proxyHost
 	}
 
 	// 设置X-Forwarded头
-	req.Header.Set("X-Forwarded-Proto", "http")
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Proto", proto)
 	req.Header.Set("X-Forwarded-Host", r.Host)
 	req.Header.Set("X-Forwarded-For", r.RemoteAddr)
 
+	// 客户端的Connection头可能带有其他逐跳选项，只保留协议升级
+	headers.RemoveHopByHopKeepUpgrade(req.Header)
+
 	return req, nil
 }
 
@@ -145,32 +149,49 @@ func ConnectToTargetServer(targetURL *url.URL, timeout time.Duration) (net.Conn,
 	return conn, nil
 }
 
-// SendUpgradeRequest 发送升级请求
-func SendUpgradeRequest(conn net.Conn, req *http.Request) (*http.Response, error) {
+// SendUpgradeRequest 发送升级请求，返回升级响应和之后用于转发的连接
+// 读取响应时可能已经缓冲了上游紧接着发送的WebSocket帧，返回的连接先读取这些数据
+func SendUpgradeRequest(conn net.Conn, req *http.Request) (*http.Response, net.Conn, error) {
 	// 发送请求
 	err := req.Write(conn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send upgrade request: %v", err)
+		return nil, nil, fmt.Errorf("failed to send upgrade request: %v", err)
 	}
 
 	// 读取响应
-	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read upgrade response: %v", err)
+		return nil, nil, fmt.Errorf("failed to read upgrade response: %v", err)
 	}
 
 	// 检查响应状态码
 	if resp.StatusCode != http.StatusSwitchingProtocols {
 		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if reader.Buffered() > 0 {
+		conn = &bufferedConn{Conn: conn, reader: reader}
 	}
+	return resp, conn, nil
+}
+
+// bufferedConn 先读取缓冲中剩余数据的连接
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
 
-	return resp, nil
+// Read 从缓冲读取，缓冲读完后直接读取连接
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
 }
 
 // SendUpgradeResponse 发送升级响应
 func SendUpgradeResponse(w http.ResponseWriter, resp *http.Response) error {
-	// 复制响应头
+	// 复制响应头，只保留协议升级相关的逐跳头部
+	headers.RemoveHopByHopKeepUpgrade(resp.Header)
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+)
+
+// newWebSocketTestHandler 创建把ws.example.test转发到service的代理处理器
+func newWebSocketTestHandler(t *testing.T, name string, service config.Service) *ProxyHandler {
+	t.Helper()
+	cfg := &config.Config{
+		HostRules: []config.HostRule{{Pattern: "ws.example.test", Target: name}},
+		Services:  map[string]config.Service{name: service},
+	}
+	ph, err := newProxyHandler(cfg, middleware.NewMiddlewareFactory(), nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ph
+}
+
+// dialWebSocket 通过代理建立WebSocket连接
+func dialWebSocket(proxyURL string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+	header.Set("Host", "ws.example.test")
+	return dialer.Dial("ws"+strings.TrimPrefix(proxyURL, "http")+"/socket", header)
+}
+
+func TestWebSocketUpgradeForwardsHeaders(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer backend.Close()
+	ph := newWebSocketTestHandler(t, "ws-headers", config.Service{URL: backend.URL, ProxyHost: "internal.example.test"})
+	proxy := httptest.NewServer(ph)
+	defer proxy.Close()
+
+	conn, _, err := dialWebSocket(proxy.URL, http.Header{"X-Request-Id": {"abc"}, "Keep-Alive": {"timeout=5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	r := <-received
+	if r.Host != "internal.example.test" {
+		t.Errorf("upstream Host = %q, want the proxy_host", r.Host)
+	}
+	if got := r.Header.Get("X-Request-Id"); got != "abc" {
+		t.Errorf("X-Request-Id = %q, want abc", got)
+	}
+	if got := r.Header.Get("Keep-Alive"); got != "" {
+		t.Errorf("hop-by-hop Keep-Alive forwarded: %q", got)
+	}
+	if got := r.Header.Get("X-Forwarded-Host"); got != "ws.example.test" {
+		t.Errorf("X-Forwarded-Host = %q", got)
+	}
+}
+
+func TestWebSocketUpgradeDialFailureReturnsBadGateway(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+	ph := newWebSocketTestHandler(t, "ws-down", config.Service{URL: backend.URL})
+	proxy := httptest.NewServer(ph)
+	defer proxy.Close()
+
+	_, resp, err := dialWebSocket(proxy.URL, http.Header{})
+	if err == nil {
+		t.Fatal("dial through the proxy succeeded with the backend down")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("response = %v, want 502", resp)
+	}
+}
+
+func TestWebSocketUpgradeCountsBackendConnections(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+	ph := newWebSocketTestHandler(t, "ws-lb", config.Service{LoadBalancer: &config.LoadBalancerConfig{
+		Strategy: "least_connections",
+		Backends: []config.LoadBalancerBackend{{URL: backend.URL, Weight: 1}},
+	}})
+	proxy := httptest.NewServer(ph)
+	defer proxy.Close()
+	lb, err := ph.loadBalancerMgr.GetLoadBalancer("ws-lb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connections := func() int { return lb.GetBackends()[0].Connections }
+
+	conn, _, err := dialWebSocket(proxy.URL, http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := connections(); n != 1 {
+		t.Errorf("connections while open = %d, want 1", n)
+	}
+	conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); connections() != 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+	}
+	if n := connections(); n != 0 {
+		t.Errorf("connections after close = %d, want 0", n)
+	}
+}
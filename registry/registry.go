@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"toyou-proxy/config"
+)
+
+// ServiceRegistry 运行时服务注册表接口
+// 静态服务来自配置文件，运行时服务可通过管理API或服务发现注册，同名时运行时服务优先
+type ServiceRegistry interface {
+	// Get 获取服务定义
+	Get(name string) (config.Service, bool)
+
+	// Register 注册或更新运行时服务
+	Register(name string, service config.Service) error
+
+	// Deregister 注销运行时服务，返回服务是否存在
+	Deregister(name string) bool
+
+	// LoadStatic 使用配置文件中的服务替换静态服务集合
+	LoadStatic(services map[string]config.Service)
+
+	// List 列出所有服务（运行时服务覆盖同名静态服务）
+	List() map[string]config.Service
+
+	// Entries 列出所有服务及其来源
+	Entries() []Entry
+
+	// Watch 注册运行时服务变化（注册、注销）时的回调
+	Watch(fn func())
+}
+
+// Entry 服务注册表条目
+type Entry struct {
+	Name    string         `json:"name"`
+	Service config.Service `json:"service"`
+	Source  string         `json:"source"` // static 或 runtime
+}
+
+// 服务来源
+const (
+	SourceStatic  = "static"
+	SourceRuntime = "runtime"
+)
+
+// DefaultServiceRegistry 默认服务注册表实现
+type DefaultServiceRegistry struct {
+	static   map[string]config.Service
+	runtime  map[string]config.Service
+	watchers []func()
+	mu       sync.RWMutex
+}
+
+// NewServiceRegistry 创建服务注册表
+func NewServiceRegistry() *DefaultServiceRegistry {
+	return &DefaultServiceRegistry{
+		static:  make(map[string]config.Service),
+		runtime: make(map[string]config.Service),
+	}
+}
+
+// Get 获取服务定义
+func (r *DefaultServiceRegistry) Get(name string) (config.Service, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if service, exists := r.runtime[name]; exists {
+		return service, true
+	}
+	service, exists := r.static[name]
+	return service, exists
+}
+
+// Register 注册或更新运行时服务
+func (r *DefaultServiceRegistry) Register(name string, service config.Service) error {
+	if name == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+	if service.URL == "" && service.LoadBalancer == nil && service.LoadBalancerRef == "" {
+		return fmt.Errorf("service '%s': url, load_balancer or load_balancer_ref is required", name)
+	}
+	if err := config.ValidateService(service); err != nil {
+		return fmt.Errorf("service '%s': %v", name, err)
+	}
+
+	r.mu.Lock()
+	r.runtime[name] = service
+	r.mu.Unlock()
+
+	r.notify()
+	return nil
+}
+
+// Deregister 注销运行时服务
+func (r *DefaultServiceRegistry) Deregister(name string) bool {
+	r.mu.Lock()
+	_, exists := r.runtime[name]
+	delete(r.runtime, name)
+	r.mu.Unlock()
+
+	if exists {
+		r.notify()
+	}
+	return exists
+}
+
+// LoadStatic 使用配置文件中的服务替换静态服务集合
+func (r *DefaultServiceRegistry) LoadStatic(services map[string]config.Service) {
+	static := make(map[string]config.Service, len(services))
+	for name, service := range services {
+		static[name] = service
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.static = static
+}
+
+// List 列出所有服务
+func (r *DefaultServiceRegistry) List() map[string]config.Service {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	services := make(map[string]config.Service, len(r.static)+len(r.runtime))
+	for name, service := range r.static {
+		services[name] = service
+	}
+	for name, service := range r.runtime {
+		services[name] = service
+	}
+	return services
+}
+
+// Entries 列出所有服务及其来源，按名称排序
+func (r *DefaultServiceRegistry) Entries() []Entry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(r.static)+len(r.runtime))
+	for name, service := range r.static {
+		if _, overridden := r.runtime[name]; overridden {
+			continue
+		}
+		entries = append(entries, Entry{Name: name, Service: service, Source: SourceStatic})
+	}
+	for name, service := range r.runtime {
+		entries = append(entries, Entry{Name: name, Service: service, Source: SourceRuntime})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// Watch 注册运行时服务变化时的回调，回调在注册或注销的调用方goroutine中执行
+func (r *DefaultServiceRegistry) Watch(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.watchers = append(r.watchers, fn)
+}
+
+// notify 调用变化回调，调用时不持有锁，回调中可以读取注册表
+func (r *DefaultServiceRegistry) notify() {
+	r.mu.RLock()
+	watchers := append([]func(){}, r.watchers...)
+	r.mu.RUnlock()
+
+	for _, fn := range watchers {
+		fn()
+	}
+}
+
+// 全局默认服务注册表实例
+var defaultRegistry = NewServiceRegistry()
+
+// GetDefaultRegistry 获取默认服务注册表实例
+func GetDefaultRegistry() ServiceRegistry {
+	return defaultRegistry
+}
@@ -0,0 +1,139 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField 单个cron字段，使用位图记录允许的取值
+type cronField struct {
+	bits       uint64
+	restricted bool // 是否为非"*"的限定取值
+}
+
+// has 检查取值是否被允许
+func (f cronField) has(v int) bool {
+	return f.bits&(1<<uint(v)) != 0
+}
+
+// CronSpec cron风格的时间表达式（分 时 日 月 周）
+type CronSpec struct {
+	minute cronField
+	hour   cronField
+	dom    cronField
+	month  cronField
+	dow    cronField
+}
+
+// fieldBounds 各字段的取值范围
+var fieldBounds = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron 解析cron表达式，支持 *、*/n、a-b、a-b/n 和逗号分隔的列表
+func ParseCron(spec string) (*CronSpec, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' must have 5 fields, got %d", spec, len(fields))
+	}
+
+	parsed := make([]cronField, 5)
+	for i, field := range fields {
+		f, err := parseCronField(field, fieldBounds[i].min, fieldBounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s field '%s': %v", fieldBounds[i].name, field, err)
+		}
+		parsed[i] = f
+	}
+
+	// 周字段中的7等同于0（周日）
+	if parsed[4].has(7) {
+		parsed[4].bits |= 1
+	}
+
+	return &CronSpec{
+		minute: parsed[0],
+		hour:   parsed[1],
+		dom:    parsed[2],
+		month:  parsed[3],
+		dow:    parsed[4],
+	}, nil
+}
+
+// parseCronField 解析单个cron字段
+func parseCronField(field string, min, max int) (cronField, error) {
+	result := cronField{restricted: field != "*"}
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx != -1 {
+			s, err := strconv.Atoi(part[idx+1:])
+			if err != nil || s <= 0 {
+				return result, fmt.Errorf("invalid step '%s'", part[idx+1:])
+			}
+			step = s
+			part = part[:idx]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			a, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return result, fmt.Errorf("invalid range start '%s'", bounds[0])
+			}
+			b, err := strconv.Atoi(bounds[1])
+			if err != nil {
+				return result, fmt.Errorf("invalid range end '%s'", bounds[1])
+			}
+			start, end = a, b
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return result, fmt.Errorf("invalid value '%s'", part)
+			}
+			start, end = v, v
+			// 单个取值配合步长时表示从该值开始到最大值
+			if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return result, fmt.Errorf("value out of range [%d-%d]", min, max)
+		}
+
+		for v := start; v <= end; v += step {
+			result.bits |= 1 << uint(v)
+		}
+	}
+
+	return result, nil
+}
+
+// Matches 检查给定时间（精确到分钟）是否满足表达式
+func (c *CronSpec) Matches(t time.Time) bool {
+	if !c.minute.has(t.Minute()) || !c.hour.has(t.Hour()) || !c.month.has(int(t.Month())) {
+		return false
+	}
+
+	domMatch := c.dom.has(t.Day())
+	dowMatch := c.dow.has(int(t.Weekday()))
+
+	// 与标准cron一致：日和周同时限定时，满足其一即可
+	if c.dom.restricted && c.dow.restricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
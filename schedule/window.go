@@ -0,0 +1,68 @@
+package schedule
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Window 生效时间窗口，当前时间（按指定时区）满足cron表达式的分钟即视为处于窗口内
+type Window struct {
+	spec     *CronSpec
+	location *time.Location
+}
+
+// NewWindow 创建时间窗口，timezone为空时使用本地时区
+func NewWindow(spec, timezone string) (*Window, error) {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	location := time.Local
+	if timezone != "" {
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone '%s': %v", timezone, err)
+		}
+	}
+
+	return &Window{
+		spec:     cron,
+		location: location,
+	}, nil
+}
+
+// Active 检查给定时间是否处于窗口内
+func (w *Window) Active(t time.Time) bool {
+	return w.spec.Matches(t.In(w.location))
+}
+
+// 已解析窗口的缓存，避免每个请求重复解析表达式
+var (
+	windowCache   = make(map[string]*Window)
+	windowCacheMu sync.RWMutex
+)
+
+// Compile 解析时间窗口并缓存结果
+func Compile(spec, timezone string) (*Window, error) {
+	key := timezone + "|" + spec
+
+	windowCacheMu.RLock()
+	w, exists := windowCache[key]
+	windowCacheMu.RUnlock()
+	if exists {
+		return w, nil
+	}
+
+	w, err := NewWindow(spec, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	windowCacheMu.Lock()
+	windowCache[key] = w
+	windowCacheMu.Unlock()
+
+	return w, nil
+}
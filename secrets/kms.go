@@ -0,0 +1,112 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/objectstore"
+)
+
+// kmsProvider 使用AWS KMS解密密文，引用格式为 kms:<base64编码的密文>
+// 密文由 aws kms encrypt 生成，解密结果不会变化，只在进程内缓存不刷新
+type kmsProvider struct {
+	endpoint string
+	region   string
+	creds    objectstore.Credentials
+	client   *http.Client
+}
+
+// kmsDecryptResponse KMS Decrypt响应
+type kmsDecryptResponse struct {
+	Plaintext string `json:"Plaintext"`
+	Type      string `json:"__type"`
+	Message   string `json:"message"`
+}
+
+// newKMSProvider 创建KMS提供方
+func newKMSProvider(cfg config.KMSConfig) (*kmsProvider, error) {
+	kp := &kmsProvider{
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		region:   cfg.Region,
+		client:   &http.Client{Timeout: defaultTimeout},
+	}
+	if kp.endpoint == "" {
+		kp.endpoint = "https://kms." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.Timeout > 0 {
+		kp.client.Timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+
+	for _, item := range []struct {
+		value  string
+		env    string
+		target *string
+	}{
+		{cfg.AccessKey, "AWS_ACCESS_KEY_ID", &kp.creds.AccessKey},
+		{cfg.SecretKey, "AWS_SECRET_ACCESS_KEY", &kp.creds.SecretKey},
+		{cfg.SessionToken, "AWS_SESSION_TOKEN", &kp.creds.SessionToken},
+	} {
+		value, _, err := resolveLocal(item.value)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			value = os.Getenv(item.env)
+		}
+		*item.target = value
+	}
+	if kp.creds.AccessKey == "" || kp.creds.SecretKey == "" {
+		return nil, fmt.Errorf("access_key and secret_key are required (or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	}
+	return kp, nil
+}
+
+func (kp *kmsProvider) fetch(ref string) (string, time.Duration, error) {
+	if _, err := base64.StdEncoding.DecodeString(ref); err != nil {
+		return "", 0, fmt.Errorf("ciphertext must be base64 encoded")
+	}
+
+	body, err := json.Marshal(map[string]string{"CiphertextBlob": ref})
+	if err != nil {
+		return "", 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, kp.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	objectstore.SignV4(req, kp.creds, kp.region, "kms", objectstore.PayloadHash(body), time.Now())
+
+	resp, err := kp.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+	var parsed kmsDecryptResponse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return "", 0, fmt.Errorf("invalid response (status %d): %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("kms returned %d: %s %s", resp.StatusCode, parsed.Type, parsed.Message)
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(parsed.Plaintext)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid plaintext in response")
+	}
+	return string(plaintext), -1, nil
+}
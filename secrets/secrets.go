@@ -0,0 +1,401 @@
+package secrets
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"toyou-proxy/config"
+)
+
+// 默认配置
+const (
+	defaultRefreshInterval = 300 * time.Second
+	defaultTimeout         = 10 * time.Second
+	refreshCheckInterval   = 10 * time.Second
+	fileCheckInterval      = time.Second
+)
+
+// provider 密钥提供方，ref为去掉 "scheme:" 前缀的引用
+// ttl为0表示密钥没有租期，按refresh_interval重新读取；ttl小于0表示密钥不会变化，不需要刷新
+type provider interface {
+	fetch(ref string) (value string, ttl time.Duration, err error)
+}
+
+// entry 缓存的密钥
+type entry struct {
+	value     string
+	refreshAt time.Time // 零值表示不需要刷新
+	expiresAt time.Time // 零值表示不会过期，刷新失败时继续使用旧值
+}
+
+// Store 密钥缓存，后台在租期结束前重新读取密钥
+type Store struct {
+	providers       map[string]provider // scheme -> provider
+	refreshInterval time.Duration
+	vault           *vaultProvider // 需要续期令牌时不为nil
+
+	mu      sync.RWMutex
+	entries map[string]*entry // 完整引用 -> 密钥
+	stop    chan struct{}
+}
+
+var (
+	current   *Store
+	currentMu sync.RWMutex
+)
+
+// generation 密钥版本，刷新后密钥的值变化、更换密钥缓存或读取过的file:引用的文件变化时增加
+var generation atomic.Uint64
+
+// 读取过的file:引用（路径 -> fileState），Generation每隔fileCheckInterval检查一次文件是否变化
+var (
+	watchedFiles  sync.Map
+	lastFileCheck atomic.Int64
+)
+
+// fileState 读取文件时的修改时间和大小
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Generation 返回密钥版本，按配置创建的中间件实例在版本变化后重新创建，使用新的密钥
+func Generation() uint64 {
+	now := time.Now().UnixNano()
+	if last := lastFileCheck.Load(); now-last >= int64(fileCheckInterval) && lastFileCheck.CompareAndSwap(last, now) {
+		checkWatchedFiles()
+	}
+	return generation.Load()
+}
+
+// checkWatchedFiles 文件被修改或删除时增加密钥版本，文件在下次解析引用时重新读取
+func checkWatchedFiles() {
+	changed := false
+	watchedFiles.Range(func(key, value interface{}) bool {
+		info, err := os.Stat(key.(string))
+		if err != nil || value.(fileState) != (fileState{modTime: info.ModTime(), size: info.Size()}) {
+			watchedFiles.Delete(key)
+			changed = true
+		}
+		return true
+	})
+	if changed {
+		generation.Add(1)
+	}
+}
+
+// Configure 根据配置创建密钥缓存，解析cfgs（中间件配置）中的所有引用后替换当前缓存，
+// 创建代理处理器和重新加载配置时调用；引用无效或无法读取时返回错误，当前缓存保持不变
+func Configure(cfg config.SecretsConfig, cfgs ...map[string]interface{}) error {
+	store, err := NewStore(cfg)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		for _, c := range cfgs {
+			if _, err := store.resolveConfig(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	currentMu.Lock()
+	previous := current
+	current = store
+	currentMu.Unlock()
+
+	// 新缓存读取的密钥与之前的不同时，已创建的中间件需要使用新的密钥
+	if store.changedFrom(previous) {
+		generation.Add(1)
+	}
+	if previous != nil {
+		previous.Stop()
+	}
+	if store != nil {
+		go store.refreshLoop()
+	}
+	return nil
+}
+
+// NewStore 创建密钥缓存，没有配置任何提供方时返回nil
+func NewStore(cfg config.SecretsConfig) (*Store, error) {
+	store := &Store{
+		providers:       make(map[string]provider),
+		refreshInterval: defaultRefreshInterval,
+		entries:         make(map[string]*entry),
+		stop:            make(chan struct{}),
+	}
+	if cfg.RefreshInterval > 0 {
+		store.refreshInterval = time.Duration(cfg.RefreshInterval) * time.Second
+	}
+
+	if cfg.Vault != nil {
+		vault, err := newVaultProvider(*cfg.Vault)
+		if err != nil {
+			return nil, fmt.Errorf("vault: %v", err)
+		}
+		store.providers["vault"] = vault
+		store.vault = vault
+	}
+	if cfg.KMS != nil {
+		kms, err := newKMSProvider(*cfg.KMS)
+		if err != nil {
+			return nil, fmt.Errorf("kms: %v", err)
+		}
+		store.providers["kms"] = kms
+	}
+
+	if len(store.providers) == 0 {
+		return nil, nil
+	}
+	return store, nil
+}
+
+// Stop 停止后台刷新
+func (s *Store) Stop() {
+	close(s.stop)
+}
+
+// Resolve 解析凭证配置，避免把密钥明文写在配置文件中
+//   - env:NAME    读取环境变量
+//   - file:/path  读取文件内容（去除首尾空白）
+//   - vault:path#key、kms:ciphertext  从已配置的密钥提供方读取并缓存
+//
+// 其他值原样返回
+func Resolve(value string) (string, error) {
+	if secret, ok, err := resolveLocal(value); ok {
+		return secret, err
+	}
+
+	currentMu.RLock()
+	store := current
+	currentMu.RUnlock()
+	if store != nil && store.IsReference(value) {
+		return store.Resolve(value)
+	}
+	return value, nil
+}
+
+// resolveLocal 解析env:和file:引用，ok表示value是这两种引用之一
+func resolveLocal(value string) (secret string, ok bool, err error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, exists := os.LookupEnv(name)
+		if !exists || secret == "" {
+			return "", true, fmt.Errorf("environment variable '%s' is not set", name)
+		}
+		return secret, true, nil
+	case strings.HasPrefix(value, "file:"):
+		path := strings.TrimPrefix(value, "file:")
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", true, fmt.Errorf("failed to read secret file: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", true, fmt.Errorf("failed to read secret file: %v", err)
+		}
+		watchedFiles.Store(path, fileState{modTime: info.ModTime(), size: info.Size()})
+		return strings.TrimSpace(string(data)), true, nil
+	}
+	return value, false, nil
+}
+
+// ResolveConfig 返回把中间件配置中的密钥引用替换为密钥后的副本，没有引用时原样返回
+// 只替换已配置的提供方（vault:、kms:）的引用，env:和file:由各中间件自行解析
+func ResolveConfig(cfg map[string]interface{}) (map[string]interface{}, error) {
+	currentMu.RLock()
+	store := current
+	currentMu.RUnlock()
+	if store == nil {
+		return cfg, nil
+	}
+	return store.resolveConfig(cfg)
+}
+
+// resolveConfig 替换中间件配置中的引用，没有引用时原样返回
+func (s *Store) resolveConfig(cfg map[string]interface{}) (map[string]interface{}, error) {
+	if cfg == nil || !s.hasReferences(cfg) {
+		return cfg, nil
+	}
+
+	resolved, err := s.resolveValue(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.(map[string]interface{}), nil
+}
+
+// IsReference 判断值是否为已配置提供方的引用
+func (s *Store) IsReference(value string) bool {
+	scheme, _, found := strings.Cut(value, ":")
+	if !found {
+		return false
+	}
+	_, exists := s.providers[scheme]
+	return exists
+}
+
+// hasReferences 检查配置中是否包含引用
+func (s *Store) hasReferences(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return s.IsReference(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			if s.hasReferences(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s.hasReferences(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveValue 递归替换配置中的引用
+func (s *Store) resolveValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !s.IsReference(v) {
+			return v, nil
+		}
+		return s.Resolve(v)
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved, err := s.resolveValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			copied[key] = resolved
+		}
+		return copied, nil
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := s.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			copied[i] = resolved
+		}
+		return copied, nil
+	}
+	return value, nil
+}
+
+// Resolve 读取引用对应的密钥，优先使用缓存
+func (s *Store) Resolve(ref string) (string, error) {
+	now := time.Now()
+	s.mu.RLock()
+	cached, exists := s.entries[ref]
+	s.mu.RUnlock()
+	if exists && (cached.expiresAt.IsZero() || now.Before(cached.expiresAt)) {
+		return cached.value, nil
+	}
+
+	fetched, err := s.fetch(ref)
+	if err != nil {
+		return "", err
+	}
+	return fetched.value, nil
+}
+
+// fetch 从提供方读取密钥并更新缓存
+func (s *Store) fetch(ref string) (*entry, error) {
+	scheme, path, _ := strings.Cut(ref, ":")
+	value, ttl, err := s.providers[scheme].fetch(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve secret '%s': %v", redact(ref), err)
+	}
+
+	now := time.Now()
+	fetched := &entry{value: value}
+	switch {
+	case ttl > 0:
+		// 有租期的密钥在租期的2/3处重新读取
+		fetched.refreshAt = now.Add(ttl * 2 / 3)
+		fetched.expiresAt = now.Add(ttl)
+	case ttl == 0:
+		fetched.refreshAt = now.Add(s.refreshInterval)
+	}
+
+	s.mu.Lock()
+	previous, exists := s.entries[ref]
+	s.entries[ref] = fetched
+	s.mu.Unlock()
+	if exists && previous.value != value {
+		generation.Add(1)
+	}
+	return fetched, nil
+}
+
+// changedFrom 判断与之前的缓存相比是否有密钥变化：配置或去掉了提供方，或同一引用读取到的值不同。
+// 新增的引用只出现在新的中间件配置中，这些中间件本来就会重新创建
+func (s *Store) changedFrom(previous *Store) bool {
+	if s == nil || previous == nil {
+		return s != previous
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	previous.mu.RLock()
+	defer previous.mu.RUnlock()
+	for ref, cached := range s.entries {
+		if old, exists := previous.entries[ref]; exists && old.value != cached.value {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshLoop 定期刷新即将到期的密钥和Vault令牌
+func (s *Store) refreshLoop() {
+	ticker := time.NewTicker(refreshCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			if s.vault != nil {
+				s.vault.renewToken(now)
+			}
+
+			s.mu.RLock()
+			var due []string
+			for ref, cached := range s.entries {
+				if !cached.refreshAt.IsZero() && now.After(cached.refreshAt) {
+					due = append(due, ref)
+				}
+			}
+			s.mu.RUnlock()
+
+			for _, ref := range due {
+				if _, err := s.fetch(ref); err != nil {
+					log.Printf("Secret refresh failed, keeping cached value: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// redact 日志中只保留引用的提供方和路径，kms密文只显示前几位
+func redact(ref string) string {
+	if strings.HasPrefix(ref, "kms:") && len(ref) > 16 {
+		return ref[:16] + "..."
+	}
+	return ref
+}
@@ -0,0 +1,53 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerationTracksSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := Resolve("file:" + path); err != nil || value != "old" {
+		t.Fatalf("Resolve = %q, %v", value, err)
+	}
+
+	lastFileCheck.Store(0)
+	before := Generation()
+	lastFileCheck.Store(0)
+	if Generation() != before {
+		t.Fatal("generation changed without modifying the file")
+	}
+
+	if err := os.WriteFile(path, []byte("rotated\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 修改时间精度较低的文件系统上大小也会变化
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// 检查间隔内不检查文件
+	lastFileCheck.Store(time.Now().UnixNano())
+	if Generation() != before {
+		t.Fatal("files were checked before fileCheckInterval")
+	}
+	lastFileCheck.Store(0)
+	after := Generation()
+	if after == before {
+		t.Fatal("generation did not change after the file was modified")
+	}
+	if value, err := Resolve("file:" + path); err != nil || value != "rotated" {
+		t.Fatalf("Resolve = %q, %v", value, err)
+	}
+
+	// 文件被删除时同样增加版本
+	os.Remove(path)
+	lastFileCheck.Store(0)
+	if Generation() == after {
+		t.Fatal("generation did not change after the file was removed")
+	}
+}
@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"toyou-proxy/config"
+)
+
+// vaultProvider 从HashiCorp Vault读取密钥，引用格式为 vault:<路径>#<字段>
+// 同时支持KV v1和v2（如 vault:secret/data/proxy#jwt_key）以及带租期的动态密钥
+type vaultProvider struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+
+	mu        sync.Mutex
+	renewable bool
+	renewAt   time.Time
+}
+
+// vaultResponse Vault API响应
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// newVaultProvider 创建Vault提供方并检查令牌是否有效
+func newVaultProvider(cfg config.VaultConfig) (*vaultProvider, error) {
+	vp := &vaultProvider{
+		address:   strings.TrimSuffix(cfg.Address, "/"),
+		namespace: cfg.Namespace,
+		client:    &http.Client{Timeout: defaultTimeout},
+	}
+	if vp.address == "" {
+		vp.address = strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	}
+	if vp.address == "" {
+		return nil, fmt.Errorf("address is required (or set VAULT_ADDR)")
+	}
+	if cfg.Timeout > 0 {
+		vp.client.Timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+
+	token, _, err := resolveLocal(cfg.Token)
+	if err != nil {
+		return nil, fmt.Errorf("token: %v", err)
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("token is required (or set VAULT_TOKEN)")
+	}
+	vp.token = token
+
+	// 查询令牌信息，可续期的令牌在有效期过半时续期
+	resp, err := vp.do(http.MethodGet, "auth/token/lookup-self")
+	if err != nil {
+		return nil, fmt.Errorf("token lookup failed: %v", err)
+	}
+	renewable, _ := resp.Data["renewable"].(bool)
+	ttl, _ := resp.Data["ttl"].(float64)
+	if renewable && ttl > 0 {
+		vp.renewable = true
+		vp.renewAt = time.Now().Add(time.Duration(ttl) * time.Second / 2)
+	}
+	return vp, nil
+}
+
+func (vp *vaultProvider) fetch(ref string) (string, time.Duration, error) {
+	path, field, found := strings.Cut(ref, "#")
+	if !found || path == "" || field == "" {
+		return "", 0, fmt.Errorf("reference must be vault:<path>#<field>")
+	}
+
+	resp, err := vp.do(http.MethodGet, path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// KV v2的字段位于data.data中
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, isV2 := data["metadata"]; isV2 {
+			data = nested
+		}
+	}
+	raw, exists := data[field]
+	if !exists || raw == nil {
+		return "", 0, fmt.Errorf("field '%s' not found", field)
+	}
+
+	var value string
+	switch v := raw.(type) {
+	case string:
+		value = v
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", 0, err
+		}
+		value = string(encoded)
+	}
+
+	// 只有动态密钥的租期有意义，KV的lease_duration只是建议的刷新间隔
+	var ttl time.Duration
+	if resp.LeaseID != "" && resp.LeaseDuration > 0 {
+		ttl = time.Duration(resp.LeaseDuration) * time.Second
+	}
+	return value, ttl, nil
+}
+
+// renewToken 令牌有效期过半时续期，失败时记录日志并在下次检查时重试
+func (vp *vaultProvider) renewToken(now time.Time) {
+	vp.mu.Lock()
+	due := vp.renewable && now.After(vp.renewAt)
+	vp.mu.Unlock()
+	if !due {
+		return
+	}
+
+	resp, err := vp.do(http.MethodPost, "auth/token/renew-self")
+	if err != nil {
+		log.Printf("Vault token renewal failed: %v", err)
+		return
+	}
+	if resp.Auth == nil || resp.Auth.LeaseDuration <= 0 {
+		return
+	}
+
+	vp.mu.Lock()
+	vp.renewable = resp.Auth.Renewable
+	vp.renewAt = now.Add(time.Duration(resp.Auth.LeaseDuration) * time.Second / 2)
+	vp.mu.Unlock()
+}
+
+// do 调用Vault API
+func (vp *vaultProvider) do(method, path string) (*vaultResponse, error) {
+	req, err := http.NewRequest(method, vp.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", vp.token)
+	if vp.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vp.namespace)
+	}
+
+	resp, err := vp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed vaultResponse
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return nil, fmt.Errorf("invalid response (status %d): %v", resp.StatusCode, err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(parsed.Errors) > 0 {
+			return nil, fmt.Errorf("vault returned %d: %s", resp.StatusCode, strings.Join(parsed.Errors, "; "))
+		}
+		return nil, fmt.Errorf("vault returned %d", resp.StatusCode)
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,98 @@
+package security
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"toyou-proxy/config"
+)
+
+// wellKnownPrefix RFC 8615 规定的公开元数据目录（如ACME证书验证），不视为隐藏文件
+const wellKnownPrefix = "/.well-known/"
+
+// PathFilter 请求路径过滤器，拒绝访问隐藏文件和黑名单中的路径
+// 代理和静态文件服务共用同一套规则
+type PathFilter struct {
+	denyHidden bool
+	prefixes   []string // 以/开头的路径前缀
+	patterns   []string // 按路径段匹配的通配符模式
+}
+
+// NewPathFilter 根据安全配置创建路径过滤器
+func NewPathFilter(cfg config.SecurityConfig) (*PathFilter, error) {
+	f := &PathFilter{denyHidden: cfg.DenyHiddenFiles}
+
+	for _, entry := range cfg.DenyPaths {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.HasPrefix(entry, "/") {
+			f.prefixes = append(f.prefixes, entry)
+			continue
+		}
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, fmt.Errorf("invalid deny_paths pattern '%s': %v", entry, err)
+		}
+		f.patterns = append(f.patterns, entry)
+	}
+
+	return f, nil
+}
+
+// Enabled 是否配置了任何过滤规则
+func (f *PathFilter) Enabled() bool {
+	return f.denyHidden || len(f.prefixes) > 0 || len(f.patterns) > 0
+}
+
+// Denied 检查路径是否被拒绝，返回拒绝原因
+// 传入解码后的路径；路径会先解析点段，避免通过 /./ 或 /../ 绕过
+func (f *PathFilter) Denied(p string) (bool, string) {
+	if !f.Enabled() {
+		return false, ""
+	}
+
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	for _, prefix := range f.prefixes {
+		if hasPathPrefix(cleaned, prefix) {
+			return true, fmt.Sprintf("path matches deny prefix '%s'", prefix)
+		}
+	}
+
+	for i, segment := range strings.Split(cleaned, "/") {
+		if segment == "" {
+			continue
+		}
+
+		if f.denyHidden && strings.HasPrefix(segment, ".") {
+			// 只放行位于根目录下的 .well-known
+			if !(i == 1 && strings.HasPrefix(cleaned+"/", wellKnownPrefix)) {
+				return true, fmt.Sprintf("hidden path segment '%s'", segment)
+			}
+		}
+
+		for _, pattern := range f.patterns {
+			if matched, _ := path.Match(pattern, segment); matched {
+				return true, fmt.Sprintf("path segment '%s' matches deny pattern '%s'", segment, pattern)
+			}
+		}
+	}
+
+	return false, ""
+}
+
+// hasPathPrefix 按路径段边界匹配前缀，/admin 匹配 /admin 和 /admin/x，但不匹配 /administrator
+func hasPathPrefix(p, prefix string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return len(p) == len(prefix) || strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/'
+}
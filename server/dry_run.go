@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/proxy"
+	"toyou-proxy/registry"
+)
+
+// ReadinessCheck 单项检查结果
+type ReadinessCheck struct {
+	Name string
+	Err  error
+}
+
+// ReadinessReport 启动自检报告
+type ReadinessReport struct {
+	Checks []ReadinessCheck
+}
+
+// add 记录一项检查结果
+func (r *ReadinessReport) add(name string, err error) {
+	r.Checks = append(r.Checks, ReadinessCheck{Name: name, Err: err})
+}
+
+// OK 所有检查是否通过
+func (r *ReadinessReport) OK() bool {
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Print 输出自检报告
+func (r *ReadinessReport) Print(w io.Writer) {
+	failed := 0
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			failed++
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", check.Name, check.Err)
+		} else {
+			fmt.Fprintf(w, "[ OK ] %s\n", check.Name)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(w, "\nNOT READY: %d of %d checks failed\n", failed, len(r.Checks))
+	} else {
+		fmt.Fprintf(w, "\nREADY: all %d checks passed\n", len(r.Checks))
+	}
+}
+
+// DryRun 执行启动自检：加载配置、编译加载插件、构建匹配器和中间件链，
+// probe为true时还会探测每个服务地址是否可达；不会监听任何端口
+func DryRun(configPath string, probe bool) *ReadinessReport {
+	report := &ReadinessReport{}
+
+	cfg, err := config.LoadConfig(configPath)
+	report.add("load config", err)
+	if err != nil {
+		return report
+	}
+
+	err = cfg.Validate()
+	report.add("validate config", err)
+	if err != nil {
+		return report
+	}
+
+	handler, err := proxy.NewProxyHandler(cfg)
+	report.add("create proxy handler", err)
+	if err != nil {
+		return report
+	}
+
+	problems := handler.SelfCheck()
+	for _, problem := range problems {
+		report.add("self check", problem)
+	}
+	if len(problems) == 0 {
+		report.add("plugins, routes and middleware chains", nil)
+	}
+
+	if probe {
+		probeServices(report, registry.GetDefaultRegistry().List(), cfg.LoadBalancers)
+	}
+
+	return report
+}
+
+// probeServices 探测服务地址是否可达，收到任意HTTP响应即视为可达，配置了负载均衡的服务探测每个后端
+func probeServices(report *ReadinessReport, services map[string]config.Service, loadBalancers map[string]config.LoadBalancerConfig) {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, name := range names {
+		for _, address := range serviceAddresses(services[name], loadBalancers) {
+			checkName := fmt.Sprintf("probe service '%s' (%s)", name, address)
+
+			resp, err := client.Get(address)
+			if err != nil {
+				report.add(checkName, err)
+				continue
+			}
+			resp.Body.Close()
+			report.add(checkName, nil)
+		}
+	}
+}
+
+// serviceAddresses 返回服务的后端地址，引用命名负载均衡器的服务返回其后端；从注册中心同步的后端在运行时发现，不探测
+func serviceAddresses(service config.Service, loadBalancers map[string]config.LoadBalancerConfig) []string {
+	lb := service.LoadBalancer
+	if named, exists := loadBalancers[service.LoadBalancerRef]; exists && service.LoadBalancerRef != "" {
+		lb = &named
+	}
+	if lb == nil {
+		return []string{service.URL}
+	}
+	addresses := make([]string, 0, len(lb.Backends))
+	for _, backend := range lb.Backends {
+		// dns+后端按域名探测，解析到的地址由负载均衡器在运行时发现
+		addresses = append(addresses, strings.TrimPrefix(backend.URL, "dns+"))
+	}
+	return addresses
+}
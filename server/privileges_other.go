@@ -0,0 +1,17 @@
+//go:build !unix
+
+package server
+
+import (
+	"fmt"
+
+	"toyou-proxy/config"
+)
+
+// dropPrivileges 当前平台不支持切换用户和根目录
+func dropPrivileges(security config.SecurityConfig) error {
+	if security.RunAs.User != "" || security.RunAs.Group != "" || security.Chroot != "" {
+		return fmt.Errorf("run_as and chroot are not supported on this platform")
+	}
+	return nil
+}
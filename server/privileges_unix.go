@@ -0,0 +1,103 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"toyou-proxy/config"
+)
+
+// dropPrivileges 在绑定端口后切换根目录并降低权限
+// 用户和用户组需在chroot之前解析，因为新根目录下通常没有/etc/passwd
+func dropPrivileges(security config.SecurityConfig) error {
+	runAs := security.RunAs
+	if runAs.User == "" && runAs.Group == "" && security.Chroot == "" {
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("run_as and chroot require starting as root")
+	}
+
+	uid, gid := -1, -1
+	if runAs.User != "" {
+		u, err := lookupUser(runAs.User)
+		if err != nil {
+			return err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if runAs.Group != "" {
+		g, err := lookupGroup(runAs.Group)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	if security.Chroot != "" {
+		if err := syscall.Chroot(security.Chroot); err != nil {
+			return fmt.Errorf("failed to chroot to '%s': %v", security.Chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return fmt.Errorf("failed to chdir after chroot: %v", err)
+		}
+		log.Printf("Changed root directory to %s", security.Chroot)
+	}
+
+	// 先切换用户组，切换用户后就没有权限再修改用户组了
+	if gid != -1 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("failed to set supplementary groups: %v", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("failed to set group id %d: %v", gid, err)
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("failed to set user id %d: %v", uid, err)
+		}
+	}
+
+	log.Printf("Dropped privileges to uid=%d gid=%d", os.Getuid(), os.Getgid())
+	return nil
+}
+
+// lookupUser 按名称或数字ID查找用户
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+		// 系统中没有对应用户时，主组使用相同的数字ID
+		return &user.User{Uid: name, Gid: name, Username: name}, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown run_as user '%s': %v", name, err)
+	}
+	return u, nil
+}
+
+// lookupGroup 按名称或数字ID查找用户组
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if g, err := user.LookupGroupId(name); err == nil {
+			return g, nil
+		}
+		return &user.Group{Gid: name, Name: name}, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown run_as group '%s': %v", name, err)
+	}
+	return g, nil
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/inflight"
+	"toyou-proxy/metrics"
+	"toyou-proxy/proxy"
+	"toyou-proxy/systemd"
+)
+
+// 默认检查配置文件是否变化的间隔
+const defaultReloadWatchInterval = 5 * time.Second
+
+// 配置重新加载指标
+var configReloads = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_config_reloads_total",
+	"Configuration reloads by trigger and result.",
+	"trigger", "result",
+)
+
+// 重新加载的触发方式
+const (
+	reloadTriggerSignal = "signal"
+	reloadTriggerWatch  = "watch"
+)
+
+// Reload 重新读取配置文件，创建新的路由表、中间件链和服务后原子替换
+// 正在处理的请求（包括SSE和WebSocket）继续使用旧配置直到完成，新请求使用新配置，
+// 配置了stream_grace_period时旧配置上的流在宽限期结束后收到重新连接提示；
+// 配置无效时返回错误，继续使用当前配置。监听器的变化需要重启，其他只在启动时生效的配置变化会记录警告；
+// 切换根目录（chroot）后配置文件不再可读，重新加载返回错误
+func (s *Server) Reload(trigger string) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	// 通知systemd正在重新加载，完成后（无论是否成功）恢复就绪状态
+	systemd.Notify(systemd.StateReloading)
+	defer systemd.Ready()
+
+	err := s.reload(trigger)
+	if err != nil {
+		configReloads.Inc(trigger, "error")
+		log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+		return err
+	}
+	configReloads.Inc(trigger, "success")
+	return nil
+}
+
+// reload 执行重新加载，成功后重新生成启动报告
+func (s *Server) reload(trigger string) error {
+	if s.chrooted {
+		return fmt.Errorf("configuration reload is not available after chroot, restart to apply changes")
+	}
+	current, handler, _ := s.current()
+
+	cfg, err := config.LoadConfig(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+
+	listeners := cfg.EffectiveListeners()
+	if !reflect.DeepEqual(listeners, s.listens) {
+		return fmt.Errorf("listeners or ports changed, a restart is required")
+	}
+	var warnings []string
+	for _, section := range restartRequired(current, cfg) {
+		warnings = append(warnings, restartWarning(section))
+	}
+
+	next, err := handler.Reload(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create proxy handler: %v", err)
+	}
+	portHandlers := make(map[int]*proxy.PortHandler, len(listeners))
+	for _, listener := range listeners {
+		portHandlers[listener.Port] = next.ForListener(listener)
+	}
+
+	s.mu.Lock()
+	s.config = cfg
+	s.handler = next
+	s.portMap = portHandlers
+	s.mu.Unlock()
+
+	// 正在处理的请求继续使用开始时的处理器，旧配置上的流在宽限期结束后提示客户端重新连接
+	inflight.GetDefaultTracker().SetGeneration(next.Generation())
+	if s.streamGrace > 0 {
+		s.retireStreams(next.Generation())
+	}
+
+	files := cfg.Files(s.configPath)
+	s.configFiles = fileStates(files)
+	log.Printf("Configuration reloaded: %d host rules, %d services, %d middlewares",
+		len(cfg.HostRules), len(cfg.Services), len(cfg.Middlewares))
+	s.setReport(newStartupReport(trigger, files, cfg, next, listeners, warnings))
+
+	// 新增服务的连接预热不阻塞重新加载
+	go next.WarmUp()
+	return nil
+}
+
+// retireStreams 宽限期结束后关闭配置版本早于generation的SSE和WebSocket流，
+// 关闭前向客户端发送重新连接提示，客户端重新连接后使用新配置
+func (s *Server) retireStreams(generation uint64) {
+	time.AfterFunc(s.streamGrace, func() {
+		if retired := inflight.GetDefaultTracker().Retire(generation); retired > 0 {
+			log.Printf("Stream grace period ended, asked %d SSE/WebSocket streams on the previous configuration to reconnect", retired)
+		}
+	})
+}
+
+// restartRequired 返回发生变化但只在启动时生效的配置项
+func restartRequired(current, next *config.Config) []string {
+	var sections []string
+	if !reflect.DeepEqual(current.EffectiveTLS(), next.EffectiveTLS()) {
+		sections = append(sections, "tls")
+	}
+	if !reflect.DeepEqual(current.Admin, next.Admin) {
+		sections = append(sections, "admin")
+	}
+	if !reflect.DeepEqual(current.Plugins, next.Plugins) {
+		sections = append(sections, "plugins")
+	}
+	if !reflect.DeepEqual(current.Advanced.Security.RunAs, next.Advanced.Security.RunAs) ||
+		current.Advanced.Security.Chroot != next.Advanced.Security.Chroot {
+		sections = append(sections, "security.run_as/chroot")
+	}
+	if !reflect.DeepEqual(current.Advanced.Limits.MaxHeaderSize, next.Advanced.Limits.MaxHeaderSize) {
+		sections = append(sections, "limits.max_header_size")
+	}
+	if current.Advanced.Reload != next.Advanced.Reload {
+		sections = append(sections, "reload")
+	}
+	return sections
+}
+
+// current 返回当前的配置、代理处理器和端口处理器
+func (s *Server) current() (*config.Config, *proxy.ProxyHandler, map[int]*proxy.PortHandler) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config, s.handler, s.portMap
+}
+
+// portHandler 返回端口的HTTP处理器，每个请求使用开始处理时的配置
+func (s *Server) portHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, portMap := s.current()
+		portMap[port].ServeHTTP(w, r)
+	})
+}
+
+// fileState 配置文件的修改时间和大小
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// fileStates 读取配置文件的状态，不存在的文件不记录
+func fileStates(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			states[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return states
+}
+
+// watchConfig 定期检查配置文件（包括config_dir中新增和删除的文件），变化后重新加载
+// 编辑器保存过程中文件可能暂时不完整，重新加载失败时保留当前配置，文件再次变化时重试
+func (s *Server) watchConfig(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cfg, _, _ := s.current()
+			s.reloadMu.Lock()
+			states := fileStates(cfg.Files(s.configPath))
+			changed := !reflect.DeepEqual(states, s.configFiles)
+			if changed {
+				// 无论重新加载是否成功，同一内容只尝试一次
+				s.configFiles = states
+			}
+			s.reloadMu.Unlock()
+
+			if changed {
+				log.Printf("Configuration file change detected, reloading")
+				s.Reload(reloadTriggerWatch)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
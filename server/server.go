@@ -1,25 +1,56 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
+	"toyou-proxy/admin"
 	"toyou-proxy/config"
+	"toyou-proxy/inflight"
+	"toyou-proxy/middleware"
 	"toyou-proxy/proxy"
+	"toyou-proxy/systemd"
+	"toyou-proxy/tlsserver"
+)
+
+// 优雅关闭的默认参数
+const (
+	defaultDrainTimeout        = 30 * time.Second
+	defaultDrainReportInterval = 5 * time.Second
+	drainPollInterval          = 100 * time.Millisecond
 )
 
 // Server 代理服务器
 type Server struct {
-	config    *config.Config
-	servers   []*http.Server
-	portMap   map[int]*proxy.ProxyHandler // 端口到处理器的映射
-	stopChan  chan struct{}
-	waitGroup sync.WaitGroup
+	configPath string
+	config     *config.Config
+	handler    *proxy.ProxyHandler        // 所有端口共享的代理处理器
+	portMap    map[int]*proxy.PortHandler // 端口到处理器的映射
+	report     *StartupReport             // 启动或最近一次重新加载后生成的报告
+	mu         sync.RWMutex               // 保护config、handler、portMap和report，重新加载时整体替换
+
+	reloadMu    sync.Mutex           // 同一时间只进行一次重新加载
+	configFiles map[string]fileState // 当前配置读取的文件状态，用于检测变化
+	streamGrace time.Duration        // 重新加载后旧配置上的流最多保留的时间，0表示保留到流结束（启动时确定）
+	chrooted    bool                 // 已切换根目录，配置文件无法再按原路径读取，不能重新加载
+
+	servers      []*http.Server
+	listens      []config.Listener  // 监听器声明，按端口排序
+	admin        *admin.Server      // 管理API服务器
+	tls          *tlsserver.Manager // HTTPS端口使用的TLS配置，未配置HTTPS时为nil
+	stopChan     chan struct{}
+	waitGroup    sync.WaitGroup
+	stopWatchdog func() // 停止systemd看门狗心跳
+	shutdownOnce sync.Once
 }
 
 // NewServer 创建新的代理服务器
@@ -30,40 +61,50 @@ func NewServer(configPath string) (*Server, error) {
 		return nil, fmt.Errorf("failed to load config: %v", err)
 	}
 
-	// 扫描host_rules获取所有需要监听的端口
-	portHandlers := make(map[int]*proxy.ProxyHandler)
+	// 验证配置
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
 
-	for _, hostRule := range cfg.HostRules {
-		port := hostRule.Port
-		if port == 0 {
-			port = 80 // 默认端口
-		}
+	// 创建共享的代理处理器（插件、中间件工厂、负载均衡器等只初始化一次）
+	handler, err := proxy.NewProxyHandler(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy handler: %v", err)
+	}
 
-		// 如果该端口还没有处理器，创建一个
-		if _, exists := portHandlers[port]; !exists {
-			handler, err := proxy.NewProxyHandler(cfg)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create proxy handler for port %d: %v", port, err)
-			}
-			portHandlers[port] = handler
-		}
+	// 每个监听器使用共享处理器的监听器级视图
+	listeners := cfg.EffectiveListeners()
+	portHandlers := make(map[int]*proxy.PortHandler, len(listeners))
+	for _, listener := range listeners {
+		portHandlers[listener.Port] = handler.ForListener(listener)
 	}
 
-	// 如果没有配置任何host_rules，使用默认端口
-	if len(portHandlers) == 0 {
-		port := 80
-		handler, err := proxy.NewProxyHandler(cfg)
+	srv := &Server{
+		configPath:  configPath,
+		config:      cfg,
+		handler:     handler,
+		portMap:     portHandlers,
+		configFiles: fileStates(cfg.Files(configPath)),
+		streamGrace: time.Duration(cfg.Advanced.Reload.StreamGracePeriod) * time.Second,
+		listens:     listeners,
+		stopChan:    make(chan struct{}),
+	}
+
+	// 加载HTTPS证书和会话票据密钥
+	if tlsCfg := cfg.EffectiveTLS(); len(tlsCfg.Ports) > 0 {
+		srv.tls, err = tlsserver.NewManager(tlsCfg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create proxy handler for default port %d: %v", port, err)
+			return nil, err
 		}
-		portHandlers[port] = handler
 	}
 
-	return &Server{
-		config:   cfg,
-		portMap:  portHandlers,
-		stopChan: make(chan struct{}),
-	}, nil
+	// 创建管理API服务器
+	if cfg.Admin.Enabled {
+		srv.admin = admin.NewServer(cfg.Admin)
+		srv.admin.SetServerState(srv)
+	}
+
+	return srv, nil
 }
 
 // Start 启动服务器
@@ -72,9 +113,9 @@ func (s *Server) Start() error {
 	log.Printf("Starting Toyou Proxy Server...")
 
 	// 获取所有监听的端口
-	ports := make([]int, 0, len(s.portMap))
-	for port := range s.portMap {
-		ports = append(ports, port)
+	ports := make([]int, 0, len(s.listens))
+	for _, listener := range s.listens {
+		ports = append(ports, listener.Port)
 	}
 
 	log.Printf("Listening on ports: %v", ports)
@@ -89,51 +130,145 @@ func (s *Server) Start() error {
 	log.Printf("Loaded %d services", len(s.config.Services))
 	log.Printf("Loaded %d middlewares", len(s.config.Middlewares))
 
+	// 先绑定所有端口，再开始处理请求
+	listeners, err := s.openListeners()
+	if err != nil {
+		return err
+	}
+
+	// 启动管理API
+	if s.admin != nil {
+		if err := s.admin.Start(); err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("failed to start admin API: %v", err)
+		}
+	}
+
+	// 端口已全部绑定，切换根目录并降低权限
+	if err := dropPrivileges(s.config.Advanced.Security); err != nil {
+		closeListeners(listeners)
+		if s.admin != nil {
+			s.admin.Stop()
+		}
+		return fmt.Errorf("failed to drop privileges: %v", err)
+	}
+	s.chrooted = s.config.Advanced.Security.Chroot != ""
+
+	// 启动中间件共享状态的后台任务
+	middleware.GetLifecycleManager().Start()
+	if s.tls != nil {
+		s.tls.Start()
+	}
+
 	// 为每个端口创建HTTP服务器
 	s.servers = make([]*http.Server, 0, len(s.portMap))
 
-	for port, handler := range s.portMap {
+	// 请求头大小上限超过默认值时放宽服务器的读取限制，由代理统一检查并记录指标
+	maxHeaderBytes := 0
+	if size, _ := config.ParseSize(s.config.Advanced.Limits.MaxHeaderSize); size > http.DefaultMaxHeaderBytes {
+		maxHeaderBytes = int(size)
+	}
+
+	for _, decl := range s.listens {
+		port := decl.Port
+		// 每个请求使用开始处理时的配置，重新加载不影响正在处理的请求
+		// HTTP端口同时响应ACME的HTTP-01验证请求
+		handler := s.portHandler(port)
+		if s.tls != nil && !decl.IsHTTPS() {
+			handler = s.tls.HTTPHandler(handler)
+		}
 		server := &http.Server{
-			Addr:    fmt.Sprintf(":%d", port),
-			Handler: handler,
+			Addr:           listenAddr(decl),
+			Handler:        handler,
+			MaxHeaderBytes: maxHeaderBytes,
+			ConnContext:    connContext,
 		}
 		s.servers = append(s.servers, server)
 
+		// HTTPS端口在监听器上完成TLS握手，证书更新和会话票据密钥轮换后对新连接立即生效
+		listener := listeners[port]
+		if s.tls != nil && s.tls.Config(port) != nil {
+			listener = tlsserver.NewListener(listener, s.tls.Config(port))
+		}
+
 		// 启动服务器
 		s.waitGroup.Add(1)
-		go func(port int, server *http.Server) {
+		go func(decl config.Listener, server *http.Server, listener net.Listener) {
 			defer s.waitGroup.Done()
 
-			log.Printf("Starting proxy server on port %d", port)
-			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("Server on port %d failed: %v", port, err)
+			log.Printf("Starting proxy server on %s", decl)
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Printf("Server on %s failed: %v", decl, err)
 			}
-		}(port, server)
+		}(decl, server, listener)
+	}
+
+	// 预先建立到后端的连接，完成后再通知就绪
+	s.handler.WarmUp()
+
+	// 生成启动报告，可以通过管理API的 /startup 获取
+	s.setReport(newStartupReport(reportTriggerStartup, s.config.Files(s.configPath), s.config, s.handler, s.listens, nil))
+
+	// 通知systemd服务已就绪，并在启用看门狗时定期发送心跳
+	if _, err := systemd.Ready(); err != nil {
+		log.Printf("Failed to notify systemd readiness: %v", err)
+	}
+	s.stopWatchdog = systemd.StartWatchdog()
+
+	// 监视配置文件，变化后自动重新加载
+	if reload := s.config.Advanced.Reload; reload.Watch {
+		interval := defaultReloadWatchInterval
+		if reload.Interval > 0 {
+			interval = time.Duration(reload.Interval) * time.Second
+		}
+		go s.watchConfig(interval)
 	}
 
-	// 设置信号处理
+	// 设置信号处理，SIGHUP重新加载配置
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// 等待信号或停止信号
-	select {
-	case sig := <-signalChan:
-		log.Printf("Received signal: %v", sig)
-		return s.Stop()
-	case <-s.stopChan:
-		log.Printf("Received stop signal")
-		return s.Stop()
+	for {
+		select {
+		case sig := <-signalChan:
+			if sig == syscall.SIGHUP {
+				log.Printf("Received SIGHUP, reloading configuration")
+				s.Reload(reloadTriggerSignal)
+				continue
+			}
+			log.Printf("Received signal: %v", sig)
+			return s.Stop()
+		case <-s.stopChan:
+			log.Printf("Received stop signal")
+			return s.Stop()
+		}
 	}
 }
 
+// Shutdown 请求正在运行的Start返回，Start会先停止所有服务器
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.stopChan)
+	})
+}
+
 // Stop 停止服务器
 func (s *Server) Stop() error {
 	log.Println("Shutting down servers...")
 
-	// 关闭所有服务器
-	for _, server := range s.servers {
-		if err := server.Close(); err != nil {
-			log.Printf("Error closing server on port: %v", err)
+	systemd.Stopping()
+	if s.stopWatchdog != nil {
+		s.stopWatchdog()
+	}
+
+	// 停止接受新请求并等待正在处理的请求完成，期间管理API保持可用
+	s.drain()
+
+	// 关闭管理API
+	if s.admin != nil {
+		if err := s.admin.Stop(); err != nil {
+			log.Printf("Error closing admin API: %v", err)
 		}
 	}
 
@@ -141,34 +276,175 @@ func (s *Server) Stop() error {
 	s.waitGroup.Wait()
 	log.Println("All servers stopped")
 
+	// 停止中间件后台任务，如写完归档队列
+	middleware.GetLifecycleManager().Stop()
+	if s.tls != nil {
+		s.tls.Stop()
+	}
+
 	return nil
 }
 
-// GetConfig 获取服务器配置
+// drain 优雅关闭所有服务器：停止接受新连接，等待正在处理的请求（包括SSE和WebSocket）完成，
+// 等待期间定期输出仍在处理的请求，超时后强制关闭剩余的连接和请求
+func (s *Server) drain() {
+	current, _, _ := s.current()
+	timeout, interval := defaultDrainTimeout, defaultDrainReportInterval
+	if cfg := current.Advanced.Shutdown; cfg.DrainTimeout > 0 {
+		timeout = time.Duration(cfg.DrainTimeout) * time.Second
+	}
+	if cfg := current.Advanced.Shutdown; cfg.ReportInterval > 0 {
+		interval = time.Duration(cfg.ReportInterval) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Shutdown不等待已劫持的WebSocket连接，因此同时以请求跟踪器判断排空是否完成
+	var shutdownGroup sync.WaitGroup
+	for _, server := range s.servers {
+		shutdownGroup.Add(1)
+		go func(server *http.Server) {
+			defer shutdownGroup.Done()
+			server.Shutdown(ctx)
+		}(server)
+	}
+	serversDone := make(chan struct{})
+	go func() {
+		shutdownGroup.Wait()
+		close(serversDone)
+	}()
+
+	tracker := inflight.GetDefaultTracker()
+	if tracker.Count() > 0 {
+		logActiveRequests(tracker)
+	}
+
+	poll := time.NewTicker(drainPollInterval)
+	defer poll.Stop()
+	report := time.NewTicker(interval)
+	defer report.Stop()
+
+	for {
+		select {
+		case <-poll.C:
+			select {
+			case <-serversDone:
+				if tracker.Count() == 0 {
+					log.Printf("All active requests drained")
+					return
+				}
+			default:
+			}
+		case <-report.C:
+			logActiveRequests(tracker)
+		case <-ctx.Done():
+			log.Printf("Drain timeout after %s, force closing %d active requests", timeout, tracker.Count())
+			logActiveRequests(tracker)
+			for _, server := range s.servers {
+				if err := server.Close(); err != nil {
+					log.Printf("Error closing server on port: %v", err)
+				}
+			}
+			tracker.CloseMatching(func(inflight.Request) bool { return true })
+			return
+		}
+	}
+}
+
+// logActiveRequests 输出仍在处理的请求，最早开始的在前
+func logActiveRequests(tracker *inflight.Tracker) {
+	requests := tracker.List()
+	if len(requests) == 0 {
+		return
+	}
+	log.Printf("Draining %d active requests:", len(requests))
+	for _, req := range requests {
+		log.Printf("  #%d %s %s %s%s from %s, age %s", req.ID, req.Kind, req.Method, req.Host, req.URI, req.Client,
+			time.Duration(req.AgeSeconds*float64(time.Second)).Round(time.Second))
+	}
+}
+
+// openListeners 为每个端口创建监听器
+// 优先使用systemd socket activation传入的监听器，这样无需root权限即可使用80/443等端口
+func (s *Server) openListeners() (map[int]net.Listener, error) {
+	activated, err := systemd.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("failed to use systemd socket activation: %v", err)
+	}
+
+	listeners := make(map[int]net.Listener, len(s.listens))
+	for _, decl := range s.listens {
+		port := decl.Port
+		if listener, exists := activated[port]; exists {
+			log.Printf("Using systemd socket activation listener for port %d", port)
+			listeners[port] = listener
+			delete(activated, port)
+			continue
+		}
+
+		listener, err := net.Listen("tcp", listenAddr(decl))
+		if err != nil {
+			closeListeners(listeners)
+			closeListeners(activated)
+			return nil, fmt.Errorf("failed to listen on port %d: %v", port, err)
+		}
+		listeners[port] = listener
+	}
+
+	// 关闭没有对应端口配置的监听器
+	for port, listener := range activated {
+		log.Printf("Warning: systemd passed a listener for port %d, but no listener is declared on it", port)
+		listener.Close()
+	}
+
+	return listeners, nil
+}
+
+// listenAddr 返回监听器的监听地址，如 :80、127.0.0.1:8080、[::1]:8443
+func listenAddr(listener config.Listener) string {
+	return net.JoinHostPort(listener.Address, strconv.Itoa(listener.Port))
+}
+
+// closeListeners 关闭所有监听器
+func closeListeners(listeners map[int]net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// GetConfig 获取服务器当前的配置
 func (s *Server) GetConfig() *config.Config {
-	return s.config
+	cfg, _, _ := s.current()
+	return cfg
 }
 
 // GetStatus 获取服务器状态
 func (s *Server) GetStatus() map[string]interface{} {
 	// 获取所有监听的端口
-	ports := make([]int, 0, len(s.portMap))
-	for port := range s.portMap {
-		ports = append(ports, port)
+	ports := make([]int, 0, len(s.listens))
+	for _, listener := range s.listens {
+		ports = append(ports, listener.Port)
 	}
 
 	// 统计所有域名规则中的路由规则总数
+	cfg := s.GetConfig()
 	totalRouteRules := 0
-	for _, hostRule := range s.config.HostRules {
+	for _, hostRule := range cfg.HostRules {
 		totalRouteRules += len(hostRule.RouteRules)
 	}
 
 	return map[string]interface{}{
 		"ports":       ports,
-		"host_rules":  len(s.config.HostRules),
+		"host_rules":  len(cfg.HostRules),
 		"route_rules": totalRouteRules,
-		"services":    len(s.config.Services),
-		"middlewares": len(s.config.Middlewares),
+		"services":    len(cfg.Services),
+		"middlewares": len(cfg.Middlewares),
 		"running":     true,
 	}
 }
+
+// connContext 为新连接分配连接ID，HTTPS连接还会保存ClientHello用于计算TLS指纹
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	return tlsserver.ConnContext(middleware.ConnContext(ctx, conn), conn)
+}
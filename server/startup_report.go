@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/middleware"
+	"toyou-proxy/proxy"
+)
+
+// StartupReport 启动报告：实际监听的端口、加载的规则、每个路由解析后的中间件链、插件和警告
+// 启动完成和每次重新加载成功后生成，通过管理API的 /startup 获取
+type StartupReport struct {
+	GeneratedAt time.Time              `json:"generated_at"`
+	Trigger     string                 `json:"trigger"` // startup、signal 或 watch
+	ConfigFiles []string               `json:"config_files"`
+	Listeners   []ListenerReport       `json:"listeners"`
+	HostRules   []proxy.HostRuleChains `json:"host_rules"`
+	Services    []string               `json:"services"`
+	Plugins     PluginReport           `json:"plugins"`
+	Warnings    []string               `json:"warnings"`
+}
+
+// ListenerReport 监听器及挂载的域名规则
+type ListenerReport struct {
+	Name      string   `json:"name,omitempty"`
+	Address   string   `json:"address"`
+	Protocol  string   `json:"protocol"`
+	HostRules []string `json:"host_rules"`
+}
+
+// PluginReport 插件加载结果
+type PluginReport struct {
+	Supported bool            `json:"supported"`
+	Loaded    []string        `json:"loaded"`
+	Failed    []PluginFailure `json:"failed"`
+}
+
+// PluginFailure 编译或加载失败的插件
+type PluginFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// reportTriggerStartup 启动时生成的报告
+const reportTriggerStartup = "startup"
+
+// newStartupReport 根据当前的配置和代理处理器生成启动报告，warnings为生成报告前发现的问题
+// 自检会按配置创建一次中间件，发现的问题加入警告
+func newStartupReport(trigger string, files []string, cfg *config.Config, handler *proxy.ProxyHandler, listeners []config.Listener, warnings []string) *StartupReport {
+	report := &StartupReport{
+		GeneratedAt: time.Now(),
+		Trigger:     trigger,
+		ConfigFiles: files,
+		Listeners:   make([]ListenerReport, 0, len(listeners)),
+		HostRules:   handler.RuleChains(),
+		Services:    make([]string, 0, len(cfg.Services)),
+		Warnings:    append([]string{}, warnings...),
+	}
+
+	for _, listener := range listeners {
+		attached := []string{}
+		for _, rule := range cfg.HostRules {
+			if listener.Attaches(rule) {
+				attached = append(attached, rule.Pattern)
+			}
+		}
+		protocol := config.ProtocolHTTP
+		if listener.IsHTTPS() {
+			protocol = config.ProtocolHTTPS
+		}
+		report.Listeners = append(report.Listeners, ListenerReport{
+			Name:      listener.Name,
+			Address:   listenAddr(listener),
+			Protocol:  protocol,
+			HostRules: attached,
+		})
+	}
+
+	for name := range cfg.Services {
+		report.Services = append(report.Services, name)
+	}
+	sort.Strings(report.Services)
+
+	loaded, failed := handler.Plugins()
+	report.Plugins = PluginReport{
+		Supported: middleware.PluginsSupported,
+		Loaded:    loaded,
+		Failed:    make([]PluginFailure, 0, len(failed)),
+	}
+	for name, err := range failed {
+		report.Plugins.Failed = append(report.Plugins.Failed, PluginFailure{Name: name, Error: err.Error()})
+	}
+	sort.Slice(report.Plugins.Failed, func(i, j int) bool {
+		return report.Plugins.Failed[i].Name < report.Plugins.Failed[j].Name
+	})
+
+	// 插件失败也包括在自检结果中
+	for _, problem := range handler.SelfCheck() {
+		report.Warnings = append(report.Warnings, problem.Error())
+	}
+	return report
+}
+
+// Log 输出报告摘要和所有警告
+func (r *StartupReport) Log() {
+	routeRules := 0
+	for _, rule := range r.HostRules {
+		routeRules += len(rule.Routes)
+	}
+	log.Printf("Startup report: %d listeners, %d host rules, %d route rules, %d services, %d plugins loaded, %d plugins failed, %d warnings",
+		len(r.Listeners), len(r.HostRules), routeRules, len(r.Services), len(r.Plugins.Loaded), len(r.Plugins.Failed), len(r.Warnings))
+	for _, warning := range r.Warnings {
+		log.Printf("Warning: %s", warning)
+	}
+}
+
+// setReport 保存并输出启动报告
+func (s *Server) setReport(report *StartupReport) {
+	s.mu.Lock()
+	s.report = report
+	s.mu.Unlock()
+	report.Log()
+}
+
+// GetStartupReport 返回最近一次生成的启动报告，启动完成前返回nil
+func (s *Server) GetStartupReport() interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.report == nil {
+		return nil
+	}
+	return s.report
+}
+
+// restartWarning 只在启动时生效的配置变化的警告
+func restartWarning(section string) string {
+	return fmt.Sprintf("%s changed, the change takes effect after a restart", section)
+}
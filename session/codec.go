@@ -0,0 +1,55 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// signer cookie签名器，使用第一个密钥签名，所有密钥都可以验证，便于轮换密钥
+type signer struct {
+	keys [][]byte
+}
+
+// newSigner 创建签名器
+func newSigner(secrets []string) *signer {
+	keys := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, []byte(secret))
+	}
+	return &signer{keys: keys}
+}
+
+// sign 对载荷签名，签名中包含cookie名称，防止不同cookie之间互相替换
+func (s *signer) sign(name, payload string) string {
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(s.keys[0], name, payload))
+}
+
+// verify 验证签名并返回载荷
+func (s *signer) verify(name, value string) (string, bool) {
+	idx := strings.LastIndex(value, ".")
+	if idx == -1 {
+		return "", false
+	}
+	payload := value[:idx]
+	signature, err := base64.RawURLEncoding.DecodeString(value[idx+1:])
+	if err != nil {
+		return "", false
+	}
+
+	for _, key := range s.keys {
+		if hmac.Equal(signature, s.mac(key, name, payload)) {
+			return payload, true
+		}
+	}
+	return "", false
+}
+
+func (s *signer) mac(key []byte, name, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name))
+	h.Write([]byte{'|'})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,48 @@
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// maxCookiePayload cookie载荷上限，浏览器通常限制单个cookie不超过4KB
+const maxCookiePayload = 3800
+
+// CookieStore 将整个会话编码进签名cookie，服务端无状态，适合多实例部署
+// 会话值对客户端可见（只签名不加密），不要存放敏感数据；删除只能清除cookie，无法吊销已签发的cookie
+type CookieStore struct{}
+
+// NewCookieStore 创建cookie会话存储
+func NewCookieStore() *CookieStore {
+	return &CookieStore{}
+}
+
+// Load 从cookie载荷解码会话
+func (cs *CookieStore) Load(ctx context.Context, payload string) (*Session, error) {
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session cookie: %v", err)
+	}
+	return unmarshal(data)
+}
+
+// Save 将会话编码为cookie载荷
+func (cs *CookieStore) Save(ctx context.Context, s *Session, ttl time.Duration) (string, error) {
+	data, err := s.marshal()
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	if len(payload) > maxCookiePayload {
+		return "", fmt.Errorf("session too large for cookie store (%d bytes)", len(payload))
+	}
+	return payload, nil
+}
+
+// Delete cookie存储没有服务端状态
+func (cs *CookieStore) Delete(ctx context.Context, id string) error {
+	return nil
+}
@@ -0,0 +1,180 @@
+package session
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"toyou-proxy/privacy"
+)
+
+// 默认配置
+const (
+	DefaultCookieName      = "toyou_session"
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 24 * time.Hour
+)
+
+// minSecretLength 签名密钥的最小长度
+const minSecretLength = 16
+
+// Options 会话管理器配置
+type Options struct {
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite
+	// IdleTimeout 空闲超时，超过该时间没有请求时会话失效
+	IdleTimeout time.Duration
+	// AbsoluteTimeout 绝对超时，会话创建后超过该时间无论是否活跃都失效
+	AbsoluteTimeout time.Duration
+	// Secrets 签名密钥，第一个用于签名，其余用于验证轮换前签发的cookie
+	Secrets []string
+}
+
+// Manager 会话管理器，负责cookie的签名、超时检查以及与存储交互
+type Manager struct {
+	store   Store
+	options Options
+	signer  *signer
+}
+
+// NewManager 创建会话管理器
+func NewManager(store Store, options Options) (*Manager, error) {
+	if len(options.Secrets) == 0 {
+		return nil, fmt.Errorf("session secret is required")
+	}
+	for _, secret := range options.Secrets {
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("session secret must be at least %d characters", minSecretLength)
+		}
+	}
+
+	if options.CookieName == "" {
+		options.CookieName = DefaultCookieName
+	}
+	if options.Path == "" {
+		options.Path = "/"
+	}
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteLaxMode
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = DefaultIdleTimeout
+	}
+	if options.AbsoluteTimeout <= 0 {
+		options.AbsoluteTimeout = DefaultAbsoluteTimeout
+	}
+	// SameSite=None 的cookie必须带Secure属性，否则浏览器会拒绝
+	if options.SameSite == http.SameSiteNoneMode && !options.Secure {
+		return nil, fmt.Errorf("same_site none requires secure cookies")
+	}
+
+	return &Manager{
+		store:   store,
+		options: options,
+		signer:  newSigner(options.Secrets),
+	}, nil
+}
+
+// Load 加载请求对应的会话，cookie缺失、签名无效或会话已超时时返回新会话
+func (m *Manager) Load(r *http.Request) *Session {
+	now := time.Now()
+
+	cookie, err := r.Cookie(m.options.CookieName)
+	if err != nil || cookie.Value == "" {
+		return newSession(now)
+	}
+
+	payload, ok := m.signer.verify(m.options.CookieName, cookie.Value)
+	if !ok {
+		log.Printf("Session: invalid cookie signature from %s", privacy.ClientIP(r.RemoteAddr))
+		return newSession(now)
+	}
+
+	s, err := m.store.Load(r.Context(), payload)
+	if err != nil {
+		log.Printf("Session: failed to load session: %v", err)
+		return newSession(now)
+	}
+	if s == nil {
+		return newSession(now)
+	}
+
+	if now.Sub(s.lastAccess) > m.options.IdleTimeout || now.Sub(s.createdAt) > m.options.AbsoluteTimeout {
+		if err := m.store.Delete(r.Context(), s.id); err != nil {
+			log.Printf("Session: failed to delete expired session: %v", err)
+		}
+		return newSession(now)
+	}
+
+	// 最后访问时间落后超过空闲超时的1/10时刷新，避免每个请求都写存储
+	if now.Sub(s.lastAccess) > m.options.IdleTimeout/10 {
+		s.lastAccess = now
+		s.touched = true
+	}
+
+	return s
+}
+
+// Save 保存会话并设置cookie，必须在写入响应头之前调用
+// 没有任何值的新会话不会保存，避免为每个匿名请求创建会话
+func (m *Manager) Save(w http.ResponseWriter, r *http.Request, s *Session) error {
+	s.mu.RLock()
+	destroyed, isNew, modified, touched, oldID := s.destroyed, s.isNew, s.modified, s.touched, s.oldID
+	id, empty, createdAt := s.id, len(s.values) == 0, s.createdAt
+	s.mu.RUnlock()
+
+	if oldID != "" {
+		if err := m.store.Delete(r.Context(), oldID); err != nil {
+			log.Printf("Session: failed to delete renewed session: %v", err)
+		}
+	}
+
+	if destroyed {
+		if !isNew {
+			if err := m.store.Delete(r.Context(), id); err != nil {
+				return err
+			}
+		}
+		m.setCookie(w, "", -1)
+		return nil
+	}
+
+	if isNew && empty {
+		return nil
+	}
+	if !modified && !touched {
+		return nil
+	}
+
+	remaining := m.options.AbsoluteTimeout - time.Since(createdAt)
+	ttl := m.options.IdleTimeout
+	if remaining < ttl {
+		ttl = remaining
+	}
+
+	payload, err := m.store.Save(r.Context(), s, ttl)
+	if err != nil {
+		return err
+	}
+
+	m.setCookie(w, m.signer.sign(m.options.CookieName, payload), int(remaining.Seconds()))
+	return nil
+}
+
+// setCookie 写入会话cookie，maxAge小于0时删除cookie
+func (m *Manager) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.options.CookieName,
+		Value:    value,
+		Path:     m.options.Path,
+		Domain:   m.options.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.options.Secure,
+		HttpOnly: true,
+		SameSite: m.options.SameSite,
+	})
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore 进程内会话存储，适合单实例部署，重启后会话丢失
+type MemoryStore struct {
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// memoryEntry 内存中的会话记录
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewMemoryStore 创建内存会话存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[string]memoryEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Load 加载会话
+func (ms *MemoryStore) Load(ctx context.Context, id string) (*Session, error) {
+	ms.mu.Lock()
+	entry, exists := ms.entries[id]
+	ms.mu.Unlock()
+
+	if !exists || time.Now().After(entry.expiresAt) {
+		return nil, nil
+	}
+	return unmarshal(entry.data)
+}
+
+// Save 保存会话，cookie中保存会话ID
+func (ms *MemoryStore) Save(ctx context.Context, s *Session, ttl time.Duration) (string, error) {
+	data, err := s.marshal()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	id := s.ID()
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.entries[id] = memoryEntry{data: data, expiresAt: now.Add(ttl)}
+
+	// 定期清理过期会话
+	if now.Sub(ms.lastSweep) > time.Minute {
+		ms.lastSweep = now
+		for key, entry := range ms.entries {
+			if now.After(entry.expiresAt) {
+				delete(ms.entries, key)
+			}
+		}
+	}
+
+	return id, nil
+}
+
+// Delete 删除会话
+func (ms *MemoryStore) Delete(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.entries, id)
+	return nil
+}
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisKeyPrefix Redis会话键前缀
+const DefaultRedisKeyPrefix = "toyou:session:"
+
+// RedisOptions Redis会话存储配置
+type RedisOptions struct {
+	Addr      string
+	Password  string
+	DB        int
+	KeyPrefix string
+}
+
+// RedisStore Redis会话存储，多个代理实例共享会话
+type RedisStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+// NewRedisStore 创建Redis会话存储
+func NewRedisStore(options RedisOptions) *RedisStore {
+	if options.KeyPrefix == "" {
+		options.KeyPrefix = DefaultRedisKeyPrefix
+	}
+
+	return &RedisStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:     options.Addr,
+			Password: options.Password,
+			DB:       options.DB,
+		}),
+		keyPrefix: options.KeyPrefix,
+	}
+}
+
+// Load 加载会话
+func (rs *RedisStore) Load(ctx context.Context, id string) (*Session, error) {
+	data, err := rs.client.Get(ctx, rs.keyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshal(data)
+}
+
+// Save 保存会话，键的过期时间与会话剩余有效期一致
+func (rs *RedisStore) Save(ctx context.Context, s *Session, ttl time.Duration) (string, error) {
+	data, err := s.marshal()
+	if err != nil {
+		return "", err
+	}
+
+	id := s.ID()
+	if err := rs.client.Set(ctx, rs.keyPrefix+id, data, ttl).Err(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Delete 删除会话
+func (rs *RedisStore) Delete(ctx context.Context, id string) error {
+	return rs.client.Del(ctx, rs.keyPrefix+id).Err()
+}
+
+// Close 关闭Redis连接
+func (rs *RedisStore) Close() error {
+	return rs.client.Close()
+}
@@ -0,0 +1,170 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Session 会话，由会话中间件加载，其他中间件（OIDC登录、A/B测试、会话保持等）读写其中的值
+type Session struct {
+	id         string
+	values     map[string]interface{}
+	createdAt  time.Time
+	lastAccess time.Time
+
+	isNew     bool
+	modified  bool
+	touched   bool   // 需要刷新最后访问时间
+	destroyed bool   // 请求结束时删除会话
+	oldID     string // 更换ID前的旧ID，保存时删除旧记录
+	mu        sync.RWMutex
+}
+
+// Store 会话存储接口
+// 服务端存储（内存、Redis）在cookie中只保存会话ID；cookie存储把整个会话编码进cookie
+type Store interface {
+	// Load 根据cookie中的载荷加载会话，会话不存在时返回nil
+	Load(ctx context.Context, payload string) (*Session, error)
+
+	// Save 保存会话并返回写入cookie的载荷，ttl为会话剩余有效期
+	Save(ctx context.Context, s *Session, ttl time.Duration) (string, error)
+
+	// Delete 删除会话
+	Delete(ctx context.Context, id string) error
+}
+
+// newSession 创建新会话
+func newSession(now time.Time) *Session {
+	return &Session{
+		id:         newID(),
+		values:     make(map[string]interface{}),
+		createdAt:  now,
+		lastAccess: now,
+		isNew:      true,
+	}
+}
+
+// ID 返回会话ID
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.id
+}
+
+// IsNew 是否是本次请求新建的会话
+func (s *Session) IsNew() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isNew
+}
+
+// CreatedAt 返回会话创建时间
+func (s *Session) CreatedAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.createdAt
+}
+
+// Get 获取会话值
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, exists := s.values[key]
+	return value, exists
+}
+
+// GetString 获取字符串类型的会话值
+func (s *Session) GetString(key string) string {
+	value, _ := s.Get(key)
+	str, _ := value.(string)
+	return str
+}
+
+// Set 设置会话值，值需要可以JSON序列化
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete 删除会话值
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.values[key]; exists {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Destroy 在请求结束时删除会话并清除cookie，如用户登出
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// RenewID 更换会话ID并保留会话值，登录等权限变化后调用以防止会话固定攻击
+func (s *Session) RenewID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.modified = true
+}
+
+// record 会话的序列化格式
+type record struct {
+	ID         string                 `json:"id"`
+	Values     map[string]interface{} `json:"values"`
+	CreatedAt  int64                  `json:"created_at"`
+	LastAccess int64                  `json:"last_access"`
+}
+
+// marshal 序列化会话
+func (s *Session) marshal() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return json.Marshal(record{
+		ID:         s.id,
+		Values:     s.values,
+		CreatedAt:  s.createdAt.Unix(),
+		LastAccess: s.lastAccess.Unix(),
+	})
+}
+
+// unmarshal 反序列化会话
+func unmarshal(data []byte) (*Session, error) {
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid session data: %v", err)
+	}
+	if rec.Values == nil {
+		rec.Values = make(map[string]interface{})
+	}
+
+	return &Session{
+		id:         rec.ID,
+		values:     rec.Values,
+		createdAt:  time.Unix(rec.CreatedAt, 0),
+		lastAccess: time.Unix(rec.LastAccess, 0),
+	}, nil
+}
+
+// newID 生成随机会话ID
+func newID() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("failed to generate session id: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,311 @@
+package slo
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+)
+
+// bucketWidth 计数的时间粒度，也是计算达标率和燃烧率的间隔
+const bucketWidth = 10 * time.Second
+
+// defaultWindow 默认达标率统计窗口
+const defaultWindow = 24 * time.Hour
+
+// SLO指标，route标签为域名规则的pattern
+var (
+	sloRequests = metrics.GetDefaultRegistry().NewCounterVec(
+		"toyou_proxy_slo_requests_total",
+		"Requests counted against latency SLOs by host rule and result (good or slow).",
+		"route", "result",
+	)
+	sloObjective = metrics.GetDefaultRegistry().NewGaugeVec(
+		"toyou_proxy_slo_objective",
+		"Configured latency SLO objective by host rule.",
+		"route",
+	)
+	sloCompliance = metrics.GetDefaultRegistry().NewGaugeVec(
+		"toyou_proxy_slo_compliance",
+		"Ratio of requests within the latency threshold over the SLO window.",
+		"route",
+	)
+	sloBudgetRemaining = metrics.GetDefaultRegistry().NewGaugeVec(
+		"toyou_proxy_slo_error_budget_remaining",
+		"Remaining error budget over the SLO window, negative when exhausted.",
+		"route",
+	)
+	sloBurnRate = metrics.GetDefaultRegistry().NewGaugeVec(
+		"toyou_proxy_slo_burn_rate",
+		"Error budget burn rate over each alert window.",
+		"route", "window",
+	)
+	sloAlertFiring = metrics.GetDefaultRegistry().NewGaugeVec(
+		"toyou_proxy_slo_alert_firing",
+		"Whether the burn rate alert is firing (1) or not (0).",
+		"route", "window",
+	)
+)
+
+// bucket 一个时间粒度内的请求数和慢请求数
+type bucket struct {
+	epoch int64 // 时间除以bucketWidth，用于判断环形缓冲区中的计数是否过期
+	total uint64
+	slow  uint64
+}
+
+// rule 一个域名规则的SLO状态
+type rule struct {
+	name    string
+	cfg     config.SLOConfig
+	latency time.Duration
+	window  time.Duration
+
+	mu      sync.Mutex
+	buckets []bucket
+	firing  []bool // 每个告警是否已触发
+}
+
+// newRule 创建规则状态，环形缓冲区覆盖达标率窗口和所有告警窗口
+func newRule(name string, cfg config.SLOConfig) *rule {
+	window := defaultWindow
+	if cfg.Window > 0 {
+		window = time.Duration(cfg.Window) * time.Second
+	}
+	longest := window
+	for _, alert := range cfg.Alerts {
+		if d := time.Duration(alert.Window) * time.Second; d > longest {
+			longest = d
+		}
+	}
+
+	return &rule{
+		name:    name,
+		cfg:     cfg,
+		latency: time.Duration(cfg.Latency) * time.Millisecond,
+		window:  window,
+		buckets: make([]bucket, bucketsFor(longest)),
+		firing:  make([]bool, len(cfg.Alerts)),
+	}
+}
+
+// bucketsFor 返回覆盖时长所需的计数个数
+func bucketsFor(d time.Duration) int {
+	return int((d + bucketWidth - 1) / bucketWidth)
+}
+
+// observe 记录一个请求的延迟
+func (r *rule) observe(latency time.Duration, now time.Time) {
+	slow := latency > r.latency
+	epoch := now.UnixNano() / int64(bucketWidth)
+
+	r.mu.Lock()
+	b := &r.buckets[epoch%int64(len(r.buckets))]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	b.total++
+	if slow {
+		b.slow++
+	}
+	r.mu.Unlock()
+
+	if slow {
+		sloRequests.Inc(r.name, "slow")
+	} else {
+		sloRequests.Inc(r.name, "good")
+	}
+}
+
+// sum 统计截至now的窗口内的请求数和慢请求数
+func (r *rule) sum(window time.Duration, now time.Time) (total, slow uint64) {
+	epoch := now.UnixNano() / int64(bucketWidth)
+	n := int64(bucketsFor(window))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for e := epoch - n + 1; e <= epoch; e++ {
+		if b := r.buckets[e%int64(len(r.buckets))]; b.epoch == e {
+			total += b.total
+			slow += b.slow
+		}
+	}
+	return total, slow
+}
+
+// budget 错误预算，即允许的慢请求比例
+func (r *rule) budget() float64 {
+	return 1 - r.cfg.Objective
+}
+
+// evaluate 更新达标率和燃烧率指标，返回触发或恢复的告警
+func (r *rule) evaluate(now time.Time) []Event {
+	total, slow := r.sum(r.window, now)
+	compliance := 1.0
+	if total > 0 {
+		compliance = 1 - float64(slow)/float64(total)
+	}
+	sloObjective.Set(r.cfg.Objective, r.name)
+	sloCompliance.Set(compliance, r.name)
+	sloBudgetRemaining.Set(1-(1-compliance)/r.budget(), r.name)
+
+	var events []Event
+	for i, alert := range r.cfg.Alerts {
+		window := time.Duration(alert.Window) * time.Second
+		label := formatWindow(window)
+
+		total, slow := r.sum(window, now)
+		burnRate := 0.0
+		if total > 0 {
+			burnRate = float64(slow) / float64(total) / r.budget()
+		}
+		sloBurnRate.Set(burnRate, r.name, label)
+
+		firing := burnRate > alert.BurnRate && total >= uint64(alert.MinRequests)
+		r.mu.Lock()
+		changed := firing != r.firing[i]
+		r.firing[i] = firing
+		r.mu.Unlock()
+
+		if firing {
+			sloAlertFiring.Set(1, r.name, label)
+		} else {
+			sloAlertFiring.Set(0, r.name, label)
+		}
+		if !changed {
+			continue
+		}
+
+		status := StatusResolved
+		if firing {
+			status = StatusFiring
+		}
+		events = append(events, Event{
+			Status:     status,
+			Route:      r.name,
+			Objective:  r.cfg.Objective,
+			LatencyMs:  r.cfg.Latency,
+			Window:     label,
+			BurnRate:   burnRate,
+			Threshold:  alert.BurnRate,
+			Requests:   total,
+			Slow:       slow,
+			Compliance: compliance,
+			Time:       now,
+		})
+	}
+	return events
+}
+
+// formatWindow 把窗口格式化为指标标签，如 5m、1h
+func formatWindow(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// Tracker 按域名规则统计延迟SLO，定期计算达标率和燃烧率并发送告警通知
+type Tracker struct {
+	mu       sync.RWMutex
+	rules    map[string]*rule
+	notifier *notifier
+	started  sync.Once
+	evalMu   sync.Mutex // 重新加载和定期计算不能同时进行，否则同一状态变化可能通知两次，已删除规则的指标可能重新出现
+}
+
+// NewTracker 创建SLO统计器
+func NewTracker() *Tracker {
+	return &Tracker{
+		rules:    make(map[string]*rule),
+		notifier: newNotifier(),
+	}
+}
+
+// 全局默认SLO统计器实例
+var defaultTracker = NewTracker()
+
+// GetDefaultTracker 获取默认SLO统计器实例
+func GetDefaultTracker() *Tracker {
+	return defaultTracker
+}
+
+// Configure 按域名规则设置SLO，创建代理处理器和重新加载配置时调用
+// 配置未变化的规则保留已有的计数和告警状态
+func (t *Tracker) Configure(hostRules []config.HostRule) {
+	t.evalMu.Lock()
+	defer t.evalMu.Unlock()
+
+	t.mu.Lock()
+	rules := make(map[string]*rule)
+	for _, hostRule := range hostRules {
+		if hostRule.SLO == nil {
+			continue
+		}
+		if existing, exists := t.rules[hostRule.Pattern]; exists && reflect.DeepEqual(existing.cfg, *hostRule.SLO) {
+			rules[hostRule.Pattern] = existing
+			continue
+		}
+		rules[hostRule.Pattern] = newRule(hostRule.Pattern, *hostRule.SLO)
+	}
+	t.rules = rules
+	t.mu.Unlock()
+
+	// 删除已移除的规则和告警窗口的指标
+	sloObjective.Reset()
+	sloCompliance.Reset()
+	sloBudgetRemaining.Reset()
+	sloBurnRate.Reset()
+	sloAlertFiring.Reset()
+
+	if len(rules) > 0 {
+		t.started.Do(func() { go t.run() })
+	}
+	t.evaluateRules(time.Now())
+}
+
+// Observe 记录域名规则的一个请求的延迟，规则没有配置SLO时忽略
+func (t *Tracker) Observe(route string, latency time.Duration) {
+	t.mu.RLock()
+	r, exists := t.rules[route]
+	t.mu.RUnlock()
+
+	if exists {
+		r.observe(latency, time.Now())
+	}
+}
+
+// run 定期计算所有规则的达标率和燃烧率
+func (t *Tracker) run() {
+	ticker := time.NewTicker(bucketWidth)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		t.evalMu.Lock()
+		t.evaluateRules(now)
+		t.evalMu.Unlock()
+	}
+}
+
+// evaluateRules 计算所有规则的指标，向规则配置的webhook发送告警状态变化，调用时持有evalMu
+func (t *Tracker) evaluateRules(now time.Time) {
+	t.mu.RLock()
+	rules := make([]*rule, 0, len(t.rules))
+	for _, r := range t.rules {
+		rules = append(rules, r)
+	}
+	t.mu.RUnlock()
+
+	for _, r := range rules {
+		for _, event := range r.evaluate(now) {
+			t.notifier.notify(r.cfg.Webhooks, event)
+		}
+	}
+}
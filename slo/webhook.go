@@ -0,0 +1,96 @@
+package slo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"toyou-proxy/metrics"
+)
+
+// webhookTimeout 发送告警通知的超时时间
+const webhookTimeout = 10 * time.Second
+
+// 告警状态
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// webhook通知结果指标
+var webhookDeliveries = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_slo_webhooks_total",
+	"SLO alert webhook deliveries by result.",
+	"result",
+)
+
+// Event 燃烧率告警触发或恢复的通知，以JSON格式POST到webhook
+type Event struct {
+	Status     string    `json:"status"` // firing或resolved
+	Route      string    `json:"route"`  // 域名规则
+	Objective  float64   `json:"objective"`
+	LatencyMs  int       `json:"latency_ms"`
+	Window     string    `json:"window"` // 告警窗口，如 5m、1h
+	BurnRate   float64   `json:"burn_rate"`
+	Threshold  float64   `json:"threshold"`
+	Requests   uint64    `json:"requests"` // 告警窗口内的请求数
+	Slow       uint64    `json:"slow"`     // 告警窗口内的慢请求数
+	Compliance float64   `json:"compliance"`
+	Time       time.Time `json:"time"`
+}
+
+// notifier 异步发送告警通知，失败时只记录日志，不重试
+type notifier struct {
+	client *http.Client
+}
+
+// newNotifier 创建告警通知发送器
+func newNotifier() *notifier {
+	return &notifier{client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// notify 记录告警状态变化，并发送到所有webhook
+func (n *notifier) notify(webhooks []string, event Event) {
+	log.Printf("SLO alert %s for %s: burn rate %.2f over %s (threshold %.2f, %d of %d requests slower than %dms)",
+		event.Status, event.Route, event.BurnRate, event.Window, event.Threshold, event.Slow, event.Requests, event.LatencyMs)
+
+	if len(webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode SLO alert: %v", err)
+		return
+	}
+	for _, webhook := range webhooks {
+		go n.send(webhook, body)
+	}
+}
+
+// send 发送一个通知
+func (n *notifier) send(webhook string, body []byte) {
+	err := n.post(webhook, body)
+	if err != nil {
+		webhookDeliveries.Inc("error")
+		log.Printf("Failed to send SLO alert to %s: %v", webhook, err)
+		return
+	}
+	webhookDeliveries.Inc("success")
+}
+
+// post 以JSON格式POST通知，2xx以外的状态码视为失败
+func (n *notifier) post(webhook string, body []byte) error {
+	resp, err := n.client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -5,7 +5,7 @@
 # 检查并构建可执行文件
 if [ ! -f "toyou-proxy" ]; then
     echo "构建 Toyou Proxy..."
-    go build -o toyou-proxy cmd/main.go
+    go build -o toyou-proxy ./cmd
 fi
 
 # 启动代理服务器
@@ -0,0 +1,128 @@
+package stores
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+)
+
+// DefaultRedisKeyPrefix 共享状态键的默认前缀
+const DefaultRedisKeyPrefix = "toyou:"
+
+// defaultRedisTimeout 单次操作的默认超时，操作在请求路径上执行，超时后使用本地状态
+const defaultRedisTimeout = 100 * time.Millisecond
+
+// failureLogInterval Redis不可用时输出日志的最小间隔
+const failureLogInterval = time.Minute
+
+// redisErrors Redis操作失败次数，按使用方分类
+var redisErrors = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_store_errors_total",
+	"Shared state store operations that failed and fell back to local state.",
+	"operation",
+)
+
+// Redis 多个代理实例共享状态的Redis存储，会话保持和限流在配置了共享存储时使用，存储不可用时退回本地状态
+type Redis struct {
+	client      *redis.Client
+	prefix      string
+	timeout     time.Duration
+	config      config.RedisStoreConfig
+	lastFailure atomic.Int64 // 上次输出失败日志的时间（UnixNano）
+}
+
+// NewRedis 创建Redis存储，连接在第一次操作时建立
+func NewRedis(cfg config.RedisStoreConfig) *Redis {
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = DefaultRedisKeyPrefix
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+
+	return &Redis{
+		client: redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		}),
+		prefix:  prefix,
+		timeout: timeout,
+		config:  cfg,
+	}
+}
+
+// Client 返回Redis客户端
+func (r *Redis) Client() *redis.Client {
+	return r.client
+}
+
+// Key 返回带前缀的键，各部分以 : 连接
+func (r *Redis) Key(parts ...string) string {
+	return r.prefix + strings.Join(parts, ":")
+}
+
+// Context 返回带操作超时的上下文
+func (r *Redis) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, r.timeout)
+}
+
+// Failed 记录失败的操作，调用方随后使用本地状态；日志每分钟最多输出一次，避免Redis不可用时刷屏
+func (r *Redis) Failed(operation string, err error) {
+	redisErrors.Inc(operation)
+
+	now := time.Now().UnixNano()
+	last := r.lastFailure.Load()
+	if now-last < int64(failureLogInterval) || !r.lastFailure.CompareAndSwap(last, now) {
+		return
+	}
+	log.Printf("Shared store %s failed, using local state: %v", operation, err)
+}
+
+// Close 关闭Redis连接
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+var (
+	defaultRedis   *Redis
+	defaultRedisMu sync.RWMutex
+)
+
+// Configure 设置全局的共享状态存储，创建代理处理器和重新加载配置时调用；配置没有变化时保留已有的连接
+func Configure(cfg config.StoresConfig) {
+	defaultRedisMu.Lock()
+	defer defaultRedisMu.Unlock()
+
+	if defaultRedis != nil && cfg.Redis != nil && reflect.DeepEqual(defaultRedis.config, *cfg.Redis) {
+		return
+	}
+
+	// 关闭旧连接，正在使用它的请求出错后使用本地状态
+	if defaultRedis != nil {
+		defaultRedis.Close()
+		defaultRedis = nil
+	}
+	if cfg.Redis != nil {
+		defaultRedis = NewRedis(*cfg.Redis)
+		log.Printf("Sharing session affinity and rate limit state through Redis at %s", cfg.Redis.Addr)
+	}
+}
+
+// GetDefaultRedis 获取按 stores.redis 配置的全局Redis存储，没有配置时返回nil
+func GetDefaultRedis() *Redis {
+	defaultRedisMu.RLock()
+	defer defaultRedisMu.RUnlock()
+	return defaultRedis
+}
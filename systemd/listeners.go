@@ -0,0 +1,64 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFdsStart systemd传递的第一个文件描述符
+const listenFdsStart = 3
+
+// Listeners 返回systemd socket activation传入的TCP监听器，按端口索引
+// 未通过socket activation启动时返回空map
+func Listeners() (map[int]net.Listener, error) {
+	listeners := make(map[int]net.Listener)
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return listeners, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return listeners, nil
+	}
+
+	// 避免子进程误用这些环境变量
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("socket activation fd %d is not a listener: %v", fd, err)
+		}
+
+		addr, ok := listener.Addr().(*net.TCPAddr)
+		if !ok {
+			listener.Close()
+			closeListeners(listeners)
+			return nil, fmt.Errorf("socket activation fd %d is not a TCP listener: %s", fd, listener.Addr())
+		}
+		if _, exists := listeners[addr.Port]; exists {
+			listener.Close()
+			closeListeners(listeners)
+			return nil, fmt.Errorf("socket activation passed multiple listeners for port %d", addr.Port)
+		}
+		listeners[addr.Port] = listener
+	}
+
+	return listeners, nil
+}
+
+// closeListeners 关闭所有监听器
+func closeListeners(listeners map[int]net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
@@ -0,0 +1,94 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sd_notify 状态
+const (
+	StateReady     = "READY=1"
+	StateStopping  = "STOPPING=1"
+	StateReloading = "RELOADING=1"
+	StateWatchdog  = "WATCHDOG=1"
+)
+
+// Notify 向systemd发送状态通知
+// 未通过systemd启动（没有NOTIFY_SOCKET）时返回false且不报错
+func Notify(state string) (bool, error) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return false, nil
+	}
+
+	// 以@开头的是抽象命名空间套接字
+	if socketAddr[0] == '@' {
+		socketAddr = "\x00" + socketAddr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ready 通知systemd服务已就绪
+func Ready() (bool, error) {
+	return Notify(StateReady)
+}
+
+// Stopping 通知systemd服务正在停止
+func Stopping() (bool, error) {
+	return Notify(StateStopping)
+}
+
+// WatchdogInterval 返回systemd配置的看门狗超时时间（WatchdogSec）
+// 未启用看门狗或看门狗不是针对当前进程时返回false
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" {
+		if pid != strconv.Itoa(os.Getpid()) {
+			return 0, false
+		}
+	}
+
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// StartWatchdog 按看门狗超时时间的一半定期发送心跳，返回停止函数
+// 未启用看门狗时不启动任何goroutine
+func StartWatchdog() (stop func()) {
+	interval, enabled := WatchdogInterval()
+	if !enabled {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				Notify(StateWatchdog)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
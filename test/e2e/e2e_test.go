@@ -0,0 +1,490 @@
+// Package e2e 端到端测试：在进程内启动完整的代理（配置加载、路由、中间件链、负载均衡）和httptest后端，
+// 按场景发送请求并检查结果，用于发现ServeHTTP组合逻辑的回归
+//
+//	go test ./test/e2e              运行所有场景
+//	go test ./test/e2e -run SSE -v  只运行名称匹配的场景并输出代理日志
+package e2e
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMain(m *testing.M) {
+	// 代理日志只在 -v 时输出
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// TestRouting 域名规则、通配符域名和嵌套路由规则选择服务
+func TestRouting(t *testing.T) {
+	s := newStack(t)
+	s.backend("web", named("web"))
+	s.backend("api", named("api"))
+	s.start(`
+services:
+  web:
+    url: "${web}"
+  api:
+    url: "${api}"
+host_rules:
+  - pattern: "www.example.test"
+    target: "web"
+    route_rules:
+      - pattern: "/api/*"
+        target: "api"
+  - pattern: "*.api.example.test"
+    target: "api"
+`)
+
+	resp := s.get("www.example.test", "/index.html")
+	s.expect(resp, http.StatusOK, "web /index.html")
+	if got := resp.header.Get("X-Target-Service"); got != "web" {
+		t.Errorf("X-Target-Service = %q, want web", got)
+	}
+	s.expect(s.get("www.example.test", "/api/users"), http.StatusOK, "api /api/users")
+	s.expect(s.get("eu.api.example.test", "/v1"), http.StatusOK, "api /v1")
+
+	if resp := s.get("unknown.example.test", "/"); resp.status != http.StatusBadGateway {
+		t.Errorf("unmatched host: status = %d, want %d", resp.status, http.StatusBadGateway)
+	}
+}
+
+// TestMiddlewareChain 域名规则和路由规则的中间件按配置顺序执行，请求和响应阶段的中间件都生效
+func TestMiddlewareChain(t *testing.T) {
+	s := newStack(t)
+	s.backend("app", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"chain":    strings.Join(r.Header.Values(chainHeader), ","),
+			"password": "hunter2",
+		})
+	}))
+	s.start(`
+services:
+  app:
+    url: "${app}"
+middleware_services:
+  - name: "host_tag"
+    type: "e2e_tag"
+    enabled: true
+    config:
+      tag: "host"
+  - name: "first_tag"
+    type: "e2e_tag"
+    enabled: true
+    config:
+      tag: "first"
+  - name: "second_tag"
+    type: "e2e_tag"
+    enabled: true
+    config:
+      tag: "second"
+  - name: "mask_password"
+    type: "mask"
+    enabled: true
+    config:
+      fields: ["password"]
+  - name: "cors_any"
+    type: "cors"
+    enabled: true
+    config:
+      allowed_origins: ["*"]
+host_rules:
+  - pattern: "chain.example.test"
+    target: "app"
+    middlewares: ["host_tag", "cors_any"]
+    route_rules:
+      - pattern: "/api/*"
+        target: "app"
+        middlewares: ["first_tag", "second_tag", "mask_password"]
+`)
+
+	var body struct {
+		Chain    string `json:"chain"`
+		Password string `json:"password"`
+	}
+
+	resp := s.get("chain.example.test", "/", "Origin", "https://app.example.test")
+	s.expect(resp, http.StatusOK, "")
+	if err := json.Unmarshal([]byte(resp.body), &body); err != nil {
+		t.Fatalf("host rule: invalid JSON %q: %v", resp.body, err)
+	}
+	if body.Chain != "host" {
+		t.Errorf("host rule: chain = %q, want host", body.Chain)
+	}
+	if body.Password != "hunter2" {
+		t.Errorf("host rule: password = %q, want it unmasked", body.Password)
+	}
+	if got := resp.header.Get("Access-Control-Allow-Origin"); got != "*" && got != "https://app.example.test" {
+		t.Errorf("host rule: Access-Control-Allow-Origin = %q, want the origin allowed", got)
+	}
+
+	resp = s.get("chain.example.test", "/api/users")
+	s.expect(resp, http.StatusOK, "")
+	if err := json.Unmarshal([]byte(resp.body), &body); err != nil {
+		t.Fatalf("route rule: invalid JSON %q: %v", resp.body, err)
+	}
+	// 路由规则的中间件先于域名规则的中间件执行
+	if body.Chain != "first,second,host" {
+		t.Errorf("route rule: chain = %q, want first,second,host", body.Chain)
+	}
+	if body.Password == "hunter2" {
+		t.Errorf("route rule: password was not masked")
+	}
+}
+
+// TestReplace 中间件设置的替换规则应用到上游响应，Content-Length随之更新；不满足生效条件（媒体类型、响应头标记）
+// 的响应原样转发；只替换第一个匹配、按普通字符串匹配和限制替换次数的规则按配置生效，规则无效的中间件不创建，响应保持原样
+func TestReplace(t *testing.T) {
+	s := newStack(t)
+	s.backend("app", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 同一路由上的二进制资源和没有标记的响应不满足规则的生效条件
+		if r.URL.Path == "/logo.png" {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		if r.URL.Path != "/unmarked" {
+			w.Header().Set("X-Rewrite", "links")
+		}
+		fmt.Fprint(w, "see example.com and example.org")
+	}))
+	s.backend("list", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "a.b axb a.b a.b a.b")
+	}))
+	s.start(`
+services:
+  app:
+    url: "${app}"
+  first:
+    url: "${app}"
+  literal:
+    url: "${list}"
+  broken:
+    url: "${app}"
+middleware_services:
+  - name: "rewrite_links"
+    type: "e2e_replace"
+    enabled: true
+    config:
+      rules:
+        - pattern: "example\\.(com|org)"
+          replacement: "example.test"
+          global: true
+          when:
+            status: 200
+            content_types: ["text/*"]
+            header: "X-Rewrite"
+            header_value: "links"
+  - name: "rewrite_first"
+    type: "e2e_replace"
+    enabled: true
+    config:
+      rules:
+        - pattern: "example\\.\\w+"
+          replacement: "example.test"
+  - name: "rewrite_literal"
+    type: "e2e_replace"
+    enabled: true
+    config:
+      rules:
+        - pattern: "a.b"
+          replacement: "x"
+          literal: true
+          max_replacements: 3
+  - name: "broken_rules"
+    type: "e2e_replace"
+    enabled: true
+    config:
+      rules:
+        - pattern: "example\\.(com"
+          replacement: "example.test"
+host_rules:
+  - pattern: "replace.example.test"
+    target: "app"
+    middlewares: ["rewrite_links"]
+  - pattern: "first.example.test"
+    target: "first"
+    middlewares: ["rewrite_first"]
+  - pattern: "literal.example.test"
+    target: "literal"
+    middlewares: ["rewrite_literal"]
+  - pattern: "broken.example.test"
+    target: "broken"
+    middlewares: ["broken_rules"]
+`)
+
+	resp := s.get("replace.example.test", "/")
+	want := "see example.test and example.test"
+	s.expect(resp, http.StatusOK, want)
+	if got := resp.header.Get("Content-Length"); got != fmt.Sprint(len(want)) {
+		t.Errorf("Content-Length = %q, want %d", got, len(want))
+	}
+
+	s.expect(s.get("replace.example.test", "/logo.png"), http.StatusOK, "see example.com and example.org")
+	s.expect(s.get("replace.example.test", "/unmarked"), http.StatusOK, "see example.com and example.org")
+	s.expect(s.get("first.example.test", "/"), http.StatusOK, "see example.test and example.org")
+	s.expect(s.get("literal.example.test", "/"), http.StatusOK, "x axb x x a.b")
+	s.expect(s.get("broken.example.test", "/"), http.StatusOK, "see example.com and example.org")
+}
+
+// TestReplaceVariables 替换值中的请求变量按每个请求展开：绝对URL改写为请求到达的域名，标签、路径和请求头的值原样插入
+func TestReplaceVariables(t *testing.T) {
+	s := newStack(t)
+	s.backend("app", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="http://internal.test/docs">docs</a> tier=TIER path=PATH`)
+	}))
+	s.start(`
+services:
+  app:
+    url: "${app}"
+middleware_services:
+  - name: "public_links"
+    type: "e2e_replace"
+    enabled: true
+    config:
+      rules:
+        - pattern: "http://internal\\.test"
+          replacement: "{{scheme}}://{{host}}{{header.X-Prefix}}"
+        - pattern: "TIER"
+          replacement: "{{label.tier}}/{{header.X-Env}}"
+          literal: true
+        - pattern: "PATH"
+          replacement: "{{path}}"
+host_rules:
+  - pattern: "*.example.test"
+    target: "app"
+    middlewares: ["public_links"]
+    labels:
+      tier: "edge"
+`)
+
+	s.expect(s.get("www.example.test", "/home"), http.StatusOK,
+		`<a href="http://www.example.test/docs">docs</a> tier=edge/ path=/home`)
+	// 请求头的值中的 $1 不作为分组引用
+	s.expect(s.get("shop.example.test", "/", "X-Env", "prod", "X-Prefix", "/$1"), http.StatusOK,
+		`<a href="http://shop.example.test/$1/docs">docs</a> tier=edge/prod path=/`)
+}
+
+// TestWebSocket 协议升级后双向转发消息
+func TestWebSocket(t *testing.T) {
+	s := newStack(t)
+	upgrader := websocket.Upgrader{}
+	s.backend("ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			kind, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(kind, append([]byte("echo: "), message...)); err != nil {
+				return
+			}
+		}
+	}))
+	s.start(`
+services:
+  ws:
+    url: "${ws}"
+host_rules:
+  - pattern: "ws.example.test"
+    target: "ws"
+`)
+
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+	header := http.Header{"Host": {"ws.example.test"}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.proxy.URL, "http")+"/socket", header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for _, message := range []string{"hello", "world"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_, reply, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(reply) != "echo: "+message {
+			t.Errorf("reply = %q, want %q", reply, "echo: "+message)
+		}
+	}
+}
+
+// TestSSE 事件流逐条转发，不等待上游响应结束
+func TestSSE(t *testing.T) {
+	s := newStack(t)
+	release := make(chan struct{})
+	s.backend("events", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	// 在关闭后端之前结束阻塞的事件流
+	t.Cleanup(func() { close(release) })
+	s.start(`
+services:
+  events:
+    url: "${events}"
+host_rules:
+  - pattern: "sse.example.test"
+    target: "events"
+`)
+
+	req := s.request(http.MethodGet, "/stream", "Accept", "text/event-stream")
+	req.Host = "sse.example.test"
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("Cache-Control"); !strings.Contains(got, "no-cache") {
+		t.Errorf("Cache-Control = %q, want no-cache", got)
+	}
+
+	// 第一条事件在上游继续发送之前到达
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				lines <- line
+			}
+		}
+	}()
+	select {
+	case line := <-lines:
+		if line != "data: first" {
+			t.Errorf("first event = %q, want %q", line, "data: first")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("first event was not streamed before the upstream finished")
+	}
+}
+
+// TestLoadBalancerFailover 一个后端宕机时，重试切换到其他后端，被动健康检查摘除宕机的后端
+func TestLoadBalancerFailover(t *testing.T) {
+	s := newStack(t)
+	s.backend("live", named("live"))
+	s.deadBackend("dead")
+	s.start(`
+services:
+  retried:
+    load_balancer:
+      strategy: "round_robin"
+      backends:
+        - url: "${live}"
+        - url: "${dead}"
+  ejected:
+    load_balancer:
+      strategy: "round_robin"
+      backends:
+        - url: "${live}"
+        - url: "${dead}"
+      outlier_detection:
+        consecutive_errors: 1
+        ejection_time: 1m
+host_rules:
+  - pattern: "retry.example.test"
+    target: "retried"
+    retry:
+      attempts: 2
+      backoff: 1
+  - pattern: "eject.example.test"
+    target: "ejected"
+`)
+
+	for i := 0; i < 4; i++ {
+		s.expect(s.get("retry.example.test", "/"), http.StatusOK, "live")
+	}
+
+	failures := 0
+	for i := 0; i < 6; i++ {
+		resp := s.get("eject.example.test", "/")
+		if resp.status != http.StatusOK {
+			failures++
+			continue
+		}
+		s.expect(resp, http.StatusOK, "live")
+	}
+	if failures != 1 {
+		t.Errorf("%d requests failed, want only the first request to the dead backend", failures)
+	}
+}
+
+// TestSessionAffinity 会话cookie让后续请求发往同一后端，没有cookie的请求照常由负载均衡策略选择
+func TestSessionAffinity(t *testing.T) {
+	s := newStack(t)
+	s.backend("one", named("one"))
+	s.backend("two", named("two"))
+	s.start(`
+services:
+  app:
+    load_balancer:
+      strategy: "round_robin"
+      session_affinity:
+        enabled: true
+        timeout: 1m
+      backends:
+        - url: "${one}"
+        - url: "${two}"
+host_rules:
+  - pattern: "sticky.example.test"
+    target: "app"
+`)
+
+	first := s.get("sticky.example.test", "/")
+	cookie := first.header.Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, "LB_SESSION=") {
+		t.Fatalf("Set-Cookie = %q, want LB_SESSION cookie", cookie)
+	}
+	cookie = strings.SplitN(cookie, ";", 2)[0]
+	backend := strings.Fields(first.body)[0]
+
+	for i := 0; i < 4; i++ {
+		s.expect(s.get("sticky.example.test", "/", "Cookie", cookie), http.StatusOK, backend)
+	}
+
+	// 没有cookie的请求继续轮询
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		seen[strings.Fields(s.get("sticky.example.test", "/").body)[0]] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("requests without a cookie reached %v, want both backends", seen)
+	}
+}
@@ -0,0 +1,154 @@
+package e2e
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/proxy"
+)
+
+// stack 进程内的代理和httptest后端
+type stack struct {
+	t        *testing.T
+	backends map[string]string // 后端名称 -> URL，配置中用 ${名称} 引用
+	proxy    *httptest.Server
+	client   *http.Client
+}
+
+// newStack 创建测试使用的代理环境，测试结束时关闭所有服务器
+func newStack(t *testing.T) *stack {
+	return &stack{
+		t:        t,
+		backends: make(map[string]string),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// backend 启动后端，返回其URL
+func (s *stack) backend(name string, handler http.Handler) string {
+	server := httptest.NewServer(handler)
+	s.t.Cleanup(server.Close)
+	s.backends[name] = server.URL
+	return server.URL
+}
+
+// deadBackend 返回一个没有服务监听的地址，用于模拟宕机的后端
+func (s *stack) deadBackend(name string) string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	s.backends[name] = server.URL
+	return server.URL
+}
+
+// start 按YAML配置创建代理，配置中的 ${名称} 替换为后端URL；配置与正式启动时一样加载和验证，
+// 只使用内置中间件，不编译插件
+func (s *stack) start(yamlConfig string) {
+	s.t.Helper()
+	dir := s.t.TempDir()
+
+	expanded := os.Expand(yamlConfig, func(name string) string {
+		url, ok := s.backends[name]
+		if !ok {
+			s.t.Fatalf("config references unknown backend ${%s}", name)
+		}
+		return url
+	})
+	expanded += fmt.Sprintf("\nplugins:\n  source_dir: %q\n  cache_dir: %q\n", filepath.Join(dir, "plugins"), filepath.Join(dir, "cache"))
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(expanded), 0o600); err != nil {
+		s.t.Fatalf("write config: %v", err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		s.t.Fatalf("load config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		s.t.Fatalf("invalid config: %v", err)
+	}
+
+	handler, err := proxy.NewProxyHandler(cfg)
+	if err != nil {
+		s.t.Fatalf("create proxy handler: %v", err)
+	}
+	listeners := cfg.EffectiveListeners()
+	if len(listeners) == 0 {
+		s.t.Fatalf("config has no host rules")
+	}
+	s.proxy = httptest.NewServer(handler.ForListener(listeners[0]))
+	s.t.Cleanup(s.proxy.Close)
+}
+
+// response 读取完响应体的响应
+type response struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// do 向代理发送请求，host为请求的Host头；请求失败时结束测试
+func (s *stack) do(req *http.Request, host string) *response {
+	s.t.Helper()
+	req.Host = host
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.t.Fatalf("%s %s%s: %v", req.Method, host, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("%s %s%s: read body: %v", req.Method, host, req.URL.Path, err)
+	}
+	return &response{status: resp.StatusCode, header: resp.Header, body: string(body)}
+}
+
+// request 创建发往代理的请求，header为键值对
+func (s *stack) request(method, path string, header ...string) *http.Request {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.proxy.URL+path, nil)
+	if err != nil {
+		s.t.Fatalf("create request: %v", err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return req
+}
+
+// get 发送GET请求
+func (s *stack) get(host, path string, header ...string) *response {
+	s.t.Helper()
+	return s.do(s.request(http.MethodGet, path, header...), host)
+}
+
+// expect 检查响应的状态码和响应体（包含want），不符合时记录失败
+func (s *stack) expect(resp *response, status int, want string) {
+	s.t.Helper()
+	if resp.status != status {
+		s.t.Errorf("status = %d, want %d (body %q)", resp.status, status, resp.body)
+		return
+	}
+	if !strings.Contains(resp.body, want) {
+		s.t.Errorf("body = %q, want it to contain %q", resp.body, want)
+	}
+}
+
+// named 返回在响应体中输出名称和请求路径的后端，用于判断请求被转发到哪个后端
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+	})
+}
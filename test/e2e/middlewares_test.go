@@ -0,0 +1,59 @@
+package e2e
+
+import (
+	"toyou-proxy/middleware"
+)
+
+// chainHeader e2e_tag中间件记录执行顺序的请求头，后端据此检查中间件链
+const chainHeader = "X-E2E-Chain"
+
+// 测试使用的中间件，与内置中间件一样通过middleware_services按类型引用
+func init() {
+	middleware.RegisterBuiltin("e2e_tag", newTagMiddleware)
+	middleware.RegisterBuiltin("e2e_replace", newReplaceMiddleware)
+}
+
+// tagMiddleware 把配置的tag追加到请求头，记录中间件的执行顺序
+type tagMiddleware struct {
+	tag string
+}
+
+func newTagMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	tag, _ := config["tag"].(string)
+	return &tagMiddleware{tag: tag}, nil
+}
+
+// Name 返回中间件名称
+func (m *tagMiddleware) Name() string {
+	return "e2e_tag"
+}
+
+// Handle 追加tag
+func (m *tagMiddleware) Handle(ctx *middleware.Context) bool {
+	ctx.Request.Header.Add(chainHeader, m.tag)
+	return true
+}
+
+// replaceMiddleware 设置代理在响应阶段应用的替换规则
+type replaceMiddleware struct {
+	rules []middleware.ReplaceRule
+}
+
+func newReplaceMiddleware(config map[string]interface{}) (middleware.Middleware, error) {
+	rules, err := middleware.ParseReplaceRules(config)
+	if err != nil {
+		return nil, err
+	}
+	return &replaceMiddleware{rules: rules}, nil
+}
+
+// Name 返回中间件名称
+func (m *replaceMiddleware) Name() string {
+	return "e2e_replace"
+}
+
+// Handle 设置替换规则
+func (m *replaceMiddleware) Handle(ctx *middleware.Context) bool {
+	ctx.Set("replaceRules", m.rules)
+	return true
+}
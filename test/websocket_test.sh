@@ -28,7 +28,7 @@ echo -e "${GREEN}依赖检查完成${NC}"
 
 # 构建代理服务器
 echo -e "${YELLOW}构建代理服务器...${NC}"
-if ! go build -o bin/toyou-proxy ./cmd/main.go; then
+if ! go build -o bin/toyou-proxy ./cmd; then
     echo -e "${RED}代理服务器构建失败${NC}"
     exit 1
 fi
@@ -0,0 +1,99 @@
+package tlsserver
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"toyou-proxy/config"
+	"toyou-proxy/matcher"
+	"toyou-proxy/metrics"
+)
+
+// 默认在证书到期前30天续期
+const defaultACMERenewBefore = 30 * 24 * time.Hour
+
+// ACME证书申请指标
+var acmeObtains = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_tls_acme_obtains_total",
+	"ACME certificate requests at startup by result.",
+	"result",
+)
+
+// acmeIssuer 通过ACME申请和续期域名证书，证书和账户密钥保存在缓存目录中
+// 首次握手时如缓存中没有证书则申请，之后在到期前自动续期；启动时预先申请，避免首个请求等待
+type acmeIssuer struct {
+	manager *autocert.Manager
+	domains []string
+}
+
+// newACMEIssuer 为域名创建ACME证书申请器，只会为这些域名申请证书
+func newACMEIssuer(cfg config.ACMEConfig, domains []string) *acmeIssuer {
+	renewBefore := defaultACMERenewBefore
+	if cfg.RenewBefore > 0 {
+		renewBefore = time.Duration(cfg.RenewBefore) * 24 * time.Hour
+	}
+
+	manager := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cfg.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(domains...),
+		RenewBefore: renewBefore,
+		Email:       cfg.Email,
+	}
+	if cfg.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+	return &acmeIssuer{manager: manager, domains: domains}
+}
+
+// GetCertificate 返回域名的证书，同时响应TLS-ALPN-01验证握手
+func (a *acmeIssuer) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificate, err := a.manager.GetCertificate(hello)
+	if err != nil {
+		return nil, err
+	}
+	if certificate.Leaf != nil && !isACMEChallenge(hello) {
+		hostCertificateExpiry.Set(float64(certificate.Leaf.NotAfter.Unix()), matcher.NormalizeHost(hello.ServerName))
+	}
+	return certificate, nil
+}
+
+// HTTPHandler 在HTTP端口响应HTTP-01验证请求，其余请求交给next处理
+func (a *acmeIssuer) HTTPHandler(next http.Handler) http.Handler {
+	return a.manager.HTTPHandler(next)
+}
+
+// obtainAll 预先为所有域名读取或申请证书，失败的域名在首次握手时重试
+func (a *acmeIssuer) obtainAll() {
+	for _, domain := range a.domains {
+		// 按支持ECDSA的客户端申请，与大多数客户端握手时使用的证书一致
+		hello := &tls.ClientHelloInfo{
+			ServerName:       domain,
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SupportedCurves:  []tls.CurveID{tls.CurveP256},
+			SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		}
+		if _, err := a.GetCertificate(hello); err != nil {
+			acmeObtains.Inc("error")
+			log.Printf("Failed to obtain ACME certificate for %s, retrying on the next handshake: %v", domain, err)
+			continue
+		}
+		acmeObtains.Inc("success")
+		log.Printf("ACME certificate ready for %s", domain)
+	}
+}
+
+// isACMEChallenge 判断握手是否为TLS-ALPN-01验证
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			return true
+		}
+	}
+	return false
+}
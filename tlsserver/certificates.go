@@ -0,0 +1,164 @@
+package tlsserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"toyou-proxy/metrics"
+)
+
+// 证书重新加载默认检查间隔
+const defaultCertReloadInterval = 10 * time.Second
+
+// 证书重新加载指标
+var (
+	certificateReloads = metrics.GetDefaultRegistry().NewCounterVec(
+		"toyou_proxy_tls_certificate_reloads_total",
+		"TLS certificate reloads from disk by result.",
+		"result",
+	)
+	certificateExpiry = metrics.GetDefaultRegistry().NewGaugeVec(
+		"toyou_proxy_tls_certificate_expiry_timestamp_seconds",
+		"Expiry time of the TLS certificate currently served.",
+	)
+	hostCertificateExpiry = metrics.GetDefaultRegistry().NewGaugeVec(
+		"toyou_proxy_tls_host_certificate_expiry_timestamp_seconds",
+		"Expiry time of the TLS certificate served for a host rule.",
+		"host",
+	)
+)
+
+// certReloader 定期检查证书和私钥文件，内容变化后重新加载
+// 证书通过GetCertificate提供给握手，更新后对新连接立即生效，已建立的连接不受影响
+type certReloader struct {
+	host     string // 域名证书对应的域名规则，默认证书为空
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	certificate *tls.Certificate
+	certPEM     []byte
+	keyPEM      []byte
+	mu          sync.RWMutex
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// newCertReloader 创建证书加载器并立即加载一次证书，host为空表示默认证书
+func newCertReloader(host, certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	if interval <= 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	r := &certReloader{
+		host:     host,
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate 返回当前的证书，用作tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+// Start 启动后台检查
+func (r *certReloader) Start() {
+	go r.run()
+}
+
+// Stop 停止后台检查
+func (r *certReloader) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+}
+
+// run 定期检查证书文件
+// 外部工具（如certbot）先后写入证书和私钥时两者可能暂时不匹配，加载失败时保留当前证书并在下次检查时重试
+func (r *certReloader) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := r.reload()
+			if err != nil {
+				certificateReloads.Inc("error")
+				log.Printf("Failed to reload TLS certificate, keeping the current one: %v", err)
+			} else if changed {
+				certificateReloads.Inc("success")
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// reload 读取证书和私钥文件，内容与当前证书不同时加载新证书
+func (r *certReloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read TLS certificate: %v", err)
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read TLS private key: %v", err)
+	}
+
+	r.mu.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("failed to parse TLS certificate: %v", err)
+	}
+	certificate.Leaf = leaf
+
+	r.mu.Lock()
+	reloaded := r.certificate != nil
+	r.certificate = &certificate
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	r.mu.Unlock()
+
+	if r.host == "" {
+		certificateExpiry.Set(float64(leaf.NotAfter.Unix()))
+	} else {
+		hostCertificateExpiry.Set(float64(leaf.NotAfter.Unix()), r.host)
+	}
+	if reloaded {
+		log.Printf("TLS certificate reloaded from %s (subject %s, expires %s)",
+			r.certFile, leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	}
+	return true, nil
+}
@@ -0,0 +1,221 @@
+package tlsserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"toyou-proxy/config"
+)
+
+// 默认ALPN协议，同时支持HTTP/2和HTTP/1.1
+var defaultALPN = []string{"h2", "http/1.1"}
+
+// 启用后量子密钥交换且未配置曲线时的传统曲线，与crypto/tls的默认顺序一致
+var defaultCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// Manager HTTPS监听使用的TLS配置及其后台任务（证书重新加载、会话票据密钥轮换）
+type Manager struct {
+	configs map[int]*tls.Config      // 端口 -> TLS配置
+	certs   map[string]*certReloader // 默认证书和域名证书，按证书和私钥文件去重
+	acme    *acmeIssuer              // 没有域名通过ACME申请证书时为nil
+	tickets *TicketKeyManager        // 关闭会话票据时为nil
+}
+
+// NewManager 根据HTTPS监听配置加载证书，并为每个HTTPS端口按其TLS策略创建服务端TLS配置
+func NewManager(cfg config.TLSConfig) (*Manager, error) {
+	interval := time.Duration(cfg.ReloadInterval) * time.Second
+	m := &Manager{
+		configs: make(map[int]*tls.Config, len(cfg.Ports)),
+		certs:   make(map[string]*certReloader),
+	}
+
+	// 默认证书，未配置时每个端口都只使用域名证书
+	var fallback *certReloader
+	if cfg.CertFile != "" {
+		var err error
+		if fallback, err = newCertReloader("", cfg.CertFile, cfg.KeyFile, interval); err != nil {
+			return nil, err
+		}
+		m.certs[cfg.CertFile+"|"+cfg.KeyFile] = fallback
+	}
+
+	// 所有端口共享一个ACME证书申请器，同一域名挂载到多个端口时只申请一次
+	var acmeDomains []string
+	seen := make(map[string]bool)
+	for _, port := range cfg.Ports {
+		for _, hostCert := range cfg.HostCertificates[port] {
+			if hostCert.ACME && !seen[hostCert.Pattern] {
+				seen[hostCert.Pattern] = true
+				acmeDomains = append(acmeDomains, hostCert.Pattern)
+			}
+		}
+	}
+	if len(acmeDomains) > 0 {
+		m.acme = newACMEIssuer(cfg.ACME, acmeDomains)
+	}
+
+	// 加密ClientHello密钥只在有端口启用时加载（或生成）
+	var ech *echKey
+	var err error
+	for _, port := range cfg.Ports {
+		if cfg.Policy.Merge(cfg.PortPolicies[port]).ECH {
+			if ech, err = loadECHKey(cfg.ECH); err != nil {
+				return nil, err
+			}
+			log.Printf("Encrypted Client Hello enabled with key %s, ECHConfigList: %s",
+				cfg.ECH.KeyFile, base64.StdEncoding.EncodeToString(ech.configList))
+			break
+		}
+	}
+
+	tlsConfigs := make([]*tls.Config, 0, len(cfg.Ports))
+	for _, port := range cfg.Ports {
+		certs, err := newCertSelector(cfg.HostCertificates[port], fallback, m.certs, m.acme, interval)
+		if err != nil {
+			return nil, fmt.Errorf("tls certificates for port %d: %v", port, err)
+		}
+		tlsConfig, err := newTLSConfig(cfg.Policy.Merge(cfg.PortPolicies[port]), certs, ech)
+		if err != nil {
+			return nil, fmt.Errorf("tls policy for port %d: %v", port, err)
+		}
+		tlsConfig.SessionTicketsDisabled = cfg.SessionTickets.Disabled
+		// 端口上有ACME域名时接受TLS-ALPN-01验证握手，普通客户端不会协商该协议
+		if certs.acme != nil {
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		}
+		m.configs[port] = tlsConfig
+		tlsConfigs = append(tlsConfigs, tlsConfig)
+	}
+
+	if cfg.SessionTickets.Disabled {
+		return m, nil
+	}
+
+	// 所有端口使用同一组会话票据密钥，会话可以在不同端口之间恢复
+	m.tickets, err = NewTicketKeyManager(cfg.SessionTickets, tlsConfigs...)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// newTLSConfig 按TLS策略创建服务端TLS配置
+func newTLSConfig(policy config.TLSPolicy, certs *certSelector, ech *echKey) (*tls.Config, error) {
+	minVersion, err := config.ParseTLSVersion(policy.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	maxVersion, err := config.ParseTLSVersion(policy.MaxVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := config.ParseCipherSuites(policy.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	curves, err := config.ParseCurves(policy.CurvePreferences)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := config.ParseClientAuth(policy.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	var clientCAs *x509.CertPool
+	if policy.ClientCAFile != "" {
+		if clientCAs, err = loadCertPool(policy.ClientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	alpn := policy.ALPN
+	if len(alpn) == 0 {
+		alpn = defaultALPN
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     minVersion,
+		MaxVersion:     maxVersion,
+		NextProtos:     alpn,
+		ClientAuth:     clientAuth,
+		ClientCAs:      clientCAs,
+	}
+	if len(cipherSuites) > 0 {
+		tlsConfig.CipherSuites = cipherSuites
+	}
+	// go.mod声明的Go版本较低，默认不启用后量子密钥交换，需要显式加入曲线列表
+	if policy.PostQuantum {
+		if len(curves) == 0 {
+			curves = defaultCurves
+		}
+		curves = append(append([]tls.CurveID(nil), postQuantumCurves...), curves...)
+	}
+	if len(curves) > 0 {
+		tlsConfig.CurvePreferences = curves
+	}
+	if policy.ECH && ech != nil {
+		setECHKey(tlsConfig, ech)
+	}
+	return tlsConfig, nil
+}
+
+// loadCertPool 读取PEM格式的CA证书
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA file '%s'", file)
+	}
+	return pool, nil
+}
+
+// Config 返回端口的服务端TLS配置，端口不提供HTTPS时返回nil
+// 监听器需直接使用该配置（而不是副本），证书和密钥更新才能生效
+func (m *Manager) Config(port int) *tls.Config {
+	return m.configs[port]
+}
+
+// HTTPHandler 在HTTP端口响应ACME的HTTP-01验证请求，其余请求交给next处理；没有ACME域名时直接返回next
+func (m *Manager) HTTPHandler(next http.Handler) http.Handler {
+	if m.acme == nil {
+		return next
+	}
+	return m.acme.HTTPHandler(next)
+}
+
+// Start 启动后台任务
+func (m *Manager) Start() {
+	for _, certs := range m.certs {
+		certs.Start()
+	}
+	if m.acme != nil {
+		go m.acme.obtainAll()
+	}
+	if m.tickets != nil {
+		m.tickets.Start()
+	}
+}
+
+// Stop 停止后台任务
+func (m *Manager) Stop() {
+	for _, certs := range m.certs {
+		certs.Stop()
+	}
+	if m.tickets != nil {
+		m.tickets.Stop()
+	}
+}
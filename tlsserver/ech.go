@@ -0,0 +1,145 @@
+package tlsserver
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"toyou-proxy/config"
+)
+
+// ECH相关常量（draft-ietf-tls-esni，RFC 9180 HPKE）
+const (
+	echVersion         = 0xfe0d
+	hpkeKEMX25519      = 0x0020 // DHKEM(X25519, HKDF-SHA256)
+	hpkeKDFSHA256      = 0x0001
+	hpkeAEADAES128GCM  = 0x0001
+	hpkeAEADChaCha20   = 0x0003
+	echMaxNameLength   = 0
+	echConfigPEMType   = "ECHCONFIG"
+	echPrivatePEMType  = "PRIVATE KEY"
+	echDefaultConfigID = 1
+)
+
+// echKey ECH密钥：序列化的ECHConfig及其HPKE私钥
+type echKey struct {
+	config     []byte // 单个ECHConfig，与发布给客户端的内容逐字节一致
+	configList []byte // 发布到DNS的ECHConfigList
+	privateKey []byte // X25519私钥
+}
+
+// loadECHKey 读取ECH密钥文件，文件不存在时使用public_name生成新密钥
+// 文件格式与draft-farrell-tls-pemesni一致：PKCS#8私钥和base64编码的ECHConfigList
+func loadECHKey(cfg config.ECHConfig) (*echKey, error) {
+	data, err := os.ReadFile(cfg.KeyFile)
+	if os.IsNotExist(err) {
+		return generateECHKey(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ECH key file: %v", err)
+	}
+
+	key := &echKey{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case echPrivatePEMType:
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ECH private key: %v", err)
+			}
+			privateKey, ok := parsed.(*ecdh.PrivateKey)
+			if !ok || privateKey.Curve() != ecdh.X25519() {
+				return nil, fmt.Errorf("ECH private key must be an X25519 key")
+			}
+			key.privateKey = privateKey.Bytes()
+		case echConfigPEMType:
+			key.configList = block.Bytes
+		}
+	}
+	if key.privateKey == nil || key.configList == nil {
+		return nil, fmt.Errorf("ECH key file '%s' must contain a PRIVATE KEY and an ECHCONFIG block", cfg.KeyFile)
+	}
+
+	// 服务端使用列表中的第一个配置
+	if len(key.configList) < 2 || int(binary.BigEndian.Uint16(key.configList))+2 != len(key.configList) {
+		return nil, fmt.Errorf("invalid ECHConfigList in '%s'", cfg.KeyFile)
+	}
+	configs := key.configList[2:]
+	if len(configs) < 4 || int(binary.BigEndian.Uint16(configs[2:4]))+4 > len(configs) {
+		return nil, fmt.Errorf("invalid ECHConfig in '%s'", cfg.KeyFile)
+	}
+	key.config = configs[:4+int(binary.BigEndian.Uint16(configs[2:4]))]
+	return key, nil
+}
+
+// generateECHKey 生成X25519密钥和ECHConfig并写入密钥文件
+func generateECHKey(cfg config.ECHConfig) (*echKey, error) {
+	if cfg.PublicName == "" || len(cfg.PublicName) > 255 {
+		return nil, fmt.Errorf("ech.public_name is required to generate an ECH key")
+	}
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ECH key: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ECH key: %v", err)
+	}
+
+	echConfig := marshalECHConfig(echDefaultConfigID, privateKey.PublicKey().Bytes(), cfg.PublicName)
+	configList := binary.BigEndian.AppendUint16(nil, uint16(len(echConfig)))
+	configList = append(configList, echConfig...)
+
+	content := pem.EncodeToMemory(&pem.Block{Type: echPrivatePEMType, Bytes: pkcs8})
+	content = append(content, pem.EncodeToMemory(&pem.Block{Type: echConfigPEMType, Bytes: configList})...)
+
+	if err := os.MkdirAll(filepath.Dir(cfg.KeyFile), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ECH key directory: %v", err)
+	}
+	if err := os.WriteFile(cfg.KeyFile, content, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write ECH key file: %v", err)
+	}
+	log.Printf("Generated ECH key %s for public name %s, publish the ECHConfigList in the DNS HTTPS record (ech=...)",
+		cfg.KeyFile, cfg.PublicName)
+
+	return &echKey{
+		config:     echConfig,
+		configList: configList,
+		privateKey: privateKey.Bytes(),
+	}, nil
+}
+
+// marshalECHConfig 序列化ECHConfig（版本0xfe0d）
+func marshalECHConfig(configID uint8, publicKey []byte, publicName string) []byte {
+	var contents []byte
+	contents = append(contents, configID)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeKEMX25519)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(publicKey)))
+	contents = append(contents, publicKey...)
+	// 支持的HPKE对称加密套件
+	contents = binary.BigEndian.AppendUint16(contents, 8)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeKDFSHA256)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeAEADAES128GCM)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeKDFSHA256)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeAEADChaCha20)
+	contents = append(contents, echMaxNameLength)
+	contents = append(contents, uint8(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0) // 扩展
+
+	echConfig := binary.BigEndian.AppendUint16(nil, echVersion)
+	echConfig = binary.BigEndian.AppendUint16(echConfig, uint16(len(contents)))
+	return append(echConfig, contents...)
+}
@@ -0,0 +1,460 @@
+package tlsserver
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 记录ClientHello的最大字节数，超过后放弃计算指纹
+const maxClientHelloSize = 16 * 1024
+
+// TLS扩展类型
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+// Fingerprint 客户端TLS指纹，根据握手时的ClientHello计算，同一客户端实现的指纹相同
+type Fingerprint struct {
+	JA3     string `json:"ja3"`      // JA3原始字符串
+	JA3Hash string `json:"ja3_hash"` // JA3字符串的MD5
+	JA4     string `json:"ja4"`
+}
+
+// NewListener 创建HTTPS监听器，在完成TLS握手的同时记录ClientHello用于计算指纹
+func NewListener(inner net.Listener, tlsConfig *tls.Config) net.Listener {
+	return tls.NewListener(&helloListener{Listener: inner}, tlsConfig)
+}
+
+// helloListener 为每个连接记录ClientHello
+type helloListener struct {
+	net.Listener
+}
+
+// Accept 接受连接
+func (l *helloListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: conn}, nil
+}
+
+// helloConn 记录连接最开始读取的握手记录，直到得到完整的ClientHello
+type helloConn struct {
+	net.Conn
+
+	raw      []byte // 读取到的原始TLS记录
+	hello    []byte // 完整的ClientHello消息，不含握手消息头
+	complete bool   // 已得到ClientHello或已放弃记录
+	mu       sync.Mutex
+
+	once        sync.Once
+	fingerprint *Fingerprint
+}
+
+// Read 读取数据，同时记录握手记录
+func (c *helloConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(p[:n])
+	}
+	return n, err
+}
+
+// record 追加原始数据并尝试提取ClientHello
+func (c *helloConn) record(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.complete {
+		return
+	}
+	c.raw = append(c.raw, data...)
+
+	hello, ok, err := extractClientHello(c.raw)
+	if ok || err != nil || len(c.raw) > maxClientHelloSize {
+		c.hello = hello
+		c.raw = nil
+		c.complete = true
+	}
+}
+
+// Fingerprint 计算连接的TLS指纹，ClientHello不完整或无法解析时返回nil
+func (c *helloConn) Fingerprint() *Fingerprint {
+	c.once.Do(func() {
+		c.mu.Lock()
+		hello := c.hello
+		c.mu.Unlock()
+
+		if hello != nil {
+			c.fingerprint, _ = computeFingerprint(hello)
+		}
+	})
+	return c.fingerprint
+}
+
+// extractClientHello 从TLS记录中拼出第一条握手消息，返回是否已完整
+func extractClientHello(raw []byte) ([]byte, bool, error) {
+	var handshake []byte
+	for len(raw) >= 5 {
+		if raw[0] != 22 { // handshake
+			return nil, false, fmt.Errorf("not a handshake record")
+		}
+		length := int(binary.BigEndian.Uint16(raw[3:5]))
+		if len(raw) < 5+length {
+			break
+		}
+		handshake = append(handshake, raw[5:5+length]...)
+		raw = raw[5+length:]
+
+		if len(handshake) >= 4 {
+			if handshake[0] != 1 { // client_hello
+				return nil, false, fmt.Errorf("not a client hello")
+			}
+			size := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+			if len(handshake) >= 4+size {
+				return handshake[4 : 4+size], true, nil
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+// clientHello 计算指纹所需的ClientHello字段
+type clientHello struct {
+	version       uint16
+	ciphers       []uint16
+	extensions    []uint16
+	groups        []uint16
+	pointFormats  []uint8
+	sigAlgs       []uint16
+	versions      []uint16
+	alpn          []string
+	hasServerName bool
+}
+
+// parseClientHello 解析ClientHello消息
+func parseClientHello(data []byte) (*clientHello, error) {
+	r := byteReader(data)
+	hello := &clientHello{}
+
+	var ok bool
+	if hello.version, ok = r.uint16(); !ok {
+		return nil, fmt.Errorf("truncated client hello")
+	}
+	if _, ok = r.bytes(32); !ok { // random
+		return nil, fmt.Errorf("truncated client hello")
+	}
+	if _, ok = r.vector8(); !ok { // session_id
+		return nil, fmt.Errorf("truncated client hello")
+	}
+	ciphers, ok := r.vector16()
+	if !ok {
+		return nil, fmt.Errorf("truncated client hello")
+	}
+	hello.ciphers = ciphers.uint16s()
+	if _, ok = r.vector8(); !ok { // compression_methods
+		return nil, fmt.Errorf("truncated client hello")
+	}
+	if len(r) == 0 {
+		return hello, nil
+	}
+
+	extensions, ok := r.vector16()
+	if !ok {
+		return nil, fmt.Errorf("truncated extensions")
+	}
+	for len(extensions) > 0 {
+		extType, ok := extensions.uint16()
+		if !ok {
+			return nil, fmt.Errorf("truncated extension")
+		}
+		extData, ok := extensions.vector16()
+		if !ok {
+			return nil, fmt.Errorf("truncated extension")
+		}
+		hello.extensions = append(hello.extensions, extType)
+
+		switch extType {
+		case extServerName:
+			hello.hasServerName = true
+		case extSupportedGroups:
+			if groups, ok := extData.vector16(); ok {
+				hello.groups = groups.uint16s()
+			}
+		case extECPointFormats:
+			if formats, ok := extData.vector8(); ok {
+				hello.pointFormats = formats
+			}
+		case extSignatureAlgorithms:
+			if algs, ok := extData.vector16(); ok {
+				hello.sigAlgs = algs.uint16s()
+			}
+		case extSupportedVersions:
+			if versions, ok := extData.vector8(); ok {
+				hello.versions = byteReader(versions).uint16s()
+			}
+		case extALPN:
+			if protocols, ok := extData.vector16(); ok {
+				for len(protocols) > 0 {
+					protocol, ok := protocols.vector8()
+					if !ok {
+						break
+					}
+					hello.alpn = append(hello.alpn, string(protocol))
+				}
+			}
+		}
+	}
+	return hello, nil
+}
+
+// computeFingerprint 根据ClientHello计算JA3和JA4指纹
+func computeFingerprint(data []byte) (*Fingerprint, error) {
+	hello, err := parseClientHello(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ja3 := ja3String(hello)
+	sum := md5.Sum([]byte(ja3))
+	return &Fingerprint{
+		JA3:     ja3,
+		JA3Hash: hex.EncodeToString(sum[:]),
+		JA4:     ja4String(hello),
+	}, nil
+}
+
+// ja3String 计算JA3字符串：版本,加密套件,扩展,曲线,点格式，忽略GREASE值
+func ja3String(hello *clientHello) string {
+	formats := make([]string, len(hello.pointFormats))
+	for i, format := range hello.pointFormats {
+		formats[i] = strconv.Itoa(int(format))
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(int(hello.version)),
+		joinDecimal(hello.ciphers),
+		joinDecimal(hello.extensions),
+		joinDecimal(hello.groups),
+		strings.Join(formats, "-"),
+	}, ",")
+}
+
+// ja4String 计算JA4指纹（TCP上的TLS）
+// 格式为 t{版本}{有无SNI}{套件数}{扩展数}{ALPN}_{排序后套件的哈希}_{排序后扩展和签名算法的哈希}
+func ja4String(hello *clientHello) string {
+	version := hello.version
+	for _, v := range hello.versions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+
+	sni := "i"
+	if hello.hasServerName {
+		sni = "d"
+	}
+
+	ciphers := withoutGREASE(hello.ciphers)
+	extensions := withoutGREASE(hello.extensions)
+
+	alpn := "00"
+	if len(hello.alpn) > 0 && hello.alpn[0] != "" {
+		alpn = alpnCode(hello.alpn[0])
+	}
+
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", tlsVersionCode(version), sni,
+		min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	// 扩展哈希不包含SNI和ALPN，二者已体现在前缀中
+	hashed := make([]uint16, 0, len(extensions))
+	for _, ext := range extensions {
+		if ext != extServerName && ext != extALPN {
+			hashed = append(hashed, ext)
+		}
+	}
+	extPart := joinHex(sortedCopy(hashed))
+	if sigAlgs := withoutGREASE(hello.sigAlgs); len(sigAlgs) > 0 {
+		extPart += "_" + joinHex(sigAlgs)
+	}
+
+	cipherHash := "000000000000"
+	if len(ciphers) > 0 {
+		cipherHash = truncatedSHA256(joinHex(sortedCopy(ciphers)))
+	}
+	extHash := "000000000000"
+	if len(hashed) > 0 {
+		extHash = truncatedSHA256(extPart)
+	}
+
+	return prefix + "_" + cipherHash + "_" + extHash
+}
+
+// tlsVersionCode JA4中的TLS版本
+func tlsVersionCode(version uint16) string {
+	switch version {
+	case tls.VersionTLS13:
+		return "13"
+	case tls.VersionTLS12:
+		return "12"
+	case tls.VersionTLS11:
+		return "11"
+	case tls.VersionTLS10:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// alpnCode JA4中的ALPN：第一个协议的首尾字符，非字母数字时取十六进制的首尾字符
+func alpnCode(protocol string) string {
+	first, last := protocol[0], protocol[len(protocol)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	encoded := hex.EncodeToString([]byte(protocol))
+	return encoded[:1] + encoded[len(encoded)-1:]
+}
+
+// isAlphanumeric 判断字节是否是ASCII字母或数字
+func isAlphanumeric(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// isGREASE 判断是否是GREASE值（RFC 8701），客户端随机插入，计算指纹时需要忽略
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// withoutGREASE 去掉GREASE值
+func withoutGREASE(values []uint16) []uint16 {
+	result := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// sortedCopy 返回排序后的副本
+func sortedCopy(values []uint16) []uint16 {
+	sorted := append([]uint16(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// joinDecimal 以十进制和"-"连接，忽略GREASE值
+func joinDecimal(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// joinHex 以4位十六进制和","连接
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// truncatedSHA256 SHA256的前12个十六进制字符
+func truncatedSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// byteReader 按TLS编码规则读取字段
+type byteReader []byte
+
+// bytes 读取n个字节
+func (r *byteReader) bytes(n int) ([]byte, bool) {
+	if len(*r) < n {
+		return nil, false
+	}
+	value := (*r)[:n]
+	*r = (*r)[n:]
+	return value, true
+}
+
+// uint16 读取2字节整数
+func (r *byteReader) uint16() (uint16, bool) {
+	value, ok := r.bytes(2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(value), true
+}
+
+// vector8 读取1字节长度前缀的数据
+func (r *byteReader) vector8() (byteReader, bool) {
+	length, ok := r.bytes(1)
+	if !ok {
+		return nil, false
+	}
+	return r.bytes(int(length[0]))
+}
+
+// vector16 读取2字节长度前缀的数据
+func (r *byteReader) vector16() (byteReader, bool) {
+	length, ok := r.uint16()
+	if !ok {
+		return nil, false
+	}
+	return r.bytes(int(length))
+}
+
+// uint16s 把剩余数据读取为2字节整数列表
+func (r byteReader) uint16s() []uint16 {
+	values := make([]uint16, 0, len(r)/2)
+	for len(r) >= 2 {
+		values = append(values, binary.BigEndian.Uint16(r))
+		r = r[2:]
+	}
+	return values
+}
+
+// 连接在请求上下文中的键
+type connContextKey struct{}
+
+// ConnContext 把HTTPS连接保存到请求上下文，用作http.Server.ConnContext
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if hc, ok := tlsConn.NetConn().(*helloConn); ok {
+			return context.WithValue(ctx, connContextKey{}, hc)
+		}
+	}
+	return ctx
+}
+
+// ClientFingerprint 获取请求所在连接的TLS指纹，非HTTPS请求或无法计算时返回nil
+func ClientFingerprint(ctx context.Context) *Fingerprint {
+	hc, ok := ctx.Value(connContextKey{}).(*helloConn)
+	if !ok {
+		return nil
+	}
+	return hc.Fingerprint()
+}
@@ -0,0 +1,17 @@
+//go:build go1.24
+
+package tlsserver
+
+import "crypto/tls"
+
+// postQuantumCurves 启用后量子密钥交换时优先使用的混合密钥交换
+var postQuantumCurves = []tls.CurveID{tls.X25519MLKEM768}
+
+// setECHKey 为TLS配置设置ECH密钥，客户端使用旧密钥失败时返回当前配置供其重试
+func setECHKey(tlsConfig *tls.Config, key *echKey) {
+	tlsConfig.EncryptedClientHelloKeys = []tls.EncryptedClientHelloKey{{
+		Config:      key.config,
+		PrivateKey:  key.privateKey,
+		SendAsRetry: true,
+	}}
+}
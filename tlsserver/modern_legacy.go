@@ -0,0 +1,11 @@
+//go:build !go1.24
+
+package tlsserver
+
+import "crypto/tls"
+
+// postQuantumCurves 当前Go版本不支持后量子密钥交换，配置验证会拒绝post_quantum
+var postQuantumCurves []tls.CurveID
+
+// setECHKey 当前Go版本不支持ECH，配置验证会拒绝ech
+func setECHKey(tlsConfig *tls.Config, key *echKey) {}
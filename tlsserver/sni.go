@@ -0,0 +1,84 @@
+package tlsserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/matcher"
+)
+
+// certSource 证书来源：证书文件或ACME
+type certSource interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// certSelector 按客户端的SNI选择端口上的证书
+// SNI匹配到配置了tls的域名规则时使用该规则的证书，否则使用默认证书；
+// 没有默认证书时使用端口上的第一个域名证书文件（如客户端直接通过IP访问）
+type certSelector struct {
+	hosts    *matcher.HostMatcher  // 域名模式 -> 证书键
+	certs    map[string]certSource // 证书键 -> 证书
+	acme     *acmeIssuer           // 端口上有通过ACME申请证书的域名时不为nil
+	fallback certSource
+}
+
+// newCertSelector 创建端口的证书选择器，reloaders按证书和私钥文件共享，同一证书只加载一次
+func newCertSelector(hostCerts []config.HostCertificate, fallback *certReloader, reloaders map[string]*certReloader, issuer *acmeIssuer, interval time.Duration) (*certSelector, error) {
+	s := &certSelector{
+		hosts: matcher.NewHostMatcher(),
+		certs: make(map[string]certSource),
+	}
+	if fallback != nil { // 避免把nil指针保存为非nil接口
+		s.fallback = fallback
+	}
+
+	for _, hostCert := range hostCerts {
+		if hostCert.ACME {
+			s.acme = issuer
+			s.certs["acme"] = issuer
+			s.hosts.AddRule(hostCert.Pattern, "acme")
+			continue
+		}
+
+		key := hostCert.CertFile + "|" + hostCert.KeyFile
+		reloader, exists := reloaders[key]
+		if !exists {
+			var err error
+			reloader, err = newCertReloader(hostCert.Pattern, hostCert.CertFile, hostCert.KeyFile, interval)
+			if err != nil {
+				return nil, fmt.Errorf("host rule '%s': %v", hostCert.Pattern, err)
+			}
+			reloaders[key] = reloader
+		}
+		s.certs[key] = reloader
+		s.hosts.AddRule(hostCert.Pattern, key)
+		if s.fallback == nil {
+			s.fallback = reloader
+		}
+	}
+
+	// 只有ACME域名时，不带SNI的客户端无法得到证书
+	if s.fallback == nil && s.acme != nil {
+		s.fallback = s.acme
+	}
+	if s.fallback == nil {
+		return nil, fmt.Errorf("no certificate configured")
+	}
+	return s, nil
+}
+
+// GetCertificate 返回与SNI匹配的证书，用作tls.Config.GetCertificate
+// TLS-ALPN-01验证握手交给ACME处理，即使域名由证书文件提供证书
+func (s *certSelector) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s.acme != nil && isACMEChallenge(hello) {
+		return s.acme.GetCertificate(hello)
+	}
+	if hello.ServerName != "" {
+		if key, ok := s.hosts.Match(hello.ServerName); ok {
+			return s.certs[key].GetCertificate(hello)
+		}
+	}
+	return s.fallback.GetCertificate(hello)
+}
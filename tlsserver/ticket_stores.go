@@ -0,0 +1,219 @@
+package tlsserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"toyou-proxy/config"
+)
+
+// DefaultTicketRedisKey 会话票据密钥在Redis中的默认列表键
+const DefaultTicketRedisKey = "toyou:tls:ticket_keys"
+
+// redisTimeout Redis操作超时
+const redisTimeout = 5 * time.Second
+
+// formatTicketKey 序列化密钥：创建时间（Unix秒）和base64编码的密钥，以空格分隔
+func formatTicketKey(key ticketKey) string {
+	return strconv.FormatInt(key.created.Unix(), 10) + " " + base64.StdEncoding.EncodeToString(key.key[:])
+}
+
+// parseTicketKey 解析序列化的密钥，只有密钥时创建时间视为0（立即轮换）
+func parseTicketKey(line string) (ticketKey, error) {
+	var key ticketKey
+	fields := strings.Fields(line)
+	encoded := fields[len(fields)-1]
+	if len(fields) == 2 {
+		seconds, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return key, fmt.Errorf("invalid key timestamp '%s'", fields[0])
+		}
+		key.created = time.Unix(seconds, 0)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) != len(key.key) {
+		return key, fmt.Errorf("ticket key must be 32 bytes encoded in base64")
+	}
+	copy(key.key[:], data)
+	return key, nil
+}
+
+// fileTicketStore 文件密钥存储，多个实例挂载同一个文件时共享密钥
+// 每行一个密钥，最新的在前；也可以由外部工具写入，只有密钥没有时间戳的行会在下次检查时被轮换
+type fileTicketStore struct {
+	path string
+}
+
+// newFileTicketStore 创建文件密钥存储
+func newFileTicketStore(path string) *fileTicketStore {
+	return &fileTicketStore{path: path}
+}
+
+// Name 存储类型
+func (s *fileTicketStore) Name() string {
+	return "file"
+}
+
+// Load 读取密钥文件，文件不存在时返回空
+func (s *fileTicketStore) Load() ([]ticketKey, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ticketKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := parseTicketKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", s.path, line, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+// Rotate 重新读取文件，最新密钥仍然过期时写入新密钥，先写临时文件再重命名保证读取方不会读到半个文件
+func (s *fileTicketStore) Rotate(key ticketKey, before time.Time, limit int) error {
+	keys, err := s.Load()
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 && keys[0].created.After(before) {
+		return nil
+	}
+
+	keys = append([]ticketKey{key}, keys...)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString(formatTicketKey(k))
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".ticket-keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// redisTicketStore Redis密钥存储，多个实例通过同一个列表键共享密钥，轮换时使用锁保证只有一个实例写入
+type redisTicketStore struct {
+	client  *redis.Client
+	key     string
+	lockTTL time.Duration
+}
+
+// newRedisTicketStore 创建Redis密钥存储
+func newRedisTicketStore(cfg config.TicketRedisConfig, interval time.Duration) *redisTicketStore {
+	key := cfg.Key
+	if key == "" {
+		key = DefaultTicketRedisKey
+	}
+	lockTTL := interval / 2
+	if lockTTL > time.Minute {
+		lockTTL = time.Minute
+	}
+
+	return &redisTicketStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		}),
+		key:     key,
+		lockTTL: lockTTL,
+	}
+}
+
+// Name 存储类型
+func (s *redisTicketStore) Name() string {
+	return "redis"
+}
+
+// Load 读取密钥列表
+func (s *redisTicketStore) Load() ([]ticketKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	values, err := s.client.LRange(ctx, s.key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]ticketKey, 0, len(values))
+	for _, value := range values {
+		key, err := parseTicketKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("redis key '%s': %v", s.key, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Rotate 获取锁后再次检查最新密钥，仍然过期时写入新密钥；其他实例持有锁时直接返回，稍后读取其结果
+func (s *redisTicketStore) Rotate(key ticketKey, before time.Time, limit int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	lockKey := s.key + ":lock"
+	acquired, err := s.client.SetNX(ctx, lockKey, "1", s.lockTTL).Result()
+	if err != nil || !acquired {
+		return err
+	}
+	defer s.client.Del(ctx, lockKey)
+
+	newest, err := s.client.LIndex(ctx, s.key, 0).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if newest != "" {
+		if current, err := parseTicketKey(newest); err == nil && current.created.After(before) {
+			return nil
+		}
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, s.key, formatTicketKey(key))
+		pipe.LTrim(ctx, s.key, 0, int64(limit-1))
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,207 @@
+package tlsserver
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"toyou-proxy/config"
+	"toyou-proxy/metrics"
+)
+
+// 会话票据密钥默认配置
+const (
+	defaultRotationInterval = time.Hour
+	defaultKeepKeys         = 2
+	maxRefreshInterval      = time.Minute
+)
+
+// ticketKeyRotations 会话票据密钥轮换次数
+var ticketKeyRotations = metrics.GetDefaultRegistry().NewCounterVec(
+	"toyou_proxy_tls_ticket_key_rotations_total",
+	"TLS session ticket key rotations performed by this instance.",
+	"store",
+)
+
+// ticketKey 会话票据密钥
+type ticketKey struct {
+	key     [32]byte
+	created time.Time
+}
+
+// ticketKeyStore 会话票据密钥存储，最新的密钥在前
+type ticketKeyStore interface {
+	// Name 存储类型，用于日志和指标
+	Name() string
+	// Load 读取当前的密钥
+	Load() ([]ticketKey, error)
+	// Rotate 在最新密钥早于before时加入新密钥，只保留最新的limit个
+	// 多个实例同时轮换时只有一个实例的新密钥生效
+	Rotate(key ticketKey, before time.Time, limit int) error
+}
+
+// TicketKeyManager 定期轮换会话票据密钥并应用到TLS配置
+// 最新的密钥加密新票据，保留的旧密钥继续解密已发出的票据
+type TicketKeyManager struct {
+	tlsConfigs []*tls.Config
+	store      ticketKeyStore
+	interval   time.Duration
+	keep       int
+	current    [32]byte
+	stop       chan struct{}
+	done       chan struct{}
+	once       sync.Once
+}
+
+// NewTicketKeyManager 创建会话票据密钥管理器并立即把一组密钥应用到所有TLS配置
+func NewTicketKeyManager(cfg config.SessionTicketConfig, tlsConfigs ...*tls.Config) (*TicketKeyManager, error) {
+	interval := defaultRotationInterval
+	if cfg.RotationInterval > 0 {
+		interval = time.Duration(cfg.RotationInterval) * time.Second
+	}
+	keep := defaultKeepKeys
+	if cfg.Keep > 0 {
+		keep = cfg.Keep
+	}
+
+	var store ticketKeyStore
+	switch {
+	case cfg.Redis.Addr != "":
+		store = newRedisTicketStore(cfg.Redis, interval)
+	case cfg.KeyFile != "":
+		store = newFileTicketStore(cfg.KeyFile)
+	default:
+		store = &memoryTicketStore{}
+	}
+
+	m := &TicketKeyManager{
+		tlsConfigs: tlsConfigs,
+		store:      store,
+		interval:   interval,
+		keep:       keep,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if err := m.refresh(); err != nil {
+		return nil, fmt.Errorf("session tickets: %v", err)
+	}
+	return m, nil
+}
+
+// Start 启动后台轮换
+func (m *TicketKeyManager) Start() {
+	go m.run()
+}
+
+// Stop 停止后台轮换
+func (m *TicketKeyManager) Stop() {
+	m.once.Do(func() {
+		close(m.stop)
+		<-m.done
+	})
+}
+
+// run 定期检查是否需要轮换，共享存储时同时获取其他实例轮换的密钥
+func (m *TicketKeyManager) run() {
+	defer close(m.done)
+
+	refreshInterval := m.interval / 4
+	if refreshInterval > maxRefreshInterval {
+		refreshInterval = maxRefreshInterval
+	}
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.refresh(); err != nil {
+				log.Printf("Failed to refresh TLS session ticket keys from %s store: %v", m.store.Name(), err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// refresh 最新密钥超过轮换间隔时轮换，然后把密钥应用到TLS配置
+func (m *TicketKeyManager) refresh() error {
+	keys, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if len(keys) == 0 || now.Sub(keys[0].created) >= m.interval {
+		key := ticketKey{created: now}
+		if _, err := rand.Read(key.key[:]); err != nil {
+			return fmt.Errorf("failed to generate ticket key: %v", err)
+		}
+		if err := m.store.Rotate(key, now.Add(-m.interval), m.keep+1); err != nil {
+			return fmt.Errorf("failed to rotate ticket key: %v", err)
+		}
+		if keys, err = m.store.Load(); err != nil {
+			return err
+		}
+		if len(keys) > 0 && keys[0].key == key.key {
+			ticketKeyRotations.Inc(m.store.Name())
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no ticket keys in %s store", m.store.Name())
+	}
+
+	if keys[0].key == m.current {
+		return nil
+	}
+	if len(keys) > m.keep+1 {
+		keys = keys[:m.keep+1]
+	}
+	sessionKeys := make([][32]byte, len(keys))
+	for i, key := range keys {
+		sessionKeys[i] = key.key
+	}
+	for _, tlsConfig := range m.tlsConfigs {
+		tlsConfig.SetSessionTicketKeys(sessionKeys)
+	}
+	m.current = keys[0].key
+	log.Printf("TLS session ticket keys updated from %s store (%d keys, newest created %s)",
+		m.store.Name(), len(keys), keys[0].created.Format(time.RFC3339))
+	return nil
+}
+
+// memoryTicketStore 进程内的密钥存储，只在单实例部署时使用
+type memoryTicketStore struct {
+	keys []ticketKey
+	mu   sync.Mutex
+}
+
+// Name 存储类型
+func (s *memoryTicketStore) Name() string {
+	return "memory"
+}
+
+// Load 读取当前的密钥
+func (s *memoryTicketStore) Load() ([]ticketKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ticketKey(nil), s.keys...), nil
+}
+
+// Rotate 加入新密钥
+func (s *memoryTicketStore) Rotate(key ticketKey, before time.Time, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.keys) > 0 && s.keys[0].created.After(before) {
+		return nil
+	}
+	s.keys = append([]ticketKey{key}, s.keys...)
+	if len(s.keys) > limit {
+		s.keys = s.keys[:limit]
+	}
+	return nil
+}